@@ -1,11 +1,18 @@
-package main
+// Package analyze extracts functions, calls and call graphs from Go source files.
+package analyze
 
 import (
+	"errors"
 	"fmt"
 	"go/ast"
 	"go/parser"
+	"go/scanner"
 	"go/token"
+	"io"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	"golang.org/x/tools/go/packages"
@@ -13,18 +20,22 @@ import (
 
 // ParameterInfo holds information about a function parameter
 type ParameterInfo struct {
-	Name string
-	Type string
+	Name string `json:"name"`
+	Type string `json:"type"`
 }
 
 // FunctionInfo holds information about a function or method
 type FunctionInfo struct {
 	Name       string
-	Receiver   string // Empty for functions, type name for methods
+	Receiver   string          // Empty for functions, type name for methods
+	TypeParams []ParameterInfo // Type parameters of generic functions, with their constraints
 	Parameters []ParameterInfo
 	Returns    []string // Return types
 	IsExported bool
 	FilePath   string // Path to the file containing this function
+	Line       int    // Line of the function name, 1-based (0 if unknown)
+	Column     int    // Byte column of the function name, 1-based (0 if unknown)
+	NoBody     bool   // Declared without a body: implemented in assembly or pulled in with go:linkname
 }
 
 // FunctionCall represents a function call
@@ -32,23 +43,110 @@ type FunctionCall struct {
 	CallerFile     string // File containing the caller
 	CallerFunction string // Function making the call
 	CalledFunction string // Function being called
-	Package        string // Package of the called function (if qualified)
+	Package        string // Package of the called function, if it is outside the analyzed files
 	Line           int    // Line number of the call
+	Possible       bool   // Heuristic edge through a stored function value rather than a direct call
+	Interface      bool   // Call of an interface method: linked to every method with its name, or with CHA and RTA one of its implementations
+}
+
+// Callee returns the name of the called function as shown in the call graph: the function
+// itself for calls within the analyzed files, qualified with its package otherwise. Methods
+// resolved with go/types already carry their qualified receiver, e.g. "(*bytes.Buffer) Write".
+func (c FunctionCall) Callee() string {
+	if c.Package == "" || strings.HasPrefix(c.CalledFunction, "(") {
+		return c.CalledFunction
+	}
+	return c.Package + "." + c.CalledFunction
+}
+
+// FunctionValueRef represents a function or method used as a value instead of being called,
+// e.g. stored in a variable, struct field or map, or passed as a callback
+type FunctionValueRef struct {
+	File     string // File containing the reference
+	Function string // Function containing the reference (empty at package level)
+	Target   string // Name of the referenced function or method
+	Slot     string // Variable, field, map or parameter the value is stored in (empty if unknown)
+	Line     int    // Line number of the reference
 }
 
 // CallGraph represents the complete call graph
 type CallGraph struct {
-	Functions map[string]*FunctionInfo // Map of function signatures to FunctionInfo
-	Calls     []FunctionCall           // List of function calls
+	Functions      map[string]*FunctionInfo // Map of function signatures to FunctionInfo
+	Calls          []FunctionCall           // List of function calls
+	FunctionValues []FunctionValueRef       // Functions and methods referenced as values
+	SyntaxErrors   []SyntaxError            // Syntax errors of files that were only partially analyzed
+}
+
+// warningOutput receives warnings about files that could not be analyzed
+var warningOutput io.Writer = os.Stdout
+
+// SetWarningOutput sets where warnings about files that could not be analyzed are written
+// (stdout by default)
+func SetWarningOutput(w io.Writer) {
+	warningOutput = w
+}
+
+// SyntaxError is a syntax error in an analyzed file. Files with syntax errors are analyzed
+// as far as the parser could recover.
+type SyntaxError struct {
+	File    string `json:"file"`
+	Line    int    `json:"line"`
+	Column  int    `json:"column"`
+	Message string `json:"message"`
+}
+
+// String formats the error as file:line:column: message
+func (e SyntaxError) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Message)
+}
+
+// parseGoFile parses a Go file, recovering from syntax errors. It returns the syntax tree of
+// everything that could be parsed along with the syntax errors; err is only set when the
+// file could not be parsed at all, e.g. because it can't be read.
+func parseGoFile(fset *token.FileSet, filePath string) (*ast.File, []SyntaxError, error) {
+	node, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments)
+	if err == nil {
+		return node, nil, nil
+	}
+
+	var list scanner.ErrorList
+	if node == nil || !errors.As(err, &list) {
+		return nil, nil, fmt.Errorf("failed to parse file %s: %w", filePath, err)
+	}
+	var syntaxErrors []SyntaxError
+	for _, e := range list {
+		syntaxErrors = append(syntaxErrors, SyntaxError{
+			File:    filePath,
+			Line:    e.Pos.Line,
+			Column:  e.Pos.Column,
+			Message: e.Msg,
+		})
+	}
+	return node, syntaxErrors, nil
 }
 
-// extractFunctionsFromGoFile uses AST parsing to extract function and method names from a Go file
-func extractFunctionsFromGoFile(filePath string) ([]FunctionInfo, error) {
+// ExtractFunctionsFromGoFile uses AST parsing to extract function and method names from a Go file.
+// Files with syntax errors are rejected; see ExtractFunctionsFromGoFileWithErrors.
+func ExtractFunctionsFromGoFile(filePath string) ([]FunctionInfo, error) {
+	functions, syntaxErrors, err := ExtractFunctionsFromGoFileWithErrors(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if len(syntaxErrors) > 0 {
+		return nil, fmt.Errorf("failed to parse file %s: %s", filePath, syntaxErrors[0])
+	}
+	return functions, nil
+}
+
+// ExtractFunctionsFromGoFileWithErrors extracts the functions and methods of a Go file that may
+// contain syntax errors. Declarations the parser could recover are returned along with the
+// syntax errors.
+func ExtractFunctionsFromGoFileWithErrors(filePath string) ([]FunctionInfo, []SyntaxError, error) {
 	// Parse the Go source file
 	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments)
+	node, syntaxErrors, err := parseGoFile(fset, filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse file %s: %w", filePath, err)
+		return nil, nil, err
 	}
 
 	var functions []FunctionInfo
@@ -57,18 +155,22 @@ func extractFunctionsFromGoFile(filePath string) ([]FunctionInfo, error) {
 	ast.Inspect(node, func(n ast.Node) bool {
 		switch x := n.(type) {
 		case *ast.FuncDecl:
+			position := fset.Position(x.Name.Pos())
 			info := FunctionInfo{
 				Name:       x.Name.Name,
 				IsExported: ast.IsExported(x.Name.Name),
 				FilePath:   filePath,
+				Line:       position.Line,
+				Column:     position.Column,
+				NoBody:     x.Body == nil,
 			}
 
-			// Check if it's a method (has receiver)
-			if x.Recv != nil && len(x.Recv.List) > 0 {
-				// Extract receiver type
-				if recvType := extractReceiverType(x.Recv.List[0].Type); recvType != "" {
-					info.Receiver = recvType
-				}
+			// Extract the receiver type if it's a method
+			info.Receiver = ReceiverType(x)
+
+			// Extract type parameters of generic functions
+			if x.Type.TypeParams != nil {
+				info.TypeParams = extractParameters(x.Type.TypeParams)
 			}
 
 			// Extract parameters
@@ -86,14 +188,33 @@ func extractFunctionsFromGoFile(filePath string) ([]FunctionInfo, error) {
 		return true
 	})
 
-	return functions, nil
+	return functions, syntaxErrors, nil
 }
 
-// extractReceiverType extracts the receiver type name from an AST expression
+// extractReceiverType extracts the receiver type from an AST expression, e.g. "*Server" or "List[T]"
 func extractReceiverType(expr ast.Expr) string {
 	return extractTypeString(expr)
 }
 
+// ReceiverType returns the receiver type of a method declaration, e.g. "*Server" or "List[T]",
+// or an empty string for plain functions
+func ReceiverType(funcDecl *ast.FuncDecl) string {
+	if funcDecl.Recv == nil || len(funcDecl.Recv.List) == 0 {
+		return ""
+	}
+	return extractReceiverType(funcDecl.Recv.List[0].Type)
+}
+
+// ReceiverTypeName returns the name of a receiver's base type without pointer and type
+// parameters, e.g. "Server" for "*Server" and "List" for "*List[T]"
+func ReceiverTypeName(receiver string) string {
+	name := strings.TrimPrefix(receiver, "*")
+	if i := strings.Index(name, "["); i >= 0 {
+		name = name[:i]
+	}
+	return name
+}
+
 // extractParameters extracts parameter information from a field list
 func extractParameters(params *ast.FieldList) []ParameterInfo {
 	var result []ParameterInfo
@@ -177,14 +298,43 @@ func extractTypeString(expr ast.Expr) string {
 		default:
 			return "chan " + extractTypeString(t.Value)
 		}
+	case *ast.StructType:
+		// Struct type
+		if t.Fields == nil || len(t.Fields.List) == 0 {
+			return "struct{}"
+		}
+		return "struct{...}"
 	case *ast.SelectorExpr:
 		// Qualified identifier (e.g., pkg.Type)
 		if x, ok := t.X.(*ast.Ident); ok {
 			return x.Name + "." + t.Sel.Name
 		}
+	case *ast.ParenExpr:
+		// Parenthesized type
+		return "(" + extractTypeString(t.X) + ")"
+	case *ast.UnaryExpr:
+		// Approximation element of a constraint (e.g., ~int)
+		if t.Op == token.TILDE {
+			return "~" + extractTypeString(t.X)
+		}
+	case *ast.BinaryExpr:
+		// Union of a constraint (e.g., ~int | ~float64)
+		if t.Op == token.OR {
+			return extractTypeString(t.X) + " | " + extractTypeString(t.Y)
+		}
 	case *ast.Ellipsis:
 		// Variadic parameter
 		return "..." + extractTypeString(t.Elt)
+	case *ast.IndexExpr:
+		// Generic type with one type argument (e.g., List[T])
+		return extractTypeString(t.X) + "[" + extractTypeString(t.Index) + "]"
+	case *ast.IndexListExpr:
+		// Generic type with several type arguments (e.g., Map[K, V])
+		var indices []string
+		for _, index := range t.Indices {
+			indices = append(indices, extractTypeString(index))
+		}
+		return extractTypeString(t.X) + "[" + strings.Join(indices, ", ") + "]"
 	}
 	return "<unknown>"
 }
@@ -197,6 +347,18 @@ func FormatFunctionSignature(fn FunctionInfo) string {
 		sig.WriteString(fmt.Sprintf("(%s) ", fn.Receiver))
 	}
 	sig.WriteString(fn.Name)
+
+	// Add type parameters
+	if len(fn.TypeParams) > 0 {
+		sig.WriteString("[")
+		for i, param := range fn.TypeParams {
+			if i > 0 {
+				sig.WriteString(", ")
+			}
+			sig.WriteString(param.Name + " " + param.Type)
+		}
+		sig.WriteString("]")
+	}
 	sig.WriteString("(")
 
 	// Add parameters
@@ -228,9 +390,9 @@ func FormatFunctionSignature(fn FunctionInfo) string {
 // extractFunctionCallsFromGoFile extracts function calls from a Go file
 func extractFunctionCallsFromGoFile(filePath string) ([]FunctionCall, error) {
 	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, filePath, nil, parser.ParseComments)
+	node, _, err := parseGoFile(fset, filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse file %s: %w", filePath, err)
+		return nil, err
 	}
 
 	var calls []FunctionCall
@@ -336,6 +498,278 @@ func isLikelyMethodCall(identifier string) bool {
 	return firstChar >= 'a' && firstChar <= 'z'
 }
 
+// indirectCall represents a call made through a variable, struct field or map element
+// holding a function value
+type indirectCall struct {
+	File     string
+	Function string
+	Slot     string
+	Line     int
+}
+
+// extractFunctionValuesFromGoFile finds functions and methods used as values and calls made
+// through stored function values. Matching is purely syntactic: a reference is recognized
+// by name against the known function and method names, and the slot is the name of the
+// variable, field, map or parameter receiving the value.
+func extractFunctionValuesFromGoFile(filePath string, functions map[string]*FunctionInfo) ([]FunctionValueRef, []indirectCall, error) {
+	fset := token.NewFileSet()
+	node, _, err := parseGoFile(fset, filePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	funcNames := make(map[string]bool)             // Plain functions, referenced by identifier
+	allNames := make(map[string]bool)              // Functions and methods, referenced by selector
+	paramNames := make(map[string][]ParameterInfo) // Function name -> parameters, for callbacks
+	for _, fn := range functions {
+		allNames[fn.Name] = true
+		if fn.Receiver == "" {
+			funcNames[fn.Name] = true
+		}
+		paramNames[fn.Name] = fn.Parameters
+	}
+
+	// valueTarget returns the function referenced by expr, if any
+	valueTarget := func(expr ast.Expr) string {
+		switch e := expr.(type) {
+		case *ast.Ident:
+			if funcNames[e.Name] {
+				return e.Name
+			}
+		case *ast.SelectorExpr:
+			// Method value (obj.Method) or qualified function (pkg.Func)
+			if allNames[e.Sel.Name] {
+				return e.Sel.Name
+			}
+		}
+		return ""
+	}
+
+	var refs []FunctionValueRef
+	var calls []indirectCall
+	var currentFunction string
+	var stack []ast.Node
+
+	addRef := func(expr ast.Expr, slot string) {
+		if target := valueTarget(expr); target != "" {
+			refs = append(refs, FunctionValueRef{
+				File:     filePath,
+				Function: currentFunction,
+				Target:   target,
+				Slot:     slot,
+				Line:     fset.Position(expr.Pos()).Line,
+			})
+		}
+	}
+
+	ast.Inspect(node, func(n ast.Node) bool {
+		if n == nil {
+			stack = stack[:len(stack)-1]
+			return true
+		}
+		var parent ast.Node
+		if len(stack) > 0 {
+			parent = stack[len(stack)-1]
+		}
+		stack = append(stack, n)
+
+		switch x := n.(type) {
+		case *ast.FuncDecl:
+			currentFunction = x.Name.Name
+			if x.Recv != nil && len(x.Recv.List) > 0 {
+				recvType := extractReceiverType(x.Recv.List[0].Type)
+				currentFunction = fmt.Sprintf("(%s) %s", recvType, x.Name.Name)
+			}
+		case *ast.GenDecl:
+			if _, ok := parent.(*ast.File); ok {
+				currentFunction = ""
+			}
+		case *ast.AssignStmt:
+			if len(x.Lhs) == len(x.Rhs) {
+				for i, rhs := range x.Rhs {
+					addRef(rhs, slotName(x.Lhs[i]))
+				}
+			}
+		case *ast.ValueSpec:
+			if len(x.Names) == len(x.Values) {
+				for i, value := range x.Values {
+					addRef(value, x.Names[i].Name)
+				}
+			}
+		case *ast.CompositeLit:
+			outerSlot := compositeLitSlot(x, parent)
+			for _, elt := range x.Elts {
+				value, slot := elt, outerSlot
+				if kv, ok := elt.(*ast.KeyValueExpr); ok {
+					value = kv.Value
+					// Keyed struct fields store the value in the field
+					if key, ok := kv.Key.(*ast.Ident); ok {
+						slot = key.Name
+					}
+				}
+				addRef(value, slot)
+			}
+		case *ast.CallExpr:
+			// Callbacks passed as arguments are stored in the callee's parameter
+			callee := ""
+			switch fun := x.Fun.(type) {
+			case *ast.Ident:
+				callee = fun.Name
+			case *ast.SelectorExpr:
+				callee = fun.Sel.Name
+			}
+			params := paramNames[callee]
+			for i, arg := range x.Args {
+				slot := ""
+				if i < len(params) {
+					slot = params[i].Name
+				}
+				addRef(arg, slot)
+			}
+
+			// Calls through a stored function value
+			if currentFunction == "" {
+				break
+			}
+			slot := ""
+			switch fun := x.Fun.(type) {
+			case *ast.Ident:
+				if !funcNames[fun.Name] {
+					slot = fun.Name
+				}
+			case *ast.SelectorExpr:
+				if !allNames[fun.Sel.Name] {
+					slot = fun.Sel.Name
+				}
+			case *ast.IndexExpr:
+				slot = slotName(fun.X)
+			}
+			if slot != "" {
+				calls = append(calls, indirectCall{
+					File:     filePath,
+					Function: currentFunction,
+					Slot:     slot,
+					Line:     fset.Position(x.Pos()).Line,
+				})
+			}
+		}
+		return true
+	})
+
+	return refs, calls, nil
+}
+
+// slotName returns the name of the variable, field or map an expression refers to
+func slotName(expr ast.Expr) string {
+	switch e := expr.(type) {
+	case *ast.Ident:
+		if e.Name != "_" {
+			return e.Name
+		}
+	case *ast.SelectorExpr:
+		return e.Sel.Name
+	case *ast.IndexExpr:
+		return slotName(e.X)
+	case *ast.StarExpr:
+		return slotName(e.X)
+	case *ast.ParenExpr:
+		return slotName(e.X)
+	}
+	return ""
+}
+
+// compositeLitSlot returns the slot that unkeyed elements of a composite literal are stored in,
+// i.e. the variable or field the literal itself is assigned to
+func compositeLitSlot(lit *ast.CompositeLit, parent ast.Node) string {
+	switch p := parent.(type) {
+	case *ast.AssignStmt:
+		for i, rhs := range p.Rhs {
+			if rhs == lit && i < len(p.Lhs) {
+				return slotName(p.Lhs[i])
+			}
+		}
+	case *ast.ValueSpec:
+		for i, value := range p.Values {
+			if value == lit && i < len(p.Names) {
+				return p.Names[i].Name
+			}
+		}
+	case *ast.KeyValueExpr:
+		if key, ok := p.Key.(*ast.Ident); ok && p.Value == lit {
+			return key.Name
+		}
+	}
+	return ""
+}
+
+// addPossibleCalls links calls made through stored function values to the functions stored
+// in a slot with the same name. Slots are matched by name only, so the edges are marked as possible.
+func addPossibleCalls(cg *CallGraph, calls []indirectCall) {
+	slotTargets := make(map[string][]string)
+	for _, ref := range cg.FunctionValues {
+		if ref.Slot == "" {
+			continue
+		}
+		slotTargets[ref.Slot] = appendUnique(slotTargets[ref.Slot], ref.Target)
+	}
+
+	seen := make(map[string]bool)
+	for _, call := range calls {
+		for _, target := range slotTargets[call.Slot] {
+			key := fmt.Sprintf("%s|%s|%d|%s", call.File, call.Function, call.Line, target)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			cg.Calls = append(cg.Calls, FunctionCall{
+				CallerFile:     call.File,
+				CallerFunction: call.Function,
+				CalledFunction: target,
+				Line:           call.Line,
+				Possible:       true,
+			})
+		}
+	}
+}
+
+// appendUnique appends value to list unless it is already present
+func appendUnique(list []string, value string) []string {
+	for _, existing := range list {
+		if existing == value {
+			return list
+		}
+	}
+	return append(list, value)
+}
+
+// IndirectOnlyReferences returns, for a function or method name that is never called
+// directly, the places where it is used as a function value. It returns nil if the
+// function is called directly or never referenced as a value.
+func (cg *CallGraph) IndirectOnlyReferences(name string) []FunctionValueRef {
+	for _, call := range cg.Calls {
+		if baseName(call.CalledFunction) == name && !call.Possible {
+			return nil
+		}
+	}
+
+	var refs []FunctionValueRef
+	for _, ref := range cg.FunctionValues {
+		if ref.Target == name {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// baseName returns the name of a function or method without its receiver and package, e.g.
+// "Run" for "(*Server) Run" and "Open" for "example.com/app/store.Open"
+func baseName(name string) string {
+	if i := strings.LastIndex(name, ") "); i >= 0 {
+		return name[i+2:]
+	}
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
 // BuildCallGraph builds a complete call graph from Go files
 func BuildCallGraph(files []string) (*CallGraph, error) {
 	return BuildCallGraphWithPackageFilter(files, nil)
@@ -343,6 +777,16 @@ func BuildCallGraph(files []string) (*CallGraph, error) {
 
 // BuildCallGraphWithPackageFilter builds a call graph from Go files with package filtering
 func BuildCallGraphWithPackageFilter(files []string, packageInfo *PackageInfo) (*CallGraph, error) {
+	return BuildCallGraphWithAlgorithm(files, packageInfo, CallGraphAlgorithmStatic)
+}
+
+// BuildCallGraphWithAlgorithm builds a call graph from Go files with package filtering, linking
+// calls of interface methods to their implementations with the given algorithm
+func BuildCallGraphWithAlgorithm(files []string, packageInfo *PackageInfo, algorithm string) (*CallGraph, error) {
+	if algorithm != CallGraphAlgorithmStatic && algorithm != CallGraphAlgorithmCHA && algorithm != CallGraphAlgorithmRTA {
+		return nil, fmt.Errorf("unknown call graph algorithm %q (expected %q, %q or %q)",
+			algorithm, CallGraphAlgorithmStatic, CallGraphAlgorithmCHA, CallGraphAlgorithmRTA)
+	}
 	cg := &CallGraph{
 		Functions: make(map[string]*FunctionInfo),
 		Calls:     []FunctionCall{},
@@ -359,11 +803,12 @@ func BuildCallGraphWithPackageFilter(files []string, packageInfo *PackageInfo) (
 			continue
 		}
 
-		functions, err := extractFunctionsFromGoFile(file)
+		functions, syntaxErrors, err := ExtractFunctionsFromGoFileWithErrors(file)
 		if err != nil {
-			fmt.Printf("Warning: Error parsing functions in %s: %v\n", file, err)
+			fmt.Fprintf(warningOutput, "Warning: Error parsing functions in %s: %v\n", file, err)
 			continue
 		}
+		cg.SyntaxErrors = append(cg.SyntaxErrors, syntaxErrors...)
 
 		for i := range functions {
 			fn := &functions[i]
@@ -372,7 +817,9 @@ func BuildCallGraphWithPackageFilter(files []string, packageInfo *PackageInfo) (
 		}
 	}
 
-	// Second pass: extract all function calls (only from current module files)
+	// Second pass: extract all function calls (only from current module files), resolved with
+	// go/types where the packages type-check
+	var callFiles []string
 	for _, file := range files {
 		if !strings.HasSuffix(file, ".go") {
 			continue
@@ -382,27 +829,91 @@ func BuildCallGraphWithPackageFilter(files []string, packageInfo *PackageInfo) (
 		if packageInfo != nil && !isCurrentModuleFile(file, packageInfo) {
 			continue
 		}
+		callFiles = append(callFiles, file)
+	}
+	typed := loadTypedPackages(callFiles)
+	var resolved map[string][]FunctionCall
+	if typed != nil {
+		resolved = typed.resolveCalls()
+	}
+	for _, file := range callFiles {
+		if absPath, err := filepath.Abs(file); err == nil {
+			if calls, ok := resolved[absPath]; ok {
+				cg.Calls = append(cg.Calls, calls...)
+				continue
+			}
+		}
 
 		calls, err := extractFunctionCallsFromGoFile(file)
 		if err != nil {
-			fmt.Printf("Warning: Error parsing calls in %s: %v\n", file, err)
+			fmt.Fprintf(warningOutput, "Warning: Error parsing calls in %s: %v\n", file, err)
 			continue
 		}
 
 		cg.Calls = append(cg.Calls, calls...)
 	}
 
+	// Link calls of interface methods to the implementations found by CHA or RTA instead of
+	// every method with the same name
+	if algorithm != CallGraphAlgorithmStatic {
+		if err := addDispatchCalls(cg, typed, resolved, algorithm); err != nil {
+			fmt.Fprintf(warningOutput, "Warning: %v, linking interface calls by method name\n", err)
+		}
+	}
+
+	// Third pass: track function values to add possible edges for indirect calls
+	var indirectCalls []indirectCall
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".go") {
+			continue
+		}
+
+		if packageInfo != nil && !isCurrentModuleFile(file, packageInfo) {
+			continue
+		}
+
+		refs, calls, err := extractFunctionValuesFromGoFile(file, cg.Functions)
+		if err != nil {
+			fmt.Fprintf(warningOutput, "Warning: Error parsing function values in %s: %v\n", file, err)
+			continue
+		}
+
+		cg.FunctionValues = append(cg.FunctionValues, refs...)
+		indirectCalls = append(indirectCalls, calls...)
+	}
+	addPossibleCalls(cg, indirectCalls)
+
 	return cg, nil
 }
 
+// FormatSyntaxErrors formats a report of the files that were only partially analyzed because
+// of syntax errors, or returns an empty string if there are none
+func FormatSyntaxErrors(syntaxErrors []SyntaxError) string {
+	if len(syntaxErrors) == 0 {
+		return ""
+	}
+
+	files := make(map[string]bool)
+	for _, e := range syntaxErrors {
+		files[e.File] = true
+	}
+
+	var output strings.Builder
+	output.WriteString(fmt.Sprintf("\n⚠️  %d file(s) with syntax errors were analyzed partially:\n", len(files)))
+	for _, e := range syntaxErrors {
+		output.WriteString(fmt.Sprintf("  %s\n", e))
+	}
+	return output.String()
+}
+
 // PackageInfo holds information about packages and their module affiliations
 type PackageInfo struct {
 	CurrentModulePackages map[string]bool // Packages that belong to the current module
 	ModulePath            string          // The module path (e.g., "go-build-interceptor")
 }
 
-// getPackageInfo uses packages.Load to determine which packages belong to the current module
-func getPackageInfo(workingDir string) (*PackageInfo, error) {
+// GetPackageInfo uses packages.Load to determine which packages belong to the current module
+func GetPackageInfo(workingDir string) (*PackageInfo, error) {
 	// Load packages using packages.Load
 	cfg := &packages.Config{
 		Mode: packages.NeedName | packages.NeedFiles | packages.NeedModule,
@@ -517,10 +1028,7 @@ func buildCallChainFromMain(cg *CallGraph, callGraph map[string][]FunctionCall)
 		calls := callGraph[funcName]
 		for _, call := range calls {
 			// For external calls, mark the call as reachable but don't traverse further
-			callee := call.CalledFunction
-			if call.Package != "" {
-				callee = call.Package + "." + callee
-			}
+			callee := call.Callee()
 			reachableFromMain[callee] = true
 
 			// For local calls, continue DFS
@@ -563,10 +1071,7 @@ func FormatCallGraph(cg *CallGraph) string {
 		if _, exists := callLineMap[caller]; !exists {
 			callLineMap[caller] = make(map[string]int)
 		}
-		callee := call.CalledFunction
-		if call.Package != "" {
-			callee = call.Package + "." + callee
-		}
+		callee := call.Callee()
 		if _, exists := callLineMap[caller][callee]; !exists {
 			callLineMap[caller][callee] = call.Line
 		}
@@ -624,6 +1129,7 @@ func FormatCallGraph(cg *CallGraph) string {
 	}
 
 	output.WriteString(fmt.Sprintf("Summary: %d functions reachable from main, %d calls\n", reachableFunctions, reachableCalls))
+	writePossibleCallsSummary(cg, &output)
 
 	return output.String()
 }
@@ -650,10 +1156,7 @@ func FormatCallGraphWithFilter(cg *CallGraph, packageInfo *PackageInfo) string {
 		if _, exists := callLineMap[caller]; !exists {
 			callLineMap[caller] = make(map[string]int)
 		}
-		callee := call.CalledFunction
-		if call.Package != "" {
-			callee = call.Package + "." + callee
-		}
+		callee := call.Callee()
 		if _, exists := callLineMap[caller][callee]; !exists {
 			callLineMap[caller][callee] = call.Line
 		}
@@ -717,10 +1220,200 @@ func FormatCallGraphWithFilter(cg *CallGraph, packageInfo *PackageInfo) string {
 		output.WriteString(fmt.Sprintf("Summary: %d functions reachable from main, %d calls\n",
 			reachableFunctions, reachableCalls))
 	}
+	writePossibleCallsSummary(cg, &output)
+
+	return output.String()
+}
+
+// Call graph output formats (--format)
+const (
+	CallGraphFormatText = "text"
+	CallGraphFormatDOT  = "dot"
+)
+
+// CallGraphEdge is a caller/callee pair, with the lines of all its calls
+type CallGraphEdge struct {
+	Caller   string `json:"caller"`
+	Callee   string `json:"callee"`
+	Lines    []int  `json:"lines"`
+	External bool   `json:"external"` // Callee is outside the analyzed files
+	Possible bool   `json:"possible"` // Every call is through a function value
+}
+
+// ReachableCallEdges returns the edges of the functions reachable from main, sorted by
+// caller and callee. Method calls resolve to every method with that name.
+func ReachableCallEdges(cg *CallGraph) []CallGraphEdge {
+	callGraph := make(map[string][]FunctionCall)
+	for _, call := range cg.Calls {
+		callGraph[call.CallerFunction] = append(callGraph[call.CallerFunction], call)
+	}
+	reachableFromMain := buildCallChainFromMain(cg, callGraph)
+	return callEdges(cg, func(caller string) bool { return reachableFromMain[caller] })
+}
+
+// CallEdges returns the edges of every function, sorted by caller and callee. Method calls
+// resolve to every method with that name.
+func CallEdges(cg *CallGraph) []CallGraphEdge {
+	return callEdges(cg, func(string) bool { return true })
+}
+
+// callEdges returns the edges of the callers accepted by include
+func callEdges(cg *CallGraph, include func(caller string) bool) []CallGraphEdge {
+	// Build adjacency list for call relationships
+	callGraph := make(map[string][]FunctionCall)
+	for _, call := range cg.Calls {
+		callGraph[call.CallerFunction] = append(callGraph[call.CallerFunction], call)
+	}
+
+	// Build method resolution mapping
+	methodSignatures := make(map[string][]string)
+	for funcSig := range callGraph {
+		if strings.Contains(funcSig, ") ") {
+			parts := strings.Split(funcSig, ") ")
+			if len(parts) >= 2 {
+				methodName := strings.Split(parts[1], "(")[0]
+				methodSignatures[methodName] = append(methodSignatures[methodName], funcSig)
+			}
+		}
+	}
+
+	edges := make(map[string]map[string]*CallGraphEdge) // caller -> callee -> edge
+	addEdge := func(caller, callee string, call FunctionCall, external bool) {
+		if _, exists := edges[caller]; !exists {
+			edges[caller] = make(map[string]*CallGraphEdge)
+		}
+		e, exists := edges[caller][callee]
+		if !exists {
+			e = &CallGraphEdge{Caller: caller, Callee: callee, External: external, Possible: true}
+			edges[caller][callee] = e
+		}
+		e.Lines = append(e.Lines, call.Line)
+		e.Possible = e.Possible && call.Possible
+	}
+
+	for caller, calls := range callGraph {
+		if !include(caller) {
+			continue
+		}
+		for _, call := range calls {
+			if call.Package != "" {
+				addEdge(caller, call.Callee(), call, true)
+				continue
+			}
+			if _, exists := callGraph[call.CalledFunction]; exists || len(methodSignatures[call.CalledFunction]) == 0 {
+				addEdge(caller, call.CalledFunction, call, false)
+				continue
+			}
+			for _, methodSig := range methodSignatures[call.CalledFunction] {
+				addEdge(caller, methodSig, call, false)
+			}
+		}
+	}
 
+	var result []CallGraphEdge
+	for _, callees := range edges {
+		for _, e := range callees {
+			result = append(result, *e)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Caller != result[j].Caller {
+			return result[i].Caller < result[j].Caller
+		}
+		return result[i].Callee < result[j].Callee
+	})
+	return result
+}
+
+// FormatCallGraphDOT formats the functions reachable from main as a Graphviz digraph, with
+// one node per function and one edge per caller/callee pair labelled with the call lines.
+// External functions are drawn as boxes and possible calls through function values as dashed edges.
+func FormatCallGraphDOT(cg *CallGraph, packageInfo *PackageInfo) string {
+	var output strings.Builder
+
+	edges := ReachableCallEdges(cg)
+	nodes := make(map[string]bool) // node -> external
+	for _, e := range edges {
+		if _, exists := nodes[e.Caller]; !exists {
+			nodes[e.Caller] = false
+		}
+		nodes[e.Callee] = e.External
+	}
+
+	title := "call graph (from main)"
+	if packageInfo != nil {
+		title = fmt.Sprintf("call graph (from main - %s module only)", packageInfo.ModulePath)
+	}
+
+	output.WriteString("digraph callgraph {\n")
+	output.WriteString(fmt.Sprintf("  label=%s;\n", strconv.Quote(title)))
+	output.WriteString("  labelloc=t;\n")
+	output.WriteString("  rankdir=LR;\n")
+	output.WriteString("  node [shape=ellipse, fontname=\"Helvetica\"];\n")
+	output.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+
+	var nodeNames []string
+	for node := range nodes {
+		nodeNames = append(nodeNames, node)
+	}
+	sort.Strings(nodeNames)
+	if len(nodeNames) > 0 {
+		output.WriteString("\n")
+	}
+	for _, node := range nodeNames {
+		if nodes[node] {
+			output.WriteString(fmt.Sprintf("  %s [shape=box, color=gray50, fontcolor=gray30];\n", strconv.Quote(node)))
+		} else {
+			output.WriteString(fmt.Sprintf("  %s;\n", strconv.Quote(node)))
+		}
+	}
+
+	if len(edges) > 0 {
+		output.WriteString("\n")
+	}
+	for _, e := range edges {
+		lines := make([]string, len(e.Lines))
+		for i, line := range e.Lines {
+			lines[i] = strconv.Itoa(line)
+		}
+		label := "line " + lines[0]
+		if len(lines) > 1 {
+			label = "lines " + strings.Join(lines, ", ")
+		}
+		attrs := "label=" + strconv.Quote(label)
+		if e.Possible {
+			attrs += ", style=dashed"
+		}
+		output.WriteString(fmt.Sprintf("  %s -> %s [%s];\n", strconv.Quote(e.Caller), strconv.Quote(e.Callee), attrs))
+	}
+
+	output.WriteString("}\n")
 	return output.String()
 }
 
+// allPossible reports whether every call in the list is a possible (indirect) edge
+func allPossible(calls []FunctionCall) bool {
+	for _, call := range calls {
+		if !call.Possible {
+			return false
+		}
+	}
+	return len(calls) > 0
+}
+
+// writePossibleCallsSummary notes how many edges were inferred from function values
+func writePossibleCallsSummary(cg *CallGraph, output *strings.Builder) {
+	possible := 0
+	for _, call := range cg.Calls {
+		if call.Possible {
+			possible++
+		}
+	}
+	if possible > 0 {
+		output.WriteString(fmt.Sprintf("Possible calls through function values: %d (marked [possible])\n", possible))
+	}
+}
+
 // generateCallChains recursively generates call chains with proper indentation
 func generateCallChains(currentFunc string, callGraph map[string][]FunctionCall,
 	callLineMap map[string]map[string]int, indent string, visited map[string]bool,
@@ -741,10 +1434,7 @@ func generateCallChains(currentFunc string, callGraph map[string][]FunctionCall,
 	// Group calls by function name to handle multiple calls to same function
 	callGroups := make(map[string][]FunctionCall)
 	for _, call := range calls {
-		callee := call.CalledFunction
-		if call.Package != "" {
-			callee = call.Package + "." + callee
-		}
+		callee := call.Callee()
 		callGroups[callee] = append(callGroups[callee], call)
 	}
 
@@ -761,6 +1451,9 @@ func generateCallChains(currentFunc string, callGraph map[string][]FunctionCall,
 		} else {
 			output.WriteString(fmt.Sprintf("%s  -> %s (line %d)", indent, callee, line))
 		}
+		if allPossible(callList) {
+			output.WriteString(" [possible]")
+		}
 
 		// Check if this callee has further calls (only for local functions)
 		if callList[0].Package == "" && callGraph[callList[0].CalledFunction] != nil {
@@ -775,10 +1468,7 @@ func generateCallChains(currentFunc string, callGraph map[string][]FunctionCall,
 				// Check if it's a simple single call that we can chain inline
 				if len(subCalls) == 1 && subCalls[0].Package == "" && !subVisited[subCalls[0].CalledFunction] {
 					// Chain inline
-					nextCallee := subCalls[0].CalledFunction
-					if subCalls[0].Package != "" {
-						nextCallee = subCalls[0].Package + "." + nextCallee
-					}
+					nextCallee := subCalls[0].Callee()
 					nextLine := callLineMap[callList[0].CalledFunction][nextCallee]
 					output.WriteString(fmt.Sprintf(" -> %s (line %d)", nextCallee, nextLine))
 
@@ -834,10 +1524,7 @@ func generateCallChainsFromMain(currentFunc string, callGraph map[string][]Funct
 	// Group calls by function name to handle multiple calls to same function
 	callGroups := make(map[string][]FunctionCall)
 	for _, call := range calls {
-		callee := call.CalledFunction
-		if call.Package != "" {
-			callee = call.Package + "." + callee
-		}
+		callee := call.Callee()
 
 		// Only include if reachable from main
 		if reachableFromMain[callee] || reachableFromMain[call.CalledFunction] {
@@ -875,6 +1562,9 @@ func generateCallChainsFromMain(currentFunc string, callGraph map[string][]Funct
 		} else {
 			output.WriteString(fmt.Sprintf("%s  -> %s (line %d)", indent, callee, line))
 		}
+		if allPossible(callList) {
+			output.WriteString(" [possible]")
+		}
 
 		output.WriteString("\n")
 
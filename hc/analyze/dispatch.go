@@ -0,0 +1,180 @@
+package analyze
+
+import (
+	"fmt"
+	"go/types"
+	"path/filepath"
+	"sort"
+
+	"golang.org/x/tools/go/callgraph"
+	"golang.org/x/tools/go/callgraph/cha"
+	"golang.org/x/tools/go/callgraph/rta"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/ssa"
+)
+
+// Call graph algorithms (--algo)
+const (
+	// CallGraphAlgorithmStatic links calls of interface methods to every method with that name
+	CallGraphAlgorithmStatic = "static"
+	// CallGraphAlgorithmCHA links them to the methods of every type implementing the interface
+	// (class hierarchy analysis)
+	CallGraphAlgorithmCHA = "cha"
+	// CallGraphAlgorithmRTA links them to the methods of the implementing types the program
+	// converts to an interface in code reachable from main (rapid type analysis)
+	CallGraphAlgorithmRTA = "rta"
+)
+
+// addDispatchCalls replaces the calls of interface methods made in the type-checked files by
+// one call per implementation found with CHA or RTA. Only the edges of interface calls are
+// taken from the algorithm; static calls and calls through function values are kept as they
+// are. Implementations outside the analyzed files are left out unless a call has no other,
+// in which case it links to the interface method itself.
+func addDispatchCalls(cg *CallGraph, typed *typedPackages, resolved map[string][]FunctionCall, algorithm string) error {
+	if typed == nil || len(typed.packages) == 0 {
+		return fmt.Errorf("%s needs packages that type-check", algorithm)
+	}
+
+	prog := typed.ssaProgram()
+
+	var graph *callgraph.Graph
+	switch algorithm {
+	case CallGraphAlgorithmCHA:
+		graph = cha.CallGraph(prog)
+	case CallGraphAlgorithmRTA:
+		var roots []*ssa.Function
+		for _, pkg := range prog.AllPackages() {
+			if pkg.Pkg.Name() != "main" || !typed.analyzed(pkg.Pkg) {
+				continue
+			}
+			for _, name := range []string{"init", "main"} {
+				if fn := pkg.Func(name); fn != nil {
+					roots = append(roots, fn)
+				}
+			}
+		}
+		if len(roots) == 0 {
+			return fmt.Errorf("rta needs a main package")
+		}
+		graph = rta.Analyze(roots, true).CallGraph
+	}
+
+	// Callees of every interface call site in the analyzed files
+	type site struct {
+		file   string
+		line   int
+		caller string
+		method *types.Func
+	}
+	callees := make(map[site]map[string]bool)
+	for fn, node := range graph.Nodes {
+		if fn == nil || fn.Pkg == nil || !typed.analyzed(fn.Pkg.Pkg) {
+			continue
+		}
+		caller := typed.enclosingName(fn)
+		if caller == "" {
+			continue
+		}
+		for _, edge := range node.Out {
+			common := edge.Site.Common()
+			if !common.IsInvoke() || edge.Callee.Func == nil {
+				continue
+			}
+			pos := prog.Fset.Position(edge.Site.Pos())
+			if !typed.wanted[pos.Filename] {
+				continue
+			}
+			key := site{file: pos.Filename, line: pos.Line, caller: caller, method: common.Method}
+			if callees[key] == nil {
+				callees[key] = make(map[string]bool)
+			}
+			if obj, ok := edge.Callee.Func.Object().(*types.Func); ok {
+				if name, ok := typed.names[obj.Origin()]; ok {
+					callees[key][name] = true
+				}
+			}
+		}
+	}
+
+	// Replace the interface calls of the type-checked files
+	var calls []FunctionCall
+	for _, call := range cg.Calls {
+		if absPath, err := filepath.Abs(call.CallerFile); err == nil && call.Interface {
+			if _, ok := resolved[absPath]; ok {
+				continue
+			}
+		}
+		calls = append(calls, call)
+	}
+	var sites []site
+	for key := range callees {
+		sites = append(sites, key)
+	}
+	sort.Slice(sites, func(i, j int) bool {
+		if sites[i].file != sites[j].file {
+			return sites[i].file < sites[j].file
+		}
+		return sites[i].line < sites[j].line
+	})
+	for _, key := range sites {
+		call := FunctionCall{CallerFile: key.file, CallerFunction: key.caller, Line: key.line, Interface: true}
+		if len(callees[key]) == 0 {
+			call.Package = key.method.Pkg().Path()
+			call.CalledFunction = fmt.Sprintf("(%s) %s", receiverString(key.method.Signature().Recv().Type()), key.method.Name())
+			calls = append(calls, call)
+			continue
+		}
+		var names []string
+		for name := range callees[key] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			call.CalledFunction = name
+			calls = append(calls, call)
+		}
+	}
+	cg.Calls = calls
+	return nil
+}
+
+// ssaProgram builds the SSA form of the typed packages. Dependencies are added from their
+// type information only: their bodies were dropped when parsing, which also leaves them
+// marked as ill-typed, so they can't go through ssautil.
+func (t *typedPackages) ssaProgram() *ssa.Program {
+	prog := ssa.NewProgram(t.packages[0].Fset, ssa.InstantiateGenerics)
+	packages.Visit(t.packages, nil, func(pkg *packages.Package) {
+		if pkg.Types == nil {
+			return
+		}
+		if t.analyzed(pkg.Types) {
+			prog.CreatePackage(pkg.Types, pkg.Syntax, pkg.TypesInfo, true)
+		} else {
+			prog.CreatePackage(pkg.Types, nil, nil, true)
+		}
+	})
+	prog.Build()
+	return prog
+}
+
+// analyzed reports whether pkg is one of the type-checked packages of the analyzed files
+func (t *typedPackages) analyzed(pkg *types.Package) bool {
+	for _, p := range t.packages {
+		if p.Types == pkg {
+			return true
+		}
+	}
+	return false
+}
+
+// enclosingName returns the name of the declared function an SSA function belongs to:
+// itself, or for closures the function declaring them
+func (t *typedPackages) enclosingName(fn *ssa.Function) string {
+	for fn.Parent() != nil {
+		fn = fn.Parent()
+	}
+	if obj, ok := fn.Object().(*types.Func); ok && fn.Synthetic == "" {
+		return t.names[obj.Origin()]
+	}
+	return ""
+}
@@ -0,0 +1,246 @@
+package analyze
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CallGraphQuery is the result of a callers/callees query: the edges leading to a function and
+// the edges leading from it, each up to Depth calls away (0: all)
+type CallGraphQuery struct {
+	Query    string               `json:"query"`
+	Function string               `json:"function"` // Call graph name the query resolved to
+	Depth    int                  `json:"depth"`
+	Callers  []CallGraphQueryEdge `json:"callers"`
+	Callees  []CallGraphQueryEdge `json:"callees"`
+}
+
+// CallGraphQueryEdge is an edge found by a query, with how many calls away from the queried
+// function it is (1 for direct callers and callees)
+type CallGraphQueryEdge struct {
+	CallGraphEdge
+	Distance int `json:"distance"`
+}
+
+// QueryCallGraph returns the transitive callers and callees of a function, up to depth calls
+// away (0 for no limit). The function is named as in the call graph ("(*Server) Run",
+// "example.com/app/store.Open"), as pkg.Func, pkg.Type.Method or pkg.(*Type).Method, where
+// pkg is main, an import path or its last elements ("store.Open"), or by its bare name if
+// that is unique.
+func QueryCallGraph(cg *CallGraph, query string, depth int) (*CallGraphQuery, error) {
+	edges := CallEdges(cg)
+	function, err := resolveQuery(edges, query)
+	if err != nil {
+		return nil, err
+	}
+
+	callers := make(map[string][]CallGraphEdge)
+	callees := make(map[string][]CallGraphEdge)
+	for _, e := range edges {
+		callers[e.Callee] = append(callers[e.Callee], e)
+		callees[e.Caller] = append(callees[e.Caller], e)
+	}
+
+	return &CallGraphQuery{
+		Query:    query,
+		Function: function,
+		Depth:    depth,
+		Callers:  walkEdges(function, callers, depth, func(e CallGraphEdge) string { return e.Caller }),
+		Callees:  walkEdges(function, callees, depth, func(e CallGraphEdge) string { return e.Callee }),
+	}, nil
+}
+
+// walkEdges returns the edges reached from function breadth first, following next from each
+// function to the one returned by other, up to depth steps away
+func walkEdges(function string, next map[string][]CallGraphEdge, depth int, other func(CallGraphEdge) string) []CallGraphQueryEdge {
+	result := []CallGraphQueryEdge{}
+	visited := map[string]bool{function: true}
+	current := []string{function}
+	for distance := 1; len(current) > 0 && (depth <= 0 || distance <= depth); distance++ {
+		var following []string
+		for _, name := range current {
+			for _, e := range next[name] {
+				result = append(result, CallGraphQueryEdge{CallGraphEdge: e, Distance: distance})
+				if node := other(e); !visited[node] {
+					visited[node] = true
+					following = append(following, node)
+				}
+			}
+		}
+		current = following
+	}
+	return result
+}
+
+// resolveQuery returns the call graph node a query names. Exact names win over names matched
+// by the end of their import path, which win over bare function and method names; a query
+// matching several nodes is an error.
+func resolveQuery(edges []CallGraphEdge, query string) (string, error) {
+	nodes := make(map[string]bool)
+	for _, e := range edges {
+		nodes[e.Caller] = true
+		nodes[e.Callee] = true
+	}
+	if nodes[query] {
+		return query, nil
+	}
+
+	var exact, suffix, bare []string
+	for node := range nodes {
+		if baseName(node) == query {
+			bare = append(bare, node)
+		}
+		for _, form := range queryForms(node) {
+			if form == query {
+				exact = append(exact, node)
+				break
+			}
+			if strings.HasSuffix(form, "/"+query) {
+				suffix = append(suffix, node)
+				break
+			}
+		}
+	}
+	matches := exact
+	if len(matches) == 0 {
+		matches = suffix
+	}
+	if len(matches) == 0 {
+		matches = bare
+	}
+	sort.Strings(matches)
+	switch len(matches) {
+	case 1:
+		return matches[0], nil
+	case 0:
+		return "", fmt.Errorf("function %q not found in the call graph", query)
+	default:
+		return "", fmt.Errorf("function %q is ambiguous, it matches %s", query, strings.Join(matches, ", "))
+	}
+}
+
+// queryForms returns the pkg.Func spellings of a call graph node: "main.foo" for "foo",
+// "example.com/app/store.(*DB).Query" and "example.com/app/store.DB.Query" for
+// "(*example.com/app/store.DB) Query"
+func queryForms(node string) []string {
+	receiver, method, isMethod := strings.Cut(strings.TrimPrefix(node, "("), ") ")
+	if !strings.HasPrefix(node, "(") || !isMethod {
+		if qualifiedName(node) {
+			return []string{node}
+		}
+		return []string{"main." + node}
+	}
+
+	pointer := strings.HasPrefix(receiver, "*")
+	receiver = strings.TrimPrefix(receiver, "*")
+	pkg, typeName := "main", receiver
+	if qualifiedName(receiver) {
+		// The type name follows the last dot of the import path, before any type arguments
+		base, _, _ := strings.Cut(receiver, "[")
+		dot := strings.LastIndex(base, ".")
+		pkg, typeName = receiver[:dot], receiver[dot+1:]
+	}
+	forms := []string{pkg + "." + typeName + "." + method}
+	if pointer {
+		forms = append(forms, pkg+".(*"+typeName+")."+method)
+	}
+	return forms
+}
+
+// SplitNodeName returns the package, receiver and function of a call graph node: "main", ""
+// and "foo" for "foo", "example.com/app/store", "*DB" and "Query" for
+// "(*example.com/app/store.DB) Query"
+func SplitNodeName(node string) (pkg, receiver, function string) {
+	if recv, method, isMethod := strings.Cut(strings.TrimPrefix(node, "("), ") "); strings.HasPrefix(node, "(") && isMethod {
+		pointer := strings.HasPrefix(recv, "*")
+		recv = strings.TrimPrefix(recv, "*")
+		pkg, receiver = "main", recv
+		if qualifiedName(recv) {
+			base, _, _ := strings.Cut(recv, "[")
+			dot := strings.LastIndex(base, ".")
+			pkg, receiver = recv[:dot], recv[dot+1:]
+		}
+		if pointer {
+			receiver = "*" + receiver
+		}
+		return pkg, receiver, method
+	}
+	if !qualifiedName(node) {
+		return "main", "", node
+	}
+	base, _, _ := strings.Cut(node, "[")
+	dot := strings.LastIndex(base, ".")
+	return node[:dot], "", node[dot+1:]
+}
+
+// qualifiedName reports whether a function or type name is qualified with its package, i.e.
+// has a dot after the last slash outside type arguments
+func qualifiedName(name string) bool {
+	base, _, _ := strings.Cut(name, "[")
+	return strings.Contains(base[strings.LastIndex(base, "/")+1:], ".")
+}
+
+// FormatCallGraphQuery formats the result of a query as two trees: the callers of the
+// function, each followed by its own callers, and its callees, each followed by its own
+// callees. A function reached again is shown without repeating its subtree.
+func FormatCallGraphQuery(q *CallGraphQuery) string {
+	var output strings.Builder
+	depth := "all levels"
+	if q.Depth > 0 {
+		depth = fmt.Sprintf("depth %d", q.Depth)
+	}
+
+	output.WriteString(fmt.Sprintf("Callers of %s (%s):\n", q.Function, depth))
+	writeQueryTree(&output, q.Function, q.Callers, "<-", func(e CallGraphEdge) (string, string) { return e.Callee, e.Caller })
+	output.WriteString(fmt.Sprintf("\nCallees of %s (%s):\n", q.Function, depth))
+	writeQueryTree(&output, q.Function, q.Callees, "->", func(e CallGraphEdge) (string, string) { return e.Caller, e.Callee })
+
+	output.WriteString(fmt.Sprintf("\nSummary: %d caller edges, %d callee edges\n", len(q.Callers), len(q.Callees)))
+	return output.String()
+}
+
+// writeQueryTree writes the edges of one direction of a query as an indented tree. ends
+// returns the function an edge is reached from and the function it leads to.
+func writeQueryTree(output *strings.Builder, function string, edges []CallGraphQueryEdge, arrow string, ends func(CallGraphEdge) (string, string)) {
+	if len(edges) == 0 {
+		output.WriteString("  (none)\n")
+		return
+	}
+	children := make(map[string][]CallGraphEdge)
+	for _, e := range edges {
+		from, _ := ends(e.CallGraphEdge)
+		children[from] = append(children[from], e.CallGraphEdge)
+	}
+
+	expanded := map[string]bool{function: true}
+	var write func(name, indent string)
+	write = func(name, indent string) {
+		for _, e := range children[name] {
+			_, to := ends(e)
+			lines := make([]string, len(e.Lines))
+			for i, line := range e.Lines {
+				lines[i] = fmt.Sprintf("%d", line)
+			}
+			label := "line"
+			if len(lines) > 1 {
+				label = "lines"
+			}
+			output.WriteString(fmt.Sprintf("%s%s %s (%s %s)", indent, arrow, to, label, strings.Join(lines, ", ")))
+			if e.Possible {
+				output.WriteString(" [possible]")
+			}
+			if expanded[to] {
+				if len(children[to]) > 0 {
+					output.WriteString(" ...")
+				}
+				output.WriteString("\n")
+				continue
+			}
+			output.WriteString("\n")
+			expanded[to] = true
+			write(to, indent+"    ")
+		}
+	}
+	write(function, "  ")
+}
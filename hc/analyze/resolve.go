@@ -0,0 +1,224 @@
+package analyze
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/types/typeutil"
+)
+
+// typedPackages are the packages of the analyzed files, loaded and type-checked with
+// go/packages. Functions are named as in the syntactic call graph ("foo", "(*Server) Run"),
+// qualified with their import path outside package main ("example.com/app/store.Open",
+// "(*example.com/app/store.DB) Query"), so functions of different packages don't collide and
+// calls are followed across packages.
+type typedPackages struct {
+	packages []*packages.Package    // Packages of the analyzed files that type-check
+	wanted   map[string]bool        // Absolute paths of the analyzed files
+	names    map[*types.Func]string // Names of the functions declared in packages
+}
+
+// loadTypedPackages loads the packages of files. Packages that can't be loaded or
+// type-checked are left out with a warning, so the calls of their files are extracted
+// syntactically instead. It returns nil if nothing could be loaded.
+func loadTypedPackages(files []string) *typedPackages {
+	wanted := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, file := range files {
+		absPath, err := filepath.Abs(file)
+		if err != nil {
+			continue
+		}
+		wanted[absPath] = true
+		dirs[filepath.Dir(absPath)] = true
+	}
+	if len(dirs) == 0 {
+		return nil
+	}
+
+	var patterns []string
+	for dir := range dirs {
+		patterns = append(patterns, dir)
+	}
+	sort.Strings(patterns)
+
+	// Dependencies are type-checked from source rather than from export data, which doesn't
+	// depend on the export format of the installed toolchain. Only the bodies of the analyzed
+	// files are needed, so the others are dropped after parsing.
+	cfg := &packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles | packages.NeedImports |
+			packages.NeedDeps | packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo,
+		ParseFile: func(fset *token.FileSet, filename string, src []byte) (*ast.File, error) {
+			file, err := parser.ParseFile(fset, filename, src, parser.SkipObjectResolution)
+			if file != nil && !wanted[sourceFileName(fset, file)] {
+				for _, decl := range file.Decls {
+					if fn, ok := decl.(*ast.FuncDecl); ok {
+						fn.Body = nil
+					}
+				}
+			}
+			return file, err
+		},
+	}
+	pkgs, err := packages.Load(cfg, patterns...)
+	if err != nil {
+		fmt.Fprintf(warningOutput, "Warning: Could not load packages to resolve calls, resolving them by name: %v\n", err)
+		return nil
+	}
+
+	// Packages with errors have incomplete type information, so their calls are left to the
+	// syntactic extraction
+	var typed []*packages.Package
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 || pkg.TypesInfo == nil {
+			if pkg.PkgPath != "" && len(pkg.Errors) > 0 {
+				fmt.Fprintf(warningOutput, "Warning: Could not type-check %s, resolving its calls by name: %v\n", pkg.PkgPath, pkg.Errors[0])
+			}
+			continue
+		}
+		typed = append(typed, pkg)
+	}
+
+	// Name every declared function first, so calls between the loaded packages resolve to the
+	// same names as the callers
+	names := make(map[*types.Func]string)
+	for _, pkg := range typed {
+		for _, file := range pkg.Syntax {
+			for _, decl := range file.Decls {
+				if fn, ok := decl.(*ast.FuncDecl); ok {
+					if obj, ok := pkg.TypesInfo.Defs[fn.Name].(*types.Func); ok {
+						names[obj] = declName(pkg.Types, fn)
+					}
+				}
+			}
+		}
+	}
+
+	return &typedPackages{packages: typed, wanted: wanted, names: names}
+}
+
+// sourceFileName returns the name of the file a parsed file was written from. The files of
+// cgo packages are loaded as the files cgo translates them into, whose //line directives
+// name the files of the package.
+func sourceFileName(fset *token.FileSet, file *ast.File) string {
+	return fset.Position(file.Package).Filename
+}
+
+// resolveCalls extracts the calls of the analyzed files with go/types, so that every call
+// names the declaration it reaches rather than the identifiers it is spelled with. Files of
+// packages that didn't type-check are missing from the result.
+func (t *typedPackages) resolveCalls() map[string][]FunctionCall {
+	calls := make(map[string][]FunctionCall)
+	for _, pkg := range t.packages {
+		for _, file := range pkg.Syntax {
+			filePath := sourceFileName(pkg.Fset, file)
+			if !t.wanted[filePath] {
+				continue
+			}
+			calls[filePath] = []FunctionCall{}
+			for _, decl := range file.Decls {
+				fn, ok := decl.(*ast.FuncDecl)
+				if !ok || fn.Body == nil {
+					continue
+				}
+				caller := declName(pkg.Types, fn)
+				ast.Inspect(fn.Body, func(n ast.Node) bool {
+					if call, ok := n.(*ast.CallExpr); ok {
+						if fc, ok := resolveCall(pkg, t.names, call, filePath, caller); ok {
+							calls[filePath] = append(calls[filePath], fc)
+						}
+					}
+					return true
+				})
+			}
+		}
+	}
+	return calls
+}
+
+// resolveCall returns the call made by a call expression. Conversions, calls of builtins and
+// calls through function values are not calls of a declared function and are skipped.
+func resolveCall(pkg *packages.Package, names map[*types.Func]string, call *ast.CallExpr, filePath, caller string) (FunctionCall, bool) {
+	fc := FunctionCall{
+		CallerFile:     filePath,
+		CallerFunction: caller,
+		Line:           pkg.Fset.Position(call.Pos()).Line,
+	}
+
+	if tv, ok := pkg.TypesInfo.Types[ast.Unparen(call.Fun)]; ok && (tv.IsType() || tv.IsBuiltin()) {
+		return fc, false
+	}
+	fn, ok := typeutil.Callee(pkg.TypesInfo, call).(*types.Func)
+	if !ok {
+		return fc, false
+	}
+	fn = fn.Origin()
+
+	recv := fn.Signature().Recv()
+	switch {
+	case recv != nil && types.IsInterface(recv.Type()):
+		// The implementation is only known at run time: like a method call on a value of
+		// unknown type, it links to every method with that name
+		fc.CalledFunction = fn.Name()
+		fc.Interface = true
+	case names[fn] != "":
+		fc.CalledFunction = names[fn]
+	default:
+		fc.Package = fn.Pkg().Path()
+		fc.CalledFunction = fn.Name()
+		if recv != nil {
+			fc.CalledFunction = fmt.Sprintf("(%s) %s", receiverString(recv.Type()), fn.Name())
+		}
+	}
+	return fc, true
+}
+
+// declName returns the call graph name of a function declared in pkg
+func declName(pkg *types.Package, fn *ast.FuncDecl) string {
+	qualifier := ""
+	if pkg.Name() != "main" {
+		qualifier = pkg.Path() + "."
+	}
+	receiver := ReceiverType(fn)
+	if receiver == "" {
+		return qualifier + fn.Name.Name
+	}
+	pointer := ""
+	if strings.HasPrefix(receiver, "*") {
+		pointer = "*"
+	}
+	return fmt.Sprintf("(%s%s%s) %s", pointer, qualifier, strings.TrimPrefix(receiver, "*"), fn.Name.Name)
+}
+
+// receiverString formats the receiver type of a method declared outside the analyzed files,
+// e.g. "*bytes.Buffer" or "example.com/lib.List[T]"
+func receiverString(t types.Type) string {
+	pointer := ""
+	if ptr, ok := t.(*types.Pointer); ok {
+		pointer = "*"
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok {
+		return pointer + types.TypeString(t, nil)
+	}
+	name := named.Obj().Name()
+	if named.Obj().Pkg() != nil {
+		name = named.Obj().Pkg().Path() + "." + name
+	}
+	if params := named.TypeParams(); params.Len() > 0 {
+		var list []string
+		for i := 0; i < params.Len(); i++ {
+			list = append(list, params.At(i).Obj().Name())
+		}
+		name += "[" + strings.Join(list, ", ") + "]"
+	}
+	return pointer + name
+}
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+	"golang.org/x/tools/go/packages"
+)
+
+// HookAnnotation opts the function it documents into instrumentation. It may be followed by
+// before=<name> and after=<name> to choose the names of the generated hook functions.
+const HookAnnotation = "//interceptor:hook"
+
+// hooksLibraryPath is the import path of the hooks library used by hooks files
+const hooksLibraryPath = "github.com/pdelewski/go-build-interceptor/hooks"
+
+// annotatedFunction is a function extendHooksFile adds a hook for: one whose doc comment holds
+// HookAnnotation, or an exported function found by --scaffold-hooks
+type annotatedFunction struct {
+	Package  string // Package as matched by hooks: the import path, or main
+	Function string
+	Receiver string // Base type of the receiver, empty for functions
+	Before   string // Hook function names given in the annotation (empty: generated)
+	After    string
+	Position token.Position // Position of the annotation
+	Origin   string         // Where the function was found, for the comments of its hook functions
+}
+
+// scanAnnotations returns the functions annotated with HookAnnotation in the packages of the
+// module in dir, sorted by package and position. Files in skipDir (the hooks package) are ignored.
+func scanAnnotations(dir, skipDir string) ([]annotatedFunction, error) {
+	cfg := &packages.Config{Mode: packages.NeedName | packages.NeedFiles, Dir: dir}
+	pkgs, err := packages.Load(cfg, "./...")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load packages: %w", err)
+	}
+
+	var found []annotatedFunction
+	for _, pkg := range pkgs {
+		packageName := pkg.PkgPath
+		if pkg.Name == "main" {
+			packageName = "main"
+		}
+		for _, file := range pkg.GoFiles {
+			if filepath.Dir(file) == skipDir {
+				continue
+			}
+			functions, err := scanFileAnnotations(file, packageName)
+			if err != nil {
+				return nil, err
+			}
+			found = append(found, functions...)
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].Position.Filename != found[j].Position.Filename {
+			return found[i].Position.Filename < found[j].Position.Filename
+		}
+		return found[i].Position.Line < found[j].Position.Line
+	})
+	return found, nil
+}
+
+// scanFileAnnotations returns the annotated functions of a Go file
+func scanFileAnnotations(file, packageName string) ([]annotatedFunction, error) {
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, file, nil, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	var found []annotatedFunction
+	for _, decl := range node.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Doc == nil {
+			continue
+		}
+		for _, comment := range funcDecl.Doc.List {
+			options, ok := strings.CutPrefix(comment.Text, HookAnnotation)
+			if !ok || options != "" && options[0] != ' ' && options[0] != '\t' {
+				continue
+			}
+			fn := annotatedFunction{
+				Package:  packageName,
+				Function: funcDecl.Name.Name,
+				Receiver: analyze.ReceiverTypeName(analyze.ReceiverType(funcDecl)),
+				Position: fset.Position(comment.Pos()),
+			}
+			fn.Origin = fmt.Sprintf("annotated in %s:%d", filepath.Base(fn.Position.Filename), fn.Position.Line)
+			for _, option := range strings.Fields(options) {
+				key, value, _ := strings.Cut(option, "=")
+				if (key != "before" && key != "after") || !token.IsIdentifier(value) {
+					return nil, fmt.Errorf("%s: invalid option %q of %s (expected before=<name> or after=<name>)", fn.Position, option, HookAnnotation)
+				}
+				if key == "before" {
+					fn.Before = value
+				} else {
+					fn.After = value
+				}
+			}
+			found = append(found, fn)
+			break
+		}
+	}
+	return found, nil
+}
+
+// extendHooksFile adds a hook for every function found that the hooks file doesn't target
+// yet, along with empty Before/After functions for the names it doesn't declare. The hooks
+// file is created if it doesn't exist, its ProvideHooks documented as returning the hooks of
+// the functions described by about. Existing hooks are kept as they are, so hooks of
+// functions whose annotation was removed have to be deleted by hand.
+func extendHooksFile(hooksFile string, found []annotatedFunction, about string) ([]instrument.HookDefinition, bool, error) {
+	if instrument.IsHooksManifest(hooksFile) {
+		return nil, false, fmt.Errorf("%s is a hooks manifest, only Go hooks files can be extended", hooksFile)
+	}
+	src, err := os.ReadFile(hooksFile)
+	created := os.IsNotExist(err)
+	if created {
+		if err := os.MkdirAll(filepath.Dir(hooksFile), 0755); err != nil {
+			return nil, false, fmt.Errorf("failed to create directory for %s: %w", hooksFile, err)
+		}
+		src = newHooksFileSource(hooksFile, about)
+	} else if err != nil {
+		return nil, false, fmt.Errorf("failed to read hooks file: %w", err)
+	}
+
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, hooksFile, src, parser.ParseComments)
+	if err != nil {
+		return nil, false, fmt.Errorf("error parsing hooks file %s: %w", hooksFile, err)
+	}
+	hooksName, ok := importName(node, hooksLibraryPath)
+	if !ok {
+		return nil, false, fmt.Errorf("%s does not import %s", hooksFile, hooksLibraryPath)
+	}
+	list := provideHooksList(node)
+	if list == nil {
+		return nil, false, fmt.Errorf("%s has no ProvideHooks function returning a []*%s.Hook literal", hooksFile, hooksName)
+	}
+	fromPath, err := instrument.GetHooksImportPath(hooksFile)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Targets already hooked and hook function names already in use
+	var existing []instrument.HookDefinition
+	if len(list.Elts) > 0 {
+		if existing, err = instrument.ParseHooksFile(hooksFile); err != nil {
+			return nil, false, err
+		}
+	}
+	hooked := make(map[string]bool)
+	used := make(map[string]bool)
+	for _, hook := range existing {
+		hooked[instrument.HookTarget(hook)] = true
+		data := newTrampolineHookData(hook, "")
+		used[data.BeforeFunc] = true
+		used[data.AfterFunc] = true
+	}
+	declared := make(map[string]bool)
+	for _, decl := range node.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil {
+			declared[funcDecl.Name.Name] = true
+		}
+	}
+
+	var added []instrument.HookDefinition
+	var entries, stubs strings.Builder
+	for _, fn := range found {
+		hook := instrument.HookDefinition{Package: fn.Package, Function: fn.Function, Receiver: fn.Receiver, Type: "before_after"}
+		target := instrument.HookTarget(hook)
+		if hooked[target] {
+			continue
+		}
+		hooked[target] = true
+		hook.BeforeFunc = fn.Before
+		if hook.BeforeFunc == "" {
+			hook.BeforeFunc = hookFunctionName("Before", fn, used)
+		}
+		hook.AfterFunc = fn.After
+		if hook.AfterFunc == "" {
+			hook.AfterFunc = hookFunctionName("After", fn, used)
+		}
+		used[hook.BeforeFunc] = true
+		used[hook.AfterFunc] = true
+		added = append(added, hook)
+
+		fmt.Fprintf(&entries, "\t\t{\n\t\t\tTarget: %s.InjectTarget{Package: %s, Function: %s", hooksName, strconv.Quote(fn.Package), strconv.Quote(fn.Function))
+		if fn.Receiver != "" {
+			fmt.Fprintf(&entries, ", Receiver: %s", strconv.Quote(fn.Receiver))
+		}
+		fmt.Fprintf(&entries, "},\n\t\t\tHooks: &%s.InjectFunctions{Before: %s, After: %s, From: %s},\n\t\t},\n",
+			hooksName, strconv.Quote(hook.BeforeFunc), strconv.Quote(hook.AfterFunc), strconv.Quote(fromPath))
+
+		for _, stub := range []struct{ name, when string }{{hook.BeforeFunc, "before"}, {hook.AfterFunc, "after"}} {
+			if declared[stub.name] {
+				continue
+			}
+			declared[stub.name] = true
+			fmt.Fprintf(&stubs, "\n// %s is called %s %s (%s)\nfunc %s(ctx %s.HookContext) {\n}\n",
+				stub.name, stub.when, target, fn.Origin, stub.name, hooksName)
+		}
+	}
+	if len(added) == 0 && !created {
+		return nil, false, nil
+	}
+
+	// Insert the new hooks before the closing brace of the literal and the functions at the end
+	rbrace := fset.Position(list.Rbrace).Offset
+	var out []byte
+	out = append(out, src[:rbrace]...)
+	out = append(out, '\n')
+	out = append(out, entries.String()...)
+	out = append(out, src[rbrace:]...)
+	out = append(out, stubs.String()...)
+	formatted, err := format.Source(out)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to format %s: %w", hooksFile, err)
+	}
+	if err := os.WriteFile(hooksFile, formatted, 0644); err != nil {
+		return nil, false, fmt.Errorf("failed to write hooks file: %w", err)
+	}
+	return added, created, nil
+}
+
+// newHooksFileSource returns an empty hooks file for the package in the directory of hooksFile,
+// for hooks of the functions described by about
+func newHooksFileSource(hooksFile, about string) []byte {
+	dir, err := filepath.Abs(filepath.Dir(hooksFile))
+	if err != nil {
+		dir = filepath.Dir(hooksFile)
+	}
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '_'
+	}, filepath.Base(dir))
+	if name == "" || !unicode.IsLetter(rune(name[0])) {
+		name = "hooks_" + name
+	}
+	return []byte(fmt.Sprintf(`package %s
+
+import "%s"
+
+// ProvideHooks returns the hooks of the functions %s
+func ProvideHooks() []*hooks.Hook {
+	return []*hooks.Hook{}
+}
+`, name, hooksLibraryPath, about))
+}
+
+// importName returns the name a file imports a package under
+func importName(file *ast.File, importPath string) (string, bool) {
+	for _, spec := range file.Imports {
+		if value, err := strconv.Unquote(spec.Path.Value); err == nil && value == importPath {
+			if spec.Name != nil {
+				return spec.Name.Name, true
+			}
+			return path.Base(importPath), true
+		}
+	}
+	return "", false
+}
+
+// provideHooksList returns the slice literal returned by the ProvideHooks function
+func provideHooksList(file *ast.File) *ast.CompositeLit {
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Name.Name != "ProvideHooks" || funcDecl.Body == nil {
+			continue
+		}
+		var list *ast.CompositeLit
+		ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+			if ret, ok := n.(*ast.ReturnStmt); ok && len(ret.Results) == 1 {
+				if lit, ok := ret.Results[0].(*ast.CompositeLit); ok {
+					list = lit
+				}
+			}
+			return list == nil
+		})
+		return list
+	}
+	return nil
+}
+
+// hookFunctionName returns an unused name for the Before or After function of an annotated
+// function: the prefix followed by the receiver and the function name, then qualified with
+// the package if another target already uses that name
+func hookFunctionName(prefix string, fn annotatedFunction, used map[string]bool) string {
+	name := prefix + exportedName(fn.Receiver) + exportedName(fn.Function)
+	if !used[name] {
+		return name
+	}
+	name = prefix + exportedName(path.Base(fn.Package)) + exportedName(fn.Receiver) + exportedName(fn.Function)
+	for i := 2; used[name]; i++ {
+		name = fmt.Sprintf("%s%s%s%s%d", prefix, exportedName(path.Base(fn.Package)), exportedName(fn.Receiver), exportedName(fn.Function), i)
+	}
+	return name
+}
+
+// exportedName capitalizes the first letter of a name and drops characters that can't be
+// part of an identifier
+func exportedName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' {
+			return r
+		}
+		return -1
+	}, name)
+	return capitalizeFirst(name)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Code generation backends that control how trampolines reach the hook implementations
+const (
+	// BackendLinkname declares bodyless functions bound to the hooks package via go:linkname
+	BackendLinkname = "linkname"
+	// BackendShim calls hooks through the exported dispatch table in the hooks library,
+	// which otel.runtime.go populates at init time
+	BackendShim = "shim"
+)
+
+// codegenBackend is the backend used for generated trampolines and runtime files
+var codegenBackend = BackendLinkname
+
+// SetCodegenBackend selects the code generation backend
+func SetCodegenBackend(name string) error {
+	switch strings.ToLower(name) {
+	case "", BackendLinkname:
+		codegenBackend = BackendLinkname
+	case BackendShim:
+		codegenBackend = BackendShim
+	default:
+		return fmt.Errorf("unknown code generation backend %q (expected %q or %q)", name, BackendLinkname, BackendShim)
+	}
+	return nil
+}
+
+// trampolinesTemplateName returns the trampolines template for the selected backend
+func trampolinesTemplateName() string {
+	if codegenBackend == BackendShim {
+		return TrampolinesShimTemplate
+	}
+	return TrampolinesTemplate
+}
+
+// otelRuntimeTemplateName returns the otel.runtime.go template for the selected backend
+func otelRuntimeTemplateName() string {
+	if codegenBackend == BackendShim {
+		return OtelRuntimeShimTemplate
+	}
+	return OtelRuntimeTemplate
+}
+
+// hookPanicPolicy is the panic policy built into instrumented programs, empty for the default
+var hookPanicPolicy string
+
+// SetHookPanicPolicy selects the policy of the hooks runtime for panicking hooks: log, count,
+// propagate, disable or disable:N (see hooks.PanicPolicy)
+func SetHookPanicPolicy(spec string) error {
+	name, count, hasCount := strings.Cut(spec, ":")
+	switch {
+	case spec == "", name == "log" && !hasCount, name == "count" && !hasCount, name == "propagate" && !hasCount:
+	case name == "disable":
+		if n, err := strconv.Atoi(count); hasCount && (err != nil || n < 1) {
+			return fmt.Errorf("invalid hook panic policy %q (expected disable:N with N at least 1)", spec)
+		}
+	default:
+		return fmt.Errorf("unknown hook panic policy %q (expected log, count, propagate, disable or disable:N)", spec)
+	}
+	hookPanicPolicy = spec
+	return nil
+}
+
+// HooksLibraryImportPath is the import path of the hooks library used by generated trampolines
+const HooksLibraryImportPath = "github.com/pdelewski/go-build-interceptor/hooks"
+
+// hooksLibraryFiles returns the hooks library files compiled into the instrumented
+// build. Only dependency-free files are listed since the library is compiled
+// with an empty importcfg.
+func hooksLibraryFiles() []string {
+	return []string{"types.go", "dispatch.go", "panics.go", "metrics.go", "runtime_env.go", "events.go", "shutdown.go"}
+}
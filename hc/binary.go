@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// binaryOut is the path of --out the binaries of compile mode are put at instead of where the
+// build put them
+var binaryOut string
+
+// SetBinaryOut sets the path the binaries of compile mode are put at
+func SetBinaryOut(path string) {
+	binaryOut = path
+}
+
+// redirectBinaries returns the commands of a build log with its binaries put at out instead of
+// where the build put them. Like the -o of go build, out is the binary of a build linking one,
+// or a directory the binaries are put in under their names when it ends with a slash or exists.
+func redirectBinaries(commands []parse.Command, out string) ([]parse.Command, error) {
+	outputs := parse.FindBinaryOutputs(commands)
+	if len(outputs) == 0 {
+		return nil, fmt.Errorf("--out: the build log puts no binary in place (no mv or cp of a link output)")
+	}
+	info, err := os.Stat(out)
+	toDir := strings.HasSuffix(out, "/") || (err == nil && info.IsDir())
+	if !toDir && len(outputs) > 1 {
+		return nil, fmt.Errorf("--out: the build log puts %d binaries in place, give a directory for them", len(outputs))
+	}
+	// The commands run in the directories of their cd, not in the one hc runs in
+	if abs, err := filepath.Abs(out); err == nil {
+		out = abs
+	}
+
+	byIndex := make(map[int]parse.BinaryOutput, len(outputs))
+	for _, output := range outputs {
+		byIndex[output.Index] = output
+	}
+	redirected := make([]parse.Command, 0, len(commands)+len(outputs))
+	for i, cmd := range commands {
+		output, ok := byIndex[i]
+		if !ok {
+			redirected = append(redirected, cmd)
+			continue
+		}
+		target := out
+		if toDir {
+			target = filepath.Join(out, output.Name())
+		}
+		redirected = append(redirected, output.To(target)...)
+		report.Printf("📦 Binary %s goes to %s instead of %s\n", output.Name(), target, output.Target)
+	}
+	return redirected, nil
+}
+
+// redirectReplayBinaries puts the binaries of the build log replayed at the path of --out
+func redirectReplayBinaries(parser *parse.Parser, out string) error {
+	commands, err := redirectBinaries(parser.GetCommands(), out)
+	if err != nil {
+		return err
+	}
+	parser.SetCommands(commands)
+	report.Println()
+	return nil
+}
+
+// reportBinaryOutputs tells where the commands of a replayed build log put their binaries
+func reportBinaryOutputs(commands []parse.Command) {
+	outputs := parse.FindBinaryOutputs(commands)
+	if len(outputs) == 0 {
+		return
+	}
+	installDirs := goInstallDirs()
+	for _, output := range outputs {
+		path := output.Path()
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		if installDirs[filepath.Dir(path)] {
+			report.Printf("📦 Installed %s to %s\n", output.Name(), path)
+		} else {
+			report.Printf("📦 Binary: %s\n", path)
+		}
+	}
+}
+
+// goInstallDirs returns the directories go install puts binaries in: GOBIN, or the bin
+// directories of GOPATH when it isn't set
+func goInstallDirs() map[string]bool {
+	dirs := make(map[string]bool)
+	out, err := exec.Command(goBinary, "env", "-json", "GOBIN", "GOPATH").Output()
+	if err != nil {
+		return dirs
+	}
+	var env map[string]string
+	if err := json.Unmarshal(out, &env); err != nil {
+		return dirs
+	}
+	if env["GOBIN"] != "" {
+		dirs[filepath.Clean(env["GOBIN"])] = true
+		return dirs
+	}
+	for _, dir := range filepath.SplitList(env["GOPATH"]) {
+		dirs[filepath.Join(dir, "bin")] = true
+	}
+	return dirs
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// captureBuildArgs are the flags and package patterns of --build-args given to the go build
+// of captures, e.g. -tags netgo -race ./cmd/...
+var captureBuildArgs []string
+
+// SetBuildArgs sets the flags and package patterns the go build of captures runs with
+func SetBuildArgs(args []string) {
+	captureBuildArgs = args
+}
+
+// captureEnvVars are the variables of the environment that change what a build compiles and
+// for which platform, recorded at capture
+var captureEnvVars = []string{
+	"GOOS", "GOARCH", "GO386", "GOAMD64", "GOARM", "GOARM64", "GOMIPS", "GOMIPS64",
+	"GOPPC64", "GORISCV64", "GOWASM", "CGO_ENABLED", "GOFLAGS",
+}
+
+// goBuildArgs returns the arguments of a go build of captures: the flags of the capturer, such
+// as -x -a -work, then --build-args
+func goBuildArgs(flags ...string) []string {
+	args := append([]string{"build"}, flags...)
+	return append(args, captureBuildArgs...)
+}
+
+// detectCaptureEnv returns the values of captureEnvVars of the go command, from the
+// environment or its go env file, leaving out the empty ones
+func detectCaptureEnv() (map[string]string, error) {
+	out, err := exec.Command(goBinary, append([]string{"env", "-json"}, captureEnvVars...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s env: %w", goBinary, err)
+	}
+	var env map[string]string
+	if err := json.Unmarshal(out, &env); err != nil {
+		return nil, fmt.Errorf("invalid output of %s env: %w", goBinary, err)
+	}
+	for name, value := range env {
+		if value == "" {
+			delete(env, name)
+		}
+	}
+	return env, nil
+}
+
+// replayEnv is the environment of the capture the replay runs with, once pinned
+var replayEnv map[string]string
+
+// pinCaptureEnv makes the replay run with the environment the build log was captured with:
+// the compile, asm and link tools read GOOS, GOARCH and the architecture variables from it,
+// not from their flags. GOFLAGS is only read by the go command, which replays don't run. The
+// log and sources that differ from the ones captured are warned about.
+func pinCaptureEnv(logFile string) error {
+	recorded, err := loadCaptureMetadata(logFile)
+	if err != nil || recorded == nil {
+		return err
+	}
+	for _, mismatch := range captureMismatches(logFile, recorded) {
+		report.Warnf("%s\n", mismatch)
+	}
+	current, err := detectCaptureEnv()
+	if err != nil {
+		return err
+	}
+	replayEnv = make(map[string]string)
+	var changed []string
+	for name, value := range recorded.Env {
+		if name == "GOFLAGS" {
+			continue
+		}
+		replayEnv[name] = value
+		if current[name] != value {
+			changed = append(changed, name+"="+value)
+		}
+		// Also seen by the commands hc runs itself, such as the hooks library compilation
+		os.Setenv(name, value)
+	}
+	if len(changed) > 0 {
+		sort.Strings(changed)
+		report.Printf("Replaying with the environment of the capture: %s\n", strings.Join(changed, " "))
+	}
+	return nil
+}
+
+// applyCaptureEnv exports the environment of the capture to the commands the parser replays
+// and the scripts it writes
+func applyCaptureEnv(parser *parse.Parser) {
+	names := make([]string, 0, len(replayEnv))
+	for name := range replayEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		parser.SetEnv(name, replayEnv[name])
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// BuildCacheDir holds the archives of instrumented builds, so rebuilding with the same hooks
+// only recompiles the packages whose inputs changed
+const BuildCacheDir = ".otel-build"
+
+// buildCacheEnabled is cleared by --no-cache
+var buildCacheEnabled = true
+
+// SetBuildCache enables or disables reusing archives from BuildCacheDir in compile mode
+func SetBuildCache(enabled bool) {
+	buildCacheEnabled = enabled
+}
+
+// cachePathPattern matches absolute paths and paths into $WORK in configuration files, such
+// as the packagefile lines of an importcfg or the Files of an embedcfg
+var cachePathPattern = regexp.MustCompile(`(?:^|[\s="])((?:\$WORK)?/[^\s"'=,{}]+)`)
+
+// buildCache computes content keys for the commands of a build log. The key of a command
+// covers its text and everything it reads: the tool binary, source files (including the
+// instrumented copies and generated files written before the replay), configuration files
+// and the keys of the files earlier commands wrote to $WORK. Two compile commands with the
+// same key produce the same archive.
+type buildCache struct {
+	dir      string            // Absolute path of BuildCacheDir
+	workDir  string            // Value of WORK in the build log
+	cwd      string            // Directory the replayed commands run in
+	keys     map[string]string // Key of every $WORK file written so far
+	pending  map[string]bool   // $WORK files written by the build log
+	heredocs map[string]string // Content of the $WORK files written by heredocs so far
+	contents map[string]string // Content hashes of files read from disk
+}
+
+func newBuildCache(dir string) (*buildCache, error) {
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get working directory: %w", err)
+	}
+	return &buildCache{
+		dir:      absDir,
+		cwd:      cwd,
+		keys:     make(map[string]string),
+		pending:  make(map[string]bool),
+		heredocs: make(map[string]string),
+		contents: make(map[string]string),
+	}, nil
+}
+
+// buildCacheRun records what applyBuildCache did, for the remote cache after the replay
+type buildCacheRun struct {
+	dir    string           // Absolute path of BuildCacheDir
+	stored []string         // Keys of the entries the replay stores
+	remote remoteCacheStats // Remote cache activity, when --remote-cache is set
+}
+
+// applyBuildCache rewrites the compile commands of a build log to reuse archives cached by
+// earlier builds. Compile commands with a cached archive are replaced by a copy from the
+// cache, the others are followed by commands storing their outputs in it. With a remote
+// cache, entries missing locally are downloaded first.
+func applyBuildCache(commands []parse.Command) ([]parse.Command, *buildCacheRun, error) {
+	cache, err := newBuildCache(BuildCacheDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	run := &buildCacheRun{dir: cache.dir}
+
+	for _, cmd := range commands {
+		if value, ok := workAssignment(&cmd); ok {
+			cache.workDir = value
+		}
+		for _, output := range cache.outputs(&cmd) {
+			cache.pending[output] = true
+		}
+	}
+
+	// Keys depend only on the build log and the files on disk, so they are all computed
+	// before any entry is downloaded
+	keys := make([]string, len(commands))
+	outputs := make([][]string, len(commands))
+	var missing []string
+	for i, cmd := range commands {
+		if cmd.Executable == "cd" && len(cmd.Args) == 1 {
+			cache.chdir(cmd.Args[0])
+		}
+		key := cache.commandKey(&cmd)
+		outputs[i] = cache.outputs(&cmd)
+		for _, output := range outputs[i] {
+			cache.keys[output] = hashStrings(key, output)
+			if cmd.IsMultiline {
+				cache.heredocs[output] = cmd.Raw
+			}
+		}
+		if parse.IsCompileCommand(&cmd) && len(outputs[i]) > 0 {
+			keys[i] = key
+			if !cache.complete(filepath.Join(cache.dir, key), outputs[i]) {
+				missing = append(missing, key)
+			}
+		}
+	}
+	downloaded := make(map[string]bool)
+	if remoteBuildCache != nil && len(missing) > 0 {
+		downloaded = fetchRemoteEntries(remoteBuildCache, cache.dir, missing, &run.remote)
+	}
+
+	var result []parse.Command
+	compiles, reused, fetched := 0, 0, 0
+	for i, cmd := range commands {
+		key := keys[i]
+		if key == "" {
+			result = append(result, cmd)
+			continue
+		}
+		compiles++
+		entry := filepath.Join(cache.dir, key)
+		if cache.complete(entry, outputs[i]) {
+			reused++
+			if downloaded[key] {
+				fetched++
+			}
+			for _, output := range outputs[i] {
+				result = append(result, shellCommand(fmt.Sprintf("cp %s %s", quoteShell(filepath.Join(entry, filepath.Base(output))), output)))
+			}
+			continue
+		}
+		result = append(result, cmd)
+		tmp := quoteShell(entry + ".tmp")
+		store := fmt.Sprintf("rm -rf %s && mkdir -p %s && cp %s %s/ && rm -rf %s && mv %s %s",
+			tmp, tmp, strings.Join(outputs[i], " "), tmp, quoteShell(entry), tmp, quoteShell(entry))
+		result = append(result, shellCommand(store))
+		run.stored = append(run.stored, key)
+	}
+
+	if compiles > 0 {
+		if remoteBuildCache != nil {
+			report.Printf("♻️  Reusing %d of %d compiled packages from %s (%d downloaded from %s)\n",
+				reused, compiles, BuildCacheDir, fetched, remoteBuildCache)
+		} else {
+			report.Printf("♻️  Reusing %d of %d compiled packages from %s\n", reused, compiles, BuildCacheDir)
+		}
+	}
+	return result, run, nil
+}
+
+// finishBuildCache uploads the entries stored by the replay to the remote cache, unless it is
+// read-only, and prints the remote cache statistics of the build
+func finishBuildCache(run *buildCacheRun) {
+	if run == nil || remoteBuildCache == nil {
+		return
+	}
+	if !remoteCacheReadOnly {
+		uploadCacheEntries(remoteBuildCache, run.dir, run.stored, &run.remote)
+	}
+	stats := run.remote
+	report.Printf("☁️  Remote cache %s: %d hits, %d misses, %d uploaded (%s down, %s up",
+		remoteBuildCache, stats.Hits, stats.Misses, stats.Uploads,
+		formatBytes(float64(stats.Downloaded)), formatBytes(float64(stats.Uploaded)))
+	if stats.Errors > 0 {
+		report.Printf(", %d errors", stats.Errors)
+	}
+	report.Printf(")\n")
+}
+
+// complete reports whether the cache entry holds every output of a compile command
+func (c *buildCache) complete(entry string, outputs []string) bool {
+	for _, output := range outputs {
+		if _, err := os.Stat(filepath.Join(entry, filepath.Base(output))); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// workAssignment returns the value of a WORK=... command
+func workAssignment(cmd *parse.Command) (string, bool) {
+	if cmd.IsMultiline || cmd.Executable == "" || len(cmd.Args) > 0 {
+		return "", false
+	}
+	value, found := strings.CutPrefix(strings.TrimSpace(cmd.Executable), "WORK=")
+	return value, found
+}
+
+// chdir follows a cd command of the build log
+func (c *buildCache) chdir(dir string) {
+	dir = c.normalize(dir)
+	if strings.HasPrefix(dir, "$WORK") {
+		dir = c.workDir + strings.TrimPrefix(dir, "$WORK")
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(c.cwd, dir)
+	}
+	c.cwd = dir
+}
+
+// normalize rewrites absolute paths into the work directory to $WORK paths, so keys don't
+// depend on where the build log was captured
+func (c *buildCache) normalize(s string) string {
+	if c.workDir == "" {
+		return s
+	}
+	s = strings.ReplaceAll(s, "${WORK}", "$WORK")
+	return strings.ReplaceAll(s, c.workDir+"/", "$WORK/")
+}
+
+// outputs returns the $WORK files a command writes: the target of a heredoc, the -o, -asmhdr
+// and -w (go tool buildid) arguments and the archive of pack r
+func (c *buildCache) outputs(cmd *parse.Command) []string {
+	if cmd.IsMultiline {
+		if cmd.Heredoc != nil {
+			return []string{c.normalize(cmd.Heredoc.Target)}
+		}
+		return nil
+	}
+	var outputs []string
+	words := append([]string{cmd.Executable}, cmd.Args...)
+	for i, arg := range words {
+		isPack := arg == "r" && i > 0 && (words[i-1] == "pack" || strings.HasSuffix(words[i-1], "/pack"))
+		if (arg == "-o" || arg == "-asmhdr" || arg == "-w" || isPack) && i+1 < len(words) {
+			if output := c.normalize(words[i+1]); strings.HasPrefix(output, "$WORK/") {
+				outputs = append(outputs, output)
+			}
+		}
+	}
+	return outputs
+}
+
+// commandKey returns the key of a command: a hash of its text and of everything it reads
+func (c *buildCache) commandKey(cmd *parse.Command) string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n", c.normalize(cmd.String()))
+	if cmd.IsMultiline {
+		for _, match := range cachePathPattern.FindAllStringSubmatch(cmd.Raw, -1) {
+			path := c.normalize(match[1])
+			fmt.Fprintf(h, "%s %s\n", path, c.pathKey(path))
+		}
+		return hex.EncodeToString(h.Sum(nil))
+	}
+
+	words := append([]string{cmd.Executable}, cmd.Args...)
+	for i, word := range words {
+		if i > 0 && (words[i-1] == "-importcfg" || words[i-1] == "-embedcfg") && c.configKey(h, word) {
+			continue
+		}
+		fmt.Fprintf(h, "%s %s\n", c.normalize(word), c.pathKey(word))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// configKey adds an importcfg or embedcfg to a key: its lines and the current keys of the
+// files they name. Import configurations are often written before the archives they list, so
+// their own keys don't cover them. The importcfg of the hooks package is written by hc before
+// the replay, with lines in no particular order, so the lines are sorted.
+func (c *buildCache) configKey(h io.Writer, word string) bool {
+	path := c.normalize(word)
+	content, written := c.heredocs[path]
+	if !written {
+		if c.pending[path] {
+			return false
+		}
+		data, err := os.ReadFile(c.resolve(path))
+		if err != nil {
+			return false
+		}
+		content = string(data)
+	}
+	lines := strings.Split(c.normalize(content), "\n")
+	for _, line := range lines {
+		for _, match := range cachePathPattern.FindAllStringSubmatch(line, -1) {
+			lines = append(lines, match[1]+" "+c.pathKey(match[1]))
+		}
+	}
+	sort.Strings(lines)
+	fmt.Fprintf(h, "%s\n%s\n", path, strings.Join(lines, "\n"))
+	return true
+}
+
+// pathKey returns what a command word contributes to the key when it names a file: the key
+// of a $WORK file written by an earlier command, or the hash of a file on disk. Words that
+// aren't files contribute nothing beyond their text.
+func (c *buildCache) pathKey(word string) string {
+	path := c.normalize(word)
+	if key, ok := c.keys[path]; ok {
+		return key
+	}
+	if c.pending[path] {
+		// Written later in the build log, so not read by this command
+		return "pending"
+	}
+	if strings.HasPrefix(word, "-") || strings.ContainsAny(word, "=$") && !strings.HasPrefix(path, "$WORK/") {
+		return ""
+	}
+	return c.contentHash(c.resolve(path))
+}
+
+// resolve returns the file system path of a normalized path
+func (c *buildCache) resolve(path string) string {
+	if rest, ok := strings.CutPrefix(path, "$WORK/"); ok {
+		return filepath.Join(c.workDir, rest)
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Join(c.cwd, path)
+	}
+	return path
+}
+
+// contentHash returns the hash of a regular file, or an empty string for anything else
+func (c *buildCache) contentHash(path string) string {
+	if hash, ok := c.contents[path]; ok {
+		return hash
+	}
+	hash := ""
+	if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+		if file, err := os.Open(path); err == nil {
+			h := sha256.New()
+			if _, err := io.Copy(h, file); err == nil {
+				hash = hex.EncodeToString(h.Sum(nil))
+			}
+			file.Close()
+		}
+	}
+	c.contents[path] = hash
+	return hash
+}
+
+// hashStrings returns the hash of parts
+func hashStrings(parts ...string) string {
+	h := sha256.New()
+	for _, part := range parts {
+		fmt.Fprintf(h, "%s\n", part)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// shellCommand returns a command running line as is
+func shellCommand(line string) parse.Command {
+	return parse.Command{Raw: line}
+}
+
+// quoteShell quotes a path for the replay script if it contains spaces or quotes
+func quoteShell(path string) string {
+	if strings.ContainsAny(path, " \t'\"$") {
+		return "'" + strings.ReplaceAll(path, "'", `'\''`) + "'"
+	}
+	return path
+}
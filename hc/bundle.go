@@ -0,0 +1,342 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+)
+
+// HooksBundleFormatVersion is the bundle layout version written by --export-hooks.
+// --import-hooks rejects bundles with a newer format.
+const HooksBundleFormatVersion = 1
+
+// Names inside a hooks bundle and of the manifest kept in an installed hooks package
+const (
+	bundleManifestName    = "manifest.json"
+	bundleFilesPrefix     = "files/"
+	InstalledManifestFile = "hooks-bundle.json"
+)
+
+// HooksBundleManifest describes a hooks bundle: the hooks it provides and the files of
+// the hooks package implementing them
+type HooksBundleManifest struct {
+	FormatVersion int          `json:"formatVersion"`
+	Name          string       `json:"name"`
+	Version       string       `json:"version"`
+	ImportPath    string       `json:"importPath"` // Import path of the hooks package in the exporting project
+	HooksFiles    []string     `json:"hooksFiles"`
+	Hooks         []BundleHook `json:"hooks"`
+	Files         []BundleFile `json:"files"`
+	CreatedAt     time.Time    `json:"createdAt"`
+}
+
+// BundleHook is a hook target provided by a bundle
+type BundleHook struct {
+	Package  string `json:"package"`
+	Function string `json:"function"`
+	Receiver string `json:"receiver,omitempty"`
+	File     string `json:"file,omitempty"`
+	Type     string `json:"type"`
+}
+
+// BundleFile is a file of the hooks package with its checksum
+type BundleFile struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// isBundledFile reports whether a file of the hooks package directory goes into the bundle
+func isBundledFile(name string) bool {
+	switch name {
+	case "go.mod", "go.sum", "README.md":
+		return true
+	case bundleManifestName, InstalledManifestFile:
+		return false
+	}
+	// Assembly files let packages declare functions the runtime provides, such as linknames
+	return strings.HasSuffix(name, ".go") || strings.HasSuffix(name, ".s") || instrument.IsHooksManifest(name)
+}
+
+// isPlainFileName reports whether name is a single path element, so installing it can't
+// escape the target directory
+func isPlainFileName(name string) bool {
+	return name != "" && name != "." && name != ".." && !strings.ContainsAny(name, `/\`)
+}
+
+// exportHooksBundle writes the hooks files, the package implementing them and its go.mod
+// to a gzip-compressed tar with a manifest
+func exportHooksBundle(bundlePath string, hooksFiles []string, version string) (*HooksBundleManifest, error) {
+	hooksFiles = instrument.UniqueHooksFiles(hooksFiles)
+	hooksDir, err := filepath.Abs(filepath.Dir(hooksFiles[0]))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	manifest := &HooksBundleManifest{
+		FormatVersion: HooksBundleFormatVersion,
+		Name:          filepath.Base(hooksDir),
+		Version:       version,
+		CreatedAt:     time.Now().UTC(),
+	}
+
+	var allHooks []instrument.HookDefinition
+	for _, hooksFile := range hooksFiles {
+		dir, err := filepath.Abs(filepath.Dir(hooksFile))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get absolute path: %w", err)
+		}
+		if dir != hooksDir {
+			return nil, fmt.Errorf("hooks files must be in one package directory to be bundled: %s is not in %s", hooksFile, hooksDir)
+		}
+		hooks, err := instrument.ParseHooksFile(hooksFile)
+		if err != nil {
+			return nil, err
+		}
+		instrument.TagHooks(hooks, hooksFile)
+		allHooks = append(allHooks, hooks...)
+		manifest.HooksFiles = append(manifest.HooksFiles, filepath.Base(hooksFile))
+	}
+	if err := instrument.CheckHookConflicts(allHooks); err != nil {
+		return nil, err
+	}
+	for _, hook := range allHooks {
+		manifest.Hooks = append(manifest.Hooks, BundleHook{
+			Package:  hook.Package,
+			Function: hook.Function,
+			Receiver: hook.Receiver,
+			File:     hook.File,
+			Type:     hook.Type,
+		})
+	}
+
+	manifest.ImportPath, err = instrument.GetHooksImportPath(hooksFiles[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect the package files; go.mod and go.sum come from the module root so the
+	// installed package is a module of its own
+	files := make(map[string]string) // name in bundle -> source path
+	entries, err := os.ReadDir(hooksDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hooks directory: %w", err)
+	}
+	for _, entry := range entries {
+		if entry.Type().IsRegular() && isBundledFile(entry.Name()) {
+			files[entry.Name()] = filepath.Join(hooksDir, entry.Name())
+		}
+	}
+	if _, exists := files["go.mod"]; !exists {
+		modPath, modDir, err := instrument.FindGoMod(hooksDir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find go.mod: %w", err)
+		}
+		files["go.mod"] = modPath
+		if _, err := os.Stat(filepath.Join(modDir, "go.sum")); err == nil {
+			files["go.sum"] = filepath.Join(modDir, "go.sum")
+		}
+	}
+
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	contents := make(map[string][]byte)
+	for _, name := range names {
+		content, err := os.ReadFile(files[name])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", files[name], err)
+		}
+		sum := sha256.Sum256(content)
+		manifest.Files = append(manifest.Files, BundleFile{
+			Name:   name,
+			Size:   int64(len(content)),
+			SHA256: hex.EncodeToString(sum[:]),
+		})
+		contents[name] = content
+	}
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	out, err := os.Create(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	if err := writeTarFile(tw, bundleManifestName, manifestData, manifest.CreatedAt); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := writeTarFile(tw, bundleFilesPrefix+name, contents[name], manifest.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write bundle: %w", err)
+	}
+	return manifest, nil
+}
+
+// writeTarFile adds a regular file to a tar archive
+func writeTarFile(tw *tar.Writer, name string, content []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(content)),
+		ModTime: modTime,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s to bundle: %w", name, err)
+	}
+	if _, err := tw.Write(content); err != nil {
+		return fmt.Errorf("failed to write %s to bundle: %w", name, err)
+	}
+	return nil
+}
+
+// readHooksBundle reads the manifest and files of a bundle, verifying the files against
+// the manifest checksums
+func readHooksBundle(bundlePath string) (*HooksBundleManifest, map[string][]byte, error) {
+	file, err := os.Open(bundlePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("not a hooks bundle: %w", err)
+	}
+	tr := tar.NewReader(gz)
+
+	var manifest *HooksBundleManifest
+	contents := make(map[string][]byte)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read bundle: %w", err)
+		}
+		if header.Typeflag == tar.TypeDir {
+			continue
+		}
+		if header.Typeflag != tar.TypeReg {
+			return nil, nil, fmt.Errorf("unexpected entry %s in bundle", header.Name)
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read %s from bundle: %w", header.Name, err)
+		}
+
+		if header.Name == bundleManifestName {
+			manifest = &HooksBundleManifest{}
+			if err := json.Unmarshal(content, manifest); err != nil {
+				return nil, nil, fmt.Errorf("invalid bundle manifest: %w", err)
+			}
+			continue
+		}
+		name := strings.TrimPrefix(header.Name, bundleFilesPrefix)
+		// Files are installed into a single directory, so anything else is rejected
+		if name == header.Name || !isPlainFileName(name) {
+			return nil, nil, fmt.Errorf("unexpected entry %s in bundle", header.Name)
+		}
+		contents[name] = content
+	}
+
+	if manifest == nil {
+		return nil, nil, fmt.Errorf("not a hooks bundle: %s missing", bundleManifestName)
+	}
+	if manifest.FormatVersion > HooksBundleFormatVersion {
+		return nil, nil, fmt.Errorf("bundle format version %d is newer than supported version %d, upgrade hc",
+			manifest.FormatVersion, HooksBundleFormatVersion)
+	}
+	if !isPlainFileName(manifest.Name) {
+		return nil, nil, fmt.Errorf("invalid bundle name %q", manifest.Name)
+	}
+
+	for _, bundleFile := range manifest.Files {
+		content, exists := contents[bundleFile.Name]
+		if !exists {
+			return nil, nil, fmt.Errorf("bundle is missing %s", bundleFile.Name)
+		}
+		sum := sha256.Sum256(content)
+		if hex.EncodeToString(sum[:]) != bundleFile.SHA256 {
+			return nil, nil, fmt.Errorf("checksum mismatch for %s", bundleFile.Name)
+		}
+	}
+	if len(contents) != len(manifest.Files) {
+		return nil, nil, fmt.Errorf("bundle contains files not listed in its manifest")
+	}
+	return manifest, contents, nil
+}
+
+// importHooksBundle installs a bundle into hooksDir/<name> and returns its manifest and
+// the installed hooks files
+func importHooksBundle(bundlePath string, hooksDir string) (*HooksBundleManifest, []string, error) {
+	manifest, contents, err := readHooksBundle(bundlePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	targetDir := filepath.Join(hooksDir, manifest.Name)
+	if _, err := os.Stat(targetDir); err == nil {
+		return nil, nil, fmt.Errorf("%s already exists, remove it to reinstall the bundle", targetDir)
+	}
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create %s: %w", targetDir, err)
+	}
+
+	for _, bundleFile := range manifest.Files {
+		target := filepath.Join(targetDir, bundleFile.Name)
+		if err := os.WriteFile(target, contents[bundleFile.Name], 0644); err != nil {
+			return nil, nil, fmt.Errorf("failed to write %s: %w", target, err)
+		}
+	}
+
+	// Keep the manifest so the installed version can be told later
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(targetDir, InstalledManifestFile), manifestData, 0644); err != nil {
+		return nil, nil, fmt.Errorf("failed to write manifest: %w", err)
+	}
+
+	var installed []string
+	for _, name := range manifest.HooksFiles {
+		hooksFile := filepath.Join(targetDir, name)
+		if _, err := instrument.ParseHooksFile(hooksFile); err != nil {
+			return nil, nil, fmt.Errorf("installed hooks file is invalid: %w", err)
+		}
+		installed = append(installed, hooksFile)
+	}
+	return manifest, installed, nil
+}
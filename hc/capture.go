@@ -4,9 +4,14 @@ import (
 	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"regexp"
 	"strings"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
 )
 
 // TextCapturer captures go build output in text format
@@ -25,18 +30,29 @@ func (t *TextCapturer) Capture() error {
 	}
 	defer logFile.Close()
 
-	fmt.Println("Running: go build -x -a -work")
-	cmd := exec.Command("go", "build", "-x", "-a", "-work")
+	args := goBuildArgs("-x", "-a", "-work")
+	report.Printf("Running: %s %s\n", goBinary, strings.Join(args, " "))
+	cmd := exec.Command(goBinary, args...)
 
 	cmd.Stdout = logFile
 	cmd.Stderr = logFile
 
+	start := time.Now()
 	err = cmd.Run()
+	recordPhase(PhaseCapture, start, err)
+	saveBuildProfile()
 	if err != nil {
-		fmt.Printf("Note: go build exited with error: %v\n", err)
-		fmt.Printf("But build commands have been captured to %s\n", logPath)
+		report.Warnf("go build exited with error: %v\n", err)
+		report.Printf("But build commands have been captured to %s\n", logPath)
 	}
 
+	if err := recordToolchain(); err != nil {
+		report.Warnf("failed to record the toolchain: %v\n", err)
+	}
+	if err := recordCaptureMetadata(CaptureFull, captureBuildArgs); err != nil {
+		report.Warnf("failed to record the capture metadata: %v\n", err)
+	}
+	recordHeredocs(logPath, HeredocsDir)
 	return nil
 }
 
@@ -48,39 +64,44 @@ func (t *TextCapturer) GetDescription() string {
 // JSONCapturer captures go build JSON output and converts to text format
 type JSONCapturer struct{}
 
-// Capture runs go build with JSON output, saves raw JSON, and converts to text
+// Capture runs go build with JSON output, writing the raw JSON to go-build.json and the build
+// log it carries to go-build.log as the build runs
 func (j *JSONCapturer) Capture() error {
 	if err := EnsureMetadataDir(); err != nil {
 		return fmt.Errorf("failed to create metadata directory: %w", err)
 	}
 
-	fmt.Println("Running: go build -x -a -work -json")
-	cmd := exec.Command("go", "build", "-x", "-a", "-work", "-json")
-
-	jsonOutput, err := cmd.CombinedOutput()
+	logPath := GetMetadataPath(BuildLogFile)
+	logFile, err := os.Create(logPath)
 	if err != nil {
-		fmt.Printf("Note: go build exited with error: %v\n", err)
-		fmt.Println("But continuing with captured JSON output...")
-	}
-
-	// Save raw JSON output
-	if err := saveRawJSON(jsonOutput); err != nil {
-		return err
+		return fmt.Errorf("failed to create %s: %w", logPath, err)
 	}
+	defer logFile.Close()
 
-	// Extract outputs and convert to text format
-	outputs, err := extractOutputsFromJSON(jsonOutput)
+	args := goBuildArgs("-x", "-a", "-work", "-json")
+	report.Printf("Running: %s %s\n", goBinary, strings.Join(args, " "))
+	stream := &jsonBuildStream{log: logFile}
+	start := time.Now()
+	runErr, err := stream.run(args)
+	recordPhase(PhaseCapture, start, runErr)
+	saveBuildProfile()
 	if err != nil {
 		return err
 	}
+	if runErr != nil {
+		report.Warnf("go build exited with error: %v\n", runErr)
+		report.Println("But continuing with captured JSON output...")
+	}
 
-	// Write to go-build.log
-	if err := writeTextOutput(outputs); err != nil {
-		return err
+	if err := recordToolchain(); err != nil {
+		report.Warnf("failed to record the toolchain: %v\n", err)
+	}
+	if err := recordCaptureMetadata(CaptureFull, captureBuildArgs); err != nil {
+		report.Warnf("failed to record the capture metadata: %v\n", err)
 	}
 
-	logPath := GetMetadataPath(BuildLogFile)
-	fmt.Printf("Extracted %d commands from JSON and saved to %s\n", len(outputs), logPath)
+	recordHeredocs(logPath, HeredocsDir)
+	report.Printf("Extracted %d commands from JSON and saved to %s\n", stream.outputs, logPath)
 	return nil
 }
 
@@ -89,74 +110,164 @@ func (j *JSONCapturer) GetDescription() string {
 	return "Captured JSON build output, converted to text format in go-build.log"
 }
 
-// saveRawJSON saves the raw JSON output to build-metadata/go-build.json
-func saveRawJSON(jsonOutput []byte) error {
+// recordHeredocs stores the content of the heredocs of a build log in build-metadata/<dir>,
+// one file per heredoc listed in its index.json, so they can be inspected and diffed
+func recordHeredocs(logFile, dir string) {
+	parser := parse.NewParser()
+	if err := parser.ParseFile(logFile); err != nil {
+		report.Warnf("failed to store the heredocs of %s: %v\n", logFile, err)
+		return
+	}
+	artifacts, err := parse.WriteHeredocArtifacts(parser.GetCommands(), GetMetadataPath(dir))
+	if err != nil {
+		report.Warnf("failed to store the heredocs of %s: %v\n", logFile, err)
+		return
+	}
+	report.Debugf("Stored %d heredocs of %s in %s\n", len(artifacts), logFile, GetMetadataPath(dir))
+}
+
+// jsonBuildStream writes the output of go build -json as the build runs, instead of holding
+// all of it: every line to go-build.json, and the Output of the actions, which is the build
+// log, to log. It reports the packages built, on one line rewritten on terminals.
+type jsonBuildStream struct {
+	log      io.Writer       // Receives the build log
+	outputs  int             // Outputs of actions written to log
+	packages map[string]bool // Packages with output so far
+	terminal bool            // Whether status messages go to a terminal
+}
+
+// run runs go build with args, which must have -json, and streams its output. runErr is the
+// error of go build, err one of reading or writing its output.
+func (s *jsonBuildStream) run(args []string) (runErr, err error) {
 	jsonPath := GetMetadataPath(BuildJSONFile)
 	jsonFile, err := os.Create(jsonPath)
 	if err != nil {
-		return fmt.Errorf("failed to create %s: %w", jsonPath, err)
+		return nil, fmt.Errorf("failed to create %s: %w", jsonPath, err)
 	}
 	defer jsonFile.Close()
 
-	_, err = jsonFile.Write(jsonOutput)
+	// One pipe for stdout and stderr keeps messages of go build such as errors in the order
+	// they were written, as in go-build.json before streaming
+	reader, writer, err := os.Pipe()
 	if err != nil {
-		return fmt.Errorf("failed to write JSON output: %w", err)
+		return nil, err
+	}
+	cmd := exec.Command(goBinary, args...)
+	cmd.Stdout = writer
+	cmd.Stderr = writer
+	if err := cmd.Start(); err != nil {
+		reader.Close()
+		writer.Close()
+		return err, nil
 	}
+	writer.Close()
 
-	return nil
+	s.packages = make(map[string]bool)
+	s.terminal = isTerminal(report.status())
+	rawJSON := bufio.NewWriter(jsonFile)
+	log := bufio.NewWriter(s.log)
+	err = s.copy(bufio.NewReader(reader), rawJSON, log)
+	reader.Close()
+	runErr = cmd.Wait()
+	if s.terminal && len(s.packages) > 0 {
+		fmt.Fprintln(report.status())
+	}
+	if err != nil {
+		return runErr, err
+	}
+	if err := rawJSON.Flush(); err != nil {
+		return runErr, fmt.Errorf("failed to write JSON output: %w", err)
+	}
+	if err := log.Flush(); err != nil {
+		return runErr, fmt.Errorf("failed to write output: %w", err)
+	}
+	return runErr, nil
 }
 
-// extractOutputsFromJSON parses JSON and extracts Output fields
-func extractOutputsFromJSON(jsonOutput []byte) ([]string, error) {
-	var allOutputs []string
-	scanner := bufio.NewScanner(strings.NewReader(string(jsonOutput)))
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if line == "" {
-			continue
+// copy copies the lines of go build -json from r, the raw lines to rawJSON and the outputs of
+// the actions to log. Lines that aren't JSON are errors of the go command before the build
+// starts, which are warned about, or commands -x echoes outside of actions, such as those
+// stamping the VCS state, shown at debug level.
+func (s *jsonBuildStream) copy(r *bufio.Reader, rawJSON, log io.Writer) error {
+	for {
+		line, readErr := r.ReadBytes('\n')
+		if len(line) > 0 {
+			if _, err := rawJSON.Write(line); err != nil {
+				return fmt.Errorf("failed to write JSON output: %w", err)
+			}
+			if err := s.action(line, log); err != nil {
+				return err
+			}
 		}
-
-		var buildAction BuildAction
-		if err := json.Unmarshal([]byte(line), &buildAction); err != nil {
-			// Skip non-JSON lines
-			continue
+		if readErr == io.EOF {
+			return nil
 		}
-
-		if buildAction.Output != "" {
-			allOutputs = append(allOutputs, buildAction.Output)
+		if readErr != nil {
+			return fmt.Errorf("error reading JSON output: %w", readErr)
 		}
 	}
+}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error scanning JSON output: %w", err)
+// action writes the output of one line of go build -json to the build log
+func (s *jsonBuildStream) action(line []byte, log io.Writer) error {
+	text := strings.TrimSpace(string(line))
+	if text == "" {
+		return nil
+	}
+	var buildAction BuildAction
+	if err := json.Unmarshal([]byte(text), &buildAction); err != nil {
+		if isGoCommandError(text) {
+			report.Warnf("go build: %s\n", text)
+		} else {
+			report.Debugf("go build: %s\n", text)
+		}
+		return nil
+	}
+	if buildAction.Output == "" {
+		return nil
+	}
+	output := buildAction.Output
+	// Add newline if the output doesn't end with one
+	if !strings.HasSuffix(output, "\n") {
+		output += "\n"
 	}
+	if _, err := io.WriteString(log, output); err != nil {
+		return fmt.Errorf("failed to write output: %w", err)
+	}
+	s.outputs++
+	if buildAction.ImportPath != "" && !s.packages[buildAction.ImportPath] {
+		s.packages[buildAction.ImportPath] = true
+		s.progress(buildAction.ImportPath)
+	}
+	return nil
+}
 
-	return allOutputs, nil
+// goCommandErrorPrefixes start the errors the go command prints outside of -json actions
+var goCommandErrorPrefixes = []string{
+	"go: ", "go build", "flag provided but not defined", "usage: go ", "Run 'go help",
+	"package ", "pattern ", "can't load package", "cannot find ", "malformed ",
 }
 
-// writeTextOutput writes the extracted outputs to build-metadata/go-build.log
-func writeTextOutput(outputs []string) error {
-	logPath := GetMetadataPath(BuildLogFile)
-	outputFile, err := os.Create(logPath)
-	if err != nil {
-		return fmt.Errorf("failed to create %s: %w", logPath, err)
-	}
-	defer outputFile.Close()
+// goSourceError matches compiler and type checker errors: file.go:line[:column]: message
+var goSourceError = regexp.MustCompile(`\.go:\d+(:\d+)?: `)
 
-	for _, output := range outputs {
-		_, err := outputFile.WriteString(output)
-		if err != nil {
-			return fmt.Errorf("failed to write output: %w", err)
-		}
-		// Add newline if the output doesn't end with one
-		if !strings.HasSuffix(output, "\n") {
-			_, err = outputFile.WriteString("\n")
-			if err != nil {
-				return fmt.Errorf("failed to write newline: %w", err)
-			}
+// isGoCommandError reports whether a line of go build that isn't JSON is an error rather than
+// a command echoed by -x
+func isGoCommandError(text string) bool {
+	for _, prefix := range goCommandErrorPrefixes {
+		if strings.HasPrefix(text, prefix) {
+			return true
 		}
 	}
+	return goSourceError.MatchString(text)
+}
 
-	return nil
+// progress reports a package the build started: on terminals by rewriting a progress line,
+// elsewhere at debug level
+func (s *jsonBuildStream) progress(pkg string) {
+	if s.terminal {
+		fmt.Fprintf(report.status(), "\r\033[K⏳ %d packages: %s", len(s.packages), pkg)
+		return
+	}
+	report.Debugf("Building %s, package %d\n", pkg, len(s.packages))
 }
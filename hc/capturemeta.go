@@ -0,0 +1,156 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// CaptureMetadata describes how, with what and from which sources a build log was captured,
+// written next to it so that the log is a reproducible artifact
+type CaptureMetadata struct {
+	CapturedAt time.Time         `json:"capturedAt"`
+	Strategy   string            `json:"strategy"`            // --capture-strategy, or exec for --exec captures
+	GoVersion  string            `json:"goVersion"`           // GOVERSION of the go command
+	Args       []string          `json:"args,omitempty"`      // Flags and package patterns of --build-args
+	Env        map[string]string `json:"env,omitempty"`       // Values of captureEnvVars the build ran with
+	Module     string            `json:"module,omitempty"`    // Path of the main module
+	ModuleDir  string            `json:"moduleDir,omitempty"` // Directory of the main module
+	Commit     string            `json:"commit,omitempty"`    // Git commit the sources were checked out at
+	Dirty      bool              `json:"dirty,omitempty"`     // Whether the sources had uncommitted changes
+	LogSHA256  string            `json:"logSha256"`           // Hash of the captured go-build.log
+}
+
+// recordCaptureMetadata writes the metadata of the capture next to the captured build log
+func recordCaptureMetadata(strategy string, args []string) error {
+	toolchain, err := detectToolchain()
+	if err != nil {
+		return err
+	}
+	env, err := detectCaptureEnv()
+	if err != nil {
+		return err
+	}
+	logHash, err := fileSHA256(GetMetadataPath(BuildLogFile))
+	if err != nil {
+		return err
+	}
+	metadata := &CaptureMetadata{
+		CapturedAt: time.Now().UTC(),
+		Strategy:   strategy,
+		GoVersion:  toolchain.Version,
+		Args:       args,
+		Env:        env,
+		LogSHA256:  logHash,
+	}
+	metadata.Module, metadata.ModuleDir = detectModule()
+	if metadata.ModuleDir != "" {
+		metadata.Commit, metadata.Dirty = gitRevision(metadata.ModuleDir)
+	}
+	data, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(GetMetadataPath(CaptureFile), append(data, '\n'), 0644)
+}
+
+// loadCaptureMetadata reads the capture metadata recorded for a build log, nil when none was
+// recorded
+func loadCaptureMetadata(logFile string) (*CaptureMetadata, error) {
+	path := filepath.Join(filepath.Dir(logFile), CaptureFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	metadata := &CaptureMetadata{}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return metadata, nil
+}
+
+// captureMismatches compares a build log and the sources with the metadata of their capture:
+// the captured log must be the one described, and the sources those of the module and commit
+// it was captured from. Logs derived from the captured one, such as go-build-modified.log,
+// aren't compared by hash.
+func captureMismatches(logFile string, recorded *CaptureMetadata) []string {
+	var mismatches []string
+	if filepath.Base(logFile) == BuildLogFile && recorded.LogSHA256 != "" {
+		if hash, err := fileSHA256(logFile); err == nil && hash != recorded.LogSHA256 {
+			mismatches = append(mismatches, fmt.Sprintf("%s changed since it was captured at %s", logFile, recorded.CapturedAt.Format(time.RFC3339)))
+		}
+	}
+	module, moduleDir := detectModule()
+	if recorded.Module != "" && (module != recorded.Module || moduleDir != recorded.ModuleDir) {
+		mismatches = append(mismatches, fmt.Sprintf("captured in module %s (%s), but hc runs in %s",
+			recorded.Module, recorded.ModuleDir, describeModule(module, moduleDir)))
+	}
+	if recorded.Commit != "" && recorded.ModuleDir != "" {
+		if commit, _ := gitRevision(recorded.ModuleDir); commit != "" && commit != recorded.Commit {
+			mismatches = append(mismatches, fmt.Sprintf("captured at commit %s, but %s is at %s", shortCommit(recorded.Commit), recorded.ModuleDir, shortCommit(commit)))
+		}
+	}
+	return mismatches
+}
+
+// detectModule returns the path and directory of the main module of the current directory,
+// empty outside modules
+func detectModule() (path, dir string) {
+	gomod := goEnv("GOMOD")["GOMOD"]
+	if gomod == "" || gomod == os.DevNull {
+		return "", ""
+	}
+	out, err := exec.Command(goBinary, "list", "-m", "-f", "{{.Path}}").Output()
+	if err != nil {
+		return "", filepath.Dir(gomod)
+	}
+	// Workspaces list their modules one per line; the first is the one of go.work
+	path, _, _ = strings.Cut(strings.TrimSpace(string(out)), "\n")
+	return path, filepath.Dir(gomod)
+}
+
+// describeModule describes the main module of the current directory for messages
+func describeModule(path, dir string) string {
+	if dir == "" {
+		return "no module"
+	}
+	return fmt.Sprintf("%s (%s)", path, dir)
+}
+
+// gitRevision returns the commit checked out in a directory and whether it has uncommitted
+// changes, empty outside git repositories
+func gitRevision(dir string) (commit string, dirty bool) {
+	out, err := exec.Command("git", "-C", dir, "rev-parse", "HEAD").Output()
+	if err != nil {
+		return "", false
+	}
+	status, err := exec.Command("git", "-C", dir, "status", "--porcelain", "--untracked-files=no").Output()
+	return strings.TrimSpace(string(out)), err == nil && len(strings.TrimSpace(string(status))) > 0
+}
+
+// shortCommit abbreviates a commit for messages
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// fileSHA256 returns the SHA-256 of the content of a file, in hex
+func fileSHA256(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
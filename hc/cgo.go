@@ -0,0 +1,42 @@
+package main
+
+import (
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// cgoCommandsByIndex returns the cgo invocations of a build log by the index of their command
+func cgoCommandsByIndex(cgoSources *parse.CgoSources) map[int]*parse.CgoInvocation {
+	invocations := cgoSources.Invocations()
+	byIndex := make(map[int]*parse.CgoInvocation, len(invocations))
+	for i := range invocations {
+		byIndex[invocations[i].Index] = &invocations[i]
+	}
+	return byIndex
+}
+
+// instrumentCgoCommand returns the cgo command translating the instrumented copies of the files
+// of its package, or modifiedCommand unchanged when none of them were instrumented. The copies
+// keep the names of their sources, so the files cgo generates keep theirs and the compile
+// command of the package needs no change.
+func instrumentCgoCommand(cmd *parse.Command, invocation *parse.CgoInvocation, modifiedCommand string,
+	fileReplacements map[string]string) string {
+	rewritten := invocation.WithFiles(cmd, fileReplacements)
+	if rewritten.Raw == cmd.Raw {
+		return modifiedCommand
+	}
+	report.Printf("           📎 cgo translates the instrumented files of package '%s'\n", invocation.ImportPath)
+	return rewritten.Raw
+}
+
+// cgoFilesInstrumented checks if any file translated by a cgo invocation was instrumented
+func cgoFilesInstrumented(invocation *parse.CgoInvocation, fileReplacements map[string]string) bool {
+	if invocation == nil {
+		return false
+	}
+	for _, file := range invocation.SourceFiles() {
+		if _, ok := fileReplacements[file]; ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// maxCheckDetails is how many files or tools a failed check lists
+const maxCheckDetails = 10
+
+// checkSourceExts are the extensions of the source files the tools of a build log read
+var checkSourceExts = map[string]bool{".go": true, ".s": true, ".c": true, ".h": true, ".cc": true, ".cpp": true, ".m": true, ".syso": true}
+
+// workPathPattern matches paths in the WORK directories of go build, e.g. /tmp/go-build123/b001/x.go
+var workPathPattern = regexp.MustCompile(`/go-build[0-9]+/`)
+
+// replayCheck is the outcome of one check of --check
+type replayCheck struct {
+	name    string
+	ok      bool
+	summary string
+	details []string
+	fix     string // What to do about a failed check
+}
+
+// checkReplayEnvironment verifies that the build log can be replayed here: the toolchain it
+// was captured with, the tools it runs, the Go version of its compiles, its source files and
+// the GOROOT and module cache they come from. Mismatches are reported with what to do about
+// them, and the check fails if any was found.
+func checkReplayEnvironment(logFile string, commands []parse.Command) error {
+	current, err := detectToolchain()
+	if err != nil {
+		return err
+	}
+	env := goEnv("GOMODCACHE")
+
+	var checks []replayCheck
+	checks = append(checks, checkRecordedToolchain(logFile, current))
+	checks = append(checks, checkCaptureMetadata(logFile))
+	checks = append(checks, checkTools(commands, current)...)
+	checks = append(checks, checkGoVersions(commands, current))
+	checks = append(checks, checkSources(commands, env["GOMODCACHE"])...)
+
+	failed := 0
+	for _, check := range checks {
+		mark := "✓"
+		if !check.ok {
+			mark = "✗"
+			failed++
+		}
+		report.Resultf("%s %s: %s\n", mark, check.name, check.summary)
+		for _, detail := range check.details {
+			report.Resultf("    %s\n", detail)
+		}
+		if !check.ok && check.fix != "" {
+			report.Resultf("  → %s\n", check.fix)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d replay checks failed", failed, len(checks))
+	}
+	report.Printf("\n%s can be replayed with %s\n", logFile, current)
+	return nil
+}
+
+// goEnv returns variables of go env, empty when go env fails
+func goEnv(names ...string) map[string]string {
+	env := make(map[string]string)
+	out, err := exec.Command(goBinary, append([]string{"env", "-json"}, names...)...).Output()
+	if err == nil {
+		json.Unmarshal(out, &env)
+	}
+	return env
+}
+
+// checkRecordedToolchain compares the go command with the toolchain recorded at capture
+func checkRecordedToolchain(logFile string, current *Toolchain) replayCheck {
+	check := replayCheck{name: "Toolchain"}
+	recorded, err := loadToolchain(logFile)
+	switch {
+	case err != nil:
+		check.summary = err.Error()
+		check.fix = "capture the build again with hc --capture"
+	case recorded == nil:
+		check.ok = true
+		check.summary = fmt.Sprintf("none recorded for %s, replaying with %s", logFile, current)
+	case recorded.Version != current.Version || recorded.GOROOT != current.GOROOT || recorded.Experiment != current.Experiment:
+		check.summary = fmt.Sprintf("captured with %s, but %s is %s", recorded, goBinary, current)
+		check.fix = fmt.Sprintf("run with --go %s, or pass --allow-toolchain-mismatch to replay anyway", recorded.GoBinary)
+	default:
+		check.ok = true
+		check.summary = fmt.Sprintf("%s, as captured", current)
+	}
+	return check
+}
+
+// checkCaptureMetadata compares the build log and the sources with the metadata of their
+// capture
+func checkCaptureMetadata(logFile string) replayCheck {
+	check := replayCheck{name: "Capture"}
+	recorded, err := loadCaptureMetadata(logFile)
+	switch {
+	case err != nil:
+		check.summary = err.Error()
+		check.fix = "capture the build again with hc --capture"
+	case recorded == nil:
+		check.ok = true
+		check.summary = fmt.Sprintf("no metadata recorded for %s", logFile)
+	default:
+		check.details = captureMismatches(logFile, recorded)
+		check.ok = len(check.details) == 0
+		source := recorded.Module
+		if source == "" {
+			source = "no module"
+		}
+		if recorded.Commit != "" {
+			source += " at " + shortCommit(recorded.Commit)
+			if recorded.Dirty {
+				source += " with uncommitted changes"
+			}
+		}
+		if check.ok {
+			check.summary = fmt.Sprintf("%s, captured %s", source, recorded.CapturedAt.Format(time.RFC3339))
+		} else {
+			check.summary = fmt.Sprintf("the log or the sources changed since the capture of %s", source)
+			check.fix = "capture the build again with hc --capture, or check out the commit it was captured at"
+		}
+	}
+	return check
+}
+
+// checkTools verifies that the tools the build log runs by absolute path exist and come
+// from the GOROOT of the go command
+func checkTools(commands []parse.Command, current *Toolchain) []replayCheck {
+	tools := make(map[string]bool)
+	goroots := make(map[string]bool)
+	usesGo := false
+	for i := range commands {
+		tool := parse.ToolPath(&commands[i])
+		if tool == "go" {
+			usesGo = true
+		}
+		if !filepath.IsAbs(tool) {
+			continue
+		}
+		tools[tool] = true
+		if root, _, ok := strings.Cut(tool, string(filepath.Separator)+filepath.Join("pkg", "tool")+string(filepath.Separator)); ok {
+			goroots[root] = true
+		}
+	}
+
+	found := replayCheck{name: "Tools", ok: true}
+	for _, tool := range sortedSet(tools) {
+		info, err := os.Stat(tool)
+		if err != nil || info.IsDir() || info.Mode().Perm()&0111 == 0 {
+			found.ok = false
+			found.details = appendDetail(found.details, tool)
+		}
+	}
+	if usesGo {
+		if _, err := exec.LookPath("go"); err != nil {
+			found.ok = false
+			found.details = appendDetail(found.details, "go (go tool buildid), not on PATH")
+		}
+	}
+	if found.ok {
+		found.summary = fmt.Sprintf("%d tools found", len(tools))
+	} else {
+		found.summary = "missing tools"
+		found.fix = "install the toolchain the build was captured with, or capture the build again with hc --capture"
+	}
+
+	goroot := replayCheck{name: "GOROOT", ok: true, summary: current.GOROOT}
+	for _, root := range sortedSet(goroots) {
+		if filepath.Clean(root) != filepath.Clean(current.GOROOT) {
+			goroot.ok = false
+			goroot.details = appendDetail(goroot.details, root)
+		}
+	}
+	if !goroot.ok {
+		goroot.summary = fmt.Sprintf("the build log runs the tools of another GOROOT than %s", current.GOROOT)
+		goroot.fix = "run with --go <GOROOT>/bin/go of the toolchain listed, or capture the build again with hc --capture"
+	}
+	return []replayCheck{found, goroot}
+}
+
+// checkGoVersions compares the -goversion of the compile commands, the version recorded in
+// the build IDs of their archives, with the version of the go command
+func checkGoVersions(commands []parse.Command, current *Toolchain) replayCheck {
+	versions := make(map[string]bool)
+	for i := range commands {
+		if parse.IsCompileCommand(&commands[i]) {
+			if version := parse.ExtractGoVersion(&commands[i]); version != "" {
+				versions[version] = true
+			}
+		}
+	}
+	check := replayCheck{name: "Go version", ok: true}
+	if len(versions) == 0 {
+		check.summary = "no compile commands with -goversion"
+		return check
+	}
+	for _, version := range sortedSet(versions) {
+		if version != current.Version {
+			check.ok = false
+			check.details = appendDetail(check.details, version)
+		}
+	}
+	if check.ok {
+		check.summary = fmt.Sprintf("compile commands built with %s", current.Version)
+	} else {
+		check.summary = fmt.Sprintf("compile commands built with other versions than %s", current.Version)
+		check.fix = "archives of other versions fail to link; replay with the go command of that version (--go)"
+	}
+	return check
+}
+
+// checkSources verifies that the source files the tools of the build log read exist, and
+// that the module cache they come from is the one of the go command
+func checkSources(commands []parse.Command, modCache string) []replayCheck {
+	cwd, _ := os.Getwd()
+	total := 0
+	var missing []string
+	caches := make(map[string]bool)
+	seen := make(map[string]bool)
+	for i := range commands {
+		cmd := &commands[i]
+		if cmd.Executable == "cd" && len(cmd.Args) == 1 {
+			dir := cmd.Args[0]
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(cwd, dir)
+			}
+			cwd = dir
+			continue
+		}
+		tool := filepath.Base(parse.ToolPath(cmd))
+		if tool != "compile" && tool != "asm" && tool != "cgo" {
+			continue
+		}
+		for _, arg := range cmd.Args {
+			if strings.HasPrefix(arg, "-") || strings.Contains(arg, "$WORK") || !checkSourceExts[filepath.Ext(arg)] {
+				continue
+			}
+			path := arg
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(cwd, path)
+			}
+			// Files of WORK directories are written by the build, or by hc --compile
+			if workPathPattern.MatchString(filepath.ToSlash(path)) || seen[path] {
+				continue
+			}
+			seen[path] = true
+			total++
+			if root, _, ok := strings.Cut(path, string(filepath.Separator)+filepath.Join("pkg", "mod")+string(filepath.Separator)); ok {
+				caches[filepath.Join(root, "pkg", "mod")] = true
+			}
+			if _, err := os.Stat(path); err != nil {
+				missing = append(missing, path)
+			}
+		}
+	}
+
+	sources := replayCheck{name: "Sources", ok: len(missing) == 0}
+	if sources.ok {
+		sources.summary = fmt.Sprintf("%d source files found", total)
+	} else {
+		sources.summary = fmt.Sprintf("%d of %d source files missing", len(missing), total)
+		for _, path := range missing {
+			sources.details = appendDetail(sources.details, path)
+		}
+		sources.fix = "check out the sources the build was captured from (go mod download restores module cache files), or capture the build again with hc --capture"
+		if len(missing) > maxCheckDetails {
+			sources.details = append(sources.details, fmt.Sprintf("... and %d more", len(missing)-maxCheckDetails))
+		}
+	}
+
+	checks := []replayCheck{sources}
+	if len(caches) > 0 {
+		cache := replayCheck{name: "Module cache", ok: true, summary: modCache}
+		for _, root := range sortedSet(caches) {
+			if modCache != "" && filepath.Clean(root) != filepath.Clean(modCache) {
+				cache.ok = false
+				cache.details = appendDetail(cache.details, root)
+			}
+		}
+		if !cache.ok {
+			cache.summary = fmt.Sprintf("the build log reads dependencies from another module cache than GOMODCACHE %s", modCache)
+			cache.fix = "set GOMODCACHE, or GOPATH to its parent, to the module cache listed, or capture the build again with hc --capture"
+		}
+		checks = append(checks, cache)
+	}
+	return checks
+}
+
+// appendDetail adds a detail to a failed check, up to maxCheckDetails
+func appendDetail(details []string, detail string) []string {
+	if len(details) >= maxCheckDetails {
+		return details
+	}
+	return append(details, detail)
+}
+
+// sortedSet returns the members of a set in order
+func sortedSet(set map[string]bool) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
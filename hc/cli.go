@@ -0,0 +1,303 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// subcommand is a command of the hc CLI, such as hc capture or hc analyze callgraph. Commands
+// run as the flags they stand for, which remain the aliases of the commands: hc instrument
+// hooks.go is hc --compile hooks.go.
+type subcommand struct {
+	name     string
+	args     string // Positional arguments, for the usage
+	summary  string
+	commands []*subcommand // Subcommands, e.g. callgraph of analyze
+	// expand returns the flags the command and its positional arguments stand for, and the
+	// arguments left for the mode after the flags, such as the new call graph of callgraph-diff
+	expand func(positional []string) (flags, rest []string, err error)
+}
+
+// fixed returns the expansion of a command without positional arguments
+func fixed(flags ...string) func([]string) ([]string, []string, error) {
+	return func(positional []string) ([]string, []string, error) {
+		if len(positional) > 0 {
+			return nil, nil, fmt.Errorf("unexpected arguments %q", positional)
+		}
+		return flags, nil, nil
+	}
+}
+
+// withArg returns the expansion of a command taking one argument, the value of flag
+func withArg(flag string, extra ...string) func([]string) ([]string, []string, error) {
+	return func(positional []string) ([]string, []string, error) {
+		if len(positional) != 1 {
+			return nil, nil, fmt.Errorf("expected one argument, got %d", len(positional))
+		}
+		return append([]string{"--" + flag, positional[0]}, extra...), nil, nil
+	}
+}
+
+// withHooks returns the expansion of a command taking hooks files, passed with --compile
+func withHooks(extra ...string) func([]string) ([]string, []string, error) {
+	return func(positional []string) ([]string, []string, error) {
+		var flags []string
+		for _, file := range positional {
+			flags = append(flags, "--compile", file)
+		}
+		return append(flags, extra...), nil, nil
+	}
+}
+
+// subcommands are the commands of the hc CLI
+var subcommands = []*subcommand{
+	{name: "capture", summary: "Capture the go build of the current directory to build-metadata/ (--json; --capture for text only)", expand: fixed("--json")},
+	{name: "exec", args: "-- <command>", summary: "Capture the go builds a command such as make build runs (--exec)", expand: fixed("--exec")},
+	{name: "instrument", args: "<hooks.go>...", summary: "Capture, instrument with the hooks and build (--compile)", expand: withHooks()},
+	{name: "plan", args: "<hooks.go>...", summary: "Show what the hooks would instrument, without touching WORK or compiling (--compile --plan)", expand: withHooks("--plan")},
+	{name: "debug", args: "<hooks.go>... [-- <args>]", summary: "Instrument, build and run the binary under dlv with the source mappings applied (--compile --debug)", expand: withHooks("--debug")},
+	{name: "preview", args: "<hooks.go>...", summary: "Write the instrumentation diffs without building (--compile --preview)", expand: withHooks("--preview")},
+	{name: "uninstrument", summary: "Remove the instrumented WORK directories, caches, debug copies and runs, keeping the capture (--uninstrument)", expand: fixed("--uninstrument")},
+	{name: "replay", summary: "Replay the build log (--execute)", expand: fixed("--execute")},
+	{name: "generate", summary: "Write the replay script of the build log (default mode)", expand: fixed()},
+	{name: "dry-run", summary: "Show the commands of the build log without running them (--dry-run)", expand: fixed("--dry-run")},
+	{name: "interactive", summary: "Replay the build log command by command (--interactive)", expand: fixed("--interactive")},
+	{name: "check", summary: "Verify the build log can be replayed here (--check)", expand: fixed("--check")},
+	{name: "source-mappings", summary: "Write source-mappings.json for debuggers (--source-mappings)", expand: fixed("--source-mappings")},
+	{name: "dlv-config", summary: "Write the dlv substitute-path rules of the source mappings, --vscode for launch.json too (--dlv-config)", expand: fixed("--dlv-config")},
+	{name: "analyze", summary: "Analyze the build log", commands: []*subcommand{
+		{name: "callgraph", summary: "Static call graph (--callgraph)", expand: fixed("--callgraph")},
+		{name: "callgraph-query", args: "<function>", summary: "Transitive callers and callees of a function (--callgraph-query)", expand: withArg("callgraph-query")},
+		{name: "callgraph-diff", args: "<old.json> <new.json>", summary: "Functions and calls added and removed between two call graphs (--callgraph-diff)",
+			expand: func(positional []string) ([]string, []string, error) {
+				if len(positional) != 2 {
+					return nil, nil, fmt.Errorf("expected the old and the new call graph, got %d arguments", len(positional))
+				}
+				return []string{"--callgraph-diff", positional[0]}, positional[1:], nil
+			}},
+		{name: "functions", summary: "Functions of the compiled files (--pack-functions)", expand: fixed("--pack-functions")},
+		{name: "files", summary: "Compiled files and their sizes (--pack-files)", expand: fixed("--pack-files")},
+		{name: "packages", summary: "Compiled packages (--pack-packages)", expand: fixed("--pack-packages")},
+		{name: "packagepath", summary: "Compiled packages and their source paths (--pack-packagepath)", expand: fixed("--pack-packagepath")},
+		{name: "workdir", summary: "Files of the WORK directory (--workdir)", expand: fixed("--workdir")},
+		{name: "weaving-report", summary: "What instrumentation added to the packages (--weaving-report)", expand: fixed("--weaving-report")},
+		{name: "commands", summary: "Parsed commands of the build log (--dump)", expand: fixed("--dump")},
+	}},
+	{name: "snapshot", summary: "Save and restore the WORK tree and build-metadata/", commands: []*subcommand{
+		{name: "create", args: "<name>", summary: "Save a snapshot (--snapshot-create)", expand: withArg("snapshot-create")},
+		{name: "restore", args: "<name>", summary: "Switch back to a snapshot (--snapshot-restore)", expand: withArg("snapshot-restore")},
+		{name: "list", summary: "List the snapshots (--snapshot-list)", expand: fixed("--snapshot-list")},
+	}},
+	{name: "hooks", summary: "Write, package and install hooks", commands: []*subcommand{
+		{name: "scan", args: "<hooks.go>", summary: "Add hooks for the functions annotated with //interceptor:hook (--scan-annotations)", expand: withArg("scan-annotations")},
+		{name: "scaffold", args: "<pattern>", summary: "Add empty hooks for the exported functions of packages (--scaffold-hooks)", expand: withArg("scaffold-hooks")},
+		{name: "export", args: "<bundle>", summary: "Package the hooks of -c into a bundle (--export-hooks)", expand: withArg("export-hooks")},
+		{name: "import", args: "<bundle>", summary: "Install a hooks bundle (--import-hooks)", expand: withArg("import-hooks")},
+	}},
+	{name: "registry", summary: "Instrumentations of the registry", commands: []*subcommand{
+		{name: "list", summary: "List the instrumentations (--list-instrumentations)", expand: fixed("--list-instrumentations")},
+		{name: "add", args: "<name>", summary: "Install an instrumentation (--add-instrumentation)", expand: withArg("add-instrumentation")},
+	}},
+	{name: "templates", args: "<dir>", summary: "Write the code generation templates to a directory (--dump-templates)", expand: withArg("dump-templates")},
+	{name: "toolexec", args: "-- <build args>", summary: "Build with go build -toolexec, instrumenting with the hooks of -c (--toolexec)", expand: fixed("--toolexec")},
+	{name: "daemon", summary: "Serve the analysis modes on a Unix socket (--daemon)", expand: fixed("--daemon")},
+	{name: "lsp", summary: "Run the language server on stdin/stdout (--lsp)", expand: fixed("--lsp")},
+	{name: "worker", args: "<addr>", summary: "Serve the build actions of --workers replays (--worker-listen)", expand: withArg("worker-listen")},
+	{name: "ui", args: "[ui flags]", summary: "Run the web UI on the current directory ($" + uiEnv + ", or ui/ui of the source checkout)"},
+	{name: "version", summary: "Print the version of hc (--version)", expand: fixed("--version")},
+}
+
+// hooksCommands are the commands that need hooks, as files or with --compile, --hooks-config
+// or --with
+var hooksCommands = map[string]bool{"instrument": true, "plan": true, "preview": true, "debug": true}
+
+// findSubcommand returns the command of commands with a name, nil if there is none
+func findSubcommand(commands []*subcommand, name string) *subcommand {
+	for _, command := range commands {
+		if command.name == name {
+			return command
+		}
+	}
+	return nil
+}
+
+// expandSubcommand returns the arguments of hc with its command replaced by the flags it
+// stands for, or unchanged without a command. Flags may come before the command, such as
+// hc --metadata-dir /tmp/meta capture. The positional arguments of the command are told from
+// the values of flags with the flags defined in flags.
+func expandSubcommand(args []string, flags *flag.FlagSet) ([]string, error) {
+	leading := flagsBefore(args, flags)
+	if leading == len(args) || args[leading] == "--" {
+		return args, nil
+	}
+	command := findSubcommand(subcommands, args[leading])
+	if command == nil {
+		if leading > 0 {
+			// Positional arguments of the flags, as the new call graph of --callgraph-diff
+			return args, nil
+		}
+		return nil, fmt.Errorf("unknown command %q (run hc help)", args[0])
+	}
+	path := command.name
+	given := append([]string(nil), args[:leading]...)
+	args = args[leading+1:]
+	for command.commands != nil {
+		if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+			return nil, fmt.Errorf("hc %s needs a command: %s", path, subcommandNames(command.commands))
+		}
+		sub := findSubcommand(command.commands, args[0])
+		if sub == nil {
+			return nil, fmt.Errorf("unknown command %q of hc %s: %s", args[0], path, subcommandNames(command.commands))
+		}
+		command, path, args = sub, path+" "+sub.name, args[1:]
+	}
+
+	var positional, passthrough []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			passthrough = args[i:]
+			break
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			positional = append(positional, arg)
+			continue
+		}
+		given = append(given, arg)
+		if takesValue(arg, flags) && i+1 < len(args) {
+			i++
+			given = append(given, args[i])
+		}
+	}
+	expanded, rest, err := command.expand(positional)
+	if err != nil {
+		return nil, fmt.Errorf("hc %s: %w", path, err)
+	}
+	if hooksCommands[command.name] && len(positional) == 0 && !hasHooksFlag(given) {
+		return nil, fmt.Errorf("hc %s: give hooks files, --hooks-config or --with", path)
+	}
+	result := append(append(expanded, given...), rest...)
+	return append(result, passthrough...), nil
+}
+
+// flagsBefore returns the number of arguments before the first positional one or --, the
+// flags and their values
+func flagsBefore(args []string, flags *flag.FlagSet) int {
+	i := 0
+	for i < len(args) && strings.HasPrefix(args[i], "-") && args[i] != "-" && args[i] != "--" {
+		if takesValue(args[i], flags) {
+			i++
+		}
+		i++
+	}
+	return min(i, len(args))
+}
+
+// takesValue reports whether a flag argument is followed by its value, as --log file is
+func takesValue(arg string, flags *flag.FlagSet) bool {
+	name := strings.TrimLeft(arg, "-")
+	if strings.Contains(name, "=") {
+		return false
+	}
+	f := flags.Lookup(name)
+	return f != nil && !isBoolFlag(f)
+}
+
+// isBoolFlag reports whether a flag takes no value, like the flags defined with flag.BoolVar
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// hasHooksFlag reports whether flags give hooks, with -c, --compile, --hooks-config or --with
+func hasHooksFlag(flags []string) bool {
+	for _, arg := range flags {
+		name, _, _ := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name == "c" || name == "compile" || name == "hooks-config" || name == "with" {
+			return true
+		}
+	}
+	return false
+}
+
+// subcommandNames lists the names of commands for messages
+func subcommandNames(commands []*subcommand) string {
+	names := make([]string, len(commands))
+	for i, command := range commands {
+		names[i] = command.name
+	}
+	return strings.Join(names, ", ")
+}
+
+// printUsage writes the commands of hc and its flags, which work with or without a command
+func printUsage(w io.Writer, flags *flag.FlagSet) {
+	fmt.Fprintf(w, "Usage: hc <command> [flags] [arguments]\n       hc [flags]\n\nCommands:\n")
+	var usages, summaries []string
+	var list func(commands []*subcommand, prefix string)
+	list = func(commands []*subcommand, prefix string) {
+		for _, command := range commands {
+			usages = append(usages, strings.TrimSpace(prefix+command.name+" "+command.args))
+			summaries = append(summaries, command.summary)
+			list(command.commands, prefix+command.name+" ")
+		}
+	}
+	list(subcommands, "")
+	width := 0
+	for _, usage := range usages {
+		width = max(width, len(usage))
+	}
+	for i, usage := range usages {
+		fmt.Fprintf(w, "  %-*s  %s\n", width, usage, summaries[i])
+	}
+	fmt.Fprintf(w, "\nFlags, also usable without a command:\n")
+	flags.SetOutput(w)
+	flags.PrintDefaults()
+}
+
+// uiEnv names the environment variable with the path of the web UI executable of hc ui
+const uiEnv = "HC_UI"
+
+// runUI runs the web UI on the current directory with the hc running it, in the directory of
+// the UI executable, where its static files are. It returns the exit code of the UI.
+func runUI(args []string) int {
+	uiPath, err := findUI()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "hc ui: %v\n", err)
+		return 1
+	}
+	dir, _ := os.Getwd()
+	self, _ := os.Executable()
+	cmd := exec.Command(uiPath, append([]string{"-dir", dir, "-interceptor", self}, args...)...)
+	cmd.Dir = filepath.Dir(uiPath)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "hc ui: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// findUI returns the path of the web UI executable: $HC_UI, or ui/ui of the source checkout
+// hc was built in, next to hc/hc
+func findUI() (string, error) {
+	if path := os.Getenv(uiEnv); path != "" {
+		return filepath.Abs(path)
+	}
+	if self, err := os.Executable(); err == nil {
+		path := filepath.Join(filepath.Dir(self), "..", "ui", "ui")
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return filepath.Clean(path), nil
+		}
+	}
+	return "", fmt.Errorf("web UI not found: build it with make -C ui, or set %s to its executable", uiEnv)
+}
@@ -1,10 +1,48 @@
 package main
 
 import (
+	"encoding/json"
 	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
 	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
 )
 
+// ProjectConfigFile is the optional per-project configuration file, read from
+// the directory hc is run in
+const ProjectConfigFile = ".hc.json"
+
+// ProjectConfig holds per-project settings that are not passed as flags
+type ProjectConfig struct {
+	Backend         string `json:"backend,omitempty"`         // Code generation backend: "linkname" or "shim"
+	NoInline        bool   `json:"noinline,omitempty"`        // Annotate instrumented functions with //go:noinline
+	PreserveLines   bool   `json:"preserveLines,omitempty"`   // Add //line directives pointing instrumented files at their original lines
+	HookPanicPolicy string `json:"hookPanicPolicy,omitempty"` // Hooks runtime policy for panicking hooks: log, count, propagate or disable[:N]
+	RemoteCache     string `json:"remoteCache,omitempty"`     // Remote cache of compiled packages: directory, http(s):// URL or s3:// location
+	Rules           string `json:"rules,omitempty"`           // Rules file changing the commands of the modified build log
+}
+
+// LoadProjectConfig reads the project configuration file from dir.
+// A missing file yields an empty configuration.
+func LoadProjectConfig(dir string) (*ProjectConfig, error) {
+	cfg := &ProjectConfig{}
+	data, err := os.ReadFile(filepath.Join(dir, ProjectConfigFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return cfg, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", ProjectConfigFile, err)
+	}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ProjectConfigFile, err)
+	}
+	return cfg, nil
+}
+
 // stringSliceFlag is a custom flag type that allows multiple values
 // Either comma-separated or multiple flags with the same name
 type stringSliceFlag []string
@@ -35,32 +73,114 @@ func ParseFlags() *Config {
 
 	// Custom flag for multiple hooks files
 	var hooksFiles stringSliceFlag
+	var with stringSliceFlag
 
-	flag.StringVar(&config.LogFile, "log", "build-metadata/go-build.log", "Path to the log file to replay")
+	flag.BoolVar(&config.Version, "version", false, "Print the version of hc and exit")
+	flag.StringVar(&config.LogFile, "log", "", "Path to the log file to replay (default go-build.log of --metadata-dir)")
+	flag.StringVar(&config.MetadataDir, "metadata-dir", DefaultMetadataDir, "Directory the build logs, replay scripts, source mappings, runs and other files of hc are written to and read from, e.g. one outside the project to keep its root clean")
 	flag.BoolVar(&config.DryRun, "dry-run", false, "Show commands without executing them")
 	flag.BoolVar(&config.Dump, "dump", false, "Dump parsed commands to console")
 	flag.BoolVar(&config.Verbose, "verbose", false, "Show detailed command information")
 	flag.BoolVar(&config.Execute, "execute", false, "Execute the generated script")
+	flag.BoolVar(&config.Native, "native", false, "Replay the build log of --execute and --compile without bash: assignments, cd, mkdir, heredocs, cp, mv and redirects are interpreted by hc and the tools run directly, so no shell quoting is involved")
+	flag.IntVar(&config.Jobs, "j", 1, "Number of independent build actions replayed in parallel by --execute and --compile (1 replays the script sequentially)")
+	flag.StringVar(&config.MemoryBudget, "memory-budget", "", "Memory the build actions replayed in parallel may use at a time, e.g. 8GiB (default no limit)")
+	flag.StringVar(&config.MemoryHints, "memory-hints", "", "Estimated memory of build actions per class, e.g. link=2GiB,cgo=1GiB,compile=512MiB")
+	flag.StringVar(&config.Workers, "workers", "", "Experimental: with -j, replay compile actions on these machines (comma-separated SSH destinations or http:// hc --worker-listen servers)")
+	flag.StringVar(&config.WorkerListen, "worker-listen", "", "Experimental: serve build actions of hc --workers on this address, e.g. :9000; without "+parse.WorkerTokenEnv+" only a loopback address such as 127.0.0.1:9000 is allowed")
+	flag.BoolVar(&config.Daemon, "daemon", false, "Keep the parsed build log, function and call graph caches in memory and serve the analysis modes as JSON on --daemon-socket, refreshing them when the build log or sources change")
+	flag.StringVar(&config.DaemonSocket, "daemon-socket", "", "Unix socket --daemon listens on (default "+DaemonSocketFile+" of --metadata-dir)")
+	flag.BoolVar(&config.LSP, "lsp", false, "Run a language server on stdin/stdout that reports the functions instrumented by the hooks of -c as diagnostics and answers hc/functions, hc/callGraph and hc/callGraphQuery requests")
+	flag.StringVar(&config.GoBinary, "go", "go", "go command builds are captured with, e.g. gotip or the go binary of a forked toolchain; replays check it is the toolchain recorded in build-metadata/"+ToolchainFile)
+	flag.BoolVar(&config.AllowToolchainMismatch, "allow-toolchain-mismatch", false, "Replay build logs with a go command other than the toolchain they were captured with, warning instead of failing")
 	flag.BoolVar(&config.Interactive, "interactive", false, "Execute commands one by one interactively")
+	flag.BoolVar(&config.Check, "check", false, "Verify the build log can be replayed here: the recorded toolchain, the compile, link and asm tools it runs, the Go version of its compiles, its source files, GOROOT and the module cache, with what to do about mismatches")
+	flag.BoolVar(&config.FreshWork, "fresh-work", false, "With --execute or --interactive, replace the WORK directories of the build log (/tmp/go-buildNNN in WORK=, commands, heredocs and importcfg files) with a new directory ($WORK if set), so old logs replay cleanly")
+	flag.StringVar(&config.LinkFlags, "ldflags", "", "With --compile, --execute, --dry-run, --generate or --interactive, add flags to the link commands of the build, quoted as for go build -ldflags, e.g. --ldflags='-X main.version=1.2.3 -s'; they come after the flags of the build log, so a -X of the same variable wins")
+	flag.StringVar(&config.BinaryOut, "out", "", "With --compile, --execute, --dry-run, --generate or --interactive, put the binary the build links at this path instead of where the build put it (its -o, the current directory or GOBIN for go install); a directory ending with / or existing gets the binaries under their names")
+	flag.StringVar(&config.OnlyPackage, "only-package", "", "With --execute, --dry-run, --generate or --interactive, keep only the commands building one package: its compile (and link, for main packages) and the actions producing the archives it imports, e.g. example.com/app/store")
+	flag.StringVar(&config.BuildArgs, "build-args", "", "With --capture, --json and --compile, give go build these flags and package patterns, quoted as for -ldflags, e.g. --build-args='-tags netgo -race -trimpath ./cmd/...'; they are recorded in build-metadata/"+CaptureFile+" with GOOS, GOARCH and CGO_ENABLED, which replays run with")
+	flag.StringVar(&config.CaptureStrategy, "capture-strategy", CaptureFull, "How --capture, --json and --compile run go build: full rebuilds every package with -a; minimal builds without -a, reusing the build cache, and adds the commands of the cached packages from the plan of go build -n -a")
 	flag.BoolVar(&config.Capture, "capture", false, "Capture go build output to go-build.log")
+	flag.BoolVar(&config.Exec, "exec", false, "Run the command given after -- (e.g. hc --exec -- make build) with a go wrapper first on PATH and capture the go build and go install it runs to go-build.log; with --compile, instrument that build instead of go build's")
 	flag.BoolVar(&config.JSONCapture, "json", false, "Capture go build JSON output and convert to text format in go-build.log")
-	flag.BoolVar(&config.PackFiles, "pack-files", false, "Process and display files from compile commands with -pack flag")
+	flag.BoolVar(&config.PackFiles, "pack-files", false, "Process and display files from compile commands with -pack flag, with their sizes and the files shared between packages")
+	flag.BoolVar(&config.Hash, "hash", false, "With --pack-files, hash every file (SHA-256) and also report different files with the same content, such as generated copies")
 	flag.BoolVar(&config.PackFunctions, "pack-functions", false, "Extract and display functions from Go files in compile commands with -pack flag")
 	flag.BoolVar(&config.PackageNames, "pack-packages", false, "Extract and display package names from compile commands with -p flag")
 	flag.BoolVar(&config.CallGraph, "callgraph", false, "Generate and display call graph from Go files in compile commands")
+	flag.StringVar(&config.Format, "format", analyze.CallGraphFormatText, "Output format for --callgraph: text or dot (Graphviz digraph on stdout, status messages on stderr)")
+	flag.StringVar(&config.Algo, "algo", analyze.CallGraphAlgorithmStatic, "Call graph algorithm for --callgraph and --callgraph-query: static (interface calls linked by method name), cha or rta")
+	flag.StringVar(&config.CallGraphQuery, "callgraph-query", "", "Show the transitive callers and callees of a function of the call graph, e.g. main.handler, store.Open or store.(*DB).Query")
+	flag.StringVar(&config.CallGraphDiff, "callgraph-diff", "", "Compare two call graphs written by --callgraph --output=json: --callgraph-diff old.json new.json lists the functions and calls added and removed, and with --compile or --hooks-config the functions each hook matches in both")
+	flag.IntVar(&config.Depth, "depth", 0, "Levels of callers and callees shown by --callgraph-query (0 for all)")
+	flag.StringVar(&config.Output, "output", OutputText, "Output format for --pack-files, --pack-functions, --pack-packages, --pack-packagepath, --callgraph, --callgraph-query, --callgraph-diff, --workdir and --weaving-report: text or json (JSON on stdout, status messages on stderr)")
+	flag.StringVar(&config.Color, "color", ColorAuto, "Color and align in columns the output of --pack-packages, --pack-functions, --dry-run and --compile: auto (on terminals, unless NO_COLOR is set), always or never")
+	flag.BoolVar(&config.NoPager, "no-pager", false, "Do not pipe the output of the listing modes through $PAGER (less) on terminals")
+	flag.BoolVar(&config.Quiet, "quiet", false, "Only print warnings, errors and the results of the mode (short for --log-level=warn)")
+	flag.StringVar(&config.LogLevel, "log-level", "info", "Level of the least severe diagnostics printed: debug, info, warn or error (results of the mode are always printed)")
 	flag.BoolVar(&config.WorkDir, "workdir", false, "Check first command and extract WORK directory, then dump all directories and files there")
 	flag.BoolVar(&config.PackPackagePath, "pack-packagepath", false, "Extract and display package names with their source paths from compile commands")
 	flag.Var(&hooksFiles, "compile", "Parse hooks file(s) and match against functions in compile commands (can be specified multiple times or comma-separated)")
 	flag.Var(&hooksFiles, "c", "Parse hooks file(s) and match against functions in compile commands (short for --compile)")
+	flag.Var(&hooksFiles, "hooks-config", "Compile with the hooks of a YAML or JSON hooks manifest instead of a Go hooks file (can be combined with --compile)")
 	flag.BoolVar(&config.SourceMappings, "source-mappings", false, "Generate source-mappings.json from existing go-build.log (for dlv debugger)")
+	flag.BoolVar(&config.DlvConfig, "dlv-config", false, "Write the dlv substitute-path rules mapping the WORK paths of the instrumented binary to the debug copies of source-mappings.json, as commands for dlv --init and as dlv configuration")
+	flag.BoolVar(&config.VSCode, "vscode", false, "With --dlv-config, add a launch configuration per binary to "+VSCodeLaunchFile)
+	flag.BoolVar(&config.Debug, "debug", false, "With --compile, run the instrumented binary under dlv exec with the substitute-path rules of --dlv-config, after checking its files resolve; arguments after -- go to the binary")
+	flag.StringVar(&config.DlvDAP, "dlv-dap", "", "With --debug, run dlv dap listening on this address, e.g. 127.0.0.1:2345, and print the launch arguments with the substitute-path rules for the client")
+	flag.BoolVar(&config.WeavingReport, "weaving-report", false, "After --compile, report the lines and bytes instrumentation added to every package and function, and its compile time and archive size against the original")
+	flag.StringVar(&config.TemplateDir, "template-dir", "", "Directory with custom templates overriding the embedded code generation templates")
+	flag.StringVar(&config.DumpTemplates, "dump-templates", "", "Write the embedded code generation templates to the given directory and exit")
+	flag.StringVar(&config.RunID, "run-id", "", "ID of the run under build-metadata/"+RunsDir+": the ID --compile and --preview give their new run, or the run --execute, --generate, --interactive, --source-mappings, --dlv-config and --weaving-report read (default: a new run, or the latest)")
+	flag.StringVar(&config.RulesFile, "rules", "", "With --compile, change the commands of the modified build log with the rules of a YAML or JSON file, e.g. add -N -l to the compile of one package (overrides "+ProjectConfigFile+")")
+	flag.StringVar(&config.HookPanicPolicy, "hook-panic-policy", "", "Policy of instrumented programs for panicking hooks: log (default), count, propagate, disable or disable:N (overrides "+ProjectConfigFile+")")
+	flag.BoolVar(&config.NoInline, "noinline", false, "Annotate instrumented functions with //go:noinline so they are never inlined")
+	flag.BoolVar(&config.PreserveLines, "preserve-lines", false, "Add //line directives to instrumented files so that panics, profiles and coverage point at the original files and lines")
+	flag.BoolVar(&config.NoCache, "no-cache", false, "With --compile, recompile every package instead of reusing archives of unchanged packages from "+BuildCacheDir+"/")
+	flag.StringVar(&config.RemoteCache, "remote-cache", "", "With --compile, share the archives of "+BuildCacheDir+"/ through a directory, http(s):// URL or s3://bucket/prefix")
+	flag.BoolVar(&config.RemoteCacheRO, "remote-cache-read-only", false, "Download archives from --remote-cache without uploading new ones")
+	flag.BoolVar(&config.NoExecute, "no-execute", false, "With --compile, instrument and write build-metadata/"+BuildModifiedLogFile+", the replay script and the preview report, but stop before executing them (run them later with --execute --run-id <id>)")
+	flag.BoolVar(&config.Plan, "plan", false, "With --compile, match the hooks against the functions of the build log and report which functions of which files and packages they would instrument, and the files instrumentation would generate, without touching WORK or compiling (--output=json for the report as JSON)")
+	flag.BoolVar(&config.Preview, "preview", false, "With --compile, instrument into a temporary directory and write per-file diffs to build-metadata/"+InstrumentationPreviewFile+" without building")
+	flag.BoolVar(&config.Toolexec, "toolexec", false, "With --compile, instrument live as a go build -toolexec wrapper instead of replaying the build log (arguments after -- are passed to go build)")
+	flag.StringVar(&config.ExportHooks, "export-hooks", "", "With --compile, package the hooks file(s) and their implementation package into a versioned bundle (tar.gz with manifest)")
+	flag.StringVar(&config.BundleVersion, "bundle-version", "0.0.0", "Version recorded in the manifest of a bundle written with --export-hooks")
+	flag.StringVar(&config.ImportHooks, "import-hooks", "", "Install a hooks bundle written by --export-hooks into --hooks-dir")
+	flag.StringVar(&config.ScanAnnotations, "scan-annotations", "", "Add a hook for every function of the module annotated with "+HookAnnotation+" to the given hooks file, creating it if needed")
+	flag.StringVar(&config.ScaffoldHooks, "scaffold-hooks", "", "Add a hook with empty Before/After functions for every exported function of the packages matching a pattern (e.g. ./... or ./store) to --scaffold-output, creating it if needed")
+	flag.StringVar(&config.ScaffoldOutput, "scaffold-output", DefaultScaffoldOutput, "Hooks file written by --scaffold-hooks")
+	flag.StringVar(&config.SnapshotCreate, "snapshot-create", "", "Archive the WORK tree, build-metadata and the hooks packages of --compile into a named snapshot in "+SnapshotDir+"/")
+	flag.StringVar(&config.SnapshotRestore, "snapshot-restore", "", "Restore the WORK tree, build-metadata and hooks packages of a named snapshot")
+	flag.BoolVar(&config.Uninstrument, "uninstrument", false, "Remove the instrumentation artifacts: the WORK directories of instrumented builds, "+BuildCacheDir+"/, "+DebugBuildDir+"/ and the modified build logs, replay scripts and runs of build-metadata, keeping the captured build log")
+	flag.BoolVar(&config.SnapshotList, "snapshot-list", false, "List the snapshots in "+SnapshotDir+"/")
+	flag.StringVar(&config.HooksDir, "hooks-dir", "instrumentations", "Directory hooks bundles are installed into by --import-hooks and --add-instrumentation (one subdirectory per bundle)")
+	flag.BoolVar(&config.ListInstrumentations, "list-instrumentations", false, "List the instrumentations of --registry with their compatibility and install status")
+	flag.StringVar(&config.AddInstrumentation, "add-instrumentation", "", "Install an instrumentation from --registry, and those it requires, into --hooks-dir")
+	flag.StringVar(&config.Registry, "registry", DefaultRegistry, "Registry file or URL of available instrumentations")
+	flag.Var(&with, "with", "Compile with the instrumentation of --registry instrumenting a package, e.g. net/http, or named so, and those it requires, installing them into --hooks-dir if missing (can be specified multiple times or comma-separated); without --registry and "+DefaultRegistry+", the registry of the hc source tree is read")
+	flag.StringVar(&config.Backend, "backend", "", "Code generation backend for hooks: linkname (default) or shim (overrides "+ProjectConfigFile+")")
 
-	flag.Parse()
+	// Commands such as hc instrument hooks.go are parsed as the flags they stand for
+	flag.Usage = func() { printUsage(flag.CommandLine.Output(), flag.CommandLine) }
+	args := os.Args[1:]
+	if len(args) > 0 && args[0] == "help" {
+		printUsage(os.Stdout, flag.CommandLine)
+		os.Exit(0)
+	}
+	args, err := expandSubcommand(args, flag.CommandLine)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	flag.CommandLine.Parse(args)
 
 	// Copy hooks files to config
 	config.HooksFiles = hooksFiles
+	config.With = with
 
-	// If HooksFiles is provided, set Compile to true
-	if len(config.HooksFiles) > 0 {
+	// If HooksFiles is provided, set Compile to true; the hooks files of --with are added in Run
+	if len(config.HooksFiles) > 0 || len(config.With) > 0 {
 		config.Compile = true
 	}
 	return config
@@ -69,18 +189,64 @@ func ParseFlags() *Config {
 // GetExecutionMode returns the execution mode based on config flags
 func (c *Config) GetExecutionMode() string {
 	switch {
+	case c.Version:
+		return "version"
+	case c.Toolexec:
+		return "toolexec"
+	case c.WorkerListen != "":
+		return "worker"
+	case c.Daemon:
+		return "daemon"
+	case c.LSP:
+		return "lsp"
+	case c.DumpTemplates != "":
+		return "dump-templates"
+	case c.ImportHooks != "":
+		return "import-hooks"
+	case c.SnapshotCreate != "":
+		return "snapshot-create"
+	case c.SnapshotRestore != "":
+		return "snapshot-restore"
+	case c.Uninstrument:
+		return "uninstrument"
+	case c.SnapshotList:
+		return "snapshot-list"
+	case c.ScanAnnotations != "":
+		return "scan-annotations"
+	case c.ScaffoldHooks != "":
+		return "scaffold-hooks"
+	case c.ExportHooks != "":
+		return "export-hooks"
+	case c.ListInstrumentations:
+		return "list-instrumentations"
+	case c.AddInstrumentation != "":
+		return "add-instrumentation"
+	case c.CallGraphDiff != "":
+		return "callgraph-diff"
+	case c.Exec && !c.Compile:
+		return "exec"
 	case c.JSONCapture:
 		return "json-capture"
 	case c.Capture:
 		return "capture"
+	case c.Compile && c.Plan:
+		return "plan"
+	case c.Compile && c.Preview:
+		return "preview"
 	case c.Compile:
 		return "compile"
 	case c.SourceMappings:
 		return "source-mappings"
+	case c.DlvConfig:
+		return "dlv-config"
+	case c.WeavingReport:
+		return "weaving-report"
 	case c.WorkDir:
 		return "workdir"
 	case c.PackPackagePath:
 		return "pack-packagepath"
+	case c.CallGraphQuery != "":
+		return "callgraph-query"
 	case c.CallGraph:
 		return "callgraph"
 	case c.PackageNames:
@@ -93,6 +259,8 @@ func (c *Config) GetExecutionMode() string {
 		return "verbose"
 	case c.Dump:
 		return "dump"
+	case c.Check:
+		return "check"
 	case c.DryRun:
 		return "dry-run"
 	case c.Interactive:
@@ -0,0 +1,514 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// DaemonSocketFile is the Unix socket --daemon listens on unless --daemon-socket is given
+const DaemonSocketFile = "hc.sock"
+
+// daemonInputs are the files besides the build log and the compiled Go files whose changes
+// invalidate the caches of the daemon: they decide which packages belong to the module
+var daemonInputs = []string{"go.mod", "go.sum", "go.work"}
+
+// DaemonStatus is the /status result of the daemon
+type DaemonStatus struct {
+	LogFile       string    `json:"logFile"`
+	Module        string    `json:"module"`
+	Started       time.Time `json:"started"`
+	Commands      int       `json:"commands"`
+	Files         int       `json:"files"`       // Compiled Go files watched for changes
+	CachedFiles   int       `json:"cachedFiles"` // Files whose functions are cached
+	CallGraphs    []string  `json:"callGraphs"`  // Algorithms of the cached call graphs
+	Results       int       `json:"results"`     // Cached results of earlier requests
+	HooksFiles    []string  `json:"hooksFiles"`
+	Hooks         int       `json:"hooks"`
+	Hits          int       `json:"hits"`
+	Misses        int       `json:"misses"`
+	Invalidations int       `json:"invalidations"`
+}
+
+// FileFunctions is the /functions result: the functions of a compiled Go file and the hooks of
+// the daemon's hooks files instrumenting them
+type FileFunctions struct {
+	File      string          `json:"file"`
+	Package   string          `json:"package"`
+	Functions []FunctionHooks `json:"functions"`
+}
+
+// FunctionHooks is a function of a file with the hooks matching it
+type FunctionHooks struct {
+	Name      string      `json:"name"`
+	Receiver  string      `json:"receiver,omitempty"`
+	Signature string      `json:"signature"`
+	Line      int         `json:"line"`   // Line of the function name, 1-based
+	Column    int         `json:"column"` // Byte column of the function name, 1-based
+	Hooks     []HookMatch `json:"hooks"`
+}
+
+// HookMatch is a hook matching a function. Target is the target as the hooks file declares
+// it, which may be a pattern.
+type HookMatch struct {
+	Target    string `json:"target"`
+	Type      string `json:"type"` // "before_after", "rewrite" or "both"
+	Before    string `json:"before,omitempty"`
+	After     string `json:"after,omitempty"`
+	Rewrite   string `json:"rewrite,omitempty"`
+	Priority  int    `json:"priority,omitempty"`
+	HooksFile string `json:"hooksFile"`
+}
+
+// fileStamp identifies the content of a file without reading it
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// cachedFunctions are the functions extracted from a file with the stamp it had then
+type cachedFunctions struct {
+	stamp        fileStamp
+	functions    []analyze.FunctionInfo
+	syntaxErrors []analyze.SyntaxError
+	err          error
+}
+
+// daemon keeps what the analysis modes compute from the build log in memory between
+// requests. Every request checks the build log, the compiled Go files and daemonInputs:
+// a new build log drops everything, a changed source file the results derived from it.
+// Requests are served one at a time, since the analyses report through the global reporter.
+type daemon struct {
+	logFile    string
+	hooksFiles []string
+	started    time.Time
+
+	mu            sync.Mutex
+	logStamp      fileStamp
+	commands      []parse.Command
+	compileCount  int
+	files         []string          // Compiled Go files
+	filePackages  map[string]string // Package compiling each Go file, by absolute path
+	fileImports   map[string]string // Import path of the package compiling each Go file
+	hooksStamp    string            // Digest of the stamps of hooksFiles
+	hooks         []instrument.HookDefinition
+	sourcesStamp  string // Digest of the stamps of files and daemonInputs
+	packageInfo   *analyze.PackageInfo
+	callGraphs    map[string]*analyze.CallGraph // By algorithm
+	results       map[string]interface{}        // By request URL
+	functions     map[string]cachedFunctions    // By file, kept across invalidations
+	hits, misses  int
+	invalidations int
+}
+
+func newDaemon(logFile string, hooksFiles []string) *daemon {
+	return &daemon{
+		logFile:    logFile,
+		hooksFiles: instrument.UniqueHooksFiles(hooksFiles),
+		started:    time.Now(),
+		callGraphs: make(map[string]*analyze.CallGraph),
+		results:    make(map[string]interface{}),
+		functions:  make(map[string]cachedFunctions),
+	}
+}
+
+// stampOf returns the stamp of a file, zero if it doesn't exist
+func stampOf(path string) fileStamp {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fileStamp{}
+	}
+	return fileStamp{size: info.Size(), modTime: info.ModTime()}
+}
+
+// stampsDigest returns a digest of the stamps of files, which changes when any of them does
+func stampsDigest(files []string) string {
+	digest := sha256.New()
+	for _, file := range files {
+		stamp := stampOf(file)
+		fmt.Fprintf(digest, "%s\x00%d\x00%d\n", file, stamp.size, stamp.modTime.UnixNano())
+	}
+	return hex.EncodeToString(digest.Sum(nil))
+}
+
+// refresh brings the caches up to date with the files on disk. It must be called with d.mu held.
+func (d *daemon) refresh() error {
+	logStamp := stampOf(d.logFile)
+	if logStamp == (fileStamp{}) {
+		return fmt.Errorf("%s does not exist, capture a build first", d.logFile)
+	}
+	if logStamp != d.logStamp || d.commands == nil {
+		parser := parse.NewParser()
+		parser.SetOutput(report.Out)
+		parser.SetLogger(report.Log)
+		if err := parser.ParseFile(d.logFile); err != nil {
+			return fmt.Errorf("error parsing file: %w", err)
+		}
+		if d.commands != nil {
+			report.Printf("🔄 %s changed, reloaded %d commands\n", d.logFile, len(parser.GetCommands()))
+		}
+		d.logStamp = logStamp
+		d.commands = parser.GetCommands()
+		d.compileCount, d.files = compiledGoFiles(d.commands)
+		d.filePackages = make(map[string]string)
+		d.fileImports = make(map[string]string)
+		cgoSources := parse.NewCgoSources(d.commands)
+		packagePaths := parse.NewPackagePaths(d.commands)
+		for _, cmd := range d.commands {
+			if !parse.IsCompileCommand(&cmd) {
+				continue
+			}
+			packageName := parse.ExtractPackageName(&cmd)
+			for _, file := range cgoSources.SourceFiles(&cmd) {
+				if absFile, err := filepath.Abs(file); err == nil {
+					d.filePackages[absFile] = packageName
+					d.fileImports[absFile] = packagePaths.ImportPath(&cmd)
+				}
+			}
+		}
+		d.sourcesStamp = ""
+	}
+	d.refreshHooks()
+
+	sourcesStamp := stampsDigest(append(append([]string{}, d.files...), daemonInputs...))
+	if sourcesStamp != d.sourcesStamp {
+		if d.sourcesStamp != "" {
+			report.Println("🔄 Sources changed, dropped cached results")
+		}
+		d.sourcesStamp = sourcesStamp
+		d.packageInfo = nil
+		d.callGraphs = make(map[string]*analyze.CallGraph)
+		d.results = make(map[string]interface{})
+		d.invalidations++
+	}
+	return nil
+}
+
+// refreshHooks reloads the hooks files when they changed. Hooks files that don't parse, for
+// example while they are being edited, are skipped with a warning; when hooks conflict, the
+// hooks loaded before are kept.
+func (d *daemon) refreshHooks() {
+	hooksStamp := stampsDigest(d.hooksFiles)
+	if hooksStamp == d.hooksStamp {
+		return
+	}
+	hooks, _, _, err := loadHooksFiles(d.hooksFiles)
+	if err != nil {
+		report.Warnf("failed to load hooks, keeping the hooks loaded before: %v\n", err)
+		return
+	}
+	if d.hooksStamp != "" {
+		report.Printf("🔄 Hooks files changed, reloaded %d hooks\n", len(hooks))
+	}
+	d.hooksStamp = hooksStamp
+	d.hooks = hooks
+	d.results = make(map[string]interface{})
+}
+
+// fileFunctions returns the functions of a compiled Go file with the hooks matching them
+func (d *daemon) fileFunctions(file string) (*FileFunctions, error) {
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return nil, err
+	}
+	packageName, ok := d.filePackages[absFile]
+	if !ok {
+		return nil, errDaemonRequest(fmt.Sprintf("%s is not compiled by %s", file, d.logFile))
+	}
+	functions, _, err := d.extractFunctions(absFile)
+	if err != nil {
+		return nil, err
+	}
+
+	hooks := instrument.ResolveMainPackage(d.hooks, packageName, d.fileImports[absFile])
+	result := &FileFunctions{File: absFile, Package: packageName, Functions: []FunctionHooks{}}
+	for _, fn := range functions {
+		entry := FunctionHooks{
+			Name:      fn.Name,
+			Receiver:  fn.Receiver,
+			Signature: analyze.FormatFunctionSignature(fn),
+			Line:      fn.Line,
+			Column:    fn.Column,
+			Hooks:     []HookMatch{},
+		}
+		for _, hook := range hooks {
+			fnInfo := fn
+			matched := instrument.MatchFunctionWithHooks(packageName, &fnInfo, []instrument.HookDefinition{hook})
+			if matched == nil {
+				continue
+			}
+			match := HookMatch{
+				Target:    instrument.HookTarget(hook),
+				Type:      matched.Type,
+				Rewrite:   matched.RewriteFuncName,
+				Priority:  hook.Priority,
+				HooksFile: hook.HooksFile,
+			}
+			if matched.Type != "rewrite" {
+				names := newTrampolineHookData(*matched, "")
+				match.Before = names.BeforeFunc
+				match.After = names.AfterFunc
+			}
+			entry.Hooks = append(entry.Hooks, match)
+		}
+		result.Functions = append(result.Functions, entry)
+	}
+	return result, nil
+}
+
+// extractFunctions is the functionExtractor of the daemon, which parses a file again only
+// when it changed
+func (d *daemon) extractFunctions(file string) ([]analyze.FunctionInfo, []analyze.SyntaxError, error) {
+	stamp := stampOf(file)
+	if cached, ok := d.functions[file]; ok && cached.stamp == stamp && stamp != (fileStamp{}) {
+		return cached.functions, cached.syntaxErrors, cached.err
+	}
+	functions, syntaxErrors, err := analyze.ExtractFunctionsFromGoFileWithErrors(file)
+	d.functions[file] = cachedFunctions{stamp: stamp, functions: functions, syntaxErrors: syntaxErrors, err: err}
+	return functions, syntaxErrors, err
+}
+
+// loadPackageInfo returns the packages of the module, nil if they can't be loaded
+func (d *daemon) loadPackageInfo() *analyze.PackageInfo {
+	if d.packageInfo == nil {
+		packageInfo, err := analyze.GetPackageInfo(".")
+		if err != nil {
+			report.Warnf("could not load package info: %v\n", err)
+			return nil
+		}
+		d.packageInfo = packageInfo
+	}
+	return d.packageInfo
+}
+
+// callGraph returns the call graph of the compiled files built with the given algorithm
+func (d *daemon) callGraph(algorithm string) (*analyze.CallGraph, error) {
+	if cg, ok := d.callGraphs[algorithm]; ok {
+		return cg, nil
+	}
+	cg, err := analyze.BuildCallGraphWithAlgorithm(d.files, d.loadPackageInfo(), algorithm)
+	if err != nil {
+		return nil, fmt.Errorf("error building call graph: %w", err)
+	}
+	d.callGraphs[algorithm] = cg
+	return cg, nil
+}
+
+// handler serves the results of the analysis modes as the JSON they print with --output=json
+func (d *daemon) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", d.serve(func(r *http.Request) (interface{}, error) {
+		return d.status(), nil
+	}, false))
+	mux.HandleFunc("/pack-files", d.serve(func(r *http.Request) (interface{}, error) {
+		return packFilesOutput(d.commands, r.URL.Query().Get("hash") == "true"), nil
+	}, true))
+	mux.HandleFunc("/pack-functions", d.serve(func(r *http.Request) (interface{}, error) {
+		return packFunctionsOutput(d.commands, d.extractFunctions), nil
+	}, true))
+	mux.HandleFunc("/pack-packages", d.serve(func(r *http.Request) (interface{}, error) {
+		return packPackagesOutput(d.commands), nil
+	}, true))
+	mux.HandleFunc("/pack-packagepath", d.serve(func(r *http.Request) (interface{}, error) {
+		return packPackagePathOutput(d.commands), nil
+	}, true))
+	mux.HandleFunc("/callgraph", d.serve(func(r *http.Request) (interface{}, error) {
+		cg, err := d.callGraph(queryAlgorithm(r))
+		if err != nil {
+			return nil, err
+		}
+		result := callGraphOutput(cg, d.loadPackageInfo())
+		result.CompileCommands = d.compileCount
+		result.Files = len(d.files)
+		result.SyntaxErrors = cg.SyntaxErrors
+		return result, nil
+	}, true))
+	mux.HandleFunc("/functions", d.serve(func(r *http.Request) (interface{}, error) {
+		file := r.URL.Query().Get("file")
+		if file == "" {
+			return nil, errDaemonRequest("missing file parameter")
+		}
+		return d.fileFunctions(file)
+	}, true))
+	mux.HandleFunc("/callgraph-query", d.serve(func(r *http.Request) (interface{}, error) {
+		function := r.URL.Query().Get("function")
+		if function == "" {
+			return nil, errDaemonRequest("missing function parameter")
+		}
+		depth := 0
+		if value := r.URL.Query().Get("depth"); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return nil, errDaemonRequest(fmt.Sprintf("invalid depth %q", value))
+			}
+			depth = n
+		}
+		cg, err := d.callGraph(queryAlgorithm(r))
+		if err != nil {
+			return nil, err
+		}
+		return analyze.QueryCallGraph(cg, function, depth)
+	}, true))
+	return mux
+}
+
+// errDaemonRequest is an invalid request, answered with 400 Bad Request
+type errDaemonRequest string
+
+func (e errDaemonRequest) Error() string { return string(e) }
+
+// queryAlgorithm returns the call graph algorithm of a request, static by default
+func queryAlgorithm(r *http.Request) string {
+	if algorithm := r.URL.Query().Get("algo"); algorithm != "" {
+		return algorithm
+	}
+	return analyze.CallGraphAlgorithmStatic
+}
+
+// serve returns a handler answering GET requests with the JSON of compute. Results of cached
+// handlers are kept by URL until the inputs change.
+func (d *daemon) serve(compute func(r *http.Request) (interface{}, error), cached bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		start := time.Now()
+		if err := d.refresh(); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		key := r.URL.RequestURI()
+		result, hit := d.results[key]
+		if !hit || !cached {
+			var err error
+			if result, err = compute(r); err != nil {
+				status := http.StatusInternalServerError
+				var invalid errDaemonRequest
+				if errors.As(err, &invalid) {
+					status = http.StatusBadRequest
+				}
+				http.Error(w, err.Error(), status)
+				return
+			}
+			if cached {
+				d.results[key] = result
+				d.misses++
+			}
+		} else {
+			d.hits++
+		}
+		report.Debugf("%s %s (cached: %t) in %s\n", r.Method, key, hit, time.Since(start).Round(time.Millisecond))
+
+		w.Header().Set("Content-Type", "application/json")
+		writeJSON(w, result)
+	}
+}
+
+// status describes the caches of the daemon. It must be called with d.mu held.
+func (d *daemon) status() DaemonStatus {
+	status := DaemonStatus{
+		LogFile:       d.logFile,
+		Started:       d.started,
+		Commands:      len(d.commands),
+		Files:         len(d.files),
+		CachedFiles:   len(d.functions),
+		CallGraphs:    []string{},
+		Results:       len(d.results),
+		Hits:          d.hits,
+		Misses:        d.misses,
+		Invalidations: d.invalidations,
+		HooksFiles:    append([]string{}, d.hooksFiles...),
+		Hooks:         len(d.hooks),
+	}
+	if d.packageInfo != nil {
+		status.Module = d.packageInfo.ModulePath
+	}
+	for algorithm := range d.callGraphs {
+		status.CallGraphs = append(status.CallGraphs, algorithm)
+	}
+	sort.Strings(status.CallGraphs)
+	return status
+}
+
+// listenDaemon listens on the Unix socket at path, replacing a socket left behind by a daemon
+// that is gone. Only the user running hc may connect.
+func listenDaemon(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("a daemon is already listening on %s", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// runDaemon serves the analyses of the build log in logFile, and the functions the hooks of
+// hooksFiles match, on the Unix socket at path until interrupted. The build log is parsed
+// before listening, so a missing log is reported at once.
+func runDaemon(logFile string, hooksFiles []string, path string) error {
+	d := newDaemon(logFile, hooksFiles)
+	d.mu.Lock()
+	err := d.refresh()
+	d.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	if err := EnsureMetadataDir(); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+	listener, err := listenDaemon(path)
+	if err != nil {
+		return err
+	}
+	report.Printf("Serving %d commands of %s on %s\n", len(d.commands), logFile, path)
+	report.Printf("Try: curl --unix-socket %s http://hc/status\n", path)
+
+	server := &http.Server{Handler: d.handler()}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdown)
+	}()
+
+	// Closing the listener removes the socket
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	report.Println("Daemon stopped")
+	return nil
+}
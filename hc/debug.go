@@ -0,0 +1,216 @@
+package main
+
+import (
+	"debug/dwarf"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// debugBinary runs the binary compile mode built under dlv with the substitute-path rules of
+// the source mappings, after checking that the files of the binary they map exist: dlv exec
+// with the rules as its --init commands, or with dapAddr, dlv dap listening there for a client
+// sending them with its launch request. The binary must have been built since built.
+func debugBinary(programArgs []string, dapAddr string, built time.Time) error {
+	report.Println()
+	report.Println("=== Debug Mode ===")
+	binary, err := debugTarget(built)
+	if err != nil {
+		return err
+	}
+
+	mappings, err := loadSourceMappings()
+	if err != nil {
+		return err
+	}
+	paths := substitutePaths(mappings, []string{binary})
+	if err := writeDlvFiles(paths); err != nil {
+		return err
+	}
+	if err := checkBreakpointFiles(binary, mappings, paths); err != nil {
+		return err
+	}
+
+	dlv, err := exec.LookPath("dlv")
+	if err != nil {
+		return fmt.Errorf("dlv not found in PATH, install it with go install github.com/go-delve/delve/cmd/dlv@latest")
+	}
+	args := []string{"exec", binary, "--init", dlvInitFile()}
+	if len(programArgs) > 0 {
+		args = append(append(args, "--"), programArgs...)
+	}
+	if dapAddr != "" {
+		args = []string{"dap", "--listen", dapAddr}
+		launch := map[string]interface{}{
+			"request":        "launch",
+			"mode":           "exec",
+			"program":        binary,
+			"substitutePath": clientSubstitutePaths(paths),
+		}
+		if len(programArgs) > 0 {
+			launch["args"] = programArgs
+		}
+		data, err := json.MarshalIndent(launch, "", "  ")
+		if err != nil {
+			return err
+		}
+		report.Printf("\nConnect a DAP client to %s with the launch arguments:\n%s\n", dapAddr, data)
+	}
+
+	report.Printf("\n🐞 Running: dlv %s\n\n", strings.Join(args, " "))
+	cmd := exec.Command(dlv, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// dlv handles interrupts, halting the program, while hc waits for it
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("dlv: %w", err)
+	}
+	return nil
+}
+
+// debugTarget returns the binary the modified build log of the current run puts in place, which
+// must be the only one and have been built since built
+func debugTarget(built time.Time) (string, error) {
+	parser := parse.NewParser()
+	parser.SetOutput(report.Log.Output())
+	parser.SetLogger(report.Log)
+	if err := parser.ParseFile(GetMetadataPath(BuildModifiedLogFile)); err != nil {
+		return "", fmt.Errorf("failed to parse %s: %w", GetMetadataPath(BuildModifiedLogFile), err)
+	}
+	binaries := binaryPaths(parser.GetCommands())
+	switch len(binaries) {
+	case 0:
+		return "", fmt.Errorf("--debug: the build log puts no binary in place to debug")
+	case 1:
+	default:
+		return "", fmt.Errorf("--debug: the build puts %d binaries in place (%s), build one, e.g. with --build-args ./cmd/<name>", len(binaries), strings.Join(binaries, ", "))
+	}
+	info, err := os.Stat(binaries[0])
+	if err != nil || info.ModTime().Before(built) {
+		return "", fmt.Errorf("--debug: the instrumented build didn't produce %s", binaries[0])
+	}
+	return binaries[0], nil
+}
+
+// checkBreakpointFiles checks that the substitute-path rules map the files of the debug
+// information of a binary to existing files, so that dlv shows them and resolves breakpoints set
+// in them. The debug copies of instrumented files must exist; generated files, which have no
+// debug copies, are reported.
+func checkBreakpointFiles(binary string, mappings *SourceMappings, paths []substitutePath) error {
+	files, err := binarySourceFiles(binary)
+	if err != nil {
+		return err
+	}
+	instrumented := make(map[string]bool)
+	for _, mapping := range mappings.Mappings {
+		instrumented[workFileKey(mapping.Instrumented)] = true
+	}
+
+	var missing, generated []string
+	resolved := 0
+	for _, file := range files {
+		local, ok := substitute(file, paths)
+		if !ok {
+			continue
+		}
+		if _, err := os.Stat(local); err == nil {
+			resolved++
+		} else if instrumented[workFileKey(file)] {
+			missing = append(missing, local)
+		} else {
+			generated = append(generated, file)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("the debug copies of %d instrumented files of %s are missing (%s), write them again with hc source-mappings while the WORK directory exists",
+			len(missing), binary, strings.Join(missing, ", "))
+	}
+	report.Printf("🔎 %d instrumented files of %s resolve to their debug copies\n", resolved, filepath.Base(binary))
+	if len(generated) > 0 {
+		report.Warnf("%d generated files of the binary have no debug copies, dlv can't show them: %s\n", len(generated), strings.Join(generated, ", "))
+	}
+	return nil
+}
+
+// substitute returns a file of the debug information of a binary as dlv finds it with the
+// substitute-path rules, and whether a rule applies to it
+func substitute(file string, paths []substitutePath) (string, bool) {
+	for _, path := range paths {
+		from := filepath.ToSlash(path.From)
+		if strings.HasPrefix(file, from+"/") {
+			return filepath.Join(path.To, filepath.FromSlash(strings.TrimPrefix(file, from+"/"))), true
+		}
+	}
+	return file, false
+}
+
+// binarySourceFiles returns the source files the line tables of a binary refer to, sorted
+func binarySourceFiles(binary string) ([]string, error) {
+	data, err := binaryDWARF(binary)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the debug information of %s (built with -ldflags=-w?): %w", binary, err)
+	}
+	seen := make(map[string]bool)
+	reader := data.Reader()
+	for {
+		entry, err := reader.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the debug information of %s: %w", binary, err)
+		}
+		if entry == nil {
+			break
+		}
+		if entry.Tag != dwarf.TagCompileUnit {
+			reader.SkipChildren()
+			continue
+		}
+		lines, err := data.LineReader(entry)
+		if err == nil && lines != nil {
+			for _, file := range lines.Files() {
+				// Skips the placeholders of the line tables, such as <autogenerated>
+				if file != nil && strings.Contains(file.Name, "/") {
+					seen[file.Name] = true
+				}
+			}
+		}
+		reader.SkipChildren()
+	}
+	files := make([]string, 0, len(seen))
+	for file := range seen {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// binaryDWARF returns the DWARF debug information of an ELF, Mach-O or PE executable
+func binaryDWARF(binary string) (*dwarf.Data, error) {
+	if f, err := elf.Open(binary); err == nil {
+		defer f.Close()
+		return f.DWARF()
+	}
+	if f, err := macho.Open(binary); err == nil {
+		defer f.Close()
+		return f.DWARF()
+	}
+	if f, err := pe.Open(binary); err == nil {
+		defer f.Close()
+		return f.DWARF()
+	}
+	return nil, fmt.Errorf("not an ELF, Mach-O or PE executable")
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// importcfgHeredocPattern matches the first line of the heredoc writing the compile importcfg
+// of a package, e.g. "cat >$WORK/b042/importcfg << 'EOF'" (newer toolchains write the
+// expanded work directory), capturing the build ID. The link importcfg doesn't match.
+var importcfgHeredocPattern = regexp.MustCompile(`^cat >\S*/(b\d+)/importcfg\s+<<`)
+
+// importcfgEchoPattern matches the command writing the compile importcfg of a package that
+// imports nothing, e.g. "echo '# import config' > $WORK/b042/importcfg # internal", capturing
+// the importcfg and the build ID
+var importcfgEchoPattern = regexp.MustCompile(`^echo '# import config' > (\S*/(b\d+)/importcfg)`)
+
+// importcfgBuildID returns the build ID of the package whose compile importcfg a heredoc
+// command writes, or "" for other commands
+func importcfgBuildID(cmd *parse.Command) string {
+	if !cmd.IsMultiline {
+		return ""
+	}
+	firstLine, _, _ := strings.Cut(cmd.Raw, "\n")
+	if m := importcfgHeredocPattern.FindStringSubmatch(firstLine); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+// runtimeDependencies returns the runtime package and the packages it depends on, read from
+// the importcfg heredocs of a build log. Every package depends on them implicitly, the hooks
+// library included, so their functions can't call hooks through trampolines: importing the
+// hooks library from them would be an import cycle.
+func runtimeDependencies(commands []parse.Command) map[string]bool {
+	buildIDs := make(map[string]string) // Import path -> build ID
+	for _, cmd := range commands {
+		if !parse.IsCompileCommand(&cmd) {
+			continue
+		}
+		if buildID := extractBuildID(parse.ExtractOutputPath(&cmd)); buildID != "" {
+			buildIDs[parse.ExtractPackageName(&cmd)] = buildID
+		}
+	}
+	imports := make(map[string][]string) // Build ID -> imported packages
+	for _, cmd := range commands {
+		if buildID := importcfgBuildID(&cmd); buildID != "" && cmd.Heredoc != nil {
+			imports[buildID] = cmd.Importcfg().Imports()
+		}
+	}
+
+	deps := map[string]bool{"runtime": true, "unsafe": true}
+	queue := []string{"runtime"}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		for _, dep := range imports[buildIDs[pkg]] {
+			if !deps[dep] {
+				deps[dep] = true
+				queue = append(queue, dep)
+			}
+		}
+	}
+	return deps
+}
+
+// dependencyKind describes where the package compiled by a command comes from: "standard
+// library", "dependency" for packages of other modules, whose sources are outside the
+// directory hc runs in, or "" for packages of the local module
+func dependencyKind(cmd *parse.Command) string {
+	if parse.IsStdlibCompileCommand(cmd) {
+		return "standard library"
+	}
+	for _, file := range parse.ExtractPackFiles(cmd) {
+		if filepath.IsAbs(file) {
+			if rel, err := filepath.Rel(".", file); err != nil || strings.HasPrefix(rel, "..") {
+				return "dependency"
+			}
+		}
+	}
+	return ""
+}
+
+// packageHooks returns the hooks applicable to the functions of a package compiling files,
+// with those targeting the import path of a main package resolved to it. Packages the runtime
+// depends on only get rewrite hooks; the Before/After part of other hooks is dropped with a
+// warning (once per package, tracked in warned).
+func packageHooks(packageName, importPath string, files []string, hooks []instrument.HookDefinition, runtimeDeps map[string]bool, warned map[string]bool) []instrument.HookDefinition {
+	hooks = instrument.ResolveMainPackage(hooks, packageName, importPath)
+	if !runtimeDeps[packageName] {
+		return hooks
+	}
+	var applicable []instrument.HookDefinition
+	var dropped []string
+	for _, hook := range hooks {
+		if !instrument.MatchesPackage(hook, packageName) || !instrument.MatchesFiles(hook, files) {
+			applicable = append(applicable, hook)
+			continue
+		}
+		switch hook.Type {
+		case "before_after":
+			dropped = append(dropped, instrument.HookTarget(hook))
+			continue
+		case "both":
+			dropped = append(dropped, instrument.HookTarget(hook))
+			hook.Type = "rewrite"
+		}
+		applicable = append(applicable, hook)
+	}
+	if len(dropped) > 0 && !warned[packageName] {
+		warned[packageName] = true
+		report.Warnf("%s is imported by the runtime, so Before/After hooks can't be called from it (only Rewrite hooks apply): %s\n",
+			packageName, strings.Join(dropped, ", "))
+	}
+	return applicable
+}
+
+// addHooksLibraryToImportcfg adds the hooks library to an importcfg heredoc of a package
+// with trampolines other than main, which gets the hooks packages too. Trampolines of
+// standard library and dependency packages import the hooks library like those of the
+// local module. The importcfg of a package importing nothing is written with echo instead,
+// and becomes a heredoc listing the hooks library.
+func addHooksLibraryToImportcfg(cmd *parse.Command, command string, trampolineFiles map[string]string, mainBuildID, workDir string) string {
+	buildID := importcfgBuildID(cmd)
+	echo := importcfgEchoPattern.FindStringSubmatch(command)
+	if echo != nil && !cmd.IsMultiline {
+		buildID = echo[2]
+	}
+	if buildID == "" || buildID == mainBuildID {
+		return command
+	}
+	for packageName, trampolinesFile := range trampolineFiles {
+		if filepath.Base(filepath.Dir(trampolinesFile)) != buildID {
+			continue
+		}
+		hooksLibPkgFile := filepath.Join(workDir, "hooks_lib", "_pkg_.a")
+		report.Debugf("Added hooks library to importcfg of package '%s'\n", packageName)
+		if echo != nil && !cmd.IsMultiline {
+			importcfg := parse.NewImportcfg()
+			importcfg.SetPackageFile(HooksLibraryImportPath, hooksLibPkgFile)
+			return parse.NewHeredocCommand(echo[1], importcfg.String()).Raw
+		}
+		importcfg := cmd.Importcfg()
+		if importcfg == nil {
+			return command
+		}
+		importcfg.SetPackageFile(HooksLibraryImportPath, hooksLibPkgFile)
+		return cmd.WithImportcfg(importcfg).Raw
+	}
+	return command
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// diffContextLines is the number of unchanged lines shown around each change
+const diffContextLines = 3
+
+// diffOp is a single line-level edit: ' ' (keep), '-' (delete) or '+' (insert)
+type diffOp struct {
+	Kind byte
+	Line string
+}
+
+// unifiedDiff returns a unified diff between two texts, or an empty string if they are equal
+func unifiedDiff(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("--- %s\n+++ %s\n", oldName, newName))
+
+	// Group changes into hunks with surrounding context
+	for start := 0; start < len(ops); {
+		// Find next change
+		first := start
+		for first < len(ops) && ops[first].Kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+
+		// Extend the hunk while changes are within 2*context of each other
+		last := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].Kind != ' ' {
+				last = i
+			} else if i-last > 2*diffContextLines {
+				break
+			}
+		}
+
+		hunkStart := max(first-diffContextLines, start)
+		hunkEnd := min(last+diffContextLines+1, len(ops))
+
+		// Line numbers at the hunk start
+		oldLine, newLine := 1, 1
+		for _, op := range ops[:hunkStart] {
+			if op.Kind != '+' {
+				oldLine++
+			}
+			if op.Kind != '-' {
+				newLine++
+			}
+		}
+		oldCount, newCount := 0, 0
+		for _, op := range ops[hunkStart:hunkEnd] {
+			if op.Kind != '+' {
+				oldCount++
+			}
+			if op.Kind != '-' {
+				newCount++
+			}
+		}
+
+		sb.WriteString(fmt.Sprintf("@@ -%d,%d +%d,%d @@\n", oldLine, oldCount, newLine, newCount))
+		for _, op := range ops[hunkStart:hunkEnd] {
+			sb.WriteByte(op.Kind)
+			sb.WriteString(op.Line)
+			sb.WriteByte('\n')
+		}
+
+		start = hunkEnd
+	}
+
+	return sb.String()
+}
+
+// splitLines splits text into lines without their trailing newlines
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes a shortest edit script between two line slices using Myers' algorithm
+func diffLines(a, b []string) []diffOp {
+	n, m := len(a), len(b)
+	maxD := n + m
+	offset := maxD + 1
+	v := make([]int, 2*maxD+3)
+	var trace [][]int
+
+	// Forward pass: record the furthest reaching x on each diagonal for every edit distance d
+	found := false
+	for d := 0; d <= maxD && !found; d++ {
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break
+			}
+		}
+		snapshot := make([]int, len(v))
+		copy(snapshot, v)
+		trace = append(trace, snapshot)
+	}
+
+	// Backtrack from the end to recover the edit script
+	var ops []diffOp
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d-1]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && prev[offset+k-1] < prev[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := prev[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, diffOp{Kind: ' ', Line: a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, diffOp{Kind: '+', Line: b[y]})
+		} else {
+			x--
+			ops = append(ops, diffOp{Kind: '-', Line: a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, diffOp{Kind: ' ', Line: a[x]})
+	}
+
+	// Reverse into forward order
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// VSCodeLaunchFile is the launch configuration file of VS Code, relative to the project
+const VSCodeLaunchFile = ".vscode/launch.json"
+
+// vscodeConfigPrefix starts the names of the launch configurations --dlv-config --vscode writes
+const vscodeConfigPrefix = "hc: "
+
+// substitutePath maps a directory recorded in the debug information of an instrumented binary,
+// a WORK directory of the build, to the directory of the debug copies of its files
+type substitutePath struct {
+	From string // Directory of the instrumented files in the binary
+	To   string // Directory of their debug copies
+}
+
+// loadSourceMappings reads the source mappings of the current run, or else of the latest one.
+// Without any, they are generated from the build logs first.
+func loadSourceMappings() (*SourceMappings, error) {
+	data, err := os.ReadFile(GetMetadataPath(SourceMappingsFile))
+	if err != nil {
+		data, err = os.ReadFile(filepath.Join(MetadataDir, SourceMappingsFile))
+	}
+	if err != nil {
+		report.Printf("No %s yet, generating it\n", SourceMappingsFile)
+		if err := generateSourceMappingsFromExisting(); err != nil {
+			return nil, err
+		}
+		if data, err = os.ReadFile(GetMetadataPath(SourceMappingsFile)); err != nil {
+			return nil, err
+		}
+	}
+	var mappings SourceMappings
+	if err := json.Unmarshal(data, &mappings); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", SourceMappingsFile, err)
+	}
+	return &mappings, nil
+}
+
+// substitutePaths returns the rules mapping the directories of the instrumented files in the
+// binaries to those of their debug copies, one per directory, sorted. Packages restored from
+// the build cache keep the WORK directory they were compiled in first, so the directories the
+// debug information of existing binaries names for an instrumented file, by its action
+// directory and name, are mapped as well.
+func substitutePaths(mappings *SourceMappings, binaries []string) []substitutePath {
+	seen := make(map[substitutePath]bool)
+	var paths []substitutePath
+	add := func(from, debugCopy string) {
+		path := substitutePath{From: filepath.Dir(from), To: filepath.Dir(debugCopy)}
+		if !seen[path] {
+			seen[path] = true
+			paths = append(paths, path)
+		}
+	}
+	debugCopies := make(map[string]string)
+	originals := make(map[string]bool)
+	for _, mapping := range mappings.Mappings {
+		if mapping.Instrumented == "" || mapping.DebugCopy == "" {
+			continue
+		}
+		add(mapping.Instrumented, mapping.DebugCopy)
+		debugCopies[workFileKey(mapping.Instrumented)] = mapping.DebugCopy
+		originals[filepath.ToSlash(mapping.Original)] = true
+	}
+	for _, binary := range binaries {
+		files, err := binarySourceFiles(binary)
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if debugCopy, ok := debugCopies[workFileKey(file)]; ok && !originals[file] {
+				add(filepath.FromSlash(file), debugCopy)
+			}
+		}
+	}
+	sort.Slice(paths, func(i, j int) bool { return paths[i].From < paths[j].From })
+	return paths
+}
+
+// workFileKey identifies a file of the WORK directory by its action directory and name, e.g.
+// b002/handlers.go
+func workFileKey(file string) string {
+	file = filepath.ToSlash(file)
+	dir, name := path.Split(file)
+	return path.Base(strings.TrimSuffix(dir, "/")) + "/" + name
+}
+
+// writeDebuggerConfig writes the substitute-path rules of the source mappings as dlv commands
+// for dlv --init (DlvInitFile) and as the substitute-path setting of the dlv configuration file
+// (DlvConfigFile). With vscode, it also adds a launch configuration per binary of the build log
+// to .vscode/launch.json.
+func writeDebuggerConfig(commands []parse.Command, vscode bool) error {
+	mappings, err := loadSourceMappings()
+	if err != nil {
+		return err
+	}
+	binaries := binaryPaths(commands)
+	paths := substitutePaths(mappings, binaries)
+	if err := writeDlvFiles(paths); err != nil {
+		return err
+	}
+
+	report.Println()
+	if len(binaries) == 0 {
+		report.Printf("Debug with: dlv exec <binary> --init %s\n", dlvInitFile())
+	}
+	for _, binary := range binaries {
+		report.Printf("Debug with: dlv exec %s --init %s\n", binary, dlvInitFile())
+	}
+
+	if !vscode {
+		return nil
+	}
+	if len(binaries) == 0 {
+		return fmt.Errorf("--vscode: the build log puts no binary in place to launch")
+	}
+	if err := addVSCodeLaunchConfigs(VSCodeLaunchFile, binaries, paths); err != nil {
+		return err
+	}
+	report.Printf("📄 VS Code launch configurations: %s\n", VSCodeLaunchFile)
+	return nil
+}
+
+// dlvInitFile returns the DlvInitFile of the metadata directory, linked to the one of the
+// newest run
+func dlvInitFile() string {
+	return filepath.Join(MetadataDir, DlvInitFile)
+}
+
+// writeDlvFiles writes the substitute-path rules to DlvInitFile and DlvConfigFile
+func writeDlvFiles(paths []substitutePath) error {
+	if len(paths) == 0 {
+		report.Warnf("%s maps no instrumented files, the debugger needs no substitute-path rules\n", SourceMappingsFile)
+	}
+
+	var init, config strings.Builder
+	init.WriteString("# Generated by hc --dlv-config: dlv exec <binary> --init " + dlvInitFile() + "\n")
+	config.WriteString("# Generated by hc --dlv-config: add to the dlv configuration file (dlv config -list)\n")
+	config.WriteString("substitute-path:\n")
+	for _, path := range paths {
+		fmt.Fprintf(&init, "config substitute-path %s %s\n", dlvArg(path.From), dlvArg(path.To))
+		fmt.Fprintf(&config, "  - {from: %s, to: %s}\n", strconv.Quote(path.From), strconv.Quote(path.To))
+	}
+
+	if err := EnsureMetadataDir(); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+	for _, file := range []struct{ name, content string }{{DlvInitFile, init.String()}, {DlvConfigFile, config.String()}} {
+		if err := os.WriteFile(GetMetadataPath(file.name), []byte(file.content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", GetMetadataPath(file.name), err)
+		}
+		linkRunFile(file.name)
+	}
+	report.Printf("📄 dlv commands: %s\n", dlvInitFile())
+	report.Printf("📄 dlv configuration: %s\n", filepath.Join(MetadataDir, DlvConfigFile))
+	for _, path := range paths {
+		report.Printf("📍 %s -> %s\n", path.From, path.To)
+	}
+	return nil
+}
+
+// binaryPaths returns the absolute paths of the binaries a build log puts in place
+func binaryPaths(commands []parse.Command) []string {
+	var binaries []string
+	for _, output := range parse.FindBinaryOutputs(commands) {
+		path := output.Path()
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		binaries = append(binaries, path)
+	}
+	return binaries
+}
+
+// addVSCodeLaunchConfigs adds a configuration launching each binary with the Go extension to a
+// VS Code launch.json, replacing the configurations an earlier run added for it
+func addVSCodeLaunchConfigs(launchFile string, binaries []string, paths []substitutePath) error {
+	launch := map[string]interface{}{"version": "0.2.0"}
+	if data, err := os.ReadFile(launchFile); err == nil {
+		if err := json.Unmarshal(data, &launch); err != nil {
+			return fmt.Errorf("failed to parse %s, remove its comments and trailing commas or add the configuration by hand: %w", launchFile, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	existing, _ := launch["configurations"].([]interface{})
+
+	substitute := clientSubstitutePaths(paths)
+	names := make(map[string]bool)
+	var added []interface{}
+	for _, binary := range binaries {
+		name := vscodeConfigPrefix + filepath.Base(binary)
+		names[name] = true
+		added = append(added, map[string]interface{}{
+			"name":           name,
+			"type":           "go",
+			"request":        "launch",
+			"mode":           "exec",
+			"program":        binary,
+			"substitutePath": substitute,
+		})
+	}
+	var configurations []interface{}
+	for _, config := range existing {
+		if entry, ok := config.(map[string]interface{}); ok && names[fmt.Sprint(entry["name"])] {
+			continue
+		}
+		configurations = append(configurations, config)
+	}
+	launch["configurations"] = append(configurations, added...)
+
+	data, err := json.MarshalIndent(launch, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(launchFile), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(launchFile, append(data, '\n'), 0644)
+}
+
+// clientSubstitutePaths returns the rules as the substitutePath of DAP launch requests to
+// dlv, which map the paths of the client to those of the binary, the reverse of dlv's own
+func clientSubstitutePaths(paths []substitutePath) []map[string]string {
+	substitute := []map[string]string{}
+	for _, path := range paths {
+		substitute = append(substitute, map[string]string{"from": path.To, "to": path.From})
+	}
+	return substitute
+}
+
+// dlvArg quotes an argument of a dlv command if it contains spaces. dlv splits the arguments
+// of config at spaces outside double quotes and doesn't unescape them.
+func dlvArg(arg string) string {
+	if strings.ContainsAny(arg, " \t") {
+		return `"` + arg + `"`
+	}
+	return arg
+}
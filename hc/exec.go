@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Environment of the go wrapper --exec puts first on PATH
+const (
+	ExecCaptureEnv = "HC_EXEC_CAPTURE" // Directory the wrapper writes the script of every go build to
+	ExecGoEnv      = "HC_EXEC_GO"      // go command the wrapper runs
+)
+
+// ExecCaptureDir is the directory of build-metadata the go wrapper writes to, one file per
+// go build or go install it ran
+const ExecCaptureDir = "exec-capture"
+
+// execCapturedCommands are the go subcommands whose script the go wrapper captures
+var execCapturedCommands = map[string]bool{"build": true, "install": true}
+
+// isGoWrapper reports whether hc runs as the go wrapper of --exec: the go command found
+// first on PATH by the commands --exec runs is a link to hc
+func isGoWrapper() bool {
+	return filepath.Base(os.Args[0]) == "go" && os.Getenv(ExecCaptureEnv) != ""
+}
+
+// runExecCapture runs a command building with go, e.g. make build, and captures the go build
+// commands it runs to build-metadata/go-build.log. The command finds a go wrapper first on
+// PATH, which adds -x -work -a to go build and go install, so builds are captured whatever
+// flags and environment the command gives them. go invoked by absolute path is not captured.
+func runExecCapture(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("--exec needs the command to run, e.g. hc --exec -- make build")
+	}
+	if err := EnsureMetadataDir(); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+
+	goPath, err := exec.LookPath(goBinary)
+	if err != nil {
+		return fmt.Errorf("go command %q not found: %w", goBinary, err)
+	}
+	if goPath, err = filepath.Abs(goPath); err != nil {
+		return err
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate hc: %w", err)
+	}
+
+	// Scripts of a previous run must not end up in this build log
+	captureDir, err := filepath.Abs(GetMetadataPath(ExecCaptureDir))
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(captureDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(captureDir, 0755); err != nil {
+		return err
+	}
+
+	wrapperDir, err := os.MkdirTemp("", "hc-exec")
+	if err != nil {
+		return fmt.Errorf("failed to create the go wrapper directory: %w", err)
+	}
+	defer os.RemoveAll(wrapperDir)
+	if err := os.Symlink(self, filepath.Join(wrapperDir, "go")); err != nil {
+		return fmt.Errorf("failed to create the go wrapper: %w", err)
+	}
+
+	report.Printf("Running: %s (go build captured through %s)\n", strings.Join(args, " "), goPath)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(),
+		"PATH="+wrapperDir+string(os.PathListSeparator)+os.Getenv("PATH"),
+		ExecCaptureEnv+"="+captureDir,
+		ExecGoEnv+"="+goPath,
+	)
+
+	start := time.Now()
+	runErr := cmd.Run()
+	recordPhase(PhaseCapture, start, runErr)
+	saveBuildProfile()
+	if runErr != nil {
+		report.Warnf("%s exited with error: %v\n", args[0], runErr)
+	}
+
+	scripts, err := mergeExecCapture(captureDir, GetMetadataPath(BuildLogFile))
+	if err != nil {
+		return err
+	}
+	if scripts == 0 {
+		return fmt.Errorf("%s ran no go build or go install through the go wrapper (is go invoked by absolute path?)", args[0])
+	}
+	if err := recordToolchain(); err != nil {
+		report.Warnf("failed to record the toolchain: %v\n", err)
+	}
+	if err := recordCaptureMetadata(CaptureExec, nil); err != nil {
+		report.Warnf("failed to record the capture metadata: %v\n", err)
+	}
+	recordHeredocs(GetMetadataPath(BuildLogFile), HeredocsDir)
+	report.Printf("Captured %d go build invocation(s) to %s\n", scripts, GetMetadataPath(BuildLogFile))
+	return nil
+}
+
+// mergeExecCapture concatenates the scripts written by the go wrapper into the build log, in
+// the order the builds started, and returns how many there were
+func mergeExecCapture(captureDir, logPath string) (int, error) {
+	entries, err := os.ReadDir(captureDir)
+	if err != nil {
+		return 0, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), ".log") {
+			names = append(names, entry.Name())
+		}
+	}
+	if len(names) == 0 {
+		return 0, nil
+	}
+	sort.Strings(names)
+
+	logFile, err := os.Create(logPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create %s: %w", logPath, err)
+	}
+	defer logFile.Close()
+	for _, name := range names {
+		data, err := os.ReadFile(filepath.Join(captureDir, name))
+		if err != nil {
+			return 0, err
+		}
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		if _, err := logFile.Write(data); err != nil {
+			return 0, fmt.Errorf("failed to write %s: %w", logPath, err)
+		}
+	}
+	return len(names), nil
+}
+
+// runGoWrapper runs the real go command with args as the go wrapper of --exec and returns its
+// exit code. go build and go install also get -x -work -a, and their standard error, which
+// carries the script, is written to a file of the capture directory instead of the terminal.
+// The environment is kept, so the go commands go generate or go run start use the wrapper too.
+func runGoWrapper(args []string) int {
+	goPath := os.Getenv(ExecGoEnv)
+	cmd := exec.Command(goPath, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	var scriptPath string
+	if len(args) > 0 && execCapturedCommands[args[0]] {
+		cmd.Args = append([]string{goPath}, captureArgs(args)...)
+		// Named after the start time so the build log keeps the order of the builds
+		scriptPath = filepath.Join(os.Getenv(ExecCaptureEnv), fmt.Sprintf("%020d-%d.log", time.Now().UnixNano(), os.Getpid()))
+		script, err := os.Create(scriptPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "hc: failed to capture go %s: %v\n", args[0], err)
+		} else {
+			defer script.Close()
+			cmd.Stderr = script
+		}
+	}
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			if scriptPath != "" {
+				fmt.Fprintf(os.Stderr, "hc: go %s failed, its output is in %s\n", args[0], scriptPath)
+			}
+			return exitErr.ExitCode()
+		}
+		fmt.Fprintf(os.Stderr, "hc: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// captureArgs adds -x -work -a to the arguments of go build or go install, after -C which
+// must come first
+func captureArgs(args []string) []string {
+	insert := 1
+	if len(args) > 1 && strings.HasPrefix(args[1], "-C=") {
+		insert = 2
+	} else if len(args) > 2 && args[1] == "-C" {
+		insert = 3
+	}
+	captured := append([]string{}, args[:insert]...)
+	captured = append(captured, "-x", "-work", "-a")
+	return append(captured, args[insert:]...)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// replayInFreshWork moves the WORK directories of the parsed build log into a new directory
+// (--fresh-work). Files of the old directories the log reads but doesn't write, such as the
+// instrumented sources and hooks archives hc --compile put there, are copied from the old
+// directories while they exist, or else from the debug copies of source-mappings.json.
+func replayInFreshWork(parser *parse.Parser, workDir string) {
+	rewrites := parser.RewriteWorkDirs(workDir)
+	for _, rewrite := range rewrites {
+		report.Printf("Replaying %s in %s\n", rewrite.From, rewrite.To)
+	}
+	if len(rewrites) == 0 {
+		return
+	}
+
+	// The mappings of the run replayed with --run-id, or else of the latest run
+	debugCopies := make(map[string]string)
+	data, err := os.ReadFile(GetMetadataPath(SourceMappingsFile))
+	if err != nil {
+		data, err = os.ReadFile(filepath.Join(MetadataDir, SourceMappingsFile))
+	}
+	if err == nil {
+		var mappings SourceMappings
+		if json.Unmarshal(data, &mappings) == nil {
+			for _, mapping := range mappings.Mappings {
+				debugCopies[mapping.Instrumented] = mapping.DebugCopy
+			}
+		}
+	}
+
+	// Files the build log writes itself, with $WORK expanded
+	written := make(map[string]bool)
+	work := workDir
+	var lines []string
+	for _, cmd := range parser.GetCommands() {
+		line := cmd.String()
+		lines = append(lines, line)
+		if name, value, ok := strings.Cut(strings.TrimSpace(line), "="); ok && name == "WORK" && !cmd.IsMultiline {
+			work = value
+			continue
+		}
+		if cmd.Heredoc != nil {
+			written[strings.ReplaceAll(cmd.Heredoc.Target, "$WORK", work)] = true
+		}
+		if output := parse.ExtractOutputPath(&cmd); output != "" {
+			written[strings.ReplaceAll(output, "$WORK", work)] = true
+		}
+	}
+	text := strings.Join(lines, "\n")
+
+	restored, missing := 0, 0
+	for _, rewrite := range rewrites {
+		pattern := regexp.MustCompile(regexp.QuoteMeta(rewrite.To) + `/[^\s"'=:;,]+`)
+		seen := make(map[string]bool)
+		for _, path := range pattern.FindAllString(text, -1) {
+			path = strings.TrimSuffix(path, "/")
+			if seen[path] || written[path] {
+				continue
+			}
+			seen[path] = true
+			old := rewrite.From + strings.TrimPrefix(path, rewrite.To)
+			source := old
+			if !freshWorkFileExists(source) {
+				source = debugCopies[old]
+			}
+			if source == "" || !freshWorkFileExists(source) {
+				if _, err := os.Stat(old); err == nil {
+					continue // A directory
+				}
+				report.Debugf("No copy of %s\n", old)
+				missing++
+				continue
+			}
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				report.Warnf("Failed to restore %s: %v\n", path, err)
+				continue
+			}
+			if err := copyRunFile(source, path); err != nil {
+				report.Warnf("Failed to restore %s: %v\n", path, err)
+				continue
+			}
+			// Import configurations list archives by their path in the old directories
+			if strings.HasPrefix(filepath.Base(path), "importcfg") {
+				if err := rewriteWorkPaths(path, rewrites); err != nil {
+					report.Warnf("Failed to restore %s: %v\n", path, err)
+					continue
+				}
+			}
+			restored++
+		}
+	}
+	if restored > 0 {
+		report.Printf("Restored %d files hc --compile wrote to the old WORK directories\n", restored)
+	}
+	if missing > 0 {
+		report.Warnf("%d files the build log reads from the old WORK directories are gone (--log-level=debug lists them); run hc --compile again if the replay fails\n", missing)
+	}
+	report.Println()
+}
+
+// rewriteWorkPaths replaces the old WORK directories in a file with their new path
+func rewriteWorkPaths(path string, rewrites []parse.WorkDirRewrite) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	content := string(data)
+	for _, rewrite := range rewrites {
+		content = strings.ReplaceAll(content, rewrite.From+"/", rewrite.To+"/")
+	}
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+// freshWorkFileExists reports whether path is a regular file
+func freshWorkFileExists(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
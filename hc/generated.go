@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"go/build/constraint"
+	"go/parser"
+	"go/token"
+	"strings"
+)
+
+// generatedMarker marks the files hc writes as generated, in the form tools recognize
+// (https://go.dev/s/generatedcode): linters, coverage and code review tools skip them
+const generatedMarker = "// Code generated by go-build-interceptor. DO NOT EDIT."
+
+// GeneratedBuildTag is the build tag the files hc writes are constrained to. Compile commands
+// list their files, so instrumented builds compile them; tools loading packages with go/build
+// or go/packages, such as gopls on the debug copies, leave them out unless the tag is set.
+const GeneratedBuildTag = "go_build_interceptor"
+
+// generatedVersionPrefix starts the header line recording the hc that wrote a file
+const generatedVersionPrefix = "// go-build-interceptor version: "
+
+// markGenerated prefixes the content of a Go file hc writes with the generated code marker,
+// the version of hc and a //go:build line constraining the file to GeneratedBuildTag. The
+// constraint of an instrumented file is combined with the tag, replacing its //go:build and
+// // +build lines. Files already marked are returned as they are.
+func markGenerated(content []byte) []byte {
+	if bytes.HasPrefix(content, []byte(generatedMarker+"\n")) {
+		return content
+	}
+
+	expr := constraint.Expr(&constraint.TagExpr{Tag: GeneratedBuildTag})
+	body := content
+	fset := token.NewFileSet()
+	if file, err := parser.ParseFile(fset, "", content, parser.PackageClauseOnly|parser.ParseComments); err == nil {
+		// Build constraints are line comments before the package clause
+		header := content[:fset.Position(file.Package).Offset]
+		var kept []string
+		var goBuild constraint.Expr
+		var plusBuild []constraint.Expr
+		for _, line := range strings.SplitAfter(string(header), "\n") {
+			text := strings.TrimSpace(line)
+			if constraint.IsGoBuild(text) || constraint.IsPlusBuild(text) {
+				if fileExpr, err := constraint.Parse(text); err == nil {
+					if constraint.IsGoBuild(text) {
+						goBuild = fileExpr
+					} else {
+						plusBuild = append(plusBuild, fileExpr)
+					}
+					continue
+				}
+			}
+			kept = append(kept, line)
+		}
+		// As for the go command, // +build lines only count in files without //go:build
+		if goBuild != nil {
+			expr = &constraint.AndExpr{X: expr, Y: goBuild}
+		} else {
+			for _, fileExpr := range plusBuild {
+				expr = &constraint.AndExpr{X: expr, Y: fileExpr}
+			}
+		}
+		body = append([]byte(strings.Join(kept, "")), content[len(header):]...)
+	}
+
+	var b bytes.Buffer
+	b.WriteString(generatedMarker + "\n")
+	b.WriteString(generatedVersionPrefix + hcVersion() + "\n\n")
+	b.WriteString("//go:build " + expr.String() + "\n\n")
+	b.Write(body)
+	return b.Bytes()
+}
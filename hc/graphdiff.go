@@ -0,0 +1,201 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+)
+
+// CallGraphDiff is the --callgraph-diff result: how the call graph changed from Old to New.
+// Edges are compared by caller and callee; an edge whose call lines moved is unchanged.
+type CallGraphDiff struct {
+	Old          string                  `json:"old"`
+	New          string                  `json:"new"`
+	AddedNodes   []CallGraphDiffNode     `json:"addedNodes"`
+	RemovedNodes []CallGraphDiffNode     `json:"removedNodes"`
+	AddedEdges   []analyze.CallGraphEdge `json:"addedEdges"`
+	RemovedEdges []analyze.CallGraphEdge `json:"removedEdges"`
+	Hooks        []HookCoverage          `json:"hooks,omitempty"` // With hooks files, except hooks targeting files
+}
+
+// CallGraphDiffNode is a function added to or removed from the call graph
+type CallGraphDiffNode struct {
+	Name     string `json:"name"`
+	External bool   `json:"external"`
+	Hooked   bool   `json:"hooked,omitempty"` // A hook targets the function
+}
+
+// HookCoverage lists the functions of both call graphs a hook targets. A hook whose functions
+// all left the call graph no longer covers the path it was written for.
+type HookCoverage struct {
+	Target string   `json:"target"`
+	Old    []string `json:"old"`
+	New    []string `json:"new"`
+	Lost   bool     `json:"lost"` // The hook matched functions of the old call graph but none of the new
+}
+
+// loadCallGraph reads a call graph written by --callgraph --output=json
+func loadCallGraph(path string) (*CallGraphOutput, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cg CallGraphOutput
+	if err := json.Unmarshal(data, &cg); err != nil {
+		return nil, fmt.Errorf("%s is not a call graph written by --callgraph --output=json: %w", path, err)
+	}
+	return &cg, nil
+}
+
+// diffCallGraphs compares the call graphs in the files oldPath and newPath. With hooks, the
+// changed functions they target are marked and their coverage is compared.
+func diffCallGraphs(oldPath, newPath string, hooks []instrument.HookDefinition) (*CallGraphDiff, error) {
+	oldGraph, err := loadCallGraph(oldPath)
+	if err != nil {
+		return nil, err
+	}
+	newGraph, err := loadCallGraph(newPath)
+	if err != nil {
+		return nil, err
+	}
+
+	diff := &CallGraphDiff{
+		Old:          oldPath,
+		New:          newPath,
+		AddedNodes:   []CallGraphDiffNode{},
+		RemovedNodes: []CallGraphDiffNode{},
+		AddedEdges:   []analyze.CallGraphEdge{},
+		RemovedEdges: []analyze.CallGraphEdge{},
+	}
+	hooked := func(name string) bool { return len(hooks) > 0 && nodeHook(name, hooks) != nil }
+
+	oldNodes := make(map[string]bool)
+	for _, node := range oldGraph.Nodes {
+		oldNodes[node.Name] = true
+	}
+	newNodes := make(map[string]bool)
+	for _, node := range newGraph.Nodes {
+		newNodes[node.Name] = true
+		if !oldNodes[node.Name] {
+			diff.AddedNodes = append(diff.AddedNodes, CallGraphDiffNode{Name: node.Name, External: node.External, Hooked: hooked(node.Name)})
+		}
+	}
+	for _, node := range oldGraph.Nodes {
+		if !newNodes[node.Name] {
+			diff.RemovedNodes = append(diff.RemovedNodes, CallGraphDiffNode{Name: node.Name, External: node.External, Hooked: hooked(node.Name)})
+		}
+	}
+
+	edgeKey := func(e analyze.CallGraphEdge) string { return e.Caller + "\x00" + e.Callee }
+	oldEdges := make(map[string]bool)
+	for _, e := range oldGraph.Edges {
+		oldEdges[edgeKey(e)] = true
+	}
+	newEdges := make(map[string]bool)
+	for _, e := range newGraph.Edges {
+		newEdges[edgeKey(e)] = true
+		if !oldEdges[edgeKey(e)] {
+			diff.AddedEdges = append(diff.AddedEdges, e)
+		}
+	}
+	for _, e := range oldGraph.Edges {
+		if !newEdges[edgeKey(e)] {
+			diff.RemovedEdges = append(diff.RemovedEdges, e)
+		}
+	}
+
+	for _, hook := range hooks {
+		if hook.File != "" {
+			continue
+		}
+		coverage := HookCoverage{
+			Target: instrument.HookTarget(hook),
+			Old:    hookedNodes(oldGraph, hook),
+			New:    hookedNodes(newGraph, hook),
+		}
+		coverage.Lost = len(coverage.Old) > 0 && len(coverage.New) == 0
+		diff.Hooks = append(diff.Hooks, coverage)
+	}
+	return diff, nil
+}
+
+// nodeHook returns the hook instrumenting the function a call graph node names, if any.
+// Hooks targeting files never match, since call graphs don't record files.
+func nodeHook(name string, hooks []instrument.HookDefinition) *instrument.HookDefinition {
+	pkg, receiver, function := analyze.SplitNodeName(name)
+	return instrument.MatchFunctionWithHooks(pkg, &analyze.FunctionInfo{Name: function, Receiver: receiver}, hooks)
+}
+
+// hookedNodes returns the sorted names of the nodes of a call graph a hook targets
+func hookedNodes(cg *CallGraphOutput, hook instrument.HookDefinition) []string {
+	nodes := []string{}
+	for _, node := range cg.Nodes {
+		if nodeHook(node.Name, []instrument.HookDefinition{hook}) != nil {
+			nodes = append(nodes, node.Name)
+		}
+	}
+	sort.Strings(nodes)
+	return nodes
+}
+
+// printCallGraphDiff prints the functions and calls a call graph diff added and removed, then
+// the coverage of the hooks
+func printCallGraphDiff(diff *CallGraphDiff) {
+	report.Resultf("Call graph changes from %s to %s:\n", diff.Old, diff.New)
+	if len(diff.AddedNodes)+len(diff.RemovedNodes)+len(diff.AddedEdges)+len(diff.RemovedEdges) == 0 {
+		report.Resultln("  No changes")
+	}
+
+	printNodes := func(title, sign, style string, nodes []CallGraphDiffNode) {
+		if len(nodes) == 0 {
+			return
+		}
+		report.Resultf("\n%s (%d):\n", title, len(nodes))
+		for _, node := range nodes {
+			line := fmt.Sprintf("  %s %s", sign, node.Name)
+			if node.Hooked {
+				line += " [hooked]"
+			}
+			report.Resultln(report.paint(style, line))
+		}
+	}
+	printEdges := func(title, sign, style string, edges []analyze.CallGraphEdge) {
+		if len(edges) == 0 {
+			return
+		}
+		report.Resultf("\n%s (%d):\n", title, len(edges))
+		for _, e := range edges {
+			lines := make([]string, len(e.Lines))
+			for i, line := range e.Lines {
+				lines[i] = fmt.Sprint(line)
+			}
+			report.Resultln(report.paint(style, fmt.Sprintf("  %s %s -> %s (line %s)", sign, e.Caller, e.Callee, strings.Join(lines, ", "))))
+		}
+	}
+	printNodes("Added functions", "+", styleGreen, diff.AddedNodes)
+	printNodes("Removed functions", "-", styleRed, diff.RemovedNodes)
+	printEdges("Added calls", "+", styleGreen, diff.AddedEdges)
+	printEdges("Removed calls", "-", styleRed, diff.RemovedEdges)
+
+	if len(diff.Hooks) > 0 {
+		report.Resultln("\nHooks:")
+		for _, hook := range diff.Hooks {
+			switch {
+			case hook.Lost:
+				report.Resultln(report.paint(styleRed, fmt.Sprintf("  ✗ %s: matched %d function(s) before, none now", hook.Target, len(hook.Old))))
+			case len(hook.New) == 0:
+				report.Resultln(report.paint(styleYellow, fmt.Sprintf("  - %s: matches no function of either call graph", hook.Target)))
+			default:
+				report.Resultf("  ✓ %s: %d function(s) (%d before)\n", hook.Target, len(hook.New), len(hook.Old))
+			}
+		}
+	}
+
+	report.Printf("\nSummary: +%d/-%d functions, +%d/-%d calls\n",
+		len(diff.AddedNodes), len(diff.RemovedNodes), len(diff.AddedEdges), len(diff.RemovedEdges))
+}
@@ -3,16 +3,23 @@ package main
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"go/ast"
-	"go/format"
 	"go/parser"
 	"go/token"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
 )
 
 // SourceMapping represents a mapping from original source file to instrumented file
@@ -29,583 +36,18 @@ type SourceMappings struct {
 	Mappings []SourceMapping `json:"mappings"`
 }
 
-// HookDefinition represents a parsed hook from the hooks file
-type HookDefinition struct {
-	Package  string
-	Function string
-	Receiver string
-	Type     string // "before_after", "rewrite", or "both"
-
-	// Rewrite-specific fields (extracted from Rewrite function AST)
-	RewriteFuncName    string // Name of the Rewrite function (e.g., "RewriteNewproc1")
-	RawCodeToInject    string // Raw code string to inject
-	RenameReturnValues bool   // Whether to rename unnamed return values
-	InjectPosition     string // "start" or "defer" - where to inject the code
-}
-
-// getHooksImportPath determines the full Go import path for a hooks file
-// by finding the nearest go.mod and calculating the relative path
-func getHooksImportPath(hooksFile string) (string, error) {
-	absPath, err := filepath.Abs(hooksFile)
-	if err != nil {
-		return "", fmt.Errorf("failed to get absolute path: %w", err)
-	}
-
-	// Get the directory containing the hooks file
-	hooksDir := filepath.Dir(absPath)
-
-	// Find the go.mod file by walking up the directory tree
-	modPath, modDir, err := findGoMod(hooksDir)
-	if err != nil {
-		return "", fmt.Errorf("failed to find go.mod: %w", err)
-	}
-
-	// Extract the module path from go.mod
-	modulePath, err := extractModulePath(modPath)
-	if err != nil {
-		return "", fmt.Errorf("failed to extract module path: %w", err)
-	}
-
-	// Calculate the relative path from module root to hooks directory
-	relPath, err := filepath.Rel(modDir, hooksDir)
-	if err != nil {
-		return "", fmt.Errorf("failed to calculate relative path: %w", err)
-	}
-
-	// Combine module path with relative path (use forward slashes for import paths)
-	if relPath == "." {
-		return modulePath, nil
-	}
-	importPath := modulePath + "/" + filepath.ToSlash(relPath)
-	return importPath, nil
-}
-
-// findGoMod walks up the directory tree to find go.mod
-func findGoMod(startDir string) (modPath string, modDir string, err error) {
-	dir := startDir
-	for {
-		modPath = filepath.Join(dir, "go.mod")
-		if _, err := os.Stat(modPath); err == nil {
-			return modPath, dir, nil
-		}
-
-		parent := filepath.Dir(dir)
-		if parent == dir {
-			// Reached root without finding go.mod
-			return "", "", fmt.Errorf("go.mod not found")
-		}
-		dir = parent
-	}
-}
-
-// extractModulePath extracts the module path from a go.mod file
-func extractModulePath(modPath string) (string, error) {
-	file, err := os.Open(modPath)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(line, "module ") {
-			modulePath := strings.TrimPrefix(line, "module ")
-			modulePath = strings.TrimSpace(modulePath)
-			return modulePath, nil
-		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return "", err
-	}
-
-	return "", fmt.Errorf("module declaration not found in go.mod")
-}
-
-// parseHooksFile parses a Go file containing hook definitions and extracts hook information
-func parseHooksFile(hooksFile string) ([]HookDefinition, error) {
-	var hooks []HookDefinition
-
-	// Parse the hooks file
-	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, hooksFile, nil, parser.ParseComments)
-	if err != nil {
-		return nil, fmt.Errorf("error parsing hooks file %s: %w", hooksFile, err)
-	}
-
-	// Find ProvideHooks function
-	for _, decl := range node.Decls {
-		funcDecl, ok := decl.(*ast.FuncDecl)
-		if !ok || funcDecl.Name.Name != "ProvideHooks" {
-			continue
-		}
-
-		// Parse the function body to extract hook definitions
-		hooks = extractHooksFromFunction(funcDecl)
-		break
-	}
-
-	if len(hooks) == 0 {
-		return nil, fmt.Errorf("no hooks found in %s", hooksFile)
-	}
-
-	return hooks, nil
-}
-
-// extractHooksFromFunction extracts hook definitions from ProvideHooks function
-func extractHooksFromFunction(funcDecl *ast.FuncDecl) []HookDefinition {
-	var hooks []HookDefinition
-
-	// Walk through the function body
-	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
-		// Look for composite literals that represent Hook structs
-		compLit, ok := n.(*ast.CompositeLit)
-		if !ok {
-			return true
-		}
-
-		// Check if this is a Hook struct
-		hook := parseHookFromCompositeLit(compLit)
-		if hook != nil {
-			hooks = append(hooks, *hook)
-		}
-
-		return true
-	})
-
-	return hooks
-}
-
-// parseHookFromCompositeLit parses a Hook struct from a composite literal
-func parseHookFromCompositeLit(lit *ast.CompositeLit) *HookDefinition {
-	hook := &HookDefinition{}
-	hasTarget := false
-	hasHooks := false
-	hasRewrite := false
-
-	for _, elt := range lit.Elts {
-		kvExpr, ok := elt.(*ast.KeyValueExpr)
-		if !ok {
-			continue
-		}
-
-		key, ok := kvExpr.Key.(*ast.Ident)
-		if !ok {
-			continue
-		}
-
-		switch key.Name {
-		case "Target":
-			// Parse InjectTarget
-			if targetLit, ok := kvExpr.Value.(*ast.CompositeLit); ok {
-				for _, targetElt := range targetLit.Elts {
-					targetKV, ok := targetElt.(*ast.KeyValueExpr)
-					if !ok {
-						continue
-					}
-
-					targetKey, ok := targetKV.Key.(*ast.Ident)
-					if !ok {
-						continue
-					}
-
-					switch targetKey.Name {
-					case "Package":
-						if lit, ok := targetKV.Value.(*ast.BasicLit); ok {
-							hook.Package = strings.Trim(lit.Value, `"`)
-						}
-					case "Function":
-						if lit, ok := targetKV.Value.(*ast.BasicLit); ok {
-							hook.Function = strings.Trim(lit.Value, `"`)
-						}
-					case "Receiver":
-						if lit, ok := targetKV.Value.(*ast.BasicLit); ok {
-							hook.Receiver = strings.Trim(lit.Value, `"`)
-						}
-					}
-				}
-				hasTarget = true
-			}
-		case "Hooks":
-			// Check if Hooks field is present (not nil)
-			if _, ok := kvExpr.Value.(*ast.UnaryExpr); ok {
-				hasHooks = true
-			}
-		case "Rewrite":
-			// Check if Rewrite field is present (not nil)
-			if kvExpr.Value != nil {
-				hasRewrite = true
-				// Extract the function name if it's an identifier
-				if ident, ok := kvExpr.Value.(*ast.Ident); ok {
-					hook.RewriteFuncName = ident.Name
-				}
-			}
-		}
-	}
-
-	// Determine hook type based on what's present
-	if hasTarget {
-		if hasHooks && hasRewrite {
-			hook.Type = "both"
-		} else if hasHooks {
-			hook.Type = "before_after"
-		} else if hasRewrite {
-			hook.Type = "rewrite"
-		} else {
-			return nil
-		}
-		return hook
-	}
-
-	return nil
-}
-
-// parseRewriteFunctionsFromFile parses a hooks file and extracts rewrite information
-// from all Rewrite functions (raw code to inject, whether to rename return values, etc.)
-func parseRewriteFunctionsFromFile(hooksFile string, hooks []HookDefinition) []HookDefinition {
-	// Parse the hooks file
-	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, hooksFile, nil, parser.ParseComments)
-	if err != nil {
-		return hooks
-	}
-
-	// Create a map for quick lookup of hooks by rewrite function name
-	hooksByRewriteFunc := make(map[string]*HookDefinition)
-	for i := range hooks {
-		if hooks[i].RewriteFuncName != "" {
-			hooksByRewriteFunc[hooks[i].RewriteFuncName] = &hooks[i]
-		}
-	}
-
-	// Find and parse each rewrite function
-	for _, decl := range node.Decls {
-		funcDecl, ok := decl.(*ast.FuncDecl)
-		if !ok {
-			continue
-		}
-
-		hook, exists := hooksByRewriteFunc[funcDecl.Name.Name]
-		if !exists {
-			continue
-		}
-
-		// Parse the rewrite function to extract info
-		parseRewriteFunction(funcDecl, hook)
-	}
-
-	return hooks
-}
-
-// parseRewriteFunction analyzes a Rewrite function and extracts:
-// - Raw code string to inject
-// - Whether renameReturnValues is called
-// - Injection position (start/defer)
-func parseRewriteFunction(funcDecl *ast.FuncDecl, hook *HookDefinition) {
-	if funcDecl.Body == nil {
-		return
-	}
-
-	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
-		switch node := n.(type) {
-		case *ast.CallExpr:
-			// Check for renameReturnValues call
-			if ident, ok := node.Fun.(*ast.Ident); ok {
-				if ident.Name == "renameReturnValues" {
-					hook.RenameReturnValues = true
-				}
-			}
-
-		case *ast.AssignStmt:
-			// Look for rawCode := `...` assignments
-			for i, lhs := range node.Lhs {
-				if ident, ok := lhs.(*ast.Ident); ok {
-					if ident.Name == "rawCode" || ident.Name == "code" || ident.Name == "deferCode" {
-						if i < len(node.Rhs) {
-							if lit, ok := node.Rhs[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
-								// Extract the raw string value
-								rawCode := strings.Trim(lit.Value, "`\"")
-								hook.RawCodeToInject = rawCode
-
-								// Determine injection position based on content
-								if strings.HasPrefix(strings.TrimSpace(rawCode), "defer ") {
-									hook.InjectPosition = "defer"
-								} else {
-									hook.InjectPosition = "start"
-								}
-							}
-						}
-					}
-				}
-			}
-		}
-		return true
-	})
-}
-
-// GeneratedFileDefinition represents a file to be generated into a package
-type GeneratedFileDefinition struct {
-	Package  string
-	FileName string
-	Content  string
-}
-
-// StructModificationDefinition represents a struct modification to apply
-type StructModificationDefinition struct {
-	Package    string
-	StructName string
-	AddFields  []StructFieldDefinition
-}
-
-// StructFieldDefinition represents a field to add to a struct
-type StructFieldDefinition struct {
-	Name string
-	Type string
-}
-
-// parseGeneratedFilesFromHooksFile parses the hooks file to extract GeneratedFile definitions
-// from GetGeneratedFiles() function
-func parseGeneratedFilesFromHooksFile(hooksFile string) []GeneratedFileDefinition {
-	var files []GeneratedFileDefinition
-
-	// Parse the hooks file
-	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, hooksFile, nil, parser.ParseComments)
-	if err != nil {
-		return files
-	}
-
-	// Find string constants that might contain generated file content
-	stringConstants := make(map[string]string)
-	for _, decl := range node.Decls {
-		genDecl, ok := decl.(*ast.GenDecl)
-		if !ok || genDecl.Tok != token.CONST {
-			continue
-		}
-		for _, spec := range genDecl.Specs {
-			valueSpec, ok := spec.(*ast.ValueSpec)
-			if !ok || len(valueSpec.Names) == 0 || len(valueSpec.Values) == 0 {
-				continue
-			}
-			if lit, ok := valueSpec.Values[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
-				stringConstants[valueSpec.Names[0].Name] = strings.Trim(lit.Value, "`\"")
-			}
-		}
-	}
-
-	// Find GetGeneratedFiles function
-	for _, decl := range node.Decls {
-		funcDecl, ok := decl.(*ast.FuncDecl)
-		if !ok || funcDecl.Name.Name != "GetGeneratedFiles" {
-			continue
-		}
-
-		// Parse the function body to extract GeneratedFile definitions
-		ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
-			compLit, ok := n.(*ast.CompositeLit)
-			if !ok {
-				return true
-			}
-
-			file := parseGeneratedFileFromCompositeLit(compLit, stringConstants)
-			if file != nil {
-				files = append(files, *file)
-			}
-
-			return true
-		})
-		break
-	}
-
-	return files
-}
-
-// parseGeneratedFileFromCompositeLit parses a GeneratedFile struct from a composite literal
-func parseGeneratedFileFromCompositeLit(lit *ast.CompositeLit, stringConstants map[string]string) *GeneratedFileDefinition {
-	file := &GeneratedFileDefinition{}
-	hasPackage := false
-	hasFileName := false
-
-	for _, elt := range lit.Elts {
-		kvExpr, ok := elt.(*ast.KeyValueExpr)
-		if !ok {
-			continue
-		}
-
-		key, ok := kvExpr.Key.(*ast.Ident)
-		if !ok {
-			continue
-		}
-
-		switch key.Name {
-		case "Package":
-			if lit, ok := kvExpr.Value.(*ast.BasicLit); ok {
-				file.Package = strings.Trim(lit.Value, `"`)
-				hasPackage = true
-			}
-		case "FileName":
-			if lit, ok := kvExpr.Value.(*ast.BasicLit); ok {
-				file.FileName = strings.Trim(lit.Value, `"`)
-				hasFileName = true
-			}
-		case "Content":
-			// Content can be a string literal or a constant reference
-			if lit, ok := kvExpr.Value.(*ast.BasicLit); ok {
-				file.Content = strings.Trim(lit.Value, "`\"")
-			} else if ident, ok := kvExpr.Value.(*ast.Ident); ok {
-				// It's a reference to a constant
-				if content, exists := stringConstants[ident.Name]; exists {
-					file.Content = content
-				}
-			}
-		}
-	}
-
-	if hasPackage && hasFileName && file.Content != "" {
-		return file
-	}
-
-	return nil
-}
-
-// parseStructModificationsFromHooksFile parses the hooks file to extract StructModification definitions
-// from GetStructModifications() function
-func parseStructModificationsFromHooksFile(hooksFile string) []StructModificationDefinition {
-	var modifications []StructModificationDefinition
-
-	// Parse the hooks file
-	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, hooksFile, nil, parser.ParseComments)
-	if err != nil {
-		return modifications
-	}
-
-	// Find GetStructModifications function
-	for _, decl := range node.Decls {
-		funcDecl, ok := decl.(*ast.FuncDecl)
-		if !ok || funcDecl.Name.Name != "GetStructModifications" {
-			continue
-		}
-
-		// Parse the function body to extract StructModification definitions
-		ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
-			compLit, ok := n.(*ast.CompositeLit)
-			if !ok {
-				return true
-			}
-
-			mod := parseStructModificationFromCompositeLit(compLit)
-			if mod != nil {
-				modifications = append(modifications, *mod)
-			}
-
-			return true
-		})
-		break
-	}
-
-	return modifications
-}
-
-// parseStructModificationFromCompositeLit parses a StructModification struct from a composite literal
-func parseStructModificationFromCompositeLit(lit *ast.CompositeLit) *StructModificationDefinition {
-	mod := &StructModificationDefinition{}
-	hasPackage := false
-	hasStructName := false
-
-	for _, elt := range lit.Elts {
-		kvExpr, ok := elt.(*ast.KeyValueExpr)
-		if !ok {
-			continue
-		}
-
-		key, ok := kvExpr.Key.(*ast.Ident)
-		if !ok {
-			continue
-		}
-
-		switch key.Name {
-		case "Package":
-			if basicLit, ok := kvExpr.Value.(*ast.BasicLit); ok {
-				mod.Package = strings.Trim(basicLit.Value, `"`)
-				hasPackage = true
-			}
-		case "StructName":
-			if basicLit, ok := kvExpr.Value.(*ast.BasicLit); ok {
-				mod.StructName = strings.Trim(basicLit.Value, `"`)
-				hasStructName = true
-			}
-		case "AddFields":
-			// Parse the slice of StructField
-			if compLit, ok := kvExpr.Value.(*ast.CompositeLit); ok {
-				for _, fieldElt := range compLit.Elts {
-					if fieldLit, ok := fieldElt.(*ast.CompositeLit); ok {
-						field := parseStructFieldFromCompositeLit(fieldLit)
-						if field != nil {
-							mod.AddFields = append(mod.AddFields, *field)
-						}
-					}
-				}
-			}
-		}
-	}
-
-	if hasPackage && hasStructName && len(mod.AddFields) > 0 {
-		return mod
-	}
-
-	return nil
-}
-
-// parseStructFieldFromCompositeLit parses a StructField from a composite literal
-func parseStructFieldFromCompositeLit(lit *ast.CompositeLit) *StructFieldDefinition {
-	field := &StructFieldDefinition{}
-	hasName := false
-	hasType := false
-
-	for _, elt := range lit.Elts {
-		kvExpr, ok := elt.(*ast.KeyValueExpr)
-		if !ok {
-			continue
-		}
-
-		key, ok := kvExpr.Key.(*ast.Ident)
-		if !ok {
-			continue
-		}
-
-		switch key.Name {
-		case "Name":
-			if basicLit, ok := kvExpr.Value.(*ast.BasicLit); ok {
-				field.Name = strings.Trim(basicLit.Value, `"`)
-				hasName = true
-			}
-		case "Type":
-			if basicLit, ok := kvExpr.Value.(*ast.BasicLit); ok {
-				field.Type = strings.Trim(basicLit.Value, `"`)
-				hasType = true
-			}
-		}
-	}
-
-	if hasName && hasType {
-		return field
-	}
-
-	return nil
-}
-
-// applyStructModification modifies a struct definition in a source file by adding new fields
-func applyStructModification(sourceFile string, targetFile string, mod StructModificationDefinition) error {
+// applyStructModification modifies a struct definition in a source file by adding new fields.
+// sourceFile is originalFile or a modified copy of it.
+func applyStructModification(originalFile, sourceFile, targetFile string, mod instrument.StructModificationDefinition) error {
 	// Parse the source file
 	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, sourceFile, nil, parser.ParseComments)
+	src, node, err := parseSourceFile(fset, sourceFile)
 	if err != nil {
 		return fmt.Errorf("failed to parse source file %s: %w", sourceFile, err)
 	}
 
 	modified := false
+	var edit declEdit
 
 	// Find the struct type declaration
 	for _, decl := range node.Decls {
@@ -624,6 +66,7 @@ func applyStructModification(sourceFile string, targetFile string, mod StructMod
 			if !ok {
 				continue
 			}
+			edit = newDeclEdit(fset, genDecl)
 
 			// Add new fields to the struct
 			for _, field := range mod.AddFields {
@@ -632,7 +75,7 @@ func applyStructModification(sourceFile string, targetFile string, mod StructMod
 					Type:  ast.NewIdent(field.Type),
 				}
 				structType.Fields.List = append(structType.Fields.List, newField)
-				fmt.Printf("           ➕ Added field '%s %s' to struct '%s'\n", field.Name, field.Type, mod.StructName)
+				report.Printf("           ➕ Added field '%s %s' to struct '%s'\n", field.Name, field.Type, mod.StructName)
 			}
 			modified = true
 			break
@@ -647,22 +90,23 @@ func applyStructModification(sourceFile string, targetFile string, mod StructMod
 		return fmt.Errorf("struct '%s' not found in file %s", mod.StructName, sourceFile)
 	}
 
-	// Write the modified file
-	file, err := os.Create(targetFile)
+	// Write the modified file, reprinting only the struct's declaration
+	content, err := applyDeclEdits(src, fset, node, []declEdit{edit})
 	if err != nil {
-		return fmt.Errorf("failed to create target file %s: %w", targetFile, err)
+		return fmt.Errorf("failed to format modified file: %w", err)
 	}
-	defer file.Close()
-
-	if err := format.Node(file, fset, node); err != nil {
-		return fmt.Errorf("failed to format and write modified file: %w", err)
+	if content, err = withLineDirectives(originalFile, markGenerated(content)); err != nil {
+		return err
+	}
+	if err := os.WriteFile(targetFile, content, 0644); err != nil {
+		return fmt.Errorf("failed to write modified file %s: %w", targetFile, err)
 	}
 
 	return nil
 }
 
 // writeGeneratedFileToPackage writes a generated file to the appropriate package directory in WORK
-func writeGeneratedFileToPackage(genFile GeneratedFileDefinition, workDir string, buildID string) (string, error) {
+func writeGeneratedFileToPackage(genFile instrument.GeneratedFileDefinition, workDir string, buildID string) (string, error) {
 	if workDir == "" || buildID == "" {
 		return "", fmt.Errorf("missing work directory or build ID")
 	}
@@ -675,11 +119,11 @@ func writeGeneratedFileToPackage(genFile GeneratedFileDefinition, workDir string
 
 	// Write the generated file
 	targetFile := filepath.Join(targetDir, genFile.FileName)
-	if err := os.WriteFile(targetFile, []byte(genFile.Content), 0644); err != nil {
+	if err := os.WriteFile(targetFile, markGenerated([]byte(genFile.Content)), 0644); err != nil {
 		return "", fmt.Errorf("failed to write generated file %s: %w", targetFile, err)
 	}
 
-	fmt.Printf("           📝 Generated file: %s\n", targetFile)
+	report.Printf("           📝 Generated file: %s\n", targetFile)
 	return targetFile, nil
 }
 
@@ -722,97 +166,282 @@ func findStructDefinitionFile(files []string, structName string) (string, error)
 	return "", fmt.Errorf("struct '%s' not found in any file", structName)
 }
 
-// matchFunctionWithHooks checks if a function matches any of the provided hooks
-func matchFunctionWithHooks(packageName string, funcInfo *FunctionInfo, hooks []HookDefinition) *HookDefinition {
-	for _, hook := range hooks {
-		// Match package name
-		if hook.Package != packageName {
+// applyPackageModifications applies the struct modifications and generated files of the hooks
+// to a package: struct fields are added to a copy of the file declaring the struct in the
+// package's $WORK directory (to the instrumented copy if the file has hooked functions too),
+// and generated files are written next to it. The copies are recorded in fileReplacements and
+// generatedFilePaths for the modified build log, and every modification in applied, so that
+// packages compiled more than once are modified once. It reports whether the package changed.
+func applyPackageModifications(packageName string, files []string, buildID, workDir string,
+	structMods []instrument.StructModificationDefinition, generatedFiles []instrument.GeneratedFileDefinition,
+	applied map[string]bool, fileReplacements map[string]string, generatedFilePaths map[string][]string) bool {
+	if buildID == "" {
+		return false
+	}
+	modified := false
+
+	for _, mod := range structMods {
+		key := "struct:" + mod.Package + "." + mod.StructName
+		if mod.Package != packageName || applied[key] {
 			continue
 		}
+		report.Printf("  🔍 Looking for struct '%s' to modify in package '%s'\n", mod.StructName, packageName)
 
-		// Match function name
-		if hook.Function != funcInfo.Name {
+		structFile, err := findStructDefinitionFile(files, mod.StructName)
+		if err != nil {
+			report.Warnf("%v\n", err)
 			continue
 		}
+		report.Printf("     Found struct in: %s\n", filepath.Base(structFile))
 
-		// Match receiver (if any)
-		if hook.Receiver != "" && hook.Receiver != funcInfo.Receiver {
+		// Earlier modifications of the file, hooks or struct fields, are kept
+		sourceFile := structFile
+		if replacement, exists := fileReplacements[structFile]; exists {
+			sourceFile = replacement
+		}
+		targetDir := filepath.Join(workDir, buildID)
+		if err := os.MkdirAll(targetDir, 0755); err != nil {
+			report.Warnf("failed to create target dir: %v\n", err)
+			continue
+		}
+		targetFile := filepath.Join(targetDir, filepath.Base(structFile))
+		if err := applyStructModification(structFile, sourceFile, targetFile, mod); err != nil {
+			report.Warnf("failed to apply struct modification: %v\n", err)
 			continue
 		}
+		applied[key] = true
+		fileReplacements[structFile] = targetFile
+		modified = true
+		report.Printf("     ✅ Modified struct '%s' and saved to: %s\n", mod.StructName, targetFile)
+	}
 
-		// If receiver is empty in hook but function has receiver, skip
-		if hook.Receiver == "" && funcInfo.Receiver != "" {
+	for _, genFile := range generatedFiles {
+		key := "file:" + genFile.Package + "/" + genFile.FileName
+		if genFile.Package != packageName || applied[key] {
 			continue
 		}
+		report.Printf("  📝 Generating file '%s' for package '%s'\n", genFile.FileName, packageName)
 
-		return &hook
+		genFilePath, err := writeGeneratedFileToPackage(genFile, workDir, buildID)
+		if err != nil {
+			report.Warnf("failed to generate file: %v\n", err)
+			continue
+		}
+		applied[key] = true
+		generatedFilePaths[packageName] = append(generatedFilePaths[packageName], genFilePath)
+		modified = true
+		report.Printf("     ✅ Generated: %s\n", genFilePath)
 	}
 
-	return nil
+	return modified
+}
+
+// warnUnappliedModifications warns about the struct modifications and generated files of
+// packages the build doesn't compile
+func warnUnappliedModifications(structMods []instrument.StructModificationDefinition, generatedFiles []instrument.GeneratedFileDefinition, applied map[string]bool) {
+	for _, mod := range structMods {
+		if !applied["struct:"+mod.Package+"."+mod.StructName] {
+			report.Warnf("struct %s.%s was not modified: the build compiles no package %s declaring it\n", mod.Package, mod.StructName, mod.Package)
+		}
+	}
+	for _, genFile := range generatedFiles {
+		if !applied["file:"+genFile.Package+"/"+genFile.FileName] {
+			report.Warnf("%s was not generated: the build compiles no package %s\n", genFile.FileName, genFile.Package)
+		}
+	}
+}
+
+// warnIndirectOnlyHookTargets warns about hook targets that are never called directly, only
+// through stored function values (handlers in a map, callbacks in struct fields). The target
+// is still instrumented, but its hooks fire only when the stored value is invoked, which
+// static analysis cannot confirm.
+func warnIndirectOnlyHookTargets(commands []parse.Command, hooks []instrument.HookDefinition) {
+	var files []string
+	cgoSources := parse.NewCgoSources(commands)
+	for _, cmd := range commands {
+		if !parse.IsCompileCommand(&cmd) || parse.IsStdlibCompileCommand(&cmd) {
+			continue
+		}
+		for _, file := range cgoSources.SourceFiles(&cmd) {
+			if strings.HasSuffix(file, ".go") {
+				files = append(files, file)
+			}
+		}
+	}
+	if len(files) == 0 {
+		return
+	}
+
+	cg, err := analyze.BuildCallGraph(files)
+	if err != nil {
+		return
+	}
+
+	warned := make(map[string]bool)
+	for _, hook := range hooks {
+		// Pattern hooks don't name a single function to look up
+		if instrument.IsPatternTarget(hook) {
+			continue
+		}
+		name := hook.Function
+		if hook.Receiver != "" {
+			name = fmt.Sprintf("(%s) %s", hook.Receiver, hook.Function)
+		}
+		key := hook.Package + "." + name
+		if warned[key] {
+			continue
+		}
+
+		refs := cg.IndirectOnlyReferences(hook.Function)
+		if len(refs) == 0 {
+			continue
+		}
+		warned[key] = true
+
+		var message strings.Builder
+		fmt.Fprintf(&message, "%s is never called directly, only through function values:\n", key)
+		for _, ref := range refs {
+			fmt.Fprintf(&message, "     - %s:%d", filepath.Base(ref.File), ref.Line)
+			if ref.Slot != "" {
+				fmt.Fprintf(&message, " (stored in %s)", ref.Slot)
+			}
+			message.WriteString("\n")
+		}
+		report.Warnf("%s", message.String())
+	}
+}
+
+// HooksPackageBuild is a hooks package compiled into the instrumented build
+type HooksPackageBuild struct {
+	ImportPath string
+	Dir        string
+	Archive    string // Compiled package file
+}
+
+// hooksPackageBuilds returns the distinct hooks packages of the hooks files, primary
+// (first) package first
+func hooksPackageBuilds(hooksFiles []string, hooksImportPath string) []HooksPackageBuild {
+	var packages []HooksPackageBuild
+	seen := make(map[string]bool)
+	for i, hooksFile := range hooksFiles {
+		dir := filepath.Dir(hooksFile)
+		if absDir, err := filepath.Abs(dir); err == nil {
+			dir = absDir
+		}
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		importPath := hooksImportPath
+		if i > 0 {
+			if path, err := instrument.GetHooksImportPath(hooksFile); err == nil {
+				importPath = path
+			}
+		}
+		packages = append(packages, HooksPackageBuild{ImportPath: importPath, Dir: dir})
+	}
+	return packages
+}
+
+// callHooksDirs returns the directories of the hooks files with Before/After hooks, whose
+// packages the trampolines call. Packages with only Rewrite hooks, struct modifications and
+// generated files are used while instrumenting and aren't compiled into the build: they may
+// import packages the build doesn't compile, such as go/ast.
+func callHooksDirs(hooksFiles []string) map[string]bool {
+	dirs := make(map[string]bool)
+	for _, hooksFile := range hooksFiles {
+		dir := filepath.Dir(hooksFile)
+		if absDir, err := filepath.Abs(dir); err == nil {
+			dir = absDir
+		}
+		hooks, err := instrument.ParseHooksFile(hooksFile)
+		if err != nil {
+			dirs[dir] = true
+			continue
+		}
+		for _, hook := range hooks {
+			if hook.Type != "rewrite" {
+				dirs[dir] = true
+				break
+			}
+		}
+	}
+	return dirs
 }
 
 // processCompileWithMultipleHooks merges hooks from multiple files and processes them in one pass
-func processCompileWithMultipleHooks(commands []Command, hooksFiles []string) error {
+func processCompileWithMultipleHooks(commands []parse.Command, hooksFiles []string) error {
 	if len(hooksFiles) == 0 {
 		return fmt.Errorf("no hooks files provided")
 	}
 
 	// If only one file, use the original function
+	hooksFiles = instrument.UniqueHooksFiles(hooksFiles)
 	if len(hooksFiles) == 1 {
 		return processCompileWithHooks(commands, hooksFiles[0])
 	}
 
 	// Merge hooks from all files
-	var allHooks []HookDefinition
-	var allStructMods []StructModificationDefinition
-	var allGeneratedFiles []GeneratedFileDefinition
+	var allHooks []instrument.HookDefinition
+	var allStructMods []instrument.StructModificationDefinition
+	var allGeneratedFiles []instrument.GeneratedFileDefinition
 	var allHooksFiles []string // Track all hooks file paths for compilation
 
-	fmt.Println("=== Merging hooks from multiple files ===")
+	report.Println("=== Merging hooks from multiple files ===")
 
 	for _, hooksFile := range hooksFiles {
-		fmt.Printf("\n📁 Loading: %s\n", filepath.Base(hooksFile))
+		report.Printf("\n📁 Loading: %s\n", hooksFile)
 
 		// Parse hooks
-		hooks, err := parseHooksFile(hooksFile)
+		hooks, err := instrument.ParseHooksFile(hooksFile)
+		if err != nil && !errors.Is(err, instrument.ErrNoHooks) {
+			return err
+		}
 		if err != nil {
-			fmt.Printf("   ⚠️  %v\n", err)
-			hooks = []HookDefinition{}
+			report.Warnf("%v\n", err)
+			hooks = []instrument.HookDefinition{}
 		} else {
-			fmt.Printf("   Hooks: %d\n", len(hooks))
+			report.Printf("   Hooks: %d\n", len(hooks))
 		}
-		hooks = parseRewriteFunctionsFromFile(hooksFile, hooks)
+		hooks = instrument.ParseRewriteFunctionsFromFile(hooksFile, hooks)
+		instrument.TagHooks(hooks, hooksFile)
 		allHooks = append(allHooks, hooks...)
 
 		// Parse struct modifications
-		structMods := parseStructModificationsFromHooksFile(hooksFile)
+		structMods := instrument.ParseStructModificationsFromHooksFile(hooksFile)
 		if len(structMods) > 0 {
-			fmt.Printf("   Struct modifications: %d\n", len(structMods))
+			report.Printf("   Struct modifications: %d\n", len(structMods))
 			allStructMods = append(allStructMods, structMods...)
 		}
 
 		// Parse generated files
-		generatedFiles := parseGeneratedFilesFromHooksFile(hooksFile)
+		generatedFiles := instrument.ParseGeneratedFilesFromHooksFile(hooksFile)
 		if len(generatedFiles) > 0 {
-			fmt.Printf("   Generated files: %d\n", len(generatedFiles))
+			report.Printf("   Generated files: %d\n", len(generatedFiles))
 			allGeneratedFiles = append(allGeneratedFiles, generatedFiles...)
 		}
 
 		allHooksFiles = append(allHooksFiles, hooksFile)
 	}
 
-	fmt.Printf("\n=== Merged totals ===\n")
-	fmt.Printf("Total hooks: %d\n", len(allHooks))
-	fmt.Printf("Total struct modifications: %d\n", len(allStructMods))
-	fmt.Printf("Total generated files: %d\n", len(allGeneratedFiles))
+	report.Printf("\n=== Merged totals ===\n")
+	report.Printf("Total hooks: %d\n", len(allHooks))
+	report.Printf("Total struct modifications: %d\n", len(allStructMods))
+	report.Printf("Total generated files: %d\n", len(allGeneratedFiles))
+
+	if err := instrument.CheckHookConflicts(allHooks); err != nil {
+		return err
+	}
 
-	// Use the first hooks file's directory for import path (all hooks files should be in same package)
-	hooksImportPath, err := getHooksImportPath(hooksFiles[0])
+	// The first hooks file's package is the primary hooks package; hooks from other
+	// packages link to their own package
+	hooksImportPath, err := instrument.GetHooksImportPath(hooksFiles[0])
 	if err != nil {
-		fmt.Printf("⚠️  Warning: Could not determine hooks import path: %v\n", err)
+		report.Warnf("could not determine hooks import path: %v\n", err)
 		hooksImportPath = "generated_hooks"
 	} else {
-		fmt.Printf("Hooks import path: %s\n", hooksImportPath)
+		report.Printf("Hooks import path: %s\n", hooksImportPath)
 	}
 
 	// Process with merged data
@@ -821,12 +450,12 @@ func processCompileWithMultipleHooks(commands []Command, hooksFiles []string) er
 }
 
 // processCompileWithHooksInternal is the internal implementation with pre-parsed data
-func processCompileWithHooksInternal(commands []Command, hooks []HookDefinition,
-	structMods []StructModificationDefinition, generatedFiles []GeneratedFileDefinition,
+func processCompileWithHooksInternal(commands []parse.Command, hooks []instrument.HookDefinition,
+	structMods []instrument.StructModificationDefinition, generatedFiles []instrument.GeneratedFileDefinition,
 	hooksFiles []string, hooksImportPath string) error {
 
-	fmt.Printf("\n=== Compile Mode with Hooks ===\n")
-	fmt.Printf("Processing %d hook definitions\n\n", len(hooks))
+	report.Printf("\n=== Compile Mode with Hooks ===\n")
+	report.Printf("Processing %d hook definitions\n\n", len(hooks))
 
 	// Get package path information using existing functionality
 	packageInfo := extractPackagePathInfo(commands)
@@ -834,19 +463,22 @@ func processCompileWithHooksInternal(commands []Command, hooks []HookDefinition,
 	// Extract work directory
 	workDir := extractWorkDirFromCommands(commands)
 	if workDir != "" {
-		fmt.Printf("Work directory: %s\n", workDir)
+		report.Printf("Work directory: %s\n", workDir)
 	}
 
 	// Display loaded hooks
-	fmt.Println("Hook Definitions:")
+	report.Println("Hook Definitions:")
 	for _, hook := range hooks {
-		fmt.Printf("  - Package: %s, Function: %s", hook.Package, hook.Function)
+		report.Printf("  - Package: %s, Function: %s", hook.Package, hook.Function)
 		if hook.Receiver != "" {
-			fmt.Printf(", Receiver: %s", hook.Receiver)
+			report.Printf(", Receiver: %s", hook.Receiver)
+		}
+		if hook.File != "" {
+			report.Printf(", File: %s", hook.File)
 		}
-		fmt.Printf(" [%s]\n", hook.Type)
+		report.Printf(" [%s]\n", hook.Type)
 	}
-	fmt.Println()
+	report.Println()
 
 	compileCount := 0
 	matchCount := 0
@@ -855,24 +487,34 @@ func processCompileWithHooksInternal(commands []Command, hooks []HookDefinition,
 	fileReplacements := make(map[string]string)
 	trampolineFiles := make(map[string]string)
 	generatedFilePaths := make(map[string][]string)
-	structModApplied := make(map[string]bool)
-	packagesWithStructMods := make(map[string]bool)
+	appliedModifications := make(map[string]bool)
+
+	// Standard library and dependency packages are instrumented like those of the module,
+	// except for the packages the runtime depends on
+	runtimeDeps := runtimeDependencies(commands)
+	warnedRuntimeDeps := make(map[string]bool)
+
+	// The files of cgo packages are analyzed and instrumented before cgo translates them
+	cgoSources := parse.NewCgoSources(commands)
+	// Hooks may target main packages by import path
+	packagePaths := parse.NewPackagePaths(commands)
 
 	// Process each compile command
 	for cmdIdx, cmd := range commands {
-		if !isCompileCommand(&cmd) {
+		if !parse.IsCompileCommand(&cmd) {
 			continue
 		}
 
 		compileCount++
-		packageName := extractPackageName(&cmd)
-		files := extractPackFiles(&cmd)
+		packageName := parse.ExtractPackageName(&cmd)
+		files := cgoSources.SourceFiles(&cmd)
 
 		if packageName == "" || len(files) == 0 {
 			continue
 		}
+		pkgHooks := packageHooks(packageName, packagePaths.ImportPath(&cmd), files, hooks, runtimeDeps, warnedRuntimeDeps)
 
-		fmt.Printf("Command %d: Package '%s' with %d files\n", cmdIdx+1, packageName, len(files))
+		report.Debugf("Command %d: Package '%s' with %d files\n", cmdIdx+1, packageName, len(files))
 
 		packageHasMatches := false
 
@@ -882,9 +524,9 @@ func processCompileWithHooksInternal(commands []Command, hooks []HookDefinition,
 				continue
 			}
 
-			functions, err := extractFunctionsFromGoFile(file)
+			functions, err := analyze.ExtractFunctionsFromGoFile(file)
 			if err != nil {
-				fmt.Printf("  Error parsing %s: %v\n", file, err)
+				report.Errorf("parsing %s: %v\n", file, err)
 				continue
 			}
 
@@ -893,15 +535,23 @@ func processCompileWithHooksInternal(commands []Command, hooks []HookDefinition,
 			fileNeedsRewrite := false
 
 			for _, fn := range functions {
-				if match := matchFunctionWithHooks(packageName, &fn, hooks); match != nil {
+				reportBodylessTarget(packageName, file, fn, pkgHooks)
+				if match := instrument.MatchFunctionWithHooks(packageName, &fn, pkgHooks); match != nil {
 					matchCount++
 					packageHasMatches = true
 					fileHasMatches = true
-					fmt.Printf("  ✓ MATCH: %s:%s", filepath.Base(file), fn.Name)
+					report.Printf("  ✓ MATCH: %s:%s", filepath.Base(file), fn.Name)
 					if fn.Receiver != "" {
-						fmt.Printf(" (receiver: %s)", fn.Receiver)
+						report.Printf(" (receiver: %s)", fn.Receiver)
 					}
-					fmt.Printf(" -> Hook type: %s\n", match.Type)
+					if kind := dependencyKind(&cmd); kind != "" {
+						report.Printf(" [%s %s]", kind, packageName)
+					}
+					report.Printf(" -> Hook type: %s", match.Type)
+					if len(match.Chain) > 0 {
+						report.Printf(" (%d hooks in priority order)", len(match.Chain)+1)
+					}
+					report.Println()
 
 					switch match.Type {
 					case "before_after":
@@ -920,8 +570,8 @@ func processCompileWithHooksInternal(commands []Command, hooks []HookDefinition,
 				if !copiedFiles[copyKey] {
 					if pkgInfo, exists := packageInfo[packageName]; exists && pkgInfo.BuildID != "" {
 						instrumentedFilePath := filepath.Join(workDir, pkgInfo.BuildID, filepath.Base(file))
-						if err := copyAndInstrumentFileOnly(file, workDir, pkgInfo.BuildID, packageName, hooks, hooksImportPath); err != nil {
-							fmt.Printf("           ⚠️  Failed to copy and instrument file: %v\n", err)
+						if err := copyAndInstrumentFileOnly(file, workDir, pkgInfo.BuildID, packageName, pkgHooks, hooksImportPath); err != nil {
+							report.Warnf("failed to copy and instrument %s: %v\n", file, err)
 						} else {
 							copiedFiles[copyKey] = true
 							if strings.HasSuffix(file, ".go") {
@@ -941,63 +591,34 @@ func processCompileWithHooksInternal(commands []Command, hooks []HookDefinition,
 			packagesWithMatches[packageName] = true
 		}
 
-		// Check for struct modifications
-		for _, mod := range structMods {
-			if mod.Package != packageName {
-				continue
-			}
-			modKey := mod.Package + ":" + mod.StructName
-			if structModApplied[modKey] {
-				continue
-			}
-
-			structFile, err := findStructDefinitionFile(files, mod.StructName)
-			if err != nil {
-				continue
-			}
-
-			if pkgInfo, exists := packageInfo[packageName]; exists && pkgInfo.BuildID != "" && workDir != "" {
-				targetDir := filepath.Join(workDir, pkgInfo.BuildID)
-				os.MkdirAll(targetDir, 0755)
-				targetFile := filepath.Join(targetDir, filepath.Base(structFile))
-				if err := applyStructModification(structFile, targetFile, mod); err == nil {
-					structModApplied[modKey] = true
-					packagesWithStructMods[packageName] = true
-					fileReplacements[structFile] = targetFile
-				}
-			}
-		}
-
-		// Check for generated files
-		for _, genFile := range generatedFiles {
-			if genFile.Package != packageName {
-				continue
-			}
-			if pkgInfo, exists := packageInfo[packageName]; exists && pkgInfo.BuildID != "" && workDir != "" {
-				genFilePath, err := writeGeneratedFileToPackage(genFile, workDir, pkgInfo.BuildID)
-				if err == nil {
-					generatedFilePaths[packageName] = append(generatedFilePaths[packageName], genFilePath)
-					packagesWithMatches[packageName] = true
-				}
+		// Struct fields and generated files declared by the hooks
+		if pkgInfo, exists := packageInfo[packageName]; exists && workDir != "" {
+			if applyPackageModifications(packageName, files, pkgInfo.BuildID, workDir, structMods, generatedFiles,
+				appliedModifications, fileReplacements, generatedFilePaths) {
+				packagesWithMatches[packageName] = true
 			}
 		}
 	}
+	warnUnappliedModifications(structMods, generatedFiles, appliedModifications)
 
-	_ = packagesWithStructMods
+	printInstrumentationSummary(compileCount, matchCount, packagesWithMatches, packageInfo)
 
-	fmt.Printf("\nSummary: Processed %d compile commands, found %d hook matches in %d packages\n",
-		compileCount, matchCount, len(packagesWithMatches))
+	if matchCount > 0 {
+		warnIndirectOnlyHookTargets(commands, hooks)
+	}
 
 	// Find main package
 	var mainPackageInfo *PackagePathInfo
 	var mainBuildID string
+	var mainFiles []string
 	for _, cmd := range commands {
-		if isCompileCommand(&cmd) {
-			pkgName := extractPackageName(&cmd)
+		if parse.IsCompileCommand(&cmd) {
+			pkgName := parse.ExtractPackageName(&cmd)
 			if pkgName == "main" {
 				if info, exists := packageInfo[pkgName]; exists {
 					mainPackageInfo = &info
 					mainBuildID = info.BuildID
+					mainFiles = cgoSources.SourceFiles(&cmd)
 				}
 				break
 			}
@@ -1009,79 +630,135 @@ func processCompileWithHooksInternal(commands []Command, hooks []HookDefinition,
 	if len(trampolineFiles) > 0 && workDir != "" && mainBuildID != "" {
 		runtimeDir := filepath.Join(workDir, mainBuildID)
 		os.MkdirAll(runtimeDir, 0755)
-		otelRuntimeFile, _ = generateOtelRuntimeFile(runtimeDir, hooksImportPath)
+		var err error
+		if otelRuntimeFile, err = generateOtelRuntimeFile(runtimeDir, hooksImportPath, hooks); err == nil {
+			addMainShutdown(mainFiles, otelRuntimeFile, workDir, mainBuildID, fileReplacements)
+		}
 	}
 
 	// Generate modified build log - pass all hooks files for compilation
 	if len(fileReplacements) > 0 || len(generatedFilePaths) > 0 {
-		// Use first hooks file for now (all should be in same package)
-		hooksFile := ""
-		if len(hooksFiles) > 0 {
-			hooksFile = hooksFiles[0]
-		}
 		if err := generateModifiedBuildLogMultipleHooks(commands, fileReplacements, trampolineFiles,
 			generatedFilePaths, hooksImportPath, workDir, hooksFiles, otelRuntimeFile, mainPackageInfo); err != nil {
-			fmt.Printf("⚠️  Failed to generate modified build log: %v\n", err)
+			report.Warnf("failed to generate modified build log: %v\n", err)
 		} else {
-			fmt.Printf("\n📄 Generated modified build log: %s\n", GetMetadataPath(BuildModifiedLogFile))
+			report.Printf("\n📄 Generated modified build log: %s\n", GetMetadataPath(BuildModifiedLogFile))
+			recordHeredocs(GetMetadataPath(BuildModifiedLogFile), ModifiedHeredocsDir)
 			saveSourceMappings(fileReplacements, workDir)
+			publishRun()
+
+			if noExecute {
+				reportSkippedExecution()
+				return nil
+			}
 
-			fmt.Printf("\n🚀 Executing commands from modified build log...\n")
+			report.Printf("\n🚀 Executing commands from modified build log...\n")
 			if err := executeModifiedBuildLogWithParser(GetMetadataPath(BuildModifiedLogFile)); err != nil {
-				fmt.Printf("⚠️  Failed to execute modified build log: %v\n", err)
+				report.Warnf("failed to execute modified build log: %v\n", err)
 			} else {
-				fmt.Printf("✅ Successfully executed all commands from modified build log\n")
+				report.Printf("✅ Successfully executed all commands from modified build log\n")
 			}
 		}
-		_ = hooksFile
 	}
 
 	return nil
 }
 
+// printInstrumentationSummary prints the number of hook matches and the packages they were
+// found in, aligned in columns when the output is a terminal
+func printInstrumentationSummary(compileCount, matchCount int, packagesWithMatches map[string]bool, packageInfo map[string]PackagePathInfo) {
+	if !report.Columns {
+		report.Printf("\nSummary: Processed %d compile commands, found %d hook matches in %d packages\n",
+			compileCount, matchCount, len(packagesWithMatches))
+		if len(packagesWithMatches) > 0 {
+			report.Println("Packages with hook matches:")
+			for pkg := range packagesWithMatches {
+				if info, exists := packageInfo[pkg]; exists {
+					report.Printf("  - %s (BuildID: %s, Path: %s)\n", pkg, info.BuildID, info.Path)
+				} else {
+					report.Printf("  - %s (no build info found)\n", pkg)
+				}
+			}
+		}
+		return
+	}
+
+	matchStyle := styleGreen
+	if matchCount == 0 {
+		matchStyle = styleYellow
+	}
+	report.Printf("\n%s Processed %d compile commands, found %s in %d packages\n",
+		report.paint(styleBold, "Summary:"), compileCount,
+		report.paint(matchStyle, fmt.Sprintf("%d hook matches", matchCount)), len(packagesWithMatches))
+	if len(packagesWithMatches) == 0 {
+		return
+	}
+
+	packages := make([]string, 0, len(packagesWithMatches))
+	for pkg := range packagesWithMatches {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+
+	t := report.newStatusTable("  ")
+	t.header("PACKAGE", "BUILD ID", "PATH")
+	for _, pkg := range packages {
+		if info, exists := packageInfo[pkg]; exists {
+			t.row(styled(styleCyan, pkg), cell{text: info.BuildID}, styled(styleDim, info.Path))
+		} else {
+			t.row(styled(styleCyan, pkg), styled(styleYellow, "no build info found"))
+		}
+	}
+	t.flush()
+}
+
 // processCompileWithHooks processes compile commands and matches them against hooks
-func processCompileWithHooks(commands []Command, hooksFile string) error {
+func processCompileWithHooks(commands []parse.Command, hooksFile string) error {
 	// Parse the hooks file
-	hooks, err := parseHooksFile(hooksFile)
+	hooks, err := instrument.ParseHooksFile(hooksFile)
+	if err != nil && !errors.Is(err, instrument.ErrNoHooks) {
+		return err
+	}
 	if err != nil {
 		// It's ok if no hooks are found - we might still have struct modifications or generated files
-		fmt.Printf("⚠️  Warning: %v\n", err)
-		hooks = []HookDefinition{}
+		report.Warnf("%v\n", err)
+		hooks = []instrument.HookDefinition{}
 	}
 
 	// Parse rewrite functions to extract raw code and transformation info
-	hooks = parseRewriteFunctionsFromFile(hooksFile, hooks)
+	hooks = instrument.ParseRewriteFunctionsFromFile(hooksFile, hooks)
+	instrument.TagHooks(hooks, hooksFile)
 
 	// Parse struct modifications from the hooks file
-	structMods := parseStructModificationsFromHooksFile(hooksFile)
+	structMods := instrument.ParseStructModificationsFromHooksFile(hooksFile)
 	if len(structMods) > 0 {
-		fmt.Printf("Loaded %d struct modifications from %s\n", len(structMods), filepath.Base(hooksFile))
+		report.Printf("Loaded %d struct modifications from %s\n", len(structMods), filepath.Base(hooksFile))
 		for _, mod := range structMods {
-			fmt.Printf("  - Package: %s, Struct: %s, Fields to add: %d\n", mod.Package, mod.StructName, len(mod.AddFields))
+			report.Printf("  - Package: %s, Struct: %s, Fields to add: %d\n", mod.Package, mod.StructName, len(mod.AddFields))
 		}
 	}
 
 	// Parse generated files from the hooks file
-	generatedFiles := parseGeneratedFilesFromHooksFile(hooksFile)
+	generatedFiles := instrument.ParseGeneratedFilesFromHooksFile(hooksFile)
 	if len(generatedFiles) > 0 {
-		fmt.Printf("Loaded %d generated files from %s\n", len(generatedFiles), filepath.Base(hooksFile))
+		report.Printf("Loaded %d generated files from %s\n", len(generatedFiles), filepath.Base(hooksFile))
 		for _, gf := range generatedFiles {
-			fmt.Printf("  - Package: %s, File: %s (%d bytes)\n", gf.Package, gf.FileName, len(gf.Content))
+			report.Printf("  - Package: %s, File: %s (%d bytes)\n", gf.Package, gf.FileName, len(gf.Content))
 		}
 	}
 
 	// Get the full import path for the hooks package
-	hooksImportPath, err := getHooksImportPath(hooksFile)
+	hooksImportPath, err := instrument.GetHooksImportPath(hooksFile)
 	if err != nil {
-		fmt.Printf("⚠️  Warning: Could not determine hooks import path: %v\n", err)
-		fmt.Printf("   Using package name only for go:linkname (may not work)\n")
+		report.Warnf("could not determine hooks import path: %v\n", err)
+		report.Printf("   Using package name only for go:linkname (may not work)\n")
 		hooksImportPath = "generated_hooks" // Fallback
 	} else {
-		fmt.Printf("Hooks import path: %s\n", hooksImportPath)
+		report.Printf("Hooks import path: %s\n", hooksImportPath)
 	}
 
-	fmt.Printf("=== Compile Mode with Hooks ===\n")
-	fmt.Printf("Loaded %d hook definitions from %s\n\n", len(hooks), filepath.Base(hooksFile))
+	report.Printf("=== Compile Mode with Hooks ===\n")
+	report.Printf("Loaded %d hook definitions from %s\n\n", len(hooks), filepath.Base(hooksFile))
 
 	// Get package path information using existing functionality
 	packageInfo := extractPackagePathInfo(commands)
@@ -1089,45 +766,58 @@ func processCompileWithHooks(commands []Command, hooksFile string) error {
 	// Extract work directory
 	workDir := extractWorkDirFromCommands(commands)
 	if workDir != "" {
-		fmt.Printf("Work directory: %s\n", workDir)
+		report.Printf("Work directory: %s\n", workDir)
 	}
 
 	// Display loaded hooks
-	fmt.Println("Hook Definitions:")
+	report.Println("Hook Definitions:")
 	for _, hook := range hooks {
-		fmt.Printf("  - Package: %s, Function: %s", hook.Package, hook.Function)
+		report.Printf("  - Package: %s, Function: %s", hook.Package, hook.Function)
 		if hook.Receiver != "" {
-			fmt.Printf(", Receiver: %s", hook.Receiver)
+			report.Printf(", Receiver: %s", hook.Receiver)
 		}
-		fmt.Printf(" [%s]\n", hook.Type)
+		if hook.File != "" {
+			report.Printf(", File: %s", hook.File)
+		}
+		report.Printf(" [%s]\n", hook.Type)
 	}
-	fmt.Println()
+	report.Println()
 
 	compileCount := 0
 	matchCount := 0
-	packagesWithMatches := make(map[string]bool)      // Track packages that have matches
-	copiedFiles := make(map[string]bool)              // Track files already copied per package
-	fileReplacements := make(map[string]string)       // Track original file -> instrumented file mapping
-	trampolineFiles := make(map[string]string)        // Track package -> trampolines file path
-	generatedFilePaths := make(map[string][]string)   // Track package -> generated file paths
-	structModApplied := make(map[string]bool)         // Track which struct modifications have been applied
-	packagesWithStructMods := make(map[string]bool)   // Track packages with struct modifications
+	packagesWithMatches := make(map[string]bool)    // Track packages that have matches
+	copiedFiles := make(map[string]bool)            // Track files already copied per package
+	fileReplacements := make(map[string]string)     // Track original file -> instrumented file mapping
+	trampolineFiles := make(map[string]string)      // Track package -> trampolines file path
+	generatedFilePaths := make(map[string][]string) // Track package -> generated file paths
+	appliedModifications := make(map[string]bool)   // Track struct modifications and generated files already applied
+
+	// Standard library and dependency packages are instrumented like those of the module,
+	// except for the packages the runtime depends on
+	runtimeDeps := runtimeDependencies(commands)
+	warnedRuntimeDeps := make(map[string]bool)
+
+	// The files of cgo packages are analyzed and instrumented before cgo translates them
+	cgoSources := parse.NewCgoSources(commands)
+	// Hooks may target main packages by import path
+	packagePaths := parse.NewPackagePaths(commands)
 
 	// Process each compile command
 	for cmdIdx, cmd := range commands {
-		if !isCompileCommand(&cmd) {
+		if !parse.IsCompileCommand(&cmd) {
 			continue
 		}
 
 		compileCount++
-		packageName := extractPackageName(&cmd)
-		files := extractPackFiles(&cmd)
+		packageName := parse.ExtractPackageName(&cmd)
+		files := cgoSources.SourceFiles(&cmd)
 
 		if packageName == "" || len(files) == 0 {
 			continue
 		}
+		pkgHooks := packageHooks(packageName, packagePaths.ImportPath(&cmd), files, hooks, runtimeDeps, warnedRuntimeDeps)
 
-		fmt.Printf("Command %d: Package '%s' with %d files\n", cmdIdx+1, packageName, len(files))
+		report.Debugf("Command %d: Package '%s' with %d files\n", cmdIdx+1, packageName, len(files))
 
 		packageHasMatches := false
 
@@ -1137,9 +827,9 @@ func processCompileWithHooks(commands []Command, hooksFile string) error {
 				continue
 			}
 
-			functions, err := extractFunctionsFromGoFile(file)
+			functions, err := analyze.ExtractFunctionsFromGoFile(file)
 			if err != nil {
-				fmt.Printf("  Error parsing %s: %v\n", file, err)
+				report.Errorf("parsing %s: %v\n", file, err)
 				continue
 			}
 
@@ -1149,29 +839,34 @@ func processCompileWithHooks(commands []Command, hooksFile string) error {
 
 			// Check each function against hooks
 			for _, fn := range functions {
-				if match := matchFunctionWithHooks(packageName, &fn, hooks); match != nil {
+				reportBodylessTarget(packageName, file, fn, pkgHooks)
+				if match := instrument.MatchFunctionWithHooks(packageName, &fn, pkgHooks); match != nil {
 					matchCount++
 					packageHasMatches = true
 					fileHasMatches = true
-					fmt.Printf("  ✓ MATCH: %s:%s", filepath.Base(file), fn.Name)
+					report.Printf("  ✓ MATCH: %s:%s", filepath.Base(file), fn.Name)
 					if fn.Receiver != "" {
-						fmt.Printf(" (receiver: %s)", fn.Receiver)
+						report.Printf(" (receiver: %s)", fn.Receiver)
+					}
+					if kind := dependencyKind(&cmd); kind != "" {
+						report.Printf(" [%s %s]", kind, packageName)
 					}
-					fmt.Printf(" -> Hook type: %s\n", match.Type)
+					report.Printf(" -> Hook type: %s", match.Type)
+					if len(match.Chain) > 0 {
+						report.Printf(" (%d hooks in priority order)", len(match.Chain)+1)
+					}
+					report.Println()
 
 					// Show what will happen
 					switch match.Type {
 					case "before_after":
-						fmt.Printf("           Will inject: Before and After hooks\n")
+						report.Debugf("Will inject: Before and After hooks\n")
 						fileNeedsTrampolines = true
 					case "rewrite":
-						fmt.Printf("           Will rewrite: Function body (inject raw code)\n")
-						if match.RawCodeToInject != "" {
-							fmt.Printf("           Raw code to inject: %d bytes\n", len(match.RawCodeToInject))
-						}
+						report.Debugf("Will rewrite: Function body (%s)\n", match.RewriteFuncName)
 						fileNeedsRewrite = true
 					case "both":
-						fmt.Printf("           Will inject: Before/After hooks AND rewrite function\n")
+						report.Debugf("Will inject: Before/After hooks AND rewrite function\n")
 						fileNeedsTrampolines = true
 						fileNeedsRewrite = true
 					}
@@ -1185,14 +880,14 @@ func processCompileWithHooks(commands []Command, hooksFile string) error {
 				if !copiedFiles[copyKey] {
 					if pkgInfo, exists := packageInfo[packageName]; exists && pkgInfo.BuildID != "" {
 						instrumentedFilePath := filepath.Join(workDir, pkgInfo.BuildID, filepath.Base(file))
-						if err := copyAndInstrumentFileOnly(file, workDir, pkgInfo.BuildID, packageName, hooks, hooksImportPath); err != nil {
-							fmt.Printf("           ⚠️  Failed to copy and instrument file: %v\n", err)
+						if err := copyAndInstrumentFileOnly(file, workDir, pkgInfo.BuildID, packageName, pkgHooks, hooksImportPath); err != nil {
+							report.Warnf("failed to copy and instrument %s: %v\n", file, err)
 						} else {
 							copiedFiles[copyKey] = true
 							// Track the file replacement mapping - only for Go files
 							if strings.HasSuffix(file, ".go") {
 								fileReplacements[file] = instrumentedFilePath
-								fmt.Printf("           🔄 Will replace %s with %s in compile command\n", file, instrumentedFilePath)
+								report.Debugf("Will replace %s with %s in compile command\n", file, instrumentedFilePath)
 
 								// Track the trampolines file for this package - only for before_after hooks
 								if fileNeedsTrampolines {
@@ -1211,99 +906,35 @@ func processCompileWithHooks(commands []Command, hooksFile string) error {
 			packagesWithMatches[packageName] = true
 		}
 
-		// Check for struct modifications in this package
-		for _, mod := range structMods {
-			if mod.Package != packageName {
-				continue
-			}
-
-			modKey := mod.Package + ":" + mod.StructName
-			if structModApplied[modKey] {
-				continue
-			}
-
-			fmt.Printf("  🔍 Looking for struct '%s' to modify in package '%s'\n", mod.StructName, packageName)
-
-			// Find the file containing the struct definition
-			structFile, err := findStructDefinitionFile(files, mod.StructName)
-			if err != nil {
-				fmt.Printf("     ⚠️  %v\n", err)
-				continue
-			}
-
-			fmt.Printf("     Found struct in: %s\n", filepath.Base(structFile))
-
-			// Apply struct modification
-			if pkgInfo, exists := packageInfo[packageName]; exists && pkgInfo.BuildID != "" && workDir != "" {
-				targetDir := filepath.Join(workDir, pkgInfo.BuildID)
-				if err := os.MkdirAll(targetDir, 0755); err != nil {
-					fmt.Printf("     ⚠️  Failed to create target dir: %v\n", err)
-					continue
-				}
-
-				targetFile := filepath.Join(targetDir, filepath.Base(structFile))
-				if err := applyStructModification(structFile, targetFile, mod); err != nil {
-					fmt.Printf("     ⚠️  Failed to apply struct modification: %v\n", err)
-				} else {
-					structModApplied[modKey] = true
-					packagesWithStructMods[packageName] = true
-
-					// Track the file replacement
-					fileReplacements[structFile] = targetFile
-					fmt.Printf("     ✅ Modified struct '%s' and saved to: %s\n", mod.StructName, targetFile)
-				}
-			}
-		}
-
-		// Check for generated files for this package
-		for _, genFile := range generatedFiles {
-			if genFile.Package != packageName {
-				continue
-			}
-
-			fmt.Printf("  📝 Generating file '%s' for package '%s'\n", genFile.FileName, packageName)
-
-			if pkgInfo, exists := packageInfo[packageName]; exists && pkgInfo.BuildID != "" && workDir != "" {
-				genFilePath, err := writeGeneratedFileToPackage(genFile, workDir, pkgInfo.BuildID)
-				if err != nil {
-					fmt.Printf("     ⚠️  Failed to generate file: %v\n", err)
-				} else {
-					generatedFilePaths[packageName] = append(generatedFilePaths[packageName], genFilePath)
-					packagesWithMatches[packageName] = true // Ensure this package gets processed
-					fmt.Printf("     ✅ Generated: %s\n", genFilePath)
-				}
+		// Struct fields and generated files declared by the hooks
+		if pkgInfo, exists := packageInfo[packageName]; exists && workDir != "" {
+			if applyPackageModifications(packageName, files, pkgInfo.BuildID, workDir, structMods, generatedFiles,
+				appliedModifications, fileReplacements, generatedFilePaths) {
+				packagesWithMatches[packageName] = true
 			}
 		}
 	}
+	warnUnappliedModifications(structMods, generatedFiles, appliedModifications)
 
-	// Suppress unused variable warnings
-	_ = packagesWithStructMods
-
-	fmt.Printf("\nSummary: Processed %d compile commands, found %d hook matches in %d packages\n",
-		compileCount, matchCount, len(packagesWithMatches))
+	printInstrumentationSummary(compileCount, matchCount, packagesWithMatches, packageInfo)
 
-	if len(packagesWithMatches) > 0 {
-		fmt.Println("Packages with hook matches:")
-		for pkg := range packagesWithMatches {
-			if info, exists := packageInfo[pkg]; exists {
-				fmt.Printf("  - %s (BuildID: %s, Path: %s)\n", pkg, info.BuildID, info.Path)
-			} else {
-				fmt.Printf("  - %s (no build info found)\n", pkg)
-			}
-		}
+	if matchCount > 0 {
+		warnIndirectOnlyHookTargets(commands, hooks)
 	}
 
 	// Find the main package compile command and generate otel.runtime.go
 	var mainPackageInfo *PackagePathInfo
 	var mainBuildID string
+	var mainFiles []string
 	for _, cmd := range commands {
-		if isCompileCommand(&cmd) {
-			pkgName := extractPackageName(&cmd)
+		if parse.IsCompileCommand(&cmd) {
+			pkgName := parse.ExtractPackageName(&cmd)
 			if pkgName == "main" {
 				if info, exists := packageInfo[pkgName]; exists {
 					mainPackageInfo = &info
 					mainBuildID = info.BuildID
-					fmt.Printf("Found main package with BuildID: %s\n", mainBuildID)
+					mainFiles = cgoSources.SourceFiles(&cmd)
+					report.Printf("Found main package with BuildID: %s\n", mainBuildID)
 				}
 				break
 			}
@@ -1317,11 +948,12 @@ func processCompileWithHooks(commands []Command, hooksFile string) error {
 		runtimeDir := filepath.Join(workDir, mainBuildID)
 		if err := os.MkdirAll(runtimeDir, 0755); err == nil {
 			var err error
-			otelRuntimeFile, err = generateOtelRuntimeFile(runtimeDir, hooksImportPath)
+			otelRuntimeFile, err = generateOtelRuntimeFile(runtimeDir, hooksImportPath, hooks)
 			if err != nil {
-				fmt.Printf("⚠️  Failed to generate otel.runtime.go: %v\n", err)
+				report.Warnf("failed to generate otel.runtime.go: %v\n", err)
 			} else {
-				fmt.Printf("📄 Generated otel.runtime.go: %s\n", otelRuntimeFile)
+				report.Printf("📄 Generated otel.runtime.go: %s\n", otelRuntimeFile)
+				addMainShutdown(mainFiles, otelRuntimeFile, workDir, mainBuildID, fileReplacements)
 			}
 		}
 	}
@@ -1329,23 +961,30 @@ func processCompileWithHooks(commands []Command, hooksFile string) error {
 	// Generate modified build log with updated file paths
 	if len(fileReplacements) > 0 || len(generatedFilePaths) > 0 {
 		if err := generateModifiedBuildLog(commands, fileReplacements, trampolineFiles, generatedFilePaths, hooksImportPath, workDir, hooksFile, otelRuntimeFile, mainPackageInfo); err != nil {
-			fmt.Printf("⚠️  Failed to generate modified build log: %v\n", err)
+			report.Warnf("failed to generate modified build log: %v\n", err)
 		} else {
-			fmt.Printf("\n📄 Generated modified build log: %s\n", GetMetadataPath(BuildModifiedLogFile))
+			report.Printf("\n📄 Generated modified build log: %s\n", GetMetadataPath(BuildModifiedLogFile))
+			recordHeredocs(GetMetadataPath(BuildModifiedLogFile), ModifiedHeredocsDir)
 
 			// Save source mappings for dlv debugger
 			if err := saveSourceMappings(fileReplacements, workDir); err != nil {
-				fmt.Printf("⚠️  Failed to save source mappings: %v\n", err)
+				report.Warnf("failed to save source mappings: %v\n", err)
 			} else {
-				fmt.Printf("📄 Generated source mappings: %s\n", GetMetadataPath(SourceMappingsFile))
+				report.Printf("📄 Generated source mappings: %s\n", GetMetadataPath(SourceMappingsFile))
+			}
+			publishRun()
+
+			if noExecute {
+				reportSkippedExecution()
+				return nil
 			}
 
 			// Execute commands from the modified build log using existing functionality
-			fmt.Printf("\n🚀 Executing commands from modified build log...\n")
+			report.Printf("\n🚀 Executing commands from modified build log...\n")
 			if err := executeModifiedBuildLogWithParser(GetMetadataPath(BuildModifiedLogFile)); err != nil {
-				fmt.Printf("⚠️  Failed to execute modified build log: %v\n", err)
+				report.Warnf("failed to execute modified build log: %v\n", err)
 			} else {
-				fmt.Printf("✅ Successfully executed all commands from modified build log\n")
+				report.Printf("✅ Successfully executed all commands from modified build log\n")
 			}
 		}
 	}
@@ -1354,7 +993,7 @@ func processCompileWithHooks(commands []Command, hooksFile string) error {
 }
 
 // extractWorkDirFromCommands extracts the work directory from commands
-func extractWorkDirFromCommands(commands []Command) string {
+func extractWorkDirFromCommands(commands []parse.Command) string {
 	for _, cmd := range commands {
 		if workDir := extractWorkDir(cmd.Raw); workDir != "" {
 			return workDir
@@ -1363,6 +1002,19 @@ func extractWorkDirFromCommands(commands []Command) string {
 	return ""
 }
 
+// reportBodylessTarget tells when hooks target a function declared without a body, such as
+// one implemented in assembly: there is no Go code to wrap, so it is left as it is
+func reportBodylessTarget(packageName, file string, fn analyze.FunctionInfo, hooks []instrument.HookDefinition) {
+	if !fn.NoBody {
+		return
+	}
+	withBody := fn
+	withBody.NoBody = false
+	if instrument.MatchFunctionWithHooks(packageName, &withBody, hooks) != nil {
+		report.Printf("  ⏭️  SKIP: %s:%s has no Go body (implemented in assembly or linked with go:linkname)\n", filepath.Base(file), fn.Name)
+	}
+}
+
 // saveSourceMappings saves the file mappings to source-mappings.json for dlv debugger
 // It reads the WORK directory from go-build.log (matching what's in the compiled binary)
 // and copies instrumented source files to a permanent location (.debug-build/debug/).
@@ -1376,13 +1028,13 @@ func saveSourceMappings(fileReplacements map[string]string, currentWorkDir strin
 	if workDir == "" {
 		// Fall back to current work dir if go-build.log not found
 		workDir = currentWorkDir
-		fmt.Printf("⚠️  Could not read WORK dir from go-build.log, using current: %s\n", workDir)
+		report.Warnf("could not read WORK dir from go-build.log, using current: %s\n", workDir)
 	} else {
-		fmt.Printf("📍 Using WORK directory from go-build.log: %s\n", workDir)
+		report.Printf("📍 Using WORK directory from go-build.log: %s\n", workDir)
 	}
 
 	// Create permanent directory for instrumented sources
-	debugDir := ".debug-build/debug"
+	debugDir := filepath.Join(DebugBuildDir, "debug")
 	if err := os.MkdirAll(debugDir, 0755); err != nil {
 		return fmt.Errorf("failed to create debug directory: %w", err)
 	}
@@ -1414,18 +1066,18 @@ func saveSourceMappings(fileReplacements map[string]string, currentWorkDir strin
 
 		// Create parent directories
 		if err := os.MkdirAll(filepath.Dir(permanentPath), 0755); err != nil {
-			fmt.Printf("⚠️  Failed to create directory for %s: %v\n", permanentPath, err)
+			report.Warnf("failed to create directory for %s: %v\n", permanentPath, err)
 			continue
 		}
 
 		// Read instrumented file and copy to permanent location
 		content, err := os.ReadFile(instrumented)
 		if err != nil {
-			fmt.Printf("⚠️  Failed to read instrumented file %s: %v\n", instrumented, err)
+			report.Warnf("failed to read instrumented file %s: %v\n", instrumented, err)
 			continue
 		}
 		if err := os.WriteFile(permanentPath, content, 0644); err != nil {
-			fmt.Printf("⚠️  Failed to write instrumented file to %s: %v\n", permanentPath, err)
+			report.Warnf("failed to write instrumented file to %s: %v\n", permanentPath, err)
 			continue
 		}
 
@@ -1441,8 +1093,8 @@ func saveSourceMappings(fileReplacements map[string]string, currentWorkDir strin
 			absDebugDir = debugDir
 		}
 
-		fmt.Printf("📋 Copied instrumented source: %s -> %s\n", filepath.Base(original), absPermanentPath)
-		fmt.Printf("   Binary debug path: %s\n", binaryInstrumentedPath)
+		report.Debugf("Copied instrumented source: %s -> %s\n", filepath.Base(original), absPermanentPath)
+		report.Debugf("Binary debug path: %s\n", binaryInstrumentedPath)
 
 		mappings.Mappings = append(mappings.Mappings, SourceMapping{
 			Original:     absOriginal,
@@ -1470,7 +1122,12 @@ func saveSourceMappings(fileReplacements map[string]string, currentWorkDir strin
 
 // getWorkDirFromBuildLog reads the WORK directory from build-metadata/go-build.log
 func getWorkDirFromBuildLog() string {
-	file, err := os.Open(GetMetadataPath(BuildLogFile))
+	return workDirOfLog(GetMetadataPath(BuildLogFile))
+}
+
+// workDirOfLog reads the WORK directory from a build log, "" if it has none
+func workDirOfLog(path string) string {
+	file, err := os.Open(path)
 	if err != nil {
 		return ""
 	}
@@ -1495,7 +1152,7 @@ func generateSourceMappingsFromExisting() error {
 	if workDir == "" {
 		return fmt.Errorf("could not find WORK directory in go-build.log")
 	}
-	fmt.Printf("📍 Found WORK directory: %s\n", workDir)
+	report.Printf("📍 Found WORK directory: %s\n", workDir)
 
 	// Parse go-build-modified.log to find instrumented files
 	// Look for lines that reference the WORK directory with .go files
@@ -1507,7 +1164,7 @@ func generateSourceMappingsFromExisting() error {
 	defer modifiedLog.Close()
 
 	// Create debug directory
-	debugDir := ".debug-build/debug"
+	debugDir := filepath.Join(DebugBuildDir, "debug")
 	if err := os.MkdirAll(debugDir, 0755); err != nil {
 		return fmt.Errorf("failed to create debug directory: %w", err)
 	}
@@ -1572,7 +1229,7 @@ func generateSourceMappingsFromExisting() error {
 
 			// Create parent directories
 			if err := os.MkdirAll(filepath.Dir(permanentPath), 0755); err != nil {
-				fmt.Printf("⚠️  Failed to create directory for %s: %v\n", permanentPath, err)
+				report.Warnf("failed to create directory for %s: %v\n", permanentPath, err)
 				continue
 			}
 
@@ -1589,18 +1246,18 @@ func generateSourceMappingsFromExisting() error {
 			}
 
 			if copyErr != nil {
-				fmt.Printf("⚠️  Could not find source for %s (WORK dir may have been cleaned)\n", baseName)
+				report.Warnf("could not find source for %s (WORK dir may have been cleaned)\n", baseName)
 				// Still add the mapping even without the file
 			} else {
 				if err := os.WriteFile(permanentPath, content, 0644); err != nil {
-					fmt.Printf("⚠️  Failed to write %s: %v\n", permanentPath, err)
+					report.Warnf("failed to write %s: %v\n", permanentPath, err)
 				}
 			}
 
 			absPermanentPath, _ := filepath.Abs(permanentPath)
 			absDebugDir, _ := filepath.Abs(debugDir)
 
-			fmt.Printf("📋 Mapping: %s -> %s\n", baseName, instrumentedPath)
+			report.Printf("📋 Mapping: %s -> %s\n", baseName, instrumentedPath)
 
 			mappings.Mappings = append(mappings.Mappings, SourceMapping{
 				Original:     originalPath,
@@ -1629,16 +1286,26 @@ func generateSourceMappingsFromExisting() error {
 	if err := os.WriteFile(sourceMappingsPath, data, 0644); err != nil {
 		return fmt.Errorf("failed to write %s: %w", sourceMappingsPath, err)
 	}
+	linkRunFile(SourceMappingsFile)
 
-	fmt.Printf("✅ Generated source-mappings.json with %d mappings\n", len(mappings.Mappings))
+	report.Printf("✅ Generated source-mappings.json with %d mappings\n", len(mappings.Mappings))
 	return nil
 }
 
 // instrumentFile instruments a Go file with trampoline functions and calls
-func instrumentFile(sourceFile, targetFile string, packageName string, hooks []HookDefinition, hooksImportPath string) error {
+func instrumentFile(sourceFile, targetFile string, packageName string, hooks []instrument.HookDefinition, hooksImportPath string) error {
+	// Run the Rewrite functions of matching rewrite hooks first
+	rewrittenFile, rewrites, err := applyRewriteHooks(sourceFile, packageName, hooks)
+	if err != nil {
+		return err
+	}
+	if rewrittenFile != sourceFile {
+		defer os.Remove(rewrittenFile)
+	}
+
 	// Parse the source file
 	fset := token.NewFileSet()
-	node, err := parser.ParseFile(fset, sourceFile, nil, parser.ParseComments)
+	src, node, err := parseSourceFile(fset, rewrittenFile)
 	if err != nil {
 		return fmt.Errorf("failed to parse source file %s: %w", sourceFile, err)
 	}
@@ -1647,90 +1314,122 @@ func instrumentFile(sourceFile, targetFile string, packageName string, hooks []H
 	actualPackageName := node.Name.Name
 
 	// Track which hooks apply to functions in this file
-	var applicableHooks []HookDefinition
+	var applicableHooks []instrument.HookDefinition
 	var instrumentedFunctions []string
 	var rewrittenFunctions []string
+	var edits []declEdit                       // Modified functions, the only code reprinted
+	noInlineFunctions := make(map[string]bool) // Functions to annotate with //go:noinline
 
 	// Find functions that match hooks
-	for _, decl := range node.Decls {
+	for i, decl := range node.Decls {
 		if funcDecl, ok := decl.(*ast.FuncDecl); ok {
-			funcInfo := &FunctionInfo{
+			// Receiver is e.g. "*Server" for methods with a pointer receiver
+			funcInfo := &analyze.FunctionInfo{
 				Name:     funcDecl.Name.Name,
-				Receiver: "",
+				Receiver: analyze.ReceiverType(funcDecl),
+				FilePath: sourceFile,
+				NoBody:   funcDecl.Body == nil,
 			}
 
-			// Extract receiver if it's a method
-			if funcDecl.Recv != nil && len(funcDecl.Recv.List) > 0 {
-				if ident, ok := funcDecl.Recv.List[0].Type.(*ast.Ident); ok {
-					funcInfo.Receiver = ident.Name
+			// Check if this function matches any hook
+			if match := instrument.MatchFunctionWithHooks(packageName, funcInfo, hooks); match != nil {
+				if noInline {
+					noInlineFunctions[funcInfo.Receiver+"."+funcInfo.Name] = true
 				}
-			}
 
-			// Check if this function matches any hook
-			if match := matchFunctionWithHooks(packageName, funcInfo, hooks); match != nil {
+				edit := newDeclEdit(fset, funcDecl)
+				modified := false
 				switch match.Type {
 				case "before_after":
 					applicableHooks = append(applicableHooks, *match)
 					instrumentedFunctions = append(instrumentedFunctions, funcDecl.Name.Name)
 					instrumentFunction(funcDecl, match)
+					modified = true
 
 				case "rewrite":
-					if err := applyRewriteTransformation(funcDecl, match); err != nil {
-						fmt.Printf("           ⚠️  Failed to apply rewrite to %s: %v\n", funcDecl.Name.Name, err)
+					if err := rewriteFunction(funcDecl, match, rewrites, i); err != nil {
+						report.Warnf("failed to apply rewrite to %s: %v\n", funcDecl.Name.Name, err)
 					} else {
 						rewrittenFunctions = append(rewrittenFunctions, funcDecl.Name.Name)
+						modified = true
 					}
 
 				case "both":
 					// First apply rewrite, then add hooks
-					if err := applyRewriteTransformation(funcDecl, match); err != nil {
-						fmt.Printf("           ⚠️  Failed to apply rewrite to %s: %v\n", funcDecl.Name.Name, err)
+					if err := rewriteFunction(funcDecl, match, rewrites, i); err != nil {
+						report.Warnf("failed to apply rewrite to %s: %v\n", funcDecl.Name.Name, err)
 					} else {
 						rewrittenFunctions = append(rewrittenFunctions, funcDecl.Name.Name)
 					}
 					applicableHooks = append(applicableHooks, *match)
 					instrumentedFunctions = append(instrumentedFunctions, funcDecl.Name.Name)
 					instrumentFunction(funcDecl, match)
+					modified = true
+				}
+				if modified {
+					edits = append(edits, edit)
 				}
 			}
 		}
 	}
 
-	// Write the instrumented file
-	file, err := os.Create(targetFile)
+	// Reprint the modified functions, keeping the rest of the file as is
+	content, err := applyDeclEdits(src, fset, node, edits)
 	if err != nil {
-		return fmt.Errorf("failed to create target file %s: %w", targetFile, err)
+		return fmt.Errorf("failed to format instrumented file: %w", err)
+	}
+
+	// Annotate instrumented functions with //go:noinline if requested
+	if len(noInlineFunctions) > 0 {
+		content, err = insertNoInlineDirectives(content, noInlineFunctions)
+		if err != nil {
+			return fmt.Errorf("failed to add //go:noinline directives: %w", err)
+		}
 	}
-	defer file.Close()
 
-	if err := format.Node(file, fset, node); err != nil {
-		return fmt.Errorf("failed to format and write instrumented file: %w", err)
+	// Write the instrumented file
+	if content, err = withLineDirectives(sourceFile, markGenerated(content)); err != nil {
+		return err
+	}
+	if err := os.WriteFile(targetFile, content, 0644); err != nil {
+		return fmt.Errorf("failed to write instrumented file %s: %w", targetFile, err)
 	}
 
 	// Generate separate trampolines file if we have applicable hooks
 	if len(applicableHooks) > 0 {
 		targetDir := filepath.Dir(targetFile)
 		trampolinesFile := filepath.Join(targetDir, "otel_trampolines.go")
-		if err := generateTrampolinesFile(trampolinesFile, actualPackageName, applicableHooks, hooksImportPath); err != nil {
+		sharedHooks := packageTrampolineHooks(trampolinesFile, sourceFile, applicableHooks)
+		if err := generateTrampolinesFile(trampolinesFile, actualPackageName, sharedHooks, hooksImportPath); err != nil {
 			return fmt.Errorf("failed to generate trampolines file: %w", err)
 		}
-		fmt.Printf("           📄 Generated trampolines file: %s\n", trampolinesFile)
+		report.Printf("           📄 Generated trampolines file: %s\n", trampolinesFile)
 	}
 
 	if len(instrumentedFunctions) > 0 {
-		fmt.Printf("           🔧 Instrumented functions: %s\n", strings.Join(instrumentedFunctions, ", "))
+		report.Printf("           🔧 Instrumented functions: %s\n", strings.Join(instrumentedFunctions, ", "))
 	}
 
 	if len(rewrittenFunctions) > 0 {
-		fmt.Printf("           ✏️  Rewritten functions: %s\n", strings.Join(rewrittenFunctions, ", "))
+		report.Printf("           ✏️  Rewritten functions: %s\n", strings.Join(rewrittenFunctions, ", "))
 	}
 
 	return nil
 }
 
+// rewriteFunction applies a rewrite hook to the declaration at index in its file. Declarations
+// whose Rewrite function was run by applyRewriteHooks only report its outcome; the others
+// get the raw code extracted from the Rewrite function injected.
+func rewriteFunction(funcDecl *ast.FuncDecl, hook *instrument.HookDefinition, rewrites map[int]error, index int) error {
+	if err, ran := rewrites[index]; ran {
+		return err
+	}
+	return applyRewriteTransformation(funcDecl, hook)
+}
+
 // applyRewriteTransformation applies the rewrite transformation to a function
 // based on the extracted RawCodeToInject and other settings
-func applyRewriteTransformation(funcDecl *ast.FuncDecl, hook *HookDefinition) error {
+func applyRewriteTransformation(funcDecl *ast.FuncDecl, hook *instrument.HookDefinition) error {
 	if funcDecl.Body == nil {
 		return fmt.Errorf("function %s has no body", funcDecl.Name.Name)
 	}
@@ -1790,136 +1489,71 @@ func parseCodeSnippet(code string) ([]ast.Stmt, error) {
 	return nil, fmt.Errorf("no function found in parsed snippet")
 }
 
-// generateTrampolinesFile creates a separate file with trampoline functions and go:linkname declarations
-func generateTrampolinesFile(targetFile string, packageName string, hooks []HookDefinition, hooksImportPath string) error {
-	var sb strings.Builder
-
-	// Write package declaration
-	sb.WriteString(fmt.Sprintf("package %s\n\n", packageName))
-
-	// Write imports - unsafe for go:linkname and hooks for HookContext
-	sb.WriteString(`import (
-	_ "unsafe" // Required for go:linkname
-
-	"github.com/pdelewski/go-build-interceptor/hooks"
-)
-
-`)
-
-	fmt.Printf("           🔗 Using go:linkname to link to: %s\n", hooksImportPath)
-
-	// Generate trampolines for each hook
-	for _, hook := range hooks {
-		pascalName := capitalizeFirst(hook.Function)
-
-		// HookContextImpl struct - implements hooks.HookContext
-		sb.WriteString(fmt.Sprintf(`// HookContextImpl%s implements hooks.HookContext for %s
-type HookContextImpl%s struct {
-	data        interface{}
-	skipCall    bool
-	funcName    string
-	packageName string
-}
-
-func (c *HookContextImpl%s) SetData(data interface{}) { c.data = data }
-func (c *HookContextImpl%s) GetData() interface{}     { return c.data }
-func (c *HookContextImpl%s) SetSkipCall(skip bool)    { c.skipCall = skip }
-func (c *HookContextImpl%s) IsSkipCall() bool         { return c.skipCall }
-func (c *HookContextImpl%s) GetFuncName() string      { return c.funcName }
-func (c *HookContextImpl%s) GetPackageName() string   { return c.packageName }
+// trampolineHooks are the hooks of the trampolines files written so far, by path and source
+// file. The instrumented files of a package share its trampolines file, which is rewritten with
+// the hooks of every file as they are instrumented.
+var trampolineHooks = make(map[string]map[string][]instrument.HookDefinition)
 
-func (c *HookContextImpl%s) GetKeyData(key string) interface{} {
-	if c.data == nil {
-		return nil
-	}
-	if m, ok := c.data.(map[string]interface{}); ok {
-		return m[key]
+// packageTrampolineHooks records the hooks of a source file in the trampolines file of its
+// package and returns the hooks of all the files instrumented so far, in file order
+func packageTrampolineHooks(trampolinesFile, sourceFile string, hooks []instrument.HookDefinition) []instrument.HookDefinition {
+	if trampolineHooks[trampolinesFile] == nil {
+		trampolineHooks[trampolinesFile] = make(map[string][]instrument.HookDefinition)
 	}
-	return nil
-}
+	trampolineHooks[trampolinesFile][sourceFile] = hooks
 
-func (c *HookContextImpl%s) SetKeyData(key string, val interface{}) {
-	if c.data == nil {
-		c.data = make(map[string]interface{})
+	var files []string
+	for file := range trampolineHooks[trampolinesFile] {
+		files = append(files, file)
 	}
-	if m, ok := c.data.(map[string]interface{}); ok {
-		m[key] = val
+	sort.Strings(files)
+	var all []instrument.HookDefinition
+	for _, file := range files {
+		all = append(all, trampolineHooks[trampolinesFile][file]...)
 	}
+	return all
 }
 
-func (c *HookContextImpl%s) HasKeyData(key string) bool {
-	if c.data == nil {
-		return false
-	}
-	if m, ok := c.data.(map[string]interface{}); ok {
-		_, ok := m[key]
-		return ok
+// generateTrampolinesFile creates a separate file with trampoline functions and go:linkname declarations
+func generateTrampolinesFile(targetFile string, packageName string, hooks []instrument.HookDefinition, hooksImportPath string) error {
+	data := TrampolinesTemplateData{
+		PackageName:     packageName,
+		HooksImportPath: hooksImportPath,
 	}
-	return false
-}
-
-`, pascalName, hook.Function,
-			pascalName,
-			pascalName, pascalName, pascalName, pascalName, pascalName, pascalName,
-			pascalName, pascalName, pascalName))
-
-		// Before trampoline - calls the go:linkname function
-		sb.WriteString(fmt.Sprintf(`// OtelBeforeTrampoline_%s is the before trampoline for %s
-func OtelBeforeTrampoline_%s() (hookContext *HookContextImpl%s, skipCall bool) {
-	defer func() {
-		if err := recover(); err != nil {
-			println("failed to exec Before hook", "Before%s")
-		}
-	}()
-	hookContext = &HookContextImpl%s{}
-	hookContext.funcName = "%s"
-	hookContext.packageName = "%s"
-	Before%s(hookContext)
-	return hookContext, hookContext.skipCall
-}
-
-`, pascalName, hook.Function,
-			pascalName, pascalName,
-			pascalName,
-			pascalName,
-			hook.Function, hook.Package,
-			pascalName))
-
-		// After trampoline - calls the go:linkname function
-		sb.WriteString(fmt.Sprintf(`// OtelAfterTrampoline_%s is the after trampoline for %s
-func OtelAfterTrampoline_%s(hookContext hooks.HookContext) {
-	defer func() {
-		if err := recover(); err != nil {
-			println("failed to exec After hook", "After%s")
-		}
-	}()
-	After%s(hookContext)
-}
+	linked := make(map[string]bool)
+	for _, hook := range hooks {
+		hookData := newTrampolineHookData(hook, hooksImportPath)
+		data.Hooks = append(data.Hooks, hookData)
+		importPath := hookData.HooksImportPath
 
-`, pascalName, hook.Function,
-			pascalName,
-			pascalName,
-			pascalName))
+		if linked[importPath] {
+			continue
+		}
+		linked[importPath] = true
+		if codegenBackend == BackendShim {
+			report.Printf("           🔗 Using hooks dispatch table for: %s\n", importPath)
+		} else {
+			report.Printf("           🔗 Using go:linkname to link to: %s\n", importPath)
+		}
+	}
 
-		// go:linkname function declarations (link to external package)
-		sb.WriteString(fmt.Sprintf("//go:linkname Before%s %s.Before%s\n", pascalName, hooksImportPath, pascalName))
-		sb.WriteString(fmt.Sprintf("func Before%s(ctx hooks.HookContext)\n\n", pascalName))
-		sb.WriteString(fmt.Sprintf("//go:linkname After%s %s.After%s\n", pascalName, hooksImportPath, pascalName))
-		sb.WriteString(fmt.Sprintf("func After%s(ctx hooks.HookContext)\n\n", pascalName))
+	content, err := executeTemplate(trampolinesTemplateName(), data)
+	if err != nil {
+		return err
 	}
 
 	// Write to file
-	return os.WriteFile(targetFile, []byte(sb.String()), 0644)
+	return os.WriteFile(targetFile, markGenerated([]byte(content)), 0644)
 }
 
 // instrumentFunction adds trampoline calls to the beginning and end of a function
 // Uses the pattern: if hookContext, _ := OtelBeforeTrampoline_XXX(); false { } else { defer OtelAfterTrampoline_XXX(hookContext) }
-func instrumentFunction(funcDecl *ast.FuncDecl, hook *HookDefinition) {
+func instrumentFunction(funcDecl *ast.FuncDecl, hook *instrument.HookDefinition) {
 	if funcDecl.Body == nil {
 		return
 	}
 
-	pascalName := capitalizeFirst(hook.Function)
+	pascalName := trampolineName(*hook)
 	beforeTrampolineName := "OtelBeforeTrampoline_" + pascalName
 	afterTrampolineName := "OtelAfterTrampoline_" + pascalName
 
@@ -1938,22 +1572,77 @@ func instrumentFunction(funcDecl *ast.FuncDecl, hook *HookDefinition) {
 	}
 
 	// Create the instrumentation pattern:
-	// if hookContext, _ := OtelBeforeTrampoline_XXX(); false {
+	// if hookContext, _ := OtelBeforeTrampoline_XXX(recv, params...); false {
 	// } else {
-	//     defer OtelAfterTrampoline_XXX(hookContext)
+	//     defer func() {
+	//         panicValue := recover()
+	//         results, recovered := OtelAfterTrampoline_XXX(hookContext, panicValue, r0, r1)
+	//         if results != nil {
+	//             if v, ok := results[0].(T0); ok || results[0] == nil { r0 = v }
+	//             ...
+	//         }
+	//         if panicValue != nil && !recovered { panic(panicValue) }
+	//     }()
 	// }
+	// The after trampoline receives the panic of the call and the values actually returned;
+	// the values After hooks set with SetReturnValue replace them, and the panic goes on
+	// unless a hook recovered it.
+	const panicName, resultsName, recoveredName = "_hookPanic", "_hookResults", "_hookRecovered"
+	hookContextName := "hookContext" + pascalName
+	results := nameResults(funcDecl)
+	afterCall := &ast.CallExpr{
+		Fun:  ast.NewIdent(afterTrampolineName),
+		Args: append([]ast.Expr{ast.NewIdent(hookContextName), ast.NewIdent(panicName)}, results...),
+	}
+	returned := ast.NewIdent("_")
+	if len(results) > 0 {
+		returned = ast.NewIdent(resultsName)
+	}
+	deferBody := []ast.Stmt{
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{ast.NewIdent(panicName)},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{&ast.CallExpr{Fun: ast.NewIdent("recover")}},
+		},
+		&ast.AssignStmt{
+			Lhs: []ast.Expr{returned, ast.NewIdent(recoveredName)},
+			Tok: token.DEFINE,
+			Rhs: []ast.Expr{afterCall},
+		},
+	}
+	if len(results) > 0 {
+		deferBody = append(deferBody, overrideResults(resultsName, results, resultTypes(funcDecl)))
+	}
+	deferBody = append(deferBody, &ast.IfStmt{
+		Cond: &ast.BinaryExpr{
+			X:  &ast.BinaryExpr{X: ast.NewIdent(panicName), Op: token.NEQ, Y: ast.NewIdent("nil")},
+			Op: token.LAND,
+			Y:  &ast.UnaryExpr{Op: token.NOT, X: ast.NewIdent(recoveredName)},
+		},
+		Body: &ast.BlockStmt{List: []ast.Stmt{&ast.ExprStmt{X: &ast.CallExpr{
+			Fun:  ast.NewIdent("panic"),
+			Args: []ast.Expr{ast.NewIdent(panicName)},
+		}}}},
+	})
+	deferStmt := &ast.DeferStmt{Call: &ast.CallExpr{
+		Fun: &ast.FuncLit{
+			Type: &ast.FuncType{Params: &ast.FieldList{}},
+			Body: &ast.BlockStmt{List: deferBody},
+		},
+	}}
 
 	// The if statement with init
 	instrumentStmt := &ast.IfStmt{
 		Init: &ast.AssignStmt{
 			Lhs: []ast.Expr{
-				ast.NewIdent("hookContext" + pascalName),
+				ast.NewIdent(hookContextName),
 				ast.NewIdent("_"),
 			},
 			Tok: token.DEFINE,
 			Rhs: []ast.Expr{
 				&ast.CallExpr{
-					Fun: ast.NewIdent(beforeTrampolineName),
+					Fun:  ast.NewIdent(beforeTrampolineName),
+					Args: nameParams(funcDecl),
 				},
 			},
 		},
@@ -1962,16 +1651,7 @@ func instrumentFunction(funcDecl *ast.FuncDecl, hook *HookDefinition) {
 			List: []ast.Stmt{}, // Empty block for the "if false" branch
 		},
 		Else: &ast.BlockStmt{
-			List: []ast.Stmt{
-				&ast.DeferStmt{
-					Call: &ast.CallExpr{
-						Fun: ast.NewIdent(afterTrampolineName),
-						Args: []ast.Expr{
-							ast.NewIdent("hookContext" + pascalName),
-						},
-					},
-				},
-			},
+			List: []ast.Stmt{deferStmt},
 		},
 	}
 
@@ -1981,6 +1661,149 @@ func instrumentFunction(funcDecl *ast.FuncDecl, hook *HookDefinition) {
 	funcDecl.Body.List = newBody
 }
 
+// nameParams returns the receiver and parameters of a function as expressions for the before
+// trampoline call. Unnamed and blank ones are named, which doesn't change the function.
+func nameParams(funcDecl *ast.FuncDecl) []ast.Expr {
+	var args []ast.Expr
+	if funcDecl.Recv != nil {
+		args = append(args, nameFields(funcDecl.Recv, "_unnamedRecv%d")...)
+	}
+	return append(args, nameFields(funcDecl.Type.Params, "_unnamedParam%d")...)
+}
+
+// nameResults returns the results of a function as expressions for the after trampoline
+// call, naming unnamed and blank results so their returned values can be read in a defer
+func nameResults(funcDecl *ast.FuncDecl) []ast.Expr {
+	return nameFields(funcDecl.Type.Results, "_unnamedRetVal%d")
+}
+
+// resultTypes returns the type of every result of a function, in the order of nameResults
+func resultTypes(funcDecl *ast.FuncDecl) []ast.Expr {
+	var types []ast.Expr
+	for _, field := range funcDecl.Type.Results.List {
+		for range max(len(field.Names), 1) {
+			types = append(types, field.Type)
+		}
+	}
+	return types
+}
+
+// overrideResults returns the statement assigning the values After hooks returned in the
+// variable resultsName to the results of a function. A value of another type than the result
+// is ignored; nil sets the result to its zero value.
+func overrideResults(resultsName string, results, types []ast.Expr) ast.Stmt {
+	const valueName, okName = "_hookResult", "_hookResultOK"
+	var assignments []ast.Stmt
+	for i, result := range results {
+		value := &ast.IndexExpr{X: ast.NewIdent(resultsName), Index: &ast.BasicLit{Kind: token.INT, Value: strconv.Itoa(i)}}
+		assignments = append(assignments, &ast.IfStmt{
+			Init: &ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent(valueName), ast.NewIdent(okName)},
+				Tok: token.DEFINE,
+				Rhs: []ast.Expr{&ast.TypeAssertExpr{X: value, Type: types[i]}},
+			},
+			Cond: &ast.BinaryExpr{
+				X:  ast.NewIdent(okName),
+				Op: token.LOR,
+				Y:  &ast.BinaryExpr{X: value, Op: token.EQL, Y: ast.NewIdent("nil")},
+			},
+			Body: &ast.BlockStmt{List: []ast.Stmt{&ast.AssignStmt{
+				Lhs: []ast.Expr{ast.NewIdent(result.(*ast.Ident).Name)},
+				Tok: token.ASSIGN,
+				Rhs: []ast.Expr{ast.NewIdent(valueName)},
+			}}},
+		})
+	}
+	return &ast.IfStmt{
+		Cond: &ast.BinaryExpr{X: ast.NewIdent(resultsName), Op: token.NEQ, Y: ast.NewIdent("nil")},
+		Body: &ast.BlockStmt{List: assignments},
+	}
+}
+
+// nameFields gives the unnamed and blank fields of a field list names made from format and
+// their position, and returns identifiers for all of them
+func nameFields(fields *ast.FieldList, format string) []ast.Expr {
+	if fields == nil {
+		return nil
+	}
+	var idents []ast.Expr
+	idx := 0
+	for _, field := range fields.List {
+		if len(field.Names) == 0 {
+			field.Names = []*ast.Ident{ast.NewIdent(fmt.Sprintf(format, idx))}
+			idx++
+		}
+		for i, name := range field.Names {
+			if name.Name == "_" {
+				field.Names[i] = ast.NewIdent(fmt.Sprintf(format, idx))
+				idx++
+			}
+			idents = append(idents, ast.NewIdent(field.Names[i].Name))
+		}
+	}
+	return idents
+}
+
+// noInline controls whether instrumented functions are annotated with //go:noinline
+var noInline bool
+
+// SetNoInline enables or disables //go:noinline annotation of instrumented functions
+func SetNoInline(enabled bool) {
+	noInline = enabled
+}
+
+// insertNoInlineDirectives adds a //go:noinline line directly above each function
+// in src whose "receiver.name" key is in funcs. This works on the formatted source
+// rather than the AST because go/printer places synthesized comments unreliably.
+func insertNoInlineDirectives(src []byte, funcs map[string]bool) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+
+	// Collect line-start offsets of matching declarations
+	var offsets []int
+	for _, decl := range file.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		if !funcs[analyze.ReceiverType(funcDecl)+"."+funcDecl.Name.Name] {
+			continue
+		}
+
+		// Skip functions that already carry the directive
+		alreadyNoInline := false
+		if funcDecl.Doc != nil {
+			for _, c := range funcDecl.Doc.List {
+				if c.Text == "//go:noinline" {
+					alreadyNoInline = true
+				}
+			}
+		}
+		if alreadyNoInline {
+			continue
+		}
+
+		pos := fset.Position(funcDecl.Type.Func)
+		offsets = append(offsets, pos.Offset-(pos.Column-1))
+	}
+
+	// Insert from the end so earlier offsets stay valid
+	result := src
+	for i := len(offsets) - 1; i >= 0; i-- {
+		offset := offsets[i]
+		updated := make([]byte, 0, len(result)+len("//go:noinline\n"))
+		updated = append(updated, result[:offset]...)
+		updated = append(updated, "//go:noinline\n"...)
+		updated = append(updated, result[offset:]...)
+		result = updated
+	}
+
+	return result, nil
+}
+
 // capitalizeFirst capitalizes the first letter of a string
 func capitalizeFirst(s string) string {
 	if len(s) == 0 {
@@ -2000,16 +1823,22 @@ func stripTrimpath(command string) string {
 }
 
 // generateOtelRuntimeFile generates the otel.runtime.go file that imports the hooks package
-// This file is added to the main package to ensure the hooks package is compiled and linked
-func generateOtelRuntimeFile(targetDir string, hooksImportPath string) (string, error) {
-	var sb strings.Builder
-
-	sb.WriteString("// This file is generated by go-build-interceptor. DO NOT EDIT.\n")
-	sb.WriteString("package main\n\n")
-	sb.WriteString(fmt.Sprintf("import _ \"%s\" // Import hooks package to ensure it's compiled\n", hooksImportPath))
+// This file is added to the main package to ensure the hooks package is compiled and linked.
+// With the shim backend it also registers the hooks in the dispatch table.
+func generateOtelRuntimeFile(targetDir string, hooksImportPath string, hooks []instrument.HookDefinition) (string, error) {
+	hookData := trampolineHookData(hooks, hooksImportPath)
+	content, err := executeTemplate(otelRuntimeTemplateName(), OtelRuntimeTemplateData{
+		HooksImportPath: hooksImportPath,
+		HooksPackages:   hooksPackageData(hooksImportPath, hookData),
+		Hooks:           hookData,
+		PanicPolicy:     hookPanicPolicy,
+	})
+	if err != nil {
+		return "", err
+	}
 
 	targetFile := filepath.Join(targetDir, "otel.runtime.go")
-	if err := os.WriteFile(targetFile, []byte(sb.String()), 0644); err != nil {
+	if err := os.WriteFile(targetFile, markGenerated([]byte(content)), 0644); err != nil {
 		return "", fmt.Errorf("failed to write otel.runtime.go: %w", err)
 	}
 
@@ -2018,11 +1847,11 @@ func generateOtelRuntimeFile(targetDir string, hooksImportPath string) (string,
 
 // generateHooksCompileCommand generates a compile command for the generated_hooks package
 // Returns the compile commands (hooks lib + generated_hooks) and the output .a file path
-func generateHooksCompileCommand(commands []Command, hooksFile string, hooksImportPath string, workDir string) (string, string) {
+func generateHooksCompileCommand(commands []parse.Command, hooksFile string, hooksImportPath string, workDir string) (string, string) {
 	// Find a sample compile command to extract the compiler path and common flags
 	var sampleCmd string
 	for _, cmd := range commands {
-		if isCompileCommand(&cmd) {
+		if parse.IsCompileCommand(&cmd) {
 			sampleCmd = cmd.Raw
 			break
 		}
@@ -2054,7 +1883,7 @@ func generateHooksCompileCommand(commands []Command, hooksFile string, hooksImpo
 	// Find the hooks library package (github.com/pdelewski/go-build-interceptor/hooks)
 	hooksLibDir, hooksLibPkgFile, err := compileHooksLibrary(compilerPath, workDir, commands)
 	if err != nil {
-		fmt.Printf("           ⚠️  Failed to compile hooks library: %v\n", err)
+		report.Warnf("failed to compile hooks library: %v\n", err)
 		return "", ""
 	}
 	_ = hooksLibDir // suppress unused variable warning
@@ -2062,7 +1891,7 @@ func generateHooksCompileCommand(commands []Command, hooksFile string, hooksImpo
 	// Create importcfg for hooks package (including the hooks library)
 	importcfgPath := filepath.Join(hooksBuildDir, "importcfg")
 	if err := createHooksImportcfg(importcfgPath, commands, workDir, hooksLibPkgFile); err != nil {
-		fmt.Printf("           ⚠️  Failed to create hooks importcfg: %v\n", err)
+		report.Warnf("failed to create hooks importcfg: %v\n", err)
 		return "", ""
 	}
 
@@ -2093,8 +1922,8 @@ func generateHooksCompileCommand(commands []Command, hooksFile string, hooksImpo
 	return sb.String(), outputFile
 }
 
-// compileHooksLibrary compiles the github.com/pdelewski/go-build-interceptor/hooks package (types.go only)
-func compileHooksLibrary(compilerPath string, workDir string, commands []Command) (string, string, error) {
+// compileHooksLibrary compiles the github.com/pdelewski/go-build-interceptor/hooks package (dependency-free files only)
+func compileHooksLibrary(compilerPath string, workDir string, commands []parse.Command) (string, string, error) {
 	// Find the hooks library directory
 	// First try using the executable path to find the module
 	execPath, err := os.Executable()
@@ -2129,11 +1958,15 @@ func compileHooksLibrary(compilerPath string, workDir string, commands []Command
 		}
 	}
 
-	// Only compile types.go (lightweight, no dependencies)
+	// Only compile the lightweight files (types.go, dispatch.go) with no dependencies
 	// hooks.go has heavy dependencies (context, go/ast) that we don't need
-	typesFile := filepath.Join(hooksLibDir, "types.go")
-	if _, err := os.Stat(typesFile); os.IsNotExist(err) {
-		return "", "", fmt.Errorf("types.go not found in hooks library: %s", hooksLibDir)
+	var libFiles []string
+	for _, name := range hooksLibraryFiles() {
+		libFile := filepath.Join(hooksLibDir, name)
+		if _, err := os.Stat(libFile); os.IsNotExist(err) {
+			return "", "", fmt.Errorf("%s not found in hooks library: %s", name, hooksLibDir)
+		}
+		libFiles = append(libFiles, libFile)
 	}
 
 	// Create output directory
@@ -2142,7 +1975,7 @@ func compileHooksLibrary(compilerPath string, workDir string, commands []Command
 		return "", "", fmt.Errorf("failed to create hooks lib build dir: %w", err)
 	}
 
-	// Create importcfg for hooks library (no dependencies needed - types.go is self-contained)
+	// Create importcfg for hooks library (no dependencies needed - library files are self-contained)
 	importcfgPath := filepath.Join(hooksLibBuildDir, "importcfg")
 	if err := os.WriteFile(importcfgPath, []byte("# import config\n"), 0644); err != nil {
 		return "", "", fmt.Errorf("failed to create hooks lib importcfg: %w", err)
@@ -2151,7 +1984,7 @@ func compileHooksLibrary(compilerPath string, workDir string, commands []Command
 	// Output file path
 	outputFile := filepath.Join(hooksLibBuildDir, "_pkg_.a")
 
-	// Build the compile command - only compile types.go
+	// Build the compile command - only compile the dependency-free library files
 	var sb strings.Builder
 	sb.WriteString(compilerPath)
 	sb.WriteString(" -o ")
@@ -2159,12 +1992,15 @@ func compileHooksLibrary(compilerPath string, workDir string, commands []Command
 	sb.WriteString(" -p github.com/pdelewski/go-build-interceptor/hooks")
 	sb.WriteString(" -importcfg ")
 	sb.WriteString(importcfgPath)
-	sb.WriteString(" -pack ")
-	sb.WriteString(typesFile)
+	sb.WriteString(" -pack")
+	for _, libFile := range libFiles {
+		sb.WriteString(" ")
+		sb.WriteString(libFile)
+	}
 
 	// Execute the compile command
 	compileCmd := sb.String()
-	fmt.Printf("           📦 Compiling hooks library (types.go)...\n")
+	report.Printf("           📦 Compiling hooks library (%s)...\n", strings.Join(hooksLibraryFiles(), ", "))
 	execCmd := exec.Command("bash", "-c", compileCmd)
 	execCmd.Dir = hooksLibDir
 	if output, err := execCmd.CombinedOutput(); err != nil {
@@ -2175,12 +2011,12 @@ func compileHooksLibrary(compilerPath string, workDir string, commands []Command
 }
 
 // createMinimalImportcfg creates an importcfg with minimal dependencies
-func createMinimalImportcfg(path string, commands []Command, workDir string) error {
+func createMinimalImportcfg(path string, commands []parse.Command, workDir string) error {
 	// Find commonly used packages from existing compile commands
 	packagePaths := make(map[string]string)
 
 	for _, cmd := range commands {
-		if !isCompileCommand(&cmd) {
+		if !parse.IsCompileCommand(&cmd) {
 			continue
 		}
 
@@ -2201,22 +2037,21 @@ func createMinimalImportcfg(path string, commands []Command, workDir string) err
 		}
 	}
 
-	var sb strings.Builder
-	sb.WriteString("# import config\n")
+	importcfg := parse.NewImportcfg()
 	for pkgName, pkgPath := range packagePaths {
-		sb.WriteString(fmt.Sprintf("packagefile %s=%s\n", pkgName, pkgPath))
+		importcfg.SetPackageFile(pkgName, pkgPath)
 	}
 
-	return os.WriteFile(path, []byte(sb.String()), 0644)
+	return importcfg.WriteFile(path)
 }
 
 // createHooksImportcfg creates an importcfg file for the generated_hooks package
-func createHooksImportcfg(path string, commands []Command, workDir string, hooksLibPkgFile string) error {
+func createHooksImportcfg(path string, commands []parse.Command, workDir string, hooksLibPkgFile string) error {
 	// Find commonly used packages from existing compile commands
 	packagePaths := make(map[string]string)
 
 	for _, cmd := range commands {
-		if !isCompileCommand(&cmd) {
+		if !parse.IsCompileCommand(&cmd) {
 			continue
 		}
 
@@ -2240,20 +2075,19 @@ func createHooksImportcfg(path string, commands []Command, workDir string, hooks
 	}
 
 	// Write importcfg
-	var sb strings.Builder
-	sb.WriteString("# import config\n")
+	importcfg := parse.NewImportcfg()
 
 	// Add the hooks library package
 	if hooksLibPkgFile != "" {
-		sb.WriteString(fmt.Sprintf("packagefile github.com/pdelewski/go-build-interceptor/hooks=%s\n", hooksLibPkgFile))
+		importcfg.SetPackageFile(HooksLibraryImportPath, hooksLibPkgFile)
 	}
 
 	// Add all packages (the hooks package may need various dependencies)
 	for pkgName, pkgPath := range packagePaths {
-		sb.WriteString(fmt.Sprintf("packagefile %s=%s\n", pkgName, pkgPath))
+		importcfg.SetPackageFile(pkgName, pkgPath)
 	}
 
-	return os.WriteFile(path, []byte(sb.String()), 0644)
+	return importcfg.WriteFile(path)
 }
 
 // updateMainImportcfg updates the main package's importcfg to include the hooks package
@@ -2273,31 +2107,28 @@ func updateMainImportcfg(compileCmd string, hooksImportPath string, hooksPkgFile
 	}
 
 	// Read existing importcfg
-	content, err := os.ReadFile(importcfgPath)
+	importcfg, err := parse.ReadImportcfg(importcfgPath)
 	if err != nil {
 		return fmt.Errorf("failed to read importcfg: %w", err)
 	}
 
-	// Add the hooks package
-	newLine := fmt.Sprintf("packagefile %s=%s\n", hooksImportPath, hooksPkgFile)
-
 	// Check if already present
-	if strings.Contains(string(content), hooksImportPath) {
+	if file, ok := importcfg.PackageFile(hooksImportPath); ok && file == hooksPkgFile {
 		return nil
 	}
 
-	// Append to importcfg
-	newContent := string(content) + newLine
-	if err := os.WriteFile(importcfgPath, []byte(newContent), 0644); err != nil {
+	// Add the hooks package
+	importcfg.SetPackageFile(hooksImportPath, hooksPkgFile)
+	if err := importcfg.WriteFile(importcfgPath); err != nil {
 		return fmt.Errorf("failed to write importcfg: %w", err)
 	}
 
-	fmt.Printf("           📎 Updated importcfg to include hooks package: %s\n", hooksImportPath)
+	report.Printf("           📎 Updated importcfg to include hooks package: %s\n", hooksImportPath)
 	return nil
 }
 
 // copyAndInstrumentFileOnly copies and instruments a source file without replacing the original
-func copyAndInstrumentFileOnly(sourceFile string, workDir string, buildID string, packageName string, hooks []HookDefinition, hooksImportPath string) error {
+func copyAndInstrumentFileOnly(sourceFile string, workDir string, buildID string, packageName string, hooks []instrument.HookDefinition, hooksImportPath string) error {
 	if workDir == "" || buildID == "" {
 		return fmt.Errorf("missing work directory or build ID")
 	}
@@ -2317,17 +2148,38 @@ func copyAndInstrumentFileOnly(sourceFile string, workDir string, buildID string
 		return fmt.Errorf("failed to instrument file: %w", err)
 	}
 
-	fmt.Printf("           📄 Copied and instrumented %s to %s\n", sourceBaseName, targetFile)
+	report.Printf("           📄 Copied and instrumented %s to %s\n", sourceBaseName, targetFile)
 	return nil
 }
 
+// appendCompileFile adds a generated file to a compile command, unless the command already
+// compiles a file of that name: a package gets one trampolines file, one otel.runtime.go and
+// one copy of each generated file however often it is instrumented
+func appendCompileFile(command, file string) string {
+	for _, field := range strings.Fields(command) {
+		if filepath.Base(field) == filepath.Base(file) {
+			report.Debugf("Not adding %s to the compile command: it already compiles %s\n", file, field)
+			return command
+		}
+	}
+	return command + " " + file
+}
+
 // generateModifiedBuildLog generates a new build log with updated file paths for instrumented files
-func generateModifiedBuildLog(commands []Command, fileReplacements map[string]string, trampolineFiles map[string]string, generatedFilePaths map[string][]string, hooksImportPath string, workDir string, hooksFile string, otelRuntimeFile string, mainPackageInfo *PackagePathInfo) error {
+func generateModifiedBuildLog(commands []parse.Command, fileReplacements map[string]string, trampolineFiles map[string]string, generatedFilePaths map[string][]string, hooksImportPath string, workDir string, hooksFile string, otelRuntimeFile string, mainPackageInfo *PackagePathInfo) error {
 	if err := EnsureMetadataDir(); err != nil {
 		return fmt.Errorf("failed to create metadata directory: %w", err)
 	}
 	outputFile := GetMetadataPath(BuildModifiedLogFile)
 
+	if binaryOut != "" {
+		redirected, err := redirectBinaries(commands, binaryOut)
+		if err != nil {
+			return err
+		}
+		commands = redirected
+	}
+
 	file, err := os.Create(outputFile)
 	if err != nil {
 		return fmt.Errorf("failed to create modified build log: %w", err)
@@ -2342,7 +2194,7 @@ func generateModifiedBuildLog(commands []Command, fileReplacements map[string]st
 	if hooksFile != "" && workDir != "" && len(trampolineFiles) > 0 {
 		hooksCompileCmd, hooksPkgFile = generateHooksCompileCommand(commands, hooksFile, hooksImportPath, workDir)
 		if hooksCompileCmd != "" {
-			fmt.Printf("📦 Generated compile command for hooks package\n")
+			report.Printf("📦 Generated compile command for hooks package\n")
 		}
 	}
 
@@ -2355,35 +2207,46 @@ func generateModifiedBuildLog(commands []Command, fileReplacements map[string]st
 	// Track if we've inserted the hooks compile command
 	hooksCompileInserted := false
 
-	for _, cmd := range commands {
+	// Linkers of Go 1.23+ restrict the go:linkname used by the trampolines
+	goVersion := buildGoVersion(commands)
+	checkLinknameOff := len(trampolineFiles) > 0 && needsCheckLinknameOff(goVersion)
+
+	cgoSources := parse.NewCgoSources(commands)
+	cgoCommands := cgoCommandsByIndex(cgoSources)
+
+	for i, cmd := range commands {
 		modifiedCommand := cmd.Raw
 
+		// cgo translates the instrumented copies of the files of cgo packages
+		if invocation := cgoCommands[i]; invocation != nil {
+			modifiedCommand = instrumentCgoCommand(&cmd, invocation, modifiedCommand, fileReplacements)
+		}
+
 		// Check if this is an importcfg heredoc for main package
-		if cmd.IsMultiline && mainBuildID != "" && hooksPkgFile != "" {
+		if parse.IsImportcfgHeredoc(&cmd) && mainBuildID != "" && hooksPkgFile != "" {
 			// Check if this heredoc creates the main package's importcfg (compile or link)
-			if strings.Contains(modifiedCommand, "/"+mainBuildID+"/importcfg") &&
-				strings.Contains(modifiedCommand, "<< 'EOF'") {
-				// Inject the hooks packages before EOF
-				hooksPackageLine := fmt.Sprintf("packagefile %s=%s", hooksImportPath, hooksPkgFile)
-				hooksLibPkgFile := filepath.Join(workDir, "hooks_lib", "_pkg_.a")
-				hooksLibPackageLine := fmt.Sprintf("packagefile github.com/pdelewski/go-build-interceptor/hooks=%s", hooksLibPkgFile)
-
-				// Check if this is the link importcfg or compile importcfg
-				if strings.Contains(modifiedCommand, "importcfg.link") {
-					// For link, add both generated_hooks and hooks library
-					modifiedCommand = strings.Replace(modifiedCommand, "\nEOF\n", "\n"+hooksPackageLine+"\n"+hooksLibPackageLine+"\nEOF\n", 1)
-					fmt.Printf("           📎 Added packages to main importcfg.link heredoc\n")
+			if strings.Contains(cmd.Heredoc.Target, "/"+mainBuildID+"/importcfg") {
+				// For link and compile, add both generated_hooks and hooks library (trampolines import hooks)
+				importcfg := cmd.Importcfg()
+				importcfg.SetPackageFile(hooksImportPath, hooksPkgFile)
+				importcfg.SetPackageFile(HooksLibraryImportPath, filepath.Join(workDir, "hooks_lib", "_pkg_.a"))
+				modifiedCommand = cmd.WithImportcfg(importcfg).Raw
+				if strings.HasSuffix(cmd.Heredoc.Target, "importcfg.link") {
+					report.Printf("           📎 Added packages to main importcfg.link heredoc\n")
 				} else {
-					// For compile, add both generated_hooks and hooks library (trampolines import hooks)
-					modifiedCommand = strings.Replace(modifiedCommand, "\nEOF\n", "\n"+hooksPackageLine+"\n"+hooksLibPackageLine+"\nEOF\n", 1)
-					fmt.Printf("           📎 Added packages to main importcfg heredoc\n")
+					report.Printf("           📎 Added packages to main importcfg heredoc\n")
 				}
 			}
 		}
 
+		// Other packages with trampolines import the hooks library
+		if hooksPkgFile != "" {
+			modifiedCommand = addHooksLibraryToImportcfg(&cmd, modifiedCommand, trampolineFiles, mainBuildID, workDir)
+		}
+
 		// If this is a compile command, check if we need to replace any file paths
-		if isCompileCommand(&cmd) {
-			packageName := extractPackageName(&cmd)
+		if parse.IsCompileCommand(&cmd) {
+			packageName := parse.ExtractPackageName(&cmd)
 			needsTrampolineFile := false
 
 			// Insert hooks compile command before main package
@@ -2392,11 +2255,13 @@ func generateModifiedBuildLog(commands []Command, fileReplacements map[string]st
 					return fmt.Errorf("failed to write hooks compile command: %w", err)
 				}
 				hooksCompileInserted = true
-				fmt.Printf("           📎 Inserted hooks compile command before main\n")
+				report.Printf("           📎 Inserted hooks compile command before main\n")
 			}
 
 			// Check if this package has instrumented files
-			hasInstrumentedFiles := false
+			// Instrumented files of cgo packages are compiled as the files cgo generates from them
+			hasInstrumentedFiles := cgoFilesInstrumented(cgoSources.ForCompile(&cmd), fileReplacements)
+			needsTrampolineFile = hasInstrumentedFiles
 			for originalFile := range fileReplacements {
 				if strings.Contains(modifiedCommand, originalFile) || strings.Contains(modifiedCommand, filepath.Base(originalFile)) {
 					hasInstrumentedFiles = true
@@ -2408,7 +2273,7 @@ func generateModifiedBuildLog(commands []Command, fileReplacements map[string]st
 			// This preserves full WORK directory paths in the binary's debug info
 			if hasInstrumentedFiles {
 				modifiedCommand = stripTrimpath(modifiedCommand)
-				fmt.Printf("           🔧 Stripped -trimpath for package '%s' to preserve debug paths\n", packageName)
+				report.Debugf("Stripped -trimpath for package '%s' to preserve debug paths\n", packageName)
 			}
 
 			// Replace file paths in the command - but only for Go files
@@ -2443,8 +2308,8 @@ func generateModifiedBuildLog(commands []Command, fileReplacements map[string]st
 			if needsTrampolineFile {
 				if trampolinesFile, exists := trampolineFiles[packageName]; exists {
 					// Append the trampolines file at the end of the compile command
-					modifiedCommand = modifiedCommand + " " + trampolinesFile
-					fmt.Printf("           📎 Adding trampolines file to compile command for package '%s': %s\n", packageName, trampolinesFile)
+					modifiedCommand = appendCompileFile(modifiedCommand, trampolinesFile)
+					report.Printf("           📎 Adding trampolines file to compile command for package '%s': %s\n", packageName, trampolinesFile)
 
 					// Strip -complete flag as we have functions without body (go:linkname declarations)
 					modifiedCommand = strings.Replace(modifiedCommand, " -complete ", " ", 1)
@@ -2454,8 +2319,8 @@ func generateModifiedBuildLog(commands []Command, fileReplacements map[string]st
 			// Add generated files to compile command if this package has any
 			if genFiles, exists := generatedFilePaths[packageName]; exists && len(genFiles) > 0 {
 				for _, genFile := range genFiles {
-					modifiedCommand = modifiedCommand + " " + genFile
-					fmt.Printf("           📎 Adding generated file to compile command for package '%s': %s\n", packageName, filepath.Base(genFile))
+					modifiedCommand = appendCompileFile(modifiedCommand, genFile)
+					report.Printf("           📎 Adding generated file to compile command for package '%s': %s\n", packageName, filepath.Base(genFile))
 				}
 				// Strip -complete flag as we're adding generated files
 				modifiedCommand = strings.Replace(modifiedCommand, " -complete ", " ", 1)
@@ -2463,30 +2328,52 @@ func generateModifiedBuildLog(commands []Command, fileReplacements map[string]st
 
 			// Add otel.runtime.go to main package compile command
 			if packageName == "main" && otelRuntimeFile != "" {
-				modifiedCommand = modifiedCommand + " " + otelRuntimeFile
-				fmt.Printf("           📎 Adding otel.runtime.go to main package compile\n")
+				modifiedCommand = appendCompileFile(modifiedCommand, otelRuntimeFile)
+				report.Printf("           📎 Adding otel.runtime.go to main package compile\n")
 
 				// Strip -complete flag for main as well (otel.runtime.go might have import issues during initial compile)
 				modifiedCommand = strings.Replace(modifiedCommand, " -complete ", " ", 1)
 			}
 		}
 
+		if len(linkFlags) > 0 && parse.IsLinkCommand(&cmd) {
+			cmd = addLinkFlags(&cmd, linkFlags)
+			modifiedCommand = cmd.Raw
+			report.Printf("           🔗 Added %s to link command\n", strings.Join(linkFlags, " "))
+		}
+
+		if checkLinknameOff && parse.IsLinkCommand(&cmd) {
+			modifiedCommand = applyCheckLinknameOff(&cmd, modifiedCommand, goVersion)
+		}
+
+		// User-defined tweaks of --rules come last, so they can undo what hc added
+		modifiedCommand = applyCommandRules(&cmd, modifiedCommand)
+
 		// Write the (potentially modified) command to the new log file
 		if _, err := fmt.Fprintf(file, "%s\n", modifiedCommand); err != nil {
 			return fmt.Errorf("failed to write command to modified build log: %w", err)
 		}
 	}
+	reportCommandRules()
 
 	return nil
 }
 
 // generateModifiedBuildLogMultipleHooks generates a modified build log that compiles all hooks files together
-func generateModifiedBuildLogMultipleHooks(commands []Command, fileReplacements map[string]string, trampolineFiles map[string]string, generatedFilePaths map[string][]string, hooksImportPath string, workDir string, hooksFiles []string, otelRuntimeFile string, mainPackageInfo *PackagePathInfo) error {
+func generateModifiedBuildLogMultipleHooks(commands []parse.Command, fileReplacements map[string]string, trampolineFiles map[string]string, generatedFilePaths map[string][]string, hooksImportPath string, workDir string, hooksFiles []string, otelRuntimeFile string, mainPackageInfo *PackagePathInfo) error {
 	if err := EnsureMetadataDir(); err != nil {
 		return fmt.Errorf("failed to create metadata directory: %w", err)
 	}
 	outputFile := GetMetadataPath(BuildModifiedLogFile)
 
+	if binaryOut != "" {
+		redirected, err := redirectBinaries(commands, binaryOut)
+		if err != nil {
+			return err
+		}
+		commands = redirected
+	}
+
 	file, err := os.Create(outputFile)
 	if err != nil {
 		return fmt.Errorf("failed to create modified build log: %w", err)
@@ -2496,11 +2383,11 @@ func generateModifiedBuildLogMultipleHooks(commands []Command, fileReplacements
 	// Generate compile command for hooks package (compiling all hooks files together)
 	// Only needed when we have before_after or both hooks (trampolineFiles is not empty)
 	hooksCompileCmd := ""
-	hooksPkgFile := ""
+	var hooksPackages []HooksPackageBuild
 	if len(hooksFiles) > 0 && workDir != "" && len(trampolineFiles) > 0 {
-		hooksCompileCmd, hooksPkgFile = generateHooksCompileCommandMultiple(commands, hooksFiles, hooksImportPath, workDir)
+		hooksCompileCmd, hooksPackages = generateHooksCompileCommandMultiple(commands, hooksFiles, hooksImportPath, workDir)
 		if hooksCompileCmd != "" {
-			fmt.Printf("📦 Generated compile command for hooks package (multiple files)\n")
+			report.Printf("📦 Generated compile commands for %d hooks package(s)\n", len(hooksPackages))
 		}
 	}
 
@@ -2512,27 +2399,38 @@ func generateModifiedBuildLogMultipleHooks(commands []Command, fileReplacements
 
 	hooksCompileInserted := false
 
-	for _, cmd := range commands {
+	// Linkers of Go 1.23+ restrict the go:linkname used by the trampolines
+	goVersion := buildGoVersion(commands)
+	checkLinknameOff := len(trampolineFiles) > 0 && needsCheckLinknameOff(goVersion)
+
+	cgoSources := parse.NewCgoSources(commands)
+	cgoCommands := cgoCommandsByIndex(cgoSources)
+
+	for i, cmd := range commands {
 		modifiedCommand := cmd.Raw
 
+		// cgo translates the instrumented copies of the files of cgo packages
+		if invocation := cgoCommands[i]; invocation != nil {
+			modifiedCommand = instrumentCgoCommand(&cmd, invocation, modifiedCommand, fileReplacements)
+		}
+
 		// Check if this is an importcfg heredoc for main package
-		if cmd.IsMultiline && mainBuildID != "" && hooksPkgFile != "" {
-			if strings.Contains(modifiedCommand, "/"+mainBuildID+"/importcfg") &&
-				strings.Contains(modifiedCommand, "<< 'EOF'") {
-				hooksPackageLine := fmt.Sprintf("packagefile %s=%s", hooksImportPath, hooksPkgFile)
-				hooksLibPkgFile := filepath.Join(workDir, "hooks_lib", "_pkg_.a")
-				hooksLibPackageLine := fmt.Sprintf("packagefile github.com/pdelewski/go-build-interceptor/hooks=%s", hooksLibPkgFile)
-
-				if strings.Contains(modifiedCommand, "importcfg.link") {
-					modifiedCommand = strings.Replace(modifiedCommand, "\nEOF\n", "\n"+hooksPackageLine+"\n"+hooksLibPackageLine+"\nEOF\n", 1)
-				} else {
-					modifiedCommand = strings.Replace(modifiedCommand, "\nEOF\n", "\n"+hooksPackageLine+"\n"+hooksLibPackageLine+"\nEOF\n", 1)
+		if parse.IsImportcfgHeredoc(&cmd) && mainBuildID != "" && len(hooksPackages) > 0 {
+			if strings.Contains(cmd.Heredoc.Target, "/"+mainBuildID+"/importcfg") {
+				importcfg := cmd.Importcfg()
+				for _, pkg := range hooksPackages {
+					importcfg.SetPackageFile(pkg.ImportPath, pkg.Archive)
 				}
+				importcfg.SetPackageFile(HooksLibraryImportPath, filepath.Join(workDir, "hooks_lib", "_pkg_.a"))
+				modifiedCommand = cmd.WithImportcfg(importcfg).Raw
 			}
 		}
+		if len(hooksPackages) > 0 {
+			modifiedCommand = addHooksLibraryToImportcfg(&cmd, modifiedCommand, trampolineFiles, mainBuildID, workDir)
+		}
 
-		if isCompileCommand(&cmd) {
-			packageName := extractPackageName(&cmd)
+		if parse.IsCompileCommand(&cmd) {
+			packageName := parse.ExtractPackageName(&cmd)
 			needsTrampolineFile := false
 
 			// Insert hooks compile command before main package
@@ -2543,7 +2441,9 @@ func generateModifiedBuildLogMultipleHooks(commands []Command, fileReplacements
 				hooksCompileInserted = true
 			}
 
-			hasInstrumentedFiles := false
+			// Instrumented files of cgo packages are compiled as the files cgo generates from them
+			hasInstrumentedFiles := cgoFilesInstrumented(cgoSources.ForCompile(&cmd), fileReplacements)
+			needsTrampolineFile = hasInstrumentedFiles
 			for originalFile := range fileReplacements {
 				if strings.Contains(modifiedCommand, originalFile) || strings.Contains(modifiedCommand, filepath.Base(originalFile)) {
 					hasInstrumentedFiles = true
@@ -2575,136 +2475,296 @@ func generateModifiedBuildLogMultipleHooks(commands []Command, fileReplacements
 
 			if needsTrampolineFile {
 				if trampolinesFile, exists := trampolineFiles[packageName]; exists {
-					modifiedCommand = modifiedCommand + " " + trampolinesFile
+					modifiedCommand = appendCompileFile(modifiedCommand, trampolinesFile)
 					modifiedCommand = strings.Replace(modifiedCommand, " -complete ", " ", 1)
 				}
 			}
 
 			if genFiles, exists := generatedFilePaths[packageName]; exists && len(genFiles) > 0 {
 				for _, genFile := range genFiles {
-					modifiedCommand = modifiedCommand + " " + genFile
+					modifiedCommand = appendCompileFile(modifiedCommand, genFile)
 				}
 				modifiedCommand = strings.Replace(modifiedCommand, " -complete ", " ", 1)
 			}
 
 			if packageName == "main" && otelRuntimeFile != "" {
-				modifiedCommand = modifiedCommand + " " + otelRuntimeFile
+				modifiedCommand = appendCompileFile(modifiedCommand, otelRuntimeFile)
 				modifiedCommand = strings.Replace(modifiedCommand, " -complete ", " ", 1)
 			}
 		}
 
+		if len(linkFlags) > 0 && parse.IsLinkCommand(&cmd) {
+			cmd = addLinkFlags(&cmd, linkFlags)
+			modifiedCommand = cmd.Raw
+			report.Printf("           🔗 Added %s to link command\n", strings.Join(linkFlags, " "))
+		}
+
+		if checkLinknameOff && parse.IsLinkCommand(&cmd) {
+			modifiedCommand = applyCheckLinknameOff(&cmd, modifiedCommand, goVersion)
+		}
+
+		modifiedCommand = applyCommandRules(&cmd, modifiedCommand)
+
 		if _, err := fmt.Fprintf(file, "%s\n", modifiedCommand); err != nil {
 			return fmt.Errorf("failed to write command to modified build log: %w", err)
 		}
 	}
+	reportCommandRules()
 
 	return nil
 }
 
-// generateHooksCompileCommandMultiple generates a compile command for multiple hooks files
-func generateHooksCompileCommandMultiple(commands []Command, hooksFiles []string, hooksImportPath string, workDir string) (string, string) {
+// generateHooksCompileCommandMultiple generates compile commands for the packages of multiple
+// hooks files, one command per package. It returns the commands (one per line) and the packages
+// with the compiled package files.
+func generateHooksCompileCommandMultiple(commands []parse.Command, hooksFiles []string, hooksImportPath string, workDir string) (string, []HooksPackageBuild) {
 	if len(hooksFiles) == 0 {
-		return "", ""
+		return "", nil
 	}
 
 	// Find a sample compile command
 	var sampleCmd string
 	for _, cmd := range commands {
-		if isCompileCommand(&cmd) {
+		if parse.IsCompileCommand(&cmd) {
 			sampleCmd = cmd.Raw
 			break
 		}
 	}
 	if sampleCmd == "" {
-		return "", ""
+		return "", nil
 	}
 
 	parts := strings.Fields(sampleCmd)
 	if len(parts) < 1 {
-		return "", ""
+		return "", nil
 	}
 	compilerPath := parts[0]
 
-	// Only compile files from the first (primary) hooks directory
-	// Other hooks files are only used for extracting hook definitions, not for compilation
-	// This is because different hooks files may be in different packages
-	primaryHooksDir := filepath.Dir(hooksFiles[0])
-	goFiles, err := filepath.Glob(filepath.Join(primaryHooksDir, "*.go"))
+	// Compile hooks library
+	hooksLibDir, hooksLibPkgFile, err := compileHooksLibrary(compilerPath, workDir, commands)
 	if err != nil {
-		return "", ""
+		report.Warnf("failed to compile hooks library: %v\n", err)
+		return "", nil
 	}
+	_ = hooksLibDir
 
-	var allGoFiles []string
-	for _, goFile := range goFiles {
-		if strings.HasSuffix(goFile, "_test.go") {
+	var compileCmds []string
+	var packages []HooksPackageBuild
+	callDirs := callHooksDirs(hooksFiles)
+	for i, pkg := range hooksPackageBuilds(hooksFiles, hooksImportPath) {
+		if !callDirs[pkg.Dir] {
+			continue
+		}
+		goFiles, err := filepath.Glob(filepath.Join(pkg.Dir, "*.go"))
+		if err != nil {
 			continue
 		}
-		allGoFiles = append(allGoFiles, goFile)
-	}
 
-	if len(allGoFiles) == 0 {
-		return "", ""
-	}
+		var allGoFiles []string
+		for _, goFile := range goFiles {
+			if strings.HasSuffix(goFile, "_test.go") {
+				continue
+			}
+			allGoFiles = append(allGoFiles, goFile)
+		}
 
-	hooksBuildDir := filepath.Join(workDir, "hooks_pkg")
-	if err := os.MkdirAll(hooksBuildDir, 0755); err != nil {
-		return "", ""
-	}
+		if len(allGoFiles) == 0 {
+			continue
+		}
 
-	// Compile hooks library
-	hooksLibDir, hooksLibPkgFile, err := compileHooksLibrary(compilerPath, workDir, commands)
-	if err != nil {
-		fmt.Printf("           ⚠️  Failed to compile hooks library: %v\n", err)
-		return "", ""
-	}
-	_ = hooksLibDir
+		// The primary package keeps the hooks_pkg directory used for a single hooks file
+		hooksBuildDir := filepath.Join(workDir, "hooks_pkg")
+		if i > 0 {
+			hooksBuildDir = filepath.Join(workDir, fmt.Sprintf("hooks_pkg_%d", i+1))
+		}
+		if err := os.MkdirAll(hooksBuildDir, 0755); err != nil {
+			continue
+		}
 
-	importcfgPath := filepath.Join(hooksBuildDir, "importcfg")
-	if err := createHooksImportcfg(importcfgPath, commands, workDir, hooksLibPkgFile); err != nil {
-		fmt.Printf("           ⚠️  Failed to create hooks importcfg: %v\n", err)
-		return "", ""
-	}
+		importcfgPath := filepath.Join(hooksBuildDir, "importcfg")
+		if err := createHooksImportcfg(importcfgPath, commands, workDir, hooksLibPkgFile); err != nil {
+			report.Warnf("failed to create hooks importcfg: %v\n", err)
+			continue
+		}
 
-	outputFile := filepath.Join(hooksBuildDir, "_pkg_.a")
+		outputFile := filepath.Join(hooksBuildDir, "_pkg_.a")
 
-	var sb strings.Builder
-	sb.WriteString(compilerPath)
-	sb.WriteString(" -o ")
-	sb.WriteString(outputFile)
-	sb.WriteString(" -p ")
-	sb.WriteString(hooksImportPath)
-	sb.WriteString(" -importcfg ")
-	sb.WriteString(importcfgPath)
-	sb.WriteString(" -pack")
+		var sb strings.Builder
+		sb.WriteString(compilerPath)
+		sb.WriteString(" -o ")
+		sb.WriteString(outputFile)
+		sb.WriteString(" -p ")
+		sb.WriteString(pkg.ImportPath)
+		sb.WriteString(" -importcfg ")
+		sb.WriteString(importcfgPath)
+		sb.WriteString(" -pack")
 
-	for _, goFile := range allGoFiles {
-		sb.WriteString(" ")
-		sb.WriteString(goFile)
-	}
+		for _, goFile := range allGoFiles {
+			sb.WriteString(" ")
+			sb.WriteString(goFile)
+		}
 
-	fmt.Printf("           📦 Compiling %d Go files from primary hooks package\n", len(allGoFiles))
+		report.Printf("           📦 Compiling %d Go files from hooks package %s\n", len(allGoFiles), pkg.ImportPath)
 
-	return sb.String(), outputFile
+		pkg.Archive = outputFile
+		packages = append(packages, pkg)
+		compileCmds = append(compileCmds, sb.String())
+	}
+
+	return strings.Join(compileCmds, "\n"), packages
 }
 
 // executeModifiedBuildLogWithParser executes the modified build log using the existing Parser functionality
 func executeModifiedBuildLogWithParser(logFile string) error {
 	// Create a new parser and parse the modified log file
-	modifiedParser := NewParser()
+	modifiedParser := parse.NewParser()
+	modifiedParser.SetOutput(report.Log.Output())
+	modifiedParser.SetLogger(report.Log)
+	applyReplayToolchain(modifiedParser)
 	if err := modifiedParser.ParseFile(logFile); err != nil {
 		return fmt.Errorf("failed to parse modified log file: %w", err)
 	}
 
+	// Reuse the archives of packages compiled with the same inputs by earlier builds
+	if buildCacheEnabled {
+		commands, run, err := applyBuildCache(modifiedParser.GetCommands())
+		if err != nil {
+			return fmt.Errorf("failed to apply build cache: %w", err)
+		}
+		modifiedParser.SetCommands(commands)
+		// Entries are stored only by the commands that succeed, so they are shared even when
+		// the build fails later
+		defer finishBuildCache(run)
+	}
+
 	// Generate the script but don't execute it yet
-	if err := modifiedParser.GenerateScript(); err != nil {
+	if err := modifiedParser.GenerateScript(GetMetadataPath(ReplayScriptFile)); err != nil {
 		return fmt.Errorf("failed to generate script from modified log file: %w", err)
 	}
+	linkRunFile(ReplayScriptFile)
+
+	if !instrumentStart.IsZero() {
+		recordPhase(PhaseInstrument, instrumentStart, nil)
+	}
+	defer saveBuildProfile()
 
 	// Now execute the script with proper error handling
-	fmt.Printf("Generated script from modified build log. Running replay_script.sh...\n")
-	if err := modifiedParser.ExecuteScript(); err != nil {
+	start := time.Now()
+	if replayJobs > 1 {
+		report.Printf("Generated script from modified build log. Replaying it with %d jobs...\n", replayJobs)
+		modifiedParser.SetMemoryBudget(replayMemoryBudget)
+		modifiedParser.SetWorkers(replayWorkers)
+		err := modifiedParser.ExecuteParallel(replayJobs)
+		recordReplay(modifiedParser, start, err)
+		if err != nil {
+			return fmt.Errorf("failed to execute modified build log: %w", err)
+		}
+		reportBinaryOutputs(modifiedParser.GetCommands())
+		return nil
+	}
+	var err error
+	if replayNative {
+		report.Printf("Generated script from modified build log. Replaying it natively...\n")
+		err = modifiedParser.ExecuteNative()
+	} else {
+		report.Printf("Generated script from modified build log. Running replay_script.sh...\n")
+		err = modifiedParser.ExecuteScript(GetMetadataPath(ReplayScriptFile))
+	}
+	recordReplay(modifiedParser, start, err)
+	if err != nil {
 		return fmt.Errorf("failed to execute modified build script: %w", err)
 	}
+	reportBinaryOutputs(modifiedParser.GetCommands())
 
 	return nil
 }
+
+// noExecute stops compile mode after writing the modified build log (--no-execute)
+var noExecute bool
+
+// SetNoExecute makes compile mode write the modified build log and the replay script
+// without executing them
+func SetNoExecute(enabled bool) {
+	noExecute = enabled
+}
+
+// reportSkippedExecution writes the replay script of the modified build log and tells how to
+// execute it, for --no-execute
+func reportSkippedExecution() {
+	modifiedParser := parse.NewParser()
+	modifiedParser.SetOutput(report.Log.Output())
+	modifiedParser.SetLogger(report.Log)
+	applyReplayToolchain(modifiedParser)
+	if err := modifiedParser.ParseFile(GetMetadataPath(BuildModifiedLogFile)); err == nil {
+		if err := modifiedParser.GenerateScript(GetMetadataPath(ReplayScriptFile)); err != nil {
+			report.Warnf("failed to generate replay script: %v\n", err)
+		} else {
+			linkRunFile(ReplayScriptFile)
+		}
+	}
+	report.Printf("\n⏸️  Not executing the modified build log (--no-execute). To build, run:\n")
+	if currentRun != "" {
+		report.Printf("   hc --execute --run-id %s\n", currentRun)
+		return
+	}
+	report.Printf("   hc --execute --log %s\n", GetMetadataPath(BuildModifiedLogFile))
+}
+
+// replayJobs is the number of build actions replayed in parallel (-j)
+var replayJobs = 1
+
+// SetReplayJobs sets the number of build actions replayed in parallel. With 1 the replay
+// script runs sequentially.
+func SetReplayJobs(jobs int) {
+	replayJobs = jobs
+}
+
+// replayNative replays sequential builds in hc instead of running the replay script with
+// bash (--native)
+var replayNative bool
+
+// SetReplayNative makes sequential replays interpret the build log instead of running bash
+func SetReplayNative(enabled bool) {
+	replayNative = enabled
+}
+
+// replayMemoryBudget limits the memory of the build actions replayed in parallel
+// (--memory-budget, --memory-hints)
+var replayMemoryBudget parse.MemoryBudget
+
+// SetReplayMemoryBudget sets the memory budget of parallel replays
+func SetReplayMemoryBudget(budget parse.MemoryBudget) {
+	replayMemoryBudget = budget
+}
+
+// replayWorkers are the machines parallel replays distribute compile actions to (--workers)
+var replayWorkers []parse.Worker
+
+// SetReplayWorkers sets the workers of parallel replays
+func SetReplayWorkers(workers []parse.Worker) {
+	replayWorkers = workers
+}
+
+// executeReplay writes the replay script of parser's commands to the run and replays them,
+// in parallel when more than one job is allowed. The replay is recorded in the build profile.
+func executeReplay(parser *parse.Parser) error {
+	scriptPath := GetMetadataPath(ReplayScriptFile)
+	if err := parser.GenerateScript(scriptPath); err != nil {
+		return err
+	}
+	linkRunFile(ReplayScriptFile)
+	start := time.Now()
+	var err error
+	if replayNative {
+		err = parser.ExecuteNative()
+	} else if replayJobs <= 1 {
+		err = parser.ExecuteScript(scriptPath)
+	} else {
+		parser.SetMemoryBudget(replayMemoryBudget)
+		parser.SetWorkers(replayWorkers)
+		err = parser.ExecuteParallel(replayJobs)
+	}
+	recordReplay(parser, start, err)
+	saveBuildProfile()
+	return err
+}
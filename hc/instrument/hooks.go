@@ -0,0 +1,610 @@
+// Package instrument loads hook definitions from hooks files and matches them against
+// functions. Generating instrumented code from the matches is done by the hc command.
+package instrument
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strconv"
+	"strings"
+)
+
+// HookDefinition represents a parsed hook from the hooks file
+type HookDefinition struct {
+	Package  string
+	Function string
+	Receiver string
+	File     string // Pattern of the source files whose functions are targeted (InjectTarget.File)
+	Type     string // "before_after", "rewrite", or "both"
+	Priority int    // Order among the hooks matching the same function (hooks.Hook.Priority)
+
+	// Names of the Before/After functions in the hooks package (InjectFunctions). Hooks
+	// with an exact target may leave them out and use Before<Function>/After<Function>.
+	BeforeFunc string
+	AfterFunc  string
+	NamedFuncs bool // Only the functions named are called, as for the hooks of manifests
+
+	// Rewrite-specific fields (extracted from Rewrite function AST)
+	RewriteFuncName    string // Name of the Rewrite function (e.g., "RewriteNewproc1")
+	RawCodeToInject    string // Raw code string to inject
+	RenameReturnValues bool   // Whether to rename unnamed return values
+	InjectPosition     string // "start" or "defer" - where to inject the code
+
+	// Origin of the hook when several hooks files are compiled together
+	HooksFile       string // Hooks file the hook was loaded from
+	HooksImportPath string // Import path of the package implementing Before/After (empty: the primary hooks package)
+
+	// Import path of the main package the hook targets, when ResolveMainPackage retargeted it
+	// to "main", the -p value of the compile commands of main packages
+	PackagePath string
+
+	// Further hooks matching the function, whose Before/After functions are called after
+	// this hook's Before and before its After (set by MatchFunctionWithHooks)
+	Chain []HookDefinition
+}
+
+// ParseHooksFile parses a Go file containing hook definitions and extracts hook information
+func ParseHooksFile(hooksFile string) ([]HookDefinition, error) {
+	var hooks []HookDefinition
+
+	if IsHooksManifest(hooksFile) {
+		manifest, err := LoadHooksManifest(hooksFile)
+		if err != nil {
+			return nil, err
+		}
+		hooks = manifest.HookDefinitions()
+		if len(hooks) == 0 {
+			return nil, fmt.Errorf("%w in %s", ErrNoHooks, hooksFile)
+		}
+		if err := ValidateHookPatterns(hooks); err != nil {
+			return nil, fmt.Errorf("%s: %w", hooksFile, err)
+		}
+		if err := checkManifestFunctions(hooksFile, hooks); err != nil {
+			return nil, err
+		}
+		return hooks, nil
+	}
+
+	// Parse the hooks file
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, hooksFile, nil, parser.ParseComments)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing hooks file %s: %w", hooksFile, err)
+	}
+
+	// Find ProvideHooks function
+	for _, decl := range node.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Name.Name != "ProvideHooks" {
+			continue
+		}
+
+		// Check the hook literals and the functions they name, then extract the hooks
+		if err := checkHooksFile(fset, hooksFile, node, funcDecl); err != nil {
+			return nil, err
+		}
+		hooks = extractHooksFromFunction(funcDecl)
+		break
+	}
+
+	if len(hooks) == 0 {
+		return nil, fmt.Errorf("%w in %s", ErrNoHooks, hooksFile)
+	}
+	if err := ValidateHookPatterns(hooks); err != nil {
+		return nil, fmt.Errorf("%s: %w", hooksFile, err)
+	}
+
+	return hooks, nil
+}
+
+// extractHooksFromFunction extracts hook definitions from ProvideHooks function
+func extractHooksFromFunction(funcDecl *ast.FuncDecl) []HookDefinition {
+	var hooks []HookDefinition
+
+	// Walk through the function body
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		// Look for composite literals that represent Hook structs
+		compLit, ok := n.(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+
+		// Check if this is a Hook struct
+		hook := parseHookFromCompositeLit(compLit)
+		if hook != nil {
+			hooks = append(hooks, *hook)
+		}
+
+		return true
+	})
+
+	return hooks
+}
+
+// parseHookFromCompositeLit parses a Hook struct from a composite literal
+func parseHookFromCompositeLit(lit *ast.CompositeLit) *HookDefinition {
+	hook := &HookDefinition{}
+	hasTarget := false
+	hasHooks := false
+	hasRewrite := false
+
+	for _, elt := range lit.Elts {
+		kvExpr, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+
+		key, ok := kvExpr.Key.(*ast.Ident)
+		if !ok {
+			continue
+		}
+
+		switch key.Name {
+		case "Target":
+			// Parse InjectTarget
+			if targetLit, ok := kvExpr.Value.(*ast.CompositeLit); ok {
+				for _, targetElt := range targetLit.Elts {
+					targetKV, ok := targetElt.(*ast.KeyValueExpr)
+					if !ok {
+						continue
+					}
+
+					targetKey, ok := targetKV.Key.(*ast.Ident)
+					if !ok {
+						continue
+					}
+
+					switch targetKey.Name {
+					case "Package":
+						if lit, ok := targetKV.Value.(*ast.BasicLit); ok {
+							hook.Package = strings.Trim(lit.Value, `"`)
+						}
+					case "Function":
+						if lit, ok := targetKV.Value.(*ast.BasicLit); ok {
+							hook.Function = strings.Trim(lit.Value, `"`)
+						}
+					case "Receiver":
+						if lit, ok := targetKV.Value.(*ast.BasicLit); ok {
+							hook.Receiver = strings.Trim(lit.Value, `"`)
+						}
+					case "File":
+						if lit, ok := targetKV.Value.(*ast.BasicLit); ok {
+							hook.File = strings.Trim(lit.Value, `"`)
+						}
+					}
+				}
+				hasTarget = true
+			}
+		case "Hooks":
+			// Check if Hooks field is present (not nil)
+			if unary, ok := kvExpr.Value.(*ast.UnaryExpr); ok {
+				hasHooks = true
+				if hooksLit, ok := unary.X.(*ast.CompositeLit); ok {
+					parseInjectFunctions(hooksLit, hook)
+				}
+			}
+		case "Priority":
+			if priority, ok := parseIntLiteral(kvExpr.Value); ok {
+				hook.Priority = priority
+			}
+		case "Rewrite":
+			// Check if Rewrite field is present (not nil)
+			if kvExpr.Value != nil {
+				hasRewrite = true
+				// Extract the function name if it's an identifier
+				if ident, ok := kvExpr.Value.(*ast.Ident); ok {
+					hook.RewriteFuncName = ident.Name
+				}
+			}
+		}
+	}
+
+	// Determine hook type based on what's present
+	if hasTarget {
+		if hasHooks && hasRewrite {
+			hook.Type = "both"
+		} else if hasHooks {
+			hook.Type = "before_after"
+		} else if hasRewrite {
+			hook.Type = "rewrite"
+		} else {
+			return nil
+		}
+		return hook
+	}
+
+	return nil
+}
+
+// parseIntLiteral returns the value of an integer literal, possibly negated
+func parseIntLiteral(expr ast.Expr) (int, bool) {
+	sign := 1
+	if unary, ok := expr.(*ast.UnaryExpr); ok && unary.Op == token.SUB {
+		sign = -1
+		expr = unary.X
+	}
+	lit, ok := expr.(*ast.BasicLit)
+	if !ok || lit.Kind != token.INT {
+		return 0, false
+	}
+	value, err := strconv.ParseInt(lit.Value, 0, 0)
+	if err != nil {
+		return 0, false
+	}
+	return sign * int(value), true
+}
+
+// parseInjectFunctions reads the Before/After function names of an InjectFunctions literal
+func parseInjectFunctions(lit *ast.CompositeLit, hook *HookDefinition) {
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+		key, ok := kv.Key.(*ast.Ident)
+		if !ok {
+			continue
+		}
+		value, ok := kv.Value.(*ast.BasicLit)
+		if !ok {
+			continue
+		}
+		switch key.Name {
+		case "Before":
+			hook.BeforeFunc = strings.Trim(value.Value, `"`)
+		case "After":
+			hook.AfterFunc = strings.Trim(value.Value, `"`)
+		}
+	}
+}
+
+// ParseRewriteFunctionsFromFile parses a hooks file and extracts rewrite information
+// from all Rewrite functions (raw code to inject, whether to rename return values, etc.)
+func ParseRewriteFunctionsFromFile(hooksFile string, hooks []HookDefinition) []HookDefinition {
+	// Parse the hooks file
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, hooksFile, nil, parser.ParseComments)
+	if err != nil {
+		return hooks
+	}
+
+	// Create a map for quick lookup of hooks by rewrite function name
+	hooksByRewriteFunc := make(map[string]*HookDefinition)
+	for i := range hooks {
+		if hooks[i].RewriteFuncName != "" {
+			hooksByRewriteFunc[hooks[i].RewriteFuncName] = &hooks[i]
+		}
+	}
+
+	// Find and parse each rewrite function
+	for _, decl := range node.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		hook, exists := hooksByRewriteFunc[funcDecl.Name.Name]
+		if !exists {
+			continue
+		}
+
+		// Parse the rewrite function to extract info
+		parseRewriteFunction(funcDecl, hook)
+	}
+
+	return hooks
+}
+
+// parseRewriteFunction analyzes a Rewrite function and extracts:
+// - Raw code string to inject
+// - Whether renameReturnValues is called
+// - Injection position (start/defer)
+func parseRewriteFunction(funcDecl *ast.FuncDecl, hook *HookDefinition) {
+	if funcDecl.Body == nil {
+		return
+	}
+
+	ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+		switch node := n.(type) {
+		case *ast.CallExpr:
+			// Check for renameReturnValues call
+			if ident, ok := node.Fun.(*ast.Ident); ok {
+				if ident.Name == "renameReturnValues" {
+					hook.RenameReturnValues = true
+				}
+			}
+
+		case *ast.AssignStmt:
+			// Look for rawCode := `...` assignments
+			for i, lhs := range node.Lhs {
+				if ident, ok := lhs.(*ast.Ident); ok {
+					if ident.Name == "rawCode" || ident.Name == "code" || ident.Name == "deferCode" {
+						if i < len(node.Rhs) {
+							if lit, ok := node.Rhs[i].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+								// Extract the raw string value
+								rawCode := strings.Trim(lit.Value, "`\"")
+								hook.RawCodeToInject = rawCode
+
+								// Determine injection position based on content
+								if strings.HasPrefix(strings.TrimSpace(rawCode), "defer ") {
+									hook.InjectPosition = "defer"
+								} else {
+									hook.InjectPosition = "start"
+								}
+							}
+						}
+					}
+				}
+			}
+		}
+		return true
+	})
+}
+
+// GeneratedFileDefinition represents a file to be generated into a package
+type GeneratedFileDefinition struct {
+	Package  string
+	FileName string
+	Content  string
+}
+
+// StructModificationDefinition represents a struct modification to apply
+type StructModificationDefinition struct {
+	Package    string
+	StructName string
+	AddFields  []StructFieldDefinition
+}
+
+// StructFieldDefinition represents a field to add to a struct
+type StructFieldDefinition struct {
+	Name string
+	Type string
+}
+
+// ParseGeneratedFilesFromHooksFile parses the hooks file to extract GeneratedFile definitions
+// from GetGeneratedFiles() function
+func ParseGeneratedFilesFromHooksFile(hooksFile string) []GeneratedFileDefinition {
+	var files []GeneratedFileDefinition
+
+	if IsHooksManifest(hooksFile) {
+		if manifest, err := LoadHooksManifest(hooksFile); err == nil {
+			files = manifest.GeneratedFileDefinitions()
+		}
+		return files
+	}
+
+	// Parse the hooks file
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, hooksFile, nil, parser.ParseComments)
+	if err != nil {
+		return files
+	}
+
+	// Find string constants that might contain generated file content
+	stringConstants := make(map[string]string)
+	for _, decl := range node.Decls {
+		genDecl, ok := decl.(*ast.GenDecl)
+		if !ok || genDecl.Tok != token.CONST {
+			continue
+		}
+		for _, spec := range genDecl.Specs {
+			valueSpec, ok := spec.(*ast.ValueSpec)
+			if !ok || len(valueSpec.Names) == 0 || len(valueSpec.Values) == 0 {
+				continue
+			}
+			if lit, ok := valueSpec.Values[0].(*ast.BasicLit); ok && lit.Kind == token.STRING {
+				stringConstants[valueSpec.Names[0].Name] = strings.Trim(lit.Value, "`\"")
+			}
+		}
+	}
+
+	// Find GetGeneratedFiles function
+	for _, decl := range node.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Name.Name != "GetGeneratedFiles" {
+			continue
+		}
+
+		// Parse the function body to extract GeneratedFile definitions
+		ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+			compLit, ok := n.(*ast.CompositeLit)
+			if !ok {
+				return true
+			}
+
+			file := parseGeneratedFileFromCompositeLit(compLit, stringConstants)
+			if file != nil {
+				files = append(files, *file)
+			}
+
+			return true
+		})
+		break
+	}
+
+	return files
+}
+
+// parseGeneratedFileFromCompositeLit parses a GeneratedFile struct from a composite literal
+func parseGeneratedFileFromCompositeLit(lit *ast.CompositeLit, stringConstants map[string]string) *GeneratedFileDefinition {
+	file := &GeneratedFileDefinition{}
+	hasPackage := false
+	hasFileName := false
+
+	for _, elt := range lit.Elts {
+		kvExpr, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+
+		key, ok := kvExpr.Key.(*ast.Ident)
+		if !ok {
+			continue
+		}
+
+		switch key.Name {
+		case "Package":
+			if lit, ok := kvExpr.Value.(*ast.BasicLit); ok {
+				file.Package = strings.Trim(lit.Value, `"`)
+				hasPackage = true
+			}
+		case "FileName":
+			if lit, ok := kvExpr.Value.(*ast.BasicLit); ok {
+				file.FileName = strings.Trim(lit.Value, `"`)
+				hasFileName = true
+			}
+		case "Content":
+			// Content can be a string literal or a constant reference
+			if lit, ok := kvExpr.Value.(*ast.BasicLit); ok {
+				file.Content = strings.Trim(lit.Value, "`\"")
+			} else if ident, ok := kvExpr.Value.(*ast.Ident); ok {
+				// It's a reference to a constant
+				if content, exists := stringConstants[ident.Name]; exists {
+					file.Content = content
+				}
+			}
+		}
+	}
+
+	if hasPackage && hasFileName && file.Content != "" {
+		return file
+	}
+
+	return nil
+}
+
+// ParseStructModificationsFromHooksFile parses the hooks file to extract StructModification definitions
+// from GetStructModifications() function
+func ParseStructModificationsFromHooksFile(hooksFile string) []StructModificationDefinition {
+	var modifications []StructModificationDefinition
+
+	if IsHooksManifest(hooksFile) {
+		if manifest, err := LoadHooksManifest(hooksFile); err == nil {
+			modifications = manifest.StructModificationDefinitions()
+		}
+		return modifications
+	}
+
+	// Parse the hooks file
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, hooksFile, nil, parser.ParseComments)
+	if err != nil {
+		return modifications
+	}
+
+	// Find GetStructModifications function
+	for _, decl := range node.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok || funcDecl.Name.Name != "GetStructModifications" {
+			continue
+		}
+
+		// Parse the function body to extract StructModification definitions
+		ast.Inspect(funcDecl.Body, func(n ast.Node) bool {
+			compLit, ok := n.(*ast.CompositeLit)
+			if !ok {
+				return true
+			}
+
+			mod := parseStructModificationFromCompositeLit(compLit)
+			if mod != nil {
+				modifications = append(modifications, *mod)
+			}
+
+			return true
+		})
+		break
+	}
+
+	return modifications
+}
+
+// parseStructModificationFromCompositeLit parses a StructModification struct from a composite literal
+func parseStructModificationFromCompositeLit(lit *ast.CompositeLit) *StructModificationDefinition {
+	mod := &StructModificationDefinition{}
+	hasPackage := false
+	hasStructName := false
+
+	for _, elt := range lit.Elts {
+		kvExpr, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+
+		key, ok := kvExpr.Key.(*ast.Ident)
+		if !ok {
+			continue
+		}
+
+		switch key.Name {
+		case "Package":
+			if basicLit, ok := kvExpr.Value.(*ast.BasicLit); ok {
+				mod.Package = strings.Trim(basicLit.Value, `"`)
+				hasPackage = true
+			}
+		case "StructName":
+			if basicLit, ok := kvExpr.Value.(*ast.BasicLit); ok {
+				mod.StructName = strings.Trim(basicLit.Value, `"`)
+				hasStructName = true
+			}
+		case "AddFields":
+			// Parse the slice of StructField
+			if compLit, ok := kvExpr.Value.(*ast.CompositeLit); ok {
+				for _, fieldElt := range compLit.Elts {
+					if fieldLit, ok := fieldElt.(*ast.CompositeLit); ok {
+						field := parseStructFieldFromCompositeLit(fieldLit)
+						if field != nil {
+							mod.AddFields = append(mod.AddFields, *field)
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if hasPackage && hasStructName && len(mod.AddFields) > 0 {
+		return mod
+	}
+
+	return nil
+}
+
+// parseStructFieldFromCompositeLit parses a StructField from a composite literal
+func parseStructFieldFromCompositeLit(lit *ast.CompositeLit) *StructFieldDefinition {
+	field := &StructFieldDefinition{}
+	hasName := false
+	hasType := false
+
+	for _, elt := range lit.Elts {
+		kvExpr, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			continue
+		}
+
+		key, ok := kvExpr.Key.(*ast.Ident)
+		if !ok {
+			continue
+		}
+
+		switch key.Name {
+		case "Name":
+			if basicLit, ok := kvExpr.Value.(*ast.BasicLit); ok {
+				field.Name = strings.Trim(basicLit.Value, `"`)
+				hasName = true
+			}
+		case "Type":
+			if basicLit, ok := kvExpr.Value.(*ast.BasicLit); ok {
+				field.Type = strings.Trim(basicLit.Value, `"`)
+				hasType = true
+			}
+		}
+	}
+
+	if hasName && hasType {
+		return field
+	}
+
+	return nil
+}
@@ -0,0 +1,215 @@
+package instrument
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// HooksManifest is a declarative hooks file, written in YAML or JSON instead of Go. Like a Go
+// hooks file, it lives in the directory of the hooks package implementing its Before/After
+// functions.
+type HooksManifest struct {
+	// Import path of the hooks package; optional, checked against the manifest's directory
+	Package             string                  `json:"package,omitempty"`
+	Hooks               []ManifestHook          `json:"hooks"`
+	StructModifications []ManifestStructChange  `json:"structModifications,omitempty"`
+	GeneratedFiles      []GeneratedFileManifest `json:"generatedFiles,omitempty"`
+}
+
+// ManifestHook is a hook of a manifest: a target and the code run around or injected into it
+type ManifestHook struct {
+	Target   ManifestTarget   `json:"target"`
+	Before   string           `json:"before,omitempty"` // Function of the hooks package run before the target
+	After    string           `json:"after,omitempty"`  // Function of the hooks package run after the target
+	Rewrite  *ManifestRewrite `json:"rewrite,omitempty"`
+	Priority int              `json:"priority,omitempty"` // Order among the hooks of the same function, see hooks.Hook
+}
+
+// ManifestTarget selects the functions a hook applies to, with the patterns of InjectTarget
+type ManifestTarget struct {
+	Package  string `json:"package"`
+	Function string `json:"function"`
+	Receiver string `json:"receiver,omitempty"`
+	File     string `json:"file,omitempty"`
+}
+
+// ManifestRewrite is code injected at the start of the target
+type ManifestRewrite struct {
+	Code               string `json:"code"`
+	RenameReturnValues bool   `json:"renameReturnValues,omitempty"`
+}
+
+// ManifestStructChange adds fields to a struct type
+type ManifestStructChange struct {
+	Package string          `json:"package"`
+	Struct  string          `json:"struct"`
+	Fields  []ManifestField `json:"fields"`
+}
+
+// ManifestField is a field added to a struct
+type ManifestField struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// GeneratedFileManifest is a file added to a package
+type GeneratedFileManifest struct {
+	Package  string `json:"package"`
+	FileName string `json:"fileName"`
+	Content  string `json:"content"`
+}
+
+// IsHooksManifest reports whether a hooks file is a YAML or JSON manifest rather than Go source
+func IsHooksManifest(hooksFile string) bool {
+	switch strings.ToLower(filepath.Ext(hooksFile)) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
+
+// LoadHooksManifest reads and validates a YAML or JSON hooks manifest. Unknown keys are
+// errors, so a misspelled key doesn't silently drop a hook.
+func LoadHooksManifest(manifestFile string) (*HooksManifest, error) {
+	data, err := os.ReadFile(manifestFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hooks manifest: %w", err)
+	}
+
+	if strings.ToLower(filepath.Ext(manifestFile)) != ".json" {
+		if data, err = YAMLToJSON(data); err != nil {
+			return nil, fmt.Errorf("error parsing hooks manifest %s: %w", manifestFile, err)
+		}
+	}
+
+	manifest := &HooksManifest{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(manifest); err != nil {
+		return nil, fmt.Errorf("error parsing hooks manifest %s: %w", manifestFile, err)
+	}
+	if err := manifest.validate(manifestFile); err != nil {
+		return nil, fmt.Errorf("invalid hooks manifest %s: %w", manifestFile, err)
+	}
+	return manifest, nil
+}
+
+// validate checks the required fields of a manifest and that its package is the one of its
+// directory
+func (m *HooksManifest) validate(manifestFile string) error {
+	var problems []string
+	if m.Package != "" {
+		importPath, err := GetHooksImportPath(manifestFile)
+		if err == nil && importPath != m.Package {
+			problems = append(problems, fmt.Sprintf("package is %s but the manifest is in %s", m.Package, importPath))
+		}
+	}
+	for i, hook := range m.Hooks {
+		where := fmt.Sprintf("hooks[%d]", i)
+		if hook.Target.File == "" && (hook.Target.Package == "" || hook.Target.Function == "") {
+			problems = append(problems, where+": target.package and target.function, or target.file, are required")
+		}
+		if hook.Before == "" && hook.After == "" && hook.Rewrite == nil {
+			problems = append(problems, where+": before, after or rewrite is required")
+		}
+		if hook.Rewrite != nil && strings.TrimSpace(hook.Rewrite.Code) == "" {
+			problems = append(problems, where+": rewrite.code is required")
+		}
+		for _, name := range []string{hook.Before, hook.After} {
+			if name != "" && !isGoIdentifier(name) {
+				problems = append(problems, fmt.Sprintf("%s: %q is not a function name", where, name))
+			}
+		}
+	}
+	for i, mod := range m.StructModifications {
+		if mod.Package == "" || mod.Struct == "" || len(mod.Fields) == 0 {
+			problems = append(problems, fmt.Sprintf("structModifications[%d]: package, struct and fields are required", i))
+		}
+		for j, field := range mod.Fields {
+			if field.Name == "" || field.Type == "" {
+				problems = append(problems, fmt.Sprintf("structModifications[%d].fields[%d]: name and type are required", i, j))
+			}
+		}
+	}
+	for i, file := range m.GeneratedFiles {
+		if file.Package == "" || file.FileName == "" || file.Content == "" {
+			problems = append(problems, fmt.Sprintf("generatedFiles[%d]: package, fileName and content are required", i))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// isGoIdentifier reports whether name is a valid Go identifier
+func isGoIdentifier(name string) bool {
+	for i, r := range name {
+		if r != '_' && !('a' <= r && r <= 'z') && !('A' <= r && r <= 'Z') && (i == 0 || !('0' <= r && r <= '9')) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// HookDefinitions converts the hooks of a manifest to hook definitions
+func (m *HooksManifest) HookDefinitions() []HookDefinition {
+	var hooks []HookDefinition
+	for _, hook := range m.Hooks {
+		definition := HookDefinition{
+			Package:    hook.Target.Package,
+			Function:   hook.Target.Function,
+			Receiver:   hook.Target.Receiver,
+			File:       hook.Target.File,
+			BeforeFunc: hook.Before,
+			AfterFunc:  hook.After,
+			NamedFuncs: true,
+			Priority:   hook.Priority,
+		}
+		hasHooks := hook.Before != "" || hook.After != ""
+		if hook.Rewrite != nil {
+			definition.RawCodeToInject = hook.Rewrite.Code
+			definition.RenameReturnValues = hook.Rewrite.RenameReturnValues
+			definition.InjectPosition = "start"
+			if strings.HasPrefix(strings.TrimSpace(hook.Rewrite.Code), "defer ") {
+				definition.InjectPosition = "defer"
+			}
+		}
+		switch {
+		case hasHooks && hook.Rewrite != nil:
+			definition.Type = "both"
+		case hasHooks:
+			definition.Type = "before_after"
+		default:
+			definition.Type = "rewrite"
+		}
+		hooks = append(hooks, definition)
+	}
+	return hooks
+}
+
+// StructModificationDefinitions converts the struct modifications of a manifest
+func (m *HooksManifest) StructModificationDefinitions() []StructModificationDefinition {
+	var modifications []StructModificationDefinition
+	for _, mod := range m.StructModifications {
+		definition := StructModificationDefinition{Package: mod.Package, StructName: mod.Struct}
+		for _, field := range mod.Fields {
+			definition.AddFields = append(definition.AddFields, StructFieldDefinition(field))
+		}
+		modifications = append(modifications, definition)
+	}
+	return modifications
+}
+
+// GeneratedFileDefinitions converts the generated files of a manifest
+func (m *HooksManifest) GeneratedFileDefinitions() []GeneratedFileDefinition {
+	var files []GeneratedFileDefinition
+	for _, file := range m.GeneratedFiles {
+		files = append(files, GeneratedFileDefinition(file))
+	}
+	return files
+}
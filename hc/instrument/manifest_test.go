@@ -0,0 +1,88 @@
+package instrument
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeManifest writes a hooks manifest to a temporary directory and returns its path
+func writeManifest(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadHooksManifestPriority(t *testing.T) {
+	path := writeManifest(t, "hooks.yaml", `hooks:
+  - target: {package: main, function: foo}
+    before: BeforeFoo
+    after: AfterFoo
+    priority: 10
+  - target: {package: main, function: foo}
+    before: BeforeFooFirst
+    priority: -5
+`)
+	manifest, err := LoadHooksManifest(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	definitions := manifest.HookDefinitions()
+	if len(definitions) != 2 {
+		t.Fatalf("expected 2 hooks, got %d", len(definitions))
+	}
+	if definitions[0].Priority != 10 || definitions[1].Priority != -5 {
+		t.Errorf("expected priorities 10 and -5, got %d and %d", definitions[0].Priority, definitions[1].Priority)
+	}
+}
+
+func TestLoadHooksManifestErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"priority not an integer", "hooks:\n  - target: {package: main, function: foo}\n    before: B\n    priority: high\n"},
+		{"unknown key", "hooks:\n  - target: {package: main, function: foo}\n    befor: B\n"},
+		{"no hook function", "hooks:\n  - target: {package: main, function: foo}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadHooksManifest(writeManifest(t, "hooks.yaml", tt.content)); err == nil {
+				t.Error("expected an error")
+			}
+		})
+	}
+}
+
+func TestParseHooksFileManifestWithOneFunction(t *testing.T) {
+	path := writeManifest(t, "hooks.yaml", `hooks:
+  - target: {package: main, function: foo}
+    before: BeforeFoo
+  - target: {package: main, function: "Serve*"}
+    after: AfterServe
+`)
+	source := `package hooks
+
+import "github.com/pdelewski/go-build-interceptor/hooks"
+
+func BeforeFoo(ctx hooks.HookContext) {}
+
+func AfterServe(ctx hooks.HookContext) {}
+`
+	if err := os.WriteFile(filepath.Join(filepath.Dir(path), "hooks.go"), []byte(source), 0644); err != nil {
+		t.Fatal(err)
+	}
+	hooks, err := ParseHooksFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][2]string{{"BeforeFoo", ""}, {"", "AfterServe"}}
+	for i, hook := range hooks {
+		if before, after := HookFunctionNames(hook); before != want[i][0] || after != want[i][1] {
+			t.Errorf("hooks[%d]: expected functions %q/%q, got %q/%q", i, want[i][0], want[i][1], before, after)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+package instrument
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+)
+
+// RegexpPrefix marks a target Package or Function given as a regular expression, e.g.
+// "regexp:(Get|Put)[A-Z].*". The expression must match the whole name.
+const RegexpPrefix = "regexp:"
+
+// IsPatternTarget reports whether the hook targets functions by pattern rather than by name.
+// Hooks targeting files always do.
+func IsPatternTarget(hook HookDefinition) bool {
+	return hook.File != "" || isPattern(hook.Package) || isPattern(hook.Function) || isPattern(receiverPattern(hook.Receiver))
+}
+
+// receiverPattern drops the pointer from a hook's Receiver: "*Server" and "Server" both
+// target the methods of Server, whichever receiver kind they are declared with. A leading
+// "*" is therefore never a glob.
+func receiverPattern(receiver string) string {
+	if strings.HasPrefix(receiver, RegexpPrefix) {
+		return receiver
+	}
+	return strings.TrimPrefix(receiver, "*")
+}
+
+// isPattern reports whether a target value is a glob or a regular expression
+func isPattern(value string) bool {
+	return strings.HasPrefix(value, RegexpPrefix) || strings.HasSuffix(value, "/...") ||
+		strings.ContainsAny(value, "*?[")
+}
+
+// compiledRegexp is a regular expression of a target, anchored, or the error compiling it
+type compiledRegexp struct {
+	re  *regexp.Regexp
+	err error
+}
+
+// targetRegexps caches the compiled regular expressions of targets by expression: matchTarget
+// runs for every function of every package against every hook
+var targetRegexps sync.Map
+
+// targetRegexp returns the anchored regular expression of a "regexp:" target, compiled once
+func targetRegexp(expr string) (*regexp.Regexp, error) {
+	if cached, ok := targetRegexps.Load(expr); ok {
+		compiled := cached.(compiledRegexp)
+		return compiled.re, compiled.err
+	}
+	var compiled compiledRegexp
+	// The expression is checked alone so errors don't show the anchors
+	if _, compiled.err = regexp.Compile(expr); compiled.err == nil {
+		compiled.re, compiled.err = regexp.Compile("^(?:" + expr + ")$")
+	}
+	targetRegexps.Store(expr, compiled)
+	return compiled.re, compiled.err
+}
+
+// matchTarget reports whether name matches a target value. Values are either exact names,
+// globs in path.Match syntax, package paths ending in "/..." (the package and everything
+// below it) or anchored regular expressions with the "regexp:" prefix.
+func matchTarget(pattern, name string) (bool, error) {
+	if expr, ok := strings.CutPrefix(pattern, RegexpPrefix); ok {
+		re, err := targetRegexp(expr)
+		if err != nil {
+			return false, err
+		}
+		return re.MatchString(name), nil
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "/..."); ok {
+		return name == prefix || strings.HasPrefix(name, prefix+"/"), nil
+	}
+	if !strings.ContainsAny(pattern, "*?[") {
+		return pattern == name, nil
+	}
+	return path.Match(pattern, name)
+}
+
+// matchTargetOrAny is matchTarget, except that an empty pattern matches every name. Hooks
+// targeting files leave out the fields they don't restrict.
+func matchTargetOrAny(pattern, name string) (bool, error) {
+	if pattern == "" {
+		return true, nil
+	}
+	return matchTarget(pattern, name)
+}
+
+// matchFile reports whether a source file matches the File pattern of a target. A glob is
+// matched against as many trailing elements of the file's absolute path as it has, so
+// "handlers/*.go" matches /src/app/handlers/server.go and "*_handler.go" any file with that
+// suffix; absolute globs and regular expressions are matched against the whole path.
+func matchFile(pattern, file string) (bool, error) {
+	if file == "" {
+		return false, nil
+	}
+	name := file
+	if absPath, err := filepath.Abs(file); err == nil {
+		name = absPath
+	}
+	name = filepath.ToSlash(name)
+	if strings.HasPrefix(pattern, RegexpPrefix) || strings.HasPrefix(pattern, "/") {
+		return matchTarget(pattern, name)
+	}
+
+	elements := strings.Split(name, "/")
+	if n := strings.Count(pattern, "/") + 1; n < len(elements) {
+		elements = elements[len(elements)-n:]
+	}
+	return path.Match(pattern, strings.Join(elements, "/"))
+}
+
+// ValidateHookPatterns returns an error for the first hook whose target pattern is malformed
+func ValidateHookPatterns(hooks []HookDefinition) error {
+	for _, hook := range hooks {
+		for _, value := range []string{hook.Package, hook.Function, receiverPattern(hook.Receiver)} {
+			if _, err := matchTarget(value, ""); err != nil {
+				return fmt.Errorf("invalid target pattern %q in hook for %s: %w", value, HookTarget(hook), err)
+			}
+		}
+		if hook.File != "" {
+			if _, err := matchFile(hook.File, "/"); err != nil {
+				return fmt.Errorf("invalid file pattern %q in hook for %s: %w", hook.File, HookTarget(hook), err)
+			}
+		}
+		if IsPatternTarget(hook) && !hook.NamedFuncs && (hook.Type == "before_after" || hook.Type == "both") &&
+			(hook.BeforeFunc == "" || hook.AfterFunc == "") {
+			return fmt.Errorf("hook for %s targets a pattern and must name its Before and After functions", HookTarget(hook))
+		}
+	}
+	return nil
+}
+
+// MatchesPackage reports whether hook targets functions of packageName. Hooks targeting
+// files without a package may target functions of any package.
+func MatchesPackage(hook HookDefinition, packageName string) bool {
+	if hook.File != "" {
+		ok, _ := matchTargetOrAny(hook.Package, packageName)
+		return ok
+	}
+	ok, _ := matchTarget(hook.Package, packageName)
+	return ok
+}
+
+// ResolveMainPackage returns the hooks to match against the functions of a package, resolving
+// the targets of main packages: compile commands name every main package "main", so hooks
+// targeting the import path of one, or a pattern matching it, are retargeted to "main" for
+// that package only. Hooks targeting "main" keep matching every main package. Other packages
+// get the hooks as they are.
+func ResolveMainPackage(hooks []HookDefinition, packageName, importPath string) []HookDefinition {
+	if packageName != "main" || importPath == "" || importPath == "main" {
+		return hooks
+	}
+	resolved := make([]HookDefinition, 0, len(hooks))
+	for _, hook := range hooks {
+		if hook.Package != "" && hook.Package != "main" {
+			if ok, _ := matchTarget(hook.Package, importPath); ok {
+				hook.PackagePath = hook.Package
+				hook.Package = "main"
+			}
+		}
+		resolved = append(resolved, hook)
+	}
+	return resolved
+}
+
+// MatchesFiles reports whether hook may target functions of a package compiling files: hooks
+// targeting files need one of them to match
+func MatchesFiles(hook HookDefinition, files []string) bool {
+	if hook.File == "" {
+		return true
+	}
+	for _, file := range files {
+		if ok, _ := matchFile(hook.File, file); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesHook reports whether a function of packageName is a target of hook
+func matchesHook(packageName string, funcInfo *analyze.FunctionInfo, hook HookDefinition) bool {
+	match := matchTarget
+	if hook.File != "" {
+		if ok, _ := matchFile(hook.File, funcInfo.FilePath); !ok {
+			return false
+		}
+		match = matchTargetOrAny
+	}
+	if ok, _ := match(hook.Package, packageName); !ok {
+		return false
+	}
+	if ok, _ := match(hook.Function, funcInfo.Name); !ok {
+		return false
+	}
+
+	// A hook without receiver only matches plain functions, unless it targets files. Receivers
+	// are matched by the name of their base type, so pointer and generic receivers match too.
+	if hook.Receiver == "" {
+		return funcInfo.Receiver == "" || hook.File != ""
+	}
+	if funcInfo.Receiver == "" {
+		return false
+	}
+	ok, _ := matchTarget(receiverPattern(hook.Receiver), analyze.ReceiverTypeName(funcInfo.Receiver))
+	return ok
+}
+
+// MatchFunctionWithHooks returns the hook instrumenting a function, combining every hook that
+// matches it. The hooks are ordered by Priority, then exact targets before patterns, then in
+// the order they were loaded. The first hook with Before/After functions is returned with
+// the others in its Chain, and the first Rewrite is applied with them. The returned hook and
+// its chain target the matched function itself. Functions without a body have no code to
+// instrument and match no hook.
+func MatchFunctionWithHooks(packageName string, funcInfo *analyze.FunctionInfo, hooks []HookDefinition) *HookDefinition {
+	matches := MatchingHooks(packageName, funcInfo, hooks)
+	if len(matches) == 0 {
+		return nil
+	}
+
+	var match, rewrite *HookDefinition
+	for i := range matches {
+		hook := &matches[i]
+		hook.Package = packageName
+		hook.PackagePath = ""
+		hook.Function = funcInfo.Name
+		hook.Receiver = funcInfo.Receiver
+		hook.File = ""
+		if (hook.Type == "rewrite" || hook.Type == "both") && rewrite == nil {
+			rewrite = hook
+		}
+		if hook.Type == "rewrite" {
+			continue
+		}
+		if match == nil {
+			match = hook
+			continue
+		}
+		chained := *hook
+		chained.Type = "before_after"
+		match.Chain = append(match.Chain, chained)
+	}
+
+	if match == nil {
+		return rewrite
+	}
+	match.Type = "before_after"
+	if rewrite != nil {
+		match.Type = "both"
+		match.RewriteFuncName = rewrite.RewriteFuncName
+		match.RawCodeToInject = rewrite.RawCodeToInject
+		match.RenameReturnValues = rewrite.RenameReturnValues
+		match.InjectPosition = rewrite.InjectPosition
+		match.HooksFile = rewrite.HooksFile
+	}
+	return match
+}
+
+// MatchingHooks returns the hooks matching a function, as they were defined, in the order
+// MatchFunctionWithHooks combines them: by Priority, then exact targets before patterns, then
+// in the order they were loaded. Functions without a body match no hook.
+func MatchingHooks(packageName string, funcInfo *analyze.FunctionInfo, hooks []HookDefinition) []HookDefinition {
+	if funcInfo.NoBody {
+		return nil
+	}
+	var matches []HookDefinition
+	for _, hook := range hooks {
+		if matchesHook(packageName, funcInfo, hook) {
+			matches = append(matches, hook)
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Priority != matches[j].Priority {
+			return matches[i].Priority < matches[j].Priority
+		}
+		return !IsPatternTarget(matches[i]) && IsPatternTarget(matches[j])
+	})
+	return matches
+}
+
+// HookImportPath returns the import path of the package implementing the hook's Before/After
+// functions, falling back to the primary hooks package
+func HookImportPath(hook HookDefinition, hooksImportPath string) string {
+	if hook.HooksImportPath != "" {
+		return hook.HooksImportPath
+	}
+	return hooksImportPath
+}
+
+// HookTarget returns the function instrumented by a hook as package.Function or
+// package.(Receiver).Function, with the package as the hooks file gives it. Hooks targeting
+// files are described as the functions of the files, e.g. "*.* in handlers/*.go".
+func HookTarget(hook HookDefinition) string {
+	if hook.PackagePath != "" {
+		hook.Package, hook.PackagePath = hook.PackagePath, ""
+	}
+	if hook.File != "" {
+		target := hook
+		target.File = ""
+		if target.Package == "" {
+			target.Package = "*"
+		}
+		if target.Function == "" {
+			target.Function = "*"
+		}
+		return HookTarget(target) + " in " + hook.File
+	}
+	if hook.Receiver != "" {
+		return fmt.Sprintf("%s.(%s).%s", hook.Package, hook.Receiver, hook.Function)
+	}
+	return hook.Package + "." + hook.Function
+}
+
+// UniqueHooksFiles drops hooks files that were passed more than once
+func UniqueHooksFiles(hooksFiles []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, hooksFile := range hooksFiles {
+		key := hooksFile
+		if absPath, err := filepath.Abs(hooksFile); err == nil {
+			key = absPath
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, hooksFile)
+	}
+	return unique
+}
+
+// TagHooks records the hooks file and hooks package the hooks were loaded from
+func TagHooks(hooks []HookDefinition, hooksFile string) {
+	importPath, err := GetHooksImportPath(hooksFile)
+	if err != nil {
+		importPath = ""
+	}
+	for i := range hooks {
+		hooks[i].HooksFile = hooksFile
+		hooks[i].HooksImportPath = importPath
+	}
+}
+
+// CheckHookConflicts returns an error listing every function targeted by more than one
+// Rewrite hook of the same priority. The Before/After functions of all hooks matching a
+// function are called, but only the first Rewrite applies, so which one would be ambiguous.
+func CheckHookConflicts(hooks []HookDefinition) error {
+	byTarget := make(map[string][]HookDefinition)
+	var targets []string
+	for _, hook := range hooks {
+		if hook.Type != "rewrite" && hook.Type != "both" {
+			continue
+		}
+		// "Server" and "*Server" target the same methods
+		keyHook := hook
+		keyHook.Receiver = receiverPattern(hook.Receiver)
+		target := fmt.Sprintf("%s (priority %d)", HookTarget(keyHook), hook.Priority)
+		if _, exists := byTarget[target]; !exists {
+			targets = append(targets, target)
+		}
+		byTarget[target] = append(byTarget[target], hook)
+	}
+
+	var conflicts []string
+	for _, target := range targets {
+		if len(byTarget[target]) < 2 {
+			continue
+		}
+		var sources []string
+		for _, hook := range byTarget[target] {
+			source := hook.HooksFile
+			if source == "" {
+				source = "<unknown>"
+			}
+			sources = append(sources, fmt.Sprintf("%s [%s]", source, hook.Type))
+		}
+		conflicts = append(conflicts, fmt.Sprintf("  %s: %s", target, strings.Join(sources, ", ")))
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("conflicting Rewrite hooks target the same function, give them different priorities:\n%s", strings.Join(conflicts, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,39 @@
+package instrument
+
+import "testing"
+
+func TestMatchTarget(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"Get", "Get", true},
+		{"Get", "GetAll", false},
+		{"Get*", "GetAll", true},
+		{"net/...", "net/http", true},
+		{"net/...", "network", false},
+		{"regexp:(Get|Put)[A-Z].*", "GetUser", true},
+		{"regexp:(Get|Put)[A-Z].*", "MyGetUser", false},
+		{"regexp:(Get|Put)[A-Z].*", "GetUser", true}, // From the cache
+		{"regexp:a|b", "ab", false},
+	}
+	for _, tt := range tests {
+		got, err := matchTarget(tt.pattern, tt.name)
+		if err != nil || got != tt.want {
+			t.Errorf("matchTarget(%q, %q) = %v, %v, want %v", tt.pattern, tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestValidateHookPatternsRegexp(t *testing.T) {
+	hooks := []HookDefinition{{Package: "main", Function: "regexp:Get(", Type: "before_after", BeforeFunc: "B", AfterFunc: "A"}}
+	for i := 0; i < 2; i++ {
+		if err := ValidateHookPatterns(hooks); err == nil {
+			t.Errorf("attempt %d: expected an error for an invalid regular expression", i+1)
+		}
+	}
+	if _, err := matchTarget("regexp:Get(", "Get"); err == nil {
+		t.Error("expected the invalid regular expression to stay an error")
+	}
+}
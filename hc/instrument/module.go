@@ -0,0 +1,89 @@
+package instrument
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GetHooksImportPath determines the full Go import path for a hooks file
+// by finding the nearest go.mod and calculating the relative path
+func GetHooksImportPath(hooksFile string) (string, error) {
+	absPath, err := filepath.Abs(hooksFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path: %w", err)
+	}
+
+	// Get the directory containing the hooks file
+	hooksDir := filepath.Dir(absPath)
+
+	// Find the go.mod file by walking up the directory tree
+	modPath, modDir, err := FindGoMod(hooksDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to find go.mod: %w", err)
+	}
+
+	// Extract the module path from go.mod
+	modulePath, err := ExtractModulePath(modPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to extract module path: %w", err)
+	}
+
+	// Calculate the relative path from module root to hooks directory
+	relPath, err := filepath.Rel(modDir, hooksDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate relative path: %w", err)
+	}
+
+	// Combine module path with relative path (use forward slashes for import paths)
+	if relPath == "." {
+		return modulePath, nil
+	}
+	importPath := modulePath + "/" + filepath.ToSlash(relPath)
+	return importPath, nil
+}
+
+// FindGoMod walks up the directory tree to find go.mod
+func FindGoMod(startDir string) (modPath string, modDir string, err error) {
+	dir := startDir
+	for {
+		modPath = filepath.Join(dir, "go.mod")
+		if _, err := os.Stat(modPath); err == nil {
+			return modPath, dir, nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			// Reached root without finding go.mod
+			return "", "", fmt.Errorf("go.mod not found")
+		}
+		dir = parent
+	}
+}
+
+// ExtractModulePath extracts the module path from a go.mod file
+func ExtractModulePath(modPath string) (string, error) {
+	file, err := os.Open(modPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "module ") {
+			modulePath := strings.TrimPrefix(line, "module ")
+			modulePath = strings.TrimSpace(modulePath)
+			return modulePath, nil
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+
+	return "", fmt.Errorf("module declaration not found in go.mod")
+}
@@ -0,0 +1,454 @@
+package instrument
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrNoHooks is returned by ParseHooksFile for hooks files declaring no hooks, which may still
+// declare struct modifications and generated files
+var ErrNoHooks = errors.New("no hooks found")
+
+// hooksLibraryPath is the import path of the package declaring hooks.Hook and hooks.HookContext
+const hooksLibraryPath = "github.com/pdelewski/go-build-interceptor/hooks"
+
+// Fields of the hooks types, as hooks files may set them
+var (
+	hookFields            = []string{"Target", "Hooks", "Rewrite", "Priority"}
+	injectTargetFields    = []string{"Package", "Function", "Receiver", "File"}
+	injectFunctionsFields = []string{"Before", "After", "From"}
+)
+
+// HookFunctionNames returns the Before and After functions of a hook, the targets of the
+// go:linkname directives of its trampolines: those named by InjectFunctions, or
+// Before<Function> and After<Function> for hooks with an exact target leaving them out. A
+// name is empty when the hook has no such function.
+func HookFunctionNames(hook HookDefinition) (before, after string) {
+	before, after = hook.BeforeFunc, hook.AfterFunc
+	if IsPatternTarget(hook) || hook.NamedFuncs {
+		return before, after
+	}
+	pascalName := hook.Function
+	if pascalName != "" {
+		pascalName = strings.ToUpper(pascalName[:1]) + pascalName[1:]
+	}
+	if before == "" {
+		before = "Before" + pascalName
+	}
+	if after == "" {
+		after = "After" + pascalName
+	}
+	return before, after
+}
+
+// packageFunc is a function declared in the hooks package
+type packageFunc struct {
+	decl        *ast.FuncDecl
+	hooksImport string // Name the declaring file imports the hooks library as, "" when it doesn't
+}
+
+// hooksFileChecker collects the problems of a hooks file, with their positions
+type hooksFileChecker struct {
+	fset     *token.FileSet
+	funcs    map[string]packageFunc // Functions of the hooks package, by name
+	problems []hooksFileProblem
+}
+
+// hooksFileProblem is a problem of a hooks file at a position of the file
+type hooksFileProblem struct {
+	pos     token.Pos
+	message string
+}
+
+func (c *hooksFileChecker) errorf(pos token.Pos, format string, args ...interface{}) {
+	c.problems = append(c.problems, hooksFileProblem{pos: pos, message: fmt.Sprintf(format, args...)})
+}
+
+// checkHooksFile checks the hooks of ProvideHooks, which parseHookFromCompositeLit reads
+// leniently: every hook literal must set the fields of the hooks types with literals hc can
+// read, and the functions they name must be declared in the hooks package with the
+// signatures the trampolines and the rewrite runner call them with
+func checkHooksFile(fset *token.FileSet, hooksFile string, node *ast.File, provideHooks *ast.FuncDecl) error {
+	c := &hooksFileChecker{fset: fset, funcs: packageFuncs(fset, hooksFile, node)}
+	hooksImport := importName(node, hooksLibraryPath)
+
+	// Literals of hooks.Hook, including those of []*hooks.Hook with the type left out
+	hookLits := make(map[*ast.CompositeLit]bool)
+	ast.Inspect(provideHooks.Body, func(n ast.Node) bool {
+		lit, ok := n.(*ast.CompositeLit)
+		if !ok {
+			return true
+		}
+		if isHooksType(lit.Type, hooksImport, "Hook") || hasKey(lit, "Target") {
+			hookLits[lit] = true
+		}
+		if array, ok := lit.Type.(*ast.ArrayType); ok && isHooksType(array.Elt, hooksImport, "Hook") {
+			for _, elt := range lit.Elts {
+				if unary, ok := elt.(*ast.UnaryExpr); ok && unary.Op == token.AND {
+					elt = unary.X
+				}
+				if eltLit, ok := elt.(*ast.CompositeLit); ok {
+					hookLits[eltLit] = true
+				}
+			}
+		}
+		return true
+	})
+	ast.Inspect(provideHooks.Body, func(n ast.Node) bool {
+		if lit, ok := n.(*ast.CompositeLit); ok && hookLits[lit] {
+			c.checkHookLiteral(lit)
+		}
+		return true
+	})
+
+	if len(c.problems) == 0 {
+		return nil
+	}
+	sort.SliceStable(c.problems, func(i, j int) bool { return c.problems[i].pos < c.problems[j].pos })
+	var lines []string
+	for _, problem := range c.problems {
+		lines = append(lines, fmt.Sprintf("%s: %s", fset.Position(problem.pos), problem.message))
+	}
+	return fmt.Errorf("invalid hooks in %s:\n  %s", hooksFile, strings.Join(lines, "\n  "))
+}
+
+// checkHookLiteral checks a hooks.Hook literal and the functions it names
+func (c *hooksFileChecker) checkHookLiteral(lit *ast.CompositeLit) {
+	fields := c.keyedFields(lit, "hooks.Hook", hookFields)
+	target, ok := fields["Target"]
+	if !ok {
+		c.errorf(lit.Pos(), "hook without a Target")
+		return
+	}
+	targetLit, ok := target.(*ast.CompositeLit)
+	if !ok {
+		c.errorf(target.Pos(), "Target must be a hooks.InjectTarget literal")
+		return
+	}
+	targetProblems := len(c.problems)
+	targetFields := c.keyedFields(targetLit, "hooks.InjectTarget", injectTargetFields)
+	for _, name := range injectTargetFields {
+		c.stringField(targetFields, "Target."+name, name)
+	}
+	if _, ok := targetFields["File"]; !ok {
+		_, hasPackage := targetFields["Package"]
+		_, hasFunction := targetFields["Function"]
+		if !hasPackage || !hasFunction {
+			c.errorf(targetLit.Pos(), "Target needs Package and Function, or File")
+		}
+	}
+	targetValid := len(c.problems) == targetProblems
+
+	var functionPositions map[string]ast.Expr // Values of the fields of InjectFunctions
+	hooksValue, hasHooks := fields["Hooks"]
+	if isNil(hooksValue) {
+		hasHooks = false
+	}
+	if hasHooks {
+		if hooksLit := injectFunctionsLiteral(hooksValue); hooksLit == nil {
+			c.errorf(hooksValue.Pos(), "Hooks must be a &hooks.InjectFunctions{...} literal")
+		} else {
+			functionPositions = c.keyedFields(hooksLit, "hooks.InjectFunctions", injectFunctionsFields)
+			for _, name := range injectFunctionsFields {
+				c.stringField(functionPositions, "Hooks."+name, name)
+			}
+		}
+	}
+
+	rewriteValue, hasRewrite := fields["Rewrite"]
+	if isNil(rewriteValue) {
+		hasRewrite = false
+	}
+	if hasRewrite {
+		if ident, ok := rewriteValue.(*ast.Ident); !ok {
+			c.errorf(rewriteValue.Pos(), "Rewrite must name a function of the hooks package")
+		} else {
+			c.checkRewriteFunc(ident)
+		}
+	}
+
+	if priority, ok := fields["Priority"]; ok {
+		if _, ok := parseIntLiteral(priority); !ok {
+			c.errorf(priority.Pos(), "Priority must be an integer literal")
+		}
+	}
+
+	if !hasHooks && !hasRewrite {
+		c.errorf(lit.Pos(), "hook sets neither Hooks nor Rewrite")
+		return
+	}
+	// The functions of hooks whose target can't be read are checked once it is fixed
+	hook := parseHookFromCompositeLit(lit)
+	if hook == nil || functionPositions == nil || !targetValid {
+		return
+	}
+	before, after := HookFunctionNames(*hook)
+	for _, f := range []struct{ field, name string }{{"Before", before}, {"After", after}} {
+		pos := hooksValue.Pos()
+		if value, ok := functionPositions[f.field]; ok {
+			pos = value.Pos()
+		}
+		if f.name == "" {
+			continue // Pattern targets without the function are reported by ValidateHookPatterns
+		}
+		c.checkHookFunc(pos, f.field, f.name, *hook)
+	}
+}
+
+// keyedFields returns the values of the fields of a struct literal by name, reporting fields
+// given without keys and fields the type doesn't have
+func (c *hooksFileChecker) keyedFields(lit *ast.CompositeLit, typeName string, known []string) map[string]ast.Expr {
+	fields := make(map[string]ast.Expr)
+	for _, elt := range lit.Elts {
+		kv, ok := elt.(*ast.KeyValueExpr)
+		if !ok {
+			c.errorf(elt.Pos(), "%s literal must name its fields", typeName)
+			continue
+		}
+		key, ok := kv.Key.(*ast.Ident)
+		if !ok || !slices.Contains(known, key.Name) {
+			c.errorf(kv.Key.Pos(), "%s has no field %s", typeName, types.ExprString(kv.Key))
+			continue
+		}
+		fields[key.Name] = kv.Value
+	}
+	return fields
+}
+
+// stringField reports a field that isn't a string literal: hc reads hooks files without
+// compiling them, so constants and expressions can't be evaluated
+func (c *hooksFileChecker) stringField(fields map[string]ast.Expr, description, name string) {
+	value, ok := fields[name]
+	if !ok {
+		return
+	}
+	if lit, ok := value.(*ast.BasicLit); !ok || lit.Kind != token.STRING {
+		c.errorf(value.Pos(), "%s must be a string literal", description)
+		return
+	}
+	if name == "Before" || name == "After" {
+		if s, err := strconv.Unquote(value.(*ast.BasicLit).Value); err == nil && !isGoIdentifier(s) {
+			c.errorf(value.Pos(), "%s %q is not a function name: the trampolines link to <hooks package>.%s", description, s, s)
+		}
+	}
+}
+
+// checkHookFunc checks that a Before or After function is declared in the hooks package as
+// the trampolines declare it: func(hooks.HookContext)
+func (c *hooksFileChecker) checkHookFunc(pos token.Pos, field, name string, hook HookDefinition) {
+	if !isGoIdentifier(name) {
+		return // Reported by stringField
+	}
+	fn, ok := c.funcs[name]
+	if !ok {
+		c.errorf(pos, "%s function %s of the hook for %s is not declared in the hooks package", field, name, HookTarget(hook))
+		return
+	}
+	decl := fn.decl
+	declPos := c.fset.Position(decl.Pos())
+	switch {
+	case decl.Recv != nil:
+		c.errorf(pos, "%s function %s of the hook for %s is a method (%s); the trampolines link to a function", field, name, HookTarget(hook), declPos)
+	case decl.Type.TypeParams != nil:
+		c.errorf(pos, "%s function %s of the hook for %s is generic (%s); the trampolines link to a function", field, name, HookTarget(hook), declPos)
+	case !isHookContextSignature(decl.Type, fn.hooksImport):
+		c.errorf(pos, "%s function %s of the hook for %s must be declared as func %s(ctx hooks.HookContext), the signature its trampolines link to (%s)",
+			field, name, HookTarget(hook), name, declPos)
+	}
+}
+
+// checkRewriteFunc checks that a Rewrite function is declared in the hooks package as
+// hooks.FunctionRewriteHook: func(ast.Node) (ast.Node, error)
+func (c *hooksFileChecker) checkRewriteFunc(ident *ast.Ident) {
+	fn, ok := c.funcs[ident.Name]
+	if !ok {
+		c.errorf(ident.Pos(), "Rewrite function %s is not declared in the hooks package", ident.Name)
+		return
+	}
+	params, results := fn.decl.Type.Params, fn.decl.Type.Results
+	if fn.decl.Recv != nil || fieldCount(params) != 1 || fieldCount(results) != 2 {
+		c.errorf(ident.Pos(), "Rewrite function %s must be declared as func %s(node ast.Node) (ast.Node, error) (%s)",
+			ident.Name, ident.Name, c.fset.Position(fn.decl.Pos()))
+	}
+}
+
+// packageFuncs returns the functions and methods of the hooks package: those of the hooks
+// file and of the other non-test Go files of its directory in the same package. Methods are
+// kept, under their name, to report them.
+func packageFuncs(fset *token.FileSet, hooksFile string, node *ast.File) map[string]packageFunc {
+	files := []*ast.File{node}
+	paths, _ := filepath.Glob(filepath.Join(filepath.Dir(hooksFile), "*.go"))
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") || sameFile(path, hooksFile) {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil || file.Name.Name != node.Name.Name {
+			continue
+		}
+		files = append(files, file)
+	}
+
+	funcs := make(map[string]packageFunc)
+	for _, file := range files {
+		hooksImport := importName(file, hooksLibraryPath)
+		for _, decl := range file.Decls {
+			funcDecl, ok := decl.(*ast.FuncDecl)
+			if !ok {
+				continue
+			}
+			// Functions take precedence over methods of the same name
+			if existing, ok := funcs[funcDecl.Name.Name]; ok && existing.decl.Recv == nil {
+				continue
+			}
+			funcs[funcDecl.Name.Name] = packageFunc{decl: funcDecl, hooksImport: hooksImport}
+		}
+	}
+	return funcs
+}
+
+// checkManifestFunctions checks that the Before/After functions of the hooks of a manifest
+// are declared in the package of its directory as the trampolines declare them
+func checkManifestFunctions(manifestFile string, hooks []HookDefinition) error {
+	fset := token.NewFileSet()
+	funcs := make(map[string]packageFunc)
+	paths, _ := filepath.Glob(filepath.Join(filepath.Dir(manifestFile), "*.go"))
+	for _, path := range paths {
+		if strings.HasSuffix(path, "_test.go") {
+			continue
+		}
+		file, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		hooksImport := importName(file, hooksLibraryPath)
+		for _, decl := range file.Decls {
+			if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil {
+				funcs[funcDecl.Name.Name] = packageFunc{decl: funcDecl, hooksImport: hooksImport}
+			}
+		}
+	}
+
+	var problems []string
+	for i, hook := range hooks {
+		if hook.Type != "before_after" && hook.Type != "both" {
+			continue
+		}
+		before, after := HookFunctionNames(hook)
+		for _, name := range []string{before, after} {
+			if name == "" {
+				continue
+			}
+			fn, ok := funcs[name]
+			switch {
+			case !ok:
+				problems = append(problems, fmt.Sprintf("hooks[%d]: function %s is not declared in the package of %s", i, name, filepath.Dir(manifestFile)))
+			case fn.decl.Type.TypeParams != nil || !isHookContextSignature(fn.decl.Type, fn.hooksImport):
+				problems = append(problems, fmt.Sprintf("hooks[%d]: %s must be declared as func %s(ctx hooks.HookContext), the signature its trampolines link to (%s)",
+					i, name, name, fset.Position(fn.decl.Pos())))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid hooks manifest %s: %s", manifestFile, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// isHookContextSignature reports whether a function type is func(hooks.HookContext), with
+// hooksImport the name the hooks library is imported as
+func isHookContextSignature(funcType *ast.FuncType, hooksImport string) bool {
+	if fieldCount(funcType.Params) != 1 || fieldCount(funcType.Results) != 0 {
+		return false
+	}
+	return isHooksType(funcType.Params.List[0].Type, hooksImport, "HookContext")
+}
+
+// isHooksType reports whether expr is the type name of the hooks library, possibly a pointer
+func isHooksType(expr ast.Expr, hooksImport, name string) bool {
+	if star, ok := expr.(*ast.StarExpr); ok {
+		expr = star.X
+	}
+	sel, ok := expr.(*ast.SelectorExpr)
+	if !ok || hooksImport == "" {
+		return false
+	}
+	pkg, ok := sel.X.(*ast.Ident)
+	return ok && pkg.Name == hooksImport && sel.Sel.Name == name
+}
+
+// importName returns the name a file imports a package as, "" when it doesn't import it
+func importName(file *ast.File, importPath string) string {
+	for _, spec := range file.Imports {
+		if path, err := strconv.Unquote(spec.Path.Value); err != nil || path != importPath {
+			continue
+		}
+		if spec.Name != nil {
+			return spec.Name.Name
+		}
+		return filepath.Base(importPath)
+	}
+	return ""
+}
+
+// fieldCount returns the number of parameters or results of a field list
+func fieldCount(list *ast.FieldList) int {
+	if list == nil {
+		return 0
+	}
+	n := 0
+	for _, field := range list.List {
+		if len(field.Names) == 0 {
+			n++
+		} else {
+			n += len(field.Names)
+		}
+	}
+	return n
+}
+
+// hasKey reports whether a composite literal sets the field key
+func hasKey(lit *ast.CompositeLit, key string) bool {
+	for _, elt := range lit.Elts {
+		if kv, ok := elt.(*ast.KeyValueExpr); ok {
+			if ident, ok := kv.Key.(*ast.Ident); ok && ident.Name == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// injectFunctionsLiteral returns the literal of &hooks.InjectFunctions{...}, nil for other
+// expressions
+func injectFunctionsLiteral(expr ast.Expr) *ast.CompositeLit {
+	unary, ok := expr.(*ast.UnaryExpr)
+	if !ok || unary.Op != token.AND {
+		return nil
+	}
+	lit, _ := unary.X.(*ast.CompositeLit)
+	return lit
+}
+
+// isNil reports whether expr is the nil identifier
+func isNil(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == "nil"
+}
+
+// sameFile reports whether two paths name the same file
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
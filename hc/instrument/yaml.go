@@ -0,0 +1,462 @@
+package instrument
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is a line of a YAML document with its indentation
+type yamlLine struct {
+	number int    // 1-based line number
+	indent int    // Leading spaces
+	text   string // Content after the indentation
+	raw    string // Whole line, for block scalars
+}
+
+// yamlParser reads the subset of YAML used by hooks manifests: block mappings and sequences,
+// plain and quoted scalars, literal block scalars (| and |-), single-line flow collections
+// ([a, b] and {key: value}) and comments. Anchors, tags and multi-document streams are rejected
+// rather than misread.
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// parseYAML parses a YAML document into maps, slices, strings, integers, booleans and nils,
+// the shape encoding/json decodes into the manifest structs
+func parseYAML(data string) (interface{}, error) {
+	p := &yamlParser{}
+	for i, raw := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		trimmed := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(trimmed, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed in indentation", i+1)
+		}
+		p.lines = append(p.lines, yamlLine{number: i + 1, indent: len(raw) - len(trimmed), text: strings.TrimRight(trimmed, " "), raw: raw})
+	}
+
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	if text := p.lines[p.pos].text; text == "---" {
+		p.pos++
+		p.skipBlank()
+		if p.pos >= len(p.lines) {
+			return nil, nil
+		}
+	}
+	value, err := p.parseNode(p.lines[p.pos].indent)
+	if err != nil {
+		return nil, err
+	}
+	p.skipBlank()
+	if p.pos < len(p.lines) {
+		return nil, p.errorf("unexpected content %q", p.lines[p.pos].text)
+	}
+	return value, nil
+}
+
+// YAMLToJSON converts a YAML document in the subset parseYAML reads to JSON, for decoding
+// with encoding/json into the structs of other hc configuration files
+func YAMLToJSON(data []byte) ([]byte, error) {
+	document, err := parseYAML(string(data))
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(document)
+}
+
+// skipBlank moves past empty lines and comments
+func (p *yamlParser) skipBlank() {
+	for p.pos < len(p.lines) && (p.lines[p.pos].text == "" || strings.HasPrefix(p.lines[p.pos].text, "#")) {
+		p.pos++
+	}
+}
+
+// errorf returns an error located at the current line
+func (p *yamlParser) errorf(format string, args ...interface{}) error {
+	number := len(p.lines)
+	if p.pos < len(p.lines) {
+		number = p.lines[p.pos].number
+	}
+	return fmt.Errorf("line %d: %s", number, fmt.Sprintf(format, args...))
+}
+
+// isSequenceItem reports whether a line starts a sequence item
+func isSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseNode parses the mapping or sequence starting at the current line, indented by indent
+func (p *yamlParser) parseNode(indent int) (interface{}, error) {
+	if isSequenceItem(p.lines[p.pos].text) {
+		return p.parseSequence(indent)
+	}
+	return p.parseMapping(indent)
+}
+
+// parseSequence parses the items of a block sequence indented by indent
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		line := &p.lines[p.pos]
+		if line.indent < indent || !isSequenceItem(line.text) {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorf("bad indentation of a sequence item")
+		}
+
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" || strings.HasPrefix(rest, "#") {
+			p.pos++
+			item, err := p.parseNested(indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+
+		// The content after the dash is a node of its own, indented to where it starts
+		offset := len(line.text) - len(rest)
+		if _, _, isKey := splitYAMLKey(rest); isKey || isSequenceItem(rest) {
+			line.indent += offset
+			line.text = rest
+			item, err := p.parseNode(line.indent)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		item, err := p.parseValue(rest, indent)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// parseMapping parses the entries of a block mapping indented by indent
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	entries := map[string]interface{}{}
+	for p.skipBlank(); p.pos < len(p.lines); p.skipBlank() {
+		line := p.lines[p.pos]
+		if line.indent < indent || (line.indent == indent && isSequenceItem(line.text)) {
+			break
+		}
+		if line.indent > indent {
+			return nil, p.errorf("bad indentation of a mapping entry")
+		}
+
+		key, rest, isKey := splitYAMLKey(line.text)
+		if !isKey {
+			return nil, p.errorf("expected key: value, got %q", line.text)
+		}
+		if _, exists := entries[key]; exists {
+			return nil, p.errorf("duplicate key %q", key)
+		}
+		if rest == "" || strings.HasPrefix(rest, "#") {
+			p.pos++
+			value, err := p.parseNested(indent)
+			if err != nil {
+				return nil, err
+			}
+			entries[key] = value
+			continue
+		}
+		value, err := p.parseValue(rest, indent)
+		if err != nil {
+			return nil, err
+		}
+		entries[key] = value
+	}
+	return entries, nil
+}
+
+// parseNested parses the node following a key or dash with no value on its line: a block
+// indented further, or a sequence at the same indentation as a mapping key. Nothing is null.
+func (p *yamlParser) parseNested(indent int) (interface{}, error) {
+	p.skipBlank()
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	if next.indent > indent || (next.indent == indent && isSequenceItem(next.text)) {
+		return p.parseNode(next.indent)
+	}
+	return nil, nil
+}
+
+// parseValue parses the value after a key or dash on the current line, consuming the line and,
+// for block scalars, the lines of the block
+func (p *yamlParser) parseValue(text string, indent int) (interface{}, error) {
+	switch text {
+	case "|", "|-", "|+":
+		p.pos++
+		return p.parseBlockScalar(indent, text), nil
+	}
+	if strings.HasPrefix(text, ">") || strings.HasPrefix(text, "|") {
+		return nil, p.errorf("unsupported block scalar %q, use | or |-", text)
+	}
+	value, err := parseYAMLScalar(text)
+	if err != nil {
+		return nil, p.errorf("%v", err)
+	}
+	p.pos++
+	return value, nil
+}
+
+// parseBlockScalar reads the lines of a literal block scalar indented more than indent,
+// keeping their line breaks. "|-" strips the final line break and "|+" keeps trailing blank
+// lines.
+func (p *yamlParser) parseBlockScalar(indent int, style string) string {
+	blockIndent := -1
+	var lines []string
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := p.lines[p.pos]
+		if line.text == "" {
+			lines = append(lines, "")
+			continue
+		}
+		if line.indent <= indent {
+			break
+		}
+		if blockIndent < 0 {
+			blockIndent = line.indent
+		}
+		if line.indent < blockIndent {
+			break
+		}
+		lines = append(lines, line.raw[blockIndent:])
+	}
+
+	// Trailing blank lines belong to the document, not to the block
+	content := len(lines)
+	for content > 0 && lines[content-1] == "" {
+		content--
+	}
+	p.pos -= len(lines) - content
+	text := strings.Join(lines[:content], "\n")
+	switch style {
+	case "|":
+		text += "\n"
+	case "|+":
+		text += strings.Repeat("\n", len(lines)-content+1)
+	}
+	return text
+}
+
+// splitYAMLKey splits a "key: value" line. Keys may be quoted; the value keeps any comment.
+func splitYAMLKey(text string) (string, string, bool) {
+	if strings.HasPrefix(text, "[") || strings.HasPrefix(text, "{") {
+		return "", "", false
+	}
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end := closingQuote(text)
+		if end < 0 || !strings.HasPrefix(text[end+1:], ":") {
+			return "", "", false
+		}
+		rest := text[end+2:]
+		if rest != "" && !strings.HasPrefix(rest, " ") {
+			return "", "", false
+		}
+		key, err := parseYAMLScalar(text[:end+1])
+		if err != nil {
+			return "", "", false
+		}
+		return key.(string), strings.TrimSpace(rest), true
+	}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '#' && (i == 0 || text[i-1] == ' ') {
+			return "", "", false
+		}
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:]), i > 0
+		}
+	}
+	return "", "", false
+}
+
+// closingQuote returns the index of the quote closing the string text starts with, or -1
+func closingQuote(text string) int {
+	quote := text[0]
+	for i := 1; i < len(text); i++ {
+		switch {
+		case quote == '"' && text[i] == '\\':
+			i++
+		case quote == '\'' && text[i] == '\'' && i+1 < len(text) && text[i+1] == '\'':
+			i++
+		case text[i] == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+// parseYAMLScalar parses a scalar: a quoted string, true, false, null, a decimal integer or a
+// plain string. Other numbers are kept as strings; no configuration field is a float.
+func parseYAMLScalar(text string) (interface{}, error) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		end := closingQuote(text)
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string %s", text)
+		}
+		if trailing := strings.TrimSpace(text[end+1:]); trailing != "" && !strings.HasPrefix(trailing, "#") {
+			return nil, fmt.Errorf("unexpected %q after string", trailing)
+		}
+		if text[0] == '\'' {
+			return strings.ReplaceAll(text[1:end], "''", "'"), nil
+		}
+		value, err := strconv.Unquote(text[:end+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid string %s", text[:end+1])
+		}
+		return value, nil
+	}
+
+	// A comment starts at a # preceded by a space
+	if i := strings.Index(text, " #"); i >= 0 && !strings.HasPrefix(text, "[") && !strings.HasPrefix(text, "{") {
+		text = strings.TrimSpace(text[:i])
+	}
+	switch text {
+	case "true", "True", "TRUE":
+		return true, nil
+	case "false", "False", "FALSE":
+		return false, nil
+	case "null", "Null", "NULL", "~":
+		return nil, nil
+	}
+	switch text[0] {
+	case '[', '{':
+		flow := &yamlFlow{text: text}
+		value, err := flow.parse()
+		if err != nil {
+			return nil, err
+		}
+		if flow.skipSpace(); flow.pos < len(text) {
+			return nil, fmt.Errorf("unexpected %q after %s", text[flow.pos:], text[:flow.pos])
+		}
+		return value, nil
+	case '&', '*', '!', '@', '`':
+		return nil, fmt.Errorf("unsupported YAML syntax %q, quote the value if it is a string", text)
+	}
+	if isYAMLInteger(text) {
+		if value, err := strconv.ParseInt(text, 10, 64); err == nil {
+			return value, nil
+		}
+	}
+	return text, nil
+}
+
+// isYAMLInteger reports whether a plain scalar is a decimal integer, e.g. 10 or -1
+func isYAMLInteger(text string) bool {
+	digits := strings.TrimLeft(text, "+-")
+	if len(text)-len(digits) > 1 || digits == "" {
+		return false
+	}
+	return strings.Trim(digits, "0123456789") == ""
+}
+
+// yamlFlow parses a flow collection: [item, ...] or {key: value, ...}, nested or not, with
+// plain or quoted scalars
+type yamlFlow struct {
+	text string
+	pos  int
+}
+
+// skipSpace moves past spaces and, at the end of the collection, a trailing comment
+func (f *yamlFlow) skipSpace() {
+	for f.pos < len(f.text) && f.text[f.pos] == ' ' {
+		f.pos++
+	}
+	if f.pos < len(f.text) && f.text[f.pos] == '#' && f.pos > 0 && f.text[f.pos-1] == ' ' {
+		f.pos = len(f.text)
+	}
+}
+
+// parse parses the value at the current position
+func (f *yamlFlow) parse() (interface{}, error) {
+	f.skipSpace()
+	if f.pos >= len(f.text) {
+		return nil, fmt.Errorf("unterminated flow collection %s", f.text)
+	}
+	switch f.text[f.pos] {
+	case '[':
+		items := []interface{}{}
+		return items, f.entries(']', func() error {
+			item, err := f.parse()
+			items = append(items, item)
+			return err
+		})
+	case '{':
+		entries := map[string]interface{}{}
+		return entries, f.entries('}', func() error {
+			key, err := f.parse()
+			if err != nil {
+				return err
+			}
+			name, ok := key.(string)
+			if !ok || f.pos >= len(f.text) || f.text[f.pos] != ':' {
+				return fmt.Errorf("expected key: value in %s", f.text)
+			}
+			f.pos++
+			value, err := f.parse()
+			entries[name] = value
+			return err
+		})
+	}
+	return f.scalar()
+}
+
+// entries parses the comma separated entries of a collection up to its closing bracket
+func (f *yamlFlow) entries(closing byte, entry func() error) error {
+	f.pos++
+	for {
+		f.skipSpace()
+		if f.pos < len(f.text) && f.text[f.pos] == closing {
+			f.pos++
+			return nil
+		}
+		if err := entry(); err != nil {
+			return err
+		}
+		f.skipSpace()
+		if f.pos >= len(f.text) {
+			return fmt.Errorf("unterminated flow collection %s", f.text)
+		}
+		switch f.text[f.pos] {
+		case ',':
+			f.pos++
+		case closing:
+		default:
+			return fmt.Errorf("expected , or %c in %s", closing, f.text)
+		}
+	}
+}
+
+// scalar parses a scalar ending at a comma, a closing bracket or, for keys, a colon
+func (f *yamlFlow) scalar() (interface{}, error) {
+	start := f.pos
+	if quote := f.text[f.pos]; quote == '"' || quote == '\'' {
+		end := closingQuote(f.text[start:])
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated string in %s", f.text)
+		}
+		f.pos += end + 1
+		return parseYAMLScalar(f.text[start:f.pos])
+	}
+	for f.pos < len(f.text) && !strings.ContainsRune(",]}", rune(f.text[f.pos])) &&
+		!(f.text[f.pos] == ':' && (f.pos+1 == len(f.text) || strings.ContainsRune(" ,]}", rune(f.text[f.pos+1])))) {
+		f.pos++
+	}
+	plain := strings.TrimSpace(f.text[start:f.pos])
+	if plain == "" {
+		return nil, fmt.Errorf("missing value in %s", f.text)
+	}
+	return parseYAMLScalar(plain)
+}
@@ -0,0 +1,144 @@
+package instrument
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want interface{}
+	}{
+		{"empty", "", nil},
+		{"only comments", "# hooks\n\n# none yet\n", nil},
+		{"document marker", "---", nil},
+		{"document marker and newline", "---\n", nil},
+		{"document marker and comment", "---\n# nothing\n", nil},
+		{"document", "---\npackage: example.com/hooks\n", map[string]interface{}{"package": "example.com/hooks"}},
+		{"windows line breaks", "a: x\r\nb: y\r\n", map[string]interface{}{"a": "x", "b": "y"}},
+		{
+			"block mapping",
+			"target:\n  package: main\n  function: \"*\"\nbefore: BeforeCall\n",
+			map[string]interface{}{
+				"target": map[string]interface{}{"package": "main", "function": "*"},
+				"before": "BeforeCall",
+			},
+		},
+		{
+			"block sequence",
+			"hooks:\n  - before: A\n    after: B\n  - rewrite:\n      code: x++\n",
+			map[string]interface{}{"hooks": []interface{}{
+				map[string]interface{}{"before": "A", "after": "B"},
+				map[string]interface{}{"rewrite": map[string]interface{}{"code": "x++"}},
+			}},
+		},
+		{
+			"sequence at the indentation of its key",
+			"files:\n- a.go\n- b.go\nnext: c\n",
+			map[string]interface{}{"files": []interface{}{"a.go", "b.go"}, "next": "c"},
+		},
+		{"nested sequences", "- - a\n  - b\n- c\n", []interface{}{[]interface{}{"a", "b"}, "c"}},
+		{"missing values", "a:\nb: ~\nc: null\n", map[string]interface{}{"a": nil, "b": nil, "c": nil}},
+		{"booleans", "a: true\nb: False\n", map[string]interface{}{"a": true, "b": false}},
+		{
+			"integers",
+			"a: 10\nb: -1\nc: [+2, 0]\nd: 1.21\ne: 1-2\nf: \"3\"\n",
+			map[string]interface{}{
+				"a": int64(10), "b": int64(-1), "c": []interface{}{int64(2), int64(0)},
+				"d": "1.21", "e": "1-2", "f": "3",
+			},
+		},
+		{
+			"quoted scalars",
+			`a: "x: \"y\"\n"` + "\nb: 'it''s # not a comment'\n\"c d\": e\n",
+			map[string]interface{}{"a": "x: \"y\"\n", "b": "it's # not a comment", "c d": "e"},
+		},
+		{
+			"flow collections",
+			"a: [x, 'y, z', {k: v}]\nb: {}\nc: [ ]\n",
+			map[string]interface{}{
+				"a": []interface{}{"x", "y, z", map[string]interface{}{"k": "v"}},
+				"b": map[string]interface{}{},
+				"c": []interface{}{},
+			},
+		},
+		{
+			"comments",
+			"# manifest\na: b # trailing\nc: d#e\nf: # nested below\n  # indented\n  g: h\n",
+			map[string]interface{}{"a": "b", "c": "d#e", "f": map[string]interface{}{"g": "h"}},
+		},
+		{
+			"literal block scalar",
+			"code: |\n  if x {\n      return\n  }\nnext: n\n",
+			map[string]interface{}{"code": "if x {\n    return\n}\n", "next": "n"},
+		},
+		{
+			"block scalar stripping the final line break",
+			"code: |-\n  a\n\n  b\n\nnext: n\n",
+			map[string]interface{}{"code": "a\n\nb", "next": "n"},
+		},
+		{
+			"block scalar keeping trailing blank lines",
+			"code: |+\n  a\n\n\nnext: n\n",
+			map[string]interface{}{"code": "a\n\n\n", "next": "n"},
+		},
+		{"block scalar at the end", "- |\n  a", []interface{}{"a\n"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseYAML(tt.yaml)
+			if err != nil {
+				t.Fatalf("parseYAML() error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseYAML() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseYAMLErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		wantErr string
+	}{
+		{"tab indentation", "a:\n\tb: c\n", "line 2: tabs are not allowed in indentation"},
+		{"duplicate key", "a: 1\nb: 2\na: 3\n", `line 3: duplicate key "a"`},
+		{"bad mapping indentation", "a:\n    b: 1\n  c: 2\n", "line 3: bad indentation of a mapping entry"},
+		{"bad sequence indentation", "- a\n  - b\n", "line 2: bad indentation of a sequence item"},
+		{"not a mapping entry", "a: 1\nplain\n", `line 2: expected key: value, got "plain"`},
+		{"content after the document", "- a\nb: c\n", `line 2: unexpected content "b: c"`},
+		{"folded block scalar", "code: >\n  a\n", `line 1: unsupported block scalar ">"`},
+		{"anchor", "a: 1\nb: &x 2\n", `line 2: unsupported YAML syntax "&x 2"`},
+		{"unterminated string", "a: \"b\n", "line 1: unterminated string"},
+		{"text after a string", "a: 'b' c\n", `line 1: unexpected "c" after string`},
+		{"unterminated flow collection", "a: [b, c\n", "line 1: unterminated flow collection"},
+		{"flow mapping without value", "a: {b}\n", "line 1: expected key: value"},
+		{"missing flow value", "a: [b, , c]\n", "line 1: missing value"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAML(tt.yaml)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseYAML() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestYAMLToJSON(t *testing.T) {
+	got, err := YAMLToJSON([]byte("rules:\n  - name: a\n    enabled: true\n    tags: [x]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"rules":[{"enabled":true,"name":"a","tags":["x"]}]}`; string(got) != want {
+		t.Errorf("YAMLToJSON() = %s, want %s", got, want)
+	}
+	if got, err := YAMLToJSON([]byte("---\n")); err != nil || string(got) != "null" {
+		t.Errorf("YAMLToJSON() of an empty document = %s, %v, want null", got, err)
+	}
+}
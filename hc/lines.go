@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// lineDirectivePrefix starts the //line directives of Go files
+const lineDirectivePrefix = "//line "
+
+// preserveLines controls whether instrumented files get //line directives pointing at the
+// lines of their original source
+var preserveLines bool
+
+// SetPreserveLines enables or disables //line directives in instrumented files
+func SetPreserveLines(enabled bool) {
+	preserveLines = enabled
+}
+
+// withLineDirectives returns content, written to a copy of originalFile, with the //line
+// directives of addLineDirectives when they are enabled
+func withLineDirectives(originalFile string, content []byte) ([]byte, error) {
+	if !preserveLines {
+		return content, nil
+	}
+	original, err := os.ReadFile(originalFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read source file %s: %w", originalFile, err)
+	}
+	return addLineDirectives(originalFile, original, content), nil
+}
+
+// addLineDirectives returns the content of the instrumented copy of sourceFile with //line
+// directives attributing its lines to the original source, so that panics, profiles and
+// coverage refer to the real file and line. Lines kept from the original, found by diffing
+// it with the copy, point at their line; code added by hc points at the original line before
+// it, such as the opening line of an instrumented function. Added comments and blank lines
+// before a kept line are numbered so that it needs no directive, which would separate it from
+// an added //go:noinline. Nothing is added before the package clause. The directives of
+// a copy modified again are replaced, and files with directives of their own, generated from
+// other sources, are left as they are.
+func addLineDirectives(sourceFile string, original, instrumented []byte) []byte {
+	if bytes.HasPrefix(original, []byte(lineDirectivePrefix)) || bytes.Contains(original, []byte("\n"+lineDirectivePrefix)) {
+		return instrumented
+	}
+	if abs, err := filepath.Abs(sourceFile); err == nil {
+		sourceFile = abs
+	}
+	instrumented = removeLineDirectives(instrumented, sourceFile)
+
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", instrumented, parser.PackageClauseOnly)
+	if err != nil {
+		return instrumented
+	}
+	packageLine := fset.Position(file.Package).Line
+
+	// Original line of each line of the copy; added lines get the line they replace or follow
+	lines := splitLines(string(instrumented))
+	originalLines := make([]int, len(lines))
+	added := make([]bool, len(lines))
+	i, j, anchor := 0, 0, 0
+	for _, op := range diffLines(splitLines(string(original)), lines) {
+		switch op.Kind {
+		case ' ':
+			i++
+			anchor = i
+			originalLines[j] = i
+			j++
+		case '-':
+			if anchor == i {
+				anchor = i + 1
+			}
+			i++
+		case '+':
+			originalLines[j] = anchor
+			added[j] = true
+			j++
+		}
+	}
+	inToken := multilineTokenLines(instrumented)
+
+	var b strings.Builder
+	next := 1 // Line the compiler gives the next line of the copy
+	for j, line := range lines {
+		target := originalLines[j]
+		if j+1 < packageLine {
+			target = 0
+		} else if added[j] && isCommentOrBlank(line) {
+			// Number added comments so that the line after them keeps its own
+			k := j
+			for k < len(lines) && added[k] && isCommentOrBlank(lines[k]) {
+				k++
+			}
+			if k < len(lines) && !added[k] && originalLines[k] > k-j {
+				target = originalLines[k] - (k - j)
+			}
+		}
+		if target > 0 && target != next && !inToken[j+1] {
+			fmt.Fprintf(&b, "%s%s:%d\n", lineDirectivePrefix, sourceFile, target)
+			next = target
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+		next++
+	}
+	return []byte(b.String())
+}
+
+// removeLineDirectives removes the //line directives pointing at sourceFile from a copy of it
+func removeLineDirectives(content []byte, sourceFile string) []byte {
+	prefix := []byte(lineDirectivePrefix + sourceFile + ":")
+	if !bytes.Contains(content, prefix) {
+		return content
+	}
+	var b bytes.Buffer
+	for _, line := range bytes.SplitAfter(content, []byte("\n")) {
+		if !bytes.HasPrefix(line, prefix) {
+			b.Write(line)
+		}
+	}
+	return b.Bytes()
+}
+
+// multilineTokenLines returns the lines of a Go file starting inside a raw string literal or
+// a comment, where no //line directive can be inserted
+func multilineTokenLines(src []byte) map[int]bool {
+	lines := make(map[int]bool)
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	var s scanner.Scanner
+	s.Init(file, src, nil, scanner.ScanComments)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		if (tok == token.STRING || tok == token.COMMENT) && strings.Contains(lit, "\n") {
+			start := fset.Position(pos).Line
+			for line := start + 1; line <= start+strings.Count(lit, "\n"); line++ {
+				lines[line] = true
+			}
+		}
+	}
+	return lines
+}
+
+// isCommentOrBlank reports whether a line holds no code
+func isCommentOrBlank(line string) bool {
+	line = strings.TrimSpace(line)
+	return line == "" || strings.HasPrefix(line, "//")
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// linkFlags are the flags of --ldflags added to the link commands of the modified build log
+var linkFlags []string
+
+// SetLinkFlags sets the flags added to the link commands of the modified build log
+func SetLinkFlags(flags []string) {
+	linkFlags = flags
+}
+
+// addLinkFlags returns a link command with flags added after its own
+func addLinkFlags(cmd *parse.Command, flags []string) parse.Command {
+	link := parse.ParseLinkCommand(cmd)
+	if link == nil {
+		return *cmd
+	}
+	link.AddFlags(flags...)
+	return link.Command()
+}
+
+// addReplayLinkFlags adds the flags of --ldflags to the link commands of the build log replayed
+func addReplayLinkFlags(parser *parse.Parser, flags []string) {
+	commands := parser.GetCommands()
+	linked := 0
+	for i := range commands {
+		if !parse.IsLinkCommand(&commands[i]) {
+			continue
+		}
+		commands[i] = addLinkFlags(&commands[i], flags)
+		linked++
+		report.Printf("Added %s to the link of %s\n", strings.Join(flags, " "), parse.ParseLinkCommand(&commands[i]).Output())
+	}
+	if linked == 0 {
+		report.Warnf("--ldflags: %d commands parsed, none of them links\n", len(commands))
+		return
+	}
+	parser.SetCommands(commands)
+	report.Println()
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"fmt"
+	"go/version"
+	"os/exec"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// linknameRestrictedVersion is the first Go release whose linker rejects go:linkname
+// references the target package does not allow, unless linking with -checklinkname=0
+const linknameRestrictedVersion = "go1.23"
+
+// checkLinknameOff is the linker flag that lifts the go:linkname restriction
+const checkLinknameOff = "-checklinkname=0"
+
+// needsCheckLinknameOff reports whether link commands of a build with the given toolchain
+// need -checklinkname=0: the trampolines use go:linkname and the linker restricts it
+func needsCheckLinknameOff(goVersion string) bool {
+	return codegenBackend == BackendLinkname && version.IsValid(goVersion) &&
+		version.Compare(goVersion, linknameRestrictedVersion) >= 0
+}
+
+// buildGoVersion returns the toolchain version a build log was captured with, taken from
+// the -goversion flag of its compile commands
+func buildGoVersion(commands []parse.Command) string {
+	for _, cmd := range commands {
+		if parse.IsCompileCommand(&cmd) {
+			if goVersion := parse.ExtractGoVersion(&cmd); goVersion != "" {
+				return releaseVersion(goVersion)
+			}
+		}
+	}
+	return ""
+}
+
+// toolGoVersion returns the toolchain version of a Go tool from its -V=full output,
+// e.g. "link version go1.24.4" or "link version devel go1.25-abcdef ..."
+func toolGoVersion(toolPath string) string {
+	output, err := exec.Command(toolPath, "-V=full").Output()
+	if err != nil {
+		return ""
+	}
+	return releaseVersion(string(output))
+}
+
+// releaseVersion returns the Go version in the version string of a toolchain, which
+// development toolchains and GOEXPERIMENT builds decorate, e.g. "go1.24.4 X:boringcrypto"
+// or "devel go1.25-abcdef Tue Jun 3 ..." (go1.25). It returns "" if there is none.
+func releaseVersion(s string) string {
+	for _, field := range strings.Fields(s) {
+		if version.IsValid(field) {
+			return field
+		}
+		if goVersion, _, _ := strings.Cut(field, "-"); version.IsValid(goVersion) {
+			return goVersion
+		}
+	}
+	return ""
+}
+
+// checkLinknameState reports whether link arguments already disable the go:linkname check,
+// and whether they explicitly enable it (-checklinkname=1), which hc leaves alone
+func checkLinknameState(args []string) (disabled bool, enabled bool) {
+	for _, arg := range args {
+		switch strings.TrimLeft(arg, "-") {
+		case "checklinkname=0", "checklinkname=false":
+			disabled, enabled = true, false
+		case "checklinkname", "checklinkname=1", "checklinkname=true":
+			disabled, enabled = false, true
+		}
+	}
+	return disabled, enabled
+}
+
+// addCheckLinknameOffArgs adds -checklinkname=0 to link tool arguments. It returns false
+// when the arguments explicitly enable the check.
+func addCheckLinknameOffArgs(args []string) ([]string, bool) {
+	disabled, enabled := checkLinknameState(args)
+	if enabled {
+		return args, false
+	}
+	if disabled {
+		return args, true
+	}
+	return append([]string{checkLinknameOff}, args...), true
+}
+
+// addCheckLinknameOffCommand adds -checklinkname=0 to a link command of the build log
+// right after the link tool. It returns false when the command explicitly enables the check.
+func addCheckLinknameOffCommand(cmd *parse.Command, command string) (string, bool) {
+	disabled, enabled := checkLinknameState(cmd.Args)
+	if enabled {
+		return command, false
+	}
+	if disabled {
+		return command, true
+	}
+	tool := parse.ToolPath(cmd)
+	return strings.Replace(command, tool+" ", tool+" "+checkLinknameOff+" ", 1), true
+}
+
+// applyCheckLinknameOff adds -checklinkname=0 to a link command of the modified build log,
+// warning when the command explicitly enables the check
+func applyCheckLinknameOff(cmd *parse.Command, command string, goVersion string) string {
+	command, ok := addCheckLinknameOffCommand(cmd, command)
+	if !ok {
+		report.Println(checkLinknameWarning(goVersion))
+		return command
+	}
+	report.Printf("           🔗 Added %s to link command (%s restricts go:linkname)\n", checkLinknameOff, goVersion)
+	return command
+}
+
+// checkLinknameWarning explains that the go:linkname trampolines may not link
+func checkLinknameWarning(goVersion string) string {
+	return fmt.Sprintf("⚠️  Warning: the link command enables -checklinkname, so the %s linker may reject the go:linkname trampolines.\n"+
+		"   Remove -checklinkname=1 from -ldflags or build with --backend=shim, which doesn't use go:linkname.", goVersion)
+}
@@ -0,0 +1,152 @@
+// Package logging writes the diagnostics of hc (progress, warnings and errors) with a level,
+// so they can be filtered and told apart from the results of a mode. Messages below the
+// level of a Logger are dropped; debug messages, warnings and errors are prefixed with their
+// level.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Level is the severity of a message
+type Level int
+
+// Levels, from the most to the least verbose
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// String returns the name of the level, as accepted by ParseLevel
+func (l Level) String() string {
+	if l < LevelDebug || l > LevelError {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel returns the level with the given name: debug, info, warn (or warning) or error
+func ParseLevel(name string) (Level, error) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return LevelDebug, nil
+	case "info", "":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	}
+	return LevelInfo, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", name)
+}
+
+// prefixes of the levels, with the ANSI style they are painted in
+var prefixes = map[Level]struct{ text, style string }{
+	LevelDebug: {"debug: ", "2"},
+	LevelWarn:  {"warning: ", "33"},
+	LevelError: {"error: ", "31"},
+}
+
+// Logger writes messages of at least its level to a writer. It is safe for concurrent use.
+type Logger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level Level
+	color bool
+}
+
+// New returns a logger writing messages of level info and above to w
+func New(w io.Writer) *Logger {
+	return &Logger{out: w, level: LevelInfo}
+}
+
+// Default is the logger of packages whose user didn't set one, writing to stdout
+var Default = New(os.Stdout)
+
+// Output returns the writer of the logger
+func (l *Logger) Output() io.Writer {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.out
+}
+
+// SetOutput sets the writer of the logger
+func (l *Logger) SetOutput(w io.Writer) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out = w
+}
+
+// Level returns the level of the least severe messages written
+func (l *Logger) Level() Level {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
+
+// SetLevel sets the level of the least severe messages written
+func (l *Logger) SetLevel(level Level) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level = level
+}
+
+// SetColor paints the level prefixes with ANSI escapes
+func (l *Logger) SetColor(color bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.color = color
+}
+
+// Enabled reports whether messages of the given level are written
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// Logf writes a formatted message of the given level. The level prefix is placed after the
+// leading newlines of the message, so blank lines before it are kept.
+func (l *Logger) Logf(level Level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level < l.level {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+	if prefix, ok := prefixes[level]; ok {
+		text := prefix.text
+		if l.color {
+			text = "\x1b[" + prefix.style + "m" + strings.TrimSuffix(text, " ") + "\x1b[0m "
+		}
+		body := strings.TrimLeft(message, "\n")
+		message = message[:len(message)-len(body)] + text + body
+	}
+	io.WriteString(l.out, message)
+}
+
+// Debugf writes a formatted message of level debug
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.Logf(LevelDebug, format, args...)
+}
+
+// Infof writes a formatted message of level info
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.Logf(LevelInfo, format, args...)
+}
+
+// Warnf writes a formatted message of level warn
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.Logf(LevelWarn, format, args...)
+}
+
+// Errorf writes a formatted message of level error
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.Logf(LevelError, format, args...)
+}
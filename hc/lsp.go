@@ -0,0 +1,387 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+)
+
+// Custom requests of the language server, next to the standard lifecycle and text document
+// notifications
+const (
+	LSPMethodFunctions      = "hc/functions"      // {uri}: FileFunctions of the document
+	LSPMethodCallGraph      = "hc/callGraph"      // {algo}: CallGraphOutput
+	LSPMethodCallGraphQuery = "hc/callGraphQuery" // {function, depth, algo}: analyze.CallGraphQuery
+)
+
+// JSON-RPC error codes used by the language server
+const (
+	lspInvalidParams        = -32602
+	lspMethodNotFound       = -32601
+	lspInternalError        = -32603
+	lspServerNotInitialized = -32002
+	lspRequestFailed        = -32803
+)
+
+// lspMessage is a JSON-RPC request, response or notification
+type lspMessage struct {
+	JSONRPC string           `json:"jsonrpc"`
+	ID      *json.RawMessage `json:"id,omitempty"`
+	Method  string           `json:"method,omitempty"`
+	Params  json.RawMessage  `json:"params,omitempty"`
+	Result  interface{}      `json:"result,omitempty"`
+	Error   *lspError        `json:"error,omitempty"`
+}
+
+type lspError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// LSP structures, as far as the language server uses them
+type (
+	lspPosition struct {
+		Line      int `json:"line"`
+		Character int `json:"character"`
+	}
+	lspRange struct {
+		Start lspPosition `json:"start"`
+		End   lspPosition `json:"end"`
+	}
+	lspDiagnostic struct {
+		Range    lspRange `json:"range"`
+		Severity int      `json:"severity"`
+		Source   string   `json:"source"`
+		Message  string   `json:"message"`
+	}
+	lspTextDocument struct {
+		URI string `json:"uri"`
+	}
+)
+
+// lspSeverityInformation marks diagnostics of instrumented functions, which are not problems
+const lspSeverityInformation = 3
+
+// lspServer is a language server answering the custom requests and publishing a diagnostic on
+// every instrumented function of the open documents, from the caches of a daemon. Requests are
+// handled in the order they arrive.
+type lspServer struct {
+	daemon      *daemon
+	out         io.Writer
+	writeMu     sync.Mutex
+	initialized bool
+	shutdown    bool
+	open        map[string]bool // URIs of the open documents
+}
+
+// runLSP serves the analyses of the build log in logFile, and the hooks of hooksFiles, to an
+// editor speaking the Language Server Protocol on in and out. It returns when the editor exits.
+func runLSP(logFile string, hooksFiles []string, in io.Reader, out io.Writer) error {
+	s := &lspServer{daemon: newDaemon(logFile, hooksFiles), out: out, open: make(map[string]bool)}
+	reader := bufio.NewReader(in)
+	for {
+		body, err := readLSPMessage(reader)
+		if err == io.EOF {
+			return fmt.Errorf("editor closed the connection without exit")
+		}
+		if err != nil {
+			return err
+		}
+		var msg lspMessage
+		if err := json.Unmarshal(body, &msg); err != nil {
+			report.Warnf("lsp: ignoring invalid message: %v\n", err)
+			continue
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return fmt.Errorf("editor exited without shutdown")
+			}
+			return nil
+		}
+		s.handle(&msg)
+	}
+}
+
+// readLSPMessage reads the body of a message framed with a Content-Length header
+func readLSPMessage(r *bufio.Reader) ([]byte, error) {
+	length := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(strings.TrimSpace(name), "Content-Length") {
+			if length, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, fmt.Errorf("invalid Content-Length %q", value)
+			}
+		}
+	}
+	if length < 0 {
+		return nil, fmt.Errorf("message without Content-Length")
+	}
+	body := make([]byte, length)
+	_, err := io.ReadFull(r, body)
+	return body, err
+}
+
+// write sends a message to the editor
+func (s *lspServer) write(msg lspMessage) {
+	msg.JSONRPC = "2.0"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		report.Warnf("lsp: %v\n", err)
+		return
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	fmt.Fprintf(s.out, "Content-Length: %d\r\n\r\n%s", len(body), body)
+}
+
+// handle answers a request or processes a notification
+func (s *lspServer) handle(msg *lspMessage) {
+	isRequest := msg.ID != nil
+	if !s.initialized && msg.Method != "initialize" {
+		if isRequest {
+			s.write(lspMessage{ID: msg.ID, Error: &lspError{Code: lspServerNotInitialized, Message: "server not initialized"}})
+		}
+		return
+	}
+
+	var result interface{}
+	var err error
+	switch msg.Method {
+	case "initialize":
+		s.initialized = true
+		result = map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync": map[string]interface{}{"openClose": true, "change": 0, "save": true},
+				"experimental": map[string]interface{}{
+					"hcRequests": []string{LSPMethodFunctions, LSPMethodCallGraph, LSPMethodCallGraphQuery},
+				},
+			},
+			"serverInfo": map[string]string{"name": "hc"},
+		}
+	case "initialized":
+	case "shutdown":
+		s.shutdown = true
+		result = json.RawMessage("null")
+	case "textDocument/didOpen", "textDocument/didSave":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if json.Unmarshal(msg.Params, &params) == nil {
+			s.open[params.TextDocument.URI] = true
+		}
+		// A saved hooks file changes the diagnostics of every document
+		for _, uri := range s.openDocuments() {
+			s.publishDiagnostics(uri)
+		}
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument lspTextDocument `json:"textDocument"`
+		}
+		if json.Unmarshal(msg.Params, &params) == nil {
+			delete(s.open, params.TextDocument.URI)
+			s.write(lspMessage{Method: "textDocument/publishDiagnostics", Params: mustMarshal(map[string]interface{}{
+				"uri": params.TextDocument.URI, "diagnostics": []lspDiagnostic{},
+			})})
+		}
+	case LSPMethodFunctions:
+		var params struct {
+			URI string `json:"uri"`
+		}
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result, err = s.functions(params.URI)
+		}
+	case LSPMethodCallGraph:
+		var params struct {
+			Algo string `json:"algo"`
+		}
+		json.Unmarshal(msg.Params, &params)
+		result, err = s.callGraph(params.Algo)
+	case LSPMethodCallGraphQuery:
+		var params struct {
+			Function string `json:"function"`
+			Depth    int    `json:"depth"`
+			Algo     string `json:"algo"`
+		}
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result, err = s.callGraphQuery(params.Function, params.Depth, params.Algo)
+		}
+	default:
+		if isRequest {
+			s.write(lspMessage{ID: msg.ID, Error: &lspError{Code: lspMethodNotFound, Message: "method not found: " + msg.Method}})
+		}
+		return
+	}
+
+	if !isRequest {
+		if err != nil {
+			report.Warnf("lsp: %s: %v\n", msg.Method, err)
+		}
+		return
+	}
+	if err != nil {
+		code := lspRequestFailed
+		var invalid errDaemonRequest
+		var syntax *json.SyntaxError
+		if errors.As(err, &invalid) || errors.As(err, &syntax) {
+			code = lspInvalidParams
+		}
+		s.write(lspMessage{ID: msg.ID, Error: &lspError{Code: code, Message: err.Error()}})
+		return
+	}
+	s.write(lspMessage{ID: msg.ID, Result: result})
+}
+
+// openDocuments returns the URIs of the open documents, sorted
+func (s *lspServer) openDocuments() []string {
+	uris := make([]string, 0, len(s.open))
+	for uri := range s.open {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
+}
+
+// functions returns the functions of the document at uri with the hooks matching them
+func (s *lspServer) functions(uri string) (*FileFunctions, error) {
+	file, err := uriPath(uri)
+	if err != nil {
+		return nil, err
+	}
+	s.daemon.mu.Lock()
+	defer s.daemon.mu.Unlock()
+	if err := s.daemon.refresh(); err != nil {
+		return nil, err
+	}
+	return s.daemon.fileFunctions(file)
+}
+
+// callGraph returns the call graph built with the given algorithm
+func (s *lspServer) callGraph(algorithm string) (*CallGraphOutput, error) {
+	if algorithm == "" {
+		algorithm = analyze.CallGraphAlgorithmStatic
+	}
+	s.daemon.mu.Lock()
+	defer s.daemon.mu.Unlock()
+	if err := s.daemon.refresh(); err != nil {
+		return nil, err
+	}
+	cg, err := s.daemon.callGraph(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	result := callGraphOutput(cg, s.daemon.loadPackageInfo())
+	result.CompileCommands = s.daemon.compileCount
+	result.Files = len(s.daemon.files)
+	result.SyntaxErrors = cg.SyntaxErrors
+	return &result, nil
+}
+
+// callGraphQuery returns the callers and callees of a function
+func (s *lspServer) callGraphQuery(function string, depth int, algorithm string) (*analyze.CallGraphQuery, error) {
+	if function == "" {
+		return nil, errDaemonRequest("missing function")
+	}
+	if depth < 0 {
+		return nil, errDaemonRequest(fmt.Sprintf("invalid depth %d", depth))
+	}
+	if algorithm == "" {
+		algorithm = analyze.CallGraphAlgorithmStatic
+	}
+	s.daemon.mu.Lock()
+	defer s.daemon.mu.Unlock()
+	if err := s.daemon.refresh(); err != nil {
+		return nil, err
+	}
+	cg, err := s.daemon.callGraph(algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return analyze.QueryCallGraph(cg, function, depth)
+}
+
+// publishDiagnostics reports the instrumented functions of a document, one diagnostic per
+// hook on the function name. Documents that are not compiled by the build log have none.
+func (s *lspServer) publishDiagnostics(uri string) {
+	diagnostics := []lspDiagnostic{}
+	functions, err := s.functions(uri)
+	var notCompiled errDaemonRequest
+	if err != nil && !errors.As(err, &notCompiled) {
+		report.Warnf("lsp: %v\n", err)
+	}
+	if functions != nil {
+		for _, fn := range functions.Functions {
+			for _, hook := range fn.Hooks {
+				diagnostics = append(diagnostics, lspDiagnostic{
+					Range: lspRange{
+						Start: lspPosition{Line: fn.Line - 1, Character: fn.Column - 1},
+						End:   lspPosition{Line: fn.Line - 1, Character: fn.Column - 1 + len(fn.Name)},
+					},
+					Severity: lspSeverityInformation,
+					Source:   "hc",
+					Message:  hookMatchMessage(hook),
+				})
+			}
+		}
+	}
+	s.write(lspMessage{Method: "textDocument/publishDiagnostics", Params: mustMarshal(map[string]interface{}{
+		"uri": uri, "diagnostics": diagnostics,
+	})})
+}
+
+// hookMatchMessage describes a hook instrumenting a function, e.g. "Instrumented by hook
+// main.handle: BeforeHandle, AfterHandle (hooks/hooks.go)"
+func hookMatchMessage(hook HookMatch) string {
+	var parts []string
+	if hook.Before != "" {
+		parts = append(parts, hook.Before, hook.After)
+	}
+	if hook.Rewrite != "" {
+		parts = append(parts, "rewritten by "+hook.Rewrite)
+	} else if hook.Type == "rewrite" || hook.Type == "both" {
+		parts = append(parts, "rewritten")
+	}
+	message := "Instrumented by hook " + hook.Target
+	if len(parts) > 0 {
+		message += ": " + strings.Join(parts, ", ")
+	}
+	if hook.HooksFile != "" {
+		message += " (" + hook.HooksFile + ")"
+	}
+	return message
+}
+
+// uriPath returns the path of a file:// URI
+func uriPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return "", errDaemonRequest(fmt.Sprintf("not a file URI: %s", uri))
+	}
+	return filepath.FromSlash(u.Path), nil
+}
+
+// mustMarshal returns the JSON of a value that always marshals
+func mustMarshal(v interface{}) json.RawMessage {
+	data, err := json.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
@@ -1,15 +1,33 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+	"github.com/pdelewski/go-build-interceptor/hc/logging"
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
 )
 
 func main() {
+	// Run as the go wrapper of --exec, taking the arguments of go
+	if isGoWrapper() {
+		os.Exit(runGoWrapper(os.Args[1:]))
+	}
+
+	// hc ui runs the web UI, which has flags of its own
+	if len(os.Args) > 1 && os.Args[1] == "ui" {
+		os.Exit(runUI(os.Args[2:]))
+	}
+
 	// Parse command line flags
 	config := ParseFlags()
 
@@ -25,14 +43,21 @@ func main() {
 // Processor handles the main processing logic
 type Processor struct {
 	config *Config
-	parser *Parser
+	parser *parse.Parser
+	report *Reporter
 }
 
-// NewProcessor creates a new processor with the given config
+// NewProcessor creates a new processor with the given config, writing to stdout
 func NewProcessor(config *Config) *Processor {
+	return NewProcessorWithReporter(config, NewReporter(os.Stdout))
+}
+
+// NewProcessorWithReporter creates a new processor writing its output to the reporter
+func NewProcessorWithReporter(config *Config, reporter *Reporter) *Processor {
 	return &Processor{
 		config: config,
-		parser: NewParser(),
+		parser: parse.NewParser(),
+		report: reporter,
 	}
 }
 
@@ -40,26 +65,328 @@ func NewProcessor(config *Config) *Processor {
 func (p *Processor) Run() error {
 	mode := p.config.GetExecutionMode()
 
-	// Capture and compile modes don't need to parse log file initially
-	if mode != "capture" && mode != "json-capture" && mode != "compile" {
+	if p.config.Format != analyze.CallGraphFormatText && p.config.Format != analyze.CallGraphFormatDOT {
+		return fmt.Errorf("unknown output format %q (expected %q or %q)", p.config.Format, analyze.CallGraphFormatText, analyze.CallGraphFormatDOT)
+	}
+	if p.config.Algo != analyze.CallGraphAlgorithmStatic && p.config.Algo != analyze.CallGraphAlgorithmCHA && p.config.Algo != analyze.CallGraphAlgorithmRTA {
+		return fmt.Errorf("unknown call graph algorithm %q (expected %q, %q or %q)", p.config.Algo,
+			analyze.CallGraphAlgorithmStatic, analyze.CallGraphAlgorithmCHA, analyze.CallGraphAlgorithmRTA)
+	}
+	if p.config.Depth < 0 {
+		return fmt.Errorf("--depth must not be negative, got %d", p.config.Depth)
+	}
+	if p.config.Output != OutputText && p.config.Output != OutputJSON {
+		return fmt.Errorf("unknown output format %q (expected %q or %q)", p.config.Output, OutputText, OutputJSON)
+	}
+	if p.config.Output == OutputJSON {
+		if !jsonOutputModes[mode] {
+			return fmt.Errorf("--output=json is not supported in %s mode", mode)
+		}
+		if p.config.Format == analyze.CallGraphFormatDOT {
+			return fmt.Errorf("--output=json and --format=dot cannot be combined")
+		}
+	}
+	// DOT and JSON output must be the only thing on stdout so it can be piped to other tools
+	if ((mode == "callgraph" && p.config.Format == analyze.CallGraphFormatDOT) || p.config.Output == OutputJSON) &&
+		p.report.Log.Output() == p.report.Out {
+		p.report.Log.SetOutput(os.Stderr)
+	}
+	if err := p.report.SetColor(p.config.Color); err != nil {
+		return err
+	}
+	level, err := logging.ParseLevel(p.config.LogLevel)
+	if err != nil {
+		return err
+	}
+	if p.config.Quiet && level < logging.LevelWarn {
+		level = logging.LevelWarn
+	}
+	p.report.Log.SetLevel(level)
+	// Standard output carries the messages of the language server
+	if mode == "lsp" {
+		p.report.Out = os.Stderr
+		p.report.Log.SetOutput(os.Stderr)
+	}
+	// Colors are decided on the terminal, before the output is moved to the pager
+	if pagerModes[mode] && !p.config.NoPager {
+		defer startPager(p.report)()
+	}
+	report = p.report
+	p.parser.SetOutput(p.report.Out)
+	p.parser.SetLogger(p.report.Log)
+	analyze.SetWarningOutput(p.report.Log.Output())
+
+	// Every file of build-metadata is read and written through GetMetadataPath
+	if p.config.MetadataDir == "" {
+		return fmt.Errorf("--metadata-dir must not be empty")
+	}
+	SetMetadataDir(p.config.MetadataDir)
+	if p.config.LogFile == "" {
+		p.config.LogFile = GetMetadataPath(BuildLogFile)
+	}
+	if p.config.DaemonSocket == "" {
+		p.config.DaemonSocket = GetMetadataPath(DaemonSocketFile)
+	}
+
+	// Use custom templates for generated code if provided
+	if p.config.TemplateDir != "" {
+		SetTemplateDir(p.config.TemplateDir)
+	}
+
+	// Apply code generation settings (flags take precedence over project config)
+	projectConfig, err := LoadProjectConfig(".")
+	if err != nil {
+		return err
+	}
+	backend := p.config.Backend
+	if backend == "" {
+		backend = projectConfig.Backend
+	}
+	if err := SetCodegenBackend(backend); err != nil {
+		return err
+	}
+	SetNoInline(p.config.NoInline || projectConfig.NoInline)
+	SetPreserveLines(p.config.PreserveLines || projectConfig.PreserveLines)
+	SetBuildCache(!p.config.NoCache)
+	remoteCache := p.config.RemoteCache
+	if remoteCache == "" {
+		remoteCache = projectConfig.RemoteCache
+	}
+	if err := SetRemoteCache(remoteCache, p.config.RemoteCacheRO); err != nil {
+		return err
+	}
+	panicPolicy := p.config.HookPanicPolicy
+	if panicPolicy == "" {
+		panicPolicy = projectConfig.HookPanicPolicy
+	}
+	if err := SetHookPanicPolicy(panicPolicy); err != nil {
+		return err
+	}
+	if p.config.Jobs < 1 {
+		return fmt.Errorf("-j must be at least 1, got %d", p.config.Jobs)
+	}
+	SetReplayJobs(p.config.Jobs)
+	if p.config.Native && p.config.Jobs > 1 {
+		return fmt.Errorf("--native replays one command at a time, it cannot be combined with -j")
+	}
+	SetReplayNative(p.config.Native)
+	budget := parse.MemoryBudget{}
+	if p.config.MemoryBudget != "" {
+		limit, err := parse.ParseMemorySize(p.config.MemoryBudget)
+		if err != nil {
+			return fmt.Errorf("--memory-budget: %w", err)
+		}
+		budget.Limit = limit
+	}
+	if p.config.MemoryHints != "" {
+		hints, err := parse.ParseMemoryHints(p.config.MemoryHints)
+		if err != nil {
+			return fmt.Errorf("--memory-hints: %w", err)
+		}
+		budget.Hints = hints
+	}
+	SetReplayMemoryBudget(budget)
+	if p.config.Workers != "" {
+		if p.config.Jobs < 2 {
+			return fmt.Errorf("--workers requires -j greater than 1")
+		}
+		workers, err := parse.ParseWorkers(p.config.Workers)
+		if err != nil {
+			return fmt.Errorf("--workers: %w", err)
+		}
+		SetReplayWorkers(workers)
+	}
+	if p.config.NoExecute && mode != "compile" {
+		return fmt.Errorf("--no-execute requires --compile without --preview or --toolexec")
+	}
+	SetNoExecute(p.config.NoExecute)
+	if p.config.VSCode && mode != "dlv-config" {
+		return fmt.Errorf("--vscode requires --dlv-config")
+	}
+	if p.config.Debug && (mode != "compile" || p.config.NoExecute) {
+		return fmt.Errorf("--debug requires --compile without --preview, --plan or --no-execute")
+	}
+	if p.config.DlvDAP != "" && !p.config.Debug {
+		return fmt.Errorf("--dlv-dap requires --debug")
+	}
+	if len(p.config.With) > 0 {
+		registry, err := LoadRegistry(withRegistry(p.config.Registry))
+		if err != nil {
+			return fmt.Errorf("--with: %w", err)
+		}
+		hooksFiles, err := withHooksFiles(registry, p.config.With, p.config.HooksDir, codegenBackend)
+		if err != nil {
+			return fmt.Errorf("--with: %w", err)
+		}
+		p.config.HooksFiles = append(hooksFiles, p.config.HooksFiles...)
+	}
+	rulesFile := p.config.RulesFile
+	if rulesFile == "" && mode == "compile" {
+		rulesFile = projectConfig.Rules
+	}
+	if rulesFile != "" {
+		if mode != "compile" {
+			return fmt.Errorf("--rules requires --compile without --preview or --toolexec")
+		}
+		rules, err := LoadCommandRules(rulesFile)
+		if err != nil {
+			return err
+		}
+		SetCommandRules(rules)
+	}
+	SetGoBinary(p.config.GoBinary)
+	SetAllowToolchainMismatch(p.config.AllowToolchainMismatch)
+	if p.config.BuildArgs != "" {
+		if (mode != "capture" && mode != "json-capture" && mode != "compile") || p.config.Exec {
+			return fmt.Errorf("--build-args requires --capture, --json or --compile without --exec")
+		}
+		args, err := parse.SplitFlags(p.config.BuildArgs)
+		if err != nil {
+			return fmt.Errorf("--build-args: %w", err)
+		}
+		SetBuildArgs(args)
+	}
+	if p.config.CaptureStrategy != CaptureFull {
+		if p.config.CaptureStrategy != CaptureMinimal {
+			return fmt.Errorf("unknown capture strategy %q (expected %q or %q)", p.config.CaptureStrategy, CaptureFull, CaptureMinimal)
+		}
+		if (mode != "capture" && mode != "json-capture" && mode != "compile") || p.config.Exec {
+			return fmt.Errorf("--capture-strategy requires --capture, --json or --compile without --exec")
+		}
+		SetCaptureStrategy(p.config.CaptureStrategy)
+	}
+	if err := p.setupRun(mode); err != nil {
+		return err
+	}
+
+	if mode == "callgraph-diff" && flag.NArg() != 1 {
+		return fmt.Errorf("--callgraph-diff takes the old and the new call graph: hc --callgraph-diff old.json new.json")
+	}
+
+	// Version, capture, exec, compile, toolexec, dump-templates, hooks bundle, registry, snapshot, call graph diff, uninstrument, daemon and language server modes don't need to parse log file initially
+	if mode != "version" && mode != "capture" && mode != "exec" && mode != "json-capture" && mode != "compile" && mode != "toolexec" && mode != "worker" && mode != "daemon" && mode != "lsp" && mode != "dump-templates" &&
+		mode != "export-hooks" && mode != "import-hooks" && mode != "scan-annotations" && mode != "scaffold-hooks" && mode != "list-instrumentations" && mode != "add-instrumentation" &&
+		mode != "snapshot-create" && mode != "snapshot-restore" && mode != "snapshot-list" && mode != "callgraph-diff" && mode != "uninstrument" {
 		// Parse the log file
 		if err := p.parser.ParseFile(p.config.LogFile); err != nil {
 			return fmt.Errorf("error parsing file: %w", err)
 		}
 
 		commands := p.parser.GetCommands()
-		fmt.Printf("Parsed %d commands from %s\n\n", len(commands), p.config.LogFile)
+		report.Printf("Parsed %d commands from %s\n\n", len(commands), p.config.LogFile)
+	}
+
+	if p.config.OnlyPackage != "" {
+		if err := p.selectPackage(mode); err != nil {
+			return err
+		}
+	}
+
+	if p.config.LinkFlags != "" {
+		flags, err := parse.SplitFlags(p.config.LinkFlags)
+		if err != nil {
+			return fmt.Errorf("--ldflags: %w", err)
+		}
+		switch mode {
+		case "compile":
+			SetLinkFlags(flags)
+		case "execute", "dry-run", "generate", "interactive":
+			addReplayLinkFlags(p.parser, flags)
+		default:
+			return fmt.Errorf("--ldflags requires --compile, --execute, --dry-run, --generate or --interactive")
+		}
+	}
+
+	if p.config.BinaryOut != "" {
+		switch mode {
+		case "compile":
+			SetBinaryOut(p.config.BinaryOut)
+		case "execute", "dry-run", "generate", "interactive":
+			if err := redirectReplayBinaries(p.parser, p.config.BinaryOut); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("--out requires --compile, --execute, --dry-run, --generate or --interactive")
+		}
+	}
+
+	// Modes replaying the build log run it with the toolchain it was captured with
+	if mode == "execute" || mode == "interactive" || mode == "generate" {
+		if err := pinToolchain(p.config.LogFile); err != nil {
+			return err
+		}
+		applyReplayToolchain(p.parser)
 	}
 
 	// Set up WORK environment if needed
 	if err := p.setupWorkEnvironment(); err != nil {
 		return err
 	}
+	if p.config.FreshWork {
+		if mode != "execute" && mode != "interactive" {
+			return fmt.Errorf("--fresh-work requires --execute or --interactive")
+		}
+		replayInFreshWork(p.parser, os.Getenv("WORK"))
+	}
 
 	// Execute based on mode
 	return p.executeMode()
 }
 
+// setupRun selects the run of build-metadata/runs the mode writes its generated files to.
+// Compile and preview start a new run; replaying modes start one too, or replay the modified
+// build log of the run given with --run-id; modes reading generated files use the latest run.
+func (p *Processor) setupRun(mode string) error {
+	switch mode {
+	case "compile", "preview":
+		return startRun(p.config.RunID)
+	case "execute", "generate", "interactive":
+		if p.config.RunID == "" {
+			return startRun("")
+		}
+		if err := selectRun(p.config.RunID); err != nil {
+			return err
+		}
+		logSet := false
+		flag.Visit(func(f *flag.Flag) { logSet = logSet || f.Name == "log" })
+		if !logSet {
+			p.config.LogFile = GetMetadataPath(BuildModifiedLogFile)
+		}
+		return nil
+	case "source-mappings", "weaving-report":
+		return selectRun(p.config.RunID)
+	case "dlv-config":
+		// The binaries are found in the modified build log of the run
+		if err := selectRun(p.config.RunID); err != nil {
+			return err
+		}
+		logSet := false
+		flag.Visit(func(f *flag.Flag) { logSet = logSet || f.Name == "log" })
+		if !logSet {
+			p.config.LogFile = GetMetadataPath(BuildModifiedLogFile)
+		}
+		return nil
+	}
+	if p.config.RunID != "" {
+		return fmt.Errorf("--run-id is not supported in %s mode", mode)
+	}
+	return nil
+}
+
+// selectPackage keeps only the commands of the build log building the package of --only-package
+func (p *Processor) selectPackage(mode string) error {
+	if mode != "execute" && mode != "dry-run" && mode != "generate" && mode != "interactive" {
+		return fmt.Errorf("--only-package requires --execute, --dry-run, --generate or --interactive")
+	}
+	selection, err := p.parser.SelectPackage(p.config.OnlyPackage)
+	if err != nil {
+		return err
+	}
+	report.Printf("Selected %d commands of %d actions building %s (%d commands dropped): %s\n\n",
+		selection.Commands, len(selection.Actions), p.config.OnlyPackage, selection.Dropped, strings.Join(selection.Actions, " "))
+	return nil
+}
+
 // setupWorkEnvironment creates a temp work directory if needed
 func (p *Processor) setupWorkEnvironment() error {
 	mode := p.config.GetExecutionMode()
@@ -69,7 +396,7 @@ func (p *Processor) setupWorkEnvironment() error {
 			return fmt.Errorf("failed to create temp directory: %w", err)
 		}
 		os.Setenv("WORK", tmpDir)
-		fmt.Printf("Created WORK directory: %s\n\n", tmpDir)
+		report.Printf("Created WORK directory: %s\n\n", tmpDir)
 
 		// Note: We don't defer cleanup here since the commands might need the directory
 		// The directory will be cleaned up when the program exits
@@ -83,29 +410,208 @@ func (p *Processor) executeMode() error {
 	commands := p.parser.GetCommands()
 
 	switch mode {
+	case "version":
+		report.Println(hcVersion())
+	case "toolexec":
+		// Runs once per toolchain invocation, so nothing is printed around it
+		return runToolexec(ToolexecOptions{
+			HooksFiles:      p.config.HooksFiles,
+			Backend:         codegenBackend,
+			HookPanicPolicy: hookPanicPolicy,
+			NoInline:        noInline,
+			PreserveLines:   preserveLines,
+			Verbose:         p.config.Verbose,
+			LogLevel:        p.report.Log.Level(),
+		}, flag.Args())
+	case "worker":
+		report.Println("=== Worker Mode ===")
+		token := os.Getenv(parse.WorkerTokenEnv)
+		if err := parse.CheckWorkerListen(p.config.WorkerListen, token); err != nil {
+			return err
+		}
+		if token == "" {
+			report.Warnf("%s is not set, only clients on this machine can run commands\n", parse.WorkerTokenEnv)
+		}
+		report.Printf("Replaying build actions of hc --workers on %s\n", p.config.WorkerListen)
+		return http.ListenAndServe(p.config.WorkerListen, parse.WorkerHandler(token))
+	case "daemon":
+		report.Println("=== Daemon Mode ===")
+		return runDaemon(p.config.LogFile, p.config.HooksFiles, p.config.DaemonSocket)
+	case "lsp":
+		return runLSP(p.config.LogFile, p.config.HooksFiles, os.Stdin, os.Stdout)
+	case "dump-templates":
+		report.Println("=== Dump Templates Mode ===")
+		report.Printf("Writing embedded templates to %s:\n", p.config.DumpTemplates)
+		if err := dumpTemplates(p.config.DumpTemplates); err != nil {
+			return fmt.Errorf("failed to dump templates: %w", err)
+		}
+		report.Printf("\nEdit the templates and pass --template-dir %s to use them.\n", p.config.DumpTemplates)
+	case "export-hooks":
+		report.Println("=== Export Hooks Mode ===")
+		if len(p.config.HooksFiles) == 0 {
+			return fmt.Errorf("no hooks file specified, use --export-hooks <bundle> --compile <hooks_file>")
+		}
+		manifest, err := exportHooksBundle(p.config.ExportHooks, p.config.HooksFiles, p.config.BundleVersion)
+		if err != nil {
+			return fmt.Errorf("failed to export hooks: %w", err)
+		}
+		report.Printf("📦 Wrote %s %s (%d hooks, %d files) to %s\n",
+			manifest.Name, manifest.Version, len(manifest.Hooks), len(manifest.Files), p.config.ExportHooks)
+		for _, hook := range manifest.Hooks {
+			report.Printf("  - %s\n", instrument.HookTarget(instrument.HookDefinition{Package: hook.Package, Function: hook.Function, Receiver: hook.Receiver, File: hook.File}))
+		}
+	case "snapshot-create":
+		report.Println("=== Snapshot Mode ===")
+		manifest, err := createSnapshot(p.config.SnapshotCreate, p.config.HooksFiles)
+		if err != nil {
+			return fmt.Errorf("failed to create snapshot: %w", err)
+		}
+		report.Printf("📸 Saved snapshot %s (%d files, %s) of WORK directory %s\n",
+			manifest.Name, manifest.Files, parse.FormatMemorySize(manifest.Size), manifest.WorkDir)
+		report.Printf("\nRestore it with: hc --snapshot-restore %s\n", manifest.Name)
+	case "snapshot-restore":
+		report.Println("=== Snapshot Restore Mode ===")
+		manifest, err := restoreSnapshot(p.config.SnapshotRestore)
+		if err != nil {
+			return fmt.Errorf("failed to restore snapshot: %w", err)
+		}
+		report.Printf("✅ Restored snapshot %s taken %s (%d files) into %s and %s\n",
+			manifest.Name, manifest.CreatedAt.Local().Format("2006-01-02 15:04"), manifest.Files, manifest.WorkDir, MetadataDir)
+		if len(manifest.HooksFiles) > 0 {
+			report.Printf("Hooks files: %s\n", strings.Join(manifest.HooksFiles, ", "))
+		}
+		report.Printf("\nReplay it with: hc --execute --log %s\n", GetMetadataPath(BuildModifiedLogFile))
+	case "uninstrument":
+		return uninstrument()
+	case "snapshot-list":
+		manifests, err := listSnapshots()
+		if err != nil {
+			return fmt.Errorf("failed to list snapshots: %w", err)
+		}
+		if len(manifests) == 0 {
+			report.Printf("No snapshots in %s\n", SnapshotDir)
+			break
+		}
+		for _, manifest := range manifests {
+			report.Resultf("%-20s %s  %6d files  %10s  %s\n", manifest.Name, manifest.CreatedAt.Local().Format("2006-01-02 15:04"),
+				manifest.Files, parse.FormatMemorySize(manifest.Size), strings.Join(manifest.HooksFiles, ","))
+		}
+	case "import-hooks":
+		report.Println("=== Import Hooks Mode ===")
+		manifest, hooksFiles, err := importHooksBundle(p.config.ImportHooks, p.config.HooksDir)
+		if err != nil {
+			return fmt.Errorf("failed to import hooks: %w", err)
+		}
+		report.Printf("✅ Installed %s %s (%d hooks) into %s\n",
+			manifest.Name, manifest.Version, len(manifest.Hooks), filepath.Join(p.config.HooksDir, manifest.Name))
+		for _, hook := range manifest.Hooks {
+			report.Printf("  - %s\n", instrument.HookTarget(instrument.HookDefinition{Package: hook.Package, Function: hook.Function, Receiver: hook.Receiver, File: hook.File}))
+		}
+		report.Printf("\nBuild with: hc --compile %s\n", strings.Join(hooksFiles, ","))
+	case "scan-annotations":
+		report.Println("=== Scan Annotations Mode ===")
+		hooksDir, err := filepath.Abs(filepath.Dir(p.config.ScanAnnotations))
+		if err != nil {
+			return err
+		}
+		found, err := scanAnnotations(".", hooksDir)
+		if err != nil {
+			return fmt.Errorf("failed to scan annotations: %w", err)
+		}
+		report.Printf("Found %d function(s) annotated with %s\n", len(found), HookAnnotation)
+		added, created, err := extendHooksFile(p.config.ScanAnnotations, found, "annotated with "+HookAnnotation)
+		if err != nil {
+			return fmt.Errorf("failed to update hooks file: %w", err)
+		}
+		if created {
+			report.Printf("📝 Created %s\n", p.config.ScanAnnotations)
+		}
+		report.Printf("Added %d hook(s) to %s\n", len(added), p.config.ScanAnnotations)
+		for _, hook := range added {
+			report.Resultf("  + %s (%s, %s)\n", instrument.HookTarget(hook), hook.BeforeFunc, hook.AfterFunc)
+		}
+		if len(added) > 0 {
+			report.Printf("\nImplement the new Before/After functions, then build with: hc --compile %s\n", p.config.ScanAnnotations)
+		}
+	case "scaffold-hooks":
+		report.Println("=== Scaffold Hooks Mode ===")
+		hooksDir, err := filepath.Abs(filepath.Dir(p.config.ScaffoldOutput))
+		if err != nil {
+			return err
+		}
+		found, err := scaffoldFunctions(".", p.config.ScaffoldHooks, hooksDir)
+		if err != nil {
+			return fmt.Errorf("failed to scan packages: %w", err)
+		}
+		report.Printf("Found %d exported function(s) in %s\n", len(found), p.config.ScaffoldHooks)
+		added, created, err := extendHooksFile(p.config.ScaffoldOutput, found, "exported by "+p.config.ScaffoldHooks)
+		if err != nil {
+			return fmt.Errorf("failed to update hooks file: %w", err)
+		}
+		if created {
+			report.Printf("📝 Created %s\n", p.config.ScaffoldOutput)
+		}
+		report.Printf("Added %d hook(s) to %s\n", len(added), p.config.ScaffoldOutput)
+		for _, hook := range added {
+			report.Resultf("  + %s (%s, %s)\n", instrument.HookTarget(hook), hook.BeforeFunc, hook.AfterFunc)
+		}
+		if len(added) > 0 {
+			report.Printf("\nImplement the Before/After functions and delete the hooks you don't need, then build with: hc --compile %s\n", p.config.ScaffoldOutput)
+		}
+	case "list-instrumentations":
+		report.Println("=== List Instrumentations Mode ===")
+		registry, err := LoadRegistry(p.config.Registry)
+		if err != nil {
+			return err
+		}
+		listInstrumentations(registry, p.config.HooksDir, codegenBackend)
+	case "add-instrumentation":
+		report.Println("=== Add Instrumentation Mode ===")
+		registry, err := LoadRegistry(p.config.Registry)
+		if err != nil {
+			return err
+		}
+		installed, err := addInstrumentation(registry, p.config.AddInstrumentation, p.config.HooksDir, codegenBackend)
+		var hooksFiles []string
+		for _, inst := range installed {
+			report.Printf("✅ Installed %s %s into %s\n", inst.Name, inst.Version, inst.Dir)
+			hooksFiles = append(hooksFiles, inst.HooksFiles...)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to add instrumentation: %w", err)
+		}
+		report.Printf("\nBuild with: hc --compile %s\n", strings.Join(hooksFiles, ","))
 	case "capture":
-		fmt.Println("=== Capture Mode ===")
-		capturer := &TextCapturer{}
+		report.Println("=== Capture Mode ===")
+		capturer := newCapturer(false)
 		if err := capturer.Capture(); err != nil {
 			return fmt.Errorf("capture failed: %w", err)
 		}
-		fmt.Println(capturer.GetDescription())
+		report.Println(capturer.GetDescription())
+	case "exec":
+		report.Println("=== Exec Capture Mode ===")
+		if err := runExecCapture(flag.Args()); err != nil {
+			return fmt.Errorf("exec capture failed: %w", err)
+		}
 	case "json-capture":
-		fmt.Println("=== JSON Capture Mode ===")
-		capturer := &JSONCapturer{}
+		report.Println("=== JSON Capture Mode ===")
+		capturer := newCapturer(true)
 		if err := capturer.Capture(); err != nil {
 			return fmt.Errorf("JSON capture failed: %w", err)
 		}
-		fmt.Println(capturer.GetDescription())
+		report.Println(capturer.GetDescription())
 	case "pack-packages":
-		fmt.Println("=== Pack Packages Mode ===")
+		report.Println("=== Pack Packages Mode ===")
+		if p.config.Output == OutputJSON {
+			return writeJSON(p.report.Out, packPackagesOutput(commands))
+		}
 		compileCount := 0
 		packageNames := make(map[string]int)
 
 		for _, cmd := range commands {
-			if isCompileCommand(&cmd) {
+			if parse.IsCompileCommand(&cmd) {
 				compileCount++
-				packageName := extractPackageName(&cmd)
+				packageName := parse.ExtractPackageName(&cmd)
 				if packageName != "" {
 					packageNames[packageName]++
 				}
@@ -113,64 +619,86 @@ func (p *Processor) executeMode() error {
 		}
 
 		if len(packageNames) > 0 {
-			fmt.Printf("Found %d unique packages in %d compile commands:\n\n", len(packageNames), compileCount)
+			report.Printf("Found %d unique packages in %d compile commands:\n\n", len(packageNames), compileCount)
+			if report.Columns {
+				printPackagesTable(packageNames)
+				break
+			}
 			for pkg, count := range packageNames {
-				fmt.Printf("  - %s", pkg)
+				report.Resultf("  - %s", pkg)
 				if count > 1 {
-					fmt.Printf(" (compiled %d times)", count)
+					report.Resultf(" (compiled %d times)", count)
 				}
-				fmt.Println()
+				report.Resultln()
 			}
 		} else {
-			fmt.Println("No package names found in compile commands.")
+			report.Println("No package names found in compile commands.")
 		}
 	case "pack-packagepath":
-		fmt.Println("=== Pack Package Path Mode ===")
+		report.Println("=== Pack Package Path Mode ===")
+		if p.config.Output == OutputJSON {
+			return writeJSON(p.report.Out, packPackagePathOutput(commands))
+		}
 		compileCount := 0
 		packageInfo := extractPackagePathInfo(commands)
 
 		// Count compile commands
 		for _, cmd := range commands {
-			if isCompileCommand(&cmd) {
+			if parse.IsCompileCommand(&cmd) {
 				compileCount++
 			}
 		}
 
 		if len(packageInfo) > 0 {
-			fmt.Printf("Found %d unique packages with paths in %d compile commands:\n\n", len(packageInfo), compileCount)
+			report.Printf("Found %d unique packages with paths in %d compile commands:\n\n", len(packageInfo), compileCount)
 			for pkg, info := range packageInfo {
-				fmt.Printf("  - Package: %s\n", pkg)
-				fmt.Printf("    Path: %s\n", info.Path)
-				fmt.Printf("    Work: %s\n", info.BuildID)
+				report.Resultf("  - Package: %s\n", pkg)
+				report.Resultf("    Path: %s\n", info.Path)
+				report.Resultf("    Work: %s\n", info.BuildID)
 			}
 		} else {
-			fmt.Println("No package paths found in compile commands.")
+			report.Println("No package paths found in compile commands.")
 		}
 	case "pack-functions":
-		fmt.Println("=== Pack Functions Mode ===")
+		report.Println("=== Pack Functions Mode ===")
+		if p.config.Output == OutputJSON {
+			return writeJSON(p.report.Out, packFunctionsOutput(commands, analyze.ExtractFunctionsFromGoFileWithErrors))
+		}
 		compileCount := 0
 		totalFuncs := 0
+		var syntaxErrors []analyze.SyntaxError
+		var functionsTable *table
+		if report.Columns {
+			functionsTable = report.newTable("  ")
+		}
 
+		cgoSources := parse.NewCgoSources(commands)
 		for _, cmd := range commands {
-			if isCompileCommand(&cmd) {
+			if parse.IsCompileCommand(&cmd) {
 				compileCount++
-				files := extractPackFiles(&cmd)
+				files := cgoSources.SourceFiles(&cmd)
 				for _, file := range files {
 					// Only process .go files
 					if strings.HasSuffix(file, ".go") {
-						functions, err := extractFunctionsFromGoFile(file)
+						functions, fileSyntaxErrors, err := analyze.ExtractFunctionsFromGoFileWithErrors(file)
 						if err != nil {
-							fmt.Printf("  Error parsing %s: %v\n", file, err)
+							report.Errorf("parsing %s: %v\n", file, err)
 							continue
 						}
-						if len(functions) > 0 {
-							fmt.Printf("\nFile: %s\n", file)
+						syntaxErrors = append(syntaxErrors, fileSyntaxErrors...)
+						if len(functions) > 0 && functionsTable != nil {
+							functionsTable.line("")
+							functionsTable.line(report.paint(styleBold, "File: "+file))
+							addFunctionRows(functionsTable, functions)
+							totalFuncs += len(functions)
+						} else if len(functions) > 0 {
+							report.Resultf("\nFile: %s\n", file)
 							for _, fn := range functions {
-								fmt.Printf("  - %s", FormatFunctionSignature(fn))
+								report.Resultf("  - %s", analyze.FormatFunctionSignature(fn))
 								if fn.IsExported {
-									fmt.Print(" [exported]")
+									report.Result(" [exported]")
 								}
-								fmt.Println()
+								report.Resultln()
 								totalFuncs++
 							}
 						}
@@ -179,243 +707,310 @@ func (p *Processor) executeMode() error {
 			}
 		}
 
+		if functionsTable != nil {
+			functionsTable.flush()
+		}
+		report.Print(analyze.FormatSyntaxErrors(syntaxErrors))
+
 		if compileCount > 0 {
-			fmt.Printf("\nProcessed %d compile commands, found %d functions/methods.\n", compileCount, totalFuncs)
+			report.Printf("\nProcessed %d compile commands, found %d functions/methods.\n", compileCount, totalFuncs)
 		} else {
-			fmt.Println("No compile commands found.")
+			report.Println("No compile commands found.")
 		}
 	case "callgraph":
-		fmt.Println("=== Call Graph Mode ===")
-		compileCount := 0
-		var allFiles []string
+		report.Println("=== Call Graph Mode ===")
+		compileCount, allFiles := compiledGoFiles(commands)
 
-		// Collect all Go files from compile commands
-		for _, cmd := range commands {
-			if isCompileCommand(&cmd) {
-				compileCount++
-				files := extractPackFiles(&cmd)
-				for _, file := range files {
-					if strings.HasSuffix(file, ".go") {
-						allFiles = append(allFiles, file)
-					}
-				}
+		if p.config.Output == OutputJSON {
+			result, err := buildCallGraphOutput(allFiles, p.config.Algo)
+			if err != nil {
+				return err
 			}
+			result.CompileCommands = compileCount
+			return writeJSON(p.report.Out, result)
 		}
 
 		if len(allFiles) > 0 {
 			// Get package information to filter only current module functions
-			packageInfo, err := getPackageInfo(".")
+			packageInfo, err := analyze.GetPackageInfo(".")
 			if err != nil {
-				fmt.Printf("Warning: Could not load package info: %v\n", err)
-				fmt.Println("Building call graph without package filtering...")
+				report.Warnf("could not load package info: %v\n", err)
+				report.Println("Building call graph without package filtering...")
 				packageInfo = nil
 			}
 
 			// Build the call graph with package filtering
-			callGraph, err := BuildCallGraphWithPackageFilter(allFiles, packageInfo)
+			callGraph, err := analyze.BuildCallGraphWithAlgorithm(allFiles, packageInfo, p.config.Algo)
 			if err != nil {
-				fmt.Printf("Error building call graph: %v\n", err)
+				report.Errorf("building call graph: %v\n", err)
 			} else {
 				// Format and display the call graph
 				var output string
-				if packageInfo != nil {
-					output = FormatCallGraphWithFilter(callGraph, packageInfo)
+				if p.config.Format == analyze.CallGraphFormatDOT {
+					output = analyze.FormatCallGraphDOT(callGraph, packageInfo)
+				} else if packageInfo != nil {
+					output = analyze.FormatCallGraphWithFilter(callGraph, packageInfo)
 				} else {
-					output = FormatCallGraph(callGraph)
+					output = analyze.FormatCallGraph(callGraph)
 				}
-				fmt.Print(output)
+				fmt.Fprint(p.report.Out, output)
+				report.Print(analyze.FormatSyntaxErrors(callGraph.SyntaxErrors))
 			}
 		} else {
-			fmt.Println("No Go files found in compile commands.")
+			report.Println("No Go files found in compile commands.")
 		}
 
 		if compileCount > 0 {
-			fmt.Printf("Processed %d compile commands with %d Go files.\n", compileCount, len(allFiles))
+			report.Printf("Processed %d compile commands with %d Go files.\n", compileCount, len(allFiles))
 		} else {
-			fmt.Println("No compile commands found.")
+			report.Println("No compile commands found.")
 		}
 	case "compile":
-		fmt.Println("=== Compile Mode ===")
+		report.Println("=== Compile Mode ===")
 		if len(p.config.HooksFiles) == 0 {
-			fmt.Println("Error: No hooks file specified. Use --compile <hooks_file> or -c <hooks_file>")
-			fmt.Println("       Multiple files can be specified: --compile file1.go,file2.go or --compile file1.go --compile file2.go")
+			report.Println("Error: No hooks file specified. Use --compile <hooks_file> or -c <hooks_file>")
+			report.Println("       Multiple files can be specified: --compile file1.go,file2.go or --compile file1.go --compile file2.go")
 			break
 		}
 
-		fmt.Printf("Using %d hooks file(s):\n", len(p.config.HooksFiles))
+		report.Printf("Using %d hooks file(s):\n", len(p.config.HooksFiles))
 		for _, hf := range p.config.HooksFiles {
-			fmt.Printf("  - %s\n", hf)
+			report.Printf("  - %s\n", hf)
 		}
-		fmt.Println()
+		report.Println()
 
-		// First capture the build log like --json does
-		fmt.Println("Capturing build output...")
-		capturer := &JSONCapturer{}
-		if err := capturer.Capture(); err != nil {
-			fmt.Printf("Error capturing build output: %v\n", err)
+		// First capture the build log like --json does, or like --exec through the command
+		report.Println("Capturing build output...")
+		if p.config.Exec {
+			if err := runExecCapture(flag.Args()); err != nil {
+				report.Errorf("capturing build output: %v\n", err)
+				break
+			}
+		} else {
+			capturer := newCapturer(true)
+			if err := capturer.Capture(); err != nil {
+				report.Errorf("capturing build output: %v\n", err)
+				break
+			}
+			report.Println(capturer.GetDescription())
+		}
+		if err := pinToolchain(p.config.LogFile); err != nil {
+			report.Errorf("%v\n", err)
 			break
 		}
-		fmt.Println(capturer.GetDescription())
 
 		// Now parse the generated log file
 		if err := p.parser.ParseFile(p.config.LogFile); err != nil {
-			fmt.Printf("Error parsing captured log file: %v\n", err)
+			report.Errorf("parsing captured log file: %v\n", err)
 			break
 		}
 
 		commands = p.parser.GetCommands()
-		fmt.Printf("Parsed %d commands from captured build\n\n", len(commands))
+		report.Printf("Parsed %d commands from captured build\n\n", len(commands))
 
 		// Process with hooks (multiple files)
+		instrumentStart = time.Now()
 		if err := processCompileWithMultipleHooks(commands, p.config.HooksFiles); err != nil {
-			fmt.Printf("Error in compile mode: %v\n", err)
+			report.Errorf("compile mode: %v\n", err)
+			break
+		}
+
+		// Without execution, also write the diffs of the instrumented files for inspection
+		if p.config.NoExecute {
+			if err := previewInstrumentation(commands, p.config.HooksFiles); err != nil {
+				report.Errorf("writing preview report: %v\n", err)
+			}
+		}
+
+		if p.config.Debug {
+			// With --exec, the arguments are the command capturing the build
+			var programArgs []string
+			if !p.config.Exec {
+				programArgs = flag.Args()
+			}
+			return debugBinary(programArgs, p.config.DlvDAP, instrumentStart)
+		}
+	case "plan":
+		report.Println("=== Instrumentation Plan Mode ===")
+		plan, err := planInstrumentation(commands, p.config.HooksFiles)
+		if err != nil {
+			return err
+		}
+		if p.config.Output == OutputJSON {
+			return writeJSON(p.report.Out, plan)
+		}
+		printInstrumentationPlan(plan)
+	case "preview":
+		report.Println("=== Instrumentation Preview Mode ===")
+		if err := previewInstrumentation(commands, p.config.HooksFiles); err != nil {
+			report.Errorf("preview mode: %v\n", err)
 		}
 	case "workdir":
-		fmt.Println("=== Work Directory Mode ===")
+		report.Println("=== Work Directory Mode ===")
+		if p.config.Output == OutputJSON {
+			result, err := workDirOutput(commands)
+			if err != nil {
+				return err
+			}
+			return writeJSON(p.report.Out, result)
+		}
 		if len(commands) == 0 {
-			fmt.Println("No commands found in log file.")
+			report.Println("No commands found in log file.")
 			break
 		}
 
 		// Get the first command
 		firstCmd := commands[0]
-		fmt.Printf("First command: %s\n", firstCmd.Raw)
+		report.Printf("First command: %s\n", firstCmd.Raw)
 
 		// Extract WORK= environment variable
 		workDir := extractWorkDir(firstCmd.Raw)
 		if workDir == "" {
-			fmt.Println("No WORK= environment variable found in first command.")
+			report.Println("No WORK= environment variable found in first command.")
 			break
 		}
 
-		fmt.Printf("Found WORK directory: %s\n\n", workDir)
+		report.Printf("Found WORK directory: %s\n\n", workDir)
 
 		// Dump all directories and files in the work directory
 		if err := dumpWorkDir(workDir); err != nil {
-			fmt.Printf("Error dumping work directory: %v\n", err)
+			report.Errorf("dumping work directory: %v\n", err)
 		}
 
 	case "source-mappings":
-		fmt.Println("=== Source Mappings Mode ===")
+		report.Println("=== Source Mappings Mode ===")
 		if err := generateSourceMappingsFromExisting(); err != nil {
-			fmt.Printf("Error generating source mappings: %v\n", err)
+			report.Errorf("generating source mappings: %v\n", err)
 		}
 
-	case "pack-files":
-		fmt.Println("=== Pack Files Mode ===")
-		compileCount := 0
-		totalFiles := 0
+	case "dlv-config":
+		report.Println("=== Debugger Configuration Mode ===")
+		return writeDebuggerConfig(commands, p.config.VSCode)
 
-		for _, cmd := range commands {
-			if isCompileCommand(&cmd) {
-				compileCount++
-				files := extractPackFiles(&cmd)
-				if len(files) > 0 {
-					totalFiles += len(files)
-					fmt.Printf("Compile command %d: Found %d files after -pack flag:\n", compileCount, len(files))
-
-					// Process each file with a custom action
-					processPackFiles(files, func(file string) {
-						fmt.Printf("  - %s\n", file)
-						// Add your custom action here for each file
-						// For example: analyzeFile(file), transformFile(file), etc.
-					})
-					fmt.Println()
-				}
-			}
+	case "callgraph-query":
+		report.Println("=== Call Graph Query Mode ===")
+		_, allFiles := compiledGoFiles(commands)
+		packageInfo, err := analyze.GetPackageInfo(".")
+		if err != nil {
+			report.Warnf("could not load package info: %v\n", err)
+			packageInfo = nil
+		}
+		callGraph, err := analyze.BuildCallGraphWithAlgorithm(allFiles, packageInfo, p.config.Algo)
+		if err != nil {
+			return fmt.Errorf("error building call graph: %w", err)
+		}
+		result, err := analyze.QueryCallGraph(callGraph, p.config.CallGraphQuery, p.config.Depth)
+		if err != nil {
+			return err
+		}
+		if p.config.Output == OutputJSON {
+			return writeJSON(p.report.Out, result)
 		}
+		report.Result(analyze.FormatCallGraphQuery(result))
 
-		if compileCount > 0 {
-			fmt.Printf("Processed %d compile commands with %d total files.\n", compileCount, totalFiles)
-		} else {
-			fmt.Println("No compile commands found.")
+	case "callgraph-diff":
+		report.Println("=== Call Graph Diff Mode ===")
+		hooks, _, _, err := loadHooksFiles(p.config.HooksFiles)
+		if err != nil {
+			return fmt.Errorf("failed to parse hooks: %w", err)
+		}
+		diff, err := diffCallGraphs(p.config.CallGraphDiff, flag.Arg(0), hooks)
+		if err != nil {
+			return err
+		}
+		if p.config.Output == OutputJSON {
+			return writeJSON(p.report.Out, diff)
+		}
+		printCallGraphDiff(diff)
+
+	case "weaving-report":
+		report.Println("=== Weaving Report Mode ===")
+		result, err := buildWeavingReport(commands, GetMetadataPath(BuildModifiedLogFile))
+		if err != nil {
+			return err
+		}
+		if p.config.Output == OutputJSON {
+			return writeJSON(p.report.Out, result)
+		}
+		printWeavingReport(result)
+
+	case "pack-files":
+		report.Println("=== Pack Files Mode ===")
+		result := packFilesOutput(commands, p.config.Hash)
+		if p.config.Output == OutputJSON {
+			return writeJSON(p.report.Out, result)
 		}
+		printPackFiles(result)
 	case "verbose":
 		p.parser.DumpCommands()
 	case "dump":
 		for i, cmd := range commands {
-			fmt.Printf("# Command %d\n", i+1)
-			fmt.Println(cmd.String())
+			report.Resultf("# Command %d\n", i+1)
+			report.Resultln(cmd.String())
 		}
+	case "check":
+		report.Println("=== Replay Check ===")
+		return checkReplayEnvironment(p.config.LogFile, commands)
 	case "dry-run":
-		fmt.Println("=== Dry Run Mode ===")
+		report.Println("=== Dry Run Mode ===")
+		if report.Columns {
+			printCommandsTable(commands)
+			break
+		}
 		for i, cmd := range commands {
 			if cmd.Executable == "" {
 				continue
 			}
-			fmt.Printf("Command %d: %s\n", i+1, cmd.String())
+			report.Resultf("Command %d: %s\n", i+1, cmd.String())
 		}
 	case "interactive":
 		if err := p.parser.ExecuteInteractive(); err != nil {
-			log.Printf("Error in interactive mode: %v", err)
+			report.Errorf("interactive mode: %v\n", err)
 		}
 	case "execute":
-		fmt.Println("=== Generating and Executing Script ===")
-		if err := p.parser.ExecuteAll(); err != nil {
-			log.Printf("Error executing commands: %v", err)
+		report.Println("=== Generating and Executing Script ===")
+		if err := executeReplay(p.parser); err != nil {
+			report.Errorf("executing commands: %v\n", err)
 		} else {
-			fmt.Println("\nReplay completed successfully!")
+			report.Println("\nReplay completed successfully!")
+			reportBinaryOutputs(p.parser.GetCommands())
 		}
 	default: // "generate"
-		fmt.Println("=== Generating Script ===")
-		if err := p.parser.GenerateScript(); err != nil {
-			log.Printf("Error generating script: %v", err)
+		report.Println("=== Generating Script ===")
+		if err := p.parser.GenerateScript(GetMetadataPath(ReplayScriptFile)); err != nil {
+			report.Errorf("generating script: %v\n", err)
 		} else {
-			fmt.Println("\nScript generated successfully! Use --execute flag to run it.")
+			linkRunFile(ReplayScriptFile)
+			report.Println("\nScript generated successfully! Use --execute flag to run it.")
 		}
 	}
 
 	return nil
 }
 
-// isCompileCommand checks if a command is a compile command
-func isCompileCommand(cmd *Command) bool {
-	return cmd.Executable != "" && strings.HasSuffix(cmd.Executable, "/compile")
+// PackagePathInfo holds package path and build information
+type PackagePathInfo struct {
+	Path    string
+	BuildID string
 }
 
-// extractPackFiles extracts files listed after the -pack flag in a compile command
-func extractPackFiles(cmd *Command) []string {
+// compiledGoFiles returns the number of compile commands and the Go files they compile
+func compiledGoFiles(commands []parse.Command) (int, []string) {
+	compileCount := 0
 	var files []string
-	packIndex := -1
-
-	// Find the -pack flag
-	for i, arg := range cmd.Args {
-		if arg == "-pack" {
-			packIndex = i
-			break
+	cgoSources := parse.NewCgoSources(commands)
+	for _, cmd := range commands {
+		if !parse.IsCompileCommand(&cmd) {
+			continue
 		}
-	}
-
-	// If -pack flag found, collect all remaining arguments as files
-	if packIndex >= 0 && packIndex+1 < len(cmd.Args) {
-		files = cmd.Args[packIndex+1:]
-	}
-
-	return files
-}
-
-// processPackFiles processes the pack files with a custom action
-func processPackFiles(files []string, action func(string)) {
-	for _, file := range files {
-		action(file)
-	}
-}
-
-// extractPackageName extracts the package name after the -p flag in a compile command
-func extractPackageName(cmd *Command) string {
-	// Find the -p flag
-	for i, arg := range cmd.Args {
-		if arg == "-p" && i+1 < len(cmd.Args) {
-			return cmd.Args[i+1]
+		compileCount++
+		for _, file := range cgoSources.SourceFiles(&cmd) {
+			if strings.HasSuffix(file, ".go") {
+				files = append(files, file)
+			}
 		}
 	}
-	return ""
-}
-
-// PackagePathInfo holds package path and build information
-type PackagePathInfo struct {
-	Path    string
-	BuildID string
+	return compileCount, files
 }
 
 // extractWorkDir extracts the WORK= environment variable from a command string
@@ -440,30 +1035,20 @@ func extractBuildID(outputPath string) string {
 	return ""
 }
 
-// extractOutputPath extracts the path after the -o flag in a compile command
-func extractOutputPath(cmd *Command) string {
-	// Find the -o flag
-	for i, arg := range cmd.Args {
-		if arg == "-o" && i+1 < len(cmd.Args) {
-			return cmd.Args[i+1]
-		}
-	}
-	return ""
-}
-
 // extractPackagePathInfo extracts package names and their common source paths from compile commands
-func extractPackagePathInfo(commands []Command) map[string]PackagePathInfo {
+func extractPackagePathInfo(commands []parse.Command) map[string]PackagePathInfo {
 	packageInfo := make(map[string]PackagePathInfo)
 	packageFiles := make(map[string][]string)  // Package name -> list of file paths
 	packageBuildIDs := make(map[string]string) // Package name -> build ID
 
 	// Collect all files and build IDs for each package
+	cgoSources := parse.NewCgoSources(commands)
 	for _, cmd := range commands {
-		if isCompileCommand(&cmd) {
-			packageName := extractPackageName(&cmd)
+		if parse.IsCompileCommand(&cmd) {
+			packageName := parse.ExtractPackageName(&cmd)
 			if packageName != "" {
 				// Extract build ID from -o flag
-				outputPath := extractOutputPath(&cmd)
+				outputPath := parse.ExtractOutputPath(&cmd)
 				if outputPath != "" {
 					buildID := extractBuildID(outputPath)
 					if buildID != "" {
@@ -472,7 +1057,7 @@ func extractPackagePathInfo(commands []Command) map[string]PackagePathInfo {
 				}
 
 				// Extract files
-				files := extractPackFiles(&cmd)
+				files := cgoSources.SourceFiles(&cmd)
 				for _, file := range files {
 					if strings.HasSuffix(file, ".go") {
 						if packageFiles[packageName] == nil {
@@ -612,13 +1197,13 @@ func dumpWorkDir(workDir string) error {
 		return fmt.Errorf("work directory does not exist: %s", workDir)
 	}
 
-	fmt.Printf("Contents of work directory: %s\n", workDir)
-	fmt.Println("=" + strings.Repeat("=", len(workDir)+27))
+	report.Printf("Contents of work directory: %s\n", workDir)
+	report.Println("=" + strings.Repeat("=", len(workDir)+27))
 
 	// Walk through the directory tree
 	err := filepath.Walk(workDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			fmt.Printf("Error accessing %s: %v\n", path, err)
+			report.Warnf("cannot access %s: %v\n", path, err)
 			return nil // Continue walking
 		}
 
@@ -637,10 +1222,10 @@ func dumpWorkDir(workDir string) error {
 				// Skip the root directory itself
 				return nil
 			}
-			fmt.Printf("%s📁 %s/\n", indent, filepath.Base(path))
+			report.Resultf("%s📁 %s/\n", indent, filepath.Base(path))
 		} else {
 			// Show file with size
-			fmt.Printf("%s📄 %s (%d bytes)\n", indent, filepath.Base(path), info.Size())
+			report.Resultf("%s📄 %s (%d bytes)\n", indent, filepath.Base(path), info.Size())
 		}
 
 		return nil
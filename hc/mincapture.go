@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// Capture strategies of --capture-strategy
+const (
+	CaptureFull    = "full"    // go build -a: the build log has the commands of every package
+	CaptureMinimal = "minimal" // go build without -a, completed with the plan of go build -n -a
+	CaptureExec    = "exec"    // Not a strategy: the builds of a command, captured by --exec
+)
+
+// captureStrategy is how captures run go build (--capture-strategy)
+var captureStrategy = CaptureFull
+
+// SetCaptureStrategy sets how captures run go build
+func SetCaptureStrategy(strategy string) {
+	captureStrategy = strategy
+}
+
+// newCapturer returns the capturer of the capture strategy, saving the JSON output of go build
+// too with json
+func newCapturer(json bool) Capturer {
+	switch {
+	case captureStrategy == CaptureMinimal:
+		return &MinimalCapturer{JSON: json}
+	case json:
+		return &JSONCapturer{}
+	default:
+		return &TextCapturer{}
+	}
+}
+
+// MinimalCapturer captures go build without -a, so packages in the build cache aren't
+// rebuilt, and completes its build log with the commands go build -n -a prints for them
+type MinimalCapturer struct {
+	JSON bool // Run go build with -json and save its output to go-build.json
+}
+
+// Capture runs go build, then go build -n -a, and writes the completed build log to
+// build-metadata/go-build.log
+func (m *MinimalCapturer) Capture() error {
+	if err := EnsureMetadataDir(); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+
+	flags := []string{"-x", "-work"}
+	if m.JSON {
+		flags = append(flags, "-json")
+	}
+	args := goBuildArgs(flags...)
+	report.Printf("Running: %s %s\n", goBinary, strings.Join(args, " "))
+	start := time.Now()
+	var captured string
+	var runErr error
+	if m.JSON {
+		var log strings.Builder
+		stream := &jsonBuildStream{log: &log}
+		var err error
+		if runErr, err = stream.run(args); err != nil {
+			return err
+		}
+		captured = log.String()
+	} else {
+		var output []byte
+		output, runErr = exec.Command(goBinary, args...).CombinedOutput()
+		captured = string(output)
+	}
+	if runErr != nil {
+		report.Warnf("go build exited with error: %v\n", runErr)
+		report.Println("But continuing with the captured output...")
+	}
+
+	// go build -n prints the commands without running them, so the plan costs no compile
+	planArgs := goBuildArgs("-n", "-a")
+	report.Printf("Planning: %s %s\n", goBinary, strings.Join(planArgs, " "))
+	plan, err := exec.Command(goBinary, planArgs...).CombinedOutput()
+	recordPhase(PhaseCapture, start, err)
+	saveBuildProfile()
+	if err != nil {
+		return fmt.Errorf("go build -n failed: %w\n%s", err, plan)
+	}
+
+	completion, err := parse.CompleteFromPlan(captured, string(plan))
+	if err != nil {
+		return err
+	}
+	logPath := GetMetadataPath(BuildLogFile)
+	if err := os.WriteFile(logPath, []byte(completion.Log), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", logPath, err)
+	}
+	report.Printf("📋 %d package(s) compiled, %d taken from the build cache: their commands come from the plan\n",
+		len(completion.Compiled), len(completion.Cached))
+	warnCachedCgoPackages(completion)
+
+	if err := recordToolchain(); err != nil {
+		report.Warnf("failed to record the toolchain: %v\n", err)
+	}
+	if err := recordCaptureMetadata(CaptureMinimal, captureBuildArgs); err != nil {
+		report.Warnf("failed to record the capture metadata: %v\n", err)
+	}
+	recordHeredocs(logPath, HeredocsDir)
+	return nil
+}
+
+// GetDescription returns a description of what this capturer does
+func (m *MinimalCapturer) GetDescription() string {
+	return "Captured go build output, completed with the plan of go build -n -a in go-build.log"
+}
+
+// warnCachedCgoPackages warns about the cgo packages taken from the build cache: the Go files
+// cgo generates for them are only written when the build log is replayed, so they can't be
+// analyzed or instrumented from the capture. The standard library ones, such as runtime/cgo,
+// are left out: they are in the cache of every build.
+func warnCachedCgoPackages(completion *parse.PlanCompletion) {
+	cached := make(map[string]bool, len(completion.Cached))
+	for _, pkg := range completion.Cached {
+		cached[pkg] = true
+	}
+	parser := parse.NewParser()
+	if err := parser.ParseReader(strings.NewReader(completion.Log)); err != nil {
+		return
+	}
+	commands := parser.GetCommands()
+	cgoSources := parse.NewCgoSources(commands)
+	for i := range commands {
+		cmd := &commands[i]
+		if !parse.IsCompileCommand(cmd) || parse.IsStdlibCompileCommand(cmd) || cgoSources.ForCompile(cmd) == nil {
+			continue
+		}
+		if pkg := parse.ExtractPackageName(cmd); cached[pkg] {
+			report.Warnf("cgo package %s was taken from the build cache: its generated files are only written by a replay, capture with --capture-strategy=%s to instrument it\n",
+				pkg, CaptureFull)
+		}
+	}
+}
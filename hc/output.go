@@ -0,0 +1,576 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// Output formats of the analysis modes (--output)
+const (
+	OutputText = "text"
+	OutputJSON = "json"
+)
+
+// jsonOutputModes are the execution modes that support --output=json
+var jsonOutputModes = map[string]bool{
+	"pack-files":       true,
+	"pack-functions":   true,
+	"pack-packages":    true,
+	"pack-packagepath": true,
+	"callgraph":        true,
+	"callgraph-query":  true,
+	"callgraph-diff":   true,
+	"workdir":          true,
+	"weaving-report":   true,
+	"plan":             true,
+}
+
+// PackFilesOutput is the --pack-files result
+type PackFilesOutput struct {
+	CompileCommands int                  `json:"compileCommands"`
+	TotalFiles      int                  `json:"totalFiles"`
+	TotalBytes      int64                `json:"totalBytes"`
+	Commands        []PackFilesCommand   `json:"commands"`
+	Duplicates      []PackFilesDuplicate `json:"duplicates"`
+}
+
+// PackFilesCommand lists the files of one compile command
+type PackFilesCommand struct {
+	Index   int        `json:"index"` // 1-based position among the compile commands
+	Package string     `json:"package"`
+	Files   []string   `json:"files"`
+	Bytes   int64      `json:"bytes"`            // Total size of the files
+	Details []PackFile `json:"details"`          // Size and hash of every file, in the order of Files
+	Assets  []PackFile `json:"assets,omitempty"` // Files the package assembles, packs or embeds besides its Go files
+}
+
+// PackFile is a file of a compile command. The hash is computed with --hash.
+type PackFile struct {
+	File   string `json:"file"`
+	Kind   string `json:"kind"` // go, asm, syso, embed or other, see parse.FileKind
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256,omitempty"`
+	Error  string `json:"error,omitempty"` // Why the file could not be read, e.g. $WORK was removed
+}
+
+// PackFilesDuplicate is a source file compiled by several compile commands: the same file,
+// possibly through symlinks ("file"), or with --hash files with the same content ("content"),
+// such as generated copies
+type PackFilesDuplicate struct {
+	Kind   string          `json:"kind"`
+	SHA256 string          `json:"sha256,omitempty"`
+	Files  []PackFilesUser `json:"files"`
+}
+
+// PackFilesUser is a file of a duplicate and the compile command compiling it
+type PackFilesUser struct {
+	Index   int    `json:"index"`
+	Package string `json:"package"`
+	File    string `json:"file"`
+}
+
+// PackFunctionsOutput is the --pack-functions result
+type PackFunctionsOutput struct {
+	CompileCommands int                   `json:"compileCommands"`
+	TotalFunctions  int                   `json:"totalFunctions"`
+	Files           []PackFunctionsFile   `json:"files"`
+	Errors          []FileError           `json:"errors,omitempty"`
+	SyntaxErrors    []analyze.SyntaxError `json:"syntaxErrors,omitempty"` // Files in Files that were parsed partially
+}
+
+// PackFunctionsFile lists the functions and methods declared in one file
+type PackFunctionsFile struct {
+	File      string           `json:"file"`
+	Functions []FunctionOutput `json:"functions"`
+}
+
+// FunctionOutput is a function or method with its formatted signature
+type FunctionOutput struct {
+	Name       string                  `json:"name"`
+	Receiver   string                  `json:"receiver,omitempty"`
+	TypeParams []analyze.ParameterInfo `json:"typeParameters,omitempty"`
+	Parameters []analyze.ParameterInfo `json:"parameters"`
+	Returns    []string                `json:"returns"`
+	Signature  string                  `json:"signature"`
+	Exported   bool                    `json:"exported"`
+}
+
+// FileError is a file that could not be analyzed
+type FileError struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// PackPackagesOutput is the --pack-packages result
+type PackPackagesOutput struct {
+	CompileCommands int             `json:"compileCommands"`
+	Packages        []PackageOutput `json:"packages"`
+}
+
+// PackageOutput is a compiled package, with its source directory and build ID for --pack-packagepath
+type PackageOutput struct {
+	Name         string `json:"name"`
+	CompileCount int    `json:"compileCount,omitempty"`
+	Path         string `json:"path,omitempty"`
+	BuildID      string `json:"buildID,omitempty"`
+}
+
+// CallGraphOutput is the --callgraph result: the edges reachable from main
+type CallGraphOutput struct {
+	Module          string                  `json:"module,omitempty"` // Empty when package info could not be loaded
+	CompileCommands int                     `json:"compileCommands"`
+	Files           int                     `json:"files"`
+	Nodes           []CallGraphNode         `json:"nodes"`
+	Edges           []analyze.CallGraphEdge `json:"edges"`
+	SyntaxErrors    []analyze.SyntaxError   `json:"syntaxErrors,omitempty"` // Files that were analyzed partially
+}
+
+// CallGraphNode is a function in the call graph
+type CallGraphNode struct {
+	Name     string `json:"name"`
+	External bool   `json:"external"`
+}
+
+// WorkDirOutput is the --workdir result
+type WorkDirOutput struct {
+	FirstCommand string         `json:"firstCommand"`
+	WorkDir      string         `json:"workDir"` // Empty when the first command sets no WORK
+	Entries      []WorkDirEntry `json:"entries"`
+}
+
+// WorkDirEntry is a file or directory in the work directory
+type WorkDirEntry struct {
+	Path  string `json:"path"` // Relative to the work directory
+	Dir   bool   `json:"dir"`
+	Size  int64  `json:"size"`
+	Error string `json:"error,omitempty"`
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+// packFilesOutput collects the files after -pack of every compile command with their sizes,
+// and the files several compile commands share. With hash, files are also hashed, and files
+// with the same content are duplicates too.
+func packFilesOutput(commands []parse.Command, hash bool) PackFilesOutput {
+	result := PackFilesOutput{Commands: []PackFilesCommand{}, Duplicates: []PackFilesDuplicate{}}
+	byPath := make(map[string][]PackFilesUser)    // Resolved path -> files
+	byContent := make(map[string][]PackFilesUser) // SHA-256 -> files
+	var paths, hashes []string                    // Keys in the order they were first seen
+	assets := parse.NewPackageAssets(commands)
+
+	for _, cmd := range commands {
+		if !parse.IsCompileCommand(&cmd) {
+			continue
+		}
+		result.CompileCommands++
+		files := parse.ExtractPackFiles(&cmd)
+		if len(files) == 0 {
+			continue
+		}
+		result.TotalFiles += len(files)
+		entry := PackFilesCommand{
+			Index:   result.CompileCommands,
+			Package: parse.ExtractPackageName(&cmd),
+			Files:   files,
+			Details: make([]PackFile, 0, len(files)),
+		}
+		for _, asset := range assets.ForCompile(&cmd) {
+			details := packFile(asset.Path, hash)
+			details.Kind = string(asset.Kind)
+			entry.Assets = append(entry.Assets, details)
+		}
+		for _, file := range files {
+			details := packFile(file, hash)
+			entry.Details = append(entry.Details, details)
+			entry.Bytes += details.Bytes
+			if details.Error != "" {
+				continue
+			}
+			user := PackFilesUser{Index: entry.Index, Package: entry.Package, File: file}
+			resolved := file
+			if real, err := filepath.EvalSymlinks(file); err == nil {
+				resolved, _ = filepath.Abs(real)
+			}
+			if _, ok := byPath[resolved]; !ok {
+				paths = append(paths, resolved)
+			}
+			byPath[resolved] = append(byPath[resolved], user)
+			if details.SHA256 != "" {
+				if _, ok := byContent[details.SHA256]; !ok {
+					hashes = append(hashes, details.SHA256)
+				}
+				byContent[details.SHA256] = append(byContent[details.SHA256], user)
+			}
+		}
+		result.TotalBytes += entry.Bytes
+		result.Commands = append(result.Commands, entry)
+	}
+
+	// A file compiled by several commands is reported once as the same file; files with the
+	// same content are reported when they are different files
+	sharedFiles := make(map[string]bool)
+	for _, path := range paths {
+		if users := byPath[path]; len(users) > 1 {
+			result.Duplicates = append(result.Duplicates, PackFilesDuplicate{Kind: "file", Files: users})
+			for _, user := range users {
+				sharedFiles[user.File] = true
+			}
+		}
+	}
+	for _, sum := range hashes {
+		users := byContent[sum]
+		distinct := make(map[string]bool)
+		for _, user := range users {
+			resolved := user.File
+			if real, err := filepath.EvalSymlinks(user.File); err == nil {
+				resolved, _ = filepath.Abs(real)
+			}
+			distinct[resolved] = true
+		}
+		if len(distinct) > 1 {
+			result.Duplicates = append(result.Duplicates, PackFilesDuplicate{Kind: "content", SHA256: sum, Files: users})
+		}
+	}
+	return result
+}
+
+// printPackFiles prints the files of every compile command with their sizes, then the files
+// shared between compile commands
+func printPackFiles(result PackFilesOutput) {
+	for _, cmd := range result.Commands {
+		report.Resultf("Compile command %d: Found %d files after -pack flag (%s, %s):\n",
+			cmd.Index, len(cmd.Files), cmd.Package, formatBytes(float64(cmd.Bytes)))
+		for _, file := range cmd.Details {
+			switch {
+			case file.Error != "":
+				report.Resultf("  - %s (%s)\n", file.File, file.Error)
+			case file.SHA256 != "":
+				report.Resultf("  - %s (%s, sha256 %s)\n", file.File, formatBytes(float64(file.Bytes)), file.SHA256[:12])
+			default:
+				report.Resultf("  - %s (%s)\n", file.File, formatBytes(float64(file.Bytes)))
+			}
+		}
+		for _, file := range cmd.Assets {
+			if file.Error != "" {
+				report.Resultf("  + %s [%s] (%s)\n", file.File, file.Kind, file.Error)
+			} else {
+				report.Resultf("  + %s [%s] (%s)\n", file.File, file.Kind, formatBytes(float64(file.Bytes)))
+			}
+		}
+		report.Resultln()
+	}
+
+	if len(result.Duplicates) > 0 {
+		report.Resultf("Files shared between compile commands: %d\n", len(result.Duplicates))
+		for _, dup := range result.Duplicates {
+			if dup.Kind == "content" {
+				report.Resultf("  Same content (sha256 %s):\n", dup.SHA256[:12])
+			} else {
+				report.Resultln("  Same file:")
+			}
+			for _, user := range dup.Files {
+				report.Resultf("    - %s (compile command %d, %s)\n", user.File, user.Index, user.Package)
+			}
+		}
+		report.Resultln()
+	}
+
+	if result.CompileCommands > 0 {
+		report.Printf("Processed %d compile commands with %d total files (%s).\n",
+			result.CompileCommands, result.TotalFiles, formatBytes(float64(result.TotalBytes)))
+	} else {
+		report.Println("No compile commands found.")
+	}
+}
+
+// packFile returns the size of a file of a compile command, and its SHA-256 with hash
+func packFile(file string, hash bool) PackFile {
+	details := PackFile{File: file, Kind: string(parse.ClassifyFile(file))}
+	f, err := os.Open(file)
+	if err != nil {
+		details.Error = err.Error()
+		return details
+	}
+	defer f.Close()
+	if !hash {
+		info, err := f.Stat()
+		if err != nil {
+			details.Error = err.Error()
+			return details
+		}
+		details.Bytes = info.Size()
+		return details
+	}
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		details.Error = err.Error()
+		return details
+	}
+	details.Bytes = n
+	details.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return details
+}
+
+// functionExtractor extracts the functions of a Go file, see analyze.ExtractFunctionsFromGoFileWithErrors
+type functionExtractor func(file string) ([]analyze.FunctionInfo, []analyze.SyntaxError, error)
+
+// packFunctionsOutput collects the functions declared in the Go files of every compile command
+func packFunctionsOutput(commands []parse.Command, extract functionExtractor) PackFunctionsOutput {
+	result := PackFunctionsOutput{Files: []PackFunctionsFile{}}
+	cgoSources := parse.NewCgoSources(commands)
+	for _, cmd := range commands {
+		if !parse.IsCompileCommand(&cmd) {
+			continue
+		}
+		result.CompileCommands++
+		for _, file := range cgoSources.SourceFiles(&cmd) {
+			if !strings.HasSuffix(file, ".go") {
+				continue
+			}
+			functions, syntaxErrors, err := extract(file)
+			if err != nil {
+				result.Errors = append(result.Errors, FileError{File: file, Error: err.Error()})
+				continue
+			}
+			result.SyntaxErrors = append(result.SyntaxErrors, syntaxErrors...)
+			if len(functions) == 0 {
+				continue
+			}
+			entry := PackFunctionsFile{File: file}
+			for _, fn := range functions {
+				entry.Functions = append(entry.Functions, FunctionOutput{
+					Name:       fn.Name,
+					Receiver:   fn.Receiver,
+					TypeParams: fn.TypeParams,
+					Parameters: append([]analyze.ParameterInfo{}, fn.Parameters...),
+					Returns:    append([]string{}, fn.Returns...),
+					Signature:  analyze.FormatFunctionSignature(fn),
+					Exported:   fn.IsExported,
+				})
+			}
+			result.TotalFunctions += len(functions)
+			result.Files = append(result.Files, entry)
+		}
+	}
+	return result
+}
+
+// packPackagesOutput collects the package names of the compile commands, sorted by name
+func packPackagesOutput(commands []parse.Command) PackPackagesOutput {
+	result := PackPackagesOutput{Packages: []PackageOutput{}}
+	counts := make(map[string]int)
+	for _, cmd := range commands {
+		if !parse.IsCompileCommand(&cmd) {
+			continue
+		}
+		result.CompileCommands++
+		if name := parse.ExtractPackageName(&cmd); name != "" {
+			counts[name]++
+		}
+	}
+	for name, count := range counts {
+		result.Packages = append(result.Packages, PackageOutput{Name: name, CompileCount: count})
+	}
+	sort.Slice(result.Packages, func(i, j int) bool {
+		return result.Packages[i].Name < result.Packages[j].Name
+	})
+	return result
+}
+
+// packPackagePathOutput collects the source directory and build ID of every compiled package
+func packPackagePathOutput(commands []parse.Command) PackPackagesOutput {
+	result := PackPackagesOutput{Packages: []PackageOutput{}}
+	for _, cmd := range commands {
+		if parse.IsCompileCommand(&cmd) {
+			result.CompileCommands++
+		}
+	}
+	for name, info := range extractPackagePathInfo(commands) {
+		result.Packages = append(result.Packages, PackageOutput{Name: name, Path: info.Path, BuildID: info.BuildID})
+	}
+	sort.Slice(result.Packages, func(i, j int) bool {
+		return result.Packages[i].Name < result.Packages[j].Name
+	})
+	return result
+}
+
+// callGraphOutput collects the nodes and edges reachable from main
+func callGraphOutput(cg *analyze.CallGraph, packageInfo *analyze.PackageInfo) CallGraphOutput {
+	result := CallGraphOutput{
+		Nodes: []CallGraphNode{},
+		Edges: analyze.ReachableCallEdges(cg),
+	}
+	if packageInfo != nil {
+		result.Module = packageInfo.ModulePath
+	}
+	if result.Edges == nil {
+		result.Edges = []analyze.CallGraphEdge{}
+	}
+
+	external := make(map[string]bool)
+	for _, e := range result.Edges {
+		if _, exists := external[e.Caller]; !exists {
+			external[e.Caller] = false
+		}
+		external[e.Callee] = e.External
+	}
+	for name, isExternal := range external {
+		result.Nodes = append(result.Nodes, CallGraphNode{Name: name, External: isExternal})
+	}
+	sort.Slice(result.Nodes, func(i, j int) bool {
+		return result.Nodes[i].Name < result.Nodes[j].Name
+	})
+	return result
+}
+
+// buildCallGraphOutput builds the call graph of files, limited to the current module when
+// its package info can be loaded, linking interface calls with the given algorithm
+func buildCallGraphOutput(files []string, algorithm string) (CallGraphOutput, error) {
+	if len(files) == 0 {
+		report.Println("No Go files found in compile commands.")
+		return callGraphOutput(&analyze.CallGraph{Functions: make(map[string]*analyze.FunctionInfo)}, nil), nil
+	}
+
+	packageInfo, err := analyze.GetPackageInfo(".")
+	if err != nil {
+		report.Warnf("could not load package info: %v\n", err)
+		packageInfo = nil
+	}
+
+	callGraph, err := analyze.BuildCallGraphWithAlgorithm(files, packageInfo, algorithm)
+	if err != nil {
+		return CallGraphOutput{}, fmt.Errorf("error building call graph: %w", err)
+	}
+	result := callGraphOutput(callGraph, packageInfo)
+	result.Files = len(files)
+	result.SyntaxErrors = callGraph.SyntaxErrors
+	return result, nil
+}
+
+// workDirOutput lists the work directory set by the first command of the build log
+func workDirOutput(commands []parse.Command) (WorkDirOutput, error) {
+	result := WorkDirOutput{Entries: []WorkDirEntry{}}
+	if len(commands) == 0 {
+		report.Println("No commands found in log file.")
+		return result, nil
+	}
+
+	result.FirstCommand = commands[0].Raw
+	result.WorkDir = extractWorkDir(commands[0].Raw)
+	if result.WorkDir == "" {
+		report.Println("No WORK= environment variable found in first command.")
+		return result, nil
+	}
+
+	entries, err := workDirEntries(result.WorkDir)
+	if err != nil {
+		return result, err
+	}
+	result.Entries = entries
+	return result, nil
+}
+
+// workDirEntries lists all files and directories in workDir, relative to it
+func workDirEntries(workDir string) ([]WorkDirEntry, error) {
+	if _, err := os.Stat(workDir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("work directory does not exist: %s", workDir)
+	}
+
+	entries := []WorkDirEntry{}
+	err := filepath.Walk(workDir, func(path string, info os.FileInfo, err error) error {
+		relPath, relErr := filepath.Rel(workDir, path)
+		if relErr != nil {
+			relPath = path
+		}
+		if err != nil {
+			entries = append(entries, WorkDirEntry{Path: relPath, Error: err.Error()})
+			return nil // Continue walking
+		}
+		if relPath == "." {
+			return nil
+		}
+		entry := WorkDirEntry{Path: filepath.ToSlash(relPath), Dir: info.IsDir()}
+		if !info.IsDir() {
+			entry.Size = info.Size()
+		}
+		entries = append(entries, entry)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error walking directory: %w", err)
+	}
+	return entries, nil
+}
+
+// printPackagesTable lists the compiled packages in columns, sorted by name, for --pack-packages
+func printPackagesTable(packageNames map[string]int) {
+	names := make([]string, 0, len(packageNames))
+	for name := range packageNames {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	t := report.newTable("  ")
+	t.header("PACKAGE", "COMPILED")
+	for _, name := range names {
+		t.row(styled(styleCyan, name), styled(styleDim, fmt.Sprintf("%dx", packageNames[name])))
+	}
+	t.flush()
+}
+
+// addFunctionRows adds the functions of a file to a --pack-functions table, with the name,
+// the rest of the signature and whether it is exported in separate columns
+func addFunctionRows(t *table, functions []analyze.FunctionInfo) {
+	for _, fn := range functions {
+		name := fn.Name
+		if fn.Receiver != "" {
+			name = fmt.Sprintf("(%s) %s", fn.Receiver, fn.Name)
+		}
+		signature := strings.TrimPrefix(analyze.FormatFunctionSignature(fn), name)
+		exported := cell{}
+		if fn.IsExported {
+			exported = styled(styleGreen, "exported")
+		}
+		t.row(styled(styleCyan, name), cell{text: signature}, exported)
+	}
+}
+
+// printCommandsTable lists the commands of the build log in columns for --dry-run, with the
+// tool and package of every command next to it
+func printCommandsTable(commands []parse.Command) {
+	t := report.newTable("")
+	t.header("#", "TOOL", "PACKAGE", "COMMAND")
+	for i, cmd := range commands {
+		if cmd.Executable == "" {
+			continue
+		}
+		// Variable assignments such as WORK=... have no tool
+		tool, pkg := styled(styleDim, ""), cell{}
+		if !strings.Contains(cmd.Executable, "=") {
+			tool.text = filepath.Base(cmd.Executable)
+		}
+		// Only the Go tools take the package after -p; for mkdir it is a flag
+		if parse.IsCompileCommand(&cmd) || parse.IsLinkCommand(&cmd) || tool.text == "asm" {
+			tool.style = styleCyan
+			pkg = styled(styleYellow, parse.ExtractPackageName(&cmd))
+		}
+		t.row(styled(styleDim, fmt.Sprintf("%d", i+1)), tool, pkg, cell{text: cmd.String()})
+	}
+	t.flush()
+}
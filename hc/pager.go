@@ -0,0 +1,71 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// pagerModes are the execution modes whose output is piped through a pager on terminals
+var pagerModes = map[string]bool{
+	"pack-files":       true,
+	"pack-functions":   true,
+	"pack-packages":    true,
+	"pack-packagepath": true,
+	"callgraph":        true,
+	"workdir":          true,
+	"verbose":          true,
+	"dump":             true,
+	"dry-run":          true,
+	"plan":             true,
+}
+
+// startPager pipes the output r writes to stdout through a pager, like git does, when stdout
+// is a terminal. The pager is $HC_PAGER or $PAGER, less by default; an empty value or cat
+// disables it. less is run with LESS=FRX unless LESS is set, so output that fits on one screen
+// is printed as it is and colors are kept. The returned function waits for the user to quit
+// the pager and must be called once the output is written.
+func startPager(r *Reporter) func() {
+	if !isTerminal(os.Stdout) || (r.Out != os.Stdout && r.Log.Output() != os.Stdout) {
+		return func() {}
+	}
+	pager, ok := os.LookupEnv("HC_PAGER")
+	if !ok {
+		pager, ok = os.LookupEnv("PAGER")
+	}
+	if !ok {
+		pager = "less"
+	}
+	if pager == "" || pager == "cat" {
+		return func() {}
+	}
+
+	cmd := exec.Command("sh", "-c", pager)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(cmd.Env, "LESS=FRX")
+	}
+	if _, ok := os.LookupEnv("LV"); !ok {
+		cmd.Env = append(cmd.Env, "LV=-c")
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return func() {}
+	}
+	// Without a pager the output goes to the terminal directly
+	if err := cmd.Start(); err != nil {
+		return func() {}
+	}
+
+	if r.Out == os.Stdout {
+		r.Out = stdin
+	}
+	if r.Log.Output() == os.Stdout {
+		r.Log.SetOutput(stdin)
+	}
+	return func() {
+		stdin.Close()
+		cmd.Wait()
+	}
+}
@@ -0,0 +1,80 @@
+package parse
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// BinaryOutput is a command of the build log putting the binary a link command wrote where the
+// build puts it, e.g. mv $WORK/b001/exe/a.out app. go build moves the binary to its -o or to
+// the directory it runs in, go install to GOBIN; cp is used when the binary can't be moved.
+type BinaryOutput struct {
+	Index  int    // Index of the mv or cp command in the build log
+	Link   int    // Index of the link command writing the binary
+	Op     string // mv or cp
+	Source string // Binary written by the link command, e.g. $WORK/b001/exe/a.out
+	Target string // Path the binary is put at, as written in the command
+	Dir    string // Directory the command runs in, from the cd before it
+}
+
+// FindBinaryOutputs finds the commands of a build log putting linked binaries in place, in
+// the order of the build log
+func FindBinaryOutputs(commands []Command) []BinaryOutput {
+	var outputs []BinaryOutput
+	links := make(map[string]int) // -o of the link commands -> index
+	dirs := CommandDirs(commands)
+	for i := range commands {
+		cmd := &commands[i]
+		if link := ParseLinkCommand(cmd); link != nil && link.Output() != "" {
+			links[link.Output()] = i
+			continue
+		}
+		if (cmd.Executable != "mv" && cmd.Executable != "cp") || len(cmd.Args) != 2 {
+			continue
+		}
+		link, ok := links[cmd.Args[0]]
+		if !ok {
+			continue
+		}
+		outputs = append(outputs, BinaryOutput{
+			Index:  i,
+			Link:   link,
+			Op:     cmd.Executable,
+			Source: cmd.Args[0],
+			Target: cmd.Args[1],
+			Dir:    dirs[i],
+		})
+	}
+	return outputs
+}
+
+// Path returns the path the binary is put at, resolved against the directory of the command
+func (o *BinaryOutput) Path() string {
+	if filepath.IsAbs(o.Target) || o.Dir == "" {
+		return o.Target
+	}
+	return filepath.Join(o.Dir, o.Target)
+}
+
+// Name returns the file name of the binary, e.g. app for go build of example.com/app
+func (o *BinaryOutput) Name() string {
+	return path.Base(filepath.ToSlash(o.Target))
+}
+
+// To returns the commands putting the binary at target instead: a mkdir -p of the directory
+// of target, as go build does for its -o, and the mv or cp of the binary
+func (o *BinaryOutput) To(target string) []Command {
+	dir := filepath.Dir(target)
+	if !strings.HasSuffix(dir, "/") {
+		dir += "/"
+	}
+	commands := []Command{
+		{Executable: "mkdir", Args: []string{"-p", dir}},
+		{Executable: o.Op, Args: []string{o.Source, target}},
+	}
+	for i := range commands {
+		commands[i].Raw = commands[i].String()
+	}
+	return commands
+}
@@ -0,0 +1,51 @@
+package parse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindBinaryOutputs(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseFile(cgoBuildLog); err != nil {
+		t.Fatal(err)
+	}
+	commands := p.GetCommands()
+	outputs := FindBinaryOutputs(commands)
+	if len(outputs) != 1 {
+		t.Fatalf("found %d binary outputs in %s, want 1 (the cp of archives to the build cache aren't)", len(outputs), cgoBuildLog)
+	}
+	output := outputs[0]
+	if !IsLinkCommand(&commands[output.Link]) || commands[output.Index].Raw != "mv $WORK/b001/exe/a.out app" {
+		t.Errorf("output = %+v", output)
+	}
+	if output.Op != "mv" || output.Source != "$WORK/b001/exe/a.out" || output.Target != "app" || output.Dir != "/tmp/zzcgo" {
+		t.Errorf("output = %+v", output)
+	}
+	if output.Path() != "/tmp/zzcgo/app" || output.Name() != "app" {
+		t.Errorf("Path() = %q, Name() = %q", output.Path(), output.Name())
+	}
+
+	var raw []string
+	for _, cmd := range output.To("/tmp/out dir/app") {
+		raw = append(raw, cmd.Raw)
+	}
+	if want := []string{"mkdir -p '/tmp/out dir/'", "mv $WORK/b001/exe/a.out '/tmp/out dir/app'"}; !reflect.DeepEqual(raw, want) {
+		t.Errorf("To() = %q, want %q", raw, want)
+	}
+}
+
+func TestBinaryOutputInstall(t *testing.T) {
+	p := NewParser()
+	p.SetCommands([]Command{
+		p.parseSingleLineCommand("cd /src/app"),
+		p.parseSingleLineCommand("/go/pkg/tool/linux_amd64/link -o $WORK/b001/exe/a.out -importcfg $WORK/b001/importcfg.link $WORK/b001/_pkg_.a"),
+		p.parseSingleLineCommand("mkdir -p /home/u/go/bin/"),
+		p.parseSingleLineCommand("mv $WORK/b001/exe/a.out /home/u/go/bin/app"),
+		p.parseSingleLineCommand("mv $WORK/b002/exe/a.out elsewhere"),
+	})
+	outputs := FindBinaryOutputs(p.GetCommands())
+	if len(outputs) != 1 || outputs[0].Index != 3 || outputs[0].Path() != "/home/u/go/bin/app" {
+		t.Errorf("outputs = %+v", outputs)
+	}
+}
@@ -0,0 +1,179 @@
+package parse
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// IsCgoCommand checks if a command runs the cgo tool, either translating the files of a
+// package or, with -dynimport, writing the dynamic imports of its C objects
+func IsCgoCommand(cmd *Command) bool {
+	return path.Base(ToolPath(cmd)) == "cgo"
+}
+
+// IsCCompilerCommand checks if a command runs the C compiler of a cgo package, e.g. gcc
+func IsCCompilerCommand(cmd *Command) bool {
+	return cCompilers[path.Base(ToolPath(cmd))]
+}
+
+// CgoInvocation is a cgo command of the build log translating the Go files of a package into
+// the Go and C files its compile and C compiler commands build, e.g.
+// cgo -objdir $WORK/b002/ -importpath example.com/app/calc -- -I $WORK/b002/ ./calc.go
+type CgoInvocation struct {
+	Index      int      // Index of the command in the build log
+	ObjDir     string   // -objdir the files are generated in, without its trailing slash
+	ImportPath string   // -importpath of the package
+	Dir        string   // Directory the command runs in, from the cd before it
+	Files      []string // Go files of the package translated, as written in the command
+}
+
+// SourceFile returns the path of a file of the invocation, resolved against its directory
+func (c *CgoInvocation) SourceFile(file string) string {
+	if filepath.IsAbs(file) || c.Dir == "" {
+		return file
+	}
+	return filepath.Join(c.Dir, file)
+}
+
+// SourceFiles returns the paths of the Go files translated, resolved against its directory
+func (c *CgoInvocation) SourceFiles() []string {
+	files := make([]string, len(c.Files))
+	for i, file := range c.Files {
+		files[i] = c.SourceFile(file)
+	}
+	return files
+}
+
+// WithFiles returns the cgo command translating other files instead, e.g. instrumented
+// copies of the package files. replace maps source files, as returned by SourceFile, to the
+// files translated instead; cgo names the files it generates after them, so a copy must have
+// the name of its source.
+func (c *CgoInvocation) WithFiles(cmd *Command, replace map[string]string) Command {
+	words := append([]string{cmd.Executable}, cmd.Args...)
+	changed := false
+	for i, word := range words {
+		if !strings.HasSuffix(word, ".go") {
+			continue
+		}
+		if replacement, ok := replace[c.SourceFile(word)]; ok {
+			words[i] = replacement
+			changed = true
+		}
+	}
+	if !changed {
+		return *cmd
+	}
+	rewritten := Command{Executable: words[0], Args: words[1:]}
+	rewritten.Raw = rewritten.String()
+	return rewritten
+}
+
+// generatedSource returns the source file a file cgo generated in the object directory was
+// translated from: x.cgo1.go comes from x.go. ok is false for other files, and source is ""
+// for the files cgo generates for the package as a whole, _cgo_gotypes.go and _cgo_import.go.
+func (c *CgoInvocation) generatedSource(file string) (source string, ok bool) {
+	if path.Dir(file) != c.ObjDir {
+		return "", false
+	}
+	name := path.Base(file)
+	if strings.HasPrefix(name, "_cgo_") {
+		return "", true
+	}
+	base, found := strings.CutSuffix(name, ".cgo1.go")
+	if !found {
+		return "", false
+	}
+	for _, source := range c.Files {
+		if strings.TrimSuffix(filepath.Base(source), ".go") == base {
+			return c.SourceFile(source), true
+		}
+	}
+	return "", false
+}
+
+// CgoSources finds the cgo invocations of a build log, so the Go files cgo generates for the
+// compile of a package can be traced back to the files of the package
+type CgoSources struct {
+	invocations []CgoInvocation
+	byObjDir    map[string]int
+}
+
+// NewCgoSources finds the cgo invocations of the commands of a build log
+func NewCgoSources(commands []Command) *CgoSources {
+	s := &CgoSources{byObjDir: make(map[string]int)}
+	dirs := CommandDirs(commands)
+	for i := range commands {
+		cmd := &commands[i]
+		if !IsCgoCommand(cmd) {
+			continue
+		}
+		invocation := CgoInvocation{Index: i, Dir: dirs[i]}
+		afterFlags := false
+		for j := 0; j < len(cmd.Args); j++ {
+			arg := cmd.Args[j]
+			switch {
+			case arg == "--":
+				afterFlags = true
+			case afterFlags && strings.HasSuffix(arg, ".go"):
+				invocation.Files = append(invocation.Files, arg)
+			case !afterFlags && arg == "-objdir" && j+1 < len(cmd.Args):
+				j++
+				invocation.ObjDir = strings.TrimSuffix(cmd.Args[j], "/")
+			case !afterFlags && arg == "-importpath" && j+1 < len(cmd.Args):
+				j++
+				invocation.ImportPath = cmd.Args[j]
+			}
+		}
+		// cgo -dynimport runs write no Go files of the package
+		if invocation.ObjDir == "" || len(invocation.Files) == 0 {
+			continue
+		}
+		s.byObjDir[invocation.ObjDir] = len(s.invocations)
+		s.invocations = append(s.invocations, invocation)
+	}
+	return s
+}
+
+// Invocations returns the cgo invocations found, in the order of the build log
+func (s *CgoSources) Invocations() []CgoInvocation {
+	if s == nil {
+		return nil
+	}
+	return s.invocations
+}
+
+// ForCompile returns the cgo invocation generating files of a compile command, or nil if
+// its package doesn't use cgo
+func (s *CgoSources) ForCompile(cmd *Command) *CgoInvocation {
+	if s == nil {
+		return nil
+	}
+	for _, file := range ExtractPackFiles(cmd) {
+		if i, ok := s.byObjDir[path.Dir(file)]; ok {
+			return &s.invocations[i]
+		}
+	}
+	return nil
+}
+
+// SourceFiles returns the files of the package a compile command builds: its -pack files,
+// with the files cgo generated replaced by the files they were translated from and those cgo
+// generates for the package as a whole left out
+func (s *CgoSources) SourceFiles(cmd *Command) []string {
+	invocation := s.ForCompile(cmd)
+	if invocation == nil {
+		return ExtractPackFiles(cmd)
+	}
+	var files []string
+	for _, file := range ExtractPackFiles(cmd) {
+		source, generated := invocation.generatedSource(file)
+		switch {
+		case !generated:
+			files = append(files, file)
+		case source != "":
+			files = append(files, source)
+		}
+	}
+	return files
+}
@@ -0,0 +1,80 @@
+package parse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCgoSources(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseFile(cgoBuildLog); err != nil {
+		t.Fatal(err)
+	}
+	commands := p.GetCommands()
+	sources := NewCgoSources(commands)
+
+	invocations := sources.Invocations()
+	if len(invocations) != 1 {
+		t.Fatalf("found %d cgo invocations in %s, want 1 (the -dynimport run writes no package files)", len(invocations), cgoBuildLog)
+	}
+	invocation := invocations[0]
+	if invocation.ObjDir != "$WORK/b001" || invocation.ImportPath != "example.com/zzcgo" || invocation.Dir != "/tmp/zzcgo" {
+		t.Errorf("invocation = %+v", invocation)
+	}
+	if want := []string{"/tmp/zzcgo/main.go"}; !reflect.DeepEqual(invocation.SourceFiles(), want) {
+		t.Errorf("SourceFiles() = %q, want %q", invocation.SourceFiles(), want)
+	}
+
+	var compile *Command
+	for i := range commands {
+		if IsCompileCommand(&commands[i]) && ExtractPackageName(&commands[i]) == "main" {
+			compile = &commands[i]
+		}
+	}
+	if compile == nil {
+		t.Fatalf("no compile of main in %s", cgoBuildLog)
+	}
+	if got := sources.ForCompile(compile); got == nil || got.Index != invocation.Index {
+		t.Errorf("ForCompile(main) = %+v", got)
+	}
+	if want := []string{"/tmp/zzcgo/main.go"}; !reflect.DeepEqual(sources.SourceFiles(compile), want) {
+		t.Errorf("SourceFiles(main) = %q, want %q", sources.SourceFiles(compile), want)
+	}
+	for i := range commands {
+		if IsCompileCommand(&commands[i]) && &commands[i] != compile && sources.ForCompile(&commands[i]) != nil {
+			t.Errorf("ForCompile(%s) found a cgo invocation", ExtractPackageName(&commands[i]))
+		}
+	}
+
+	cgo := &commands[invocation.Index]
+	rewritten := invocation.WithFiles(cgo, map[string]string{"/tmp/zzcgo/main.go": "$WORK/b001/instrumented/main.go"})
+	if !IsCgoCommand(&rewritten) || !strings.HasSuffix(rewritten.Raw, " -O2 -g $WORK/b001/instrumented/main.go") {
+		t.Errorf("WithFiles rewrote the command into %q", rewritten.Raw)
+	}
+	if !strings.HasPrefix(rewritten.Raw, "TERM=dumb CGO_LDFLAGS= ") {
+		t.Errorf("WithFiles lost the environment of the command: %q", rewritten.Raw)
+	}
+	if unchanged := invocation.WithFiles(cgo, map[string]string{"/tmp/other/main.go": "x.go"}); unchanged.Raw != cgo.Raw {
+		t.Errorf("WithFiles changed the command without a replacement: %q", unchanged.Raw)
+	}
+}
+
+func TestCCompilerCommand(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseFile(cgoBuildLog); err != nil {
+		t.Fatal(err)
+	}
+	cCompiles, cgoRuns := 0, 0
+	for _, cmd := range p.GetCommands() {
+		if IsCCompilerCommand(&cmd) {
+			cCompiles++
+		}
+		if IsCgoCommand(&cmd) {
+			cgoRuns++
+		}
+	}
+	if cCompiles != 5 || cgoRuns != 2 {
+		t.Errorf("found %d C compiler and %d cgo commands, want 5 and 2", cCompiles, cgoRuns)
+	}
+}
@@ -0,0 +1,143 @@
+package parse
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// IsCompileCommand checks if a command is a compile command
+func IsCompileCommand(cmd *Command) bool {
+	return cmd.Executable != "" && strings.HasSuffix(cmd.Executable, "/compile")
+}
+
+// IsLinkCommand checks if a command is a link command
+func IsLinkCommand(cmd *Command) bool {
+	return strings.HasSuffix(ToolPath(cmd), "/link")
+}
+
+// ToolPath returns the program a command runs, skipping environment assignments such as
+// the GOROOT='...' prefix of link commands
+func ToolPath(cmd *Command) string {
+	if !isEnvAssignment(cmd.Executable) {
+		return cmd.Executable
+	}
+	for _, arg := range cmd.Args {
+		if !isEnvAssignment(arg) {
+			return arg
+		}
+	}
+	return ""
+}
+
+// isEnvAssignment reports whether a command word is a NAME=value environment assignment
+func isEnvAssignment(word string) bool {
+	name, _, found := strings.Cut(word, "=")
+	if !found || name == "" {
+		return false
+	}
+	for _, r := range name {
+		if r != '_' && (r < 'A' || r > 'Z') && (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// IsStdlibCompileCommand checks if a compile command builds a standard library package
+func IsStdlibCompileCommand(cmd *Command) bool {
+	for _, arg := range cmd.Args {
+		if arg == "-std" {
+			return true
+		}
+	}
+	return false
+}
+
+// compileValueFlags are the flags of the compile tool taking a value that go build passes after
+// -pack, e.g. -asmhdr $WORK/b001/go_asm.h for packages with assembly
+var compileValueFlags = map[string]bool{
+	"asmhdr": true, "buildid": true, "embedcfg": true, "importcfg": true, "o": true, "p": true, "symabis": true,
+}
+
+// ExtractPackFiles extracts the files listed after the -pack flag in a compile command,
+// skipping the flags between them, such as -asmhdr go_asm.h
+func ExtractPackFiles(cmd *Command) []string {
+	var files []string
+	packIndex := -1
+
+	// Find the -pack flag
+	for i, arg := range cmd.Args {
+		if arg == "-pack" {
+			packIndex = i
+			break
+		}
+	}
+	if packIndex < 0 {
+		return nil
+	}
+
+	for i := packIndex + 1; i < len(cmd.Args); i++ {
+		arg := cmd.Args[i]
+		if !strings.HasPrefix(arg, "-") {
+			files = append(files, arg)
+			continue
+		}
+		if name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "="); !hasValue && compileValueFlags[name] {
+			i++
+		}
+	}
+
+	return files
+}
+
+// ExtractPackageName extracts the package name after the -p flag in a compile command
+func ExtractPackageName(cmd *Command) string {
+	// Find the -p flag
+	for i, arg := range cmd.Args {
+		if arg == "-p" && i+1 < len(cmd.Args) {
+			return cmd.Args[i+1]
+		}
+	}
+	return ""
+}
+
+// ExtractGoVersion extracts the toolchain version after the -goversion flag in a compile command
+func ExtractGoVersion(cmd *Command) string {
+	for i, arg := range cmd.Args {
+		if arg == "-goversion" && i+1 < len(cmd.Args) {
+			return cmd.Args[i+1]
+		}
+	}
+	return ""
+}
+
+// ExtractOutputPath extracts the path after the -o flag in a compile command
+func ExtractOutputPath(cmd *Command) string {
+	// Find the -o flag
+	for i, arg := range cmd.Args {
+		if arg == "-o" && i+1 < len(cmd.Args) {
+			return cmd.Args[i+1]
+		}
+	}
+	return ""
+}
+
+// CommandDirs returns the directory each command of a build log runs in, from the cd commands
+// before it; "" before the first. Relative directories are joined to the one before them.
+func CommandDirs(commands []Command) []string {
+	dirs := make([]string, len(commands))
+	dir := ""
+	for i := range commands {
+		dirs[i] = dir
+		cmd := &commands[i]
+		if cmd.Executable != "cd" || len(cmd.Args) != 1 {
+			continue
+		}
+		if filepath.IsAbs(cmd.Args[0]) || strings.HasPrefix(cmd.Args[0], "$") || dir == "" {
+			dir = cmd.Args[0]
+		} else {
+			dir = filepath.Join(dir, cmd.Args[0])
+		}
+	}
+	return dirs
+}
@@ -0,0 +1,513 @@
+package parse
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WorkerTokenEnv names the environment variable holding the bearer token HTTP workers expect
+const WorkerTokenEnv = "HC_WORKER_TOKEN"
+
+// workerTimeout bounds a request to an HTTP worker, i.e. the replay of one action
+const workerTimeout = 30 * time.Minute
+
+// Worker runs build actions of a distributed replay on another machine. The machine needs
+// the Go installation of the build at the same GOROOT; everything else an action reads is
+// shipped with it.
+type Worker interface {
+	// Run runs a shell command with stdin on the worker. An error means the worker could
+	// not run it at all; a failing command is reported by the result's exit code.
+	Run(command string, stdin []byte) (*WorkerResult, error)
+	String() string
+}
+
+// WorkerRequest is the body of a request to an HTTP worker
+type WorkerRequest struct {
+	Command string `json:"command"`
+	Stdin   []byte `json:"stdin"`
+}
+
+// WorkerResult is the outcome of a command run by a worker, and the body of an HTTP worker's
+// response
+type WorkerResult struct {
+	Stdout   []byte `json:"stdout"`
+	Stderr   []byte `json:"stderr"`
+	ExitCode int    `json:"exitCode"`
+}
+
+// ParseWorkers parses a comma separated list of workers: SSH destinations (host, user@host or
+// ssh://user@host:port) and HTTP workers (http://host:port, started with hc --worker-listen)
+func ParseWorkers(spec string) ([]Worker, error) {
+	var workers []Worker
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case strings.HasPrefix(entry, "http://") || strings.HasPrefix(entry, "https://"):
+			workers = append(workers, &httpWorker{url: strings.TrimSuffix(entry, "/"), client: &http.Client{Timeout: workerTimeout}})
+		case strings.Contains(entry, "://") && !strings.HasPrefix(entry, "ssh://"):
+			return nil, fmt.Errorf("unsupported worker %s: use host, user@host, ssh://host or http://host:port", entry)
+		default:
+			workers = append(workers, &sshWorker{destination: entry})
+		}
+	}
+	if len(workers) == 0 {
+		return nil, fmt.Errorf("no workers in %q", spec)
+	}
+	return workers, nil
+}
+
+// SetWorkers makes ExecuteParallel run compile actions on workers (none by default)
+func (p *Parser) SetWorkers(workers []Worker) {
+	p.workers = workers
+}
+
+// sshWorker runs commands with ssh, which must log in without prompting
+type sshWorker struct {
+	destination string
+}
+
+func (w *sshWorker) Run(command string, stdin []byte) (*WorkerResult, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("ssh", "-o", "BatchMode=yes", w.destination, "bash -c "+shellQuote(command))
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return &WorkerResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() != 255:
+		// ssh exits with 255 when the connection fails, and with the command's status otherwise
+		return &WorkerResult{Stdout: stdout.Bytes(), Stderr: stderr.Bytes(), ExitCode: exitErr.ExitCode()}, nil
+	}
+	return nil, fmt.Errorf("ssh %s: %v: %s", w.destination, err, strings.TrimSpace(stderr.String()))
+}
+
+func (w *sshWorker) String() string {
+	return w.destination
+}
+
+// httpWorker posts commands to an hc --worker-listen server
+type httpWorker struct {
+	url    string
+	client *http.Client
+}
+
+func (w *httpWorker) Run(command string, stdin []byte) (*WorkerResult, error) {
+	body, err := json.Marshal(WorkerRequest{Command: command, Stdin: stdin})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, w.url+"/run", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token := os.Getenv(WorkerTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("POST %s/run: %s", w.url, resp.Status)
+	}
+	result := &WorkerResult{}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return nil, fmt.Errorf("invalid response from %s: %w", w.url, err)
+	}
+	return result, nil
+}
+
+func (w *httpWorker) String() string {
+	return w.url
+}
+
+// CheckWorkerListen returns an error when a worker would serve an address other machines can
+// reach without a token: workers run the commands of their clients, so only workers listening
+// on a loopback address may go without one
+func CheckWorkerListen(addr string, token string) error {
+	if token != "" {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid worker address %s: %w", addr, err)
+	}
+	if !isLoopbackHost(host) {
+		return fmt.Errorf("%s is not set: a worker listening on %s would run the commands of any client, set it or listen on a loopback address such as 127.0.0.1", WorkerTokenEnv, addr)
+	}
+	return nil
+}
+
+// isLoopbackHost reports whether a host only reaches the local machine; an empty host
+// listens on every interface
+func isLoopbackHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// WorkerHandler serves the requests of distributed replays: POST /run runs a command with
+// bash and returns its output. Requests must carry token as a bearer token; without token,
+// only requests from the local machine are served.
+func WorkerHandler(token string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/run", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if !authorizedWorkerRequest(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req WorkerRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid request: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command("bash", "-c", req.Command)
+		cmd.Stdin = bytes.NewReader(req.Stdin)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		result := &WorkerResult{}
+		if err := cmd.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			result.ExitCode = exitErr.ExitCode()
+		}
+		result.Stdout, result.Stderr = stdout.Bytes(), stderr.Bytes()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(result)
+	})
+	return mux
+}
+
+// authorizedWorkerRequest reports whether a request carries token as its bearer token, compared
+// in constant time, or comes from the local machine when there is no token
+func authorizedWorkerRequest(r *http.Request, token string) bool {
+	if token == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		return err == nil && isLoopbackHost(host)
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// shellQuote quotes a word for bash
+func shellQuote(word string) string {
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
+
+// remoteEligible reports whether an action can run on a worker: actions compiling one package
+// in their own $WORK directory. Linking, cgo and the commands between actions run locally.
+func remoteEligible(action *buildAction) bool {
+	return action.id != "" && action.workDir != "" && action.memory == MemoryClassCompile
+}
+
+// inputPathPattern matches the paths a script may read: absolute, into $WORK or relative
+// with a directory or extension
+var inputPathPattern = regexp.MustCompile(`(?:\$\{?WORK\}?/|/|\./|\.\./)[^\s"'=,;|&<>()]*|[A-Za-z0-9_.-]+\.[A-Za-z0-9]+`)
+
+// distributor tracks the workers of a distributed replay and the files shipped to each
+type distributor struct {
+	goroot  string
+	workers []Worker
+	mu      sync.Mutex
+	shipped []map[string]bool // Files present on every worker, shipped or written by it
+}
+
+func newDistributor(workers []Worker) *distributor {
+	d := &distributor{workers: workers}
+	if out, err := exec.Command("go", "env", "GOROOT").Output(); err == nil {
+		d.goroot = strings.TrimSpace(string(out))
+	}
+	for range workers {
+		d.shipped = append(d.shipped, make(map[string]bool))
+	}
+	return d
+}
+
+// inputs returns the existing regular files an action's script reads, outside GOROOT, with
+// the files listed by the import and embed configurations it reads from disk
+func (d *distributor) inputs(action *buildAction) []string {
+	var inputs []string
+	seen := make(map[string]bool)
+	var add func(path string)
+	add = func(path string) {
+		path = filepath.Clean(path)
+		if seen[path] || (d.goroot != "" && strings.HasPrefix(path, d.goroot+string(filepath.Separator))) {
+			return
+		}
+		seen[path] = true
+		info, err := os.Stat(path)
+		if err != nil || !info.Mode().IsRegular() {
+			return
+		}
+		inputs = append(inputs, path)
+		if base := filepath.Base(path); base == "importcfg" || base == "embedcfg" || strings.HasSuffix(base, ".importcfg") {
+			if content, err := os.ReadFile(path); err == nil {
+				for _, match := range inputPathPattern.FindAllString(string(content), -1) {
+					if filepath.IsAbs(match) {
+						add(match)
+					}
+				}
+			}
+		}
+	}
+
+	cwd := ""
+	for _, line := range action.script {
+		if dir, ok := strings.CutPrefix(line, "cd "); ok && !strings.Contains(line, "\n") {
+			cwd = d.expand(strings.Trim(dir, `"`), action.workDir)
+			continue
+		}
+		for _, match := range inputPathPattern.FindAllString(line, -1) {
+			path := d.expand(match, action.workDir)
+			if !filepath.IsAbs(path) {
+				if cwd == "" {
+					continue
+				}
+				path = filepath.Join(cwd, path)
+			}
+			add(path)
+		}
+	}
+	return inputs
+}
+
+// expand replaces $WORK in a path with the work directory
+func (d *distributor) expand(path, workDir string) string {
+	path = strings.ReplaceAll(path, "${WORK}", workDir)
+	return strings.ReplaceAll(path, "$WORK", workDir)
+}
+
+// localCommands are the file commands that stay on the machine replaying the build when they
+// touch files outside $WORK, such as the copies into the go and hc build caches
+var localCommands = map[string]bool{"cp": true, "mv": true, "rm": true, "mkdir": true, "ln": true, "touch": true}
+
+// split divides the script of an action into the lines replayed by a worker and the file
+// commands writing outside $WORK, replayed locally afterwards with the assignments and cd
+// commands before them
+func (d *distributor) split(action *buildAction) (remote, local []string) {
+	var state []string // Assignments and the last cd, for the local lines
+	hasLocal := false
+	for _, line := range action.script {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || strings.Contains(line, "\n") {
+			remote = append(remote, line)
+			continue
+		}
+		if assignmentPattern.MatchString(line) || fields[0] == "cd" {
+			remote = append(remote, line)
+			state = append(state, line)
+			continue
+		}
+		if localCommands[fields[0]] && d.writesOutsideWork(line, action.workDir) {
+			local = append(local, state...)
+			local = append(local, line)
+			state = nil
+			hasLocal = true
+			continue
+		}
+		remote = append(remote, line)
+	}
+	if !hasLocal {
+		local = nil
+	}
+	return remote, local
+}
+
+// writesOutsideWork reports whether a file command names an absolute path outside the work
+// directory and GOROOT
+func (d *distributor) writesOutsideWork(line, workDir string) bool {
+	for _, match := range inputPathPattern.FindAllString(line, -1) {
+		path := d.expand(match, workDir)
+		if !filepath.IsAbs(path) || path == workDir || strings.HasPrefix(path, workDir+"/") {
+			continue
+		}
+		if d.goroot != "" && strings.HasPrefix(path, d.goroot+"/") {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// command returns the shell command replaying lines of an action on a worker: it unpacks the
+// inputs from stdin, runs the lines with their output on stderr and writes the action's $WORK
+// directory to stdout
+func (d *distributor) command(action *buildAction, lines []string) string {
+	var dirs []string
+	for _, line := range lines {
+		if dir, ok := strings.CutPrefix(line, "cd "); ok && !strings.Contains(line, "\n") {
+			dirs = append(dirs, shellQuote(d.expand(strings.Trim(dir, `"`), action.workDir)))
+		}
+	}
+	output := strings.TrimPrefix(filepath.Join(action.workDir, action.id), "/")
+
+	var b strings.Builder
+	b.WriteString("set -e\n")
+	fmt.Fprintf(&b, "mkdir -p %s %s\n", shellQuote(filepath.Join(action.workDir, action.id)), strings.Join(dirs, " "))
+	b.WriteString("tar -xzf - -C /\n")
+	b.WriteString("bash -e >&2 <<'HC_ACTION_SCRIPT'\n")
+	b.WriteString(strings.Join(lines, "\n"))
+	b.WriteString("\nHC_ACTION_SCRIPT\n")
+	fmt.Fprintf(&b, "tar -czf - -C / %s\n", shellQuote(output))
+	return b.String()
+}
+
+// runRemote replays an action on a worker and unpacks its $WORK directory locally. The error
+// is set when the worker could not be used, so the action can be replayed locally instead.
+func (d *distributor) runRemote(index, worker int, action *buildAction) (*actionResult, error) {
+	inputs := d.inputs(action)
+	d.mu.Lock()
+	var ship []string
+	for _, input := range inputs {
+		if !d.shipped[worker][input] {
+			ship = append(ship, input)
+		}
+	}
+	d.mu.Unlock()
+
+	payload, err := packFiles(ship)
+	if err != nil {
+		return nil, err
+	}
+	remoteLines, localLines := d.split(action)
+	remote, err := d.workers[worker].Run(d.command(action, remoteLines), payload)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &actionResult{index: index}
+	result.stderr.Write(remote.Stderr)
+	if remote.ExitCode != 0 {
+		result.err = fmt.Errorf("exit status %d on %s", remote.ExitCode, d.workers[worker])
+		return result, nil
+	}
+	outputs, err := unpackFiles(remote.Stdout, filepath.Join(action.workDir, action.id))
+	if err != nil {
+		return nil, fmt.Errorf("outputs of %s from %s: %w", action.id, d.workers[worker], err)
+	}
+
+	d.mu.Lock()
+	for _, path := range append(ship, outputs...) {
+		d.shipped[worker][path] = true
+	}
+	d.mu.Unlock()
+
+	if len(localLines) > 0 {
+		local := runAction(index, &buildAction{id: action.id, script: localLines})
+		result.stdout.Write(local.stdout.Bytes())
+		result.stderr.Write(local.stderr.Bytes())
+		result.err = local.err
+	}
+	return result, nil
+}
+
+// packFiles returns a gzip-compressed tar of files, named by their absolute paths without the
+// leading slash
+func packFiles(paths []string) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		header := &tar.Header{Name: strings.TrimPrefix(path, "/"), Mode: int64(info.Mode().Perm()), Size: info.Size(), ModTime: info.ModTime()}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(tw, file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// unpackFiles writes the regular files of a gzip-compressed tar to their absolute paths, which
+// must be inside dir, and returns them
+func unpackFiles(data []byte, dir string) ([]string, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return paths, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		path := filepath.Join("/", header.Name)
+		if path != dir && !strings.HasPrefix(path, dir+string(filepath.Separator)) {
+			return nil, fmt.Errorf("unexpected file %s", header.Name)
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return nil, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return nil, err
+			}
+			file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode).Perm())
+			if err != nil {
+				return nil, err
+			}
+			_, err = io.Copy(file, tr)
+			file.Close()
+			if err != nil {
+				return nil, err
+			}
+			paths = append(paths, path)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package parse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCheckWorkerListen(t *testing.T) {
+	tests := []struct {
+		addr    string
+		token   string
+		wantErr bool
+	}{
+		{":9000", "secret", false},
+		{"0.0.0.0:9000", "secret", false},
+		{"127.0.0.1:9000", "", false},
+		{"[::1]:9000", "", false},
+		{"localhost:9000", "", false},
+		{":9000", "", true},
+		{"0.0.0.0:9000", "", true},
+		{"10.0.0.5:9000", "", true},
+		{"build.example.com:9000", "", true},
+		{"9000", "", true},
+	}
+	for _, tt := range tests {
+		err := CheckWorkerListen(tt.addr, tt.token)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("CheckWorkerListen(%q, %q) = %v, want error %v", tt.addr, tt.token, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWorkerHandlerAuthorization(t *testing.T) {
+	tests := []struct {
+		name          string
+		token         string
+		remoteAddr    string
+		authorization string
+		wantStatus    int
+	}{
+		{"token given", "secret", "10.0.0.7:4000", "Bearer secret", http.StatusOK},
+		{"wrong token", "secret", "10.0.0.7:4000", "Bearer other", http.StatusUnauthorized},
+		{"token prefix", "secret", "10.0.0.7:4000", "Bearer secre", http.StatusUnauthorized},
+		{"no bearer", "secret", "10.0.0.7:4000", "secret", http.StatusUnauthorized},
+		{"missing token", "secret", "127.0.0.1:4000", "", http.StatusUnauthorized},
+		{"no token, local client", "", "127.0.0.1:4000", "", http.StatusOK},
+		{"no token, remote client", "", "10.0.0.7:4000", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/run", strings.NewReader(`{"command":"true"}`))
+			req.RemoteAddr = tt.remoteAddr
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			WorkerHandler(tt.token).ServeHTTP(rec, req)
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package parse
+
+import (
+	"encoding/json"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileKind is what the build does with a file of a package
+type FileKind string
+
+const (
+	FileGo    FileKind = "go"    // Go source, compiled
+	FileAsm   FileKind = "asm"   // Assembly source (.s), assembled by the asm tool
+	FileSyso  FileKind = "syso"  // System object (.syso), packed into the archive as it is
+	FileEmbed FileKind = "embed" // Resource embedded with //go:embed
+	FileOther FileKind = "other" // Anything else, e.g. the C files of cgo packages
+)
+
+// ClassifyFile returns the kind of a file from its name. Embedded files can have any name and
+// are only known from the embed configuration of their compile command.
+func ClassifyFile(file string) FileKind {
+	switch path.Ext(filepath.ToSlash(file)) {
+	case ".go":
+		return FileGo
+	case ".s", ".S":
+		return FileAsm
+	case ".syso":
+		return FileSyso
+	}
+	return FileOther
+}
+
+// PackageFile is a file of a package and what the build does with it
+type PackageFile struct {
+	Path string   // As written in the build log, or resolved by the embed configuration
+	Kind FileKind // Kind of the file
+}
+
+// Embedcfg is the embed configuration of a compile command of a package with //go:embed
+// directives, written by a heredoc and passed with -embedcfg: the files each pattern matches,
+// and the files they are read from, which go build writes as absolute paths
+type Embedcfg struct {
+	Patterns map[string][]string // Pattern of a //go:embed directive -> files it matches
+	Files    map[string]string   // File, relative to the package directory -> file read
+}
+
+// ParseEmbedcfg parses the JSON of an embed configuration
+func ParseEmbedcfg(content string) (*Embedcfg, error) {
+	var cfg Embedcfg
+	if err := json.Unmarshal([]byte(content), &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// EmbeddedFiles returns the files read for the embedded files, sorted
+func (c *Embedcfg) EmbeddedFiles() []string {
+	files := make([]string, 0, len(c.Files))
+	for _, file := range c.Files {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// PackageAssets finds the files the build log assembles, packs into archives and embeds for
+// each package besides its Go files, by the $WORK/bNNN directory of the package
+type PackageAssets struct {
+	byObjDir map[string][]PackageFile
+}
+
+// NewPackageAssets finds the assembly sources, system objects and embedded files of the
+// packages of a build log
+func NewPackageAssets(commands []Command) *PackageAssets {
+	a := &PackageAssets{byObjDir: make(map[string][]PackageFile)}
+	seen := make(map[string]bool)
+	add := func(objDir, file string, kind FileKind) {
+		if key := objDir + "\x00" + file; !seen[key] {
+			seen[key] = true
+			a.byObjDir[objDir] = append(a.byObjDir[objDir], PackageFile{Path: file, Kind: kind})
+		}
+	}
+
+	embedcfgs := make(map[string]*Embedcfg) // Heredoc target -> configuration
+	dirs := CommandDirs(commands)
+	for i := range commands {
+		cmd := &commands[i]
+		switch {
+		case cmd.Heredoc != nil && path.Base(cmd.Heredoc.Target) == "embedcfg":
+			if cfg, err := ParseEmbedcfg(cmd.Heredoc.Content); err == nil {
+				embedcfgs[path.Base(path.Dir(cmd.Heredoc.Target))] = cfg
+			}
+		case path.Base(ToolPath(cmd)) == "asm":
+			// The asm commands of a package write their objects, and the symabis of its
+			// compile, into its directory
+			objDir := path.Dir(flagArg(cmd.Args, "-o"))
+			for _, arg := range cmd.Args {
+				if ClassifyFile(arg) == FileAsm {
+					add(objDir, resolveFile(dirs[i], arg), FileAsm)
+				}
+			}
+		case cmd.Executable == "go" && len(cmd.Args) > 3 && cmd.Args[0] == "tool" && cmd.Args[1] == "pack" && cmd.Args[2] == "r":
+			objDir := path.Dir(cmd.Args[3])
+			for _, arg := range cmd.Args[4:] {
+				if ClassifyFile(arg) == FileSyso {
+					add(objDir, resolveFile(dirs[i], arg), FileSyso)
+				}
+			}
+		case IsCompileCommand(cmd):
+			// Heredoc targets are written expanded or with $WORK, so they are matched by
+			// the name of the package directory, e.g. b001
+			embedcfg := flagArg(cmd.Args, "-embedcfg")
+			if cfg := embedcfgs[path.Base(path.Dir(embedcfg))]; embedcfg != "" && cfg != nil {
+				for _, file := range cfg.EmbeddedFiles() {
+					add(path.Dir(ExtractOutputPath(cmd)), file, FileEmbed)
+				}
+			}
+		}
+	}
+	return a
+}
+
+// ForCompile returns the files a compile command's package assembles, packs and embeds
+// besides its Go files, in the order of the build log
+func (a *PackageAssets) ForCompile(cmd *Command) []PackageFile {
+	if a == nil {
+		return nil
+	}
+	output := ExtractOutputPath(cmd)
+	if output == "" {
+		return nil
+	}
+	return a.byObjDir[path.Dir(output)]
+}
+
+// flagArg returns the value following a flag in args, or "" without it
+func flagArg(args []string, flag string) string {
+	for i, arg := range args {
+		if arg == flag && i+1 < len(args) {
+			return args[i+1]
+		}
+	}
+	return ""
+}
+
+// resolveFile resolves a relative file of a command against the directory it runs in
+func resolveFile(dir, file string) string {
+	if filepath.IsAbs(file) || strings.HasPrefix(file, "$") || dir == "" {
+		return file
+	}
+	return filepath.Join(dir, file)
+}
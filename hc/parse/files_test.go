@@ -0,0 +1,120 @@
+package parse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// embedAsmBuildLog builds a package with an assembly function and a main package embedding a
+// directory, whose embedcfg heredoc has no final newline
+const embedAsmBuildLog = "testdata/embed-asm-build.log"
+
+// compileOf returns the compile command of a package in commands
+func compileOf(t *testing.T, commands []Command, pkg string) *Command {
+	t.Helper()
+	for i := range commands {
+		if IsCompileCommand(&commands[i]) && ExtractPackageName(&commands[i]) == pkg {
+			return &commands[i]
+		}
+	}
+	t.Fatalf("no compile of %s", pkg)
+	return nil
+}
+
+func TestParseUnterminatedHeredoc(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseFile(embedAsmBuildLog); err != nil {
+		t.Fatal(err)
+	}
+	commands := p.GetCommands()
+	var embedcfg *Command
+	for i := range commands {
+		if commands[i].Heredoc != nil && strings.HasSuffix(commands[i].Heredoc.Target, "/embedcfg") {
+			embedcfg = &commands[i]
+		}
+	}
+	if embedcfg == nil {
+		t.Fatalf("no embedcfg heredoc in %s", embedAsmBuildLog)
+	}
+	if !strings.HasSuffix(embedcfg.Heredoc.Content, "\t}\n}\n") || !strings.HasSuffix(embedcfg.Raw, "}\nEOF\n") {
+		t.Errorf("embedcfg heredoc not ended at }EOF:\n%s", embedcfg.Raw)
+	}
+	// The commands after the heredoc are commands, not part of its content
+	main := compileOf(t, commands, "main")
+	if files := ExtractPackFiles(main); !reflect.DeepEqual(files, []string{"./main.go"}) {
+		t.Errorf("ExtractPackFiles(main) = %q", files)
+	}
+
+	cfg, err := ParseEmbedcfg(embedcfg.Heredoc.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"static/hello.txt"}; !reflect.DeepEqual(cfg.Patterns["static"], want) {
+		t.Errorf("Patterns[static] = %q, want %q", cfg.Patterns["static"], want)
+	}
+	if want := []string{"/tmp/embapp/static/hello.txt"}; !reflect.DeepEqual(cfg.EmbeddedFiles(), want) {
+		t.Errorf("EmbeddedFiles() = %q, want %q", cfg.EmbeddedFiles(), want)
+	}
+}
+
+func TestExtractPackFilesSkipsFlags(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseFile(embedAsmBuildLog); err != nil {
+		t.Fatal(err)
+	}
+	mathx := compileOf(t, p.GetCommands(), "example.com/embapp/mathx")
+	if !strings.Contains(mathx.Raw, "-pack -asmhdr $WORK/b050/go_asm.h ./mathx/add.go") {
+		t.Fatalf("unexpected compile command: %s", mathx.Raw)
+	}
+	if files := ExtractPackFiles(mathx); !reflect.DeepEqual(files, []string{"./mathx/add.go"}) {
+		t.Errorf("ExtractPackFiles(mathx) = %q", files)
+	}
+}
+
+func TestPackageAssets(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseFile(embedAsmBuildLog); err != nil {
+		t.Fatal(err)
+	}
+	commands := p.GetCommands()
+	assets := NewPackageAssets(commands)
+
+	want := []PackageFile{{Path: "/tmp/embapp/mathx/add_amd64.s", Kind: FileAsm}}
+	if got := assets.ForCompile(compileOf(t, commands, "example.com/embapp/mathx")); !reflect.DeepEqual(got, want) {
+		t.Errorf("ForCompile(mathx) = %+v, want %+v", got, want)
+	}
+	want = []PackageFile{{Path: "/tmp/embapp/static/hello.txt", Kind: FileEmbed}}
+	if got := assets.ForCompile(compileOf(t, commands, "main")); !reflect.DeepEqual(got, want) {
+		t.Errorf("ForCompile(main) = %+v, want %+v", got, want)
+	}
+
+	p.SetCommands([]Command{
+		p.parseSingleLineCommand("/go/pkg/tool/linux_amd64/compile -o $WORK/b001/_pkg_.a -p main -pack ./main.go"),
+		p.parseSingleLineCommand("cd /src/app"),
+		p.parseSingleLineCommand("go tool pack r $WORK/b001/_pkg_.a rsrc_windows_amd64.syso # internal"),
+	})
+	commands = p.GetCommands()
+	want = []PackageFile{{Path: "/src/app/rsrc_windows_amd64.syso", Kind: FileSyso}}
+	if got := NewPackageAssets(commands).ForCompile(&commands[0]); !reflect.DeepEqual(got, want) {
+		t.Errorf("ForCompile(main) = %+v, want %+v", got, want)
+	}
+}
+
+func TestClassifyFile(t *testing.T) {
+	for file, kind := range map[string]FileKind{
+		"./main.go":                  FileGo,
+		"$WORK/b002/calc.cgo1.go":    FileGo,
+		"./add_amd64.s":              FileAsm,
+		"/src/app/rsrc.syso":         FileSyso,
+		"/src/app/static/hello.txt":  FileOther,
+		"$WORK/b002/_cgo_export.c":   FileOther,
+		"/usr/local/go/src/go.mod":   FileOther,
+		"/src/app/gen/asm_linux.S":   FileAsm,
+		"/src/app/testdata/x.go.txt": FileOther,
+	} {
+		if got := ClassifyFile(file); got != kind {
+			t.Errorf("ClassifyFile(%q) = %q, want %q", file, got, kind)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package parse
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// HeredocIndexFile is the index WriteHeredocArtifacts writes next to the artifacts
+const HeredocIndexFile = "index.json"
+
+// Heredoc is the file a heredoc command of the build log writes, e.g. the import
+// configuration of a package written by cat >$WORK/b001/importcfg << 'EOF'
+type Heredoc struct {
+	Target   string // File written, as in the build log, e.g. $WORK/b001/importcfg
+	Content  string // Lines between the command and EOF, each ending with a newline
+	Artifact string // File WriteHeredocArtifacts stored Content in, empty until then
+}
+
+// heredocTargetPattern matches the file a heredoc command writes, e.g. cat >$WORK/b001/importcfg << 'EOF'
+var heredocTargetPattern = regexp.MustCompile(`^cat\s*>\s*(\S+)\s*<<`)
+
+// NewHeredocCommand returns the command writing content to target with a heredoc
+func NewHeredocCommand(target, content string) Command {
+	cmd := Command{
+		Executable:  "cat",
+		Args:        []string{">" + target, "<<", "'EOF'"},
+		IsMultiline: true,
+		Heredoc:     &Heredoc{Target: target},
+	}
+	cmd.Raw = fmt.Sprintf("cat >%s << 'EOF'\n", target)
+	return cmd.WithHeredocContent(content)
+}
+
+// WithHeredocContent returns a copy of a heredoc command writing content instead, with its
+// Raw text rewritten. The command itself is left unchanged.
+func (c Command) WithHeredocContent(content string) Command {
+	if c.Heredoc == nil {
+		return c
+	}
+	if content != "" && !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	heredoc := *c.Heredoc
+	heredoc.Content = content
+	heredoc.Artifact = ""
+	firstLine, _, _ := strings.Cut(c.Raw, "\n")
+	c.Heredoc = &heredoc
+	c.Raw = firstLine + "\n" + content + "EOF\n"
+	return c
+}
+
+// WithHeredocLines returns a copy of a heredoc command writing lines after its content
+func (c Command) WithHeredocLines(lines ...string) Command {
+	if c.Heredoc == nil || len(lines) == 0 {
+		return c
+	}
+	return c.WithHeredocContent(c.Heredoc.Content + strings.Join(lines, "\n") + "\n")
+}
+
+// HeredocArtifact is an entry of the index of the heredoc artifacts
+type HeredocArtifact struct {
+	Command  int    `json:"command"` // Number of the command in the build log, from 1 as in --dump
+	Target   string `json:"target"`
+	Artifact string `json:"artifact"` // File name in the artifact directory
+	Lines    int    `json:"lines"`
+	SHA256   string `json:"sha256"`
+}
+
+// WriteHeredocArtifacts stores the content of every heredoc command in a file of dir, named
+// after its target, e.g. b001-importcfg.link, and records it in Heredoc.Artifact. Targets
+// written again, as by the builds of several go commands, get a .2, .3... suffix. The files
+// are listed in dir/index.json with the commands writing them. dir is emptied first.
+func WriteHeredocArtifacts(commands []Command, dir string) ([]HeredocArtifact, error) {
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	artifacts := []HeredocArtifact{}
+	written := make(map[string]int)
+	for i := range commands {
+		heredoc := commands[i].Heredoc
+		if heredoc == nil {
+			continue
+		}
+		name := artifactName(heredoc.Target)
+		if written[name]++; written[name] > 1 {
+			name = fmt.Sprintf("%s.%d", name, written[name])
+		}
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(heredoc.Content), 0644); err != nil {
+			return nil, fmt.Errorf("failed to write heredoc artifact: %w", err)
+		}
+		heredoc.Artifact = path
+		sum := sha256.Sum256([]byte(heredoc.Content))
+		artifacts = append(artifacts, HeredocArtifact{
+			Command:  i + 1,
+			Target:   heredoc.Target,
+			Artifact: name,
+			Lines:    strings.Count(heredoc.Content, "\n"),
+			SHA256:   hex.EncodeToString(sum[:]),
+		})
+	}
+
+	data, err := json.MarshalIndent(artifacts, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(dir, HeredocIndexFile), append(data, '\n'), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write heredoc index: %w", err)
+	}
+	return artifacts, nil
+}
+
+// artifactName turns the target of a heredoc into a file name: the path below the work
+// directory with its separators replaced, e.g. b001-importcfg.link
+func artifactName(target string) string {
+	target = strings.TrimPrefix(target, "${WORK}/")
+	target = strings.TrimPrefix(target, "$WORK/")
+	if filepath.IsAbs(target) {
+		// Expanded work directories, e.g. /tmp/go-build123/b001/importcfg
+		target = filepath.Join(filepath.Base(filepath.Dir(target)), filepath.Base(target))
+	}
+	name := strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || r == '$' || r == '{' || r == '}' {
+			return '-'
+		}
+		return r
+	}, target)
+	return strings.Trim(name, "-")
+}
@@ -0,0 +1,193 @@
+package parse
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// Directives of import configurations
+const (
+	ImportcfgPackageFile = "packagefile" // packagefile path=archive: the archive of an imported package
+	ImportcfgImportMap   = "importmap"   // importmap path=actual: the package an import path resolves to
+	ImportcfgModInfo     = "modinfo"     // modinfo "...": the module information of a linked binary
+)
+
+// ImportcfgEntry is a line of an import configuration
+type ImportcfgEntry struct {
+	Directive string // e.g. packagefile, importmap, modinfo; empty for comments and blank lines
+	Key       string // Import path of packagefile, packageshlib and importmap entries
+	Value     string // Archive of packagefile, actual path of importmap, rest of the line otherwise
+	Text      string // Comments and blank lines, as written
+}
+
+// String returns the entry as a line of an import configuration, without its newline
+func (e ImportcfgEntry) String() string {
+	switch {
+	case e.Directive == "":
+		return e.Text
+	case e.Key != "":
+		return e.Directive + " " + e.Key + "=" + e.Value
+	default:
+		return e.Directive + " " + e.Value
+	}
+}
+
+// Importcfg is an import configuration, the file passed to compile and link with -importcfg
+// that maps import paths to archives. Entries are kept in order, comments included, so an
+// unchanged configuration is written back as it was read.
+type Importcfg struct {
+	Entries []ImportcfgEntry
+}
+
+// keyedDirectives are the directives whose argument is path=value
+var keyedDirectives = map[string]bool{ImportcfgPackageFile: true, ImportcfgImportMap: true, "packageshlib": true}
+
+// NewImportcfg returns an import configuration with the comment go build starts them with
+func NewImportcfg() *Importcfg {
+	return &Importcfg{Entries: []ImportcfgEntry{{Text: "# import config"}}}
+}
+
+// ParseImportcfg parses the content of an import configuration
+func ParseImportcfg(content string) *Importcfg {
+	cfg := &Importcfg{}
+	content = strings.TrimSuffix(content, "\n")
+	if content == "" {
+		return cfg
+	}
+	for _, line := range strings.Split(content, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			cfg.Entries = append(cfg.Entries, ImportcfgEntry{Text: line})
+			continue
+		}
+		directive, argument, _ := strings.Cut(trimmed, " ")
+		entry := ImportcfgEntry{Directive: directive, Value: strings.TrimSpace(argument)}
+		if keyedDirectives[directive] {
+			if key, value, found := strings.Cut(entry.Value, "="); found {
+				entry.Key, entry.Value = key, value
+			}
+		}
+		cfg.Entries = append(cfg.Entries, entry)
+	}
+	return cfg
+}
+
+// ReadImportcfg reads and parses an import configuration file
+func ReadImportcfg(filename string) (*Importcfg, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParseImportcfg(string(data)), nil
+}
+
+// WriteFile writes the import configuration to a file
+func (c *Importcfg) WriteFile(filename string) error {
+	return os.WriteFile(filename, []byte(c.String()), 0644)
+}
+
+// String returns the content of the import configuration, each line ending with a newline
+func (c *Importcfg) String() string {
+	var sb strings.Builder
+	for _, entry := range c.Entries {
+		sb.WriteString(entry.String())
+		sb.WriteByte('\n')
+	}
+	return sb.String()
+}
+
+// PackageFile returns the archive of an imported package
+func (c *Importcfg) PackageFile(importPath string) (string, bool) {
+	for _, entry := range c.Entries {
+		if entry.Directive == ImportcfgPackageFile && entry.Key == importPath {
+			return entry.Value, true
+		}
+	}
+	return "", false
+}
+
+// PackageFiles returns the archives of the imported packages by import path
+func (c *Importcfg) PackageFiles() map[string]string {
+	files := make(map[string]string)
+	for _, entry := range c.Entries {
+		if entry.Directive == ImportcfgPackageFile {
+			files[entry.Key] = entry.Value
+		}
+	}
+	return files
+}
+
+// Imports returns the import paths of the packagefile entries, in order
+func (c *Importcfg) Imports() []string {
+	var imports []string
+	for _, entry := range c.Entries {
+		if entry.Directive == ImportcfgPackageFile {
+			imports = append(imports, entry.Key)
+		}
+	}
+	return imports
+}
+
+// SetPackageFile sets the archive of an imported package, replacing its packagefile entry or
+// adding one after the last. Entries are added before modinfo, which go build writes last.
+func (c *Importcfg) SetPackageFile(importPath, archive string) {
+	for i, entry := range c.Entries {
+		if entry.Directive == ImportcfgPackageFile && entry.Key == importPath {
+			c.Entries[i].Value = archive
+			return
+		}
+	}
+	at := len(c.Entries)
+	for i, entry := range c.Entries {
+		if entry.Directive == ImportcfgModInfo {
+			at = i
+			break
+		}
+	}
+	entry := ImportcfgEntry{Directive: ImportcfgPackageFile, Key: importPath, Value: archive}
+	c.Entries = append(c.Entries[:at], append([]ImportcfgEntry{entry}, c.Entries[at:]...)...)
+}
+
+// RemovePackageFile removes the packagefile entry of an imported package and reports whether
+// there was one
+func (c *Importcfg) RemovePackageFile(importPath string) bool {
+	for i, entry := range c.Entries {
+		if entry.Directive == ImportcfgPackageFile && entry.Key == importPath {
+			c.Entries = append(c.Entries[:i], c.Entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// ModInfo returns the argument of the modinfo entry of a link import configuration, a quoted
+// Go string, or "" without one
+func (c *Importcfg) ModInfo() string {
+	for _, entry := range c.Entries {
+		if entry.Directive == ImportcfgModInfo {
+			return entry.Value
+		}
+	}
+	return ""
+}
+
+// IsImportcfgHeredoc reports whether a command writes an import configuration with a heredoc,
+// e.g. cat >$WORK/b001/importcfg << 'EOF' or the importcfg.link of a link
+func IsImportcfgHeredoc(cmd *Command) bool {
+	return cmd.Heredoc != nil && strings.HasPrefix(path.Base(cmd.Heredoc.Target), "importcfg")
+}
+
+// Importcfg returns the import configuration a heredoc command writes, or nil for other
+// commands
+func (c Command) Importcfg() *Importcfg {
+	if !IsImportcfgHeredoc(&c) {
+		return nil
+	}
+	return ParseImportcfg(c.Heredoc.Content)
+}
+
+// WithImportcfg returns a copy of a heredoc command writing the import configuration instead
+func (c Command) WithImportcfg(cfg *Importcfg) Command {
+	return c.WithHeredocContent(cfg.String())
+}
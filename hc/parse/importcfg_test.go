@@ -0,0 +1,97 @@
+package parse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// TestImportcfgRoundTrip checks that the import configurations of a build log are written
+// back as they were read
+func TestImportcfgRoundTrip(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseFile(cgoBuildLog); err != nil {
+		t.Fatal(err)
+	}
+	found := 0
+	for _, cmd := range p.GetCommands() {
+		importcfg := cmd.Importcfg()
+		if importcfg == nil {
+			continue
+		}
+		found++
+		if got := importcfg.String(); got != cmd.Heredoc.Content {
+			t.Errorf("%s round trip\n got %q\nwant %q", cmd.Heredoc.Target, got, cmd.Heredoc.Content)
+		}
+		if got := cmd.WithImportcfg(importcfg).Raw; got != cmd.Raw {
+			t.Errorf("%s command round trip\n got %q\nwant %q", cmd.Heredoc.Target, got, cmd.Raw)
+		}
+	}
+	if found != 2 {
+		t.Errorf("found %d import configurations in %s, want 2", found, cgoBuildLog)
+	}
+}
+
+func TestImportcfgEdit(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseFile(cgoBuildLog); err != nil {
+		t.Fatal(err)
+	}
+	var link Command
+	for _, cmd := range p.GetCommands() {
+		if cmd.Heredoc != nil && strings.HasSuffix(cmd.Heredoc.Target, "/importcfg.link") {
+			link = cmd
+		}
+	}
+	importcfg := link.Importcfg()
+	if importcfg == nil {
+		t.Fatalf("no importcfg.link in %s", cgoBuildLog)
+	}
+
+	wantImports := []string{"example.com/zzcgo", "runtime/cgo", "syscall", "runtime"}
+	if got := importcfg.Imports(); !reflect.DeepEqual(got, wantImports) {
+		t.Errorf("Imports() = %q, want %q", got, wantImports)
+	}
+	if file, ok := importcfg.PackageFile("syscall"); !ok || file != "/tmp/go-build1743213396/b037/_pkg_.a" {
+		t.Errorf("PackageFile(syscall) = %q, %v", file, ok)
+	}
+	if modinfo := importcfg.ModInfo(); !strings.HasPrefix(modinfo, `"0w\xaf`) || !strings.Contains(modinfo, `main.version=1.0 beta`) {
+		t.Errorf("ModInfo() = %.40q...", modinfo)
+	}
+
+	importcfg.SetPackageFile("example.com/hooks", "/tmp/hooks/_pkg_.a")
+	importcfg.SetPackageFile("syscall", "/tmp/syscall/_pkg_.a")
+	if !importcfg.RemovePackageFile("runtime/cgo") || importcfg.RemovePackageFile("runtime/cgo") {
+		t.Error("RemovePackageFile(runtime/cgo) didn't remove the entry once")
+	}
+
+	lines := strings.Split(strings.TrimSuffix(importcfg.String(), "\n"), "\n")
+	wantLines := []string{
+		"packagefile example.com/zzcgo=/tmp/go-build1743213396/b001/_pkg_.a",
+		"packagefile syscall=/tmp/syscall/_pkg_.a",
+		"packagefile runtime=/tmp/go-build1743213396/b014/_pkg_.a",
+		"packagefile example.com/hooks=/tmp/hooks/_pkg_.a",
+	}
+	if len(lines) != len(wantLines)+1 || !reflect.DeepEqual(lines[:len(wantLines)], wantLines) || !strings.HasPrefix(lines[len(wantLines)], "modinfo ") {
+		t.Errorf("edited importcfg.link:\n%s", strings.Join(lines, "\n"))
+	}
+
+	edited := link.WithImportcfg(importcfg)
+	if reparsed := edited.Importcfg(); !reflect.DeepEqual(reparsed, importcfg) {
+		t.Errorf("edited heredoc parses into %v, want %v", reparsed, importcfg)
+	}
+	if !strings.HasSuffix(edited.Raw, "\nEOF\n") || !strings.HasPrefix(edited.Raw, "cat >/tmp/go-build1743213396/b001/importcfg.link << 'EOF'") {
+		t.Errorf("edited heredoc command:\n%s", edited.Raw)
+	}
+}
+
+func TestNewImportcfg(t *testing.T) {
+	importcfg := NewImportcfg()
+	importcfg.SetPackageFile("fmt", "$WORK/b002/_pkg_.a")
+	if got, want := importcfg.String(), "# import config\npackagefile fmt=$WORK/b002/_pkg_.a\n"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	if got := ParseImportcfg(importcfg.String()); !reflect.DeepEqual(got, importcfg) {
+		t.Errorf("ParseImportcfg(%q) = %v", importcfg.String(), got)
+	}
+}
@@ -0,0 +1,144 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// linkValueFlags are the flags of the link tool taking a value, as -o file or -o=file. The
+// others are boolean.
+var linkValueFlags = map[string]bool{
+	"B": true, "D": true, "E": true, "H": true, "I": true, "L": true, "R": true, "T": true, "X": true,
+	"benchmark": true, "benchmarkprofile": true, "buildid": true, "buildmode": true, "capturehostobjs": true,
+	"cpuprofile": true, "debugtextsize": true, "debugtramp": true, "extar": true, "extld": true,
+	"extldflags": true, "fipso": true, "funcalign": true, "importcfg": true, "installsuffix": true,
+	"k": true, "libgcc": true, "linkmode": true, "macos": true, "macsdk": true, "memprofile": true,
+	"memprofilerate": true, "o": true, "pluginpath": true, "r": true, "randlayout": true,
+	"strictdups": true, "tmpdir": true,
+}
+
+// LinkCommand is a link command of the build log split into its parts, e.g.
+// GOROOT='/usr/local/go' .../link -o $WORK/b001/exe/a.out -importcfg $WORK/b001/importcfg.link
+// -buildmode=exe -X main.version=1.0 -extld=gcc $WORK/b001/_pkg_.a
+type LinkCommand struct {
+	Env     []string // Environment assignments before the tool, e.g. GOROOT=/usr/local/go
+	Tool    string   // Path of the link tool
+	Flags   []string // Flags with their values, as written
+	Archive string   // Archive of the main package, the argument after the flags
+}
+
+// ParseLinkCommand splits a link command into its parts, or returns nil for other commands
+func ParseLinkCommand(cmd *Command) *LinkCommand {
+	if !IsLinkCommand(cmd) {
+		return nil
+	}
+	words := append([]string{cmd.Executable}, cmd.Args...)
+	link := &LinkCommand{}
+	for len(words) > 0 && isEnvAssignment(words[0]) {
+		link.Env = append(link.Env, words[0])
+		words = words[1:]
+	}
+	link.Tool, words = words[0], words[1:]
+	for i := 0; i < len(words); i++ {
+		name, _, hasValue := strings.Cut(strings.TrimLeft(words[i], "-"), "=")
+		if !strings.HasPrefix(words[i], "-") {
+			link.Archive = words[i]
+			break
+		}
+		link.Flags = append(link.Flags, words[i])
+		if !hasValue && linkValueFlags[name] && i+1 < len(words) {
+			i++
+			link.Flags = append(link.Flags, words[i])
+		}
+	}
+	return link
+}
+
+// Flag returns the value of the last occurrence of a flag, "true" for boolean flags given
+// without a value. The name is given without its dash, e.g. buildmode.
+func (l *LinkCommand) Flag(name string) (string, bool) {
+	value, found := "", false
+	for i := 0; i < len(l.Flags); i++ {
+		flagName, flagValue, hasValue := strings.Cut(strings.TrimLeft(l.Flags[i], "-"), "=")
+		if !strings.HasPrefix(l.Flags[i], "-") {
+			continue
+		}
+		switch {
+		case hasValue:
+		case linkValueFlags[flagName] && i+1 < len(l.Flags):
+			i++
+			flagValue = l.Flags[i]
+		default:
+			flagValue = "true"
+		}
+		if flagName == name {
+			value, found = flagValue, true
+		}
+	}
+	return value, found
+}
+
+// Output returns the file the link writes, the -o flag
+func (l *LinkCommand) Output() string {
+	output, _ := l.Flag("o")
+	return output
+}
+
+// Importcfg returns the import configuration of the link, the -importcfg flag
+func (l *LinkCommand) Importcfg() string {
+	importcfg, _ := l.Flag("importcfg")
+	return importcfg
+}
+
+// BuildMode returns the -buildmode of the link, exe when it has none
+func (l *LinkCommand) BuildMode() string {
+	if mode, ok := l.Flag("buildmode"); ok {
+		return mode
+	}
+	return "exe"
+}
+
+// AddFlags adds flags after those of the command, so they win over flags given before, such
+// as a -X of the same variable
+func (l *LinkCommand) AddFlags(flags ...string) {
+	l.Flags = append(l.Flags, flags...)
+}
+
+// Command returns the link command with its parts joined back, quoted for the shell
+func (l *LinkCommand) Command() Command {
+	words := append(append(append([]string{}, l.Env...), l.Tool), l.Flags...)
+	if l.Archive != "" {
+		words = append(words, l.Archive)
+	}
+	cmd := Command{Executable: words[0], Args: words[1:]}
+	cmd.Raw = cmd.String()
+	return cmd
+}
+
+// SplitFlags splits a list of flags as the go command splits -ldflags: at spaces, with a
+// word starting with a single or double quote running to the matching quote, e.g.
+// -X 'main.version=1.0 beta' -s. There are no escapes.
+func SplitFlags(flags string) ([]string, error) {
+	var split []string
+	for {
+		flags = strings.TrimLeft(flags, " \t\n\r")
+		if flags == "" {
+			return split, nil
+		}
+		if quote := flags[0]; quote == '\'' || quote == '"' {
+			end := strings.IndexByte(flags[1:], quote)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated %c quote in %q", quote, flags)
+			}
+			split = append(split, flags[1:1+end])
+			flags = flags[2+end:]
+			continue
+		}
+		end := strings.IndexAny(flags, " \t\n\r")
+		if end < 0 {
+			end = len(flags)
+		}
+		split = append(split, flags[:end])
+		flags = flags[end:]
+	}
+}
@@ -0,0 +1,118 @@
+package parse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseLinkCommand(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseFile(cgoBuildLog); err != nil {
+		t.Fatal(err)
+	}
+	var link *LinkCommand
+	commands := p.GetCommands()
+	for i := range commands {
+		if parsed := ParseLinkCommand(&commands[i]); parsed != nil {
+			if link != nil {
+				t.Fatalf("more than one link command in %s", cgoBuildLog)
+			}
+			link = parsed
+		} else if strings.Contains(commands[i].Raw, "/link ") {
+			t.Errorf("link command not parsed: %q", commands[i].Raw)
+		}
+	}
+	if link == nil {
+		t.Fatalf("no link command in %s", cgoBuildLog)
+	}
+
+	if want := []string{"GOROOT=/usr/local/go"}; !reflect.DeepEqual(link.Env, want) {
+		t.Errorf("Env = %q, want %q", link.Env, want)
+	}
+	if link.Tool != "/usr/local/go/pkg/tool/linux_amd64/link" {
+		t.Errorf("Tool = %q", link.Tool)
+	}
+	if link.Archive != "$WORK/b001/_pkg_.a" {
+		t.Errorf("Archive = %q", link.Archive)
+	}
+	if got := link.Output(); got != "$WORK/b001/exe/a.out" {
+		t.Errorf("Output() = %q", got)
+	}
+	if got := link.Importcfg(); got != "$WORK/b001/importcfg.link" {
+		t.Errorf("Importcfg() = %q", got)
+	}
+	if got := link.BuildMode(); got != "exe" {
+		t.Errorf("BuildMode() = %q", got)
+	}
+	if got, _ := link.Flag("X"); got != "main.version=1.0 beta" {
+		t.Errorf("Flag(X) = %q", got)
+	}
+	if got, ok := link.Flag("s"); !ok || got != "true" {
+		t.Errorf("Flag(s) = %q, %v", got, ok)
+	}
+	if got, _ := link.Flag("extld"); got != "gcc" {
+		t.Errorf("Flag(extld) = %q", got)
+	}
+	if _, ok := link.Flag("w"); ok {
+		t.Error("Flag(w) found")
+	}
+
+	link.AddFlags("-X", "main.version=2.0 rc", "-w")
+	cmd := link.Command()
+	if !IsLinkCommand(&cmd) {
+		t.Fatalf("rebuilt command isn't a link: %q", cmd.Raw)
+	}
+	if !strings.HasSuffix(cmd.Raw, ` -X 'main.version=2.0 rc' -w $WORK/b001/_pkg_.a`) {
+		t.Errorf("rebuilt command = %q", cmd.Raw)
+	}
+	reparsed := ParseLinkCommand(&cmd)
+	if !reflect.DeepEqual(reparsed, link) {
+		t.Errorf("rebuilt command parses into %+v, want %+v", reparsed, link)
+	}
+	if got, _ := reparsed.Flag("X"); got != "main.version=2.0 rc" {
+		t.Errorf("Flag(X) after AddFlags = %q", got)
+	}
+}
+
+func TestLinkBuildMode(t *testing.T) {
+	for _, tt := range []struct {
+		line, mode string
+	}{
+		{"/go/pkg/tool/linux_amd64/link -o a.out -buildmode=pie main.a", "pie"},
+		{"/go/pkg/tool/linux_amd64/link -buildmode c-shared -o lib.so main.a", "c-shared"},
+		{"/go/pkg/tool/linux_amd64/link -o a.out main.a", "exe"},
+	} {
+		cmd := NewParser().parseSingleLineCommand(tt.line)
+		link := ParseLinkCommand(&cmd)
+		if link == nil {
+			t.Fatalf("%q not parsed", tt.line)
+		}
+		if got := link.BuildMode(); got != tt.mode {
+			t.Errorf("%q: BuildMode() = %q, want %q", tt.line, got, tt.mode)
+		}
+		if link.Archive != "main.a" {
+			t.Errorf("%q: Archive = %q", tt.line, link.Archive)
+		}
+	}
+}
+
+func TestSplitFlags(t *testing.T) {
+	for _, tt := range []struct {
+		flags string
+		want  []string
+	}{
+		{`-X main.version=1.2.3 -s -w`, []string{"-X", "main.version=1.2.3", "-s", "-w"}},
+		{` -X 'main.version=1.0 beta'  -extldflags "-static -lm"`, []string{"-X", "main.version=1.0 beta", "-extldflags", "-static -lm"}},
+		{`-X=main.a=$HOME;b`, []string{"-X=main.a=$HOME;b"}},
+		{"", nil},
+	} {
+		got, err := SplitFlags(tt.flags)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitFlags(%q) = %q, %v, want %q", tt.flags, got, err, tt.want)
+		}
+	}
+	if _, err := SplitFlags(`-X 'main.version=1.0`); err == nil {
+		t.Error("SplitFlags accepted an unterminated quote")
+	}
+}
@@ -0,0 +1,137 @@
+package parse
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// MemoryClass is the kind of work of a build action, which its memory use is estimated from
+type MemoryClass string
+
+// Memory classes, from the most to the least memory hungry
+const (
+	MemoryClassLink    MemoryClass = "link"    // Links an executable
+	MemoryClassCgo     MemoryClass = "cgo"     // Runs cgo or the C compiler
+	MemoryClassCompile MemoryClass = "compile" // Compiles or assembles Go packages
+	MemoryClassOther   MemoryClass = "other"   // Copies files, writes build IDs, ...
+)
+
+// memoryClassOrder ranks the classes: an action gets the class of its hungriest command
+var memoryClassOrder = []MemoryClass{MemoryClassOther, MemoryClassCompile, MemoryClassCgo, MemoryClassLink}
+
+// DefaultMemoryHints are the memory estimates of actions of each class, in bytes
+var DefaultMemoryHints = map[MemoryClass]int64{
+	MemoryClassLink:    1 << 30,
+	MemoryClassCgo:     512 << 20,
+	MemoryClassCompile: 256 << 20,
+	MemoryClassOther:   16 << 20,
+}
+
+// cCompilers are the programs cgo builds run to compile and link C code
+var cCompilers = map[string]bool{"gcc": true, "g++": true, "cc": true, "c++": true, "clang": true, "clang++": true}
+
+// MemoryBudget limits the memory that actions replayed in parallel are estimated to use at a time
+type MemoryBudget struct {
+	Limit int64                 // Bytes; 0 for no limit
+	Hints map[MemoryClass]int64 // Estimated bytes used by an action of each class, DefaultMemoryHints if nil
+}
+
+// SetMemoryBudget sets the memory budget of ExecuteParallel (no limit by default)
+func (p *Parser) SetMemoryBudget(budget MemoryBudget) {
+	p.memory = budget
+}
+
+// estimate returns the memory an action of the given class is estimated to use
+func (b MemoryBudget) estimate(class MemoryClass) int64 {
+	if size, ok := b.Hints[class]; ok {
+		return size
+	}
+	return DefaultMemoryHints[class]
+}
+
+// CommandMemoryClass returns the memory class of a command
+func CommandMemoryClass(cmd *Command) MemoryClass {
+	tool := filepath.Base(ToolPath(cmd))
+	switch {
+	case IsLinkCommand(cmd):
+		return MemoryClassLink
+	case tool == "cgo" || cCompilers[tool]:
+		return MemoryClassCgo
+	case tool == "compile" || tool == "asm":
+		return MemoryClassCompile
+	}
+	return MemoryClassOther
+}
+
+// hungrier returns the more memory hungry of two classes
+func hungrier(a, b MemoryClass) MemoryClass {
+	rank := func(c MemoryClass) int {
+		for i, class := range memoryClassOrder {
+			if class == c {
+				return i
+			}
+		}
+		return 0
+	}
+	if rank(b) > rank(a) {
+		return b
+	}
+	return a
+}
+
+// ParseMemorySize parses a size such as 512MiB, 8G or 1073741824. K, M, G and T, with or without
+// a trailing B or iB, are powers of 1024.
+func ParseMemorySize(s string) (int64, error) {
+	value := strings.TrimSpace(s)
+	upper := strings.ToUpper(value)
+	upper = strings.TrimSuffix(strings.TrimSuffix(upper, "IB"), "B")
+	multiplier := int64(1)
+	if upper != "" {
+		if shift := strings.Index("KMGT", upper[len(upper)-1:]); shift >= 0 {
+			multiplier = int64(1) << (10 * (shift + 1))
+			upper = upper[:len(upper)-1]
+		}
+	}
+	number, err := strconv.ParseFloat(strings.TrimSpace(upper), 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid memory size %q", s)
+	}
+	return int64(number * float64(multiplier)), nil
+}
+
+// ParseMemoryHints parses per-class memory estimates such as "link=2GiB,cgo=1GiB". Classes not
+// listed keep their default estimate.
+func ParseMemoryHints(s string) (map[MemoryClass]int64, error) {
+	hints := make(map[MemoryClass]int64)
+	for _, entry := range strings.Split(s, ",") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+		name, size, found := strings.Cut(entry, "=")
+		class := MemoryClass(strings.TrimSpace(name))
+		if _, known := DefaultMemoryHints[class]; !found || !known {
+			return nil, fmt.Errorf("invalid memory hint %q, expected link, cgo, compile or other=SIZE", entry)
+		}
+		bytes, err := ParseMemorySize(size)
+		if err != nil {
+			return nil, err
+		}
+		hints[class] = bytes
+	}
+	return hints, nil
+}
+
+// FormatMemorySize formats a size in bytes with the largest binary unit keeping it at least 1
+func FormatMemorySize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return strconv.FormatFloat(math.Round(value*10)/10, 'f', -1, 64) + units[unit]
+}
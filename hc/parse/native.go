@@ -0,0 +1,430 @@
+package parse
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// nativeShell runs the commands of a build log in hc instead of bash: variable assignments,
+// export, cd, mkdir, echo, cat (with heredocs), cp, mv, rm and touch are interpreted in Go,
+// other commands are run directly with their words as arguments, and >, >>, <, 2>, 2>>
+// and 2>&1 redirects and &&, || and ; lists are applied, so no shell quoting is involved.
+type nativeShell struct {
+	dir      string
+	vars     map[string]string
+	exported map[string]bool
+	stdout   io.Writer
+	stderr   io.Writer
+}
+
+// newNativeShell returns a shell in the current directory with the environment of hc
+func newNativeShell(stdout, stderr io.Writer) *nativeShell {
+	s := &nativeShell{vars: make(map[string]string), exported: make(map[string]bool), stdout: stdout, stderr: stderr}
+	s.dir, _ = os.Getwd()
+	for _, variable := range os.Environ() {
+		if name, value, ok := strings.Cut(variable, "="); ok {
+			s.vars[name], s.exported[name] = value, true
+		}
+	}
+	return s
+}
+
+// lookup returns the value of a shell variable
+func (s *nativeShell) lookup(name string) (string, bool) {
+	value, ok := s.vars[name]
+	return value, ok
+}
+
+// environ returns the environment of a command: the exported variables and the assignments
+// before the command
+func (s *nativeShell) environ(assignments []string) []string {
+	var env []string
+	for name, value := range s.vars {
+		if s.exported[name] {
+			env = append(env, name+"="+value)
+		}
+	}
+	return append(env, assignments...)
+}
+
+// path resolves a file name against the directory of the shell
+func (s *nativeShell) path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(s.dir, name)
+}
+
+// Run runs a command of the build log
+func (s *nativeShell) Run(cmd *Command) error {
+	if cmd.Heredoc != nil {
+		return s.runHeredoc(cmd)
+	}
+	line := strings.TrimSpace(cmd.Raw)
+	// Variables are expanded when each command of a list runs, after the ones before it
+	words, err := splitShellWords(line, nil)
+	if err != nil {
+		return fmt.Errorf("%w in %q", err, line)
+	}
+
+	// Lists of commands separated by &&, || and ;
+	var status error
+	start := 0
+	run := true
+	for i := 0; i <= len(words); i++ {
+		if i < len(words) && words[i].Op != "&&" && words[i].Op != "||" && words[i].Op != ";" {
+			continue
+		}
+		if run {
+			status = s.runSimple(joinShellWords(words[start:i]), line)
+		}
+		if i < len(words) {
+			switch words[i].Op {
+			case "&&":
+				run = status == nil
+			case "||":
+				run = status != nil
+			default:
+				run = true
+			}
+			start = i + 1
+		}
+	}
+	return status
+}
+
+// joinShellWords returns the words as written, to be split again with variables expanded
+func joinShellWords(words []shellWord) string {
+	raw := make([]string, len(words))
+	for i, word := range words {
+		raw[i] = word.Raw
+	}
+	return strings.Join(raw, " ")
+}
+
+// runSimple runs a simple command: assignments, then words and redirects. line is the command
+// of the build log it is part of, for errors.
+func (s *nativeShell) runSimple(simple, line string) error {
+	words, err := splitShellWords(simple, s.lookup)
+	if err != nil {
+		return fmt.Errorf("%w in %q", err, line)
+	}
+
+	// Assignments before the command, then its words and redirects
+	var assignments, args []string
+	type redirect struct{ op, target string }
+	var redirects []redirect
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		switch {
+		case word.Op == "2>&1" || word.Op == ">&2":
+			redirects = append(redirects, redirect{op: word.Op})
+		case word.Op == ">" || word.Op == ">>" || word.Op == "<" || word.Op == "2>" || word.Op == "2>>" || word.Op == "&>":
+			if i+1 >= len(words) || words[i+1].Op != "" {
+				return fmt.Errorf("missing file after %s in %q", word.Op, line)
+			}
+			redirects = append(redirects, redirect{op: word.Op, target: words[i+1].Text})
+			i++
+		case word.Op != "":
+			return fmt.Errorf("%s is not supported by native execution in %q; replay without --native", word.Op, line)
+		case len(args) == 0 && isEnvAssignment(word.Raw):
+			assignments = append(assignments, word.Text)
+		default:
+			if strings.ContainsAny(word.Raw, "*?") && !strings.ContainsAny(word.Raw, "'\"") {
+				return fmt.Errorf("glob %s is not supported by native execution; replay without --native", word.Raw)
+			}
+			args = append(args, word.Text)
+		}
+	}
+
+	if len(args) == 0 {
+		// NAME=value sets a shell variable, exported only if it already was
+		for _, assignment := range assignments {
+			name, value, _ := strings.Cut(assignment, "=")
+			s.vars[name] = value
+		}
+		return nil
+	}
+
+	stdin := io.Reader(os.Stdin)
+	stdout, stderr := s.stdout, s.stderr
+	for _, r := range redirects {
+		switch r.op {
+		case "2>&1":
+			stderr = stdout
+			continue
+		case ">&2":
+			stdout = stderr
+			continue
+		case "<":
+			file, err := os.Open(s.path(r.target))
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			stdin = file
+			continue
+		}
+		flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+		if strings.HasSuffix(r.op, ">>") {
+			flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+		}
+		file, err := os.OpenFile(s.path(r.target), flags, 0666)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		switch r.op {
+		case "2>", "2>>":
+			stderr = file
+		case "&>":
+			stdout, stderr = file, file
+		default:
+			stdout = file
+		}
+	}
+
+	if builtin, ok := nativeBuiltins[args[0]]; ok && len(assignments) == 0 {
+		return builtin(s, args[1:], stdout)
+	}
+	return s.runProgram(args, assignments, stdin, stdout, stderr)
+}
+
+// ExecuteNative replays the commands without bash (--native): assignments, cd, mkdir,
+// heredocs and the other commands of build logs are interpreted by a nativeShell, one after
+// the other, stopping at the first failure
+func (p *Parser) ExecuteNative() error {
+	s := newNativeShell(p.out, os.Stderr)
+	for _, variable := range p.env {
+		name, value, _ := strings.Cut(variable, "=")
+		s.vars[name], s.exported[name] = value, true
+	}
+
+	p.log.Infof("Starting native build replay...\n")
+	for i := range p.commands {
+		cmd := &p.commands[i]
+		if strings.TrimSpace(cmd.Raw) == "" {
+			continue
+		}
+		p.log.Debugf("+ %s\n", cmd.String())
+		if err := s.Run(cmd); err != nil {
+			return fmt.Errorf("command %d: %w", i+1, err)
+		}
+	}
+	p.log.Infof("Build replay completed!\n")
+	return nil
+}
+
+// runProgram runs a program directly, with the directory and exported variables of the shell
+func (s *nativeShell) runProgram(args, assignments []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	program := exec.Command(args[0], args[1:]...)
+	program.Dir = s.dir
+	program.Env = s.environ(assignments)
+	program.Stdin = stdin
+	program.Stdout = stdout
+	program.Stderr = stderr
+	if err := program.Run(); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(args[0]), err)
+	}
+	return nil
+}
+
+// runHeredoc writes the content of a heredoc command to its target. The content of heredocs
+// whose delimiter is not quoted has its variables expanded, as bash does.
+func (s *nativeShell) runHeredoc(cmd *Command) error {
+	words, err := splitShellWords(cmd.Heredoc.Target, s.lookup)
+	if err != nil || len(words) != 1 {
+		return fmt.Errorf("invalid heredoc target %s", cmd.Heredoc.Target)
+	}
+	content := cmd.Heredoc.Content
+	firstLine, _, _ := strings.Cut(cmd.Raw, "\n")
+	if _, delimiter, _ := strings.Cut(firstLine, "<<"); !strings.ContainsAny(delimiter, "'\"\\") {
+		var expanded strings.Builder
+		for i := 0; i < len(content); i++ {
+			if content[i] != '$' {
+				expanded.WriteByte(content[i])
+				continue
+			}
+			n, err := expandVariable(content[i:], s.lookup, &expanded)
+			if err != nil {
+				return err
+			}
+			i += n - 1
+		}
+		content = expanded.String()
+	}
+	return os.WriteFile(s.path(words[0].Text), []byte(content), 0666)
+}
+
+// nativeBuiltins are the commands of build logs nativeShell interprets itself
+var nativeBuiltins = map[string]func(s *nativeShell, args []string, stdout io.Writer) error{
+	"cd": func(s *nativeShell, args []string, _ io.Writer) error {
+		if len(args) != 1 {
+			return fmt.Errorf("cd: expected one directory, got %q", args)
+		}
+		dir := s.path(args[0])
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return fmt.Errorf("cd: %s: no such directory", args[0])
+		}
+		s.dir = dir
+		return nil
+	},
+	"export": func(s *nativeShell, args []string, _ io.Writer) error {
+		for _, arg := range args {
+			name, value, ok := strings.Cut(arg, "=")
+			if ok {
+				s.vars[name] = value
+			}
+			s.exported[name] = true
+		}
+		return nil
+	},
+	"mkdir": func(s *nativeShell, args []string, _ io.Writer) error {
+		parents, dirs := nativeFlags(args, "p")
+		for _, dir := range dirs {
+			var err error
+			if parents["p"] {
+				err = os.MkdirAll(s.path(dir), 0777)
+			} else {
+				err = os.Mkdir(s.path(dir), 0777)
+			}
+			if err != nil {
+				return fmt.Errorf("mkdir: %w", err)
+			}
+		}
+		return nil
+	},
+	"echo": func(s *nativeShell, args []string, stdout io.Writer) error {
+		newline := "\n"
+		if len(args) > 0 && args[0] == "-n" {
+			newline, args = "", args[1:]
+		}
+		_, err := io.WriteString(stdout, strings.Join(args, " ")+newline)
+		return err
+	},
+	"cat": func(s *nativeShell, args []string, stdout io.Writer) error {
+		for _, name := range args {
+			data, err := os.ReadFile(s.path(name))
+			if err != nil {
+				return fmt.Errorf("cat: %w", err)
+			}
+			if _, err := stdout.Write(data); err != nil {
+				return err
+			}
+		}
+		return nil
+	},
+	"cp": func(s *nativeShell, args []string, _ io.Writer) error {
+		_, files := nativeFlags(args, "")
+		if len(files) < 2 {
+			return fmt.Errorf("cp: expected sources and a destination, got %q", args)
+		}
+		dst := s.path(files[len(files)-1])
+		for _, src := range files[:len(files)-1] {
+			if err := nativeCopy(s.path(src), nativeDestination(s.path(src), dst)); err != nil {
+				return fmt.Errorf("cp: %w", err)
+			}
+		}
+		return nil
+	},
+	"mv": func(s *nativeShell, args []string, _ io.Writer) error {
+		_, files := nativeFlags(args, "f")
+		if len(files) != 2 {
+			return fmt.Errorf("mv: expected a source and a destination, got %q", args)
+		}
+		src := s.path(files[0])
+		dst := nativeDestination(src, s.path(files[1]))
+		if err := os.Rename(src, dst); err != nil {
+			// Across file systems, such as from $WORK to the project
+			if err := nativeCopy(src, dst); err != nil {
+				return fmt.Errorf("mv: %w", err)
+			}
+			return os.Remove(src)
+		}
+		return nil
+	},
+	"rm": func(s *nativeShell, args []string, _ io.Writer) error {
+		flags, files := nativeFlags(args, "rf")
+		for _, name := range files {
+			var err error
+			if flags["r"] {
+				err = os.RemoveAll(s.path(name))
+			} else {
+				err = os.Remove(s.path(name))
+			}
+			if err != nil && !(flags["f"] && os.IsNotExist(err)) {
+				return fmt.Errorf("rm: %w", err)
+			}
+		}
+		return nil
+	},
+	"touch": func(s *nativeShell, args []string, _ io.Writer) error {
+		now := time.Now()
+		for _, name := range args {
+			path := s.path(name)
+			if err := os.Chtimes(path, now, now); os.IsNotExist(err) {
+				file, err := os.Create(path)
+				if err != nil {
+					return fmt.Errorf("touch: %w", err)
+				}
+				file.Close()
+			} else if err != nil {
+				return fmt.Errorf("touch: %w", err)
+			}
+		}
+		return nil
+	},
+	"true": func(*nativeShell, []string, io.Writer) error { return nil },
+}
+
+// nativeFlags splits the arguments of a builtin into its single-letter flags, of those
+// allowed, and its operands. Flags that are not allowed are kept as operands.
+func nativeFlags(args []string, allowed string) (map[string]bool, []string) {
+	flags := make(map[string]bool)
+	var operands []string
+	for _, arg := range args {
+		if len(arg) > 1 && arg[0] == '-' && strings.Trim(arg[1:], allowed) == "" {
+			for _, flag := range arg[1:] {
+				flags[string(flag)] = true
+			}
+			continue
+		}
+		operands = append(operands, arg)
+	}
+	return flags, operands
+}
+
+// nativeDestination returns where cp and mv put src: into dst when it is a directory
+func nativeDestination(src, dst string) string {
+	if info, err := os.Stat(dst); err == nil && info.IsDir() {
+		return filepath.Join(dst, filepath.Base(src))
+	}
+	return dst
+}
+
+// nativeCopy copies a file, keeping its permissions
+func nativeCopy(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,49 @@
+package parse
+
+import (
+	"path"
+	"strings"
+)
+
+// PackagePaths resolves the import paths of the packages compiled by a build log. The -p flag
+// of compile commands is the import path of every package but main ones, which are compiled
+// with -p main; the importcfg.link of the binary linking a main package lists its archive
+// under its import path.
+type PackagePaths struct {
+	byArchive map[string]string // Import paths of the linked archives, by archiveKey
+}
+
+// NewPackagePaths collects the archives the importcfg.link heredocs of a build log list
+func NewPackagePaths(commands []Command) *PackagePaths {
+	p := &PackagePaths{byArchive: make(map[string]string)}
+	for i := range commands {
+		cmd := &commands[i]
+		if !IsImportcfgHeredoc(cmd) || path.Base(cmd.Heredoc.Target) != "importcfg.link" {
+			continue
+		}
+		for importPath, archive := range cmd.Importcfg().PackageFiles() {
+			p.byArchive[archiveKey(archive)] = importPath
+		}
+	}
+	return p
+}
+
+// ImportPath returns the import path of the package a compile command builds: its -p value,
+// or for main packages the import path they are linked under, "main" when no link lists it
+func (p *PackagePaths) ImportPath(cmd *Command) string {
+	packageName := ExtractPackageName(cmd)
+	if packageName != "main" || p == nil {
+		return packageName
+	}
+	if importPath, ok := p.byArchive[archiveKey(ExtractOutputPath(cmd))]; ok {
+		return importPath
+	}
+	return packageName
+}
+
+// archiveKey identifies an archive of the WORK directory by its action directory and name,
+// e.g. b001/_pkg_.a, which heredocs give with WORK expanded and commands with $WORK
+func archiveKey(archive string) string {
+	dir, name := path.Split(archive)
+	return path.Base(strings.TrimSuffix(dir, "/")) + "/" + name
+}
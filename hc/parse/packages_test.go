@@ -0,0 +1,53 @@
+package parse
+
+import (
+	"strings"
+	"testing"
+)
+
+// twoBinariesLog is the log of go build -x -work ./cmd/... building two commands
+const twoBinariesLog = `WORK=/tmp/go-build42
+mkdir -p $WORK/b002/
+cd /src/app
+/go/pkg/tool/linux_amd64/compile -o $WORK/b002/_pkg_.a -trimpath "$WORK/b002=>" -p example.com/app/handlers -pack ./handlers/handlers.go
+mkdir -p $WORK/b001/
+/go/pkg/tool/linux_amd64/compile -o $WORK/b001/_pkg_.a -trimpath "$WORK/b001=>" -p main -complete -pack ./cmd/api/main.go
+mkdir -p $WORK/b003/
+/go/pkg/tool/linux_amd64/compile -o $WORK/b003/_pkg_.a -trimpath "$WORK/b003=>" -p main -complete -pack ./cmd/worker/main.go
+cat >/tmp/go-build42/b001/importcfg.link << 'EOF' # internal
+packagefile example.com/app/cmd/api=/tmp/go-build42/b001/_pkg_.a
+packagefile example.com/app/handlers=/tmp/go-build42/b002/_pkg_.a
+EOF
+cat >/tmp/go-build42/b003/importcfg.link << 'EOF' # internal
+packagefile example.com/app/cmd/worker=/tmp/go-build42/b003/_pkg_.a
+packagefile example.com/app/handlers=/tmp/go-build42/b002/_pkg_.a
+EOF
+`
+
+func TestPackagePaths(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseReader(strings.NewReader(twoBinariesLog)); err != nil {
+		t.Fatal(err)
+	}
+	commands := p.GetCommands()
+	paths := NewPackagePaths(commands)
+
+	var compiles []*Command
+	var got []string
+	for i := range commands {
+		if IsCompileCommand(&commands[i]) {
+			compiles = append(compiles, &commands[i])
+			got = append(got, paths.ImportPath(&commands[i]))
+		}
+	}
+	want := []string{"example.com/app/handlers", "example.com/app/cmd/api", "example.com/app/cmd/worker"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("import paths = %q, want %q", got, want)
+	}
+
+	// Main packages no link lists keep their -p value
+	unlinked := NewPackagePaths(nil)
+	if importPath := unlinked.ImportPath(compiles[1]); importPath != "main" {
+		t.Errorf("import path of an unlinked main package = %q", importPath)
+	}
+}
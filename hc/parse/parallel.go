@@ -0,0 +1,458 @@
+package parse
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// buildAction is a group of commands working in one directory of $WORK, such as the compilation of
+// a package (mkdir, importcfg, compile, buildid, cp). Its commands are replayed in order, and
+// only after the actions whose outputs they reference.
+type buildAction struct {
+	id     string   // Directory of the action in $WORK, e.g. b001; empty for barriers
+	script []string // Shell lines replaying the action, with the state they depend on
+	deps   []int    // Indexes of the actions this action waits for
+	reads  []int    // Indexes of the actions whose outputs it references, a subset of deps
+	memory MemoryClass
+	kind   string // "compile" or "link" for actions running the compiler or the linker
+	pkg    string // Package compiled by compile actions
+	// Value of WORK when the action starts, to ship its inputs to workers
+	workDir   string
+	positions []int // Positions of the commands of the action in the build log
+}
+
+// workRefPattern matches references to directories of $WORK
+var workRefPattern = regexp.MustCompile(`\$\{?WORK\}?/([^/\s"'=:]+)`)
+
+// assignmentPattern matches shell variable assignments such as WORK=/tmp/go-build123
+var assignmentPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*=\S*$`)
+
+// planActions groups the commands into actions and derives their dependencies. Variable
+// assignments and cd commands are shell state: they are replayed at the start of every action
+// needing them. Commands referencing no directory of $WORK are barriers, ordered after all
+// actions before them and before all actions after them.
+func (p *Parser) planActions() ([]*buildAction, error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get working directory: %w", err)
+	}
+
+	// References of a command to other actions, resolved once all actions are known
+	type reference struct {
+		action int
+		pos    int
+		refs   []string // Directories the command reads
+		soft   []string // Directories listed by import configurations written before the build
+	}
+
+	var actions []*buildAction
+	var starts []int                   // Position of the first command of every action
+	segments := make(map[string][]int) // Actions of every directory, split by barriers
+	var references []reference
+	// Every action starts with the exported variables and the assignments before it
+	assignments := p.envExports()
+	workDir := ""
+	barrier := -1
+	// Directory each action's script is in, to emit cd only when it changes
+	actionDirs := make(map[int]string)
+	// Files written by heredocs of the build log
+	written := make(map[string]bool)
+
+	for pos, cmd := range p.commands {
+		line := cmd.String()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		if !cmd.IsMultiline && assignmentPattern.MatchString(strings.TrimSpace(line)) {
+			assignments = append(assignments, line)
+			if name, value, _ := strings.Cut(strings.TrimSpace(line), "="); name == "WORK" {
+				workDir = value
+			}
+			continue
+		}
+		if cmd.Executable == "cd" && len(cmd.Args) == 1 {
+			dir := cmd.Args[0]
+			if !filepath.IsAbs(dir) && !strings.HasPrefix(dir, "$") {
+				dir = filepath.Join(cwd, dir)
+			}
+			cwd = dir
+			continue
+		}
+
+		if cmd.Heredoc != nil {
+			written[strings.ReplaceAll(cmd.Heredoc.Target, "$WORK", workDir)] = true
+		}
+		refs := workRefs(line, workDir)
+
+		index := -1
+		if len(refs) > 0 {
+			// Commands after a barrier start a new action, which can wait for the barrier
+			if segs := segments[refs[0]]; len(segs) > 0 && segs[len(segs)-1] > barrier {
+				index = segs[len(segs)-1]
+			}
+		}
+		if index < 0 {
+			action := &buildAction{script: append([]string{}, assignments...), workDir: workDir}
+			if len(refs) > 0 {
+				action.id = refs[0]
+				if barrier >= 0 {
+					action.deps = append(action.deps, barrier)
+				}
+			} else {
+				for i := range actions {
+					action.deps = append(action.deps, i)
+				}
+			}
+			index = len(actions)
+			actions = append(actions, action)
+			starts = append(starts, pos)
+			if action.id != "" {
+				segments[action.id] = append(segments[action.id], index)
+			} else {
+				barrier = index
+			}
+		}
+
+		action := actions[index]
+		action.memory = hungrier(action.memory, CommandMemoryClass(&cmd))
+		if actionDirs[index] != cwd {
+			action.script = append(action.script, "cd "+quoteDir(cwd))
+			actionDirs[index] = cwd
+		}
+		action.script = append(action.script, line)
+		action.positions = append(action.positions, pos)
+		if IsCompileCommand(&cmd) {
+			action.kind, action.pkg = "compile", ExtractPackageName(&cmd)
+		} else if IsLinkCommand(&cmd) {
+			action.kind = "link"
+		}
+
+		ref := reference{action: index, pos: pos}
+		if len(refs) > 1 {
+			ref.refs = refs[1:]
+		}
+		// Import configurations written before the build, such as the one of the hooks
+		// package, list the archives the command may need
+		for i, arg := range cmd.Args {
+			if arg != "-importcfg" || i+1 >= len(cmd.Args) {
+				continue
+			}
+			importcfg := strings.ReplaceAll(cmd.Args[i+1], "$WORK", workDir)
+			if written[importcfg] {
+				continue
+			}
+			if content, err := os.ReadFile(importcfg); err == nil {
+				ref.soft = append(ref.soft, workRefs(string(content), workDir)...)
+			}
+		}
+		references = append(references, ref)
+	}
+
+	// resolve returns the action of dir current at pos, or the first one after it
+	resolve := func(dir string, pos int) int {
+		segs := segments[dir]
+		if len(segs) == 0 {
+			return -1
+		}
+		resolved := segs[0]
+		for _, seg := range segs {
+			if starts[seg] <= pos {
+				resolved = seg
+			}
+		}
+		return resolved
+	}
+
+	// Archives listed by import configurations but not referenced by the build log may not be
+	// needed at all, so they are waited for only when that creates no cycle
+	for _, soft := range []bool{false, true} {
+		for _, ref := range references {
+			action := actions[ref.action]
+			dirs := ref.refs
+			if soft {
+				dirs = ref.soft
+			}
+			for _, dir := range dirs {
+				dep := resolve(dir, ref.pos)
+				if dep < 0 || dep == ref.action {
+					continue
+				}
+				if !slices.Contains(action.deps, dep) {
+					if dependsOn(actions, dep, ref.action) {
+						if soft {
+							continue
+						}
+						return nil, fmt.Errorf("actions %s and %s depend on each other", action.id, actions[dep].id)
+					}
+					action.deps = append(action.deps, dep)
+				}
+				if !slices.Contains(action.reads, dep) {
+					action.reads = append(action.reads, dep)
+				}
+			}
+		}
+	}
+
+	for _, action := range actions {
+		sort.Ints(action.deps)
+	}
+	return actions, nil
+}
+
+// dependsOn reports whether the action at index from waits for the action at index to,
+// directly or through other actions
+func dependsOn(actions []*buildAction, from, to int) bool {
+	visited := make(map[int]bool)
+	pending := []int{from}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dep := range actions[current].deps {
+			if dep == to {
+				return true
+			}
+			if !visited[dep] {
+				visited[dep] = true
+				pending = append(pending, dep)
+			}
+		}
+	}
+	return false
+}
+
+// workRefs returns the directories of $WORK referenced by line, the first one first. Absolute
+// paths into the work directory are recognized as well, as used by importcfg heredocs.
+func workRefs(line, workDir string) []string {
+	if workDir != "" {
+		line = strings.ReplaceAll(line, workDir+"/", "$WORK/")
+	}
+	var refs []string
+	for _, match := range workRefPattern.FindAllStringSubmatch(line, -1) {
+		if !slices.Contains(refs, match[1]) {
+			refs = append(refs, match[1])
+		}
+	}
+	return refs
+}
+
+// quoteDir quotes a directory for cd, leaving variable references such as $WORK expandable
+func quoteDir(dir string) string {
+	if strings.ContainsAny(dir, " \t'\"") {
+		return `"` + strings.ReplaceAll(dir, `"`, `\"`) + `"`
+	}
+	return dir
+}
+
+// scriptLines returns the commands as lines of a shell script
+func (p *Parser) scriptLines() []string {
+	lines := p.envExports()
+	for _, cmd := range p.commands {
+		if line := cmd.String(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// actionResult is the outcome of replaying an action
+type actionResult struct {
+	index          int
+	stdout, stderr bytes.Buffer
+	err            error
+	worker         int   // Worker that replayed the action, -1 when replayed locally
+	workerErr      error // Why the worker could not replay the action, which is then replayed locally
+	start, end     time.Time
+}
+
+// ActionTiming is when an action of a parallel replay ran, for build profiles
+type ActionTiming struct {
+	Action  string // Directory of the action in $WORK, or its position for command groups
+	Kind    string // "compile" or "link" for actions running the compiler or the linker
+	Package string // Package compiled by compile actions
+	Worker  string // Worker that replayed the action, empty when replayed locally
+	Start   time.Time
+	End     time.Time
+}
+
+// Timings returns when the actions of the last ExecuteParallel ran, in the order they finished
+func (p *Parser) Timings() []ActionTiming {
+	return p.timings
+}
+
+// ExecuteParallel replays the build with up to jobs actions running at a time. The output of
+// every action is written once it finishes, so the output of concurrent actions doesn't
+// interleave. After a failure no further actions are started. With workers (SetWorkers),
+// compile actions are replayed by the least busy worker, with the files they read; a worker
+// that fails is not used again and its action is replayed locally.
+func (p *Parser) ExecuteParallel(jobs int) error {
+	if jobs < 1 {
+		return fmt.Errorf("invalid number of jobs %d", jobs)
+	}
+	actions, err := p.planActions()
+	if err != nil {
+		p.log.Warnf("cannot replay the build in parallel, replaying it sequentially: %v\n", err)
+		actions = []*buildAction{{script: p.scriptLines()}}
+	}
+	if p.memory.Limit > 0 {
+		p.log.Infof("Replaying %d commands as %d actions with %d jobs within %s of memory...\n",
+			len(p.commands), len(actions), jobs, FormatMemorySize(p.memory.Limit))
+	} else {
+		p.log.Infof("Replaying %d commands as %d actions with %d jobs...\n", len(p.commands), len(actions), jobs)
+	}
+
+	waiting := make([]int, len(actions))
+	dependents := make([][]int, len(actions))
+	var ready []int
+	for i, action := range actions {
+		waiting[i] = len(action.deps)
+		for _, dep := range action.deps {
+			dependents[dep] = append(dependents[dep], i)
+		}
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+
+	var dist *distributor
+	busy := make([]int, len(p.workers)) // Actions running on every worker, -1 once it failed
+	if len(p.workers) > 0 {
+		dist = newDistributor(p.workers)
+		names := make([]string, len(p.workers))
+		for i, worker := range p.workers {
+			names[i] = worker.String()
+		}
+		p.log.Infof("Distributing compile actions to %s...\n", strings.Join(names, ", "))
+	}
+	distributed := 0
+
+	p.timings = nil
+	results := make(chan *actionResult)
+	running := 0
+	var reserved int64 // Memory estimated to be used by the running actions
+	done := 0
+	var failure error
+	for done < len(actions) {
+		// Actions start in order, so a memory hungry action isn't overtaken indefinitely by
+		// smaller ones. An action estimated to need more than the budget runs alone.
+		for failure == nil && running < jobs && len(ready) > 0 {
+			index := ready[0]
+			estimate := p.memory.estimate(actions[index].memory)
+			if p.memory.Limit > 0 && running > 0 && reserved+estimate > p.memory.Limit {
+				p.log.Debugf("Action %s (%s) waits for %s of memory\n", actionName(actions, index), actions[index].memory, FormatMemorySize(estimate))
+				break
+			}
+			ready = ready[1:]
+			running++
+			reserved += estimate
+			worker := -1
+			if dist != nil && remoteEligible(actions[index]) {
+				for i, n := range busy {
+					if n >= 0 && (worker < 0 || n < busy[worker]) {
+						worker = i
+					}
+				}
+			}
+			if worker < 0 {
+				go func() {
+					results <- runAction(index, actions[index])
+				}()
+				continue
+			}
+			busy[worker]++
+			go func() {
+				start := time.Now()
+				result, err := dist.runRemote(index, worker, actions[index])
+				if err != nil {
+					result = &actionResult{index: index, workerErr: err}
+				}
+				result.worker = worker
+				result.start, result.end = start, time.Now()
+				results <- result
+			}()
+		}
+		if running == 0 {
+			if failure == nil {
+				failure = fmt.Errorf("%d actions wait for each other", len(actions)-done)
+			}
+			break
+		}
+
+		result := <-results
+		if result.worker >= 0 && busy[result.worker] >= 0 {
+			busy[result.worker]--
+		}
+		if result.workerErr != nil {
+			p.log.Warnf("worker %s failed, not using it again: %v\n", p.workers[result.worker], result.workerErr)
+			busy[result.worker] = -1
+			go func() {
+				results <- runAction(result.index, actions[result.index])
+			}()
+			continue
+		}
+		if result.worker >= 0 {
+			distributed++
+		}
+		running--
+		reserved -= p.memory.estimate(actions[result.index].memory)
+		done++
+		timing := ActionTiming{Action: actionName(actions, result.index), Kind: actions[result.index].kind,
+			Package: actions[result.index].pkg, Start: result.start, End: result.end}
+		if result.worker >= 0 {
+			timing.Worker = p.workers[result.worker].String()
+		}
+		p.timings = append(p.timings, timing)
+		p.out.Write(result.stdout.Bytes())
+		os.Stderr.Write(result.stderr.Bytes())
+		if result.err != nil {
+			if failure == nil {
+				failure = fmt.Errorf("replay of %s failed: %w", actionName(actions, result.index), result.err)
+			}
+			continue
+		}
+		for _, dependent := range dependents[result.index] {
+			waiting[dependent]--
+			if waiting[dependent] == 0 {
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if failure != nil {
+		return failure
+	}
+
+	if dist != nil {
+		p.log.Infof("Replayed %d of %d actions on workers\n", distributed, len(actions))
+	}
+	p.log.Infof("Build replay completed!\n")
+	return nil
+}
+
+// actionName returns the $WORK directory of an action, or its position for barriers
+func actionName(actions []*buildAction, index int) string {
+	if actions[index].id == "" {
+		return fmt.Sprintf("command group %d", index+1)
+	}
+	return actions[index].id
+}
+
+// runAction replays the commands of an action in a shell stopping at the first error
+func runAction(index int, action *buildAction) *actionResult {
+	result := &actionResult{index: index, worker: -1}
+	cmd := exec.Command("bash", "-e", "-c", strings.Join(action.script, "\n"))
+	cmd.Env = os.Environ()
+	cmd.Stdout = &result.stdout
+	cmd.Stderr = &result.stderr
+	result.start = time.Now()
+	result.err = cmd.Run()
+	result.end = time.Now()
+	return result
+}
@@ -0,0 +1,463 @@
+// Package parse reads the commands of a go build -x log (as captured by hc --json or
+// hc --capture) so they can be inspected, rewritten and replayed.
+package parse
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/logging"
+)
+
+type Command struct {
+	Raw         string
+	Executable  string
+	Args        []string
+	IsMultiline bool
+	Heredoc     *Heredoc // File written by a heredoc command, nil for other commands
+}
+
+type Parser struct {
+	commands []Command
+	out      io.Writer       // Receives the output of executed commands and of DumpCommands
+	log      *logging.Logger // Receives progress messages and warnings
+	memory   MemoryBudget    // Limits the actions ExecuteParallel runs at a time
+	workers  []Worker        // Machines ExecuteParallel distributes compile actions to
+	env      []string        // NAME=value variables exported to replayed commands (SetEnv)
+	timings  []ActionTiming  // When the actions of the last ExecuteParallel ran
+}
+
+func NewParser() *Parser {
+	return &Parser{
+		commands: make([]Command, 0),
+		out:      os.Stdout,
+		log:      logging.Default,
+	}
+}
+
+// SetOutput sets where the output of executed commands and dumps is written (stdout by default)
+func (p *Parser) SetOutput(w io.Writer) {
+	p.out = w
+}
+
+// SetLogger sets the logger of progress messages and warnings (logging.Default by default)
+func (p *Parser) SetLogger(log *logging.Logger) {
+	p.log = log
+}
+
+// SetEnv exports an environment variable to the replayed commands, in generated scripts too
+func (p *Parser) SetEnv(name, value string) {
+	for i, variable := range p.env {
+		if strings.HasPrefix(variable, name+"=") {
+			p.env[i] = name + "=" + value
+			return
+		}
+	}
+	p.env = append(p.env, name+"="+value)
+}
+
+// envExports returns the shell lines exporting the variables set with SetEnv
+func (p *Parser) envExports() []string {
+	var lines []string
+	for _, variable := range p.env {
+		name, value, _ := strings.Cut(variable, "=")
+		lines = append(lines, "export "+name+"="+shellQuote(value))
+	}
+	return lines
+}
+
+func (p *Parser) ParseFile(filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	return p.ParseReader(file)
+}
+
+func (p *Parser) ParseReader(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			continue
+		}
+
+		if strings.Contains(line, "cat >") && strings.Contains(line, "<< 'EOF'") {
+			cmd, err := p.parseHeredocCommand(line, scanner)
+			if err != nil {
+				return fmt.Errorf("failed to parse heredoc: %w", err)
+			}
+			p.commands = append(p.commands, cmd)
+		} else {
+			cmd := p.parseSingleLineCommand(line)
+			p.commands = append(p.commands, cmd)
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (p *Parser) parseHeredocCommand(startLine string, scanner *bufio.Scanner) (Command, error) {
+	// Remove any comment from the heredoc start line
+	cleanStartLine := startLine
+	if idx := strings.Index(startLine, " # "); idx != -1 {
+		cleanStartLine = startLine[:idx]
+	}
+
+	var content strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "EOF" {
+			break
+		}
+		// go -x prints files not ending with a newline, such as the embedcfg of packages
+		// with //go:embed, with EOF right after their last line, e.g. }EOF
+		last, unterminated := strings.CutSuffix(line, "EOF")
+		if unterminated {
+			line = last
+		}
+		content.WriteString(line)
+		content.WriteString("\n")
+		if unterminated {
+			break
+		}
+	}
+
+	raw := cleanStartLine + "\n" + content.String() + "EOF\n"
+
+	parts := strings.Fields(cleanStartLine)
+	if len(parts) < 2 {
+		return Command{}, fmt.Errorf("invalid heredoc command: %s", startLine)
+	}
+
+	cmd := Command{
+		Raw:         raw,
+		Executable:  parts[0],
+		Args:        parts[1:],
+		IsMultiline: true,
+	}
+	if target := heredocTargetPattern.FindStringSubmatch(cleanStartLine); target != nil {
+		cmd.Heredoc = &Heredoc{Target: target[1], Content: content.String()}
+	}
+	return cmd, nil
+}
+
+func (p *Parser) parseSingleLineCommand(line string) Command {
+	parts := parseCommandLine(line)
+
+	if len(parts) == 0 {
+		return Command{Raw: line}
+	}
+
+	return Command{
+		Raw:         line,
+		Executable:  parts[0],
+		Args:        parts[1:],
+		IsMultiline: false,
+	}
+}
+
+// parseCommandLine splits a command line into its words as the shell does, see
+// splitShellWords: quotes are removed, $WORK and other variables are kept for the shell to
+// expand, operators such as > and 2>&1 are words of their own and a trailing # comment is
+// dropped. Lines the tokenizer rejects, e.g. with an unterminated quote, are split at spaces.
+func parseCommandLine(line string) []string {
+	words, err := splitShellWords(line, nil)
+	if err != nil {
+		return strings.Fields(line)
+	}
+	parts := make([]string, len(words))
+	for i, word := range words {
+		parts[i] = word.Text
+	}
+	return parts
+}
+
+func (p *Parser) GetCommands() []Command {
+	return p.commands
+}
+
+// SetCommands replaces the parsed commands, e.g. with a rewritten build
+func (p *Parser) SetCommands(commands []Command) {
+	p.commands = commands
+}
+
+// GenerateScript writes the parsed commands to scriptPath as an executable bash script
+func (p *Parser) GenerateScript(scriptPath string) error {
+	if len(p.commands) == 0 {
+		return nil
+	}
+
+	// Ensure the script directory exists
+	if err := os.MkdirAll(filepath.Dir(scriptPath), 0755); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+
+	// Create a single shell script with all commands
+	var script strings.Builder
+	script.WriteString("#!/bin/bash\n")
+	script.WriteString("set -e  # Exit on any error\n\n")
+	for _, line := range p.envExports() {
+		script.WriteString(line + "\n")
+	}
+
+	for _, cmd := range p.commands {
+		cmdStr := cmd.String()
+		if cmdStr != "" {
+			script.WriteString(cmdStr)
+			script.WriteString("\n")
+		}
+	}
+
+	// Write script to file
+	scriptFile, err := os.Create(scriptPath)
+	if err != nil {
+		return fmt.Errorf("failed to create script file: %w", err)
+	}
+	defer scriptFile.Close()
+
+	_, err = scriptFile.WriteString(script.String())
+	if err != nil {
+		return fmt.Errorf("failed to write script file: %w", err)
+	}
+
+	// Make the script executable
+	err = os.Chmod(scriptPath, 0755)
+	if err != nil {
+		return fmt.Errorf("failed to make script executable: %w", err)
+	}
+
+	p.log.Infof("Generated executable script saved to: %s\n", scriptPath)
+	return nil
+}
+
+// ExecuteAll writes the replay script to scriptPath and runs it
+func (p *Parser) ExecuteAll(scriptPath string) error {
+	// First generate the script
+	err := p.GenerateScript(scriptPath)
+	if err != nil {
+		return err
+	}
+
+	// Then execute it
+	return p.ExecuteScript(scriptPath)
+}
+
+// ExecuteScript runs a replay script written by GenerateScript
+func (p *Parser) ExecuteScript(scriptPath string) error {
+	// Check if script file exists
+	if _, err := os.Stat(scriptPath); os.IsNotExist(err) {
+		return fmt.Errorf("replay script does not exist: %s", scriptPath)
+	}
+
+	// Execute the script from current directory with explicit bash and environment
+	shellCmd := exec.Command("bash", scriptPath)
+
+	// Explicitly inherit all environment variables
+	shellCmd.Env = os.Environ()
+
+	// Set up IO streams
+	shellCmd.Stdout = p.out
+	shellCmd.Stderr = os.Stderr
+	shellCmd.Stdin = os.Stdin
+
+	// Progress is logged by hc rather than echoed by the script, so it can be filtered
+	p.log.Infof("Starting build replay...\n")
+	if err := shellCmd.Run(); err != nil {
+		return err
+	}
+	p.log.Infof("Build replay completed!\n")
+	return nil
+}
+
+func (p *Parser) ExecuteInteractive() error {
+	if len(p.commands) == 0 {
+		fmt.Fprintln(p.out, "No commands to execute.")
+		return nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	fmt.Fprintln(p.out, "=== Interactive Mode ===")
+	fmt.Fprintln(p.out, "Commands will be executed one by one. You can:")
+	fmt.Fprintln(p.out, "  y/yes/enter - Execute this command")
+	fmt.Fprintln(p.out, "  n/no        - Skip this command")
+	fmt.Fprintln(p.out, "  q/quit      - Quit interactive mode")
+	fmt.Fprintln(p.out, "  s/show      - Show the command without executing")
+	fmt.Fprintln(p.out)
+
+	// Commands run one after the other in a persistent bash shell, so what one sets up is
+	// seen by the next
+	sh, err := startShell(p.out, p.envExports())
+	if err != nil {
+		return err
+	}
+	defer sh.Close()
+
+	executed := 0
+	skipped := 0
+	failed := 0
+
+	for i, cmd := range p.commands {
+		cmdStr := cmd.String()
+		if cmdStr == "" {
+			continue
+		}
+
+		fmt.Fprintf(p.out, "Command %d/%d:\n", i+1, len(p.commands))
+
+		// Show a shortened version of long commands
+		displayCmd := cmdStr
+		if len(displayCmd) > 100 {
+			displayCmd = displayCmd[:97] + "..."
+		}
+		fmt.Fprintf(p.out, "  %s\n", displayCmd)
+
+		for {
+			fmt.Fprint(p.out, "Execute? [y/n/q/s]: ")
+			input, err := reader.ReadString('\n')
+			if err != nil {
+				return fmt.Errorf("error reading input: %w", err)
+			}
+
+			input = strings.TrimSpace(strings.ToLower(input))
+
+			switch input {
+			case "", "y", "yes":
+				fmt.Fprintf(p.out, "Executing: %s\n", cmdStr)
+				executed++
+
+				status, err := sh.Run(cmdStr)
+				if err != nil {
+					fmt.Fprintf(p.out, "Commands executed: %d, skipped: %d, failed: %d\n", executed, skipped, failed)
+					return fmt.Errorf("command %d: %w", i+1, err)
+				}
+				if status == 0 {
+					fmt.Fprintln(p.out, "✓ Command succeeded")
+					goto nextCommand
+				}
+
+				failed++
+				fmt.Fprintf(p.out, "✗ Command failed with exit status %d\n", status)
+				fmt.Fprint(p.out, "Continue with the next command? [y/N]: ")
+				continueInput, _ := reader.ReadString('\n')
+				continueInput = strings.TrimSpace(strings.ToLower(continueInput))
+				if continueInput != "y" && continueInput != "yes" {
+					fmt.Fprintf(p.out, "Commands executed: %d, skipped: %d, failed: %d\n", executed, skipped, failed)
+					return fmt.Errorf("command %d failed with exit status %d", i+1, status)
+				}
+				goto nextCommand
+
+			case "n", "no":
+				fmt.Fprintln(p.out, "⊝ Skipped")
+				skipped++
+				goto nextCommand
+
+			case "q", "quit":
+				fmt.Fprintf(p.out, "\nInteractive mode stopped by user.\n")
+				fmt.Fprintf(p.out, "Commands executed: %d, skipped: %d, failed: %d\n", executed, skipped, failed)
+				return nil
+
+			case "s", "show":
+				fmt.Fprintf(p.out, "Full command:\n%s\n", cmdStr)
+				// Continue the loop to ask again
+
+			default:
+				fmt.Fprintln(p.out, "Invalid input. Use y/n/q/s")
+				// Continue the loop to ask again
+			}
+		}
+
+	nextCommand:
+		fmt.Fprintln(p.out)
+	}
+
+	fmt.Fprintf(p.out, "Interactive execution completed!\n")
+	fmt.Fprintf(p.out, "Commands executed: %d, skipped: %d, failed: %d\n", executed, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d commands failed", failed)
+	}
+	return nil
+}
+
+func (p *Parser) DumpCommands() {
+	for i, cmd := range p.commands {
+		fmt.Fprintf(p.out, "Command %d:\n", i+1)
+		if cmd.IsMultiline {
+			fmt.Fprintf(p.out, "  Type: Multiline (Heredoc)\n")
+			if cmd.Heredoc != nil {
+				fmt.Fprintf(p.out, "  Target: %s\n", cmd.Heredoc.Target)
+				if cmd.Heredoc.Artifact != "" {
+					fmt.Fprintf(p.out, "  Artifact: %s\n", cmd.Heredoc.Artifact)
+				}
+			}
+			fmt.Fprintf(p.out, "  Raw:\n%s\n", indent(cmd.Raw, "    "))
+		} else {
+			fmt.Fprintf(p.out, "  Type: Single Line\n")
+			fmt.Fprintf(p.out, "  Executable: %s\n", cmd.Executable)
+			if len(cmd.Args) > 0 {
+				fmt.Fprintf(p.out, "  Args: %v\n", cmd.Args)
+			}
+			fmt.Fprintf(p.out, "  Raw: %s\n", cmd.Raw)
+		}
+		fmt.Fprintln(p.out)
+	}
+}
+
+func indent(text string, prefix string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Execute runs the command on its own, without bash, in the current directory and the
+// environment of hc. Variables it assigns and cd are lost after it.
+func (c *Command) Execute() error {
+	if strings.TrimSpace(c.Raw) == "" {
+		return nil
+	}
+	return newNativeShell(os.Stdout, os.Stderr).Run(c)
+}
+
+func (c *Command) String() string {
+	if c.IsMultiline {
+		return c.Raw
+	}
+	if c.Executable == "" {
+		return c.Raw
+	}
+
+	// Commands with redirections, pipes or lists are kept as written, without their comment
+	cleanRaw := c.Raw
+	if idx := strings.Index(c.Raw, " # "); idx != -1 {
+		cleanRaw = strings.TrimSpace(c.Raw[:idx])
+	}
+	if words, err := splitShellWords(c.Raw, nil); err == nil {
+		for _, word := range words {
+			if word.Op != "" {
+				return cleanRaw
+			}
+		}
+	}
+
+	// Quote the words so the shell splits them back into the same Executable and Args
+	assignments := isEnvAssignment(c.Executable)
+	words := []string{quoteCommandWord(c.Executable, assignments)}
+	for _, arg := range c.Args {
+		assignments = assignments && isEnvAssignment(arg)
+		words = append(words, quoteCommandWord(arg, assignments))
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,135 @@
+package parse
+
+import (
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// cgoBuildLog is an excerpt of go build -x -a of a cgo program built with
+// -ldflags '-X "main.version=1.0 beta" -s'
+const cgoBuildLog = "testdata/cgo-build.log"
+
+func TestParseCommandLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{
+			`/usr/local/go/pkg/tool/linux_amd64/compile -o $WORK/b005/_pkg_.a -trimpath "$WORK/b005=>" -p internal/goarch`,
+			[]string{"/usr/local/go/pkg/tool/linux_amd64/compile", "-o", "$WORK/b005/_pkg_.a", "-trimpath", "$WORK/b005=>", "-p", "internal/goarch"},
+		},
+		{
+			`TERM='dumb' CGO_LDFLAGS='' /usr/local/go/pkg/tool/linux_amd64/cgo -objdir $WORK/b001/ "-ldflags=\"-O2\" \"-g\"" -- -I $WORK/b001/ -O2 -g ./main.go`,
+			[]string{"TERM=dumb", "CGO_LDFLAGS=", "/usr/local/go/pkg/tool/linux_amd64/cgo", "-objdir", "$WORK/b001/", `-ldflags="-O2" "-g"`, "--", "-I", "$WORK/b001/", "-O2", "-g", "./main.go"},
+		},
+		{
+			`GOROOT='/usr/local/go' /usr/local/go/pkg/tool/linux_amd64/link -o $WORK/b001/exe/a.out -X "main.version=1.0 beta" -s`,
+			[]string{"GOROOT=/usr/local/go", "/usr/local/go/pkg/tool/linux_amd64/link", "-o", "$WORK/b001/exe/a.out", "-X", "main.version=1.0 beta", "-s"},
+		},
+		{
+			`gcc -o $WORK/b001/_cgo_.o $WORK/b001/_cgo_main.o -O2 -g # test for internal linking errors (succeeded)`,
+			[]string{"gcc", "-o", "$WORK/b001/_cgo_.o", "$WORK/b001/_cgo_main.o", "-O2", "-g"},
+		},
+		{
+			`echo '# import config' > $WORK/b002/importcfg # internal`,
+			[]string{"echo", "# import config", ">", "$WORK/b002/importcfg"},
+		},
+		{
+			`printf "%s\n" 'it'\''s' "a \"quoted\" \$WORK" a\ b`,
+			[]string{"printf", `%s\n`, "it's", `a "quoted" $WORK`, "a b"},
+		},
+		{
+			`cgo -ldflags="-X 'main.name=a b'" -ccflags=-DX=1#2`,
+			[]string{"cgo", "-ldflags=-X 'main.name=a b'", "-ccflags=-DX=1#2"},
+		},
+		{
+			`cd "$WORK/b001" && ls 2>&1`,
+			[]string{"cd", "$WORK/b001", "&&", "ls", "2>&1"},
+		},
+		{
+			`echo "unterminated quote`,
+			[]string{"echo", `"unterminated`, "quote"},
+		},
+		{`# comment only`, []string{}},
+	}
+	for _, tt := range tests {
+		if got := parseCommandLine(tt.line); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseCommandLine(%q)\n got %q\nwant %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestParseBuildLog(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseFile(cgoBuildLog); err != nil {
+		t.Fatal(err)
+	}
+
+	find := func(prefix string) *Command {
+		t.Helper()
+		commands := p.GetCommands()
+		for i := range commands {
+			if strings.HasPrefix(commands[i].Raw, prefix) {
+				return &commands[i]
+			}
+		}
+		t.Fatalf("no command starting with %q in %s", prefix, cgoBuildLog)
+		return nil
+	}
+
+	cgo := find("TERM='dumb' CGO_LDFLAGS='' ")
+	if tool := ToolPath(cgo); tool != "/usr/local/go/pkg/tool/linux_amd64/cgo" {
+		t.Errorf("ToolPath of the cgo command = %q", tool)
+	}
+	if !slices.Contains(cgo.Args, `-ldflags="-O2" "-g"`) {
+		t.Errorf("cgo -ldflags mangled: %q", cgo.Args)
+	}
+
+	compile := find("/usr/local/go/pkg/tool/linux_amd64/compile -o $WORK/b001/")
+	if name := ExtractPackageName(compile); name != "main" {
+		t.Errorf("ExtractPackageName = %q, want main", name)
+	}
+	if files := ExtractPackFiles(compile); len(files) != 3 || files[0] != "$WORK/b001/_cgo_gotypes.go" {
+		t.Errorf("ExtractPackFiles = %q", files)
+	}
+	if !slices.Contains(compile.Args, "$WORK/b001=>") {
+		t.Errorf("compile -trimpath mangled: %q", compile.Args)
+	}
+
+	link := find("GOROOT='/usr/local/go' ")
+	if !IsLinkCommand(link) {
+		t.Errorf("not a link command: %q", link.Raw)
+	}
+	if !slices.Contains(link.Args, "main.version=1.0 beta") || !slices.Contains(link.Args, "-s") {
+		t.Errorf("link -X mangled: %q", link.Args)
+	}
+
+	buildid := find("go tool buildid -w $WORK/b001/_pkg_.a")
+	if want := []string{"tool", "buildid", "-w", "$WORK/b001/_pkg_.a"}; !reflect.DeepEqual(buildid.Args, want) {
+		t.Errorf("buildid args = %q, want %q", buildid.Args, want)
+	}
+}
+
+// TestCommandStringRoundTrip checks that the script line of every command splits back into the
+// same words
+func TestCommandStringRoundTrip(t *testing.T) {
+	p := NewParser()
+	if err := p.ParseFile(cgoBuildLog); err != nil {
+		t.Fatal(err)
+	}
+	commands := append(p.GetCommands(),
+		Command{Executable: "CGO_CFLAGS=-O2 -g", Args: []string{"compile", "-D", "", "it's", `a "b" $c`, "x\\y", "{a,b}", "*.go"}},
+	)
+	for _, cmd := range commands {
+		if cmd.IsMultiline || cmd.Executable == "" {
+			continue
+		}
+		line := cmd.String()
+		got := p.parseSingleLineCommand(line)
+		if got.Executable != cmd.Executable || !reflect.DeepEqual(got.Args, cmd.Args) {
+			t.Errorf("%q\n splits into %q %q\n        want %q %q", line, got.Executable, got.Args, cmd.Executable, cmd.Args)
+		}
+	}
+}
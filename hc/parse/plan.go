@@ -0,0 +1,97 @@
+package parse
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+)
+
+// probeSuffix ends the C compiler probes go build -n prints for cgo packages, such as
+// gcc -fno-caret-diagnostics -c -x c - -o /dev/null || true. go build runs them with its own
+// stdin and caches their result, so they are neither in the logs of go build -x nor replayable.
+const probeSuffix = " -o /dev/null || true"
+
+// PlanCompletion is the build log of a build run without -a, completed with the commands go
+// build -n -a prints for every action of the build: the plan of the build
+type PlanCompletion struct {
+	Log      string   // Build log: the WORK= assignment of the build and the commands of the plan
+	WorkDir  string   // WORK directory of the build, whose archives are those it compiled
+	Compiled []string // Packages the build compiled, in the order of the plan
+	Cached   []string // Packages the build took from the build cache, in the order of the plan
+}
+
+// CompleteFromPlan completes the build log of go build -x -work, without -a, with its plan,
+// printed by go build -n -a with the same flags. The actions of the build get the same
+// $WORK/bNNN directories in both, so the plan is the build log of the build rebuilding every
+// package: its compile commands of the packages the build compiled are the ones it ran, and
+// those of the packages it took from the build cache are synthesized. The heredocs of the plan
+// are written as go build -x writes them, with the WORK directory of the build instead of
+// $WORK, and its C compiler probes are left out.
+func CompleteFromPlan(captured, plan string) (*PlanCompletion, error) {
+	workDir := ""
+	for _, line := range strings.Split(captured, "\n") {
+		if strings.HasPrefix(line, "WORK=") {
+			workDir = strings.TrimPrefix(line, "WORK=")
+			break
+		}
+	}
+	if workDir == "" {
+		return nil, fmt.Errorf("no WORK= in the build log (was it captured with -x -work?)")
+	}
+
+	var log strings.Builder
+	log.WriteString("WORK=" + workDir + "\n")
+	scanner := bufio.NewScanner(strings.NewReader(plan))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	inHeredoc := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case inHeredoc:
+			line = strings.ReplaceAll(line, "$WORK", workDir)
+			// The last line of a heredoc without a final newline ends with EOF, e.g. }EOF
+			inHeredoc = !strings.HasSuffix(line, "EOF")
+		case strings.Contains(line, "<< 'EOF'"):
+			inHeredoc = true
+		case strings.HasSuffix(line, probeSuffix):
+			continue
+		}
+		log.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading the plan: %w", err)
+	}
+
+	completion := &PlanCompletion{Log: log.String(), WorkDir: workDir}
+	compiled := make(map[string]bool)
+	for _, pkg := range compiledPackages(captured) {
+		compiled[pkg] = true
+	}
+	for _, pkg := range compiledPackages(completion.Log) {
+		if compiled[pkg] {
+			completion.Compiled = append(completion.Compiled, pkg)
+		} else {
+			completion.Cached = append(completion.Cached, pkg)
+		}
+	}
+	if len(completion.Compiled)+len(completion.Cached) == 0 {
+		return nil, fmt.Errorf("the plan compiles no package")
+	}
+	return completion, nil
+}
+
+// compiledPackages returns the packages of the compile commands of a build log
+func compiledPackages(log string) []string {
+	p := NewParser()
+	if err := p.ParseReader(strings.NewReader(log)); err != nil {
+		return nil
+	}
+	var packages []string
+	commands := p.GetCommands()
+	for i := range commands {
+		if IsCompileCommand(&commands[i]) {
+			packages = append(packages, ExtractPackageName(&commands[i]))
+		}
+	}
+	return packages
+}
@@ -0,0 +1,97 @@
+package parse
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// planCaptured is the log of go build -x -work of a build taking every package but main from
+// the build cache
+const planCaptured = `WORK=/tmp/go-build123
+mkdir -p $WORK/b001/
+cat >/tmp/go-build123/b001/importcfg << 'EOF' # internal
+# import config
+packagefile example.com/app/calc=/root/.cache/go-build/4a/4a25-d
+EOF
+cd /src/app
+/go/pkg/tool/linux_amd64/compile -o $WORK/b001/_pkg_.a -trimpath "$WORK/b001=>" -p main -importcfg $WORK/b001/importcfg -pack ./main.go
+`
+
+// planPrinted is the plan of the build printed by go build -n -a
+const planPrinted = `mkdir -p $WORK/b002/
+
+#
+# example.com/app/calc
+#
+
+cd $WORK
+gcc -fno-caret-diagnostics -O2 -g -c -x c - -o /dev/null || true
+cat >$WORK/b002/importcfg << 'EOF' # internal
+# import config
+packagefile runtime/cgo=$WORK/b003/_pkg_.a
+EOF
+cat >$WORK/b002/embedcfg << 'EOF'
+{"Patterns":{},"Files":{}}EOF
+cd /src/app
+/go/pkg/tool/linux_amd64/compile -o $WORK/b002/_pkg_.a -trimpath "$WORK/b002=>" -p example.com/app/calc -importcfg $WORK/b002/importcfg -embedcfg $WORK/b002/embedcfg -pack ./calc/calc.go
+mkdir -p $WORK/b001/
+
+#
+# example.com/app
+#
+
+cat >$WORK/b001/importcfg << 'EOF' # internal
+# import config
+packagefile example.com/app/calc=$WORK/b002/_pkg_.a
+EOF
+/go/pkg/tool/linux_amd64/compile -o $WORK/b001/_pkg_.a -trimpath "$WORK/b001=>" -p main -importcfg $WORK/b001/importcfg -pack ./main.go
+`
+
+func TestCompleteFromPlan(t *testing.T) {
+	completion, err := CompleteFromPlan(planCaptured, planPrinted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completion.WorkDir != "/tmp/go-build123" {
+		t.Errorf("WorkDir = %q", completion.WorkDir)
+	}
+	if !reflect.DeepEqual(completion.Compiled, []string{"main"}) || !reflect.DeepEqual(completion.Cached, []string{"example.com/app/calc"}) {
+		t.Errorf("Compiled = %q, Cached = %q", completion.Compiled, completion.Cached)
+	}
+	if strings.Contains(completion.Log, "/dev/null") {
+		t.Errorf("C compiler probe kept:\n%s", completion.Log)
+	}
+
+	p := NewParser()
+	if err := p.ParseReader(strings.NewReader(completion.Log)); err != nil {
+		t.Fatal(err)
+	}
+	commands := p.GetCommands()
+	if commands[0].Raw != "WORK=/tmp/go-build123" {
+		t.Errorf("first command = %q", commands[0].Raw)
+	}
+	var heredocs []string
+	for _, cmd := range commands {
+		if cmd.Heredoc != nil {
+			heredocs = append(heredocs, cmd.Heredoc.Content)
+		}
+	}
+	want := []string{
+		"# import config\npackagefile runtime/cgo=/tmp/go-build123/b003/_pkg_.a\n",
+		"{\"Patterns\":{},\"Files\":{}}\n",
+		"# import config\npackagefile example.com/app/calc=/tmp/go-build123/b002/_pkg_.a\n",
+	}
+	if !reflect.DeepEqual(heredocs, want) {
+		t.Errorf("heredocs = %q, want %q", heredocs, want)
+	}
+	// Commands keep $WORK, which the shell expands
+	calc := compileOf(t, commands, "example.com/app/calc")
+	if ExtractOutputPath(calc) != "$WORK/b002/_pkg_.a" {
+		t.Errorf("compile of calc = %s", calc.Raw)
+	}
+
+	if _, err := CompleteFromPlan("mkdir -p $WORK/b001/\n", planPrinted); err == nil {
+		t.Error("CompleteFromPlan accepted a build log without WORK=")
+	}
+}
@@ -0,0 +1,132 @@
+package parse
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PackageSelection is what SelectPackage kept of the build log
+type PackageSelection struct {
+	Actions  []string // Directories of $WORK of the actions kept, in build order
+	Commands int      // Commands kept
+	Dropped  int      // Commands dropped
+}
+
+// SelectPackage keeps only the commands building one package: the actions compiling it, and
+// linking it for main packages, and the actions producing the archives they reference,
+// directly or through their import configurations. Variable assignments and the cd commands
+// before kept commands are kept as shell state; commands referencing no directory of $WORK
+// are dropped. The package is matched by the -p flag of its compile command, or, for main
+// packages compiled as -p main, by the packagefile entries of the import configurations.
+func (p *Parser) SelectPackage(importPath string) (*PackageSelection, error) {
+	actions, err := p.planActions()
+	if err != nil {
+		return nil, err
+	}
+
+	dir := ""
+	for _, action := range actions {
+		if action.kind == "compile" && action.pkg == importPath {
+			dir = action.id
+			break
+		}
+	}
+	if dir == "" {
+		dir = p.packageFileDir(importPath)
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("no compile command for package %s in the build log", importPath)
+	}
+
+	// The actions of the package's directory and, transitively, the actions they read
+	keep := make(map[int]bool)
+	var pending []int
+	for i, action := range actions {
+		if action.id == dir {
+			keep[i] = true
+			pending = append(pending, i)
+		}
+	}
+	for len(pending) > 0 {
+		current := pending[len(pending)-1]
+		pending = pending[:len(pending)-1]
+		for _, dep := range actions[current].reads {
+			if !keep[dep] {
+				keep[dep] = true
+				pending = append(pending, dep)
+			}
+		}
+	}
+
+	inAction := make(map[int]bool) // Positions of the commands of any action
+	kept := make(map[int]bool)
+	selection := &PackageSelection{}
+	indexes := make([]int, 0, len(keep))
+	for i := range keep {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		selection.Actions = append(selection.Actions, actions[i].id)
+		for _, pos := range actions[i].positions {
+			kept[pos] = true
+		}
+	}
+	for _, action := range actions {
+		for _, pos := range action.positions {
+			inAction[pos] = true
+		}
+	}
+
+	var commands []Command
+	// cd commands are kept only when a kept command follows them; an absolute cd makes the
+	// ones before it pointless
+	var cds []Command
+	for pos, cmd := range p.commands {
+		switch {
+		case inAction[pos] && !kept[pos]:
+			selection.Dropped++
+		case cmd.Executable == "cd" && len(cmd.Args) == 1:
+			if dir := cmd.Args[0]; filepath.IsAbs(dir) || strings.HasPrefix(dir, "$") {
+				cds = cds[:0]
+			}
+			cds = append(cds, cmd)
+		default:
+			if kept[pos] {
+				commands = append(commands, cds...)
+				cds = cds[:0]
+				selection.Commands++
+			}
+			commands = append(commands, cmd)
+		}
+	}
+	p.commands = commands
+	return selection, nil
+}
+
+// packageFileDir returns the directory of $WORK of the archive of a package listed by the
+// packagefile entries of the import configurations written by the build log
+func (p *Parser) packageFileDir(importPath string) string {
+	workDir := ""
+	for _, cmd := range p.commands {
+		line := strings.TrimSpace(cmd.String())
+		if !cmd.IsMultiline && assignmentPattern.MatchString(line) {
+			if name, value, _ := strings.Cut(line, "="); name == "WORK" {
+				workDir = value
+			}
+			continue
+		}
+		importcfg := cmd.Importcfg()
+		if importcfg == nil {
+			continue
+		}
+		if archive, ok := importcfg.PackageFile(importPath); ok {
+			if refs := workRefs(archive, workDir); len(refs) > 0 {
+				return refs[0]
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,105 @@
+package parse
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// shell is the persistent bash of ExecuteInteractive. Every command is followed by a line
+// printing a sentinel with its exit status, so Run knows when the command finished and
+// whether it succeeded while variables and the working directory carry over to the next.
+type shell struct {
+	cmd      *exec.Cmd
+	stdin    io.WriteCloser
+	sentinel string
+	status   chan int // Exit status of every command, closed when the shell exits
+}
+
+// startShell starts bash with the output of the commands written to out
+func startShell(out io.Writer, env []string) (*shell, error) {
+	nonce := make([]byte, 8)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to create sentinel: %w", err)
+	}
+	s := &shell{
+		cmd:      exec.Command("bash"),
+		sentinel: "__HC_STATUS_" + hex.EncodeToString(nonce) + "__",
+		status:   make(chan int),
+	}
+	stdin, err := s.cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	stdout, err := s.cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+	s.stdin = stdin
+	s.cmd.Stderr = os.Stderr
+	if err := s.cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start shell: %w", err)
+	}
+	go s.forward(stdout, out)
+
+	for _, line := range env {
+		if _, err := fmt.Fprintln(s.stdin, line); err != nil {
+			s.Close()
+			return nil, fmt.Errorf("failed to set up shell: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// forward copies the output of the shell to out, taking the sentinel lines out of it
+func (s *shell) forward(stdout io.Reader, out io.Writer) {
+	defer close(s.status)
+	reader := bufio.NewReader(stdout)
+	for {
+		line, err := reader.ReadString('\n')
+		if i := strings.Index(line, s.sentinel); i >= 0 {
+			// Output not ending in a newline is followed by the sentinel on the same line
+			if i > 0 {
+				fmt.Fprintln(out, line[:i])
+			}
+			status, convErr := strconv.Atoi(strings.TrimSpace(line[i+len(s.sentinel):]))
+			if convErr != nil {
+				status = -1
+			}
+			s.status <- status
+		} else if line != "" {
+			io.WriteString(out, line)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Run runs a command in the shell and returns its exit status once it finished. It fails
+// when the shell exited, e.g. because the command ran exit.
+func (s *shell) Run(command string) (int, error) {
+	if _, err := fmt.Fprintf(s.stdin, "%s\nprintf '%%s%%d\\n' %s \"$?\"\n", command, s.sentinel); err != nil {
+		return 0, fmt.Errorf("failed to send command to shell: %w", err)
+	}
+	status, ok := <-s.status
+	if !ok {
+		return 0, fmt.Errorf("shell exited: %v", s.cmd.Wait())
+	}
+	return status, nil
+}
+
+// Close ends the shell after its running command
+func (s *shell) Close() {
+	s.stdin.Close()
+	// Drain the status of a command nobody waits for so forward can return
+	for range s.status {
+	}
+	s.cmd.Wait()
+}
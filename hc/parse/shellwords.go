@@ -0,0 +1,194 @@
+package parse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// shellWord is a word of a command line split by splitShellWords: a word with its quotes
+// removed, or an operator such as > or 2>&1
+type shellWord struct {
+	Text string // Word without quotes, with variables expanded when a lookup was given
+	Raw  string // Word as written, to recognize NAME=value assignments
+	Op   string // Operator, e.g. ">", ">>", "<", "2>", "2>&1", "|"; empty for words
+}
+
+// shellOperators are the operators splitShellWords recognizes outside quotes, longest first
+var shellOperators = []string{"2>&1", ">&2", "&>", "2>>", "2>", ">>", "<<", ">", "<", "&&", "||", "|", ";", "&", "(", ")"}
+
+// splitShellWords splits a command line into words and operators as a POSIX shell does:
+// single quotes keep their content as is, double quotes and backslashes escape, and # starts
+// a comment at the beginning of a word. With lookup, $NAME and ${NAME} are expanded outside
+// single quotes; without it they are kept as written. Command substitution is not supported.
+func splitShellWords(line string, lookup func(string) (string, bool)) ([]shellWord, error) {
+	var words []shellWord
+	var text, raw strings.Builder
+	inWord := false
+	flush := func() {
+		if inWord {
+			words = append(words, shellWord{Text: text.String(), Raw: raw.String()})
+		}
+		text.Reset()
+		raw.Reset()
+		inWord = false
+	}
+
+	for i := 0; i < len(line); {
+		c := line[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			flush()
+			i++
+		case c == '#' && !inWord:
+			return words, nil
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated single quote")
+			}
+			text.WriteString(line[i+1 : i+1+end])
+			raw.WriteString(line[i : i+2+end])
+			inWord = true
+			i += end + 2
+		case c == '"':
+			j := i + 1
+			for ; j < len(line) && line[j] != '"'; j++ {
+				switch {
+				case line[j] == '\\' && j+1 < len(line) && strings.IndexByte("$`\"\\\n", line[j+1]) >= 0:
+					j++
+					if line[j] != '\n' {
+						text.WriteByte(line[j])
+					}
+				case line[j] == '$':
+					n, err := expandVariable(line[j:], lookup, &text)
+					if err != nil {
+						return nil, err
+					}
+					j += n - 1
+				case line[j] == '`':
+					return nil, fmt.Errorf("command substitution is not supported")
+				default:
+					text.WriteByte(line[j])
+				}
+			}
+			if j >= len(line) {
+				return nil, fmt.Errorf("unterminated double quote")
+			}
+			raw.WriteString(line[i : j+1])
+			inWord = true
+			i = j + 1
+		case c == '\\':
+			if i+1 < len(line) {
+				if line[i+1] != '\n' {
+					text.WriteByte(line[i+1])
+					inWord = true
+				}
+				raw.WriteString(line[i : i+2])
+				i += 2
+			} else {
+				i++
+			}
+		case c == '$':
+			n, err := expandVariable(line[i:], lookup, &text)
+			if err != nil {
+				return nil, err
+			}
+			raw.WriteString(line[i : i+n])
+			inWord = true
+			i += n
+		case c == '`':
+			return nil, fmt.Errorf("command substitution is not supported")
+		default:
+			op := ""
+			for _, candidate := range shellOperators {
+				if strings.HasPrefix(line[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			// A digit is the file descriptor of a redirect only when it starts the word
+			if op != "" && strings.HasPrefix(op, "2") && inWord {
+				op = ""
+			}
+			if op == "" {
+				text.WriteByte(c)
+				raw.WriteByte(c)
+				inWord = true
+				i++
+				continue
+			}
+			flush()
+			words = append(words, shellWord{Text: op, Raw: op, Op: op})
+			i += len(op)
+		}
+	}
+	flush()
+	return words, nil
+}
+
+// expandVariable writes the value of the variable reference at the start of s, $NAME or
+// ${NAME}, to text and returns its length. A $ not followed by a name is kept as is, and so
+// are references when lookup is nil. Unset variables expand to nothing.
+func expandVariable(s string, lookup func(string) (string, bool), text *strings.Builder) (int, error) {
+	if len(s) > 1 && s[1] == '(' {
+		return 0, fmt.Errorf("command substitution is not supported")
+	}
+	name, n := "", 1
+	if len(s) > 1 && s[1] == '{' {
+		end := strings.IndexByte(s, '}')
+		if end < 0 {
+			return 0, fmt.Errorf("unterminated ${")
+		}
+		name, n = s[2:end], end+1
+	} else {
+		for n < len(s) && isNameByte(s[n], n == 1) {
+			n++
+		}
+		name = s[1:n]
+	}
+	if name == "" || lookup == nil {
+		text.WriteString(s[:n])
+		return n, nil
+	}
+	value, _ := lookup(name)
+	text.WriteString(value)
+	return n, nil
+}
+
+// isNameByte reports whether c can be part of a shell variable name
+func isNameByte(c byte, first bool) bool {
+	return c == '_' || (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (!first && c >= '0' && c <= '9')
+}
+
+// quoteCommandWord quotes a word of a parsed command for the shell to split it back into the
+// same word. Variable references such as $WORK stay expandable, so words with $ are double
+// quoted when they need quotes; NAME=value assignments have only their value quoted to remain
+// assignments.
+func quoteCommandWord(word string, assignment bool) string {
+	if assignment {
+		name, value, _ := strings.Cut(word, "=")
+		if value == "" {
+			return name + "="
+		}
+		return name + "=" + quoteCommandWord(value, false)
+	}
+	if word == "" {
+		return "''"
+	}
+	plain := true
+	for i := 0; i < len(word); i++ {
+		c := word[i]
+		if !isNameByte(c, false) && strings.IndexByte("$@%+=:,./-", c) < 0 {
+			plain = false
+			break
+		}
+	}
+	switch {
+	case plain:
+		return word
+	case strings.Contains(word, "$"):
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "`", "\\`").Replace(word) + `"`
+	default:
+		return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+	}
+}
@@ -0,0 +1,70 @@
+package parse
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+)
+
+// goBuildDirPattern matches the WORK directories go build creates, e.g. /tmp/go-build123456
+var goBuildDirPattern = regexp.MustCompile(`(?:/[^/\s"'=:;,]+)*/go-build[0-9]+`)
+
+// WorkDirRewrite is a WORK directory of the build log and the directory it was moved to
+type WorkDirRewrite struct {
+	From string
+	To   string
+}
+
+// RewriteWorkDirs moves the build into workDir: the WORK directories of the build log, the
+// /tmp/go-buildNNN paths of its WORK= assignments, commands, heredocs and import
+// configurations, are replaced so a log whose directories were removed long ago replays
+// cleanly. The first directory becomes workDir, the directories of further builds of the log
+// its subdirectories 2, 3... It returns the directories replaced, in the order they were found.
+func (p *Parser) RewriteWorkDirs(workDir string) []WorkDirRewrite {
+	var rewrites []WorkDirRewrite
+	mapping := make(map[string]string)
+	for _, cmd := range p.commands {
+		for _, dir := range goBuildDirPattern.FindAllString(cmd.String(), -1) {
+			if _, ok := mapping[dir]; ok {
+				continue
+			}
+			to := workDir
+			if len(rewrites) > 0 {
+				to = filepath.Join(workDir, fmt.Sprint(len(rewrites)+1))
+			}
+			mapping[dir] = to
+			rewrites = append(rewrites, WorkDirRewrite{From: dir, To: to})
+		}
+	}
+	if len(rewrites) == 0 {
+		return nil
+	}
+
+	rewrite := func(text string) string {
+		return goBuildDirPattern.ReplaceAllStringFunc(text, func(dir string) string {
+			return mapping[dir]
+		})
+	}
+	for i, cmd := range p.commands {
+		if !cmd.IsMultiline {
+			if raw := rewrite(cmd.Raw); raw != cmd.Raw {
+				p.commands[i] = p.parseSingleLineCommand(raw)
+			}
+			continue
+		}
+		cmd.Raw = rewrite(cmd.Raw)
+		cmd.Args = append([]string{}, cmd.Args...)
+		for j, arg := range cmd.Args {
+			cmd.Args[j] = rewrite(arg)
+		}
+		if cmd.Heredoc != nil {
+			heredoc := *cmd.Heredoc
+			heredoc.Target = rewrite(heredoc.Target)
+			heredoc.Content = rewrite(heredoc.Content)
+			heredoc.Artifact = ""
+			cmd.Heredoc = &heredoc
+		}
+		p.commands[i] = cmd
+	}
+	return rewrites
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// InstrumentationPlan is the --plan result: what --compile would instrument with the hooks,
+// found by matching them against the functions of the build log without writing or compiling
+// anything
+type InstrumentationPlan struct {
+	HooksFiles      []string               `json:"hooksFiles"`
+	HooksImportPath string                 `json:"hooksImportPath"`
+	Packages        []PlannedPackage       `json:"packages"`
+	GeneratedFiles  []PlannedGeneratedFile `json:"generatedFiles"`
+	UnmatchedHooks  []string               `json:"unmatchedHooks"` // Targets of the hooks matching no function
+}
+
+// PlannedPackage is a package whose files instrumentation would rewrite
+type PlannedPackage struct {
+	Package string        `json:"package"`
+	Files   []PlannedFile `json:"files"`
+}
+
+// PlannedFile is a source file instrumentation would rewrite
+type PlannedFile struct {
+	File      string            `json:"file"`
+	Functions []PlannedFunction `json:"functions"`
+	Structs   []string          `json:"structs,omitempty"` // Structs of the file the hooks add fields to
+}
+
+// PlannedFunction is a function instrumentation would hook
+type PlannedFunction struct {
+	Function string        `json:"function"` // Name, with the receiver for methods: (*Server) Run
+	Line     int           `json:"line"`
+	Hooks    []PlannedHook `json:"hooks"` // Hooks applying to the function, in the order they are combined
+}
+
+// PlannedHook is a hook applying to a function
+type PlannedHook struct {
+	Target    string `json:"target"`  // Target of the hook, as in the hooks file
+	Applies   string `json:"applies"` // What of the hook applies: before_after, rewrite or both
+	HooksFile string `json:"hooksFile"`
+}
+
+// PlannedGeneratedFile is a file instrumentation would add to a package
+type PlannedGeneratedFile struct {
+	Package string `json:"package"`
+	Name    string `json:"name"`
+}
+
+// planInstrumentation matches the hooks against the functions of the packages compiled by the
+// build log as --compile does, and returns which functions of which files they would
+// instrument and which files would be generated. Nothing is written: the WORK directory is
+// left untouched and nothing is compiled.
+func planInstrumentation(commands []parse.Command, hooksFiles []string) (*InstrumentationPlan, error) {
+	if len(hooksFiles) == 0 {
+		return nil, fmt.Errorf("no hooks files provided")
+	}
+	hooks, structMods, generatedFiles, err := loadHooksFiles(hooksFiles)
+	if err != nil {
+		return nil, err
+	}
+	hooksImportPath, err := instrument.GetHooksImportPath(hooksFiles[0])
+	if err != nil {
+		report.Warnf("could not determine hooks import path: %v\n", err)
+		hooksImportPath = "generated_hooks"
+	}
+
+	plan := &InstrumentationPlan{
+		HooksFiles:      hooksFiles,
+		HooksImportPath: hooksImportPath,
+		Packages:        []PlannedPackage{},
+		GeneratedFiles:  []PlannedGeneratedFile{},
+		UnmatchedHooks:  []string{},
+	}
+	matched := make(map[string]bool)
+	seenFiles := make(map[string]bool)
+	seenStructMods := make(map[string]bool)
+	runtimeDeps := runtimeDependencies(commands)
+	warnedRuntimeDeps := make(map[string]bool)
+	cgoSources := parse.NewCgoSources(commands)
+	packagePaths := parse.NewPackagePaths(commands)
+	needsRuntime := false
+
+	for i := range commands {
+		cmd := &commands[i]
+		if !parse.IsCompileCommand(cmd) {
+			continue
+		}
+		packageName := parse.ExtractPackageName(cmd)
+		files := cgoSources.SourceFiles(cmd)
+		if packageName == "" || len(files) == 0 {
+			continue
+		}
+		pkgHooks := packageHooks(packageName, packagePaths.ImportPath(cmd), files, hooks, runtimeDeps, warnedRuntimeDeps)
+
+		pkg := PlannedPackage{Package: packageName}
+		needsTrampolines := false
+		for _, file := range files {
+			if !strings.HasSuffix(file, ".go") || seenFiles[packageName+":"+file] {
+				continue
+			}
+			seenFiles[packageName+":"+file] = true
+			functions, err := analyze.ExtractFunctionsFromGoFile(file)
+			if err != nil {
+				continue
+			}
+			planned := PlannedFile{File: file}
+			for j := range functions {
+				fn := &functions[j]
+				fnHooks := plannedHooks(packageName, fn, pkgHooks, matched)
+				if len(fnHooks) == 0 {
+					continue
+				}
+				for _, hook := range fnHooks {
+					needsTrampolines = needsTrampolines || hook.Applies != "rewrite"
+				}
+				name := fn.Name
+				if fn.Receiver != "" {
+					name = fmt.Sprintf("(%s) %s", fn.Receiver, fn.Name)
+				}
+				planned.Functions = append(planned.Functions, PlannedFunction{Function: name, Line: fn.Line, Hooks: fnHooks})
+			}
+			if len(planned.Functions) > 0 {
+				pkg.Files = append(pkg.Files, planned)
+			}
+		}
+
+		for _, mod := range structMods {
+			modKey := mod.Package + ":" + mod.StructName
+			if mod.Package != packageName || seenStructMods[modKey] {
+				continue
+			}
+			structFile, err := findStructDefinitionFile(files, mod.StructName)
+			if err != nil {
+				continue
+			}
+			seenStructMods[modKey] = true
+			added := false
+			for j := range pkg.Files {
+				if pkg.Files[j].File == structFile {
+					pkg.Files[j].Structs = append(pkg.Files[j].Structs, mod.StructName)
+					added = true
+				}
+			}
+			if !added {
+				pkg.Files = append(pkg.Files, PlannedFile{File: structFile, Functions: []PlannedFunction{}, Structs: []string{mod.StructName}})
+			}
+		}
+
+		if len(pkg.Files) > 0 {
+			plan.Packages = append(plan.Packages, pkg)
+		}
+		if needsTrampolines {
+			plan.GeneratedFiles = append(plan.GeneratedFiles, PlannedGeneratedFile{Package: packageName, Name: "otel_trampolines.go"})
+			needsRuntime = true
+		}
+	}
+
+	if needsRuntime {
+		plan.GeneratedFiles = append(plan.GeneratedFiles, PlannedGeneratedFile{Package: "main", Name: "otel.runtime.go"})
+	}
+	for _, genFile := range generatedFiles {
+		plan.GeneratedFiles = append(plan.GeneratedFiles, PlannedGeneratedFile{Package: genFile.Package, Name: genFile.FileName})
+	}
+	for _, hook := range hooks {
+		if !matched[plannedHookKey(hook)] {
+			plan.UnmatchedHooks = append(plan.UnmatchedHooks, instrument.HookTarget(hook))
+		}
+	}
+	return plan, nil
+}
+
+// plannedHooks returns the hooks applying to a function, as MatchFunctionWithHooks combines
+// them: the Before/After functions of every matching hook, and the first Rewrite. The hooks
+// are recorded in matched.
+func plannedHooks(packageName string, fn *analyze.FunctionInfo, hooks []instrument.HookDefinition, matched map[string]bool) []PlannedHook {
+	var planned []PlannedHook
+	rewrite := false
+	for _, hook := range instrument.MatchingHooks(packageName, fn, hooks) {
+		matched[plannedHookKey(hook)] = true
+		applies := ""
+		if (hook.Type == "rewrite" || hook.Type == "both") && !rewrite {
+			rewrite = true
+			applies = "rewrite"
+		}
+		if hook.Type == "before_after" || hook.Type == "both" {
+			if applies == "rewrite" {
+				applies = "both"
+			} else {
+				applies = "before_after"
+			}
+		}
+		// Rewrites after the first one don't apply
+		if applies == "" {
+			continue
+		}
+		planned = append(planned, PlannedHook{Target: instrument.HookTarget(hook), Applies: applies, HooksFile: hook.HooksFile})
+	}
+	return planned
+}
+
+// plannedHookKey identifies a hook among those loaded, also when packageHooks keeps only its
+// Rewrite
+func plannedHookKey(hook instrument.HookDefinition) string {
+	return strings.Join([]string{hook.HooksFile, instrument.HookTarget(hook), hook.BeforeFunc, hook.AfterFunc, hook.RewriteFuncName}, "\x00")
+}
+
+// printInstrumentationPlan writes the plan as a table of the hooked functions by package and
+// file, followed by the generated files and the hooks matching nothing
+func printInstrumentationPlan(plan *InstrumentationPlan) {
+	if len(plan.Packages) == 0 {
+		report.Resultln("The hooks match no function of the build log: nothing would be instrumented.")
+	} else {
+		t := report.newTable("")
+		t.header("FUNCTION", "LINE", "HOOK", "APPLIES")
+		for _, pkg := range plan.Packages {
+			t.row(styled(styleCyan, pkg.Package))
+			for _, file := range pkg.Files {
+				t.row(styled(styleDim, "  "+file.File))
+				for _, fn := range file.Functions {
+					for i, hook := range fn.Hooks {
+						name, line := "", ""
+						if i == 0 {
+							name, line = "    "+fn.Function, fmt.Sprint(fn.Line)
+						}
+						t.row(cell{text: name}, cell{text: line}, cell{text: hook.Target}, cell{text: hook.Applies})
+					}
+				}
+				for _, structName := range file.Structs {
+					t.row(cell{text: "    type " + structName}, cell{}, cell{text: "struct modification"})
+				}
+			}
+		}
+		t.flush()
+	}
+
+	if len(plan.GeneratedFiles) > 0 {
+		report.Resultln("\nGenerated files:")
+		for _, g := range plan.GeneratedFiles {
+			report.Resultf("  + %s (%s)\n", g.Name, g.Package)
+		}
+	}
+	if len(plan.UnmatchedHooks) > 0 {
+		report.Resultln("\nHooks matching no function:")
+		for _, target := range plan.UnmatchedHooks {
+			report.Resultf("  %s\n", target)
+		}
+	}
+
+	functions, files := 0, 0
+	for _, pkg := range plan.Packages {
+		files += len(pkg.Files)
+		for _, file := range pkg.Files {
+			functions += len(file.Functions)
+		}
+	}
+	report.Resultf("\nPlan: %d function(s) in %d file(s) of %d package(s) instrumented, %d file(s) generated; nothing was built\n",
+		functions, files, len(plan.Packages), len(plan.GeneratedFiles))
+}
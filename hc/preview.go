@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// PreviewFileDiff is a source file changed by instrumentation, with a unified diff against the original
+type PreviewFileDiff struct {
+	Package string `json:"package"`
+	File    string `json:"file"` // Original source file path
+	Diff    string `json:"diff"`
+}
+
+// PreviewGeneratedFile is a file that instrumentation adds to a package
+type PreviewGeneratedFile struct {
+	Package string `json:"package"`
+	Name    string `json:"name"`
+	Content string `json:"content"`
+}
+
+// InstrumentationPreview is the result of running the instrumentation pipeline without building
+type InstrumentationPreview struct {
+	HooksFiles      []string               `json:"hooksFiles"`
+	HooksImportPath string                 `json:"hooksImportPath"`
+	Files           []PreviewFileDiff      `json:"files"`
+	GeneratedFiles  []PreviewGeneratedFile `json:"generatedFiles"`
+}
+
+// previewInstrumentation instruments matching files into a temporary directory, diffs them
+// against the originals and writes the result to build-metadata/instrumentation-preview.json.
+// Nothing is compiled and the WORK directory is left untouched.
+func previewInstrumentation(commands []parse.Command, hooksFiles []string) error {
+	if len(hooksFiles) == 0 {
+		return fmt.Errorf("no hooks files provided")
+	}
+
+	hooks, structMods, generatedFiles, err := loadHooksFiles(hooksFiles)
+	if err != nil {
+		return err
+	}
+
+	hooksImportPath, err := instrument.GetHooksImportPath(hooksFiles[0])
+	if err != nil {
+		report.Warnf("could not determine hooks import path: %v\n", err)
+		hooksImportPath = "generated_hooks"
+	}
+
+	previewDir, err := os.MkdirTemp("", "hc-preview")
+	if err != nil {
+		return fmt.Errorf("failed to create preview directory: %w", err)
+	}
+	defer os.RemoveAll(previewDir)
+
+	preview := &InstrumentationPreview{
+		HooksFiles:      hooksFiles,
+		HooksImportPath: hooksImportPath,
+		Files:           []PreviewFileDiff{},
+		GeneratedFiles:  []PreviewGeneratedFile{},
+	}
+	seenFiles := make(map[string]bool)
+	seenStructMods := make(map[string]bool)
+	needsRuntime := false
+	cgoSources := parse.NewCgoSources(commands)
+	packagePaths := parse.NewPackagePaths(commands)
+
+	for cmdIdx, cmd := range commands {
+		if !parse.IsCompileCommand(&cmd) {
+			continue
+		}
+		packageName := parse.ExtractPackageName(&cmd)
+		files := cgoSources.SourceFiles(&cmd)
+		if packageName == "" || len(files) == 0 {
+			continue
+		}
+		pkgHooks := instrument.ResolveMainPackage(hooks, packageName, packagePaths.ImportPath(&cmd))
+
+		// Each package gets its own directory, like $WORK/bXXX in a real build
+		packageDir := filepath.Join(previewDir, fmt.Sprintf("b%03d", cmdIdx))
+		if err := os.MkdirAll(packageDir, 0755); err != nil {
+			return fmt.Errorf("failed to create preview directory: %w", err)
+		}
+
+		for _, file := range files {
+			if !strings.HasSuffix(file, ".go") || seenFiles[packageName+":"+file] {
+				continue
+			}
+
+			functions, err := analyze.ExtractFunctionsFromGoFile(file)
+			if err != nil {
+				continue
+			}
+			hasMatches := false
+			for _, fn := range functions {
+				if instrument.MatchFunctionWithHooks(packageName, &fn, pkgHooks) != nil {
+					hasMatches = true
+					break
+				}
+			}
+			if !hasMatches {
+				continue
+			}
+			seenFiles[packageName+":"+file] = true
+
+			targetFile := filepath.Join(packageDir, filepath.Base(file))
+			if err := instrumentFile(file, targetFile, packageName, pkgHooks, hooksImportPath); err != nil {
+				report.Warnf("failed to instrument %s: %v\n", file, err)
+				continue
+			}
+			if diff, err := diffFiles(file, targetFile); err != nil {
+				report.Warnf("failed to diff %s: %v\n", file, err)
+			} else if diff != "" {
+				preview.Files = append(preview.Files, PreviewFileDiff{Package: packageName, File: file, Diff: diff})
+			}
+		}
+
+		// Trampolines are generated next to the instrumented files
+		trampolinesFile := filepath.Join(packageDir, "otel_trampolines.go")
+		if content, err := os.ReadFile(trampolinesFile); err == nil {
+			preview.GeneratedFiles = append(preview.GeneratedFiles, PreviewGeneratedFile{
+				Package: packageName,
+				Name:    filepath.Base(trampolinesFile),
+				Content: string(content),
+			})
+			needsRuntime = true
+		}
+
+		for _, mod := range structMods {
+			modKey := mod.Package + ":" + mod.StructName
+			if mod.Package != packageName || seenStructMods[modKey] {
+				continue
+			}
+			structFile, err := findStructDefinitionFile(files, mod.StructName)
+			if err != nil {
+				continue
+			}
+			seenStructMods[modKey] = true
+
+			targetFile := filepath.Join(packageDir, filepath.Base(structFile))
+			// Apply on top of an already instrumented copy if the file also has hooks
+			sourceFile := structFile
+			if _, err := os.Stat(targetFile); err == nil {
+				sourceFile = targetFile
+			}
+			if err := applyStructModification(structFile, sourceFile, targetFile, mod); err != nil {
+				report.Warnf("failed to apply struct modification %s: %v\n", modKey, err)
+				continue
+			}
+			diff, err := diffFiles(structFile, targetFile)
+			if err != nil {
+				continue
+			}
+			replaced := false
+			for i := range preview.Files {
+				if preview.Files[i].File == structFile {
+					preview.Files[i].Diff = diff
+					replaced = true
+				}
+			}
+			if !replaced {
+				preview.Files = append(preview.Files, PreviewFileDiff{Package: packageName, File: structFile, Diff: diff})
+			}
+		}
+	}
+
+	for _, genFile := range generatedFiles {
+		preview.GeneratedFiles = append(preview.GeneratedFiles, PreviewGeneratedFile{
+			Package: genFile.Package,
+			Name:    genFile.FileName,
+			Content: genFile.Content,
+		})
+	}
+
+	if needsRuntime {
+		runtimeFile, err := generateOtelRuntimeFile(previewDir, hooksImportPath, hooks)
+		if err != nil {
+			report.Warnf("failed to generate otel.runtime.go: %v\n", err)
+		} else if content, err := os.ReadFile(runtimeFile); err == nil {
+			preview.GeneratedFiles = append(preview.GeneratedFiles, PreviewGeneratedFile{
+				Package: "main",
+				Name:    filepath.Base(runtimeFile),
+				Content: string(content),
+			})
+		}
+	}
+
+	if err := EnsureMetadataDir(); err != nil {
+		return fmt.Errorf("failed to create metadata directory: %w", err)
+	}
+	data, err := json.MarshalIndent(preview, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode preview: %w", err)
+	}
+	previewPath := GetMetadataPath(InstrumentationPreviewFile)
+	if err := os.WriteFile(previewPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", previewPath, err)
+	}
+	linkRunFile(InstrumentationPreviewFile)
+
+	report.Printf("\n✅ Preview: %d instrumented files, %d generated files\n", len(preview.Files), len(preview.GeneratedFiles))
+	for _, f := range preview.Files {
+		report.Printf("  ~ %s (%s)\n", f.File, f.Package)
+	}
+	for _, g := range preview.GeneratedFiles {
+		report.Printf("  + %s (%s)\n", g.Name, g.Package)
+	}
+	report.Printf("Written to %s\n", previewPath)
+	return nil
+}
+
+// diffFiles returns a unified diff between an original and an instrumented file
+func diffFiles(originalFile, instrumentedFile string) (string, error) {
+	original, err := os.ReadFile(originalFile)
+	if err != nil {
+		return "", err
+	}
+	instrumented, err := os.ReadFile(instrumentedFile)
+	if err != nil {
+		return "", err
+	}
+	return unifiedDiff(originalFile, originalFile+" (instrumented)", string(original), string(instrumented)), nil
+}
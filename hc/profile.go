@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// Phases of a build profile
+const (
+	PhaseCapture    = "capture"    // go build -x run by --capture, --json and --compile
+	PhaseInstrument = "instrument" // Hooks processing of --compile, up to the replay
+	PhaseReplay     = "replay"     // Replay of a build log by --execute and --compile
+)
+
+// BuildProfile is build-metadata/build-profile.json: when the phases of the last build ran.
+// A parallel replay (-j 2 or more) also records each of its actions, so the compile time of
+// every package and the link show up on the timeline of the web UI.
+type BuildProfile struct {
+	Entries []ProfileEntry `json:"entries"`
+}
+
+// ProfileEntry is a phase, or an action replayed during the replay phase
+type ProfileEntry struct {
+	Phase   string    `json:"phase"`
+	Action  string    `json:"action,omitempty"`  // Directory of the action in $WORK, empty for the phase itself
+	Kind    string    `json:"kind,omitempty"`    // "compile" or "link" for actions running the compiler or the linker
+	Package string    `json:"package,omitempty"` // Package compiled by compile actions
+	Worker  string    `json:"worker,omitempty"`  // Worker that replayed the action, empty when replayed locally
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Failed  bool      `json:"failed,omitempty"`
+}
+
+// profiled are the entries recorded by this run of hc, written by saveBuildProfile
+var profiled []ProfileEntry
+
+// instrumentStart is when --compile started processing hooks, zero in other modes
+var instrumentStart time.Time
+
+// recordPhase records a phase that ran from start until now
+func recordPhase(phase string, start time.Time, err error) {
+	profiled = append(profiled, ProfileEntry{Phase: phase, Start: start, End: time.Now(), Failed: err != nil})
+}
+
+// recordReplay records the replay phase that ran from start until now, with the actions of
+// the parser's parallel replay
+func recordReplay(parser *parse.Parser, start time.Time, err error) {
+	recordPhase(PhaseReplay, start, err)
+	for _, timing := range parser.Timings() {
+		profiled = append(profiled, ProfileEntry{
+			Phase:   PhaseReplay,
+			Action:  timing.Action,
+			Kind:    timing.Kind,
+			Package: timing.Package,
+			Worker:  timing.Worker,
+			Start:   timing.Start,
+			End:     timing.End,
+		})
+	}
+}
+
+// saveBuildProfile writes the entries recorded by this run to the build profile. They replace
+// the entries of the same phases recorded by earlier runs; a capture replaces all of them,
+// since it starts a new build.
+func saveBuildProfile() {
+	if len(profiled) == 0 {
+		return
+	}
+	path := GetMetadataPath(BuildProfileFile)
+	recorded := make(map[string]bool)
+	for _, entry := range profiled {
+		recorded[entry.Phase] = true
+	}
+
+	profile := BuildProfile{}
+	if data, err := os.ReadFile(path); err == nil && !recorded[PhaseCapture] {
+		var earlier BuildProfile
+		if err := json.Unmarshal(data, &earlier); err == nil {
+			for _, entry := range earlier.Entries {
+				if !recorded[entry.Phase] {
+					profile.Entries = append(profile.Entries, entry)
+				}
+			}
+		}
+	}
+	profile.Entries = append(profile.Entries, profiled...)
+	sort.SliceStable(profile.Entries, func(i, j int) bool {
+		return profile.Entries[i].Start.Before(profile.Entries[j].Start)
+	})
+
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path, append(data, '\n'), 0644)
+	}
+	if err != nil {
+		report.Warnf("failed to write the build profile: %v\n", err)
+	}
+}
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"go/version"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// RegistryFormatVersion is the registry layout version understood by hc.
+// Registries with a newer format are rejected.
+const RegistryFormatVersion = 1
+
+// DefaultRegistry is the registry read by --list-instrumentations and --add-instrumentation
+// when --registry is not given
+const DefaultRegistry = "instrumentations/registry.json"
+
+// registryFetchTimeout bounds downloading a registry or bundle over HTTP
+const registryFetchTimeout = 30 * time.Second
+
+// Registry lists instrumentations that can be installed with --add-instrumentation
+type Registry struct {
+	FormatVersion    int             `json:"formatVersion"`
+	Instrumentations []RegistryEntry `json:"instrumentations"`
+	location         string          // File path or URL the registry was read from
+	byName           map[string]int  // Index of each entry in Instrumentations
+}
+
+// RegistryEntry is an instrumentation offered by a registry. It is installed either from a
+// hooks bundle (Bundle) or, for registries on disk, from hooks files next to the registry
+// (HooksFiles), which are bundled on the fly.
+type RegistryEntry struct {
+	Name          string        `json:"name"`
+	Description   string        `json:"description"`
+	Version       string        `json:"version"`
+	Bundle        string        `json:"bundle,omitempty"`     // Bundle path or URL, relative to the registry
+	SHA256        string        `json:"sha256,omitempty"`     // Expected checksum of Bundle
+	HooksFiles    []string      `json:"hooksFiles,omitempty"` // Hooks files, relative to the registry
+	Packages      []string      `json:"packages,omitempty"`   // Packages it instruments, which --with selects it by
+	Compatibility Compatibility `json:"compatibility"`
+}
+
+// Compatibility describes where an instrumentation is known to work
+type Compatibility struct {
+	MinGo    string   `json:"minGo,omitempty"`    // Oldest supported Go release, e.g. "1.24"
+	MaxGo    string   `json:"maxGo,omitempty"`    // Newest supported Go release
+	Backends []string `json:"backends,omitempty"` // Supported code generation backends (empty: all)
+	Requires []string `json:"requires,omitempty"` // Instrumentations that must be installed too
+}
+
+// isURL reports whether a registry or bundle location is fetched over HTTP
+func isURL(location string) bool {
+	return strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://")
+}
+
+// fetchLocation reads a local file or downloads a URL
+func fetchLocation(location string) ([]byte, error) {
+	if !isURL(location) {
+		return os.ReadFile(location)
+	}
+
+	client := &http.Client{Timeout: registryFetchTimeout}
+	resp, err := client.Get(location)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", location, resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// LoadRegistry reads a registry from a file or URL
+func LoadRegistry(location string) (*Registry, error) {
+	data, err := fetchLocation(location)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read registry: %w", err)
+	}
+
+	registry := &Registry{}
+	if err := json.Unmarshal(data, registry); err != nil {
+		return nil, fmt.Errorf("failed to parse registry %s: %w", location, err)
+	}
+	if registry.FormatVersion > RegistryFormatVersion {
+		return nil, fmt.Errorf("registry format version %d is newer than supported version %d, upgrade hc",
+			registry.FormatVersion, RegistryFormatVersion)
+	}
+
+	registry.location = location
+	registry.byName = make(map[string]int)
+	for i, entry := range registry.Instrumentations {
+		if !isPlainFileName(entry.Name) {
+			return nil, fmt.Errorf("registry entry %d has invalid name %q", i+1, entry.Name)
+		}
+		if _, exists := registry.byName[entry.Name]; exists {
+			return nil, fmt.Errorf("registry lists %s more than once", entry.Name)
+		}
+		if (entry.Bundle == "") == (len(entry.HooksFiles) == 0) {
+			return nil, fmt.Errorf("registry entry %s needs either a bundle or hooks files", entry.Name)
+		}
+		registry.byName[entry.Name] = i
+	}
+	return registry, nil
+}
+
+// Lookup returns the entry with the given name
+func (r *Registry) Lookup(name string) (*RegistryEntry, error) {
+	i, exists := r.byName[name]
+	if !exists {
+		return nil, fmt.Errorf("%s is not in registry %s", name, r.location)
+	}
+	return &r.Instrumentations[i], nil
+}
+
+// LookupPackage returns the entry --with selects: the one instrumenting the given package, such
+// as net/http, or else the one with the given name
+func (r *Registry) LookupPackage(name string) (*RegistryEntry, error) {
+	for i, entry := range r.Instrumentations {
+		for _, pkg := range entry.Packages {
+			if pkg == name {
+				return &r.Instrumentations[i], nil
+			}
+		}
+	}
+	if _, exists := r.byName[name]; !exists {
+		return nil, fmt.Errorf("no instrumentation of %s in registry %s", name, r.location)
+	}
+	return r.Lookup(name)
+}
+
+// resolve returns a location listed in the registry relative to the registry itself
+func (r *Registry) resolve(ref string) (string, error) {
+	if isURL(ref) {
+		return ref, nil
+	}
+	if isURL(r.location) {
+		base, err := url.Parse(r.location)
+		if err != nil {
+			return "", fmt.Errorf("invalid registry URL: %w", err)
+		}
+		refURL, err := url.Parse(ref)
+		if err != nil {
+			return "", fmt.Errorf("invalid location %q: %w", ref, err)
+		}
+		return base.ResolveReference(refURL).String(), nil
+	}
+	if filepath.IsAbs(ref) {
+		return ref, nil
+	}
+	return filepath.Join(filepath.Dir(r.location), filepath.FromSlash(ref)), nil
+}
+
+// localGoVersion returns the version of the go command used for builds, e.g. "go1.24.4",
+// falling back to the version hc was built with
+func localGoVersion() string {
+	out, err := exec.Command(goBinary, "env", "GOVERSION").Output()
+	if err == nil {
+		if goVersion := strings.TrimSpace(string(out)); version.IsValid(goVersion) {
+			return goVersion
+		}
+	}
+	return runtime.Version()
+}
+
+// CheckCompatibility returns the reasons an instrumentation can't be used with the given Go
+// version and code generation backend, or nil if it can
+func (e *RegistryEntry) CheckCompatibility(goVersion string, backend string) []string {
+	var problems []string
+	compat := e.Compatibility
+	if compat.MinGo != "" && version.Compare(goVersion, "go"+compat.MinGo) < 0 {
+		problems = append(problems, fmt.Sprintf("needs Go %s or newer (have %s)", compat.MinGo, goVersion))
+	}
+	// A release like "1.24" covers all its patch releases
+	if compat.MaxGo != "" && version.Compare(version.Lang(goVersion), "go"+compat.MaxGo) > 0 {
+		problems = append(problems, fmt.Sprintf("supports Go up to %s (have %s)", compat.MaxGo, goVersion))
+	}
+	if len(compat.Backends) > 0 {
+		supported := false
+		for _, b := range compat.Backends {
+			if b == backend {
+				supported = true
+			}
+		}
+		if !supported {
+			problems = append(problems, fmt.Sprintf("supports the %s backend(s), not %s", strings.Join(compat.Backends, ", "), backend))
+		}
+	}
+	return problems
+}
+
+// installedVersion returns the version of an instrumentation installed in hooksDir from its
+// bundle manifest, "unknown" when it was installed otherwise, or "" when it isn't installed
+func installedVersion(hooksDir string, name string) string {
+	dir := filepath.Join(hooksDir, name)
+	if _, err := os.Stat(dir); err != nil {
+		return ""
+	}
+	data, err := os.ReadFile(filepath.Join(dir, InstalledManifestFile))
+	if err != nil {
+		return "unknown"
+	}
+	var manifest HooksBundleManifest
+	if err := json.Unmarshal(data, &manifest); err != nil || manifest.Version == "" {
+		return "unknown"
+	}
+	return manifest.Version
+}
+
+// listInstrumentations prints the instrumentations of a registry with their compatibility
+// with the local toolchain and whether they are installed in hooksDir
+func listInstrumentations(registry *Registry, hooksDir string, backend string) {
+	goVersion := localGoVersion()
+	report.Printf("Registry: %s\n", registry.location)
+	report.Printf("Go: %s, backend: %s\n\n", goVersion, backend)
+
+	if len(registry.Instrumentations) == 0 {
+		report.Println("No instrumentations found in registry.")
+		return
+	}
+
+	for _, entry := range registry.Instrumentations {
+		status := "❌ incompatible"
+		problems := entry.CheckCompatibility(goVersion, backend)
+		if len(problems) == 0 {
+			status = "✅ compatible"
+		}
+		report.Resultf("📦 %s %s  %s\n", entry.Name, entry.Version, status)
+		if entry.Description != "" {
+			report.Resultf("   %s\n", entry.Description)
+		}
+		for _, problem := range problems {
+			report.Resultf("   ⚠️  %s\n", problem)
+		}
+
+		var compat []string
+		if entry.Compatibility.MinGo != "" || entry.Compatibility.MaxGo != "" {
+			compat = append(compat, fmt.Sprintf("Go %s..%s", entry.Compatibility.MinGo, entry.Compatibility.MaxGo))
+		}
+		if len(entry.Compatibility.Backends) > 0 {
+			compat = append(compat, "backends: "+strings.Join(entry.Compatibility.Backends, ", "))
+		}
+		if len(entry.Compatibility.Requires) > 0 {
+			compat = append(compat, "requires: "+strings.Join(entry.Compatibility.Requires, ", "))
+		}
+		if len(compat) > 0 {
+			report.Resultf("   %s\n", strings.Join(compat, "; "))
+		}
+
+		if installed := installedVersion(hooksDir, entry.Name); installed != "" {
+			report.Resultf("   Installed: %s (%s)\n", filepath.Join(hooksDir, entry.Name), installed)
+		}
+		report.Resultln()
+	}
+	report.Println("Install with: hc --add-instrumentation <name>")
+}
+
+// InstalledInstrumentation is an instrumentation installed by addInstrumentation
+type InstalledInstrumentation struct {
+	Name       string
+	Version    string
+	Dir        string
+	HooksFiles []string
+}
+
+// addInstrumentation installs an instrumentation and the ones it requires into hooksDir.
+// Required instrumentations that are already installed are left alone.
+func addInstrumentation(registry *Registry, name string, hooksDir string, backend string) ([]InstalledInstrumentation, error) {
+	var installed []InstalledInstrumentation
+	visiting := make(map[string]bool)
+	goVersion := localGoVersion()
+
+	var add func(name string, required bool) error
+	add = func(name string, required bool) error {
+		if visiting[name] {
+			return fmt.Errorf("circular requirement on %s", name)
+		}
+		visiting[name] = true
+		defer delete(visiting, name)
+
+		entry, err := registry.Lookup(name)
+		if err != nil {
+			return err
+		}
+		if problems := entry.CheckCompatibility(goVersion, backend); len(problems) > 0 {
+			return fmt.Errorf("%s %s is not compatible: %s", entry.Name, entry.Version, strings.Join(problems, "; "))
+		}
+		for _, requirement := range entry.Compatibility.Requires {
+			if installedVersion(hooksDir, requirement) != "" {
+				continue
+			}
+			if err := add(requirement, true); err != nil {
+				return fmt.Errorf("%s requires %s: %w", entry.Name, requirement, err)
+			}
+		}
+		if required && installedVersion(hooksDir, name) != "" {
+			return nil
+		}
+
+		result, err := installRegistryEntry(registry, entry, hooksDir)
+		if err != nil {
+			return fmt.Errorf("failed to install %s: %w", entry.Name, err)
+		}
+		installed = append(installed, *result)
+		return nil
+	}
+
+	if err := add(name, false); err != nil {
+		return installed, err
+	}
+	return installed, nil
+}
+
+// withRegistry returns the registry --with reads: the registry given, or, when it is the
+// default one and the project has none, the registry of the hc source tree hc was built in
+func withRegistry(location string) string {
+	if location != DefaultRegistry {
+		return location
+	}
+	if _, err := os.Stat(location); err == nil {
+		return location
+	}
+	execPath, err := os.Executable()
+	if err != nil {
+		return location
+	}
+	for dir := filepath.Dir(execPath); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		candidate := filepath.Join(dir, filepath.FromSlash(DefaultRegistry))
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return location
+}
+
+// withHooksFiles returns the hooks files of the instrumentations --with selects and of those
+// they require, the required ones first. Instrumentations missing from hooksDir are installed
+// from the registry first; installed ones are used as they are.
+func withHooksFiles(registry *Registry, names []string, hooksDir string, backend string) ([]string, error) {
+	var hooksFiles []string
+	added := make(map[string]bool)
+
+	var add func(entry *RegistryEntry) error
+	add = func(entry *RegistryEntry) error {
+		if added[entry.Name] {
+			return nil
+		}
+		added[entry.Name] = true
+		for _, requirement := range entry.Compatibility.Requires {
+			required, err := registry.Lookup(requirement)
+			if err != nil {
+				return fmt.Errorf("%s requires %s: %w", entry.Name, requirement, err)
+			}
+			if err := add(required); err != nil {
+				return err
+			}
+		}
+		files, err := installedHooksFiles(hooksDir, entry)
+		if err != nil {
+			return err
+		}
+		hooksFiles = append(hooksFiles, files...)
+		return nil
+	}
+
+	for _, name := range names {
+		entry, err := registry.LookupPackage(name)
+		if err != nil {
+			return nil, err
+		}
+		if installedVersion(hooksDir, entry.Name) == "" {
+			installed, err := addInstrumentation(registry, entry.Name, hooksDir, backend)
+			for _, inst := range installed {
+				report.Printf("✅ Installed %s %s into %s\n", inst.Name, inst.Version, inst.Dir)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to add instrumentation: %w", err)
+			}
+		}
+		if err := add(entry); err != nil {
+			return nil, err
+		}
+	}
+	return hooksFiles, nil
+}
+
+// installedHooksFiles returns the hooks files of an instrumentation installed in hooksDir, as
+// listed by its bundle manifest, or else as named by the registry
+func installedHooksFiles(hooksDir string, entry *RegistryEntry) ([]string, error) {
+	dir := filepath.Join(hooksDir, entry.Name)
+	names := make([]string, len(entry.HooksFiles))
+	for i, hooksFile := range entry.HooksFiles {
+		names[i] = filepath.Base(filepath.FromSlash(hooksFile))
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, InstalledManifestFile)); err == nil {
+		var manifest HooksBundleManifest
+		if err := json.Unmarshal(data, &manifest); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filepath.Join(dir, InstalledManifestFile), err)
+		}
+		names = manifest.HooksFiles
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s has no hooks files, remove it to reinstall %s", dir, entry.Name)
+	}
+
+	hooksFiles := make([]string, len(names))
+	for i, name := range names {
+		hooksFiles[i] = filepath.Join(dir, name)
+		if _, err := os.Stat(hooksFiles[i]); err != nil {
+			return nil, fmt.Errorf("hooks file of %s not installed: %w", entry.Name, err)
+		}
+	}
+	return hooksFiles, nil
+}
+
+// installRegistryEntry fetches the bundle of an entry, or bundles its hooks files, and
+// installs it into hooksDir
+func installRegistryEntry(registry *Registry, entry *RegistryEntry, hooksDir string) (*InstalledInstrumentation, error) {
+	tmpDir, err := os.MkdirTemp("", "hc-registry-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temp directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+	bundlePath := filepath.Join(tmpDir, entry.Name+".tar.gz")
+
+	if entry.Bundle != "" {
+		location, err := registry.resolve(entry.Bundle)
+		if err != nil {
+			return nil, err
+		}
+		data, err := fetchLocation(location)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch bundle: %w", err)
+		}
+		if entry.SHA256 != "" {
+			sum := sha256.Sum256(data)
+			if hex.EncodeToString(sum[:]) != entry.SHA256 {
+				return nil, fmt.Errorf("checksum mismatch for bundle %s", location)
+			}
+		}
+		if err := os.WriteFile(bundlePath, data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to write bundle: %w", err)
+		}
+	} else {
+		if isURL(registry.location) {
+			return nil, fmt.Errorf("hooks files can only be installed from a registry on disk")
+		}
+		var hooksFiles []string
+		for _, hooksFile := range entry.HooksFiles {
+			path, err := registry.resolve(hooksFile)
+			if err != nil {
+				return nil, err
+			}
+			hooksFiles = append(hooksFiles, path)
+		}
+		if _, err := exportHooksBundle(bundlePath, hooksFiles, entry.Version); err != nil {
+			return nil, err
+		}
+	}
+
+	manifest, hooksFiles, err := importHooksBundle(bundlePath, hooksDir)
+	if err != nil {
+		return nil, err
+	}
+	return &InstalledInstrumentation{
+		Name:       manifest.Name,
+		Version:    manifest.Version,
+		Dir:        filepath.Join(hooksDir, manifest.Name),
+		HooksFiles: hooksFiles,
+	}, nil
+}
@@ -0,0 +1,415 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// remoteCacheTimeout bounds every request to an HTTP remote cache
+const remoteCacheTimeout = 60 * time.Second
+
+// remoteCacheConcurrency is the number of entries downloaded or uploaded at a time
+const remoteCacheConcurrency = 8
+
+// RemoteCacheTokenEnv names the environment variable holding a bearer token for HTTP remote
+// caches
+const RemoteCacheTokenEnv = "HC_REMOTE_CACHE_TOKEN"
+
+// remoteCache stores the entries of the build cache where other machines can reuse them. An
+// entry is a gzip-compressed tar of the outputs of one compile command, named by its key.
+type remoteCache interface {
+	// get writes the entry of key to w and reports whether it exists
+	get(key string, w io.Writer) (bool, error)
+	// put stores the entry of key
+	put(key string, data []byte) error
+	// String returns the location of the cache, for messages
+	String() string
+}
+
+// remoteBuildCache is the remote cache of compile mode (--remote-cache), nil when not set
+var remoteBuildCache remoteCache
+
+// remoteCacheReadOnly keeps compile mode from uploading entries (--remote-cache-read-only)
+var remoteCacheReadOnly bool
+
+// SetRemoteCache sets the remote cache compile mode shares archives through: a directory, an
+// http(s):// URL or an s3://bucket/prefix location. An empty location disables it.
+func SetRemoteCache(location string, readOnly bool) error {
+	remoteCacheReadOnly = readOnly
+	if location == "" {
+		remoteBuildCache = nil
+		return nil
+	}
+	cache, err := newRemoteCache(location)
+	if err != nil {
+		return err
+	}
+	remoteBuildCache = cache
+	return nil
+}
+
+// newRemoteCache returns the remote cache of a location
+func newRemoteCache(location string) (remoteCache, error) {
+	switch {
+	case strings.HasPrefix(location, "http://") || strings.HasPrefix(location, "https://"):
+		u, err := url.Parse(location)
+		if err != nil {
+			return nil, fmt.Errorf("invalid remote cache URL %s: %w", location, err)
+		}
+		return &httpCache{base: u, client: &http.Client{Timeout: remoteCacheTimeout}}, nil
+	case strings.HasPrefix(location, "s3://"):
+		bucket := strings.TrimSuffix(strings.TrimPrefix(location, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("invalid remote cache %s: missing bucket", location)
+		}
+		if _, err := exec.LookPath("aws"); err != nil {
+			return nil, fmt.Errorf("remote cache %s needs the aws CLI: %w", location, err)
+		}
+		return &s3Cache{location: "s3://" + bucket}, nil
+	case strings.Contains(location, "://") && !strings.HasPrefix(location, "file://"):
+		return nil, fmt.Errorf("unsupported remote cache %s: use a directory, http(s):// or s3://", location)
+	}
+	dir, err := filepath.Abs(strings.TrimPrefix(location, "file://"))
+	if err != nil {
+		return nil, err
+	}
+	return &dirCache{dir: dir}, nil
+}
+
+// dirCache is a remote cache in a directory, such as a shared volume of CI agents
+type dirCache struct {
+	dir string
+}
+
+func (c *dirCache) get(key string, w io.Writer) (bool, error) {
+	file, err := os.Open(filepath.Join(c.dir, key+".tar.gz"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+	_, err = io.Copy(w, file)
+	return err == nil, err
+}
+
+func (c *dirCache) put(key string, data []byte) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	// Written under a temporary name, so concurrent builds never read a partial entry
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(c.dir, key+".tar.gz"))
+}
+
+func (c *dirCache) String() string {
+	return c.dir
+}
+
+// httpCache is a remote cache served over HTTP: entries are read with GET and stored with PUT
+// at <base>/<key>.tar.gz, as by the HTTP caches of Bazel, sccache or nginx with WebDAV.
+// Credentials come from the URL (basic auth) or HC_REMOTE_CACHE_TOKEN (bearer token).
+type httpCache struct {
+	base   *url.URL
+	client *http.Client
+}
+
+// request returns a request for the entry of key
+func (c *httpCache) request(method, key string, body io.Reader) (*http.Request, error) {
+	u := *c.base
+	u.User = nil
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key + ".tar.gz"
+	req, err := http.NewRequest(method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if c.base.User != nil {
+		password, _ := c.base.User.Password()
+		req.SetBasicAuth(c.base.User.Username(), password)
+	} else if token := os.Getenv(RemoteCacheTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+func (c *httpCache) get(key string, w io.Writer) (bool, error) {
+	req, err := c.request(http.MethodGet, key, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("GET %s: %s", req.URL, resp.Status)
+	}
+	_, err = io.Copy(w, resp.Body)
+	return err == nil, err
+}
+
+func (c *httpCache) put(key string, data []byte) error {
+	req, err := c.request(http.MethodPut, key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/gzip")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("PUT %s: %s", req.URL, resp.Status)
+	}
+	return nil
+}
+
+func (c *httpCache) String() string {
+	u := *c.base
+	u.User = nil
+	return u.String()
+}
+
+// s3Cache is a remote cache in an S3 bucket, accessed with the aws CLI and its usual
+// credentials (environment, profile or instance role)
+type s3Cache struct {
+	location string // s3://bucket/prefix
+}
+
+func (c *s3Cache) object(key string) string {
+	return c.location + "/" + key + ".tar.gz"
+}
+
+func (c *s3Cache) get(key string, w io.Writer) (bool, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("aws", "s3", "cp", "--only-show-errors", c.object(key), "-")
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := stderr.String()
+		if strings.Contains(message, "404") || strings.Contains(message, "Not Found") || strings.Contains(message, "NoSuchKey") {
+			return false, nil
+		}
+		return false, fmt.Errorf("aws s3 cp %s: %v: %s", c.object(key), err, strings.TrimSpace(message))
+	}
+	return true, nil
+}
+
+func (c *s3Cache) put(key string, data []byte) error {
+	var stderr bytes.Buffer
+	cmd := exec.Command("aws", "s3", "cp", "--only-show-errors", "-", c.object(key))
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("aws s3 cp %s: %v: %s", c.object(key), err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func (c *s3Cache) String() string {
+	return c.location
+}
+
+// remoteCacheStats counts what the remote cache contributed to a build
+type remoteCacheStats struct {
+	Hits       int   // Entries downloaded
+	Misses     int   // Entries missing locally and remotely
+	Uploads    int   // Entries uploaded after the build
+	Errors     int   // Failed downloads and uploads; the first one stops the others
+	Downloaded int64 // Bytes downloaded
+	Uploaded   int64 // Bytes uploaded
+}
+
+// fetchRemoteEntries downloads the entries of keys missing from the local cache directory and
+// extracts them into it, several at a time. It returns the keys that were found. After an
+// error, such as a rejected token, the remaining entries are not requested.
+func fetchRemoteEntries(cache remoteCache, dir string, keys []string, stats *remoteCacheStats) map[string]bool {
+	found := make(map[string]bool)
+	var mu sync.Mutex
+	forEachConcurrently(keys, func(key string) {
+		if stats.failed(&mu) {
+			return
+		}
+		var buf bytes.Buffer
+		ok, err := cache.get(key, &buf)
+		if err == nil && ok {
+			err = extractCacheEntry(buf.Bytes(), filepath.Join(dir, key))
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch {
+		case err != nil:
+			stats.fail(cache, err)
+		case ok:
+			stats.Hits++
+			stats.Downloaded += int64(buf.Len())
+			found[key] = true
+		default:
+			stats.Misses++
+		}
+	})
+	return found
+}
+
+// uploadCacheEntries uploads the local entries of keys to the remote cache, several at a time.
+// Entries that were not stored, because their command failed, are skipped, and so are all
+// entries after an error.
+func uploadCacheEntries(cache remoteCache, dir string, keys []string, stats *remoteCacheStats) {
+	var mu sync.Mutex
+	forEachConcurrently(keys, func(key string) {
+		if stats.failed(&mu) {
+			return
+		}
+		data, err := packCacheEntry(filepath.Join(dir, key))
+		if os.IsNotExist(err) {
+			return
+		}
+		if err == nil {
+			err = cache.put(key, data)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil {
+			stats.fail(cache, err)
+			return
+		}
+		stats.Uploads++
+		stats.Uploaded += int64(len(data))
+	})
+}
+
+// failed reports whether a download or upload failed, holding mu
+func (s *remoteCacheStats) failed(mu *sync.Mutex) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return s.Errors > 0
+}
+
+// fail records an error, warning about the first one only
+func (s *remoteCacheStats) fail(cache remoteCache, err error) {
+	if s.Errors == 0 {
+		report.Warnf("remote cache %s unavailable, continuing without it: %v\n", cache, err)
+	}
+	s.Errors++
+}
+
+// forEachConcurrently calls fn for every key, remoteCacheConcurrency at a time
+func forEachConcurrently(keys []string, fn func(string)) {
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, remoteCacheConcurrency)
+	for _, key := range keys {
+		wg.Add(1)
+		slots <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			fn(key)
+		}()
+	}
+	wg.Wait()
+}
+
+// packCacheEntry returns the files of a local cache entry as a gzip-compressed tar
+func packCacheEntry(entry string) ([]byte, error) {
+	files, err := os.ReadDir(entry)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	for _, file := range files {
+		if !file.Type().IsRegular() {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(entry, file.Name()))
+		if err != nil {
+			return nil, err
+		}
+		header := &tar.Header{Name: file.Name(), Mode: 0644, Size: int64(len(content))}
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// extractCacheEntry writes the files of a downloaded entry to a local cache entry, replacing it
+// at once so builds never see a partial entry
+func extractCacheEntry(data []byte, entry string) error {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("invalid cache entry %s: %w", filepath.Base(entry), err)
+	}
+	tmp := entry + ".download"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("invalid cache entry %s: %w", filepath.Base(entry), err)
+		}
+		if header.Typeflag != tar.TypeReg || !isPlainFileName(header.Name) {
+			return fmt.Errorf("unexpected file %s in cache entry %s", header.Name, filepath.Base(entry))
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return fmt.Errorf("invalid cache entry %s: %w", filepath.Base(entry), err)
+		}
+		if err := os.WriteFile(filepath.Join(tmp, header.Name), content, 0644); err != nil {
+			return err
+		}
+	}
+	if err := os.RemoveAll(entry); err != nil {
+		return err
+	}
+	return os.Rename(tmp, entry)
+}
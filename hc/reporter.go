@@ -0,0 +1,232 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pdelewski/go-build-interceptor/hc/logging"
+)
+
+// Reporter is where hc writes its output. Results (listings, call graphs, JSON and DOT
+// documents) go to Out; headers, progress, warnings and errors go to Log, which drops those
+// below its level (--log-level, --quiet). Both write to stdout by default, and modes printing
+// machine-readable results move Log to stderr so Out can be piped to other tools. Passing
+// other writers lets the modes be reused with their output captured, e.g. by the web UI or a
+// pager.
+type Reporter struct {
+	Out io.Writer
+	Log *logging.Logger
+	// Columns aligns listings in columns and Color paints them with ANSI escapes, see SetColor
+	Columns bool
+	Color   bool
+}
+
+// NewReporter returns a reporter writing both results and status messages to w
+func NewReporter(w io.Writer) *Reporter {
+	return &Reporter{Out: w, Log: logging.New(w)}
+}
+
+// report is the reporter of the running mode, set by Processor.Run
+var report = NewReporter(os.Stdout)
+
+// Printf writes a formatted status message
+func (r *Reporter) Printf(format string, args ...interface{}) {
+	r.Log.Infof(format, args...)
+}
+
+// Println writes a status message followed by a newline
+func (r *Reporter) Println(args ...interface{}) {
+	r.Log.Infof("%s", fmt.Sprintln(args...))
+}
+
+// Print writes a status message
+func (r *Reporter) Print(args ...interface{}) {
+	r.Log.Infof("%s", fmt.Sprint(args...))
+}
+
+// Debugf writes a formatted message shown only with --log-level=debug
+func (r *Reporter) Debugf(format string, args ...interface{}) {
+	r.Log.Debugf(format, args...)
+}
+
+// Warnf writes a formatted warning
+func (r *Reporter) Warnf(format string, args ...interface{}) {
+	r.Log.Warnf(format, args...)
+}
+
+// Errorf writes a formatted error
+func (r *Reporter) Errorf(format string, args ...interface{}) {
+	r.Log.Errorf(format, args...)
+}
+
+// Resultf writes a formatted part of the result of a mode, whatever the log level
+func (r *Reporter) Resultf(format string, args ...interface{}) {
+	fmt.Fprintf(r.Out, format, args...)
+}
+
+// Resultln writes a part of the result of a mode followed by a newline
+func (r *Reporter) Resultln(args ...interface{}) {
+	fmt.Fprintln(r.Out, args...)
+}
+
+// Result writes a part of the result of a mode
+func (r *Reporter) Result(args ...interface{}) {
+	fmt.Fprint(r.Out, args...)
+}
+
+// status returns the writer of status messages, or io.Discard when they are filtered out
+func (r *Reporter) status() io.Writer {
+	if !r.Log.Enabled(logging.LevelInfo) {
+		return io.Discard
+	}
+	return r.Log.Output()
+}
+
+// Color modes of --color
+const (
+	ColorAuto   = "auto"
+	ColorAlways = "always"
+	ColorNever  = "never"
+)
+
+// ANSI styles of the terminal output
+const (
+	styleBold   = "1"
+	styleDim    = "2"
+	styleRed    = "31"
+	styleGreen  = "32"
+	styleYellow = "33"
+	styleCyan   = "36"
+)
+
+// SetColor configures the reporter for the --color mode. Listings are aligned in columns when
+// the output is a terminal or color is forced. In auto mode they are colored only on terminals
+// that support it, and never when the NO_COLOR environment variable is set.
+func (r *Reporter) SetColor(mode string) error {
+	terminal := isTerminal(r.Log.Output())
+	switch mode {
+	case ColorAuto, "":
+		r.Columns = terminal
+		r.Color = terminal && os.Getenv("NO_COLOR") == "" && os.Getenv("TERM") != "dumb"
+	case ColorAlways:
+		r.Columns = true
+		r.Color = true
+	case ColorNever:
+		r.Columns = terminal
+		r.Color = false
+	default:
+		return fmt.Errorf("unknown color mode %q (expected %q, %q or %q)", mode, ColorAuto, ColorAlways, ColorNever)
+	}
+	r.Log.SetColor(r.Color)
+	return nil
+}
+
+// isTerminal reports whether w is a character device such as a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// paint returns s in the given ANSI style, or unchanged when color is off
+func (r *Reporter) paint(style, s string) string {
+	if !r.Color || style == "" || s == "" {
+		return s
+	}
+	return "\x1b[" + style + "m" + s + "\x1b[0m"
+}
+
+// cell is a table cell, with the ANSI style it is painted in
+type cell struct {
+	text  string
+	style string
+}
+
+// styled returns a cell painted in style
+func styled(style, text string) cell {
+	return cell{text: text, style: style}
+}
+
+// table collects rows and writes them with every column padded to its widest cell. Widths
+// are measured before painting, so escape sequences don't break the alignment.
+type table struct {
+	r      *Reporter
+	w      io.Writer
+	indent string
+	rows   [][]cell // nil rows are lines, written as they are
+	lines  []string
+}
+
+// newTable returns a table of results whose rows are written to Out with the given indent
+func (r *Reporter) newTable(indent string) *table {
+	return &table{r: r, w: r.Out, indent: indent}
+}
+
+// newStatusTable returns a table of status messages, written to Log unless they are
+// filtered out
+func (r *Reporter) newStatusTable(indent string) *table {
+	return &table{r: r, w: r.status(), indent: indent}
+}
+
+// header adds a row of bold column titles
+func (t *table) header(titles ...string) {
+	cells := make([]cell, len(titles))
+	for i, title := range titles {
+		cells[i] = styled(styleBold, title)
+	}
+	t.row(cells...)
+}
+
+// row adds a row of cells
+func (t *table) row(cells ...cell) {
+	t.rows = append(t.rows, cells)
+	t.lines = append(t.lines, "")
+}
+
+// line adds a line between the rows, which does not take part in the alignment
+func (t *table) line(text string) {
+	t.rows = append(t.rows, nil)
+	t.lines = append(t.lines, text)
+}
+
+// flush writes the table
+func (t *table) flush() {
+	var widths []int
+	for _, row := range t.rows {
+		for i, c := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(c.text); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+
+	for i, row := range t.rows {
+		if row == nil {
+			fmt.Fprintln(t.w, t.lines[i])
+			continue
+		}
+		var line strings.Builder
+		line.WriteString(t.indent)
+		for j, c := range row {
+			if j > 0 {
+				line.WriteString("  ")
+			}
+			line.WriteString(t.r.paint(c.style, c.text))
+			// The last column is not padded, to leave no trailing spaces
+			if j < len(row)-1 {
+				line.WriteString(strings.Repeat(" ", widths[j]-utf8.RuneCountInString(c.text)))
+			}
+		}
+		fmt.Fprintln(t.w, strings.TrimRight(line.String(), " "))
+	}
+	t.rows, t.lines = nil, nil
+}
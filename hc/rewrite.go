@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+)
+
+// rewriteTarget is a function declaration to rewrite, by its index in the file's declarations
+type rewriteTarget struct {
+	Decl    int    `json:"decl"`
+	Rewrite string `json:"rewrite"`
+}
+
+// rewriteResult reports the outcome of rewriting a target
+type rewriteResult struct {
+	Decl  int    `json:"decl"`
+	Error string `json:"error,omitempty"`
+}
+
+// applyRewriteHooks runs the Rewrite functions of the hooks matching functions in sourceFile
+// and writes the rewritten source to a temporary file. It returns the file to instrument
+// further, which is sourceFile when nothing was rewritten, and the outcome for every
+// declaration the Rewrite functions ran on, by index. Rewrite functions that could not be run
+// leave their declarations out of the outcomes, so the caller can fall back to the raw code
+// extracted from them.
+func applyRewriteHooks(sourceFile, packageName string, hooks []instrument.HookDefinition) (string, map[int]error, error) {
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, sourceFile, nil, 0)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to parse source file %s: %w", sourceFile, err)
+	}
+
+	// Group the targets by the hooks file declaring their Rewrite function
+	targets := make(map[string][]rewriteTarget)
+	var hooksFiles []string
+	for i, decl := range node.Decls {
+		funcDecl, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		funcInfo := &analyze.FunctionInfo{Name: funcDecl.Name.Name, Receiver: analyze.ReceiverType(funcDecl), FilePath: sourceFile, NoBody: funcDecl.Body == nil}
+		match := instrument.MatchFunctionWithHooks(packageName, funcInfo, hooks)
+		if match == nil || (match.Type != "rewrite" && match.Type != "both") ||
+			match.RewriteFuncName == "" || match.HooksFile == "" {
+			continue
+		}
+		if _, exists := targets[match.HooksFile]; !exists {
+			hooksFiles = append(hooksFiles, match.HooksFile)
+		}
+		targets[match.HooksFile] = append(targets[match.HooksFile], rewriteTarget{Decl: i, Rewrite: match.RewriteFuncName})
+	}
+
+	outcomes := make(map[int]error)
+	current := sourceFile
+	for _, hooksFile := range hooksFiles {
+		rewrittenFile, results, err := runRewriteFunctions(hooksFile, current, targets[hooksFile])
+		if err != nil {
+			report.Warnf("could not run the rewrite functions of %s, injecting their raw code instead: %v\n", filepath.Base(hooksFile), err)
+			continue
+		}
+		if current != sourceFile {
+			os.Remove(current)
+		}
+		current = rewrittenFile
+		for _, result := range results {
+			if result.Error != "" {
+				outcomes[result.Decl] = errors.New(result.Error)
+			} else {
+				outcomes[result.Decl] = nil
+			}
+		}
+	}
+	return current, outcomes, nil
+}
+
+// runRewriteFunctions builds a program calling the Rewrite functions of the hooks package in
+// hooksFile and runs it on sourceFile. It returns the temporary file holding the rewritten
+// source and the outcome of every target.
+func runRewriteFunctions(hooksFile, sourceFile string, targets []rewriteTarget) (string, []rewriteResult, error) {
+	var rewriteFuncs []string
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		if !seen[target.Rewrite] {
+			seen[target.Rewrite] = true
+			rewriteFuncs = append(rewriteFuncs, target.Rewrite)
+		}
+	}
+
+	tmpDir, err := os.MkdirTemp("", "hc-rewrite")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	runner := filepath.Join(tmpDir, "rewrite-runner")
+	if err := buildRewriteRunner(hooksFile, rewriteFuncs, runner); err != nil {
+		return "", nil, err
+	}
+
+	input, err := json.Marshal(targets)
+	if err != nil {
+		return "", nil, err
+	}
+	output, err := os.CreateTemp("", "hc-rewritten-*.go")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	output.Close()
+	resultsFile := filepath.Join(tmpDir, "results.json")
+
+	cmd := exec.Command(runner, sourceFile, output.Name(), resultsFile)
+	cmd.Stdin = strings.NewReader(string(input))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(output.Name())
+		return "", nil, fmt.Errorf("rewrite functions failed: %w\n%s", err, out)
+	}
+
+	data, err := os.ReadFile(resultsFile)
+	if err != nil {
+		os.Remove(output.Name())
+		return "", nil, fmt.Errorf("failed to read rewrite results: %w", err)
+	}
+	var results []rewriteResult
+	if err := json.Unmarshal(data, &results); err != nil {
+		os.Remove(output.Name())
+		return "", nil, fmt.Errorf("failed to parse rewrite results: %w", err)
+	}
+	return output.Name(), results, nil
+}
+
+// buildRewriteRunner generates the rewrite runner for the hooks package in hooksFile and
+// builds it to binary. The runner is built inside the hooks module, so the hooks package
+// resolves with the module's own dependencies, and for the host even when cross-compiling.
+func buildRewriteRunner(hooksFile string, rewriteFuncs []string, binary string) error {
+	absPath, err := filepath.Abs(hooksFile)
+	if err != nil {
+		return fmt.Errorf("failed to get absolute path: %w", err)
+	}
+	_, modDir, err := instrument.FindGoMod(filepath.Dir(absPath))
+	if err != nil {
+		return fmt.Errorf("failed to find go.mod: %w", err)
+	}
+	hooksImportPath, err := instrument.GetHooksImportPath(absPath)
+	if err != nil {
+		return err
+	}
+
+	source, err := executeTemplate(RewriteRunnerTemplate, RewriteRunnerTemplateData{
+		HooksImportPath: hooksImportPath,
+		RewriteFuncs:    rewriteFuncs,
+	})
+	if err != nil {
+		return err
+	}
+
+	srcDir, err := os.MkdirTemp(modDir, "hc-rewrite-runner")
+	if err != nil {
+		return fmt.Errorf("failed to create rewrite runner directory: %w", err)
+	}
+	defer os.RemoveAll(srcDir)
+	if err := os.WriteFile(filepath.Join(srcDir, "main.go"), []byte(source), 0644); err != nil {
+		return fmt.Errorf("failed to write rewrite runner: %w", err)
+	}
+
+	cmd := exec.Command("go", "build", "-o", binary, "./"+filepath.Base(srcDir))
+	cmd.Dir = modDir
+	cmd.Env = append(os.Environ(), "GOOS=", "GOARCH=", "GOFLAGS=")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to build rewrite runner: %w\n%s", err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
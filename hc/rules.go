@@ -0,0 +1,312 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// CommandRules is a rules file: tweaks of the tool commands of the modified build log, such as
+// compiling one package without optimizations, written in YAML or JSON
+type CommandRules struct {
+	Rules []CommandRule `json:"rules"`
+}
+
+// CommandRule changes the commands it matches. Actions run in the order remove, replace, add.
+type CommandRule struct {
+	Name    string               `json:"name,omitempty"` // Shown in the output, rules[N] if empty
+	Match   CommandRuleMatch     `json:"match"`
+	Add     []string             `json:"add,omitempty"`     // Words inserted right after the tool, e.g. -N
+	Remove  []string             `json:"remove,omitempty"`  // Word patterns removed, see matchWords
+	Replace []CommandRuleReplace `json:"replace,omitempty"` // Word patterns replaced
+}
+
+// CommandRuleMatch selects commands: every field given must match. Patterns use * and ?.
+type CommandRuleMatch struct {
+	Tool    string `json:"tool,omitempty"`    // Base name of the tool, e.g. compile, link or asm
+	Package string `json:"package,omitempty"` // Import path after -p; path/... also matches the packages below it
+	Flag    string `json:"flag,omitempty"`    // A flag the command has, e.g. -std, with or without =value
+}
+
+// CommandRuleReplace replaces the words matching From with the words of To
+type CommandRuleReplace struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// commandRules are the rules of --rules, applied by applyCommandRules
+var commandRules []CommandRule
+
+// commandRuleCounts counts the commands each rule changed, by index
+var commandRuleCounts []int
+
+// SetCommandRules sets the rules applied to the commands of the modified build log
+func SetCommandRules(rules []CommandRule) {
+	commandRules = rules
+	commandRuleCounts = make([]int, len(rules))
+}
+
+// LoadCommandRules reads and validates a YAML or JSON rules file. Unknown keys are errors, so
+// a misspelled key doesn't silently drop a rule.
+func LoadCommandRules(rulesFile string) ([]CommandRule, error) {
+	data, err := os.ReadFile(rulesFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %w", err)
+	}
+	if strings.ToLower(filepath.Ext(rulesFile)) != ".json" {
+		if data, err = instrument.YAMLToJSON(data); err != nil {
+			return nil, fmt.Errorf("error parsing rules file %s: %w", rulesFile, err)
+		}
+	}
+
+	rules := &CommandRules{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(rules); err != nil {
+		return nil, fmt.Errorf("error parsing rules file %s: %w", rulesFile, err)
+	}
+
+	var problems []string
+	for i := range rules.Rules {
+		rule := &rules.Rules[i]
+		if rule.Name == "" {
+			rule.Name = fmt.Sprintf("rules[%d]", i)
+		}
+		if rule.Match == (CommandRuleMatch{}) {
+			problems = append(problems, rule.Name+": match needs tool, package or flag")
+		}
+		if len(rule.Add) == 0 && len(rule.Remove) == 0 && len(rule.Replace) == 0 {
+			problems = append(problems, rule.Name+": add, remove or replace is required")
+		}
+		for _, pattern := range rule.Remove {
+			if len(strings.Fields(pattern)) == 0 || len(strings.Fields(pattern)) > 2 {
+				problems = append(problems, fmt.Sprintf("%s: remove pattern %q must be one word or a flag and its value", rule.Name, pattern))
+			}
+		}
+		for _, replace := range rule.Replace {
+			if len(strings.Fields(replace.From)) == 0 || len(strings.Fields(replace.From)) > 2 {
+				problems = append(problems, fmt.Sprintf("%s: replace from %q must be one word or a flag and its value", rule.Name, replace.From))
+			}
+			if len(strings.Fields(replace.To)) == 0 {
+				problems = append(problems, fmt.Sprintf("%s: replace to is required, use remove to drop words", rule.Name))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return nil, fmt.Errorf("invalid rules file %s:\n  %s", rulesFile, strings.Join(problems, "\n  "))
+	}
+	return rules.Rules, nil
+}
+
+// applyCommandRules applies the rules matching a command of the build log to its text in the
+// modified build log. Heredocs are left alone.
+func applyCommandRules(cmd *parse.Command, command string) string {
+	if len(commandRules) == 0 || cmd.Heredoc != nil || cmd.IsMultiline {
+		return command
+	}
+	tool := parse.ToolPath(cmd)
+	if tool == "" {
+		return command
+	}
+
+	// The words keep their quotes so the command is written back as it was
+	line, comment := command, ""
+	if i := strings.Index(command, " # "); i >= 0 {
+		line, comment = command[:i], command[i:]
+	}
+	words := splitCommandWords(line)
+	modified := false
+	for i, rule := range commandRules {
+		if !rule.Match.matches(cmd, tool, words) {
+			continue
+		}
+		// The tool and the environment assignments before it are never changed
+		start := toolWordIndex(words, tool) + 1
+		changed := append([]string(nil), words...)
+		for _, pattern := range rule.Remove {
+			changed = replaceWords(changed, start, pattern, nil)
+		}
+		for _, replace := range rule.Replace {
+			changed = replaceWords(changed, start, replace.From, strings.Fields(replace.To))
+		}
+		if len(rule.Add) > 0 {
+			changed = append(changed[:start], append(append([]string(nil), rule.Add...), changed[start:]...)...)
+		}
+		if strings.Join(changed, " ") != strings.Join(words, " ") {
+			words, modified = changed, true
+			commandRuleCounts[i]++
+			report.Debugf("Rule %s changed the %s command of %s\n", rule.Name, filepath.Base(tool), commandSubject(cmd))
+		}
+	}
+	if !modified {
+		return command
+	}
+	return strings.Join(words, " ") + comment
+}
+
+// reportCommandRules prints how many commands every rule changed, warning about rules that
+// matched no command
+func reportCommandRules() {
+	for i, rule := range commandRules {
+		if commandRuleCounts[i] == 0 {
+			report.Warnf("rule %s matched no command of the build log\n", rule.Name)
+			continue
+		}
+		report.Printf("           📐 Rule %s changed %d command(s)\n", rule.Name, commandRuleCounts[i])
+	}
+}
+
+// matches reports whether a command of the build log satisfies every condition of a match
+func (m CommandRuleMatch) matches(cmd *parse.Command, tool string, words []string) bool {
+	if m.Tool != "" && !globMatch(m.Tool, filepath.Base(tool)) {
+		return false
+	}
+	if m.Package != "" {
+		pkg := parse.ExtractPackageName(cmd)
+		if pkg == "" {
+			return false
+		}
+		if prefix, ok := strings.CutSuffix(m.Package, "/..."); ok {
+			if pkg != prefix && !strings.HasPrefix(pkg, prefix+"/") {
+				return false
+			}
+		} else if !globMatch(m.Package, pkg) {
+			return false
+		}
+	}
+	if m.Flag != "" {
+		found := false
+		for _, word := range words {
+			name, _, _ := strings.Cut(unquoteWord(word), "=")
+			if strings.HasPrefix(name, "-") && (globMatch(m.Flag, name) || globMatch(m.Flag, unquoteWord(word))) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// commandSubject names what a command builds, for messages: its package or output
+func commandSubject(cmd *parse.Command) string {
+	if pkg := parse.ExtractPackageName(cmd); pkg != "" {
+		return pkg
+	}
+	return parse.ExtractOutputPath(cmd)
+}
+
+// splitCommandWords splits a command line at the spaces outside quotes, keeping the quotes
+func splitCommandWords(line string) []string {
+	var words []string
+	var current strings.Builder
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			}
+		case r == '"' || r == '\'':
+			quote = r
+		case r == ' ' || r == '\t':
+			if current.Len() > 0 {
+				words = append(words, current.String())
+				current.Reset()
+			}
+			continue
+		}
+		current.WriteRune(r)
+	}
+	if current.Len() > 0 {
+		words = append(words, current.String())
+	}
+	return words
+}
+
+// unquoteWord removes the shell quotes of a command word, e.g. "$WORK/b001=>" or GOROOT='/usr/local/go'
+func unquoteWord(word string) string {
+	return strings.NewReplacer(`"`, "", "'", "").Replace(word)
+}
+
+// toolWordIndex returns the index of the tool among the words of a command, after any
+// environment assignments
+func toolWordIndex(words []string, tool string) int {
+	for i, word := range words {
+		if unquoteWord(word) == tool {
+			return i
+		}
+	}
+	return 0
+}
+
+// replaceWords replaces every run of words from start matching pattern with replacement. A
+// pattern is one word, where -flag also matches -flag=value, or a flag and a pattern for the
+// value following it, e.g. "-trimpath *".
+func replaceWords(words []string, start int, pattern string, replacement []string) []string {
+	parts := strings.Fields(pattern)
+	result := append([]string(nil), words[:start]...)
+	for i := start; i < len(words); i++ {
+		if matchWords(parts, words[i:]) {
+			result = append(result, replacement...)
+			i += len(parts) - 1
+			continue
+		}
+		result = append(result, words[i])
+	}
+	return result
+}
+
+// matchWords reports whether the words start with the pattern parts
+func matchWords(parts []string, words []string) bool {
+	if len(words) < len(parts) {
+		return false
+	}
+	first := unquoteWord(words[0])
+	if !globMatch(parts[0], first) {
+		name, _, hasValue := strings.Cut(first, "=")
+		if len(parts) > 1 || !hasValue || strings.Contains(parts[0], "=") || !globMatch(parts[0], name) {
+			return false
+		}
+	}
+	return len(parts) == 1 || globMatch(parts[1], unquoteWord(words[1]))
+}
+
+// globMatch matches s against a pattern where * matches any text, / included, and ? one character
+func globMatch(pattern, s string) bool {
+	p, i := 0, 0
+	star, next := -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, next = p, i
+			p++
+		case star >= 0:
+			p = star + 1
+			next++
+			i = next
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
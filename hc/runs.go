@@ -0,0 +1,286 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RunsDir is the directory of build-metadata with a directory per run for the files a run
+// generates, so concurrent runs in one project don't overwrite each other's files
+const RunsDir = "runs"
+
+// LatestRun names the last run that generated a modified build log, a symlink in RunsDir
+// (a file holding the run ID where symlinks aren't available)
+const LatestRun = "latest"
+
+// maxRuns is how many runs are kept, older ones are removed when a run is published
+const maxRuns = 20
+
+// runFiles are the metadata files generated per run. GetMetadataPath returns them in the
+// directory of the current run; build-metadata/<name> links to the newest of each.
+var runFiles = []string{
+	BuildModifiedLogFile,
+	ReplayScriptFile,
+	SourceMappingsFile,
+	DlvInitFile,
+	DlvConfigFile,
+	InstrumentationPreviewFile,
+	ModifiedHeredocsDir,
+}
+
+// currentRun is the ID of the run of this process, "" for the files directly in build-metadata
+var currentRun string
+
+// isRunFile reports whether a metadata file is generated per run
+func isRunFile(name string) bool {
+	for _, runFile := range runFiles {
+		if name == runFile {
+			return true
+		}
+	}
+	return false
+}
+
+// runDir returns the directory of a run
+func runDir(id string) string {
+	return filepath.Join(MetadataDir, RunsDir, id)
+}
+
+// newRunID returns an ID for a new run: the time it started and the process ID, so runs
+// started in the same second differ and sort by age
+func newRunID() string {
+	return time.Now().Format("20060102-150405") + "-" + strconv.Itoa(os.Getpid())
+}
+
+// validateRunID checks a run ID given with --run-id
+func validateRunID(id string) error {
+	if !isPlainFileName(id) || id == LatestRun || strings.HasPrefix(id, ".") {
+		return fmt.Errorf("invalid run ID %q", id)
+	}
+	return nil
+}
+
+// startRun makes id, or a new ID if empty, the run of this process and creates its directory
+func startRun(id string) error {
+	if id == "" {
+		id = newRunID()
+	} else if err := validateRunID(id); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(runDir(id), 0755); err != nil {
+		return fmt.Errorf("failed to create run directory: %w", err)
+	}
+	currentRun = id
+	return nil
+}
+
+// selectRun makes an existing run the run of this process: id, or the latest run if empty.
+// Without runs, the files directly in build-metadata are used.
+func selectRun(id string) error {
+	if id == "" {
+		currentRun = latestRun()
+		return nil
+	}
+	if err := validateRunID(id); err != nil {
+		return err
+	}
+	if info, err := os.Stat(runDir(id)); err != nil || !info.IsDir() {
+		return fmt.Errorf("no run %s in %s", id, filepath.Join(MetadataDir, RunsDir))
+	}
+	currentRun = id
+	return nil
+}
+
+// latestRun returns the ID of the latest run, "" if no run was published
+func latestRun() string {
+	latest := filepath.Join(MetadataDir, RunsDir, LatestRun)
+	if target, err := os.Readlink(latest); err == nil {
+		return filepath.Base(target)
+	}
+	if data, err := os.ReadFile(latest); err == nil {
+		return strings.TrimSpace(string(data))
+	}
+	return ""
+}
+
+// linkRunFile points build-metadata/<name> to the file of the current run, so tools reading
+// the fixed names, such as the web UI, see the newest one. A regular file there from before
+// runs is replaced. Where symlinks aren't available the file is copied.
+func linkRunFile(name string) {
+	if currentRun == "" {
+		return
+	}
+	legacyPath := filepath.Join(MetadataDir, name)
+	if info, err := os.Lstat(legacyPath); err == nil && info.IsDir() {
+		if err := os.RemoveAll(legacyPath); err != nil {
+			report.Warnf("failed to replace %s: %v\n", legacyPath, err)
+			return
+		}
+	}
+	target := filepath.Join(RunsDir, currentRun, name)
+	if err := replaceSymlink(target, legacyPath); err != nil {
+		info, statErr := os.Stat(GetMetadataPath(name))
+		if statErr != nil || !info.Mode().IsRegular() {
+			report.Debugf("Not linking %s: %v\n", legacyPath, err)
+			return
+		}
+		if err := copyRunFile(GetMetadataPath(name), legacyPath); err != nil {
+			report.Warnf("failed to update %s: %v\n", legacyPath, err)
+		}
+	}
+}
+
+// publishRun links build-metadata/<name> to the files of the current run, makes it the latest
+// run and removes the oldest runs
+func publishRun() {
+	if currentRun == "" {
+		return
+	}
+	for _, name := range runFiles {
+		if _, err := os.Stat(GetMetadataPath(name)); err == nil {
+			linkRunFile(name)
+		}
+	}
+	latest := filepath.Join(MetadataDir, RunsDir, LatestRun)
+	if err := replaceSymlink(currentRun, latest); err != nil {
+		if err := writeFileAtomic(latest, []byte(currentRun+"\n")); err != nil {
+			report.Warnf("failed to publish run %s: %v\n", currentRun, err)
+			return
+		}
+	}
+	report.Printf("📁 Run %s: %s\n", currentRun, runDir(currentRun))
+	pruneRuns()
+}
+
+// pruneRuns removes the oldest runs beyond maxRuns, with the WORK directories of their
+// instrumented builds unless a kept run or go-build.log still uses them. The current run, the
+// latest run and the runs build-metadata/<name> still links to are kept.
+func pruneRuns() {
+	entries, err := os.ReadDir(filepath.Join(MetadataDir, RunsDir))
+	if err != nil {
+		return
+	}
+	keep := map[string]bool{currentRun: true, latestRun(): true}
+	for _, name := range runFiles {
+		if target, err := os.Readlink(filepath.Join(MetadataDir, name)); err == nil {
+			keep[filepath.Base(filepath.Dir(target))] = true
+		}
+	}
+
+	type run struct {
+		id      string
+		modTime time.Time
+	}
+	var runs []run
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == LatestRun {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		runs = append(runs, run{entry.Name(), info.ModTime()})
+	}
+	if len(runs) <= maxRuns {
+		return
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].modTime.After(runs[j].modTime) })
+	for i, r := range runs {
+		keep[r.id] = keep[r.id] || i < maxRuns
+	}
+	usedWorkDirs := map[string]bool{getWorkDirFromBuildLog(): true}
+	for _, r := range runs {
+		if keep[r.id] {
+			usedWorkDirs[runWorkDir(r.id)] = true
+		}
+	}
+	for _, r := range runs[maxRuns:] {
+		if keep[r.id] {
+			continue
+		}
+		workDir := runWorkDir(r.id)
+		if err := os.RemoveAll(runDir(r.id)); err != nil {
+			report.Warnf("failed to remove run %s: %v\n", r.id, err)
+			continue
+		}
+		report.Debugf("Removed run %s\n", r.id)
+		if workDir != "" && !usedWorkDirs[workDir] && isGoBuildWorkDir(workDir) {
+			usedWorkDirs[workDir] = true
+			if err := os.RemoveAll(workDir); err != nil {
+				report.Warnf("failed to remove %s of run %s: %v\n", workDir, r.id, err)
+			}
+		}
+	}
+}
+
+// runWorkDir returns the WORK directory of the instrumented build of a run, "" if it has none
+func runWorkDir(id string) string {
+	return workDirOfLog(filepath.Join(runDir(id), BuildModifiedLogFile))
+}
+
+// replaceSymlink atomically replaces path with a symlink to target, so concurrent runs never
+// see it missing
+func replaceSymlink(target, path string) error {
+	tmp := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	os.Remove(tmp)
+	if err := os.Symlink(target, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// writeFileAtomic replaces path with data through a temporary file and a rename
+func writeFileAtomic(path string, data []byte) error {
+	tmp := fmt.Sprintf("%s.tmp-%d", path, os.Getpid())
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// copyRunFile replaces dst with a copy of src through a temporary file and a rename
+func copyRunFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.tmp-%d", dst, os.Getpid())
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
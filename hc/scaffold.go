@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"go/token"
+	"path/filepath"
+	"sort"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"golang.org/x/tools/go/packages"
+)
+
+// DefaultScaffoldOutput is the hooks file --scaffold-hooks writes unless --scaffold-output is given
+const DefaultScaffoldOutput = "generated_hooks/generated_hooks.go"
+
+// scaffoldFunctions returns the exported functions, and the exported methods of exported types,
+// of the packages of the module in dir matching pattern, sorted by package and file. Files in
+// skipDir (the hooks package) are ignored.
+func scaffoldFunctions(dir, pattern, skipDir string) ([]annotatedFunction, error) {
+	cfg := &packages.Config{Mode: packages.NeedName | packages.NeedFiles, Dir: dir}
+	pkgs, err := packages.Load(cfg, pattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load packages: %w", err)
+	}
+	if len(pkgs) == 0 {
+		return nil, fmt.Errorf("no packages match %s", pattern)
+	}
+
+	var found []annotatedFunction
+	for _, pkg := range pkgs {
+		if len(pkg.Errors) > 0 {
+			return nil, fmt.Errorf("%s: %v", pkg.PkgPath, pkg.Errors[0])
+		}
+		packageName := pkg.PkgPath
+		if pkg.Name == "main" {
+			packageName = "main"
+		}
+		for _, file := range pkg.GoFiles {
+			if filepath.Dir(file) == skipDir {
+				continue
+			}
+			functions, err := analyze.ExtractFunctionsFromGoFile(file)
+			if err != nil {
+				return nil, err
+			}
+			for _, function := range functions {
+				receiver := analyze.ReceiverTypeName(function.Receiver)
+				if !function.IsExported || receiver != "" && !token.IsExported(receiver) {
+					continue
+				}
+				found = append(found, annotatedFunction{
+					Package:  packageName,
+					Function: function.Name,
+					Receiver: receiver,
+					Position: token.Position{Filename: file},
+					Origin:   "declared in " + filepath.Base(file),
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].Package != found[j].Package {
+			return found[i].Package < found[j].Package
+		}
+		return found[i].Position.Filename < found[j].Position.Filename
+	})
+	return found, nil
+}
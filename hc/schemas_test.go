@@ -0,0 +1,323 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+)
+
+// schemasDir holds the published schemas of the files and outputs of hc
+const schemasDir = "../docs/schemas/v1"
+
+// schemaArtifacts are the types written as every published artifact, by schema
+var schemaArtifacts = map[string]interface{}{
+	"go-build.schema.json":                BuildAction{},
+	"source-mappings.schema.json":         SourceMappings{},
+	"instrumentation-preview.schema.json": InstrumentationPreview{},
+	"build-profile.schema.json":           BuildProfile{},
+	"capture.schema.json":                 CaptureMetadata{},
+	"pack-files.schema.json":              PackFilesOutput{},
+	"pack-functions.schema.json":          PackFunctionsOutput{},
+	"pack-packages.schema.json":           PackPackagesOutput{},
+	"callgraph.schema.json":               CallGraphOutput{},
+	"callgraph-query.schema.json":         analyze.CallGraphQuery{},
+	"callgraph-diff.schema.json":          CallGraphDiff{},
+	"workdir.schema.json":                 WorkDirOutput{},
+	"weaving-report.schema.json":          WeavingReport{},
+	"plan.schema.json":                    InstrumentationPlan{},
+}
+
+// TestArtifactSchemas checks that every field of the artifacts is in their schema and every
+// property of the schemas is written: a value with all fields set must match the schema with
+// no property missing
+func TestArtifactSchemas(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join(schemasDir, "*.schema.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		if _, ok := schemaArtifacts[filepath.Base(file)]; !ok {
+			t.Errorf("%s: no artifact type for the schema", file)
+		}
+	}
+
+	for name, artifact := range schemaArtifacts {
+		t.Run(name, func(t *testing.T) {
+			schema := loadSchema(t, name)
+			if id := schema.root["$id"]; id != "https://github.com/pdelewski/go-build-interceptor/docs/schemas/v1/"+name {
+				t.Errorf("$id is %v", id)
+			}
+
+			value := reflect.New(reflect.TypeOf(artifact)).Elem()
+			schema.fill(value, schema.root)
+			data, err := json.Marshal(value.Interface())
+			if err != nil {
+				t.Fatal(err)
+			}
+			var instance interface{}
+			if err := json.Unmarshal(data, &instance); err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range schema.validate(instance, schema.root, "$", true) {
+				t.Error(problem)
+			}
+		})
+	}
+}
+
+// TestArtifactSchemasEmpty checks that the results of empty builds match their schemas
+func TestArtifactSchemasEmpty(t *testing.T) {
+	workDir, err := workDirOutput(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := map[string]interface{}{
+		"pack-files.schema.json":      packFilesOutput(nil, false),
+		"pack-functions.schema.json":  packFunctionsOutput(nil, nil),
+		"pack-packages.schema.json":   packPackagesOutput(nil),
+		"workdir.schema.json":         workDir,
+		"build-profile.schema.json":   BuildProfile{},
+		"source-mappings.schema.json": SourceMappings{},
+	}
+	for name, result := range results {
+		schema := loadSchema(t, name)
+		data, err := json.Marshal(result)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var instance interface{}
+		if err := json.Unmarshal(data, &instance); err != nil {
+			t.Fatal(err)
+		}
+		for _, problem := range schema.validate(instance, schema.root, "$", false) {
+			t.Errorf("%s: %s", name, problem)
+		}
+	}
+}
+
+// jsonSchema is a loaded schema, validated with the subset of JSON Schema the published
+// schemas use: type, properties, required, additionalProperties, items, enum, minimum and
+// local $ref
+type jsonSchema struct {
+	root map[string]interface{}
+}
+
+func loadSchema(t *testing.T, name string) *jsonSchema {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(schemasDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema := &jsonSchema{}
+	if err := json.Unmarshal(data, &schema.root); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return schema
+}
+
+// resolve follows a local $ref such as #/$defs/edge
+func (s *jsonSchema) resolve(node map[string]interface{}) map[string]interface{} {
+	for node != nil {
+		ref, ok := node["$ref"].(string)
+		if !ok {
+			return node
+		}
+		node = s.root
+		for _, part := range strings.Split(strings.TrimPrefix(ref, "#/"), "/") {
+			node, _ = node[part].(map[string]interface{})
+		}
+	}
+	return node
+}
+
+// types returns the JSON types a schema allows
+func types(node map[string]interface{}) []string {
+	switch t := node["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var result []string
+		for _, name := range t {
+			result = append(result, name.(string))
+		}
+		return result
+	}
+	return nil
+}
+
+// validate returns the differences of an instance from a schema. With complete, every
+// property of the schema must be present.
+func (s *jsonSchema) validate(instance interface{}, node map[string]interface{}, path string, complete bool) []string {
+	node = s.resolve(node)
+	if node == nil {
+		return []string{path + ": not in the schema"}
+	}
+	var problems []string
+	if allowed := types(node); len(allowed) > 0 {
+		actual := jsonType(instance)
+		ok := false
+		for _, name := range allowed {
+			if name == actual || (name == "number" && actual == "integer") {
+				ok = true
+			}
+		}
+		if !ok {
+			return []string{fmt.Sprintf("%s: %s, want %s", path, actual, strings.Join(allowed, " or "))}
+		}
+	}
+	if enum, ok := node["enum"].([]interface{}); ok {
+		found := false
+		for _, value := range enum {
+			if value == instance {
+				found = true
+			}
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", path, instance, enum))
+		}
+	}
+	if minimum, ok := node["minimum"].(float64); ok {
+		if number, ok := instance.(float64); ok && number < minimum {
+			problems = append(problems, fmt.Sprintf("%s: %v is less than %v", path, number, minimum))
+		}
+	}
+
+	switch value := instance.(type) {
+	case map[string]interface{}:
+		properties, _ := node["properties"].(map[string]interface{})
+		for _, name := range sortedKeys(value) {
+			property, ok := properties[name].(map[string]interface{})
+			if !ok {
+				if node["additionalProperties"] == false {
+					problems = append(problems, fmt.Sprintf("%s.%s: not in the schema", path, name))
+				}
+				continue
+			}
+			problems = append(problems, s.validate(value[name], property, path+"."+name, complete)...)
+		}
+		if required, ok := node["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, ok := value[name.(string)]; !ok {
+					problems = append(problems, fmt.Sprintf("%s: required %s is missing", path, name))
+				}
+			}
+		}
+		if complete {
+			for _, name := range sortedKeys(properties) {
+				if _, ok := value[name]; !ok {
+					problems = append(problems, fmt.Sprintf("%s.%s: in the schema but not written", path, name))
+				}
+			}
+		}
+	case []interface{}:
+		items, _ := node["items"].(map[string]interface{})
+		for i, item := range value {
+			problems = append(problems, s.validate(item, items, fmt.Sprintf("%s[%d]", path, i), complete)...)
+		}
+	}
+	return problems
+}
+
+// fill sets every field of a value, taking the values of enums from the schema
+func (s *jsonSchema) fill(value reflect.Value, node map[string]interface{}) {
+	node = s.resolve(node)
+	if value.Type() == reflect.TypeOf(time.Time{}) {
+		value.Set(reflect.ValueOf(time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)))
+		return
+	}
+	switch value.Kind() {
+	case reflect.Struct:
+		var properties map[string]interface{}
+		if node != nil {
+			properties, _ = node["properties"].(map[string]interface{})
+		}
+		for i := 0; i < value.NumField(); i++ {
+			field := value.Type().Field(i)
+			if field.Anonymous {
+				s.fill(value.Field(i), node)
+				continue
+			}
+			name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+			if name == "-" || !field.IsExported() {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			property, _ := properties[name].(map[string]interface{})
+			s.fill(value.Field(i), property)
+		}
+	case reflect.Slice:
+		var items map[string]interface{}
+		if node != nil {
+			items, _ = node["items"].(map[string]interface{})
+		}
+		slice := reflect.MakeSlice(value.Type(), 1, 1)
+		s.fill(slice.Index(0), items)
+		value.Set(slice)
+	case reflect.Map:
+		var values map[string]interface{}
+		if node != nil {
+			values, _ = node["additionalProperties"].(map[string]interface{})
+		}
+		element := reflect.New(value.Type().Elem()).Elem()
+		s.fill(element, values)
+		value.Set(reflect.MakeMap(value.Type()))
+		value.SetMapIndex(reflect.ValueOf("x").Convert(value.Type().Key()), element)
+	case reflect.Ptr:
+		value.Set(reflect.New(value.Type().Elem()))
+		s.fill(value.Elem(), node)
+	case reflect.String:
+		value.SetString("x")
+		if node != nil {
+			if enum, ok := node["enum"].([]interface{}); ok {
+				value.SetString(enum[0].(string))
+			}
+		}
+	case reflect.Bool:
+		value.SetBool(true)
+	case reflect.Int, reflect.Int64:
+		value.SetInt(2)
+	case reflect.Float64:
+		value.SetFloat(2.5)
+	}
+}
+
+// jsonType returns the JSON Schema type of a decoded JSON value
+func jsonType(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []interface{}:
+		return "array"
+	case map[string]interface{}:
+		return "object"
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shutdownFuncName is the function otel.runtime.go declares in the main package to run the
+// shutdown sequence of the hooks runtime (hooks.Shutdown)
+const shutdownFuncName = "otelShutdown"
+
+// findMainFuncFile returns the Go file of files declaring func main, "" if there is none
+func findMainFuncFile(files []string) string {
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".go") {
+			continue
+		}
+		_, node, err := parseSourceFile(token.NewFileSet(), file)
+		if err != nil {
+			continue
+		}
+		if mainFuncDecl(node) != nil {
+			return file
+		}
+	}
+	return ""
+}
+
+// mainFuncDecl returns the declaration of func main in a file of package main
+func mainFuncDecl(file *ast.File) *ast.FuncDecl {
+	if file.Name.Name != "main" {
+		return nil
+	}
+	for _, decl := range file.Decls {
+		if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Recv == nil && funcDecl.Name.Name == "main" && funcDecl.Body != nil {
+			return funcDecl
+		}
+	}
+	return nil
+}
+
+// deferShutdown writes sourceFile, originalFile or an instrumented copy of it, to targetFile
+// with "defer otelShutdown()" as the first statement of func main. Deferred first, it runs
+// last when main returns or panics: after the After hooks of main.main, which the trampolines
+// defer after it. Files deferring it already are copied as they are.
+func deferShutdown(originalFile, sourceFile, targetFile string) error {
+	fset := token.NewFileSet()
+	src, node, err := parseSourceFile(fset, sourceFile)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %w", sourceFile, err)
+	}
+	funcDecl := mainFuncDecl(node)
+	if funcDecl == nil {
+		return fmt.Errorf("%s does not declare func main", sourceFile)
+	}
+
+	content := src
+	if !defersShutdown(funcDecl) {
+		edit := newDeclEdit(fset, funcDecl)
+		deferStmt := &ast.DeferStmt{Call: &ast.CallExpr{Fun: ast.NewIdent(shutdownFuncName)}}
+		funcDecl.Body.List = append([]ast.Stmt{deferStmt}, funcDecl.Body.List...)
+		if content, err = applyDeclEdits(src, fset, node, []declEdit{edit}); err != nil {
+			return fmt.Errorf("failed to format %s: %w", sourceFile, err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(targetFile), 0755); err != nil {
+		return err
+	}
+	if content, err = withLineDirectives(originalFile, markGenerated(content)); err != nil {
+		return err
+	}
+	return os.WriteFile(targetFile, content, 0644)
+}
+
+// defersShutdown reports whether func main starts by deferring the shutdown sequence
+func defersShutdown(funcDecl *ast.FuncDecl) bool {
+	if len(funcDecl.Body.List) == 0 {
+		return false
+	}
+	deferStmt, ok := funcDecl.Body.List[0].(*ast.DeferStmt)
+	if !ok {
+		return false
+	}
+	ident, ok := deferStmt.Call.Fun.(*ast.Ident)
+	return ok && ident.Name == shutdownFuncName
+}
+
+// addMainShutdown makes func main of the main package run the shutdown sequence of the hooks
+// runtime, recording the modified copy of its file in fileReplacements. The copy of a file
+// instrumented already is modified in place. Nothing changes when otelRuntimeFile, generated
+// from custom templates, doesn't declare otelShutdown.
+func addMainShutdown(files []string, otelRuntimeFile, workDir, buildID string, fileReplacements map[string]string) {
+	runtime, err := os.ReadFile(otelRuntimeFile)
+	if err != nil || !strings.Contains(string(runtime), "func "+shutdownFuncName+"(") {
+		report.Warnf("%s does not declare %s, the hooks runtime won't shut down when main returns\n",
+			filepath.Base(otelRuntimeFile), shutdownFuncName)
+		return
+	}
+	mainFile := findMainFuncFile(files)
+	if mainFile == "" {
+		report.Warnf("func main not found, the hooks runtime won't shut down when main returns\n")
+		return
+	}
+	sourceFile := mainFile
+	if instrumented, exists := fileReplacements[mainFile]; exists {
+		sourceFile = instrumented
+	}
+	targetFile := filepath.Join(workDir, buildID, filepath.Base(mainFile))
+	if err := deferShutdown(mainFile, sourceFile, targetFile); err != nil {
+		report.Warnf("failed to add the hooks runtime shutdown to main: %v\n", err)
+		return
+	}
+	fileReplacements[mainFile] = targetFile
+	report.Printf("           🛑 Deferred hooks runtime shutdown in %s\n", filepath.Base(mainFile))
+}
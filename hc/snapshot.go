@@ -0,0 +1,370 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotDir is the directory named snapshots of the instrumentation workspace are kept in
+const SnapshotDir = ".hc-snapshots"
+
+// Names inside a snapshot archive
+const (
+	snapshotManifestName   = "snapshot.json"
+	snapshotWorkPrefix     = "work/"     // Files of the WORK tree, relative to it
+	snapshotMetadataPrefix = "metadata/" // Files of build-metadata, relative to it
+	snapshotHooksPrefix    = "hooks/"    // Files of the hooks packages, by absolute path
+	snapshotFileExtension  = ".tar.gz"
+)
+
+// SnapshotManifest describes a snapshot: the workspace it was taken of and what it holds
+type SnapshotManifest struct {
+	Name       string    `json:"name"`
+	CreatedAt  time.Time `json:"createdAt"`
+	WorkDir    string    `json:"workDir"`              // WORK directory of the captured build, restored at the same path
+	HooksFiles []string  `json:"hooksFiles,omitempty"` // Hooks files passed when the snapshot was taken
+	Files      int       `json:"files"`
+	Size       int64     `json:"size"` // Bytes of the archived files, uncompressed
+}
+
+// snapshotPath returns the archive of a named snapshot
+func snapshotPath(name string) (string, error) {
+	if !isPlainFileName(name) {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return filepath.Join(SnapshotDir, name+snapshotFileExtension), nil
+}
+
+// createSnapshot archives the WORK tree of the captured build, build-metadata (build logs,
+// replay script and source mappings) and the packages of the hooks files into a named
+// snapshot, replacing an existing one of that name
+func createSnapshot(name string, hooksFiles []string) (*SnapshotManifest, error) {
+	archivePath, err := snapshotPath(name)
+	if err != nil {
+		return nil, err
+	}
+	workDir := getWorkDirFromBuildLog()
+	if workDir == "" {
+		return nil, fmt.Errorf("no WORK directory in %s, capture or compile the build first", GetMetadataPath(BuildLogFile))
+	}
+	if _, err := os.Stat(workDir); err != nil {
+		return nil, fmt.Errorf("WORK directory of the build is gone: %w", err)
+	}
+
+	manifest := &SnapshotManifest{Name: name, CreatedAt: time.Now().UTC(), WorkDir: workDir}
+	// Archive name -> file on disk
+	entries := make(map[string]string)
+	if err := collectSnapshotFiles(entries, snapshotWorkPrefix, workDir); err != nil {
+		return nil, err
+	}
+	if err := collectMetadataFiles(entries); err != nil {
+		return nil, err
+	}
+	hooksDirs := make(map[string]bool)
+	for _, hooksFile := range hooksFiles {
+		absPath, err := filepath.Abs(hooksFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve hooks file %s: %w", hooksFile, err)
+		}
+		manifest.HooksFiles = append(manifest.HooksFiles, hooksFile)
+		hooksDirs[filepath.Dir(absPath)] = true
+	}
+	for dir := range hooksDirs {
+		dirEntries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read hooks package %s: %w", dir, err)
+		}
+		for _, entry := range dirEntries {
+			if entry.Type().IsRegular() && isBundledFile(entry.Name()) {
+				path := filepath.Join(dir, entry.Name())
+				entries[snapshotHooksPrefix+strings.TrimPrefix(filepath.ToSlash(path), "/")] = path
+			}
+		}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name, path := range entries {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		names = append(names, name)
+		manifest.Files++
+		manifest.Size += info.Size()
+	}
+	sort.Strings(names)
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot manifest: %w", err)
+	}
+
+	if err := os.MkdirAll(SnapshotDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", SnapshotDir, err)
+	}
+	// Written next to the snapshot and renamed, so a failure keeps the previous one
+	tmpFile, err := os.CreateTemp(SnapshotDir, name+".*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create snapshot: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	// The manifest goes first, so listing snapshots doesn't read them whole
+	gz := gzip.NewWriter(tmpFile)
+	tw := tar.NewWriter(gz)
+	if err := writeTarFile(tw, snapshotManifestName, manifestData, manifest.CreatedAt); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := writeSnapshotFile(tw, name, entries[name]); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmpFile.Chmod(0644); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	if err := os.Rename(tmpFile.Name(), archivePath); err != nil {
+		return nil, fmt.Errorf("failed to write snapshot: %w", err)
+	}
+	return manifest, nil
+}
+
+// collectSnapshotFiles adds the regular files under dir to entries, named prefix + their
+// path relative to dir
+func collectSnapshotFiles(entries map[string]string, prefix, dir string) error {
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		entries[prefix+filepath.ToSlash(rel)] = path
+		return nil
+	})
+}
+
+// collectMetadataFiles adds the files of build-metadata to entries. Of the runs, only the
+// files build-metadata/<name> links to are added, under those names, so a restored snapshot
+// holds the newest generated files without the runs.
+func collectMetadataFiles(entries map[string]string) error {
+	dirEntries, err := os.ReadDir(MetadataDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range dirEntries {
+		path := filepath.Join(MetadataDir, entry.Name())
+		name := snapshotMetadataPrefix + entry.Name()
+		switch {
+		case entry.Name() == RunsDir:
+		case entry.Type()&fs.ModeSymlink != 0:
+			info, err := os.Stat(path)
+			if err != nil {
+				continue // Links to a removed run
+			}
+			if info.IsDir() {
+				// WalkDir doesn't follow a symlink, even at its root
+				resolved, err := filepath.EvalSymlinks(path)
+				if err != nil {
+					return err
+				}
+				if err := collectSnapshotFiles(entries, name+"/", resolved); err != nil {
+					return err
+				}
+			} else if info.Mode().IsRegular() {
+				entries[name] = path
+			}
+		case entry.IsDir():
+			if err := collectSnapshotFiles(entries, name+"/", path); err != nil {
+				return err
+			}
+		case entry.Type().IsRegular():
+			entries[name] = path
+		}
+	}
+	return nil
+}
+
+// writeSnapshotFile adds a file to a snapshot archive, keeping its mode
+func writeSnapshotFile(tw *tar.Writer, name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", path, err)
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s to snapshot: %w", name, err)
+	}
+	if _, err := io.Copy(tw, file); err != nil {
+		return fmt.Errorf("failed to write %s to snapshot: %w", name, err)
+	}
+	return nil
+}
+
+// restoreSnapshot replaces the WORK tree and build-metadata with those of a named snapshot
+// and writes back the files of its hooks packages, so the instrumented build can be
+// replayed or inspected again without capturing and compiling it
+func restoreSnapshot(name string) (*SnapshotManifest, error) {
+	archivePath, err := snapshotPath(name)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := readSnapshotManifest(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	if !filepath.IsAbs(manifest.WorkDir) {
+		return nil, fmt.Errorf("invalid WORK directory %q in snapshot %s", manifest.WorkDir, name)
+	}
+
+	// Files of the other experiment must not outlive the restore
+	if err := os.RemoveAll(manifest.WorkDir); err != nil {
+		return nil, fmt.Errorf("failed to clear %s: %w", manifest.WorkDir, err)
+	}
+	if err := os.RemoveAll(MetadataDir); err != nil {
+		return nil, fmt.Errorf("failed to clear %s: %w", MetadataDir, err)
+	}
+
+	file, err := os.Open(archivePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("not a snapshot: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot: %w", err)
+		}
+		if header.Name == snapshotManifestName {
+			continue
+		}
+		target, err := snapshotTarget(header, manifest.WorkDir)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(target), err)
+		}
+		out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, header.FileInfo().Mode().Perm())
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+		_, err = io.Copy(out, tr)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %w", target, err)
+		}
+	}
+	return manifest, nil
+}
+
+// snapshotTarget returns where an entry of a snapshot is restored, rejecting entries that
+// would be written outside their tree
+func snapshotTarget(header *tar.Header, workDir string) (string, error) {
+	if header.Typeflag != tar.TypeReg {
+		return "", fmt.Errorf("unexpected entry %s in snapshot", header.Name)
+	}
+	var root, rel string
+	switch {
+	case strings.HasPrefix(header.Name, snapshotWorkPrefix):
+		root, rel = workDir, strings.TrimPrefix(header.Name, snapshotWorkPrefix)
+	case strings.HasPrefix(header.Name, snapshotMetadataPrefix):
+		root, rel = MetadataDir, strings.TrimPrefix(header.Name, snapshotMetadataPrefix)
+	case strings.HasPrefix(header.Name, snapshotHooksPrefix):
+		root, rel = "/", strings.TrimPrefix(header.Name, snapshotHooksPrefix)
+	default:
+		return "", fmt.Errorf("unexpected entry %s in snapshot", header.Name)
+	}
+	if !filepath.IsLocal(filepath.FromSlash(rel)) {
+		return "", fmt.Errorf("unexpected entry %s in snapshot", header.Name)
+	}
+	return filepath.Join(root, filepath.FromSlash(rel)), nil
+}
+
+// readSnapshotManifest reads the manifest of a snapshot archive, its first entry
+func readSnapshotManifest(archivePath string) (*SnapshotManifest, error) {
+	file, err := os.Open(archivePath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no snapshot %s (see --snapshot-list)", strings.TrimSuffix(filepath.Base(archivePath), snapshotFileExtension))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open snapshot: %w", err)
+	}
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, fmt.Errorf("not a snapshot: %w", err)
+	}
+	tr := tar.NewReader(gz)
+	header, err := tr.Next()
+	if err != nil || header.Name != snapshotManifestName {
+		return nil, fmt.Errorf("not a snapshot: %s missing", snapshotManifestName)
+	}
+	manifest := &SnapshotManifest{}
+	if err := json.NewDecoder(tr).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("invalid snapshot manifest: %w", err)
+	}
+	return manifest, nil
+}
+
+// listSnapshots returns the manifests of the snapshots in SnapshotDir, oldest first
+func listSnapshots() ([]*SnapshotManifest, error) {
+	archives, err := filepath.Glob(filepath.Join(SnapshotDir, "*"+snapshotFileExtension))
+	if err != nil {
+		return nil, err
+	}
+	var manifests []*SnapshotManifest
+	for _, archivePath := range archives {
+		manifest, err := readSnapshotManifest(archivePath)
+		if err != nil {
+			report.Warnf("skipping %s: %v\n", archivePath, err)
+			continue
+		}
+		manifests = append(manifests, manifest)
+	}
+	sort.Slice(manifests, func(i, j int) bool {
+		return manifests[i].CreatedAt.Before(manifests[j].CreatedAt)
+	})
+	return manifests, nil
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"os"
+	"sort"
+)
+
+// declEdit replaces the source of a declaration with its modified AST. Only the declaration
+// itself is reprinted, so the rest of the file keeps its comments, blank lines and formatting
+// byte for byte. Statements prepended to a function body are inserted after its opening brace,
+// leaving the original body as it was.
+type declEdit struct {
+	start, end int // Byte offsets of the original declaration, without its doc comment
+	decl       ast.Decl
+	lbrace     int        // Byte offset of the function body's opening brace, or -1
+	body       []ast.Stmt // Original statements of the function body
+}
+
+// newDeclEdit records the source range of decl. It must be called before decl is modified,
+// since new nodes have no positions.
+func newDeclEdit(fset *token.FileSet, decl ast.Decl) declEdit {
+	edit := declEdit{
+		start:  fset.Position(decl.Pos()).Offset,
+		end:    fset.Position(decl.End()).Offset,
+		decl:   decl,
+		lbrace: -1,
+	}
+	if funcDecl, ok := decl.(*ast.FuncDecl); ok && funcDecl.Body != nil {
+		edit.lbrace = fset.Position(funcDecl.Body.Lbrace).Offset
+		edit.body = append([]ast.Stmt{}, funcDecl.Body.List...)
+	}
+	return edit
+}
+
+// prepended returns the statements prepended to the function body since the edit was
+// created, or false if the body was changed otherwise
+func (e declEdit) prepended(fset *token.FileSet) ([]ast.Stmt, bool) {
+	funcDecl, ok := e.decl.(*ast.FuncDecl)
+	if !ok || e.lbrace < 0 || funcDecl.Body == nil || !funcDecl.Body.Lbrace.IsValid() ||
+		fset.Position(funcDecl.Body.Lbrace).Offset != e.lbrace {
+		return nil, false
+	}
+	list := funcDecl.Body.List
+	added := len(list) - len(e.body)
+	if added < 0 {
+		return nil, false
+	}
+	for i, stmt := range e.body {
+		if list[added+i] != stmt {
+			return nil, false
+		}
+	}
+	return list[:added], true
+}
+
+// parseSourceFile parses a Go file, returning its source along with the AST so that the
+// file can be written back with applyDeclEdits
+func parseSourceFile(fset *token.FileSet, filename string) ([]byte, *ast.File, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	file, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, nil, err
+	}
+	return src, file, nil
+}
+
+// applyDeclEdits returns src, the source file was parsed from, with every edited declaration
+// replaced by its printed AST
+func applyDeclEdits(src []byte, fset *token.FileSet, file *ast.File, edits []declEdit) ([]byte, error) {
+	sorted := append([]declEdit{}, edits...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].start < sorted[j].start })
+
+	var result bytes.Buffer
+	offset := 0
+	for _, edit := range sorted {
+		if edit.start < offset || edit.end > len(src) {
+			return nil, fmt.Errorf("overlapping or invalid declaration range %d-%d", edit.start, edit.end)
+		}
+		result.Write(src[offset:edit.start])
+		// Bodies on one line with the opening brace are reprinted as a whole
+		stmts, ok := edit.prepended(fset)
+		if ok && bytes.HasPrefix(src[edit.lbrace+1:], []byte("\n")) {
+			// Reprint the signature, which may have had parameters named, and insert the
+			// statements after the opening brace
+			funcDecl := *edit.decl.(*ast.FuncDecl)
+			funcDecl.Body = nil
+			signature, err := printDecl(fset, &funcDecl, commentsIn(fset, file, edit.start, edit.lbrace))
+			if err != nil {
+				return nil, err
+			}
+			result.Write(signature)
+			result.WriteString(" {")
+			for _, stmt := range stmts {
+				printed, err := printStmt(stmt)
+				if err != nil {
+					return nil, err
+				}
+				result.WriteString("\n")
+				result.Write(printed)
+			}
+			result.Write(src[edit.lbrace+1 : edit.end])
+		} else {
+			printed, err := printDecl(fset, edit.decl, commentsIn(fset, file, edit.start, edit.end))
+			if err != nil {
+				return nil, err
+			}
+			result.Write(printed)
+		}
+		offset = edit.end
+	}
+	result.Write(src[offset:])
+	return result.Bytes(), nil
+}
+
+// commentsIn returns the comments of file between the start and end offsets
+func commentsIn(fset *token.FileSet, file *ast.File, start, end int) []*ast.CommentGroup {
+	var comments []*ast.CommentGroup
+	for _, group := range file.Comments {
+		offset := fset.Position(group.Pos()).Offset
+		if offset >= start && offset < end {
+			comments = append(comments, group)
+		}
+	}
+	return comments
+}
+
+// printDecl prints a declaration the way gofmt would, with the given comments. The doc
+// comment is left out: it stays in the source, outside the replaced range.
+func printDecl(fset *token.FileSet, decl ast.Decl, comments []*ast.CommentGroup) ([]byte, error) {
+	switch d := decl.(type) {
+	case *ast.FuncDecl:
+		undocumented := *d
+		undocumented.Doc = nil
+		decl = &undocumented
+	case *ast.GenDecl:
+		undocumented := *d
+		undocumented.Doc = nil
+		decl = &undocumented
+	}
+
+	var buf bytes.Buffer
+	config := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8}
+	if err := config.Fprint(&buf, fset, &printer.CommentedNode{Node: decl, Comments: comments}); err != nil {
+		return nil, fmt.Errorf("failed to print declaration: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// printStmt prints an injected statement indented for a function body. Its positions, if any,
+// belong to the snippet it was parsed from, so they are ignored.
+func printStmt(stmt ast.Stmt) ([]byte, error) {
+	var buf bytes.Buffer
+	config := printer.Config{Mode: printer.UseSpaces | printer.TabIndent, Tabwidth: 8, Indent: 1}
+	if err := config.Fprint(&buf, token.NewFileSet(), stmt); err != nil {
+		return nil, fmt.Errorf("failed to print statement: %w", err)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+)
+
+// Template names for generated files
+const (
+	TrampolinesTemplate     = "trampolines.go.tmpl"
+	OtelRuntimeTemplate     = "otel.runtime.go.tmpl"
+	TrampolinesShimTemplate = "trampolines_shim.go.tmpl"
+	OtelRuntimeShimTemplate = "otel.runtime_shim.go.tmpl"
+	RewriteRunnerTemplate   = "rewrite_runner.go.tmpl"
+)
+
+//go:embed templates/*.tmpl
+var embeddedTemplates embed.FS
+
+// templateDir is an optional directory with user-provided templates that
+// override the embedded ones (set via --template-dir)
+var templateDir string
+
+// SetTemplateDir sets the directory used to look up template overrides
+func SetTemplateDir(dir string) {
+	templateDir = dir
+}
+
+// TrampolineHookData holds the per-hook values used by the trampolines template
+type TrampolineHookData struct {
+	Function        string
+	Package         string
+	PascalName      string
+	BeforeFunc      string // Before function in the hooks package
+	AfterFunc       string // After function in the hooks package
+	HooksImportPath string // Package implementing the Before/After hooks
+	HooksAlias      string // Import name of that package in otel.runtime.go
+
+	Chain      []HookCallData // Further hooks of the function, in the order their Before functions are called
+	AfterChain []HookCallData // Chain in the order the After functions are called (reversed)
+}
+
+// HookCallData is a further hook called by the trampolines of a function matched by several
+// hooks. Index suffixes the names of its declarations in the trampolines file.
+type HookCallData struct {
+	Index           int
+	BeforeFunc      string
+	AfterFunc       string
+	HooksImportPath string
+}
+
+// TrampolinesTemplateData is the data passed to the trampolines template
+type TrampolinesTemplateData struct {
+	PackageName     string
+	HooksImportPath string // Primary hooks package
+	Hooks           []TrampolineHookData
+}
+
+// HooksPackageData is a hooks package imported by otel.runtime.go
+type HooksPackageData struct {
+	ImportPath string
+	Alias      string
+}
+
+// OtelRuntimeTemplateData is the data passed to the otel.runtime.go template
+type OtelRuntimeTemplateData struct {
+	HooksImportPath string               // Primary hooks package
+	HooksPackages   []HooksPackageData   // All hooks packages, primary first
+	Hooks           []TrampolineHookData // Hooks to register (shim backend only)
+	PanicPolicy     string               // Hook panic policy, empty for the runtime default
+}
+
+// RewriteRunnerTemplateData is the data passed to the rewrite runner template
+type RewriteRunnerTemplateData struct {
+	HooksImportPath string   // Package declaring the Rewrite functions
+	RewriteFuncs    []string // Rewrite functions the runner can call
+}
+
+// newTrampolineHookData returns the template data of a hook. The Before/After functions
+// default to Before<Function>/After<Function> when the hook doesn't name them.
+func newTrampolineHookData(hook instrument.HookDefinition, hooksImportPath string) TrampolineHookData {
+	data := TrampolineHookData{
+		Function:        hook.Function,
+		Package:         hook.Package,
+		PascalName:      trampolineName(hook),
+		HooksImportPath: instrument.HookImportPath(hook, hooksImportPath),
+	}
+	data.BeforeFunc, data.AfterFunc = instrument.HookFunctionNames(hook)
+
+	// Hooks naming the same Before/After functions are called once
+	called := map[string]bool{data.HooksImportPath + "." + data.BeforeFunc + "/" + data.AfterFunc: true}
+	for _, chained := range hook.Chain {
+		chainedData := newTrampolineHookData(chained, hooksImportPath)
+		key := chainedData.HooksImportPath + "." + chainedData.BeforeFunc + "/" + chainedData.AfterFunc
+		if called[key] {
+			continue
+		}
+		called[key] = true
+		data.Chain = append(data.Chain, HookCallData{
+			Index:           len(data.Chain) + 2,
+			BeforeFunc:      chainedData.BeforeFunc,
+			AfterFunc:       chainedData.AfterFunc,
+			HooksImportPath: chainedData.HooksImportPath,
+		})
+	}
+	for i := len(data.Chain) - 1; i >= 0; i-- {
+		data.AfterChain = append(data.AfterChain, data.Chain[i])
+	}
+	return data
+}
+
+// trampolineName returns the name identifying the declarations generated for the function a
+// hook instruments, such as OtelBeforeTrampoline_<name> and HookContextImpl<name>: the
+// capitalized function name, prefixed with the receiver type for methods (Server_Do for
+// (*Server).Do) so that methods of the same name on different receivers don't collide. The
+// package needs no encoding, as each package gets its own trampolines file.
+func trampolineName(hook instrument.HookDefinition) string {
+	if hook.Receiver == "" {
+		return capitalizeFirst(hook.Function)
+	}
+	return exportedName(analyze.ReceiverTypeName(hook.Receiver)) + "_" + capitalizeFirst(hook.Function)
+}
+
+// trampolineHookData converts before/after hook definitions into the template data of
+// otel.runtime.go, skipping duplicates that would register the same hook functions. Hooks
+// without an import path of their own belong to hooksImportPath.
+func trampolineHookData(hooks []instrument.HookDefinition, hooksImportPath string) []TrampolineHookData {
+	var data []TrampolineHookData
+	seen := make(map[string]bool)
+	for _, hook := range hooks {
+		if hook.Type != "before_after" && hook.Type != "both" {
+			continue
+		}
+		hookData := newTrampolineHookData(hook, hooksImportPath)
+		key := hookData.HooksImportPath + "." + hookData.BeforeFunc + "/" + hookData.AfterFunc
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		data = append(data, hookData)
+	}
+	return data
+}
+
+// hooksPackageData lists the hooks packages implementing hooks and sets the import alias
+// of each hook's package. Without hooks it lists only the primary hooks package.
+func hooksPackageData(hooksImportPath string, hooks []TrampolineHookData) []HooksPackageData {
+	if len(hooks) == 0 {
+		return []HooksPackageData{{ImportPath: hooksImportPath, Alias: "userhooks"}}
+	}
+
+	var packages []HooksPackageData
+	aliases := make(map[string]string)
+	for i := range hooks {
+		alias, exists := aliases[hooks[i].HooksImportPath]
+		if !exists {
+			alias = "userhooks"
+			if len(packages) > 0 {
+				alias = fmt.Sprintf("userhooks%d", len(packages)+1)
+			}
+			aliases[hooks[i].HooksImportPath] = alias
+			packages = append(packages, HooksPackageData{ImportPath: hooks[i].HooksImportPath, Alias: alias})
+		}
+		hooks[i].HooksAlias = alias
+	}
+	return packages
+}
+
+// loadTemplate returns the named template, preferring an override from templateDir
+func loadTemplate(name string) (*template.Template, error) {
+	if templateDir != "" {
+		overridePath := filepath.Join(templateDir, name)
+		if content, err := os.ReadFile(overridePath); err == nil {
+			tmpl, err := template.New(name).Parse(string(content))
+			if err != nil {
+				return nil, fmt.Errorf("failed to parse template %s: %w", overridePath, err)
+			}
+			return tmpl, nil
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read template %s: %w", overridePath, err)
+		}
+	}
+
+	content, err := embeddedTemplates.ReadFile("templates/" + name)
+	if err != nil {
+		return nil, fmt.Errorf("embedded template %s not found: %w", name, err)
+	}
+	tmpl, err := template.New(name).Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse embedded template %s: %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// executeTemplate renders the named template with the given data
+func executeTemplate(name string, data interface{}) (string, error) {
+	tmpl, err := loadTemplate(name)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	if err := tmpl.Execute(&sb, data); err != nil {
+		return "", fmt.Errorf("failed to execute template %s: %w", name, err)
+	}
+	return sb.String(), nil
+}
+
+// dumpTemplates writes all embedded templates to the given directory so they
+// can be customized and passed back with --template-dir
+func dumpTemplates(targetDir string) error {
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		return fmt.Errorf("failed to create template directory %s: %w", targetDir, err)
+	}
+
+	entries, err := embeddedTemplates.ReadDir("templates")
+	if err != nil {
+		return fmt.Errorf("failed to read embedded templates: %w", err)
+	}
+
+	for _, entry := range entries {
+		content, err := embeddedTemplates.ReadFile("templates/" + entry.Name())
+		if err != nil {
+			return fmt.Errorf("failed to read embedded template %s: %w", entry.Name(), err)
+		}
+		targetFile := filepath.Join(targetDir, entry.Name())
+		if err := os.WriteFile(targetFile, content, 0644); err != nil {
+			return fmt.Errorf("failed to write template %s: %w", targetFile, err)
+		}
+		report.Printf("  - %s\n", targetFile)
+	}
+
+	return nil
+}
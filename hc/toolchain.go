@@ -0,0 +1,140 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// goBinary is the go command hc runs builds with (--go), e.g. gotip or the go binary of a
+// forked toolchain
+var goBinary = "go"
+
+// SetGoBinary sets the go command builds are captured with
+func SetGoBinary(path string) {
+	if path != "" {
+		goBinary = path
+	}
+}
+
+// Toolchain identifies the toolchain a build log was captured with
+type Toolchain struct {
+	GoBinary   string `json:"goBinary"`               // Absolute path of the go command
+	GOROOT     string `json:"goroot"`                 // Root of the toolchain, whose tools the log runs
+	Version    string `json:"version"`                // GOVERSION, e.g. go1.24.4 or devel go1.25-abcdef
+	Experiment string `json:"goexperiment,omitempty"` // GOEXPERIMENT the build ran with
+}
+
+func (t *Toolchain) String() string {
+	s := fmt.Sprintf("%s (%s", t.Version, t.GOROOT)
+	if t.Experiment != "" {
+		s += ", GOEXPERIMENT=" + t.Experiment
+	}
+	return s + ")"
+}
+
+// detectToolchain returns the toolchain of the go command
+func detectToolchain() (*Toolchain, error) {
+	path, err := exec.LookPath(goBinary)
+	if err != nil {
+		return nil, fmt.Errorf("go command %q not found: %w", goBinary, err)
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	out, err := exec.Command(path, "env", "-json", "GOROOT", "GOVERSION", "GOEXPERIMENT").Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s env: %w", path, err)
+	}
+	var env map[string]string
+	if err := json.Unmarshal(out, &env); err != nil {
+		return nil, fmt.Errorf("invalid output of %s env: %w", path, err)
+	}
+	return &Toolchain{GoBinary: path, GOROOT: env["GOROOT"], Version: env["GOVERSION"], Experiment: env["GOEXPERIMENT"]}, nil
+}
+
+// recordToolchain writes the toolchain of the go command next to the captured build log
+func recordToolchain() error {
+	toolchain, err := detectToolchain()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(toolchain, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(GetMetadataPath(ToolchainFile), append(data, '\n'), 0644)
+}
+
+// loadToolchain reads the toolchain recorded for a build log, nil when none was recorded
+func loadToolchain(logFile string) (*Toolchain, error) {
+	path := filepath.Join(filepath.Dir(logFile), ToolchainFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	toolchain := &Toolchain{}
+	if err := json.Unmarshal(data, toolchain); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return toolchain, nil
+}
+
+// allowToolchainMismatch replays build logs with a toolchain other than the recorded one
+// (--allow-toolchain-mismatch)
+var allowToolchainMismatch bool
+
+// SetAllowToolchainMismatch makes toolchain mismatches warnings instead of errors
+func SetAllowToolchainMismatch(allow bool) {
+	allowToolchainMismatch = allow
+}
+
+// replayToolchain is the toolchain the replayed build log was captured with, once pinned
+var replayToolchain *Toolchain
+
+// pinToolchain checks that the go command is the toolchain the build log was captured with
+// and makes the replay use its GOEXPERIMENT, and the environment of the capture. Logs
+// captured before toolchains were recorded are replayed as they are.
+func pinToolchain(logFile string) error {
+	if err := pinCaptureEnv(logFile); err != nil {
+		return err
+	}
+	recorded, err := loadToolchain(logFile)
+	if err != nil || recorded == nil {
+		return err
+	}
+	current, err := detectToolchain()
+	if err != nil {
+		return err
+	}
+	if current.Version != recorded.Version || current.GOROOT != recorded.GOROOT || current.Experiment != recorded.Experiment {
+		if !allowToolchainMismatch {
+			return fmt.Errorf("build log was captured with %s, but %s is %s; run with --go %s or pass --allow-toolchain-mismatch",
+				recorded, goBinary, current, recorded.GoBinary)
+		}
+		report.Warnf("build log was captured with %s, replaying it with %s\n", recorded, current)
+	}
+
+	replayToolchain = recorded
+	// Also seen by the commands hc runs itself, such as the hooks library compilation
+	if recorded.Experiment != "" {
+		os.Setenv("GOEXPERIMENT", recorded.Experiment)
+	}
+	return nil
+}
+
+// applyReplayToolchain exports the GOEXPERIMENT of the pinned toolchain, and the environment
+// of the capture, to the commands the parser replays and the scripts it writes
+func applyReplayToolchain(parser *parse.Parser) {
+	applyCaptureEnv(parser)
+	if replayToolchain != nil && replayToolchain.Experiment != "" {
+		parser.SetEnv("GOEXPERIMENT", replayToolchain.Experiment)
+	}
+}
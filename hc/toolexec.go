@@ -0,0 +1,635 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/instrument"
+	"github.com/pdelewski/go-build-interceptor/hc/logging"
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// toolexecNestedEnv marks go commands started by hc itself while running as a toolexec
+// wrapper, so their tool invocations are forwarded without instrumentation
+const toolexecNestedEnv = "HC_TOOLEXEC_NESTED"
+
+// toolexecDirName is the directory under $WORK where the toolexec wrapper keeps shared state
+const toolexecDirName = "hc-toolexec"
+
+// toolexecTools are the toolchain programs go build invokes through -toolexec
+var toolexecTools = map[string]bool{
+	"addr2line": true, "asm": true, "buildid": true, "cgo": true, "compile": true,
+	"cover": true, "link": true, "nm": true, "objdump": true, "pack": true, "vet": true,
+}
+
+// ToolexecOptions are the hc settings forwarded to every toolexec invocation
+type ToolexecOptions struct {
+	HooksFiles      []string
+	Backend         string
+	HookPanicPolicy string // From where go build runs: the wrapper runs in package directories, without .hc.json
+	NoInline        bool
+	PreserveLines   bool
+	Verbose         bool          // Show instrumentation output (printed by go build under the package name)
+	LogLevel        logging.Level // Level of the least severe instrumentation messages shown with Verbose
+}
+
+// runToolexec is the entry point of --toolexec. When args start with a toolchain program,
+// hc acts as the -toolexec wrapper for that invocation; otherwise it runs go build with
+// itself as the wrapper and args as the build arguments.
+func runToolexec(opts ToolexecOptions, args []string) error {
+	if len(args) > 0 && isToolexecTool(args[0]) {
+		return runToolexecTool(opts, args[0], args[1:])
+	}
+	return runGoBuildWithToolexec(opts, args)
+}
+
+// isToolexecTool reports whether path is a toolchain program passed by go build
+func isToolexecTool(path string) bool {
+	if !filepath.IsAbs(path) {
+		return false
+	}
+	return toolexecTools[strings.TrimSuffix(filepath.Base(path), ".exe")]
+}
+
+// runGoBuildWithToolexec runs go build with hc registered as the -toolexec wrapper
+func runGoBuildWithToolexec(opts ToolexecOptions, buildArgs []string) error {
+	if len(opts.HooksFiles) == 0 {
+		return fmt.Errorf("no hooks files provided, use --toolexec --compile <hooks_file>")
+	}
+
+	execPath, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	// The wrapper runs in each package directory, so all paths must be absolute
+	var hooksFiles []string
+	for _, hooksFile := range opts.HooksFiles {
+		absPath, err := filepath.Abs(hooksFile)
+		if err != nil {
+			return fmt.Errorf("failed to resolve hooks file %s: %w", hooksFile, err)
+		}
+		if _, err := os.Stat(absPath); err != nil {
+			return fmt.Errorf("hooks file not found: %s", absPath)
+		}
+		hooksFiles = append(hooksFiles, absPath)
+	}
+	// Report conflicting hooks once, before go build fails on them in every package
+	if _, _, _, err := loadHooksFiles(hooksFiles); err != nil {
+		return err
+	}
+
+	toolexec := []string{quoteToolexecArg(execPath), "--toolexec", "--compile", quoteToolexecArg(strings.Join(hooksFiles, ","))}
+	if opts.Backend != "" {
+		toolexec = append(toolexec, "--backend", opts.Backend)
+	}
+	if opts.HookPanicPolicy != "" {
+		toolexec = append(toolexec, "--hook-panic-policy", opts.HookPanicPolicy)
+	}
+	if opts.NoInline {
+		toolexec = append(toolexec, "--noinline")
+	}
+	if opts.PreserveLines {
+		toolexec = append(toolexec, "--preserve-lines")
+	}
+	if opts.Verbose {
+		toolexec = append(toolexec, "--verbose")
+	}
+	if opts.LogLevel != logging.LevelInfo {
+		toolexec = append(toolexec, "--log-level", opts.LogLevel.String())
+	}
+	// The hooks packages are listed with the same toolchain, so their export data matches
+	if goBinary != "go" {
+		goPath, err := exec.LookPath(goBinary)
+		if err != nil {
+			return fmt.Errorf("go command %q not found: %w", goBinary, err)
+		}
+		if goPath, err = filepath.Abs(goPath); err != nil {
+			return err
+		}
+		toolexec = append(toolexec, "--go", quoteToolexecArg(goPath))
+	}
+
+	goArgs := append([]string{"build", "-toolexec", strings.Join(toolexec, " ")}, buildArgs...)
+	report.Printf("🔧 Running: %s %s\n", goBinary, strings.Join(goArgs, " "))
+
+	cmd := exec.Command(goBinary, goArgs...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go build failed: %w", err)
+	}
+
+	report.Println("✅ Build completed with instrumentation")
+	return nil
+}
+
+// quoteToolexecArg quotes an argument of the -toolexec command line if it contains spaces
+func quoteToolexecArg(arg string) string {
+	if strings.ContainsAny(arg, " \t") {
+		return "'" + arg + "'"
+	}
+	return arg
+}
+
+// runToolexecTool handles a single toolchain invocation: compile and link commands are
+// instrumented, everything else is forwarded unchanged to the real tool
+func runToolexecTool(opts ToolexecOptions, toolPath string, toolArgs []string) error {
+	// Keep instrumentation messages out of the tool's output unless requested
+	toolStdout := os.Stdout
+	status := io.Discard
+	if opts.Verbose {
+		status = os.Stderr
+	}
+	report = NewReporter(status)
+	report.Log.SetLevel(opts.LogLevel)
+	analyze.SetWarningOutput(status)
+
+	toolName := strings.TrimSuffix(filepath.Base(toolPath), ".exe")
+
+	args, err := expandResponseFiles(toolArgs)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case os.Getenv(toolexecNestedEnv) != "":
+		args = toolArgs
+	case len(args) == 1 && args[0] == "-V=full":
+		return runToolVersion(opts, toolPath, args, toolStdout)
+	case toolName == "compile":
+		if args, err = instrumentCompileArgs(opts, toolPath, args); err != nil {
+			return fmt.Errorf("toolexec: %w", err)
+		}
+	case toolName == "link":
+		if args, err = instrumentLinkArgs(opts, toolPath, args); err != nil {
+			return fmt.Errorf("toolexec: %w", err)
+		}
+	default:
+		args = toolArgs
+	}
+
+	return execTool(toolPath, args, toolStdout)
+}
+
+// execTool runs the real tool and exits with its status code on failure, so go build
+// reports the tool's own error
+func execTool(toolPath string, args []string, stdout io.Writer) error {
+	cmd := exec.Command(toolPath, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			os.Exit(exitErr.ExitCode())
+		}
+		return fmt.Errorf("failed to run %s: %w", toolPath, err)
+	}
+	return nil
+}
+
+// runToolVersion answers `tool -V=full`, which go build uses as the tool ID in its cache keys.
+// The hooks fingerprint is folded into the ID so cached packages are rebuilt when hooks change.
+func runToolVersion(opts ToolexecOptions, toolPath string, args []string, stdout io.Writer) error {
+	output, err := exec.Command(toolPath, args...).Output()
+	if err != nil {
+		return fmt.Errorf("failed to run %s -V=full: %w", toolPath, err)
+	}
+
+	fingerprint, err := hooksFingerprint(opts)
+	if err != nil {
+		return err
+	}
+
+	fields := strings.Fields(strings.TrimSpace(string(output)))
+	if n := len(fields); n > 0 && strings.HasPrefix(fields[n-1], "buildID=") {
+		// Development toolchains: go build only uses the content ID part of the build ID
+		sum := sha256.Sum256([]byte(fields[n-1] + fingerprint))
+		fields[n-1] = fields[n-1] + "+" + hex.EncodeToString(sum[:])[:32]
+	} else {
+		fields = append(fields, "hc="+fingerprint)
+	}
+
+	_, err = fmt.Fprintln(stdout, strings.Join(fields, " "))
+	return err
+}
+
+// hooksFingerprint hashes everything that changes the generated code: the hooks packages,
+// the hooks files given, including YAML/JSON manifests, the hc settings and the hc executable
+// itself
+func hooksFingerprint(opts ToolexecOptions) (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "backend=%s hook-panic-policy=%s noinline=%t preserve-lines=%t\n", opts.Backend, opts.HookPanicPolicy, opts.NoInline, opts.PreserveLines)
+
+	hashed := make(map[string]bool)
+	dirs := make(map[string]bool)
+	for _, hooksFile := range opts.HooksFiles {
+		hashed[hooksFile] = true
+		dirs[filepath.Dir(hooksFile)] = true
+	}
+	for dir := range dirs {
+		files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+		if err != nil {
+			return "", err
+		}
+		for _, file := range files {
+			hashed[file] = true
+		}
+	}
+	var files []string
+	for file := range hashed {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to read hooks file %s: %w", file, err)
+		}
+		fmt.Fprintf(h, "%s %d\n", file, len(content))
+		h.Write(content)
+	}
+
+	if execPath, err := os.Executable(); err == nil {
+		if info, err := os.Stat(execPath); err == nil {
+			fmt.Fprintf(h, "hc %d %d\n", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// expandResponseFiles replaces @file arguments, which go build uses for long command
+// lines, with the arguments they contain
+func expandResponseFiles(args []string) ([]string, error) {
+	var expanded []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "@") {
+			expanded = append(expanded, arg)
+			continue
+		}
+		content, err := os.ReadFile(arg[1:])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read response file %s: %w", arg[1:], err)
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(string(content), "\n"), "\n") {
+			line = strings.ReplaceAll(line, `\n`, "\n")
+			expanded = append(expanded, strings.ReplaceAll(line, `\\`, `\`))
+		}
+	}
+	return expanded, nil
+}
+
+// flagValue returns the value following a flag in a tool argument list
+func flagValue(args []string, name string) string {
+	for i, arg := range args {
+		if arg == name && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(arg, name+"=") {
+			return strings.TrimPrefix(arg, name+"=")
+		}
+	}
+	return ""
+}
+
+// setFlagValue replaces the value following a flag in a tool argument list
+func setFlagValue(args []string, name, value string) []string {
+	for i, arg := range args {
+		if arg == name && i+1 < len(args) {
+			args[i+1] = value
+		} else if strings.HasPrefix(arg, name+"=") {
+			args[i] = name + "=" + value
+		}
+	}
+	return args
+}
+
+// removeArg removes every occurrence of a boolean flag from a tool argument list
+func removeArg(args []string, name string) []string {
+	var result []string
+	for _, arg := range args {
+		if arg != name {
+			result = append(result, arg)
+		}
+	}
+	return result
+}
+
+// loadHooksFiles parses hooks, rewrite functions, struct modifications and generated files
+// from all hooks files. Hooks from several files that target the same function are an error.
+func loadHooksFiles(hooksFiles []string) ([]instrument.HookDefinition, []instrument.StructModificationDefinition, []instrument.GeneratedFileDefinition, error) {
+	var hooks []instrument.HookDefinition
+	var structMods []instrument.StructModificationDefinition
+	var generatedFiles []instrument.GeneratedFileDefinition
+	for _, hooksFile := range instrument.UniqueHooksFiles(hooksFiles) {
+		fileHooks, err := instrument.ParseHooksFile(hooksFile)
+		if err != nil && !errors.Is(err, instrument.ErrNoHooks) {
+			return nil, nil, nil, err
+		}
+		if err != nil {
+			report.Warnf("%v\n", err)
+			fileHooks = []instrument.HookDefinition{}
+		}
+		fileHooks = instrument.ParseRewriteFunctionsFromFile(hooksFile, fileHooks)
+		instrument.TagHooks(fileHooks, hooksFile)
+		hooks = append(hooks, fileHooks...)
+		structMods = append(structMods, instrument.ParseStructModificationsFromHooksFile(hooksFile)...)
+		generatedFiles = append(generatedFiles, instrument.ParseGeneratedFilesFromHooksFile(hooksFile)...)
+	}
+	if err := instrument.CheckHookConflicts(hooks); err != nil {
+		return nil, nil, nil, err
+	}
+	return hooks, structMods, generatedFiles, nil
+}
+
+// toolexecImportPath returns the import path of the package go build runs the tool for, which
+// it sets in TOOLEXEC_IMPORTPATH, e.g. "example.com/app" or "example.com/app [example.com/app.test]"
+// for test variants
+func toolexecImportPath() string {
+	importPath, _, _ := strings.Cut(os.Getenv("TOOLEXEC_IMPORTPATH"), " ")
+	return importPath
+}
+
+// hasTrampolineHooks reports whether any hook needs trampolines (and so the hooks package)
+func hasTrampolineHooks(hooks []instrument.HookDefinition) bool {
+	for _, hook := range hooks {
+		if hook.Type == "before_after" || hook.Type == "both" {
+			return true
+		}
+	}
+	return false
+}
+
+// instrumentCompileArgs instruments the Go files of a compile invocation into the package's
+// $WORK/bXXX directory and returns the arguments pointing the compiler at them
+func instrumentCompileArgs(opts ToolexecOptions, toolPath string, args []string) ([]string, error) {
+	cmd := &parse.Command{Executable: toolPath, Args: args}
+	packageName := parse.ExtractPackageName(cmd)
+	files := parse.ExtractPackFiles(cmd)
+	outputPath := parse.ExtractOutputPath(cmd)
+	importcfgPath := flagValue(args, "-importcfg")
+	if packageName == "" || len(files) == 0 || outputPath == "" || importcfgPath == "" {
+		return args, nil
+	}
+
+	hooks, structMods, generatedFiles, err := loadHooksFiles(opts.HooksFiles)
+	if err != nil {
+		return nil, err
+	}
+	hooksImportPath, err := instrument.GetHooksImportPath(opts.HooksFiles[0])
+	if err != nil {
+		return nil, fmt.Errorf("could not determine hooks import path: %w", err)
+	}
+	hooks = instrument.ResolveMainPackage(hooks, packageName, toolexecImportPath())
+
+	packageDir := filepath.Dir(outputPath)
+	replacements := make(map[string]string)
+	var extraFiles []string
+	needsTrampolines := false
+
+	for _, file := range files {
+		if !strings.HasSuffix(file, ".go") {
+			continue
+		}
+		functions, err := analyze.ExtractFunctionsFromGoFile(file)
+		if err != nil {
+			continue
+		}
+		hasMatches := false
+		for _, fn := range functions {
+			if match := instrument.MatchFunctionWithHooks(packageName, &fn, hooks); match != nil {
+				hasMatches = true
+				if match.Type == "before_after" || match.Type == "both" {
+					needsTrampolines = true
+				}
+			}
+		}
+		if !hasMatches {
+			continue
+		}
+
+		targetFile := filepath.Join(packageDir, filepath.Base(file))
+		if err := instrumentFile(file, targetFile, packageName, hooks, hooksImportPath); err != nil {
+			return nil, fmt.Errorf("failed to instrument %s: %w", file, err)
+		}
+		replacements[file] = targetFile
+	}
+	if needsTrampolines {
+		extraFiles = append(extraFiles, filepath.Join(packageDir, "otel_trampolines.go"))
+	}
+
+	for _, mod := range structMods {
+		if mod.Package != packageName {
+			continue
+		}
+		structFile, err := findStructDefinitionFile(files, mod.StructName)
+		if err != nil {
+			continue
+		}
+		sourceFile := structFile
+		if instrumented, exists := replacements[structFile]; exists {
+			sourceFile = instrumented
+		}
+		targetFile := filepath.Join(packageDir, filepath.Base(structFile))
+		if err := applyStructModification(structFile, sourceFile, targetFile, mod); err != nil {
+			return nil, fmt.Errorf("failed to apply struct modification %s.%s: %w", mod.Package, mod.StructName, err)
+		}
+		replacements[structFile] = targetFile
+	}
+
+	for _, genFile := range generatedFiles {
+		if genFile.Package != packageName {
+			continue
+		}
+		genFilePath, err := writeGeneratedFileToPackage(genFile, filepath.Dir(packageDir), filepath.Base(packageDir))
+		if err != nil {
+			return nil, err
+		}
+		extraFiles = append(extraFiles, genFilePath)
+	}
+
+	// The main package imports the hooks package so it is linked into the binary
+	isMain := packageName == "main"
+	if isMain && hasTrampolineHooks(hooks) {
+		runtimeFile, err := generateOtelRuntimeFile(packageDir, hooksImportPath, hooks)
+		if err != nil {
+			return nil, err
+		}
+		extraFiles = append(extraFiles, runtimeFile)
+		addMainShutdown(files, runtimeFile, filepath.Dir(packageDir), filepath.Base(packageDir), replacements)
+	}
+
+	if len(replacements) == 0 && len(extraFiles) == 0 {
+		return args, nil
+	}
+
+	// Packages with trampolines import the hooks library; main also imports the hooks package
+	var imports []string
+	if needsTrampolines || (isMain && hasTrampolineHooks(hooks)) {
+		imports = append(imports, HooksLibraryImportPath)
+	}
+	if isMain && hasTrampolineHooks(hooks) {
+		for _, pkg := range hooksPackageData(hooksImportPath, trampolineHookData(hooks, hooksImportPath)) {
+			imports = append(imports, pkg.ImportPath)
+		}
+	}
+	if len(imports) > 0 {
+		hooksImportcfg, err := loadHooksImportcfg(opts, filepath.Dir(filepath.Dir(importcfgPath)))
+		if err != nil {
+			return nil, err
+		}
+		newImportcfg := filepath.Join(packageDir, "importcfg.hc")
+		if err := extendImportcfg(importcfgPath, newImportcfg, hooksImportcfg, imports); err != nil {
+			return nil, err
+		}
+		args = setFlagValue(args, "-importcfg", newImportcfg)
+	}
+
+	for i, arg := range args {
+		if instrumented, exists := replacements[arg]; exists {
+			args[i] = instrumented
+		}
+	}
+	args = append(args, extraFiles...)
+	// Trampolines declare bodyless linkname functions, which -complete rejects
+	args = removeArg(args, "-complete")
+
+	report.Printf("🔧 toolexec: instrumented package %s (%d files replaced, %d files added)\n",
+		packageName, len(replacements), len(extraFiles))
+	return args, nil
+}
+
+// instrumentLinkArgs adds the hooks package and its dependencies to the link importcfg, and
+// -checklinkname=0 when the linker restricts the go:linkname used by the trampolines
+func instrumentLinkArgs(opts ToolexecOptions, toolPath string, args []string) ([]string, error) {
+	importcfgPath := flagValue(args, "-importcfg")
+	if importcfgPath == "" {
+		return args, nil
+	}
+
+	hooks, _, _, err := loadHooksFiles(opts.HooksFiles)
+	if err != nil {
+		return nil, err
+	}
+	if !hasTrampolineHooks(hooks) {
+		return args, nil
+	}
+
+	hooksImportcfg, err := loadHooksImportcfg(opts, filepath.Dir(filepath.Dir(importcfgPath)))
+	if err != nil {
+		return nil, err
+	}
+
+	var imports []string
+	for importPath := range hooksImportcfg {
+		imports = append(imports, importPath)
+	}
+	sort.Strings(imports)
+
+	newImportcfg := filepath.Join(filepath.Dir(importcfgPath), "importcfg.link.hc")
+	if err := extendImportcfg(importcfgPath, newImportcfg, hooksImportcfg, imports); err != nil {
+		return nil, err
+	}
+	args = setFlagValue(args, "-importcfg", newImportcfg)
+
+	if goVersion := toolGoVersion(toolPath); needsCheckLinknameOff(goVersion) {
+		var ok bool
+		if args, ok = addCheckLinknameOffArgs(args); !ok {
+			// Printed to stderr, since go build discards the wrapper's other messages
+			fmt.Fprintln(os.Stderr, checkLinknameWarning(goVersion))
+		}
+	}
+	return args, nil
+}
+
+// loadHooksImportcfg returns the compiled package files of the hooks packages and all their
+// dependencies. They are built once per go build by `go list -export` and cached in $WORK.
+func loadHooksImportcfg(opts ToolexecOptions, workDir string) (map[string]string, error) {
+	cacheFile := filepath.Join(workDir, toolexecDirName, "importcfg.hooks")
+	content, err := os.ReadFile(cacheFile)
+	if err != nil {
+		// Hooks packages may live in different modules, so each is listed from its own directory
+		content = nil
+		for _, pkg := range hooksPackageBuilds(opts.HooksFiles, "") {
+			cmd := exec.Command(goBinary, "list", "-export", "-deps",
+				"-f", "{{if .Export}}packagefile {{.ImportPath}}={{.Export}}{{end}}", ".")
+			cmd.Dir = pkg.Dir
+			cmd.Env = append(toolexecNestedEnviron(), toolexecNestedEnv+"=1")
+			cmd.Stderr = os.Stderr
+			output, err := cmd.Output()
+			if err != nil {
+				return nil, fmt.Errorf("failed to build hooks package in %s: %w", pkg.Dir, err)
+			}
+			content = append(content, output...)
+		}
+
+		// Concurrent compiles may race here; each writes the same content atomically
+		if err := os.MkdirAll(filepath.Dir(cacheFile), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(cacheFile), err)
+		}
+		tmpFile, err := os.CreateTemp(filepath.Dir(cacheFile), "importcfg-*")
+		if err != nil {
+			return nil, fmt.Errorf("failed to cache hooks importcfg: %w", err)
+		}
+		tmpFile.Write(content)
+		tmpFile.Close()
+		os.Rename(tmpFile.Name(), cacheFile)
+	}
+
+	return parse.ParseImportcfg(string(content)).PackageFiles(), nil
+}
+
+// toolexecNestedEnviron returns the environment for go commands started by the wrapper,
+// without a -toolexec in GOFLAGS that would recurse into hc
+func toolexecNestedEnviron() []string {
+	var env []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "GOFLAGS=") {
+			var flags []string
+			for _, f := range strings.Fields(strings.TrimPrefix(kv, "GOFLAGS=")) {
+				if !strings.HasPrefix(f, "-toolexec") {
+					flags = append(flags, f)
+				}
+			}
+			kv = "GOFLAGS=" + strings.Join(flags, " ")
+		}
+		env = append(env, kv)
+	}
+	return env
+}
+
+// extendImportcfg writes a copy of an importcfg with packagefile entries for the given
+// imports. Packages the build already provides are kept as they are.
+func extendImportcfg(importcfgPath, newImportcfgPath string, packageFiles map[string]string, imports []string) error {
+	importcfg, err := parse.ReadImportcfg(importcfgPath)
+	if err != nil {
+		return fmt.Errorf("failed to read importcfg: %w", err)
+	}
+	for _, importPath := range imports {
+		if _, exists := importcfg.PackageFile(importPath); exists {
+			continue
+		}
+		file, exists := packageFiles[importPath]
+		if !exists {
+			return fmt.Errorf("no compiled package file for %s", importPath)
+		}
+		importcfg.SetPackageFile(importPath, file)
+	}
+
+	if err := importcfg.WriteFile(newImportcfgPath); err != nil {
+		return fmt.Errorf("failed to write %s: %w", newImportcfgPath, err)
+	}
+	return nil
+}
@@ -5,25 +5,58 @@ import (
 	"path/filepath"
 )
 
+// DefaultMetadataDir is the directory where build metadata files are stored unless
+// --metadata-dir gives another
+const DefaultMetadataDir = "build-metadata"
+
 // MetadataDir is the directory where all build metadata files are stored
-const MetadataDir = "build-metadata"
+var MetadataDir = DefaultMetadataDir
+
+// SetMetadataDir sets the directory where all build metadata files are stored (--metadata-dir)
+func SetMetadataDir(dir string) {
+	MetadataDir = filepath.Clean(dir)
+}
 
 // MetadataFile names
 const (
-	BuildLogFile         = "go-build.log"
-	BuildJSONFile        = "go-build.json"
-	BuildModifiedLogFile = "go-build-modified.log"
-	ReplayScriptFile     = "replay_script.sh"
-	SourceMappingsFile   = "source-mappings.json"
+	BuildLogFile               = "go-build.log"
+	BuildJSONFile              = "go-build.json"
+	BuildModifiedLogFile       = "go-build-modified.log"
+	ReplayScriptFile           = "replay_script.sh"
+	SourceMappingsFile         = "source-mappings.json"
+	DlvInitFile                = "dlv-init"       // dlv commands applying the substitute-path rules, for dlv --init
+	DlvConfigFile              = "dlv-config.yml" // substitute-path setting of the dlv configuration file
+	InstrumentationPreviewFile = "instrumentation-preview.json"
+	ToolchainFile              = "toolchain.json"
+	CaptureFile                = "capture.json"
+	BuildProfileFile           = "build-profile.json"
+)
+
+// DebugBuildDir holds the copies of the instrumented files debuggers show, in its debug directory
+const DebugBuildDir = ".debug-build"
+
+// Directories of build-metadata with the content of the heredocs of the build logs, such as
+// the import configurations of the packages
+const (
+	HeredocsDir         = "heredocs"          // Heredocs of go-build.log
+	ModifiedHeredocsDir = "heredocs-modified" // Heredocs of go-build-modified.log
 )
 
-// GetMetadataPath returns the full path to a metadata file
+// GetMetadataPath returns the full path to a metadata file. The files generated per run are
+// in the directory of the current run, see runFiles.
 func GetMetadataPath(filename string) string {
+	if currentRun != "" && isRunFile(filename) {
+		return filepath.Join(runDir(currentRun), filename)
+	}
 	return filepath.Join(MetadataDir, filename)
 }
 
-// EnsureMetadataDir creates the metadata directory if it doesn't exist
+// EnsureMetadataDir creates the metadata directory, and the directory of the current run,
+// if they don't exist
 func EnsureMetadataDir() error {
+	if currentRun != "" {
+		return os.MkdirAll(runDir(currentRun), 0755)
+	}
 	return os.MkdirAll(MetadataDir, 0755)
 }
 
@@ -47,23 +80,91 @@ type BuildAction struct {
 
 // Config holds all configuration options
 type Config struct {
-	LogFile         string
-	DryRun          bool
-	Dump            bool
-	Verbose         bool
-	Execute         bool
-	Interactive     bool
-	Capture         bool
-	JSONCapture     bool
-	PackFiles       bool
-	PackFunctions   bool
-	PackageNames    bool
-	CallGraph       bool
-	WorkDir         bool
-	PackPackagePath bool
-	Compile         bool
-	HooksFiles      []string // Multiple hooks files (comma-separated or multiple --compile flags)
-	SourceMappings  bool
+	LogFile                string // Build log of the replaying and analysis modes, default go-build.log of MetadataDir
+	MetadataDir            string // Directory of the build metadata files (--metadata-dir)
+	Version                bool   // Print the version of hc and exit
+	DryRun                 bool
+	Dump                   bool
+	Verbose                bool
+	Execute                bool
+	Interactive            bool
+	OnlyPackage            string // Package whose compile, with the actions it needs, is the only one replayed
+	LinkFlags              string // Flags added to the link commands, e.g. -X main.version=1.2.3
+	BinaryOut              string // Path the linked binaries are put at instead of where the build put them
+	BuildArgs              string // Flags and package patterns given to the go build of captures, e.g. -tags netgo ./cmd/...
+	CaptureStrategy        string // How captures run go build: full (-a) or minimal (build cache and go build -n -a)
+	FreshWork              bool   // Move the WORK directories of the build log into a new directory before replaying
+	Check                  bool   // Verify the toolchain, tools and sources of the build log can be replayed
+	Jobs                   int    // Build actions replayed in parallel
+	Native                 bool   // Replay the build log in hc instead of bash
+	MemoryBudget           string // Memory the actions replayed in parallel may use, e.g. 8GiB
+	MemoryHints            string // Estimated memory of actions per class, e.g. link=2GiB,cgo=1GiB
+	Workers                string // Machines compile actions are replayed on, e.g. host1,http://host2:9000
+	WorkerListen           string // Address to serve build actions of other hc processes on
+	Daemon                 bool   // Serve the analysis modes from in-memory caches on DaemonSocket
+	DaemonSocket           string // Unix socket of --daemon
+	LSP                    bool   // Serve the function list, call graph and hook matches to an editor on stdio
+	Exec                   bool   // Capture the go builds of the command given after -- through a go wrapper
+	GoBinary               string // go command builds are captured with, e.g. gotip
+	AllowToolchainMismatch bool   // Replay build logs with a toolchain other than the one they were captured with
+	Capture                bool
+	JSONCapture            bool
+	PackFiles              bool
+	Hash                   bool // With --pack-files, hash every file and report files with the same content
+	PackFunctions          bool
+	PackageNames           bool
+	CallGraph              bool
+	Format                 string // Output format for --callgraph: "text" or "dot"
+	Algo                   string // Call graph algorithm for --callgraph: "static", "cha" or "rta"
+	CallGraphQuery         string // Function whose callers and callees are shown
+	Depth                  int    // Levels of callers and callees shown by --callgraph-query (0: all)
+	CallGraphDiff          string // Call graph (--callgraph --output=json) compared with the one given as argument
+	Output                 string // Output format for the analysis modes: "text" or "json"
+	Color                  string // Color mode of the terminal output: "auto", "always" or "never"
+	NoPager                bool   // Do not pipe the output through a pager on terminals
+	Quiet                  bool   // Only print warnings, errors and the results of the mode
+	LogLevel               string // Level of the least severe diagnostics printed: debug, info, warn or error
+	WorkDir                bool
+	PackPackagePath        bool
+	Compile                bool
+	HooksFiles             []string // Multiple hooks files (comma-separated or multiple --compile flags)
+	SourceMappings         bool
+	DlvConfig              bool   // Write the dlv substitute-path rules of the source mappings
+	VSCode                 bool   // With DlvConfig, add launch configurations to .vscode/launch.json
+	Debug                  bool   // With --compile, run the instrumented binary under dlv
+	DlvDAP                 string // With --debug, address dlv dap listens on instead of running dlv exec
+	WeavingReport          bool   // Report what instrumentation added to every package of the last --compile
+	TemplateDir            string // Directory with template overrides for generated code
+	DumpTemplates          string // Directory to write the embedded templates to
+	Backend                string // Code generation backend: "linkname" or "shim"
+	HookPanicPolicy        string // Hooks runtime policy for panicking hooks, overriding the project config
+	NoInline               bool   // Annotate instrumented functions with //go:noinline
+	PreserveLines          bool   // Add //line directives pointing instrumented files at their original lines
+	RulesFile              string // YAML or JSON rules changing the commands of the modified build log
+	RunID                  string // Run of build-metadata/runs written or read, see runs.go
+	NoCache                bool   // Recompile every package instead of reusing archives from .otel-build
+	RemoteCache            string // Directory, http(s):// URL or s3:// location sharing cached archives between machines
+	RemoteCacheRO          bool   // Download from the remote cache without uploading
+	Preview                bool   // With --compile, write instrumentation diffs instead of building
+	Plan                   bool   // With --compile, report what would be instrumented instead of building
+	NoExecute              bool   // With --compile, write the modified build log without replaying it
+	Toolexec               bool   // Run as a go build -toolexec wrapper instead of replaying a build log
+	ExportHooks            string // With --compile, write the hooks package to this bundle file
+	BundleVersion          string // Version recorded in an exported hooks bundle
+	ImportHooks            string // Hooks bundle to install
+	SnapshotCreate         string // Name of the snapshot of the instrumentation workspace to take
+	SnapshotRestore        string // Name of the snapshot to restore the workspace from
+	SnapshotList           bool   // List the snapshots of the workspace
+	Uninstrument           bool   // Remove the instrumentation artifacts, keeping the captured build log
+	HooksDir               string // Directory hooks bundles are installed into
+	ScanAnnotations        string // Hooks file to extend with the functions annotated with //interceptor:hook
+	ScaffoldHooks          string // Package pattern whose exported functions get hooks in ScaffoldOutput
+	ScaffoldOutput         string // Hooks file written by --scaffold-hooks
+
+	ListInstrumentations bool     // List the instrumentations of the registry
+	AddInstrumentation   string   // Instrumentation to install from the registry
+	Registry             string   // Registry file or URL
+	With                 []string // Instrumentations to compile with, by the package they instrument or by name
 }
 
 // Capturer interface for different capture methods
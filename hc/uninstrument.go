@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// isGoBuildWorkDir reports whether dir is a WORK directory created by go build -work, or by
+// hc for replays: the only directories outside the project hc removes
+func isGoBuildWorkDir(dir string) bool {
+	return filepath.IsAbs(dir) && strings.HasPrefix(filepath.Base(dir), "go-build")
+}
+
+// instrumentedWorkDirs returns the WORK directories of the instrumented builds recorded in
+// build-metadata: those of the modified build logs of the runs and of build-metadata itself
+func instrumentedWorkDirs() []string {
+	seen := make(map[string]bool)
+	var workDirs []string
+	add := func(logFile string) {
+		if workDir := workDirOfLog(logFile); workDir != "" && !seen[workDir] {
+			seen[workDir] = true
+			workDirs = append(workDirs, workDir)
+		}
+	}
+	add(filepath.Join(MetadataDir, BuildModifiedLogFile))
+	if entries, err := os.ReadDir(filepath.Join(MetadataDir, RunsDir)); err == nil {
+		for _, entry := range entries {
+			if entry.IsDir() {
+				add(filepath.Join(runDir(entry.Name()), BuildModifiedLogFile))
+			}
+		}
+	}
+	sort.Strings(workDirs)
+	return workDirs
+}
+
+// uninstrument removes what instrumentation left behind: the WORK directories of the
+// instrumented builds, with their instrumented copies, trampolines and otel.runtime.go, the
+// cache of instrumented archives, the debug copies of the instrumented files and the files
+// of the runs in build-metadata. The captured build log and its metadata are kept, so the
+// next --compile instruments the original sources again and replays of go-build.log recreate
+// its WORK directory.
+func uninstrument() error {
+	var removed int
+	var failed []string
+	remove := func(path string) {
+		if _, err := os.Lstat(path); err != nil {
+			return
+		}
+		if err := os.RemoveAll(path); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", path, err))
+			return
+		}
+		removed++
+		report.Printf("🧹 Removed %s\n", path)
+	}
+
+	for _, workDir := range instrumentedWorkDirs() {
+		if !isGoBuildWorkDir(workDir) {
+			report.Warnf("not removing %s: not a WORK directory of go build\n", workDir)
+			continue
+		}
+		remove(workDir)
+	}
+	remove(BuildCacheDir)
+	remove(DebugBuildDir)
+	for _, name := range runFiles {
+		remove(filepath.Join(MetadataDir, name))
+	}
+	remove(filepath.Join(MetadataDir, RunsDir))
+
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove %s", strings.Join(failed, ", "))
+	}
+	if removed == 0 {
+		report.Println("Nothing to remove: no instrumentation artifacts found.")
+		return nil
+	}
+	report.Printf("\n✅ Removed %d instrumentation artifact(s); %s is kept\n", removed, GetMetadataPath(BuildLogFile))
+	return nil
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// hcVersion returns the version of hc: the module version it was installed at, or the VCS
+// revision it was built from, with the Go version that built it
+func hcVersion() string {
+	v := "(devel)"
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return fmt.Sprintf("hc %s %s", v, runtime.Version())
+	}
+	if info.Main.Version != "" {
+		v = info.Main.Version
+	}
+	if v == "(devel)" {
+		var revision, modified string
+		for _, setting := range info.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				revision = setting.Value
+			case "vcs.modified":
+				modified = setting.Value
+			}
+		}
+		if len(revision) > 12 {
+			revision = revision[:12]
+		}
+		if revision != "" {
+			v += " " + revision
+			if modified == "true" {
+				v += "-dirty"
+			}
+		}
+	}
+	return fmt.Sprintf("hc %s %s", v, info.GoVersion)
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hc/analyze"
+	"github.com/pdelewski/go-build-interceptor/hc/parse"
+)
+
+// weavingTimingRuns is how many times each compile command is run by --weaving-report; the
+// fastest run is reported, which keeps the comparison stable on a busy machine
+const weavingTimingRuns = 3
+
+// WeavingReport is the --weaving-report result: what instrumentation added to every package
+// the modified build log compiles differently from the original one
+type WeavingReport struct {
+	Packages                 []WeavingPackage `json:"packages"`
+	LinesAdded               int              `json:"linesAdded"`
+	BytesAdded               int              `json:"bytesAdded"`
+	OriginalCompileMs        float64          `json:"originalCompileMs"`
+	InstrumentedCompileMs    float64          `json:"instrumentedCompileMs"`
+	OriginalArchiveBytes     int64            `json:"originalArchiveBytes"`
+	InstrumentedArchiveBytes int64            `json:"instrumentedArchiveBytes"`
+}
+
+// WeavingPackage is the instrumentation cost of one package. Compile times and archive sizes
+// are zero when the package could not be compiled again, with the reason in Error.
+type WeavingPackage struct {
+	Package                  string            `json:"package"`
+	LinesAdded               int               `json:"linesAdded"`
+	BytesAdded               int               `json:"bytesAdded"`
+	OriginalCompileMs        float64           `json:"originalCompileMs"`
+	InstrumentedCompileMs    float64           `json:"instrumentedCompileMs"`
+	OriginalArchiveBytes     int64             `json:"originalArchiveBytes"`
+	InstrumentedArchiveBytes int64             `json:"instrumentedArchiveBytes"`
+	Files                    []WeavingFile     `json:"files"`
+	Functions                []WeavingFunction `json:"functions"`
+	Error                    string            `json:"error,omitempty"`
+}
+
+// WeavingFile is a source file replaced by an instrumented copy or added to the package
+type WeavingFile struct {
+	File          string `json:"file"` // Original source file, or the generated file
+	Generated     bool   `json:"generated"`
+	OriginalLines int    `json:"originalLines"`
+	Lines         int    `json:"lines"`
+	OriginalBytes int    `json:"originalBytes"`
+	Bytes         int    `json:"bytes"`
+}
+
+// WeavingFunction is a function whose declaration instrumentation changed or added
+type WeavingFunction struct {
+	File          string `json:"file"`
+	Function      string `json:"function"` // Name, with the receiver for methods: "(*Server) Run"
+	OriginalLines int    `json:"originalLines"`
+	Lines         int    `json:"lines"`
+	OriginalBytes int    `json:"originalBytes"`
+	Bytes         int    `json:"bytes"`
+}
+
+// compileInvocation is a compile command of a build log with the directory it runs in
+type compileInvocation struct {
+	cmd     parse.Command
+	dir     string
+	workDir string
+}
+
+// buildWeavingReport compares the compile commands of the original build log with those of
+// the modified build log written by --compile. Both compile commands of every instrumented
+// package are run again into a temporary directory to measure their time and archive size,
+// which needs the WORK directory of the build to still exist.
+func buildWeavingReport(original []parse.Command, modifiedLog string) (*WeavingReport, error) {
+	modifiedParser := parse.NewParser()
+	if err := modifiedParser.ParseFile(modifiedLog); err != nil {
+		return nil, fmt.Errorf("no instrumented build found, run hc --compile first: %w", err)
+	}
+	originals := compileInvocations(original)
+	modified := compileInvocations(modifiedParser.GetCommands())
+
+	tmpDir, err := os.MkdirTemp("", "hc-weaving")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	var packageNames []string
+	for name := range modified {
+		if _, ok := originals[name]; ok {
+			packageNames = append(packageNames, name)
+		}
+	}
+	sort.Strings(packageNames)
+
+	result := &WeavingReport{Packages: []WeavingPackage{}}
+	for _, name := range packageNames {
+		before, after := originals[name], modified[name]
+		files := weavingFiles(before, after)
+		if len(files) == 0 {
+			continue
+		}
+
+		pkg := WeavingPackage{Package: name, Files: []WeavingFile{}, Functions: []WeavingFunction{}}
+		for _, file := range files {
+			pkg.Files = append(pkg.Files, file.WeavingFile)
+			pkg.LinesAdded += file.Lines - file.OriginalLines
+			pkg.BytesAdded += file.Bytes - file.OriginalBytes
+			functions, err := weavingFunctions(file.originalPath, file.path, file.File)
+			if err != nil {
+				report.Warnf("could not compare the functions of %s: %v\n", file.File, err)
+			}
+			pkg.Functions = append(pkg.Functions, functions...)
+		}
+
+		report.Printf("Compiling %s %d times without and with instrumentation...\n", name, weavingTimingRuns)
+		var errs []string
+		for _, side := range []struct {
+			invocation compileInvocation
+			dir        string
+			ms         *float64
+			size       *int64
+		}{
+			{before, "original", &pkg.OriginalCompileMs, &pkg.OriginalArchiveBytes},
+			{after, "instrumented", &pkg.InstrumentedCompileMs, &pkg.InstrumentedArchiveBytes},
+		} {
+			elapsed, size, err := timeCompile(side.invocation, filepath.Join(tmpDir, fmt.Sprintf("%s-%d", side.dir, len(result.Packages))))
+			if err != nil {
+				errs = append(errs, fmt.Sprintf("%s compile: %v", side.dir, err))
+				continue
+			}
+			*side.ms = float64(elapsed.Microseconds()) / 1000
+			*side.size = size
+		}
+		if len(errs) > 0 {
+			pkg.Error = strings.Join(errs, "; ")
+			pkg.OriginalCompileMs, pkg.InstrumentedCompileMs = 0, 0
+			pkg.OriginalArchiveBytes, pkg.InstrumentedArchiveBytes = 0, 0
+			report.Warnf("could not measure %s: %s\n", name, pkg.Error)
+		}
+
+		result.LinesAdded += pkg.LinesAdded
+		result.BytesAdded += pkg.BytesAdded
+		result.OriginalCompileMs += pkg.OriginalCompileMs
+		result.InstrumentedCompileMs += pkg.InstrumentedCompileMs
+		result.OriginalArchiveBytes += pkg.OriginalArchiveBytes
+		result.InstrumentedArchiveBytes += pkg.InstrumentedArchiveBytes
+		result.Packages = append(result.Packages, pkg)
+	}
+	return result, nil
+}
+
+// compileInvocations returns the first compile command of every package of a build log,
+// with the directory it runs in
+func compileInvocations(commands []parse.Command) map[string]compileInvocation {
+	invocations := make(map[string]compileInvocation)
+	cwd, _ := os.Getwd()
+	workDir := ""
+	for _, cmd := range commands {
+		if value, ok := workAssignment(&cmd); ok {
+			workDir = value
+			continue
+		}
+		if cmd.Executable == "cd" && len(cmd.Args) == 1 {
+			dir := strings.ReplaceAll(cmd.Args[0], "$WORK", workDir)
+			if !filepath.IsAbs(dir) {
+				dir = filepath.Join(cwd, dir)
+			}
+			cwd = dir
+			continue
+		}
+		if !parse.IsCompileCommand(&cmd) {
+			continue
+		}
+		name := parse.ExtractPackageName(&cmd)
+		if _, seen := invocations[name]; name == "" || seen {
+			continue
+		}
+		invocations[name] = compileInvocation{cmd: cmd, dir: cwd, workDir: workDir}
+	}
+	return invocations
+}
+
+// weavingFile is a WeavingFile with the paths of both versions
+type weavingFile struct {
+	WeavingFile
+	originalPath string // Empty for generated files
+	path         string
+}
+
+// weavingFiles returns the Go files of a package that differ between its original and its
+// instrumented compile command. Instrumented copies keep the name of the file they replace.
+func weavingFiles(before, after compileInvocation) []weavingFile {
+	resolve := func(invocation compileInvocation, file string) string {
+		file = strings.ReplaceAll(file, "$WORK", invocation.workDir)
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(invocation.dir, file)
+		}
+		return file
+	}
+	originalFiles := make(map[string]string)
+	for _, file := range parse.ExtractPackFiles(&before.cmd) {
+		if strings.HasSuffix(file, ".go") {
+			originalFiles[filepath.Base(file)] = resolve(before, file)
+		}
+	}
+
+	var files []weavingFile
+	for _, file := range parse.ExtractPackFiles(&after.cmd) {
+		if !strings.HasSuffix(file, ".go") {
+			continue
+		}
+		path := resolve(after, file)
+		originalPath, replaced := originalFiles[filepath.Base(file)]
+		if replaced && originalPath == path {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			report.Warnf("could not read %s: %v\n", path, err)
+			continue
+		}
+		wf := weavingFile{path: path}
+		wf.File = filepath.Base(path)
+		wf.Lines, wf.Bytes = bytes.Count(data, []byte("\n")), len(data)
+		if replaced {
+			original, err := os.ReadFile(originalPath)
+			if err != nil {
+				report.Warnf("could not read %s: %v\n", originalPath, err)
+				continue
+			}
+			wf.File = originalPath
+			wf.originalPath = originalPath
+			wf.OriginalLines, wf.OriginalBytes = bytes.Count(original, []byte("\n")), len(original)
+		} else {
+			wf.Generated = true
+		}
+		files = append(files, wf)
+	}
+	return files
+}
+
+// weavingFunctions returns the functions of an instrumented file whose declaration differs
+// in size from the original one, or that the original file doesn't declare
+func weavingFunctions(originalPath, path, file string) ([]WeavingFunction, error) {
+	var original map[string]WeavingFunction
+	if originalPath != "" {
+		var err error
+		if original, err = functionSizes(originalPath); err != nil {
+			return nil, err
+		}
+	}
+	instrumented, err := functionSizes(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var changed []WeavingFunction
+	for name, fn := range instrumented {
+		before := original[name]
+		if before.Bytes == fn.Bytes && before.Lines == fn.Lines {
+			continue
+		}
+		changed = append(changed, WeavingFunction{
+			File:          file,
+			Function:      name,
+			OriginalLines: before.Lines,
+			Lines:         fn.Lines,
+			OriginalBytes: before.Bytes,
+			Bytes:         fn.Bytes,
+		})
+	}
+	sort.Slice(changed, func(i, j int) bool { return changed[i].Function < changed[j].Function })
+	return changed, nil
+}
+
+// functionSizes returns the number of lines and bytes of every function declared in a file,
+// from the func keyword to the closing brace
+func functionSizes(path string) (map[string]WeavingFunction, error) {
+	fset := token.NewFileSet()
+	node, err := parser.ParseFile(fset, path, nil, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, err
+	}
+	sizes := make(map[string]WeavingFunction)
+	for _, decl := range node.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+		name := fn.Name.Name
+		if receiver := analyze.ReceiverType(fn); receiver != "" {
+			name = fmt.Sprintf("(%s) %s", receiver, name)
+		}
+		start, end := fset.Position(fn.Pos()), fset.Position(fn.End())
+		sizes[name] = WeavingFunction{Function: name, Lines: end.Line - start.Line + 1, Bytes: end.Offset - start.Offset}
+	}
+	return sizes, nil
+}
+
+// timeCompile runs a compile command weavingTimingRuns times with its outputs redirected to
+// outDir, and returns the fastest run and the size of the archive it wrote
+func timeCompile(invocation compileInvocation, outDir string) (time.Duration, int64, error) {
+	if err := os.MkdirAll(outDir, 0755); err != nil {
+		return 0, 0, err
+	}
+	line := invocation.cmd.String()
+	archive := ""
+	words := invocation.cmd.Args
+	for i, arg := range words {
+		if (arg != "-o" && arg != "-asmhdr") || i+1 >= len(words) {
+			continue
+		}
+		target := filepath.Join(outDir, filepath.Base(words[i+1]))
+		if arg == "-o" {
+			archive = target
+		}
+		line = strings.Replace(line, " "+arg+" "+words[i+1], " "+arg+" "+quoteShell(target), 1)
+	}
+	if archive == "" {
+		return 0, 0, fmt.Errorf("compile command has no -o output")
+	}
+	script := fmt.Sprintf("WORK=%s\ncd %s\n%s", quoteShell(invocation.workDir), quoteShell(invocation.dir), line)
+
+	var fastest time.Duration
+	for run := 0; run < weavingTimingRuns; run++ {
+		var stderr bytes.Buffer
+		cmd := exec.Command("bash", "-e", "-c", script)
+		cmd.Env = os.Environ()
+		cmd.Stderr = &stderr
+		start := time.Now()
+		if err := cmd.Run(); err != nil {
+			return 0, 0, fmt.Errorf("%v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		if elapsed := time.Since(start); run == 0 || elapsed < fastest {
+			fastest = elapsed
+		}
+	}
+	info, err := os.Stat(archive)
+	if err != nil {
+		return 0, 0, err
+	}
+	return fastest, info.Size(), nil
+}
+
+// printWeavingReport writes a table of the instrumented packages with the files and functions
+// instrumentation changed in each. Functions of generated files are only part of the JSON
+// output, as every one of them is new.
+func printWeavingReport(result *WeavingReport) {
+	if len(result.Packages) == 0 {
+		report.Resultln("No instrumented packages found in " + GetMetadataPath(BuildModifiedLogFile) + ".")
+		return
+	}
+
+	t := report.newTable("")
+	t.header("PACKAGE", "LINES", "BYTES", "COMPILE TIME", "ARCHIVE SIZE")
+	for _, pkg := range result.Packages {
+		compileTime, archiveSize := styled(styleYellow, "n/a"), styled(styleYellow, "n/a")
+		if pkg.Error == "" {
+			compileTime = cell{text: formatChange(pkg.OriginalCompileMs, pkg.InstrumentedCompileMs, formatMillis)}
+			archiveSize = cell{text: formatChange(float64(pkg.OriginalArchiveBytes), float64(pkg.InstrumentedArchiveBytes), formatBytes)}
+		}
+		t.row(styled(styleCyan, pkg.Package), cell{text: fmt.Sprintf("%+d", pkg.LinesAdded)},
+			cell{text: fmt.Sprintf("%+d", pkg.BytesAdded)}, compileTime, archiveSize)
+		for _, file := range pkg.Files {
+			name := "  " + file.File
+			if file.Generated {
+				name += " (generated)"
+			}
+			t.row(styled(styleDim, name), cell{text: fmt.Sprintf("%+d", file.Lines-file.OriginalLines)},
+				cell{text: fmt.Sprintf("%+d", file.Bytes-file.OriginalBytes)})
+			if file.Generated {
+				continue
+			}
+			for _, fn := range pkg.Functions {
+				if fn.File == file.File {
+					t.row(cell{text: "    " + fn.Function}, cell{text: fmt.Sprintf("%+d", fn.Lines-fn.OriginalLines)},
+						cell{text: fmt.Sprintf("%+d", fn.Bytes-fn.OriginalBytes)})
+				}
+			}
+		}
+	}
+	t.flush()
+
+	report.Resultf("\nTotal: %+d lines, %+d bytes in %d package(s); compile time %s, archive size %s\n",
+		result.LinesAdded, result.BytesAdded, len(result.Packages),
+		formatChange(result.OriginalCompileMs, result.InstrumentedCompileMs, formatMillis),
+		formatChange(float64(result.OriginalArchiveBytes), float64(result.InstrumentedArchiveBytes), formatBytes))
+}
+
+// formatChange formats a measurement before and after instrumentation with the relative change
+func formatChange(before, after float64, format func(float64) string) string {
+	if before == 0 {
+		return fmt.Sprintf("%s -> %s", format(before), format(after))
+	}
+	return fmt.Sprintf("%s -> %s (%+.1f%%)", format(before), format(after), (after-before)/before*100)
+}
+
+// formatMillis formats a duration in milliseconds
+func formatMillis(ms float64) string {
+	return fmt.Sprintf("%.1fms", ms)
+}
+
+// formatBytes formats a size in bytes with a binary unit
+func formatBytes(n float64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MiB", n/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KiB", n/(1<<10))
+	default:
+		return fmt.Sprintf("%.0f B", n)
+	}
+}
@@ -0,0 +1,20 @@
+package hooks
+
+// HookFunc is the signature of Before/After hook implementations
+type HookFunc func(ctx HookContext)
+
+// hookTable maps fully qualified hook names (importPath.FuncName) to their
+// implementations. It is populated from init functions in the generated
+// otel.runtime.go when the "shim" code generation backend is used, so no
+// locking is needed.
+var hookTable = make(map[string]HookFunc)
+
+// RegisterHook registers a hook implementation under its fully qualified name
+func RegisterHook(name string, fn HookFunc) {
+	hookTable[name] = fn
+}
+
+// LookupHook returns the hook registered under name, or nil if none is registered
+func LookupHook(name string) HookFunc {
+	return hookTable[name]
+}
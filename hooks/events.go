@@ -0,0 +1,66 @@
+package hooks
+
+import _ "unsafe" // Required for go:linkname
+
+// HookEventsEnv is the environment variable making the program trace its first N hook calls:
+// each Before and After hook call is printed to stderr as a line
+//
+//	hc-hook-event <start> <duration> <hook> <package>.<function>
+//
+// with start in nanoseconds since the hooks library was initialized and duration in
+// nanoseconds. The web UI reads these lines to show the hook calls on its timeline.
+const HookEventsEnv = "HC_HOOK_EVENTS"
+
+// HookEventPrefix starts the lines of traced hook calls
+const HookEventPrefix = "hc-hook-event"
+
+// nanotime returns the monotonic time of the runtime, which the hooks library reads directly
+// like the environment
+//
+//go:linkname nanotime runtime.nanotime
+func nanotime() int64
+
+// eventsTraced is set once when the program starts, so untraced hook calls don't take
+// eventsLock, which guards the number of calls still to trace
+var (
+	eventsTraced bool
+	eventsLock   = make(chan struct{}, 1)
+	eventsLeft   uint64
+	eventsOrigin int64
+)
+
+func init() {
+	spec, ok := lookupEnv(HookEventsEnv)
+	if !ok || spec == "" {
+		return
+	}
+	var n uint64
+	for i := 0; i < len(spec); i++ {
+		if spec[i] < '0' || spec[i] > '9' {
+			println("hooks: ignoring invalid", HookEventsEnv+"="+spec)
+			return
+		}
+		n = n*10 + uint64(spec[i]-'0')
+	}
+	eventsOrigin = nanotime()
+	eventsLeft = n
+	eventsTraced = n > 0
+}
+
+// traceHookCall prints a hook call that ran from start to end, unless the maximum number of
+// calls was traced already
+func traceHookCall(hook string, ctx HookContext, start, end int64) {
+	eventsLock <- struct{}{}
+	if eventsLeft == 0 {
+		<-eventsLock
+		return
+	}
+	eventsLeft--
+	<-eventsLock
+
+	target := ""
+	if ctx != nil {
+		target = ctx.GetPackageName() + "." + ctx.GetFuncName()
+	}
+	println(HookEventPrefix, start-eventsOrigin, end-start, hook, target)
+}
@@ -0,0 +1,69 @@
+// Package hookstest provides a hooks.HookContext for testing hook functions outside
+// instrumented builds, where the generated trampoline code provides it.
+package hookstest
+
+import "github.com/pdelewski/go-build-interceptor/hooks"
+
+// Context is a hooks.HookContext whose call is described by its fields. Tests set Results and
+// Panic between calling a Before and an After hook, like the trampoline after the call.
+type Context struct {
+	PackageName string
+	FuncName    string
+	Args        []interface{}
+	Results     []interface{}
+	Panic       interface{} // Value the function panicked with, nil if it returned
+	Data        interface{}
+	SkipCall    bool
+	Recovered   bool // Set by RecoverPanic when the function panicked
+
+	keyData map[string]interface{}
+}
+
+var _ hooks.HookContext = (*Context)(nil)
+
+// NewContext returns a Context for a call of funcName in packageName
+func NewContext(packageName, funcName string) *Context {
+	return &Context{PackageName: packageName, FuncName: funcName}
+}
+
+func (c *Context) SetData(data interface{}) { c.Data = data }
+func (c *Context) GetData() interface{}     { return c.Data }
+
+func (c *Context) SetKeyData(key string, val interface{}) {
+	if c.keyData == nil {
+		c.keyData = make(map[string]interface{})
+	}
+	c.keyData[key] = val
+}
+
+func (c *Context) GetKeyData(key string) interface{} { return c.keyData[key] }
+
+func (c *Context) HasKeyData(key string) bool {
+	_, ok := c.keyData[key]
+	return ok
+}
+
+func (c *Context) SetSkipCall(skip bool) { c.SkipCall = skip }
+func (c *Context) IsSkipCall() bool      { return c.SkipCall }
+
+func (c *Context) GetFuncName() string    { return c.FuncName }
+func (c *Context) GetPackageName() string { return c.PackageName }
+
+func (c *Context) GetArgs() []interface{}    { return c.Args }
+func (c *Context) GetResults() []interface{} { return c.Results }
+
+// SetReturnValue replaces result i, out of range indexes are ignored like in the trampolines
+func (c *Context) SetReturnValue(i int, value interface{}) {
+	if i >= 0 && i < len(c.Results) {
+		c.Results[i] = value
+	}
+}
+
+func (c *Context) GetPanic() interface{} { return c.Panic }
+
+// RecoverPanic marks the panic recovered, it does nothing if the function didn't panic
+func (c *Context) RecoverPanic() {
+	if c.Panic != nil {
+		c.Recovered = true
+	}
+}
@@ -0,0 +1,35 @@
+package hookstest
+
+import "testing"
+
+func TestContext(t *testing.T) {
+	ctx := NewContext("example.com/app", "Handle")
+	if ctx.GetPackageName() != "example.com/app" || ctx.GetFuncName() != "Handle" {
+		t.Errorf("expected example.com/app.Handle, got %s.%s", ctx.GetPackageName(), ctx.GetFuncName())
+	}
+
+	if ctx.HasKeyData("start") {
+		t.Error("expected no key data before SetKeyData")
+	}
+	ctx.SetKeyData("start", 1)
+	if !ctx.HasKeyData("start") || ctx.GetKeyData("start") != 1 {
+		t.Errorf("expected key data 1, got %v", ctx.GetKeyData("start"))
+	}
+
+	ctx.Results = []interface{}{"a", nil}
+	ctx.SetReturnValue(1, "b")
+	ctx.SetReturnValue(2, "ignored")
+	if ctx.GetResults()[1] != "b" || len(ctx.GetResults()) != 2 {
+		t.Errorf("expected results [a b], got %v", ctx.GetResults())
+	}
+
+	ctx.RecoverPanic()
+	if ctx.Recovered {
+		t.Error("expected RecoverPanic to do nothing without a panic")
+	}
+	ctx.Panic = "boom"
+	ctx.RecoverPanic()
+	if !ctx.Recovered {
+		t.Error("expected RecoverPanic to recover the panic")
+	}
+}
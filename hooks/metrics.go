@@ -0,0 +1,21 @@
+package hooks
+
+// MetricsSink receives metrics of the hooks runtime as they change, e.g. to export them to a
+// monitoring system. It is called from the goroutine that ran the hook, so it must be safe
+// for concurrent use.
+type MetricsSink interface {
+	// HookPanicked is called after the hook with the given fully qualified name panicked,
+	// with its number of panics so far and whether it is disabled from now on
+	HookPanicked(hook string, panics uint64, disabled bool)
+}
+
+// metricsSink is guarded by panicsLock
+var metricsSink MetricsSink
+
+// SetMetricsSink sets the sink receiving the metrics of the hooks runtime, or removes it
+// when sink is nil
+func SetMetricsSink(sink MetricsSink) {
+	lockPanics()
+	metricsSink = sink
+	unlockPanics()
+}
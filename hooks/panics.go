@@ -0,0 +1,216 @@
+package hooks
+
+// PanicPolicy is what the generated trampolines do when a Before or After hook panics
+type PanicPolicy string
+
+const (
+	// PanicLog prints the panic and keeps calling the hook. It is the default.
+	PanicLog PanicPolicy = "log"
+	// PanicCount counts the panic without printing it
+	PanicCount PanicPolicy = "count"
+	// PanicDisable prints the panic and stops calling the hook once it panicked the maximum
+	// number of times
+	PanicDisable PanicPolicy = "disable"
+	// PanicPropagate panics again in the instrumented function, as if the hook was part of it
+	PanicPropagate PanicPolicy = "propagate"
+)
+
+// PanicPolicyEnv is the environment variable selecting the panic policy when the program
+// starts: log, count, propagate, disable or disable:N. It overrides the policy built into the
+// program from the hookPanicPolicy setting of .hc.json.
+const PanicPolicyEnv = "HC_HOOK_PANIC_POLICY"
+
+// DefaultMaxPanics is the number of panics after which PanicDisable disables a hook
+const DefaultMaxPanics = 1
+
+// HookPanics counts the panics of a Before or After hook. Every panic is counted, whatever
+// the policy.
+type HookPanics struct {
+	name     string
+	count    uint64
+	disabled chan struct{} // Closed once the hook is disabled
+}
+
+// The hooks library can't import sync, since trampolines may be injected into the packages
+// sync depends on, so a channel guards the policy and the counts
+var (
+	panicsLock    = make(chan struct{}, 1)
+	panicPolicy   = PanicLog
+	maxPanics     = uint64(DefaultMaxPanics)
+	policyFromEnv bool
+	hookPanics    = make(map[string]*HookPanics)
+)
+
+func lockPanics()   { panicsLock <- struct{}{} }
+func unlockPanics() { <-panicsLock }
+
+func init() {
+	spec, ok := lookupEnv(PanicPolicyEnv)
+	if !ok || spec == "" {
+		return
+	}
+	policy, max, ok := ParsePanicPolicy(spec)
+	if !ok {
+		println("hooks: ignoring invalid", PanicPolicyEnv+"="+spec)
+		return
+	}
+	SetPanicPolicy(policy, max)
+	policyFromEnv = true
+}
+
+// ParsePanicPolicy parses a policy spec: log, count, propagate, disable or disable:N, where N
+// is the number of panics after which the hook is disabled
+func ParsePanicPolicy(spec string) (policy PanicPolicy, max uint64, ok bool) {
+	name, count := spec, ""
+	for i := 0; i < len(spec); i++ {
+		if spec[i] == ':' {
+			name, count = spec[:i], spec[i+1:]
+			break
+		}
+	}
+	switch PanicPolicy(name) {
+	case PanicLog, PanicCount, PanicPropagate:
+		return PanicPolicy(name), DefaultMaxPanics, len(name) == len(spec)
+	case PanicDisable:
+		if len(name) == len(spec) {
+			return PanicDisable, DefaultMaxPanics, true
+		}
+		if count == "" {
+			return "", 0, false
+		}
+		for i := 0; i < len(count); i++ {
+			if count[i] < '0' || count[i] > '9' {
+				return "", 0, false
+			}
+			max = max*10 + uint64(count[i]-'0')
+		}
+		return PanicDisable, max, max > 0
+	}
+	return "", 0, false
+}
+
+// SetPanicPolicy sets the panic policy of all hooks. max is the number of panics after which
+// PanicDisable disables a hook.
+func SetPanicPolicy(policy PanicPolicy, max uint64) {
+	if max == 0 {
+		max = DefaultMaxPanics
+	}
+	lockPanics()
+	panicPolicy = policy
+	maxPanics = max
+	unlockPanics()
+}
+
+// ConfigurePanicPolicy sets the policy built into the program, unless HC_HOOK_PANIC_POLICY
+// selected one. It is called by the generated otel.runtime.go.
+func ConfigurePanicPolicy(spec string) {
+	if policyFromEnv {
+		return
+	}
+	policy, max, ok := ParsePanicPolicy(spec)
+	if !ok {
+		println("hooks: ignoring invalid panic policy", spec)
+		return
+	}
+	SetPanicPolicy(policy, max)
+}
+
+// Panics returns the panic counter of the hook with the given fully qualified name. The
+// generated trampolines keep one for each of their Before and After hooks.
+func Panics(name string) *HookPanics {
+	lockPanics()
+	defer unlockPanics()
+	h, ok := hookPanics[name]
+	if !ok {
+		h = &HookPanics{name: name, disabled: make(chan struct{})}
+		hookPanics[name] = h
+	}
+	return h
+}
+
+// CallHook calls a Before or After hook of a trampoline under the panic policy: it isn't
+// called once disabled, and its panics are counted by panics and recovered, unless the
+// policy propagates them. The call is traced when HC_HOOK_EVENTS asks for it.
+func CallHook(panics *HookPanics, hook func(HookContext), ctx HookContext) {
+	if panics.Disabled() {
+		return
+	}
+	if eventsTraced {
+		start := nanotime()
+		defer func() {
+			traceHookCall(panics.name, ctx, start, nanotime())
+		}()
+	}
+	defer func() {
+		if err := recover(); err != nil {
+			panics.Recovered(err)
+		}
+	}()
+	hook(ctx)
+}
+
+// Disabled reports whether the hook was disabled by PanicDisable and must not be called
+func (h *HookPanics) Disabled() bool {
+	select {
+	case <-h.disabled:
+		return true
+	default:
+		return false
+	}
+}
+
+// Recovered applies the panic policy to a panic of the hook, recovered by its trampoline.
+// Under PanicPropagate it panics again with value.
+func (h *HookPanics) Recovered(value interface{}) {
+	lockPanics()
+	h.count++
+	count := h.count
+	policy := panicPolicy
+	disabled := false
+	if policy == PanicDisable && count >= maxPanics && !h.Disabled() {
+		close(h.disabled)
+		disabled = true
+	}
+	sink := metricsSink
+	unlockPanics()
+
+	switch policy {
+	case PanicLog, PanicDisable:
+		println("failed to exec hook", h.name+":", panicText(value))
+		if disabled {
+			println("hook", h.name, "disabled after", count, "panics")
+		}
+	}
+	if sink != nil {
+		sink.HookPanicked(h.name, count, h.Disabled())
+	}
+	if policy == PanicPropagate {
+		panic(value)
+	}
+}
+
+// PanicCounts returns the number of panics of every hook that panicked, by name
+func PanicCounts() map[string]uint64 {
+	lockPanics()
+	defer unlockPanics()
+	counts := make(map[string]uint64)
+	for name, h := range hookPanics {
+		if h.count > 0 {
+			counts[name] = h.count
+		}
+	}
+	return counts
+}
+
+// panicText returns a panic value as text, without fmt
+func panicText(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case error:
+		return v.Error()
+	case interface{ String() string }:
+		return v.String()
+	}
+	return "non-string panic value"
+}
@@ -0,0 +1,19 @@
+package hooks
+
+import _ "unsafe" // Required for go:linkname
+
+// runtimeEnvs returns the environment of the process. The hooks library can't import os or
+// syscall, so it asks the runtime for it, like syscall does.
+//
+//go:linkname runtimeEnvs syscall.runtime_envs
+func runtimeEnvs() []string
+
+// lookupEnv returns the value of the environment variable name
+func lookupEnv(name string) (string, bool) {
+	for _, env := range runtimeEnvs() {
+		if len(env) > len(name) && env[len(name)] == '=' && env[:len(name)] == name {
+			return env[len(name)+1:], true
+		}
+	}
+	return "", false
+}
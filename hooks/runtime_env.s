@@ -0,0 +1,2 @@
+// Lets the hooks library declare functions the runtime provides, like runtimeEnvs and sleep,
+// without a body.
@@ -0,0 +1,145 @@
+package hooks
+
+import _ "unsafe" // Required for go:linkname
+
+// ShutdownTimeoutEnv is the environment variable limiting how long the shutdown sequence may
+// take, as a number of ms, s or m, e.g. 500ms or 10s. 0 waits for it however long it takes.
+const ShutdownTimeoutEnv = "HC_SHUTDOWN_TIMEOUT"
+
+// DefaultShutdownTimeout is the time in nanoseconds the shutdown sequence may take unless
+// HC_SHUTDOWN_TIMEOUT sets another
+const DefaultShutdownTimeout = 5 * 1000 * 1000 * 1000
+
+// Flusher is implemented by sinks that buffer what they receive, e.g. a MetricsSink exporting
+// in batches. Shutdown flushes the metrics sink when it implements Flusher.
+type Flusher interface {
+	Flush()
+}
+
+// sleep pauses the calling goroutine for ns nanoseconds; the runtime implements time.Sleep
+//
+//go:linkname sleep time.Sleep
+func sleep(ns int64)
+
+// shutdownLock guards the finalizers and the state of the shutdown sequence, which starts once
+var (
+	shutdownLock = make(chan struct{}, 1)
+	finalizers   []func()
+	shutdownDone chan struct{} // Closed when the sequence finished, nil until it started
+)
+
+// OnShutdown registers f to run in the shutdown sequence, after the metrics sink was flushed.
+// Finalizers run one after the other in reverse order of registration, like deferred calls,
+// so what a bundle registers first is stopped last. Finalizers registered once the sequence
+// started don't run.
+func OnShutdown(f func()) {
+	shutdownLock <- struct{}{}
+	if shutdownDone == nil {
+		finalizers = append(finalizers, f)
+	}
+	<-shutdownLock
+}
+
+// Shutdown runs the shutdown sequence of the hooks runtime:
+//
+//  1. the metrics sink is flushed, if it implements Flusher
+//  2. the finalizers registered with OnShutdown run, the last registered first
+//
+// A step that panics is reported on stderr and the sequence goes on. Shutdown returns when
+// the sequence finished, or when HC_SHUTDOWN_TIMEOUT (5s by default) expired, abandoning the
+// steps still running. Instrumented programs call it when main.main returns, after the After
+// hooks of main.main ran; programs leaving with os.Exit must call it before. The sequence runs
+// once: later calls wait for it like the first.
+func Shutdown() {
+	shutdownLock <- struct{}{}
+	if shutdownDone == nil {
+		shutdownDone = make(chan struct{})
+		go runShutdown(finalizers, shutdownDone)
+		finalizers = nil
+	}
+	done := shutdownDone
+	<-shutdownLock
+
+	timeout, spec := shutdownTimeout()
+	if timeout == 0 {
+		<-done
+		return
+	}
+	expired := make(chan struct{})
+	go func() {
+		sleep(timeout)
+		close(expired)
+	}()
+	select {
+	case <-done:
+	case <-expired:
+		println("hooks: shutdown did not finish within", spec+", exiting anyway")
+	}
+}
+
+// runShutdown runs the steps of the shutdown sequence and closes done
+func runShutdown(finalizers []func(), done chan struct{}) {
+	defer close(done)
+
+	lockPanics()
+	sink := metricsSink
+	unlockPanics()
+	if flusher, ok := sink.(Flusher); ok {
+		runShutdownStep("metrics sink flush", flusher.Flush)
+	}
+	for i := len(finalizers) - 1; i >= 0; i-- {
+		runShutdownStep("finalizer", finalizers[i])
+	}
+}
+
+// runShutdownStep runs a step of the shutdown sequence, reporting its panic
+func runShutdownStep(step string, f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			println("hooks: shutdown", step, "panicked:", panicText(err))
+		}
+	}()
+	f()
+}
+
+// shutdownTimeout returns the time in nanoseconds the shutdown sequence may take, with the
+// text it was given as
+func shutdownTimeout() (int64, string) {
+	spec, ok := lookupEnv(ShutdownTimeoutEnv)
+	if !ok || spec == "" {
+		return DefaultShutdownTimeout, "5s"
+	}
+	timeout, ok := parseTimeout(spec)
+	if !ok {
+		println("hooks: ignoring invalid", ShutdownTimeoutEnv+"="+spec)
+		return DefaultShutdownTimeout, "5s"
+	}
+	return timeout, spec
+}
+
+// parseTimeout parses a whole number of ms, s or m into nanoseconds; a bare 0 is allowed
+func parseTimeout(spec string) (int64, bool) {
+	digits := 0
+	var n int64
+	for digits < len(spec) && spec[digits] >= '0' && spec[digits] <= '9' {
+		n = n*10 + int64(spec[digits]-'0')
+		if n > 1<<31 {
+			return 0, false
+		}
+		digits++
+	}
+	if digits == 0 {
+		return 0, false
+	}
+	switch spec[digits:] {
+	case "ms":
+		return n * 1000 * 1000, true
+	case "s":
+		return n * 1000 * 1000 * 1000, true
+	case "m":
+		return n * 60 * 1000 * 1000 * 1000, true
+	case "":
+		return 0, n == 0
+	}
+	return 0, false
+}
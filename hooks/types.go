@@ -7,13 +7,27 @@ type Hook struct {
 	Target  InjectTarget
 	Hooks   *InjectFunctions // Optional: for before/after hooks
 	Rewrite interface{}      // Optional: FunctionRewriteHook for rewriting entire function
+
+	// Priority orders the hooks matching the same function: Before hooks are called in
+	// ascending priority and After hooks in descending priority, so the first hook called
+	// before the function is the last one called after it. Hooks of equal priority keep the
+	// order they are loaded in, exact targets before patterns. Only the first Rewrite applies.
+	Priority int
 }
 
-// InjectTarget specifies the target function to instrument
+// InjectTarget specifies the target function to instrument. Each field may also be a
+// glob ("Handle*"), a package subtree ("github.com/myapp/...") or a regular expression
+// prefixed with "regexp:" to instrument every matching function.
+//
+// File instruments the functions declared in the matching source files instead, e.g.
+// "handlers/*.go". A glob is matched against as many trailing elements of the file path as
+// it has, a regular expression against the whole absolute path. With File, an empty Package,
+// Function or Receiver matches any, so every function and method of the files is instrumented.
 type InjectTarget struct {
 	Package  string
 	Function string
 	Receiver string
+	File     string
 }
 
 // InjectFunctions specifies the before/after hook functions
@@ -35,6 +49,21 @@ type HookContext interface {
 	IsSkipCall() bool
 	GetFuncName() string
 	GetPackageName() string
+	// GetArgs returns the arguments of the instrumented call: the receiver first for
+	// methods, then the parameters in declaration order
+	GetArgs() []interface{}
+	// GetResults returns the values the instrumented call returned; nil in Before hooks
+	GetResults() []interface{}
+	// SetReturnValue replaces the i-th value the instrumented call returns, e.g. to wrap its
+	// error. Only After hooks can set results; a value of another type than the result is
+	// ignored, and nil sets it to its zero value.
+	SetReturnValue(i int, value interface{})
+	// GetPanic returns the value the instrumented call panicked with, or nil when it returned
+	// normally; nil in Before hooks
+	GetPanic() interface{}
+	// RecoverPanic stops the panic of the instrumented call after the After hooks ran, so it
+	// returns its results, e.g. an error set with SetReturnValue. Without it the panic goes on.
+	RecoverPanic()
 }
 
 // StructField defines a field to be added to a struct
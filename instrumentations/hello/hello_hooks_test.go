@@ -65,6 +65,22 @@ func (m *MockHookContext) GetPackageName() string {
 	return m.packageName
 }
 
+func (m *MockHookContext) GetArgs() []interface{} {
+	return nil
+}
+
+func (m *MockHookContext) GetResults() []interface{} {
+	return nil
+}
+
+func (m *MockHookContext) SetReturnValue(i int, value interface{}) {}
+
+func (m *MockHookContext) GetPanic() interface{} {
+	return nil
+}
+
+func (m *MockHookContext) RecoverPanic() {}
+
 // Verify MockHookContext implements hooks.HookContext
 var _ hooks.HookContext = (*MockHookContext)(nil)
 
@@ -0,0 +1,100 @@
+package metrics_instrumentation
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/pdelewski/go-build-interceptor/hooks"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// MetricsAddrEnv is the environment variable making the program serve its metrics at
+// /metrics on an address, e.g. :9464. Without it, the metrics are only registered with the
+// default Prometheus registry, which programs serving their own metrics expose already.
+const MetricsAddrEnv = "HC_METRICS_ADDR"
+
+// startKey is the key of the time BeforeCall passes to AfterCall in the HookContext the hooks
+// of a call share
+const startKey = "metrics.start"
+
+// Metrics of the calls of the instrumented functions, by import path of their package and name
+var (
+	labelNames = []string{"package", "function"}
+
+	callsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hc_function_calls_total",
+		Help: "Calls of the instrumented functions that returned or panicked.",
+	}, labelNames)
+	errorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "hc_function_errors_total",
+		Help: "Calls of the instrumented functions that panicked or returned a non-nil error last.",
+	}, labelNames)
+	callDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "hc_function_duration_seconds",
+		Help:    "Duration of the calls of the instrumented functions.",
+		Buckets: prometheus.DefBuckets,
+	}, labelNames)
+)
+
+func init() {
+	prometheus.MustRegister(callsTotal, errorsTotal, callDuration)
+	if addr := os.Getenv(MetricsAddrEnv); addr != "" {
+		if _, err := serveMetrics(addr); err != nil {
+			fmt.Fprintf(os.Stderr, "metrics hooks: %v, not serving metrics\n", err)
+		}
+	}
+}
+
+// BeforeCall is a Before hook recording when the call of the hooked function started
+func BeforeCall(ctx hooks.HookContext) {
+	ctx.SetKeyData(startKey, time.Now())
+}
+
+// AfterCall is an After hook counting the call of the hooked function, as an error when it
+// panicked or returned a non-nil error last, and observing its duration
+func AfterCall(ctx hooks.HookContext) {
+	start, ok := ctx.GetKeyData(startKey).(time.Time)
+	if !ok {
+		return
+	}
+	labels := []string{ctx.GetPackageName(), ctx.GetFuncName()}
+	callsTotal.WithLabelValues(labels...).Inc()
+	callDuration.WithLabelValues(labels...).Observe(time.Since(start).Seconds())
+	// Created for every function, so that error rates of functions without errors are 0
+	errors := errorsTotal.WithLabelValues(labels...)
+	if failed(ctx) {
+		errors.Inc()
+	}
+}
+
+// failed reports whether a call panicked or returned a non-nil error last
+func failed(ctx hooks.HookContext) bool {
+	if ctx.GetPanic() != nil {
+		return true
+	}
+	results := ctx.GetResults()
+	if len(results) == 0 {
+		return false
+	}
+	err, _ := results[len(results)-1].(error)
+	return err != nil
+}
+
+// serveMetrics serves the metrics of the default Prometheus registry at /metrics on addr until
+// the hooks runtime shuts down, returning the address it listens on
+func serveMetrics(addr string) (net.Addr, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go server.Serve(listener)
+	hooks.OnShutdown(func() { server.Close() })
+	return listener.Addr(), nil
+}
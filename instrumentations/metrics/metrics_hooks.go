@@ -0,0 +1,25 @@
+package metrics_instrumentation
+
+import (
+	"github.com/pdelewski/go-build-interceptor/hooks"
+)
+
+// ProvideHooks returns the functions whose calls are measured. Add a hook with the BeforeCall
+// and AfterCall functions for the functions to measure; every function of the main packages
+// is measured by default.
+func ProvideHooks() []*hooks.Hook {
+	return []*hooks.Hook{
+		{
+			Target: hooks.InjectTarget{
+				Package:  "main",
+				Function: "*",
+				Receiver: "",
+			},
+			Hooks: &hooks.InjectFunctions{
+				Before: "BeforeCall",
+				After:  "AfterCall",
+				From:   "metrics_instrumentation",
+			},
+		},
+	}
+}
@@ -0,0 +1,98 @@
+package metrics_instrumentation
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pdelewski/go-build-interceptor/hooks/hookstest"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProvideHooks(t *testing.T) {
+	hookList := ProvideHooks()
+	if len(hookList) != 1 {
+		t.Fatalf("expected 1 hook, got %d", len(hookList))
+	}
+	hook := hookList[0]
+	if hook.Target.Package != "main" || hook.Target.Function != "*" {
+		t.Errorf("expected a hook on main.*, got %s.%s", hook.Target.Package, hook.Target.Function)
+	}
+	if hook.Hooks.Before != "BeforeCall" || hook.Hooks.After != "AfterCall" {
+		t.Errorf("expected BeforeCall/AfterCall, got %s/%s", hook.Hooks.Before, hook.Hooks.After)
+	}
+	if err := hook.Validate(); err != nil {
+		t.Errorf("hook validation failed: %v", err)
+	}
+}
+
+func TestAfterCall(t *testing.T) {
+	call := func(funcName string, results []interface{}, panicked interface{}) {
+		ctx := hookstest.NewContext("example.com/app", funcName)
+		BeforeCall(ctx)
+		ctx.Results = results
+		ctx.Panic = panicked
+		AfterCall(ctx)
+	}
+	call("Get", []interface{}{1, nil}, nil)
+	call("Get", []interface{}{0, errors.New("not found")}, nil)
+	call("Get", nil, "boom")
+	call("Put", nil, nil)
+
+	tests := []struct {
+		funcName string
+		calls    float64
+		errors   float64
+	}{
+		{"Get", 3, 2},
+		{"Put", 1, 0},
+	}
+	for _, tt := range tests {
+		if got := testutil.ToFloat64(callsTotal.WithLabelValues("example.com/app", tt.funcName)); got != tt.calls {
+			t.Errorf("%s: expected %v calls, got %v", tt.funcName, tt.calls, got)
+		}
+		if got := testutil.ToFloat64(errorsTotal.WithLabelValues("example.com/app", tt.funcName)); got != tt.errors {
+			t.Errorf("%s: expected %v errors, got %v", tt.funcName, tt.errors, got)
+		}
+	}
+	if got := testutil.CollectAndCount(callDuration, "hc_function_duration_seconds"); got != 2 {
+		t.Errorf("expected durations of 2 functions, got %d", got)
+	}
+}
+
+func TestAfterCallWithoutBeforeCall(t *testing.T) {
+	AfterCall(hookstest.NewContext("example.com/app", "Unmeasured"))
+	if got := testutil.ToFloat64(callsTotal.WithLabelValues("example.com/app", "Unmeasured")); got != 0 {
+		t.Errorf("expected no call counted without a start time, got %v", got)
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	addr, err := serveMetrics("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := hookstest.NewContext("example.com/app", "Served")
+	BeforeCall(ctx)
+	AfterCall(ctx)
+
+	resp, err := http.Get("http://" + addr.String() + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `hc_function_calls_total{function="Served",package="example.com/app"} 1`
+	if !strings.Contains(string(body), want) {
+		t.Errorf("expected /metrics to contain %q, got:\n%s", want, body)
+	}
+
+	if _, err := serveMetrics("invalid address"); err == nil {
+		t.Error("expected an error for an invalid address")
+	}
+}
@@ -0,0 +1,245 @@
+package nethttp_instrumentation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	_ "unsafe" // Required for go:linkname
+
+	"github.com/pdelewski/go-build-interceptor/hooks"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName is the instrumentation scope of the spans of the hooks
+const TracerName = "github.com/pdelewski/go-build-interceptor/instrumentations/nethttp"
+
+// exporterUserAgent starts the User-Agent of the requests of the OTLP/HTTP exporter, which
+// are not traced: exporting their spans would send more requests
+const exporterUserAgent = "OTel OTLP Exporter"
+
+// Keys of the data the Start hooks pass to the End hooks in the HookContext the hooks of a
+// call share
+const (
+	spanKey   = "nethttp.span"
+	parentKey = "nethttp.parent"
+)
+
+// The runtime instrumentation (instrumentations/runtime) adds the trace context of goroutines
+// to the runtime, copied to the goroutines they start
+
+//go:linkname getTraceContextFromGLS runtime.GetTraceContextFromGLS
+func getTraceContextFromGLS() interface{}
+
+//go:linkname setTraceContextToGLS runtime.SetTraceContextToGLS
+func setTraceContextToGLS(traceContext interface{})
+
+// tracer starts the spans with the tracer provider the otel instrumentation sets up, which
+// the global tracer delegates to once it is set
+var tracer = otel.Tracer(TracerName)
+
+// propagator reads the trace context of received requests and adds it to sent ones, in the
+// W3C traceparent, tracestate and baggage headers
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// StartServerSpan is a Before hook of net/http.serverHandler.ServeHTTP starting a server span
+// for the request, a child of the span of its traceparent header, which becomes the span of
+// the goroutine serving it and of the request's context until EndServerSpan
+func StartServerSpan(ctx hooks.HookContext) {
+	req := requestArg(ctx.GetArgs())
+	if req == nil {
+		return
+	}
+	spanCtx, span := startServerSpan(req)
+	ctx.SetKeyData(spanKey, span)
+	ctx.SetKeyData(parentKey, getTraceContextFromGLS())
+	setTraceContextToGLS(spanCtx)
+	// Handlers get the span from the request as they would with otelhttp
+	*req = *req.WithContext(spanCtx)
+}
+
+// EndServerSpan is an After hook of net/http.serverHandler.ServeHTTP ending the span
+// StartServerSpan started, named after the route of the ServeMux pattern that matched, and
+// restoring the span of the goroutine
+func EndServerSpan(ctx hooks.HookContext) {
+	span, ok := ctx.GetKeyData(spanKey).(trace.Span)
+	if !ok {
+		return
+	}
+	args := ctx.GetArgs()
+	var status int
+	if len(args) > 1 {
+		status = responseStatus(args[1])
+	}
+	endServerSpan(span, requestArg(args), status, ctx.GetPanic())
+	setTraceContextToGLS(ctx.GetKeyData(parentKey))
+}
+
+// StartClientSpan is a Before hook of net/http.(*Transport).RoundTrip starting a client span
+// for the request, a child of the span of the calling goroutine, and adding its trace context
+// to the request's headers
+func StartClientSpan(ctx hooks.HookContext) {
+	req := requestArg(ctx.GetArgs())
+	if req == nil || strings.HasPrefix(req.UserAgent(), exporterUserAgent) {
+		return
+	}
+	ctx.SetKeyData(spanKey, startClientSpan(parentContext(req), req))
+}
+
+// EndClientSpan is an After hook of net/http.(*Transport).RoundTrip ending the span
+// StartClientSpan started, with the status of the response or the error of the request
+func EndClientSpan(ctx hooks.HookContext) {
+	span, ok := ctx.GetKeyData(spanKey).(trace.Span)
+	if !ok {
+		return
+	}
+	var resp *http.Response
+	var err error
+	if results := ctx.GetResults(); len(results) == 2 {
+		resp, _ = results[0].(*http.Response)
+		err, _ = results[1].(error)
+	}
+	if recovered := ctx.GetPanic(); recovered != nil {
+		err = fmt.Errorf("panic: %v", recovered)
+	}
+	endClientSpan(span, resp, err)
+}
+
+// requestArg returns the request among the arguments of a hooked call, after its receiver
+func requestArg(args []interface{}) *http.Request {
+	for _, arg := range args {
+		if req, ok := arg.(*http.Request); ok {
+			return req
+		}
+	}
+	return nil
+}
+
+// parentContext returns the context client spans are children of: the span of the request's
+// context, as set by instrumented handlers or code using otel, or else the span of the calling
+// goroutine
+func parentContext(req *http.Request) context.Context {
+	if trace.SpanContextFromContext(req.Context()).IsValid() {
+		return req.Context()
+	}
+	if traceContext, ok := getTraceContextFromGLS().(context.Context); ok {
+		return traceContext
+	}
+	return req.Context()
+}
+
+// startServerSpan starts the span of a received request, a child of the span of its headers
+func startServerSpan(req *http.Request) (context.Context, trace.Span) {
+	parent := propagator.Extract(req.Context(), propagation.HeaderCarrier(req.Header))
+	return tracer.Start(parent, req.Method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.path", req.URL.Path),
+		attribute.String("url.scheme", requestScheme(req)),
+		attribute.String("server.address", req.Host),
+		attribute.String("user_agent.original", req.UserAgent()),
+		attribute.String("network.protocol.version", fmt.Sprintf("%d.%d", req.ProtoMajor, req.ProtoMinor)),
+	))
+}
+
+// endServerSpan ends the span of a served request, with an error status when its handler
+// panicked or it got a 5xx response
+func endServerSpan(span trace.Span, req *http.Request, status int, recovered interface{}) {
+	if req != nil {
+		if route := patternRoute(req.Pattern); route != "" {
+			span.SetName(req.Method + " " + route)
+			span.SetAttributes(attribute.String("http.route", route))
+		}
+	}
+	if status > 0 {
+		span.SetAttributes(attribute.Int("http.response.status_code", status))
+	}
+	switch {
+	case recovered != nil:
+		err := fmt.Errorf("panic: %v", recovered)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case status >= http.StatusInternalServerError:
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+	span.End()
+}
+
+// startClientSpan starts the span of a sent request and adds its trace context to the headers
+func startClientSpan(parent context.Context, req *http.Request) trace.Span {
+	spanCtx, span := tracer.Start(parent, req.Method, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("http.request.method", req.Method),
+		attribute.String("url.full", redactedURL(req)),
+		attribute.String("server.address", req.URL.Hostname()),
+	))
+	if req.Header == nil {
+		req.Header = make(http.Header)
+	}
+	propagator.Inject(spanCtx, propagation.HeaderCarrier(req.Header))
+	return span
+}
+
+// endClientSpan ends the span of a sent request, with an error status when it failed or got a
+// 4xx or 5xx response
+func endClientSpan(span trace.Span, resp *http.Response, err error) {
+	switch {
+	case err != nil:
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	case resp != nil:
+		span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+		if resp.StatusCode >= http.StatusBadRequest {
+			span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
+		}
+	}
+	span.End()
+}
+
+// patternRoute returns the path of a ServeMux pattern, e.g. /items/{id} for
+// "GET example.com/items/{id}", or "" without pattern
+func patternRoute(pattern string) string {
+	if i := strings.Index(pattern, "/"); i >= 0 {
+		return pattern[i:]
+	}
+	return ""
+}
+
+// requestScheme returns the scheme a request was received with
+func requestScheme(req *http.Request) string {
+	if req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// redactedURL returns the URL of a sent request without its credentials
+func redactedURL(req *http.Request) string {
+	if req.URL.User == nil {
+		return req.URL.String()
+	}
+	u := *req.URL
+	u.User = nil
+	return u.String()
+}
+
+// responseStatus returns the status code a server handler wrote to its ResponseWriter, read
+// from the status field of the server's response, 200 when it wrote none, as the server then
+// replies, or 0 for other ResponseWriters
+func responseStatus(rw interface{}) int {
+	v := reflect.ValueOf(rw)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return 0
+	}
+	status := v.Elem().FieldByName("status")
+	if !status.IsValid() || status.Kind() != reflect.Int {
+		return 0
+	}
+	if status.Int() == 0 {
+		return http.StatusOK
+	}
+	return int(status.Int())
+}
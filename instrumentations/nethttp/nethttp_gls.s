@@ -0,0 +1,2 @@
+// Lets nethttp.go declare the goroutine local storage accessors of the runtime
+// instrumentation without a body.
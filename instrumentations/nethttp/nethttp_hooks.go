@@ -0,0 +1,37 @@
+package nethttp_instrumentation
+
+import (
+	"github.com/pdelewski/go-build-interceptor/hooks"
+)
+
+// ProvideHooks returns the net/http functions traced: the handling of every request a server
+// receives, whatever handler or framework serves it, and every request the transport of a
+// client sends.
+func ProvideHooks() []*hooks.Hook {
+	return []*hooks.Hook{
+		{
+			Target: hooks.InjectTarget{
+				Package:  "net/http",
+				Function: "ServeHTTP",
+				Receiver: "serverHandler",
+			},
+			Hooks: &hooks.InjectFunctions{
+				Before: "StartServerSpan",
+				After:  "EndServerSpan",
+				From:   "nethttp_instrumentation",
+			},
+		},
+		{
+			Target: hooks.InjectTarget{
+				Package:  "net/http",
+				Function: "RoundTrip",
+				Receiver: "*Transport",
+			},
+			Hooks: &hooks.InjectFunctions{
+				Before: "StartClientSpan",
+				After:  "EndClientSpan",
+				From:   "nethttp_instrumentation",
+			},
+		},
+	}
+}
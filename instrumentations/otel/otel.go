@@ -0,0 +1,150 @@
+package otel_instrumentation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	_ "unsafe" // Required for go:linkname
+
+	"github.com/pdelewski/go-build-interceptor/hooks"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// TracesExporterEnv selects where spans go: otlp (the default) sends them over OTLP/HTTP to
+// OTEL_EXPORTER_OTLP_ENDPOINT or OTEL_EXPORTER_OTLP_TRACES_ENDPOINT (http://localhost:4318
+// by default), console prints them to stderr and none drops them
+const TracesExporterEnv = "OTEL_TRACES_EXPORTER"
+
+// TracerName is the instrumentation scope of the spans of the hooks
+const TracerName = "github.com/pdelewski/go-build-interceptor/instrumentations/otel"
+
+// Keys of the data StartSpan passes to EndSpan in the HookContext the hooks of a call share
+const (
+	spanKey   = "otel.span"
+	parentKey = "otel.parent"
+)
+
+// The runtime instrumentation (instrumentations/runtime) adds the trace context of goroutines
+// to the runtime, copied to the goroutines they start
+
+//go:linkname getTraceContextFromGLS runtime.GetTraceContextFromGLS
+func getTraceContextFromGLS() interface{}
+
+//go:linkname setTraceContextToGLS runtime.SetTraceContextToGLS
+func setTraceContextToGLS(traceContext interface{})
+
+var (
+	tracerOnce sync.Once
+	tracer     trace.Tracer
+)
+
+// StartSpan is a Before hook starting a span for the call of the hooked function, a child of
+// the span of the calling goroutine, which becomes the span of the goroutine until EndSpan
+func StartSpan(ctx hooks.HookContext) {
+	name := ctx.GetPackageName() + "." + ctx.GetFuncName()
+	spanCtx, span := getTracer().Start(Context(), name, trace.WithAttributes(
+		attribute.String("code.namespace", ctx.GetPackageName()),
+		attribute.String("code.function", ctx.GetFuncName()),
+	))
+	ctx.SetKeyData(spanKey, span)
+	ctx.SetKeyData(parentKey, getTraceContextFromGLS())
+	setTraceContextToGLS(spanCtx)
+}
+
+// EndSpan is an After hook ending the span StartSpan started, with an error status when the
+// function panicked or returned a non-nil error last, and restoring the span of the goroutine
+func EndSpan(ctx hooks.HookContext) {
+	span, ok := ctx.GetKeyData(spanKey).(trace.Span)
+	if !ok {
+		return
+	}
+	if err := callError(ctx); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	setTraceContextToGLS(ctx.GetKeyData(parentKey))
+}
+
+// Context returns the context of the span of the calling goroutine, context.Background()
+// outside of traced calls, for hooks starting spans of their own
+func Context() context.Context {
+	if traceContext, ok := getTraceContextFromGLS().(context.Context); ok {
+		return traceContext
+	}
+	return context.Background()
+}
+
+// callError returns the error a call ended with: its panic, or its last result when it is a
+// non-nil error
+func callError(ctx hooks.HookContext) error {
+	if recovered := ctx.GetPanic(); recovered != nil {
+		return fmt.Errorf("panic: %v", recovered)
+	}
+	results := ctx.GetResults()
+	if len(results) == 0 {
+		return nil
+	}
+	err, _ := results[len(results)-1].(error)
+	return err
+}
+
+// getTracer returns the tracer of the spans, setting up the tracer provider on first use
+func getTracer() trace.Tracer {
+	tracerOnce.Do(func() {
+		provider, err := newTracerProvider()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "otel hooks: %v, not tracing\n", err)
+			tracer = noop.NewTracerProvider().Tracer(TracerName)
+			return
+		}
+		otel.SetTracerProvider(provider)
+		tracer = provider.Tracer(TracerName)
+	})
+	return tracer
+}
+
+// newTracerProvider returns the tracer provider exporting the spans as TracesExporterEnv
+// selects, shut down, exporting the spans left, when the hooks runtime shuts down. Its
+// resource is read from OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES.
+func newTracerProvider() (trace.TracerProvider, error) {
+	exporter := strings.TrimSpace(os.Getenv(TracesExporterEnv))
+	if strings.EqualFold(os.Getenv("OTEL_SDK_DISABLED"), "true") {
+		exporter = "none"
+	}
+	var options []sdktrace.TracerProviderOption
+	switch exporter {
+	case "", "otlp":
+		client, err := otlptracehttp.New(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the OTLP exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(client))
+	case "console":
+		client, err := stdouttrace.New(stdouttrace.WithWriter(os.Stderr))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the console exporter: %w", err)
+		}
+		options = append(options, sdktrace.WithBatcher(client))
+	case "none":
+		return noop.NewTracerProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown %s=%s (expected otlp, console or none)", TracesExporterEnv, exporter)
+	}
+	provider := sdktrace.NewTracerProvider(options...)
+	hooks.OnShutdown(func() {
+		if err := provider.Shutdown(context.Background()); err != nil {
+			fmt.Fprintf(os.Stderr, "otel hooks: failed to export spans: %v\n", err)
+		}
+	})
+	return provider, nil
+}
@@ -0,0 +1,2 @@
+// Lets the hooks declare the functions the runtime instrumentation adds to the runtime, like
+// getTraceContextFromGLS, without a body.
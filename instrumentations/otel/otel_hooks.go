@@ -0,0 +1,27 @@
+package otel_instrumentation
+
+import (
+	"github.com/pdelewski/go-build-interceptor/hooks"
+)
+
+// ProvideHooks returns the functions traced with a span per call. Add a hook with the StartSpan
+// and EndSpan functions for every function to trace; the span of main.main is the root of the
+// spans of the calls it makes, in its goroutine and the ones they start.
+func ProvideHooks() []*hooks.Hook {
+	return []*hooks.Hook{
+		{
+			Target: hooks.InjectTarget{
+				Package:  "main",
+				Function: "main",
+				Receiver: "",
+			},
+			Hooks: &hooks.InjectFunctions{
+				Before: "StartSpan",
+				After:  "EndSpan",
+				From:   "otel_instrumentation",
+			},
+			// Wraps the other hooks of main.main
+			Priority: -10,
+		},
+	}
+}
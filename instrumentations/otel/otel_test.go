@@ -0,0 +1,82 @@
+package otel_instrumentation
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/pdelewski/go-build-interceptor/hooks/hookstest"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// StartSpan and EndSpan use the functions the runtime instrumentation adds to the runtime, so
+// binaries calling them only link in instrumented builds; the tests cover the rest.
+
+func TestProvideHooks(t *testing.T) {
+	hookList := ProvideHooks()
+	if len(hookList) != 1 {
+		t.Fatalf("expected 1 hook, got %d", len(hookList))
+	}
+	hook := hookList[0]
+	if hook.Target.Package != "main" || hook.Target.Function != "main" {
+		t.Errorf("expected a hook on main.main, got %s.%s", hook.Target.Package, hook.Target.Function)
+	}
+	if hook.Hooks.Before != "StartSpan" || hook.Hooks.After != "EndSpan" {
+		t.Errorf("expected StartSpan/EndSpan, got %s/%s", hook.Hooks.Before, hook.Hooks.After)
+	}
+	if err := hook.Validate(); err != nil {
+		t.Errorf("hook validation failed: %v", err)
+	}
+}
+
+func TestCallError(t *testing.T) {
+	failure := errors.New("failed")
+	tests := []struct {
+		name     string
+		results  []interface{}
+		panicked interface{}
+		want     string
+	}{
+		{"no results", nil, nil, ""},
+		{"nil error", []interface{}{1, nil}, nil, ""},
+		{"error last", []interface{}{1, failure}, nil, "failed"},
+		{"error not last", []interface{}{failure, 1}, nil, ""},
+		{"panic", nil, "boom", "panic: boom"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := callError(&hookstest.Context{Results: tt.results, Panic: tt.panicked})
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tt.want {
+				t.Errorf("callError() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTracerProvider(t *testing.T) {
+	t.Setenv(TracesExporterEnv, "none")
+	provider, err := newTracerProvider()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := provider.(noop.TracerProvider); !ok {
+		t.Errorf("expected a no-op provider for %s=none, got %T", TracesExporterEnv, provider)
+	}
+
+	t.Setenv(TracesExporterEnv, "zipkin")
+	if _, err := newTracerProvider(); err == nil || !strings.Contains(err.Error(), "unknown") {
+		t.Errorf("expected an error for an unknown exporter, got %v", err)
+	}
+
+	t.Setenv(TracesExporterEnv, "console")
+	t.Setenv("OTEL_SDK_DISABLED", "true")
+	if provider, err := newTracerProvider(); err != nil {
+		t.Fatal(err)
+	} else if _, ok := provider.(noop.TracerProvider); !ok {
+		t.Errorf("expected a no-op provider with OTEL_SDK_DISABLED=true, got %T", provider)
+	}
+}
@@ -0,0 +1,7 @@
+module github.com/pdelewski/go-build-interceptor/instrumentations/pprof
+
+go 1.24.4
+
+require github.com/pdelewski/go-build-interceptor/hooks v0.0.0-00010101000000-000000000000
+
+replace github.com/pdelewski/go-build-interceptor/hooks => ../../hooks
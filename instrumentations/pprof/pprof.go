@@ -0,0 +1,81 @@
+package pprof_instrumentation
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime/pprof"
+	"unsafe"
+
+	"github.com/pdelewski/go-build-interceptor/hooks"
+)
+
+// CPUProfileEnv is the environment variable making the program write a CPU profile of its
+// whole run to a file, for programs that don't profile themselves
+const CPUProfileEnv = "HC_CPUPROFILE"
+
+// Labels of the samples taken during the calls of the hooked functions
+const (
+	FunctionLabel = "function"
+	PackageLabel  = "package"
+)
+
+// labelsKey is the key of the labels SetLabels passes to RestoreLabels in the HookContext the
+// hooks of a call share
+const labelsKey = "pprof.labels"
+
+// The labels of a goroutine can only be replaced through runtime/pprof; the runtime keeps
+// these accessors for packages restoring labels they didn't set (go.dev/issue/67401)
+
+//go:linkname getProfLabel runtime/pprof.runtime_getProfLabel
+func getProfLabel() unsafe.Pointer
+
+//go:linkname setProfLabel runtime/pprof.runtime_setProfLabel
+func setProfLabel(labels unsafe.Pointer)
+
+func init() {
+	if path := os.Getenv(CPUProfileEnv); path != "" {
+		if err := startCPUProfile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "pprof hooks: %v, not profiling\n", err)
+		}
+	}
+}
+
+// SetLabels is a Before hook labeling the samples of the calling goroutine with the function
+// and package of the hooked function until RestoreLabels
+func SetLabels(ctx hooks.HookContext) {
+	ctx.SetKeyData(labelsKey, getProfLabel())
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels(
+		FunctionLabel, ctx.GetFuncName(),
+		PackageLabel, ctx.GetPackageName(),
+	)))
+}
+
+// RestoreLabels is an After hook giving the goroutine the labels it had before SetLabels,
+// those of the calling hooked function or the program's own
+func RestoreLabels(ctx hooks.HookContext) {
+	labels, ok := ctx.GetKeyData(labelsKey).(unsafe.Pointer)
+	if !ok {
+		return
+	}
+	setProfLabel(labels)
+}
+
+// startCPUProfile writes a CPU profile to path until the hooks runtime shuts down
+func startCPUProfile(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := pprof.StartCPUProfile(file); err != nil {
+		file.Close()
+		return err
+	}
+	hooks.OnShutdown(func() {
+		pprof.StopCPUProfile()
+		if err := file.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "pprof hooks: failed to write %s: %v\n", path, err)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,25 @@
+package pprof_instrumentation
+
+import (
+	"github.com/pdelewski/go-build-interceptor/hooks"
+)
+
+// ProvideHooks returns the functions whose calls are labeled in profiles. Add a hook with the
+// SetLabels and RestoreLabels functions for the functions to label; every function of the main
+// packages is labeled by default.
+func ProvideHooks() []*hooks.Hook {
+	return []*hooks.Hook{
+		{
+			Target: hooks.InjectTarget{
+				Package:  "main",
+				Function: "*",
+				Receiver: "",
+			},
+			Hooks: &hooks.InjectFunctions{
+				Before: "SetLabels",
+				After:  "RestoreLabels",
+				From:   "pprof_instrumentation",
+			},
+		},
+	}
+}
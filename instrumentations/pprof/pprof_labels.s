@@ -0,0 +1,2 @@
+// Lets the hooks declare the profiler label accessors of the runtime, like getProfLabel,
+// without a body.
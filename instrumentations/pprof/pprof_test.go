@@ -0,0 +1,108 @@
+package pprof_instrumentation
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime/pprof"
+	"strings"
+	"testing"
+
+	"github.com/pdelewski/go-build-interceptor/hooks/hookstest"
+)
+
+// goroutineLabels returns the labels of the calling goroutine as the goroutine profile prints
+// them, e.g. {"function":"Get", "package":"example.com/app"}, or "" without labels
+func goroutineLabels(t *testing.T) string {
+	t.Helper()
+	labels := make(chan string)
+	// A goroutine started by the calling one inherits its labels
+	go func() {
+		var profile strings.Builder
+		if err := pprof.Lookup("goroutine").WriteTo(&profile, 1); err != nil {
+			t.Error(err)
+		}
+		for _, goroutine := range strings.Split(profile.String(), "\n\n") {
+			if !strings.Contains(goroutine, "goroutineLabels.func1") {
+				continue
+			}
+			for _, line := range strings.Split(goroutine, "\n") {
+				if strings.HasPrefix(line, "# labels: ") {
+					labels <- strings.TrimPrefix(line, "# labels: ")
+					return
+				}
+			}
+		}
+		labels <- ""
+	}()
+	return <-labels
+}
+
+func TestProvideHooks(t *testing.T) {
+	hookList := ProvideHooks()
+	if len(hookList) != 1 {
+		t.Fatalf("expected 1 hook, got %d", len(hookList))
+	}
+	hook := hookList[0]
+	if hook.Target.Package != "main" || hook.Target.Function != "*" {
+		t.Errorf("expected a hook on main.*, got %s.%s", hook.Target.Package, hook.Target.Function)
+	}
+	if hook.Hooks.Before != "SetLabels" || hook.Hooks.After != "RestoreLabels" {
+		t.Errorf("expected SetLabels/RestoreLabels, got %s/%s", hook.Hooks.Before, hook.Hooks.After)
+	}
+	if err := hook.Validate(); err != nil {
+		t.Errorf("hook validation failed: %v", err)
+	}
+}
+
+func TestSetLabels(t *testing.T) {
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels("handler", "orders")))
+	defer pprof.SetGoroutineLabels(context.Background())
+	program := goroutineLabels(t)
+
+	outer := hookstest.NewContext("example.com/app", "Serve")
+	SetLabels(outer)
+	if got, want := goroutineLabels(t), `{"function":"Serve", "package":"example.com/app"}`; got != want {
+		t.Errorf("expected labels %s during Serve, got %s", want, got)
+	}
+
+	inner := hookstest.NewContext("example.com/app", "Get")
+	SetLabels(inner)
+	if got, want := goroutineLabels(t), `{"function":"Get", "package":"example.com/app"}`; got != want {
+		t.Errorf("expected labels %s during Get, got %s", want, got)
+	}
+
+	RestoreLabels(inner)
+	if got, want := goroutineLabels(t), `{"function":"Serve", "package":"example.com/app"}`; got != want {
+		t.Errorf("expected the labels of Serve back after Get, got %s", got)
+	}
+	RestoreLabels(outer)
+	if got := goroutineLabels(t); got != program {
+		t.Errorf("expected the labels of the program %s back after Serve, got %s", program, got)
+	}
+}
+
+func TestRestoreLabelsWithoutSetLabels(t *testing.T) {
+	pprof.SetGoroutineLabels(pprof.WithLabels(context.Background(), pprof.Labels("handler", "orders")))
+	defer pprof.SetGoroutineLabels(context.Background())
+	program := goroutineLabels(t)
+
+	RestoreLabels(hookstest.NewContext("example.com/app", "Unlabeled"))
+	if got := goroutineLabels(t); got != program {
+		t.Errorf("expected the labels of the program %s to stay, got %s", program, got)
+	}
+}
+
+func TestStartCPUProfile(t *testing.T) {
+	if err := startCPUProfile(filepath.Join(t.TempDir(), "missing", "cpu.pprof")); err == nil {
+		t.Error("expected an error for a file in a missing directory")
+	}
+	path := filepath.Join(t.TempDir(), "cpu.pprof")
+	if err := startCPUProfile(path); err != nil {
+		t.Fatal(err)
+	}
+	defer pprof.StopCPUProfile()
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("expected the profile file to be created: %v", err)
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Roles, from least to most privileged. Viewers browse files and analysis results,
+// operators also run builds and instrumentation and modify files.
+const (
+	roleViewer   = "viewer"
+	roleOperator = "operator"
+)
+
+// tokenCookieName is the cookie holding the access token of a browser
+const tokenCookieName = "hc_token"
+
+// maxAuditBodySize is the largest request body inspected for audit parameters
+const maxAuditBodySize = 1 << 20
+
+// User is an API user authenticated by a bearer token
+type User struct {
+	Name  string `json:"name"`
+	Token string `json:"token"`
+	Role  string `json:"role"`
+}
+
+// AuthConfig is the file passed with -auth-file
+type AuthConfig struct {
+	Users []User `json:"users"`
+}
+
+// AuditEntry is one operator action written to the audit log
+type AuditEntry struct {
+	Time   time.Time         `json:"time"`
+	User   string            `json:"user"`
+	Role   string            `json:"role"`
+	Remote string            `json:"remote"`
+	Method string            `json:"method"`
+	Path   string            `json:"path"`
+	Query  string            `json:"query,omitempty"`
+	Params map[string]string `json:"params,omitempty"`
+	Status int               `json:"status"`
+}
+
+// Authentication state; without -auth-file every request acts as an anonymous operator
+var (
+	authUsers   []User
+	authEnabled bool
+)
+
+// Audit trail of operator actions (-audit-log); written to the server log when unset
+var (
+	auditWriter io.Writer
+	auditMutex  sync.Mutex
+)
+
+// anonymousUser is used for all requests when authentication is disabled
+var anonymousUser = &User{Name: "anonymous", Role: roleOperator}
+
+// loadAuthFile reads users and their roles from a JSON file
+func loadAuthFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read auth file: %w", err)
+	}
+	var config AuthConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("failed to parse auth file: %w", err)
+	}
+
+	for i, user := range config.Users {
+		if user.Name == "" || user.Token == "" {
+			return fmt.Errorf("auth file: user %d needs a name and a token", i+1)
+		}
+		if user.Role != roleViewer && user.Role != roleOperator {
+			return fmt.Errorf("auth file: user %s has unknown role %q (expected %q or %q)",
+				user.Name, user.Role, roleViewer, roleOperator)
+		}
+	}
+
+	authUsers = config.Users
+	authEnabled = true
+	return nil
+}
+
+// openAuditLog appends the audit trail to the given file
+func openAuditLog(path string) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log: %w", err)
+	}
+	auditWriter = file
+	return nil
+}
+
+// requestToken returns the access token of a request from the Authorization header, the
+// token cookie or the ?token= query parameter, and whether it came from the query
+func requestToken(r *http.Request) (string, bool) {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer "), false
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token, true
+	}
+	if cookie, err := r.Cookie(tokenCookieName); err == nil {
+		return cookie.Value, false
+	}
+	return "", false
+}
+
+// authenticate returns the user making the request, or nil if the token is missing or unknown
+func authenticate(r *http.Request) *User {
+	if !authEnabled {
+		return anonymousUser
+	}
+
+	token, _ := requestToken(r)
+	if token == "" {
+		return nil
+	}
+	for i := range authUsers {
+		if subtle.ConstantTimeCompare([]byte(authUsers[i].Token), []byte(token)) == 1 {
+			return &authUsers[i]
+		}
+	}
+	return nil
+}
+
+// roleAllows reports whether a user with role has the privileges of required
+func roleAllows(role, required string) bool {
+	return role == roleOperator || role == required
+}
+
+// userRole returns the role of the request's user, or an empty string if unauthenticated
+func userRole(r *http.Request) string {
+	if user := authenticate(r); user != nil {
+		return user.Role
+	}
+	return ""
+}
+
+// sendAuthError writes a JSON error with the given status code
+func sendAuthError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(FileResponse{
+		Success: false,
+		Error:   message,
+	})
+}
+
+// requireRole wraps a handler so only users with the given role (or a higher one) may
+// call it. Operator actions are recorded in the audit trail.
+func requireRole(required string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := authenticate(r)
+		if user == nil {
+			sendAuthError(w, http.StatusUnauthorized, "Authentication required: open /?token=<token> or send an Authorization: Bearer <token> header")
+			return
+		}
+		if !roleAllows(user.Role, required) {
+			log.Printf("Denied %s %s to %s (%s)\n", r.Method, r.URL.Path, user.Name, user.Role)
+			sendAuthError(w, http.StatusForbidden, fmt.Sprintf("Role %q is not allowed to perform this action", user.Role))
+			return
+		}
+
+		// A token passed in the URL is kept in a cookie so the browser can use the API
+		if token, fromQuery := requestToken(r); fromQuery && authEnabled {
+			http.SetCookie(w, &http.Cookie{
+				Name:     tokenCookieName,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			})
+		}
+
+		// Anonymous actions are only audited when an audit log was requested
+		if required != roleOperator || (!authEnabled && auditWriter == nil) {
+			handler(w, r)
+			return
+		}
+
+		entry := AuditEntry{
+			Time:   time.Now().UTC(),
+			User:   user.Name,
+			Role:   user.Role,
+			Remote: r.RemoteAddr,
+			Method: r.Method,
+			Path:   r.URL.Path,
+			Query:  auditQuery(r),
+			Params: auditParams(r),
+		}
+		recorder := &statusRecorder{ResponseWriter: w}
+		handler(recorder, r)
+		entry.Status = recorder.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		writeAuditEntry(entry)
+	}
+}
+
+// auditQuery returns the request query without the access token
+func auditQuery(r *http.Request) string {
+	query := r.URL.Query()
+	query.Del("token")
+	return query.Encode()
+}
+
+// auditParams returns the string parameters of a JSON request body, leaving out file
+// contents. The body is restored for the handler.
+func auditParams(r *http.Request) map[string]string {
+	if r.Body == nil || r.Method == http.MethodGet {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxAuditBodySize+1))
+	r.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), r.Body))
+	if err != nil || len(body) > maxAuditBodySize {
+		return nil
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return nil
+	}
+	params := make(map[string]string)
+	for key, value := range fields {
+		if key == "content" {
+			continue
+		}
+		switch v := value.(type) {
+		case string:
+			if len(v) > 256 {
+				v = v[:256] + "..."
+			}
+			params[key] = v
+		case bool, float64:
+			params[key] = fmt.Sprint(v)
+		}
+	}
+	if len(params) == 0 {
+		return nil
+	}
+	return params
+}
+
+// writeAuditEntry appends an entry to the audit trail as a JSON line
+func writeAuditEntry(entry AuditEntry) {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	auditMutex.Lock()
+	defer auditMutex.Unlock()
+	if auditWriter == nil {
+		log.Printf("audit: %s\n", line)
+		return
+	}
+	auditWriter.Write(append(line, '\n'))
+}
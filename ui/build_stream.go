@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BuildEvent is a line of the progress streamed by /api/capture and /api/instrument, with the
+// types of the /ws/run messages: "started", "stdout", "stderr", "exited" and "error"
+type BuildEvent struct {
+	Type       string `json:"type"`
+	Command    string `json:"command,omitempty"`
+	Pid        int    `json:"pid,omitempty"`
+	Output     string `json:"output,omitempty"`
+	ExitCode   *int   `json:"exitCode,omitempty"` // Set on "exited"
+	DurationMs int64  `json:"durationMs,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// buildEventWriter writes BuildEvents as newline-delimited JSON, flushing each one so the
+// browser sees the output of the command while it runs
+type buildEventWriter struct {
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+func newBuildEventWriter(w http.ResponseWriter) *buildEventWriter {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	flusher, _ := w.(http.Flusher)
+	return &buildEventWriter{w: w, flusher: flusher}
+}
+
+func (e *buildEventWriter) send(event BuildEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.write(event)
+}
+
+// write sends an event. It must be called with e.mu held.
+func (e *buildEventWriter) write(event BuildEvent) {
+	json.NewEncoder(e.w).Encode(event)
+	if e.flusher != nil {
+		e.flusher.Flush()
+	}
+}
+
+// runCapture captures the build of the session root with hc --capture, or hc --json when the
+// request asks for it, streaming the output
+func runCapture(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		JSON bool `json:"json"` // Capture with go build -json
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			sendErrorResponse(w, "Invalid request format")
+			return
+		}
+	}
+
+	flag := "--capture"
+	if req.JSON {
+		flag = "--json"
+	}
+	streamHC(w, r, "hc "+flag, flag)
+}
+
+// runInstrument instruments and builds the session root with hc --compile, streaming the output
+func runInstrument(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		HooksFile string `json:"hooksFile"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request format")
+		return
+	}
+	if strings.TrimSpace(req.HooksFile) == "" {
+		sendErrorResponse(w, "Hooks file is required for instrumentation")
+		return
+	}
+
+	streamHC(w, r, "hc --compile", "--compile", strings.TrimSpace(req.HooksFile))
+}
+
+// streamHC runs hc with args in the session root and streams its output line by line. The
+// command is killed when the browser goes away.
+func streamHC(w http.ResponseWriter, r *http.Request, name string, args ...string) {
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	execPath, err := hcExecutable()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	defer lockRoot(root)()
+
+	events := newBuildEventWriter(w)
+	command := "hc " + strings.Join(args, " ")
+	fmt.Printf("📍 Executing: %s %s from directory: %s\n", execPath, strings.Join(args, " "), root)
+
+	cmd := exec.CommandContext(r.Context(), execPath, args...)
+	cmd.Dir = root
+	stdout := &buildEventLines{events: events, eventType: "stdout"}
+	stderr := &buildEventLines{events: events, eventType: "stderr"}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	// go build, started by hc, may keep the output open after hc was killed
+	cmd.WaitDelay = 5 * time.Second
+	job := commandLogs.track(cmd)
+
+	// The output of the command waits for the started event
+	start := time.Now()
+	events.mu.Lock()
+	if err := cmd.Start(); err != nil {
+		events.write(BuildEvent{Type: "error", Error: fmt.Sprintf("Failed to start %s: %v", command, err)})
+		events.mu.Unlock()
+		commandLogs.finish(job, err)
+		return
+	}
+	events.write(BuildEvent{Type: "started", Command: command, Pid: cmd.Process.Pid})
+	events.mu.Unlock()
+	commandLogs.started(job)
+	analysisJobsRunning.add(1, "command", name)
+
+	err = cmd.Wait()
+	commandLogs.finish(job, err)
+	duration := time.Since(start)
+	analysisJobsRunning.add(-1, "command", name)
+	recordCommand(name, err == nil, duration)
+	stdout.flush()
+	stderr.flush()
+
+	exitCode := 0
+	if cmd.ProcessState != nil {
+		exitCode = cmd.ProcessState.ExitCode()
+	}
+	if r.Context().Err() != nil {
+		fmt.Printf("⏹️  %s stopped: the client disconnected\n", command)
+		return
+	}
+	events.send(BuildEvent{Type: "exited", ExitCode: &exitCode, DurationMs: duration.Milliseconds()})
+}
+
+// buildEventLines sends what a command writes as events, one per line
+type buildEventLines struct {
+	events    *buildEventWriter
+	eventType string
+	partial   []byte // Last line, until its newline is written
+}
+
+func (l *buildEventLines) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		l.events.send(BuildEvent{Type: l.eventType, Output: string(l.partial[:i+1])})
+		l.partial = l.partial[i+1:]
+	}
+}
+
+// flush sends the last line if it doesn't end with a newline
+func (l *buildEventLines) flush() {
+	if len(l.partial) > 0 {
+		l.events.send(BuildEvent{Type: l.eventType, Output: string(l.partial)})
+		l.partial = nil
+	}
+}
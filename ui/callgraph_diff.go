@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// callGraphBaselineFile keeps the call graph changes are compared with next to the build log
+const callGraphBaselineFile = "callgraph-baseline.json"
+
+// CallGraphDiffResponse is the result of hc --callgraph-diff between the baseline and the
+// current call graph of the session root
+type CallGraphDiffResponse struct {
+	Success  bool            `json:"success"`
+	Baseline time.Time       `json:"baseline"` // When the baseline was taken
+	Diff     json.RawMessage `json:"diff"`
+}
+
+// setCallGraphBaseline records the current call graph of the session root as the baseline
+// later call graphs are compared with
+func setCallGraphBaseline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	defer lockRoot(root)()
+
+	execPath, err := hcExecutable()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	fmt.Printf("🕸️ Recording the call graph baseline of %s...\n", root)
+	data, err := writeCallGraphJSON(execPath, root)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	if err := os.MkdirAll(filepath.Join(root, "build-metadata"), 0755); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to create build-metadata: %v", err))
+		return
+	}
+	if err := os.WriteFile(filepath.Join(root, "build-metadata", callGraphBaselineFile), data, 0644); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to save the baseline: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FileResponse{Success: true, Content: "Call graph baseline recorded"})
+}
+
+// getCallGraphDiff compares the current call graph of the session root with the baseline.
+// The optional hooks query parameter names a hooks file whose coverage is compared too.
+func getCallGraphDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	defer lockRoot(root)()
+
+	baseline := filepath.Join(root, "build-metadata", callGraphBaselineFile)
+	info, err := os.Stat(baseline)
+	if err != nil {
+		sendErrorResponse(w, "No call graph baseline yet: set one before changing the code")
+		return
+	}
+
+	execPath, err := hcExecutable()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	fmt.Printf("🕸️ Comparing the call graph of %s with its baseline...\n", root)
+	data, err := writeCallGraphJSON(execPath, root)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	current, err := os.CreateTemp("", "callgraph-*.json")
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to create temporary file: %v", err))
+		return
+	}
+	defer os.Remove(current.Name())
+	_, err = current.Write(data)
+	current.Close()
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to write temporary file: %v", err))
+		return
+	}
+
+	// Flags go first: hc stops parsing them at the call graph files
+	args := []string{"--output=json"}
+	if hooksFile := r.URL.Query().Get("hooks"); hooksFile != "" {
+		args = append(args, "--compile", hooksFile)
+	}
+	args = append(args, "--callgraph-diff", baseline, current.Name())
+	cmd := exec.Command(execPath, args...)
+	cmd.Dir = root
+	diff, err := runCommandOutput("hc --callgraph-diff", cmd)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to compare call graphs: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(CallGraphDiffResponse{Success: true, Baseline: info.ModTime(), Diff: diff})
+}
+
+// writeCallGraphJSON returns the call graph of root written by hc --callgraph --output=json
+func writeCallGraphJSON(execPath, root string) ([]byte, error) {
+	cmd := exec.Command(execPath, "--callgraph", "--output=json")
+	cmd.Dir = root
+	output, err := runCommandOutput("hc --callgraph", cmd)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to build the call graph: %v", err)
+	}
+	return output, nil
+}
+
+// runCommandOutput runs cmd like runCommand, but returns only its standard output, where hc
+// writes JSON. The standard error is included in the error of a failed command.
+func runCommandOutput(name string, cmd *exec.Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	analysisJobsRunning.add(1, "command", name)
+	start := time.Now()
+	err := runTracked(cmd)
+	analysisJobsRunning.add(-1, "command", name)
+	recordCommand(name, err == nil, time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
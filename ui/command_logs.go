@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logSubscriberBuffer is the number of events a /ws/logs client may fall behind before
+// events are dropped for it, so a slow browser never holds up a command
+const logSubscriberBuffer = 1024
+
+// LogEvent is a message of /ws/logs. Started, stdout, stderr and exited events carry the
+// job of the command; "jobs" lists the running commands when a client connects.
+type LogEvent struct {
+	Type       string    `json:"type"` // "jobs", "started", "stdout", "stderr", "exited" or "error"
+	Job        int       `json:"job,omitempty"`
+	Command    string    `json:"command,omitempty"`
+	Root       string    `json:"root,omitempty"`
+	Output     string    `json:"output,omitempty"`
+	ExitCode   *int      `json:"exitCode,omitempty"`
+	Canceled   bool      `json:"canceled,omitempty"`
+	DurationMs int64     `json:"durationMs,omitempty"`
+	Jobs       []JobInfo `json:"jobs,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// JobInfo describes a running command
+type JobInfo struct {
+	Job     int       `json:"job"`
+	Command string    `json:"command"`
+	Root    string    `json:"root"`
+	Started time.Time `json:"started"`
+}
+
+// commandJob is a command started by a handler whose output is streamed to /ws/logs
+type commandJob struct {
+	JobInfo
+	cmd      *exec.Cmd
+	process  *os.Process // Guarded by commandLogs.mu, nil until the command started
+	canceled bool        // Guarded by commandLogs.mu
+}
+
+// commandLogHub keeps the running commands and the /ws/logs clients following them
+type commandLogHub struct {
+	mu          sync.Mutex
+	nextJob     int
+	jobs        map[int]*commandJob
+	subscribers map[chan LogEvent]bool
+}
+
+var commandLogs = &commandLogHub{
+	jobs:        make(map[int]*commandJob),
+	subscribers: make(map[chan LogEvent]bool),
+}
+
+// track registers cmd before it is started: what it writes to its Stdout and Stderr is
+// also sent to /ws/logs line by line. The returned job must be marked started once cmd
+// started, and finished once it exited.
+func (h *commandLogHub) track(cmd *exec.Cmd) *commandJob {
+	h.mu.Lock()
+	h.nextJob++
+	job := &commandJob{
+		JobInfo: JobInfo{
+			Job:     h.nextJob,
+			Command: strings.Join(append([]string{commandName(cmd.Path)}, cmd.Args[1:]...), " "),
+			Root:    cmd.Dir,
+			Started: time.Now(),
+		},
+		cmd: cmd,
+	}
+	h.jobs[job.Job] = job
+	h.mu.Unlock()
+
+	cmd.Stdout = teeLines(cmd.Stdout, &logLines{hub: h, job: job.Job, eventType: "stdout"})
+	cmd.Stderr = teeLines(cmd.Stderr, &logLines{hub: h, job: job.Job, eventType: "stderr"})
+	h.broadcast(LogEvent{Type: "started", Job: job.Job, Command: job.Command, Root: job.Root})
+	return job
+}
+
+// finish reports the end of a job's command, which returned err
+func (h *commandLogHub) finish(job *commandJob, err error) {
+	for _, w := range []io.Writer{job.cmd.Stdout, job.cmd.Stderr} {
+		if tee, ok := w.(*lineTee); ok {
+			tee.lines.flush()
+		}
+	}
+
+	exitCode := 0
+	if job.cmd.ProcessState != nil {
+		exitCode = job.cmd.ProcessState.ExitCode()
+	} else if err != nil {
+		exitCode = -1
+	}
+	h.mu.Lock()
+	delete(h.jobs, job.Job)
+	canceled := job.canceled
+	h.mu.Unlock()
+
+	event := LogEvent{Type: "exited", Job: job.Job, ExitCode: &exitCode, Canceled: canceled,
+		DurationMs: time.Since(job.Started).Milliseconds()}
+	if err != nil && job.cmd.ProcessState == nil {
+		event.Error = err.Error()
+	}
+	h.broadcast(event)
+}
+
+// runTracked runs cmd to completion, streaming its output to /ws/logs
+func runTracked(cmd *exec.Cmd) error {
+	if cmd.WaitDelay == 0 {
+		// go build, started by hc, may keep the output open after hc was canceled
+		cmd.WaitDelay = 5 * time.Second
+	}
+	job := commandLogs.track(cmd)
+	err := cmd.Start()
+	if err == nil {
+		commandLogs.started(job)
+		err = cmd.Wait()
+	}
+	commandLogs.finish(job, err)
+	return err
+}
+
+// started records that the command of a job started, so it can be canceled
+func (h *commandLogHub) started(job *commandJob) {
+	h.mu.Lock()
+	job.process = job.cmd.Process
+	h.mu.Unlock()
+}
+
+// cancel kills the command of a running job
+func (h *commandLogHub) cancel(id int) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	job, exists := h.jobs[id]
+	if !exists {
+		return fmt.Errorf("job %d is not running", id)
+	}
+	if job.process == nil {
+		return fmt.Errorf("job %d has not started yet", id)
+	}
+	job.canceled = true
+	log.Printf("Canceling job %d (PID: %d): %s\n", id, job.process.Pid, job.Command)
+	return job.process.Kill()
+}
+
+// running returns the running jobs, oldest first
+func (h *commandLogHub) running() []JobInfo {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	jobs := []JobInfo{}
+	for _, job := range h.jobs {
+		jobs = append(jobs, job.JobInfo)
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].Job < jobs[j].Job })
+	return jobs
+}
+
+func (h *commandLogHub) subscribe() chan LogEvent {
+	events := make(chan LogEvent, logSubscriberBuffer)
+	h.mu.Lock()
+	h.subscribers[events] = true
+	h.mu.Unlock()
+	return events
+}
+
+func (h *commandLogHub) unsubscribe(events chan LogEvent) {
+	h.mu.Lock()
+	delete(h.subscribers, events)
+	h.mu.Unlock()
+}
+
+// broadcast sends an event to every client, dropping it for clients that fell behind
+func (h *commandLogHub) broadcast(event LogEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for events := range h.subscribers {
+		select {
+		case events <- event:
+		default:
+		}
+	}
+}
+
+// commandName shortens the path of the hc executable for display
+func commandName(path string) string {
+	if execPath, err := hcExecutable(); err == nil && execPath == path {
+		return "hc"
+	}
+	return path
+}
+
+// logLines sends what a command writes as events, one per line
+type logLines struct {
+	hub       *commandLogHub
+	job       int
+	eventType string
+	partial   []byte // Last line, until its newline is written
+}
+
+func (l *logLines) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		l.hub.broadcast(LogEvent{Type: l.eventType, Job: l.job, Output: string(l.partial[:i+1])})
+		l.partial = l.partial[i+1:]
+	}
+}
+
+// flush sends the last line if it doesn't end with a newline
+func (l *logLines) flush() {
+	if len(l.partial) > 0 {
+		l.hub.broadcast(LogEvent{Type: l.eventType, Job: l.job, Output: string(l.partial)})
+		l.partial = nil
+	}
+}
+
+// lineTee writes to the destination of a command's output and to its log lines
+type lineTee struct {
+	dest  io.Writer
+	lines *logLines
+}
+
+func teeLines(dest io.Writer, lines *logLines) io.Writer {
+	return &lineTee{dest: dest, lines: lines}
+}
+
+func (t *lineTee) Write(p []byte) (int, error) {
+	t.lines.Write(p)
+	if t.dest == nil {
+		return len(p), nil
+	}
+	return t.dest.Write(p)
+}
+
+// lockedBuffer collects the output of a command written to both Stdout and Stderr, which are
+// written concurrently once wrapped
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
+// handleLogsWebSocket streams the output of the commands started by the UI to the browser.
+// Operators can cancel a running command with {"command": "cancel", "job": <id>}.
+func handleLogsWebSocket(w http.ResponseWriter, r *http.Request) {
+	canCancel := roleAllows(userRole(r), roleOperator)
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("Logs WebSocket upgrade failed: %v\n", err)
+		return
+	}
+	defer closeWebSocket(conn)
+
+	events := commandLogs.subscribe()
+	defer commandLogs.unsubscribe(events)
+
+	var connMutex sync.Mutex
+	safeWriteJSON := func(v interface{}) error {
+		connMutex.Lock()
+		defer connMutex.Unlock()
+		return conn.WriteJSON(v)
+	}
+	if err := safeWriteJSON(LogEvent{Type: "jobs", Jobs: commandLogs.running()}); err != nil {
+		return
+	}
+
+	// Commands from the browser, read until it disconnects
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var req struct {
+				Command string `json:"command"`
+				Job     int    `json:"job"`
+			}
+			if err := json.Unmarshal(message, &req); err != nil || req.Command != "cancel" {
+				safeWriteJSON(LogEvent{Type: "error", Error: "Invalid command"})
+				continue
+			}
+			if !canCancel {
+				safeWriteJSON(LogEvent{Type: "error", Job: req.Job, Error: "Only operators can cancel commands"})
+				continue
+			}
+			if err := commandLogs.cancel(req.Job); err != nil {
+				safeWriteJSON(LogEvent{Type: "error", Job: req.Job, Error: err.Error()})
+			}
+		}
+	}()
+
+	for {
+		select {
+		case event := <-events:
+			if err := safeWriteJSON(event); err != nil {
+				return
+			}
+		case <-disconnected:
+			return
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// interceptorEnv is the environment variable giving the hc executable when -interceptor isn't set
+const interceptorEnv = "HC_INTERCEPTOR"
+
+// interceptorNames are the names the hc executable is looked up under on PATH
+var interceptorNames = []string{"hc", "go-build-interceptor"}
+
+// interceptorFlag is the hc executable given with -interceptor
+var interceptorFlag string
+
+// hcExecutable returns the absolute path to the hc executable: the one of -interceptor or
+// HC_INTERCEPTOR, else ../hc/hc of a source checkout, else hc or go-build-interceptor on PATH
+func hcExecutable() (string, error) {
+	execPath, _, err := resolveInterceptor()
+	return execPath, err
+}
+
+// resolveInterceptor returns the absolute path to the hc executable and where it was found:
+// "flag", "env", "checkout" or "path"
+func resolveInterceptor() (string, string, error) {
+	configured, source := interceptorFlag, "flag"
+	if configured == "" {
+		configured, source = os.Getenv(interceptorEnv), "env"
+	}
+	if configured != "" {
+		// A bare name is looked up on PATH, a path is taken as is
+		execPath, err := exec.LookPath(configured)
+		if err != nil {
+			return "", source, fmt.Errorf("Executable not found at: %s", configured)
+		}
+		if execPath, err = filepath.Abs(execPath); err != nil {
+			return "", source, fmt.Errorf("Failed to resolve executable path: %v", err)
+		}
+		return execPath, source, nil
+	}
+
+	if execPath, err := filepath.Abs("../hc/hc"); err == nil {
+		if info, err := os.Stat(execPath); err == nil && !info.IsDir() {
+			return execPath, "checkout", nil
+		}
+	}
+	for _, name := range interceptorNames {
+		if execPath, err := exec.LookPath(name); err == nil {
+			if execPath, err = filepath.Abs(execPath); err == nil {
+				return execPath, "path", nil
+			}
+		}
+	}
+	return "", "", fmt.Errorf("hc executable not found: pass -interceptor, set %s, build ../hc/hc or put %s on PATH",
+		interceptorEnv, strings.Join(interceptorNames, " or "))
+}
+
+// interceptorVersions caches the output of hc --version by executable and modification time
+var interceptorVersions sync.Map
+
+// interceptorVersion returns the version hc --version prints
+func interceptorVersion(execPath string) (string, error) {
+	info, err := os.Stat(execPath)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s@%d", execPath, info.ModTime().UnixNano())
+	if v, ok := interceptorVersions.Load(key); ok {
+		return v.(string), nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, execPath, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s --version: %v (built before --version existed?)", execPath, err)
+	}
+	v := strings.TrimSpace(string(output))
+	interceptorVersions.Store(key, v)
+	return v, nil
+}
+
+// InterceptorHealth describes the hc executable the handlers run
+type InterceptorHealth struct {
+	Path    string `json:"path,omitempty"`
+	Source  string `json:"source,omitempty"` // "flag", "env", "checkout" or "path"
+	Version string `json:"version,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// handleHealth reports the resolved hc executable and its version. The status is "degraded"
+// while hc is not found, since every analysis and build endpoint needs it.
+func handleHealth(w http.ResponseWriter, r *http.Request) {
+	status := "ok"
+	execPath, source, err := resolveInterceptor()
+	interceptor := InterceptorHealth{Path: execPath, Source: source}
+	if err != nil {
+		status = "degraded"
+		interceptor.Error = err.Error()
+	} else if interceptor.Version, err = interceptorVersion(execPath); err != nil {
+		interceptor.Error = err.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":      status,
+		"interceptor": interceptor,
+	})
+}
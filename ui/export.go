@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// exportBundleFiles are the build-metadata files included in the export bundle
+var exportBundleFiles = []string{
+	"go-build.log",
+	"go-build-modified.log",
+	"source-mappings.json",
+	"instrumentation-preview.json",
+	"build-profile.json",
+	"hook-events.json",
+}
+
+// exportBundleDirs are the directories included recursively in the export bundle
+var exportBundleDirs = []string{
+	filepath.Join(".debug-build", "debug"),
+}
+
+// bundlePaths returns the files of root that go into the export bundle, relative to root
+func bundlePaths(root string) ([]string, error) {
+	var paths []string
+	for _, name := range exportBundleFiles {
+		relPath := filepath.Join("build-metadata", name)
+		if info, err := os.Stat(filepath.Join(root, relPath)); err == nil && info.Mode().IsRegular() {
+			paths = append(paths, relPath)
+		}
+	}
+
+	for _, dir := range exportBundleDirs {
+		err := filepath.WalkDir(filepath.Join(root, dir), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if !d.Type().IsRegular() {
+				return nil
+			}
+			relPath, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			paths = append(paths, relPath)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s: %w", dir, err)
+		}
+	}
+	return paths, nil
+}
+
+// handleExport streams a zip of the build logs, source mappings, instrumentation preview and
+// instrumented sources of the session root, for attaching to bug reports
+func handleExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	// Don't read build-metadata while a build in the same root is writing it
+	defer lockRoot(root)()
+
+	paths, err := bundlePaths(root)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	if len(paths) == 0 {
+		sendErrorResponse(w, "Nothing to export: no build-metadata or .debug-build files found")
+		return
+	}
+
+	filename := fmt.Sprintf("hc-bundle-%s-%s.zip", filepath.Base(root), time.Now().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+
+	// Files are streamed one by one; once the response has started, errors can only be logged
+	archive := zip.NewWriter(w)
+	for _, relPath := range paths {
+		if err := addFileToZip(archive, root, relPath); err != nil {
+			log.Printf("Export: failed to add %s: %v\n", relPath, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		log.Printf("Export: failed to finish zip: %v\n", err)
+		return
+	}
+
+	fmt.Printf("📦 Exported %d files from %s\n", len(paths), root)
+}
+
+// addFileToZip adds root/relPath to the archive under relPath
+func addFileToZip(archive *zip.Writer, root, relPath string) error {
+	file, err := os.Open(filepath.Join(root, relPath))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = filepath.ToSlash(relPath)
+	header.Method = zip.Deflate
+
+	writer, err := archive.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(writer, file)
+	return err
+}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// trashDirName is the directory under the root where deleted files are kept for undo
+const trashDirName = ".trash"
+
+// PathRequest is the request body for /api/mkdir, /api/delete and /api/restore
+type PathRequest struct {
+	Path string `json:"path"`
+}
+
+// RenameRequest is the request body for /api/rename (also used to move files between directories)
+type RenameRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// File change notification clients (explorer refresh), keyed to the root directory they watch
+var (
+	fileWatchClients = make(map[*websocket.Conn]string)
+	fileWatchMutex   sync.Mutex
+)
+
+// getScopedPath resolves a path relative to root and rejects anything that escapes it.
+// Unlike getFullPath this is always enforced, since it guards destructive operations.
+func getScopedPath(root, relativePath string) (string, error) {
+	if relativePath == "" {
+		return "", fmt.Errorf("path is required")
+	}
+	if filepath.IsAbs(relativePath) {
+		return "", fmt.Errorf("absolute paths are not allowed")
+	}
+
+	fullPath := filepath.Join(root, filepath.Clean(relativePath))
+	if !isWithin(root, fullPath) {
+		return "", fmt.Errorf("path outside root directory")
+	}
+	if fullPath == root {
+		return "", fmt.Errorf("operation not allowed on the root directory")
+	}
+	if isWithin(filepath.Join(root, trashDirName), fullPath) {
+		return "", fmt.Errorf("operation not allowed on the trash directory")
+	}
+
+	return fullPath, nil
+}
+
+// isWithin reports whether target is base itself or located below it
+func isWithin(base, target string) bool {
+	rel, err := filepath.Rel(base, target)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// relativeToRoot returns a slash-separated path relative to root
+func relativeToRoot(root, fullPath string) string {
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil {
+		return fullPath
+	}
+	return filepath.ToSlash(rel)
+}
+
+// makeDirectory creates a directory (and any missing parents) within the root directory
+func makeDirectory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PathRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request format")
+		return
+	}
+
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	fullPath, err := getScopedPath(root, req.Path)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid path: %v", err))
+		return
+	}
+
+	if _, err := os.Stat(fullPath); err == nil {
+		sendErrorResponse(w, fmt.Sprintf("Already exists: %s", req.Path))
+		return
+	}
+
+	fmt.Printf("📁 Creating directory: %s\n", req.Path)
+	if err := os.MkdirAll(fullPath, 0755); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to create directory: %v", err))
+		return
+	}
+
+	notifyFileChange(root, "created", relativeToRoot(root, fullPath))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"path":    relativeToRoot(root, fullPath),
+	})
+}
+
+// renamePath renames or moves a file or directory within the root directory
+func renamePath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req RenameRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request format")
+		return
+	}
+
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	fromPath, err := getScopedPath(root, req.From)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid source path: %v", err))
+		return
+	}
+	toPath, err := getScopedPath(root, req.To)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid destination path: %v", err))
+		return
+	}
+
+	if _, err := os.Stat(fromPath); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Source not found: %s", req.From))
+		return
+	}
+	if _, err := os.Stat(toPath); err == nil {
+		sendErrorResponse(w, fmt.Sprintf("Destination already exists: %s", req.To))
+		return
+	}
+
+	// Moving a directory into itself would make it unreachable
+	if isWithin(fromPath, toPath) {
+		sendErrorResponse(w, "Cannot move a directory into itself")
+		return
+	}
+
+	fmt.Printf("✏️  Renaming: %s -> %s\n", req.From, req.To)
+	if err := os.MkdirAll(filepath.Dir(toPath), 0755); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to create destination directory: %v", err))
+		return
+	}
+	if err := os.Rename(fromPath, toPath); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to rename: %v", err))
+		return
+	}
+
+	notifyFileChange(root, "renamed", relativeToRoot(root, fromPath), relativeToRoot(root, toPath))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"from":    relativeToRoot(root, fromPath),
+		"to":      relativeToRoot(root, toPath),
+	})
+}
+
+// deletePath moves a file or directory into the trash directory so it can be restored
+func deletePath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req PathRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request format")
+		return
+	}
+
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	fullPath, err := getScopedPath(root, req.Path)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid path: %v", err))
+		return
+	}
+
+	if _, err := os.Lstat(fullPath); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Not found: %s", req.Path))
+		return
+	}
+
+	// Each delete gets its own trash entry that keeps the original relative path
+	relPath := relativeToRoot(root, fullPath)
+	trashID := time.Now().Format("20060102-150405.000000000")
+	trashPath := filepath.Join(root, trashDirName, trashID, filepath.FromSlash(relPath))
+
+	fmt.Printf("🗑️  Deleting: %s (moved to %s)\n", relPath, trashDirName)
+	if err := os.MkdirAll(filepath.Dir(trashPath), 0755); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to create trash directory: %v", err))
+		return
+	}
+	if err := os.Rename(fullPath, trashPath); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to delete: %v", err))
+		return
+	}
+
+	notifyFileChange(root, "deleted", relPath)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"path":    relPath,
+		"trashId": trashID,
+	})
+}
+
+// restorePath moves a trashed file or directory back to its original location (undo delete)
+func restorePath(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		TrashID string `json:"trashId"`
+		Path    string `json:"path"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request format")
+		return
+	}
+
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	if req.TrashID == "" || strings.ContainsAny(req.TrashID, `/\`) || strings.HasPrefix(req.TrashID, ".") {
+		sendErrorResponse(w, "Invalid trash ID")
+		return
+	}
+
+	fullPath, err := getScopedPath(root, req.Path)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid path: %v", err))
+		return
+	}
+
+	trashEntry := filepath.Join(root, trashDirName, req.TrashID)
+	trashPath := filepath.Join(trashEntry, filepath.FromSlash(relativeToRoot(root, fullPath)))
+	if _, err := os.Lstat(trashPath); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Nothing to restore for %s", req.Path))
+		return
+	}
+	if _, err := os.Lstat(fullPath); err == nil {
+		sendErrorResponse(w, fmt.Sprintf("Cannot restore, path already exists: %s", req.Path))
+		return
+	}
+
+	fmt.Printf("♻️  Restoring: %s\n", req.Path)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to create directory: %v", err))
+		return
+	}
+	if err := os.Rename(trashPath, fullPath); err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to restore: %v", err))
+		return
+	}
+	os.RemoveAll(trashEntry)
+
+	notifyFileChange(root, "created", relativeToRoot(root, fullPath))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"path":    relativeToRoot(root, fullPath),
+	})
+}
+
+// handleFilesWebSocket registers a client for file change notifications used to refresh the explorer
+func handleFilesWebSocket(w http.ResponseWriter, r *http.Request) {
+	root, err := requestRoot(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("Files WebSocket upgrade failed: %v\n", err)
+		return
+	}
+
+	fileWatchMutex.Lock()
+	fileWatchClients[conn] = root
+	fileWatchMutex.Unlock()
+
+	defer func() {
+		fileWatchMutex.Lock()
+		delete(fileWatchClients, conn)
+		fileWatchMutex.Unlock()
+		closeWebSocket(conn)
+	}()
+
+	// Notifications are one-way; read until the client disconnects
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			return
+		}
+	}
+}
+
+// notifyFileChange tells the explorers watching root that paths were created, renamed or deleted
+func notifyFileChange(root, change string, paths ...string) {
+	fileWatchMutex.Lock()
+	defer fileWatchMutex.Unlock()
+
+	for conn, watchedRoot := range fileWatchClients {
+		if watchedRoot != root {
+			continue
+		}
+		conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+		if err := conn.WriteJSON(map[string]interface{}{
+			"type":   "changed",
+			"change": change,
+			"paths":  paths,
+		}); err != nil {
+			conn.Close()
+			delete(fileWatchClients, conn)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultGitLogLimit is the number of commits /api/git/log returns unless limit is given
+const defaultGitLogLimit = 50
+
+// GitFile is a file of the session root with changes since the last commit
+type GitFile struct {
+	Path         string `json:"path"`                   // Relative to the session root
+	Status       string `json:"status"`                 // Porcelain XY code, e.g. " M", "A " or "??"
+	Staged       bool   `json:"staged"`                 // The index differs from HEAD
+	OrigPath     string `json:"origPath,omitempty"`     // Path before a rename or copy
+	Instrumented string `json:"instrumented,omitempty"` // Instrumented copy of the last hc --compile
+}
+
+// GitStatusResponse is the response of /api/git/status
+type GitStatusResponse struct {
+	Success    bool      `json:"success"`
+	Repository bool      `json:"repository"` // False when the session root is not in a git work tree
+	Toplevel   string    `json:"toplevel,omitempty"`
+	Branch     string    `json:"branch,omitempty"` // "(detached)" when HEAD is detached
+	Commit     string    `json:"commit,omitempty"`
+	Upstream   string    `json:"upstream,omitempty"`
+	Ahead      int       `json:"ahead"`
+	Behind     int       `json:"behind"`
+	Files      []GitFile `json:"files"`
+	// Files with an instrumented copy, changed or not, for diffs against the original
+	Instrumented []GitFile `json:"instrumented"`
+}
+
+// GitCommit is a commit of /api/git/log
+type GitCommit struct {
+	Hash    string    `json:"hash"`
+	Author  string    `json:"author"`
+	Date    time.Time `json:"date"`
+	Subject string    `json:"subject"`
+}
+
+// runGit runs git with args in root and returns its standard output
+func runGit(root string, args ...string) ([]byte, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = root
+	return runCommandOutput("git "+args[0], cmd)
+}
+
+// gitToplevel returns the top directory of the work tree containing root, "" if there is none
+func gitToplevel(root string) string {
+	if _, err := exec.LookPath("git"); err != nil {
+		return ""
+	}
+	cmd := exec.Command("git", "rev-parse", "--show-toplevel")
+	cmd.Dir = root
+	output, err := cmd.Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// getGitStatus returns the branch of the session root and the files changed in it
+func getGitStatus(w http.ResponseWriter, r *http.Request) {
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	response := GitStatusResponse{Success: true, Files: []GitFile{}, Instrumented: []GitFile{}}
+	instrumented := instrumentedCopies(root)
+	for original, copy := range instrumented {
+		response.Instrumented = append(response.Instrumented, GitFile{Path: original, Instrumented: copy})
+	}
+	sort.Slice(response.Instrumented, func(i, j int) bool {
+		return response.Instrumented[i].Path < response.Instrumented[j].Path
+	})
+
+	toplevel := gitToplevel(root)
+	if toplevel == "" {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+	response.Repository = true
+	response.Toplevel = toplevel
+
+	// Porcelain v2 with branch headers, NUL-terminated so paths need no unquoting
+	output, err := runGit(root, "status", "--porcelain=v2", "--branch", "-z", "--", ".")
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("git status failed: %v", err))
+		return
+	}
+	entries := strings.Split(strings.TrimSuffix(string(output), "\x00"), "\x00")
+	for i := 0; i < len(entries); i++ {
+		entry := entries[i]
+		switch {
+		case strings.HasPrefix(entry, "# branch.oid "):
+			response.Commit = strings.TrimPrefix(entry, "# branch.oid ")
+		case strings.HasPrefix(entry, "# branch.head "):
+			response.Branch = strings.TrimPrefix(entry, "# branch.head ")
+		case strings.HasPrefix(entry, "# branch.upstream "):
+			response.Upstream = strings.TrimPrefix(entry, "# branch.upstream ")
+		case strings.HasPrefix(entry, "# branch.ab "):
+			fmt.Sscanf(strings.TrimPrefix(entry, "# branch.ab "), "+%d -%d", &response.Ahead, &response.Behind)
+		case strings.HasPrefix(entry, "1 "), strings.HasPrefix(entry, "u "):
+			// 1 XY sub mH mI mW hH hI path; u XY sub m1 m2 m3 mW h1 h2 h3 path
+			fields := 9
+			if entry[0] == 'u' {
+				fields = 11
+			}
+			parts := strings.SplitN(entry, " ", fields)
+			if len(parts) == fields {
+				response.Files = append(response.Files, newGitFile(root, toplevel, parts[1], parts[fields-1], ""))
+			}
+		case strings.HasPrefix(entry, "2 "):
+			// 2 XY sub mH mI mW hH hI Xscore path, followed by the original path
+			parts := strings.SplitN(entry, " ", 10)
+			origPath := ""
+			if i+1 < len(entries) {
+				i++
+				origPath = entries[i]
+			}
+			if len(parts) == 10 {
+				response.Files = append(response.Files, newGitFile(root, toplevel, parts[1], parts[9], origPath))
+			}
+		case strings.HasPrefix(entry, "? "):
+			response.Files = append(response.Files, newGitFile(root, toplevel, "??", strings.TrimPrefix(entry, "? "), ""))
+		}
+	}
+	for i := range response.Files {
+		response.Files[i].Instrumented = instrumented[response.Files[i].Path]
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// newGitFile returns a changed file, with its path made relative to the session root
+func newGitFile(root, toplevel, status, path, origPath string) GitFile {
+	file := GitFile{
+		Path:   gitRelPath(root, toplevel, path),
+		Status: strings.ReplaceAll(status, ".", " "),
+		Staged: status[0] != '.' && status[0] != '?',
+	}
+	if origPath != "" {
+		file.OrigPath = gitRelPath(root, toplevel, origPath)
+	}
+	return file
+}
+
+// gitRelPath converts a path relative to the top of the work tree to one relative to root
+func gitRelPath(root, toplevel, path string) string {
+	if rel, err := filepath.Rel(root, filepath.Join(toplevel, path)); err == nil {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
+
+// instrumentedCopies returns the instrumented copy of every source file of the root, by path
+// relative to it, read from the source mappings of the last hc --compile
+func instrumentedCopies(root string) map[string]string {
+	copies := make(map[string]string)
+	data, err := os.ReadFile(filepath.Join(root, "build-metadata", "source-mappings.json"))
+	if err != nil {
+		return copies
+	}
+	var mappings SourceMappings
+	if err := json.Unmarshal(data, &mappings); err != nil {
+		return copies
+	}
+	for _, mapping := range mappings.Mappings {
+		rel, err := filepath.Rel(root, mapping.Original)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		// The WORK directory is gone after a replay; the debug copy stays
+		copy := mapping.Instrumented
+		if _, err := os.Stat(copy); err != nil && mapping.DebugCopy != "" {
+			copy = mapping.DebugCopy
+		}
+		copies[filepath.ToSlash(rel)] = copy
+	}
+	return copies
+}
+
+// getGitDiff returns the diff of a file of the session root: its changes since the last commit,
+// or with instrumented=true the changes instrumentation made to it in the last hc --compile
+func getGitDiff(w http.ResponseWriter, r *http.Request) {
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		sendErrorResponse(w, "path is required")
+		return
+	}
+	fullPath, err := getFullPath(root, path)
+	if err != nil {
+		sendErrorResponse(w, "Invalid path - path outside root directory")
+		return
+	}
+	rel, err := filepath.Rel(root, fullPath)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid path: %v", err))
+		return
+	}
+	rel = filepath.ToSlash(rel)
+
+	var diff []byte
+	if r.URL.Query().Get("instrumented") == "true" {
+		copy, exists := instrumentedCopies(root)[rel]
+		if !exists {
+			sendErrorResponse(w, fmt.Sprintf("%s has no instrumented copy, run Compile with Hooks first", rel))
+			return
+		}
+		diff, err = gitNoIndexDiff(root, rel, copy)
+	} else if gitToplevel(root) == "" {
+		sendErrorResponse(w, "No repository detected")
+		return
+	} else if indexed(root, rel) {
+		// Staged and unstaged changes against the last commit, from the path before a rename
+		args := []string{"diff", "--no-color", "-M", "HEAD", "--", rel}
+		if origPath := r.URL.Query().Get("origPath"); origPath != "" {
+			if _, err := getFullPath(root, origPath); err != nil {
+				sendErrorResponse(w, "Invalid path - path outside root directory")
+				return
+			}
+			args = append(args, origPath)
+		}
+		diff, err = runGit(root, args...)
+	} else {
+		diff, err = gitNoIndexDiff(root, os.DevNull, rel)
+	}
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("git diff failed: %v", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(FileResponse{Success: true, Content: string(diff)})
+}
+
+// indexed reports whether a file of root is in the index, i.e. not untracked
+func indexed(root, rel string) bool {
+	_, err := runGit(root, "ls-files", "--error-unmatch", "--", rel)
+	return err == nil
+}
+
+// gitNoIndexDiff returns the diff between two files, in or out of a repository. git diff
+// --no-index exits with 1 when the files differ, which is not a failure.
+func gitNoIndexDiff(root, from, to string) ([]byte, error) {
+	cmd := exec.Command("git", "diff", "--no-color", "--no-index", "--", from, to)
+	cmd.Dir = root
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	analysisJobsRunning.add(1, "command", "git diff")
+	start := time.Now()
+	err := runTracked(cmd)
+	analysisJobsRunning.add(-1, "command", "git diff")
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+		err = nil
+	}
+	recordCommand("git diff", err == nil, time.Since(start))
+	if err != nil {
+		return nil, fmt.Errorf("%v\n%s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
+
+// getGitLog returns the last commits of the session root, or of a file of it with path
+func getGitLog(w http.ResponseWriter, r *http.Request) {
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+	if gitToplevel(root) == "" {
+		sendErrorResponse(w, "No repository detected")
+		return
+	}
+	limit := defaultGitLogLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			sendErrorResponse(w, "limit must be a positive number")
+			return
+		}
+	}
+	args := []string{"log", "-n", strconv.Itoa(limit), "--format=%H%x1f%an%x1f%aI%x1f%s%x1e", "--"}
+	if path := r.URL.Query().Get("path"); path != "" {
+		if _, err := getFullPath(root, path); err != nil {
+			sendErrorResponse(w, "Invalid path - path outside root directory")
+			return
+		}
+		args = append(args, path)
+	} else {
+		args = append(args, ".")
+	}
+
+	commits := []GitCommit{}
+	output, err := runGit(root, args...)
+	if err != nil {
+		// A repository without commits has no log
+		if strings.Contains(err.Error(), "does not have any commits") {
+			output, err = nil, nil
+		} else {
+			sendErrorResponse(w, fmt.Sprintf("git log failed: %v", err))
+			return
+		}
+	}
+	for _, record := range strings.Split(string(output), "\x1e") {
+		fields := strings.Split(strings.TrimSpace(record), "\x1f")
+		if len(fields) != 4 {
+			continue
+		}
+		date, _ := time.Parse(time.RFC3339, fields[2])
+		commits = append(commits, GitCommit{Hash: fields[0], Author: fields[1], Date: date, Subject: fields[3]})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "commits": commits})
+}
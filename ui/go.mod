@@ -2,4 +2,4 @@ module github.com/pdelewski/go-build-interceptor/ui
 
 go 1.24.4
 
-require github.com/gorilla/websocket v1.5.3 // indirect
+require github.com/gorilla/websocket v1.5.3
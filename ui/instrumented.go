@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// trampolineCallPattern matches the calls instrumentation injects into a function, with the
+// trampoline kind and the function it was generated for
+var trampolineCallPattern = regexp.MustCompile(`Otel(Before|After)Trampoline_(\w+)\(`)
+
+// TrampolineCall is a line of an instrumented copy calling a trampoline
+type TrampolineCall struct {
+	Line     int    `json:"line"`     // 1-based, in the instrumented copy
+	Kind     string `json:"kind"`     // "before" or "after"
+	Function string `json:"function"` // Pascal-case name the trampolines were generated for
+}
+
+// InstrumentedSourceResponse is the response of /api/instrumented-source
+type InstrumentedSourceResponse struct {
+	Success          bool             `json:"success"`
+	Path             string           `json:"path,omitempty"` // Relative to the session root
+	Original         string           `json:"original,omitempty"`
+	Instrumented     string           `json:"instrumented,omitempty"`
+	InstrumentedPath string           `json:"instrumentedPath,omitempty"` // Copy in WORK, or its debug copy
+	Trampolines      []TrampolineCall `json:"trampolines,omitempty"`
+	Files            []string         `json:"files,omitempty"` // Files with instrumented copies, without path
+}
+
+// getInstrumentedSource returns a file of the session root and its instrumented copy of the last
+// hc --compile, with the trampoline calls instrumentation injected, for the side-by-side view.
+// Without path it lists the files that have instrumented copies.
+func getInstrumentedSource(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+	copies := instrumentedCopies(root)
+
+	path := r.URL.Query().Get("path")
+	if path == "" {
+		files := make([]string, 0, len(copies))
+		for rel := range copies {
+			files = append(files, rel)
+		}
+		sort.Strings(files)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"success": true, "files": files})
+		return
+	}
+
+	fullPath, err := getFullPath(root, path)
+	if err != nil {
+		sendErrorResponse(w, "Invalid path - path outside root directory")
+		return
+	}
+	rel := relativeToRoot(root, fullPath)
+	copy, exists := copies[rel]
+	if !exists {
+		sendErrorResponse(w, fmt.Sprintf("%s has no instrumented copy, run Compile with Hooks first", rel))
+		return
+	}
+	original, err := os.ReadFile(fullPath)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to read %s: %v", rel, err))
+		return
+	}
+	instrumented, err := os.ReadFile(copy)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to read the instrumented copy %s: %v", copy, err))
+		return
+	}
+
+	response := InstrumentedSourceResponse{
+		Success:          true,
+		Path:             rel,
+		Original:         string(original),
+		Instrumented:     string(instrumented),
+		InstrumentedPath: filepath.ToSlash(copy),
+		Trampolines:      trampolineCalls(string(instrumented)),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// trampolineCalls returns the lines of an instrumented file calling trampolines
+func trampolineCalls(content string) []TrampolineCall {
+	var calls []TrampolineCall
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), len(content)+1)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		// Comments naming a trampoline are not calls
+		if strings.HasPrefix(strings.TrimSpace(text), "//") {
+			continue
+		}
+		for _, match := range trampolineCallPattern.FindAllStringSubmatch(text, -1) {
+			calls = append(calls, TrampolineCall{Line: line, Kind: strings.ToLower(match[1]), Function: match[2]})
+		}
+	}
+	return calls
+}
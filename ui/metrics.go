@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metric types in the Prometheus text exposition format
+const (
+	counterMetric   = "counter"
+	gaugeMetric     = "gauge"
+	histogramMetric = "histogram"
+)
+
+// Histogram buckets in seconds for HTTP requests and commands
+var (
+	requestDurationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	commandDurationBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+)
+
+// metric is a metric family: one value (or histogram) per label set
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	buckets []float64
+
+	mu         sync.Mutex
+	values     map[string]float64
+	histograms map[string]*histogram
+}
+
+// histogram holds cumulative bucket counts, sum and count for one label set
+type histogram struct {
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// Server metrics exposed on /metrics
+var (
+	httpRequestsTotal = newMetric("hc_ui_http_requests_total",
+		"HTTP requests by handler, method and status code.", counterMetric, nil)
+	httpRequestDuration = newMetric("hc_ui_http_request_duration_seconds",
+		"HTTP request latency by handler (WebSocket connections excluded).", histogramMetric, requestDurationBuckets)
+	webSocketConnections = newMetric("hc_ui_websocket_connections",
+		"Open WebSocket connections by handler.", gaugeMetric, nil)
+	analysisJobsRunning = newMetric("hc_ui_analysis_jobs_running",
+		"Commands (hc, go, executables) currently running by command.", gaugeMetric, nil)
+	commandsExecutedTotal = newMetric("hc_ui_commands_executed_total",
+		"Executed commands by command and result.", counterMetric, nil)
+	commandDuration = newMetric("hc_ui_command_duration_seconds",
+		"Command run time by command.", histogramMetric, commandDurationBuckets)
+	cacheRequestsTotal = newMetric("hc_ui_cache_requests_total",
+		"Cache lookups by cache and result (hit or miss): static assets revalidated by the browser and reused build logs.", counterMetric, nil)
+)
+
+// allMetrics lists the metrics in /metrics output order
+var allMetrics = []*metric{
+	httpRequestsTotal, httpRequestDuration, webSocketConnections,
+	analysisJobsRunning, commandsExecutedTotal, commandDuration, cacheRequestsTotal,
+}
+
+func newMetric(name, help, kind string, buckets []float64) *metric {
+	return &metric{
+		name:       name,
+		help:       help,
+		kind:       kind,
+		buckets:    buckets,
+		values:     make(map[string]float64),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+// formatLabels renders label name/value pairs as {name="value",...}
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var parts []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		parts = append(parts, fmt.Sprintf("%s=%q", labels[i], value))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+// add adds delta to the counter or gauge with the given label name/value pairs
+func (m *metric) add(delta float64, labels ...string) {
+	key := formatLabels(labels)
+	m.mu.Lock()
+	m.values[key] += delta
+	m.mu.Unlock()
+}
+
+// observe records a value in the histogram with the given label name/value pairs
+func (m *metric) observe(value float64, labels ...string) {
+	key := formatLabels(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h, exists := m.histograms[key]
+	if !exists {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		m.histograms[key] = h
+	}
+	for i, bound := range m.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// write renders the metric family in the Prometheus text format
+func (m *metric) write(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+
+	if m.kind != histogramMetric {
+		for _, key := range sortedKeys(m.values) {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, key, formatValue(m.values[key]))
+		}
+		return
+	}
+
+	for _, key := range sortedKeys(m.histograms) {
+		h := m.histograms[key]
+		// Bucket labels are appended to the label set
+		labelPrefix := "{"
+		if key != "" {
+			labelPrefix = strings.TrimSuffix(key, "}") + ","
+		}
+		for i, bound := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%sle=\"%s\"} %d\n", m.name, labelPrefix, formatValue(bound), h.counts[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%sle=\"+Inf\"} %d\n", m.name, labelPrefix, h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, key, formatValue(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, key, h.count)
+	}
+}
+
+func sortedKeys[V any](values map[string]V) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// handleMetrics serves all metrics in the Prometheus text format
+func handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+
+	bw := bufio.NewWriter(w)
+	for _, m := range allMetrics {
+		m.write(bw)
+	}
+
+	sessionsMutex.Lock()
+	sessionCount := len(sessions)
+	sessionsMutex.Unlock()
+	fmt.Fprintf(bw, "# HELP hc_ui_sessions Open browser sessions.\n# TYPE hc_ui_sessions gauge\nhc_ui_sessions %d\n", sessionCount)
+
+	bw.Flush()
+}
+
+// statusRecorder captures the status code of a response. It passes Hijack through so
+// WebSocket upgrades keep working.
+type statusRecorder struct {
+	http.ResponseWriter
+	status   int
+	hijacked bool
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *statusRecorder) Flush() {
+	if flusher, ok := r.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := r.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer does not support hijacking")
+	}
+	r.hijacked = true
+	r.status = http.StatusSwitchingProtocols
+	return hijacker.Hijack()
+}
+
+// instrumentHandler records request counts and latencies, labelled by the matched route
+// pattern rather than the raw path to keep the number of series bounded
+func instrumentHandler(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := mux.Handler(r)
+		if pattern == "" {
+			pattern = "unmatched"
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		mux.ServeHTTP(recorder, r)
+
+		status := recorder.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		httpRequestsTotal.add(1, "handler", pattern, "method", r.Method, "code", strconv.Itoa(status))
+		if !recorder.hijacked {
+			httpRequestDuration.observe(time.Since(start).Seconds(), "handler", pattern)
+		}
+
+		// The browser revalidates static assets; 304 means its cached copy was reused
+		if pattern == "/static/" {
+			switch status {
+			case http.StatusNotModified:
+				cacheRequestsTotal.add(1, "cache", "static", "result", "hit")
+			case http.StatusOK:
+				cacheRequestsTotal.add(1, "cache", "static", "result", "miss")
+			}
+		}
+	})
+}
+
+// runCommand runs cmd to completion and returns its combined output, recording it in the
+// running jobs gauge and the executed command metrics under name. The output is streamed
+// to /ws/logs while the command runs.
+func runCommand(name string, cmd *exec.Cmd) ([]byte, error) {
+	analysisJobsRunning.add(1, "command", name)
+	defer analysisJobsRunning.add(-1, "command", name)
+
+	var output lockedBuffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	start := time.Now()
+	err := runTracked(cmd)
+	recordCommand(name, err == nil, time.Since(start))
+	return output.Bytes(), err
+}
+
+// recordCommand records a finished command in the executed command metrics
+func recordCommand(name string, success bool, duration time.Duration) {
+	result := "success"
+	if !success {
+		result = "failure"
+	}
+	commandsExecutedTotal.add(1, "command", name, "result", result)
+	commandDuration.observe(duration.Seconds(), "command", name)
+}
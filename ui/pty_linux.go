@@ -0,0 +1,70 @@
+//go:build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+// startPTY starts cmd as the leader of a new session on a new pseudo-terminal and returns the
+// master side of the terminal, which reads the output of cmd and writes its input
+func startPTY(cmd *exec.Cmd) (*os.File, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open a pseudo-terminal: %w", err)
+	}
+	var number uint32
+	unlock := int32(0)
+	if err := ptyIoctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to unlock the pseudo-terminal: %w", err)
+	}
+	if err := ptyIoctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number))); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to get the pseudo-terminal number: %w", err)
+	}
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", number), os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to open the pseudo-terminal: %w", err)
+	}
+	defer tty.Close()
+
+	resizePTY(master, 24, 80)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 0}
+	if err := cmd.Start(); err != nil {
+		master.Close()
+		return nil, fmt.Errorf("failed to start %s: %w", cmd.Path, err)
+	}
+	return master, nil
+}
+
+// resizePTY sets the size of the terminal, which sends SIGWINCH to its foreground processes
+func resizePTY(master *os.File, rows, cols uint16) error {
+	size := struct{ rows, cols, x, y uint16 }{rows, cols, 0, 0}
+	return ptyIoctl(master, syscall.TIOCSWINSZ, uintptr(unsafe.Pointer(&size)))
+}
+
+// ptyIoctl runs an ioctl on the master side of a terminal. It goes through SyscallConn rather
+// than Fd, which would switch the file to blocking mode and keep Close from interrupting a Read.
+func ptyIoctl(master *os.File, request, arg uintptr) error {
+	raw, err := master.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err := raw.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, arg)
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+)
+
+// startPTY is only implemented on Linux
+func startPTY(cmd *exec.Cmd) (*os.File, error) {
+	return nil, fmt.Errorf("the terminal is not supported on %s", runtime.GOOS)
+}
+
+// resizePTY is only implemented on Linux
+func resizePTY(master *os.File, rows, cols uint16) error {
+	return nil
+}
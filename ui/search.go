@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits of /api/search
+const (
+	defaultSearchLimit = 1000    // Matches returned unless limit is given
+	maxSearchFileSize  = 1 << 20 // Larger files are skipped
+	maxSearchLineText  = 300     // Characters of a matching line returned
+)
+
+// searchSkippedDirs are the directories /api/search skips outside git work trees, which
+// use .gitignore instead
+var searchSkippedDirs = map[string]bool{
+	".git":          true,
+	"node_modules":  true,
+	trashDirName:    true,
+	".hc-snapshots": true,
+	".otel-build":   true,
+}
+
+// SearchMatch is a match of /api/search in a line of a file
+type SearchMatch struct {
+	Line   int    `json:"line"`   // 1-based
+	Column int    `json:"column"` // 1-based, in characters
+	Length int    `json:"length"` // In characters
+	Text   string `json:"text"`   // The line, cut after maxSearchLineText characters
+}
+
+// SearchFile is a file with matches of /api/search
+type SearchFile struct {
+	Path    string        `json:"path"` // Relative to the session root
+	Matches []SearchMatch `json:"matches"`
+}
+
+// SearchResponse is the response of /api/search
+type SearchResponse struct {
+	Success   bool         `json:"success"`
+	Files     []SearchFile `json:"files"`
+	Matches   int          `json:"matches"`
+	Searched  int          `json:"searched"`  // Files searched
+	Truncated bool         `json:"truncated"` // The limit was reached before every file was searched
+}
+
+// searchPatterns splits a comma-separated list of include or exclude patterns
+func searchPatterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.Trim(strings.TrimSpace(pattern), "/")
+		pattern = strings.TrimSuffix(strings.TrimPrefix(pattern, "**/"), "/**")
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// matchesSearchPattern reports whether a file, relative to the root with slashes, or one of
+// its directories matches a pattern by path or by name, e.g. *.go, vendor or hc/parse
+func matchesSearchPattern(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		for candidate := rel; candidate != "."; candidate = path.Dir(candidate) {
+			if ok, _ := path.Match(pattern, candidate); ok {
+				return true
+			}
+			if ok, _ := path.Match(pattern, path.Base(candidate)); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// searchFileList returns the files of root to search, relative to it with slashes. In a git
+// work tree these are the tracked and untracked files not ignored by .gitignore.
+func searchFileList(root string) ([]string, error) {
+	if gitToplevel(root) != "" {
+		output, err := runGit(root, "ls-files", "-z", "--cached", "--others", "--exclude-standard")
+		if err == nil {
+			var files []string
+			seen := make(map[string]bool)
+			for _, rel := range strings.Split(string(output), "\x00") {
+				// Files with unmerged changes are listed once per stage
+				if rel != "" && !seen[rel] {
+					seen[rel] = true
+					files = append(files, rel)
+				}
+			}
+			return files, nil
+		}
+	}
+
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if p == root {
+				return err
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if p != root && searchSkippedDirs[d.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			files = append(files, relativeToRoot(root, p))
+		}
+		return nil
+	})
+	return files, err
+}
+
+// searchFile appends the matches of re in a file to result, up to limit matches in total
+func searchFile(result *SearchResponse, root, rel string, re *regexp.Regexp, limit int) {
+	fullPath := filepath.Join(root, filepath.FromSlash(rel))
+	info, err := os.Lstat(fullPath)
+	if err != nil || !info.Mode().IsRegular() || info.Size() > maxSearchFileSize {
+		return
+	}
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		return
+	}
+	// Binary files, as git tells them apart
+	head := data
+	if len(head) > 8000 {
+		head = head[:8000]
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		return
+	}
+	result.Searched++
+
+	file := SearchFile{Path: rel}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxSearchFileSize+1)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		for _, loc := range re.FindAllStringIndex(line, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			if result.Matches == limit {
+				result.Truncated = true
+				break
+			}
+			text := line
+			if utf8.RuneCountInString(text) > maxSearchLineText {
+				text = string([]rune(text)[:maxSearchLineText])
+			}
+			file.Matches = append(file.Matches, SearchMatch{
+				Line:   lineNumber,
+				Column: utf8.RuneCountInString(line[:loc[0]]) + 1,
+				Length: utf8.RuneCountInString(line[loc[0]:loc[1]]),
+				Text:   text,
+			})
+			result.Matches++
+		}
+		if result.Truncated {
+			break
+		}
+	}
+	if len(file.Matches) > 0 {
+		result.Files = append(result.Files, file)
+	}
+}
+
+// getSearch searches the contents of the files of the session root for q: a regular
+// expression with regex=true, else text, matched ignoring case unless caseSensitive=true and
+// as a whole word with word=true. include and exclude take comma-separated patterns of paths
+// or names, e.g. *.go,hc/parse. At most limit matches are returned.
+func getSearch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+	query := r.URL.Query()
+	q := query.Get("q")
+	if q == "" {
+		sendErrorResponse(w, "q is required")
+		return
+	}
+	expr := regexp.QuoteMeta(q)
+	if query.Get("regex") == "true" {
+		if _, err := regexp.Compile(q); err != nil {
+			sendErrorResponse(w, fmt.Sprintf("Invalid regular expression: %v", err))
+			return
+		}
+		expr = q
+	}
+	if query.Get("word") == "true" {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if query.Get("caseSensitive") != "true" {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid regular expression: %v", err))
+		return
+	}
+	limit := defaultSearchLimit
+	if value := query.Get("limit"); value != "" {
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 {
+			sendErrorResponse(w, "limit must be a positive number")
+			return
+		}
+	}
+	include := searchPatterns(query.Get("include"))
+	exclude := searchPatterns(query.Get("exclude"))
+
+	files, err := searchFileList(root)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to list files: %v", err))
+		return
+	}
+	result := SearchResponse{Success: true, Files: []SearchFile{}}
+	for _, rel := range files {
+		if r.Context().Err() != nil {
+			return
+		}
+		if len(include) > 0 && !matchesSearchPattern(include, rel) {
+			continue
+		}
+		if matchesSearchPattern(exclude, rel) {
+			continue
+		}
+		searchFile(&result, root, rel, re, limit)
+		if result.Truncated {
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// systemdListenFDsStart is the first file descriptor passed by systemd socket activation
+const systemdListenFDsStart = 3
+
+// shutdownTimeout is how long in-flight requests may take to finish on shutdown (-shutdown-timeout)
+var shutdownTimeout = 30 * time.Second
+
+// serverReady reports readiness on /readyz; cleared when shutdown starts
+var serverReady atomic.Bool
+
+// Open WebSocket connections, closed on shutdown since http.Server.Shutdown does not
+// track hijacked connections
+var (
+	webSockets      = make(map[*websocket.Conn]string)
+	webSocketsMutex sync.Mutex
+)
+
+// upgradeWebSocket upgrades the request to a WebSocket connection that is closed on shutdown
+func upgradeWebSocket(w http.ResponseWriter, r *http.Request) (*websocket.Conn, error) {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	webSocketsMutex.Lock()
+	webSockets[conn] = r.URL.Path
+	webSocketsMutex.Unlock()
+	webSocketConnections.add(1, "handler", r.URL.Path)
+	return conn, nil
+}
+
+// closeWebSocket closes a connection opened with upgradeWebSocket
+func closeWebSocket(conn *websocket.Conn) {
+	webSocketsMutex.Lock()
+	path, open := webSockets[conn]
+	delete(webSockets, conn)
+	webSocketsMutex.Unlock()
+	if open {
+		webSocketConnections.add(-1, "handler", path)
+	}
+	conn.Close()
+}
+
+// closeAllWebSockets tells all clients the server is going away and closes their connections
+func closeAllWebSockets() {
+	webSocketsMutex.Lock()
+	defer webSocketsMutex.Unlock()
+
+	message := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+	for conn, path := range webSockets {
+		conn.WriteControl(websocket.CloseMessage, message, time.Now().Add(time.Second))
+		conn.Close()
+		delete(webSockets, conn)
+		webSocketConnections.add(-1, "handler", path)
+	}
+}
+
+// stopChildProcesses kills the executable and debugger started from the UI
+func stopChildProcesses() {
+	runningMutex.Lock()
+	if runningCmd != nil && runningCmd.Process != nil {
+		log.Printf("Killing running process (PID: %d)\n", runningCmd.Process.Pid)
+		runningCmd.Process.Kill()
+	}
+	runningMutex.Unlock()
+
+	dlvMutex.Lock()
+	if dlvCmd != nil && dlvCmd.Process != nil {
+		log.Printf("Killing dlv (PID: %d)\n", dlvCmd.Process.Pid)
+		dlvCmd.Process.Kill()
+	}
+	dlvMutex.Unlock()
+}
+
+// handleHealthz is the liveness endpoint: the process is up and serving requests
+func handleHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ok",
+	})
+}
+
+// handleReadyz is the readiness endpoint: it fails until the server is serving and again once
+// shutdown has started, so load balancers stop sending new requests while in-flight ones drain
+func handleReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !serverReady.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": "not ready",
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "ready",
+	})
+}
+
+// systemdListener returns the listener passed by systemd socket activation, or nil when
+// the server was not socket activated
+func systemdListener() (net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count < 1 {
+		return nil, nil
+	}
+	if count > 1 {
+		log.Printf("Warning: %d sockets passed by systemd, using the first one\n", count)
+	}
+
+	// Don't pass the sockets on to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	file := os.NewFile(uintptr(systemdListenFDsStart), "systemd-socket")
+	listener, err := net.FileListener(file)
+	file.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to use socket passed by systemd: %w", err)
+	}
+	return listener, nil
+}
+
+// sdNotify sends a state update to systemd (Type=notify services); a no-op elsewhere
+func sdNotify(state string) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return
+	}
+	// Abstract socket names start with '@'
+	if strings.HasPrefix(socketPath, "@") {
+		socketPath = "\x00" + socketPath[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socketPath, Net: "unixgram"})
+	if err != nil {
+		log.Printf("Warning: failed to notify systemd: %v\n", err)
+		return
+	}
+	defer conn.Close()
+	conn.Write([]byte(state))
+}
+
+// runServer serves HTTP on the systemd socket if one was passed, otherwise on addr.
+// On SIGINT or SIGTERM it stops accepting connections, drains in-flight requests for up
+// to shutdownTimeout, closes WebSocket connections and stops child processes.
+func runServer(addr string) error {
+	listener, err := systemdListener()
+	if err != nil {
+		return err
+	}
+	if listener != nil {
+		log.Printf("Using socket passed by systemd: %s\n", listener.Addr())
+	} else {
+		listener, err = net.Listen("tcp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+	}
+
+	if tcpAddr, ok := listener.Addr().(*net.TCPAddr); ok {
+		fmt.Printf("📝 Access the editor at: http://localhost:%d\n", tcpAddr.Port)
+	}
+
+	server := &http.Server{
+		Handler:           instrumentHandler(http.DefaultServeMux),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	server.RegisterOnShutdown(closeAllWebSockets)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		serveErr <- server.Serve(listener)
+	}()
+
+	serverReady.Store(true)
+	sdNotify("READY=1")
+
+	select {
+	case err := <-serveErr:
+		return err
+	case <-ctx.Done():
+	}
+	// A second signal exits immediately
+	stop()
+
+	fmt.Printf("\n⏹️  Shutting down (waiting up to %s for requests to finish)...\n", shutdownTimeout)
+	serverReady.Store(false)
+	sdNotify("STOPPING=1")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	err = server.Shutdown(shutdownCtx)
+	stopChildProcesses()
+	if err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("shutdown failed: %w", err)
+	}
+	if err != nil {
+		log.Println("Shutdown timed out, closing remaining connections")
+		server.Close()
+	}
+
+	fmt.Println("👋 Server stopped")
+	return nil
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// sessionCookieName is the cookie identifying a browser session
+const sessionCookieName = "hc_session"
+
+// Sessions unused for sessionIdleTimeout are dropped, and the least recently used ones once
+// there are maxSessions, so requests without the cookie don't grow the registry forever
+const (
+	sessionIdleTimeout = 24 * time.Hour
+	maxSessions        = 1000
+)
+
+// Session is a per-browser working context. Each session has its own root directory, so
+// users working on different projects (or checkouts) get separate build-metadata outputs.
+type Session struct {
+	ID   string
+	Root string // Guarded by sessionsMutex, read it with root()
+
+	lastUsed time.Time // Guarded by sessionsMutex
+}
+
+// Session registry
+var (
+	sessions      = make(map[string]*Session)
+	sessionsMutex sync.Mutex
+)
+
+// Per-root locks serializing runs that write build-metadata in the same directory
+var (
+	rootLocks      = make(map[string]*sync.Mutex)
+	rootLocksMutex sync.Mutex
+)
+
+// newSessionID returns a random session identifier
+func newSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// lookupSession returns the session referenced by the request cookie, if any
+func lookupSession(r *http.Request) *Session {
+	cookie, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return nil
+	}
+
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	session := sessions[cookie.Value]
+	if session == nil {
+		return nil
+	}
+	now := time.Now()
+	if now.Sub(session.lastUsed) > sessionIdleTimeout {
+		delete(sessions, session.ID)
+		return nil
+	}
+	session.lastUsed = now
+	return session
+}
+
+// root returns the session's working directory
+func (s *Session) root() string {
+	sessionsMutex.Lock()
+	defer sessionsMutex.Unlock()
+	return s.Root
+}
+
+// pruneSessions drops idle sessions and, at maxSessions, the least recently used one, making
+// room for a new session. sessionsMutex must be held.
+func pruneSessions(now time.Time) {
+	var oldest *Session
+	for id, session := range sessions {
+		if now.Sub(session.lastUsed) > sessionIdleTimeout {
+			delete(sessions, id)
+			continue
+		}
+		if oldest == nil || session.lastUsed.Before(oldest.lastUsed) {
+			oldest = session
+		}
+	}
+	if len(sessions) >= maxSessions && oldest != nil {
+		delete(sessions, oldest.ID)
+	}
+}
+
+// ensureSession returns the request's session, creating it (and setting the cookie) if needed
+func ensureSession(w http.ResponseWriter, r *http.Request) (*Session, error) {
+	if session := lookupSession(r); session != nil {
+		return session, nil
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create session: %w", err)
+	}
+	now := time.Now()
+	session := &Session{ID: id, Root: rootDirectory, lastUsed: now}
+
+	sessionsMutex.Lock()
+	pruneSessions(now)
+	sessions[id] = session
+	sessionsMutex.Unlock()
+
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    id,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	log.Printf("New session %s (root: %s)\n", id[:8], rootDirectory)
+	return session, nil
+}
+
+// resolveRoot validates a requested root directory. It must be an existing directory and,
+// when navigation is restricted, located within the server's root directory.
+func resolveRoot(requested string) (string, error) {
+	absRoot, err := filepath.Abs(requested)
+	if err != nil {
+		return "", fmt.Errorf("invalid root %s: %w", requested, err)
+	}
+
+	info, err := os.Stat(absRoot)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("root is not a directory: %s", absRoot)
+	}
+
+	if restrictNavigation && !isWithin(rootDirectory, absRoot) {
+		return "", fmt.Errorf("root outside server root directory: %s", absRoot)
+	}
+
+	return absRoot, nil
+}
+
+// requestRoot returns the working directory for a request: a per-request ?root= parameter,
+// then the session's root, then the server's root directory
+func requestRoot(r *http.Request) (string, error) {
+	if requested := r.URL.Query().Get("root"); requested != "" {
+		return resolveRoot(requested)
+	}
+	if session := lookupSession(r); session != nil {
+		return session.root(), nil
+	}
+	return rootDirectory, nil
+}
+
+// setSessionRoot switches the session's working directory
+func setSessionRoot(session *Session, requested string) error {
+	root, err := resolveRoot(requested)
+	if err != nil {
+		return err
+	}
+
+	sessionsMutex.Lock()
+	session.Root = root
+	sessionsMutex.Unlock()
+
+	log.Printf("Session %s root set to %s\n", session.ID[:8], root)
+	return nil
+}
+
+// lockRoot serializes runs that write build-metadata in root. It returns the unlock function.
+func lockRoot(root string) func() {
+	rootLocksMutex.Lock()
+	mu, exists := rootLocks[root]
+	if !exists {
+		mu = &sync.Mutex{}
+		rootLocks[root] = mu
+	}
+	rootLocksMutex.Unlock()
+
+	mu.Lock()
+	return mu.Unlock
+}
@@ -16,6 +16,10 @@ class CodeEditor {
         this.breakpoints = new Map();
         this.breakpointDecorations = new Map(); // Map of filename -> decoration IDs
 
+        // File change notifications (explorer refresh)
+        this.filesSocket = null;
+        this.lastDeleted = null; // { path, trashId } of the last delete, for undo
+
         // LSP WebSocket connection
         this.lspSocket = null;
         this.lspRequestId = 0;
@@ -106,6 +110,9 @@ class CodeEditor {
             // Now initialize other components
             this.initializeEventListeners();
             this.loadFileTree();
+            loadGitStatus();
+            initializeSearch();
+            this.connectFilesWebSocket();
             this.updateUI();
             this.initializeResize();
             this.initializeTerminalResize();
@@ -642,6 +649,8 @@ class CodeEditor {
             this.loadFileTree();
         } else if (panelName === 'search') {
             document.getElementById('searchInput')?.focus();
+        } else if (panelName === 'git') {
+            loadGitStatus();
         }
     }
     
@@ -1201,11 +1210,194 @@ class CodeEditor {
     }
     
     createNewFile() {
-        const filename = prompt('Enter filename:');
+        const filename = prompt('Enter filename:', this.getSelectedExplorerDir());
         if (filename) {
             this.createOrSwitchTab(filename, '');
         }
     }
+
+    // Path of the selected explorer file or directory, without trailing slash
+    getSelectedExplorerPath() {
+        const item = this.selectedExplorerItem;
+        if (!item || !item.classList.contains('file-item') || !item.dataset.itemPath) {
+            return '';
+        }
+        return item.dataset.itemPath.replace(/\/$/, '');
+    }
+
+    // Directory for new files/folders: the selected directory, or the parent of the selected file
+    getSelectedExplorerDir() {
+        const path = this.getSelectedExplorerPath();
+        if (!path) return '';
+        if (this.selectedExplorerItem.dataset.itemType === 'directory') {
+            return path + '/';
+        }
+        const slash = path.lastIndexOf('/');
+        return slash >= 0 ? path.substring(0, slash + 1) : '';
+    }
+
+    async postFileOperation(url, body) {
+        const response = await fetch(url, {
+            method: 'POST',
+            headers: { 'Content-Type': 'application/json' },
+            body: JSON.stringify(body)
+        });
+        return response.json();
+    }
+
+    async createNewFolder() {
+        const path = prompt('Enter folder name:', this.getSelectedExplorerDir());
+        if (!path) return;
+
+        try {
+            const result = await this.postFileOperation('/api/mkdir', { path });
+            if (result.success) {
+                this.setStatus(`Created ${result.path}`, 'success');
+                this.refreshFileTree();
+            } else {
+                this.setStatus(`Error: ${result.error}`, 'error');
+            }
+        } catch (error) {
+            this.setStatus(`Error creating folder: ${error.message}`, 'error');
+        }
+    }
+
+    // Rename or move the selected explorer item (a path in another directory moves it)
+    async renameSelectedItem() {
+        const from = this.getSelectedExplorerPath();
+        if (!from) {
+            this.setStatus('Select a file or folder in the explorer first', 'warning');
+            return;
+        }
+
+        const to = prompt('Rename or move to:', from);
+        if (!to || to === from) return;
+
+        try {
+            const result = await this.postFileOperation('/api/rename', { from, to });
+            if (result.success) {
+                this.updateTabsForPathChange(result.from, result.to);
+                this.setStatus(`Renamed ${result.from} to ${result.to}`, 'success');
+                this.refreshFileTree();
+            } else {
+                this.setStatus(`Error: ${result.error}`, 'error');
+            }
+        } catch (error) {
+            this.setStatus(`Error renaming: ${error.message}`, 'error');
+        }
+    }
+
+    async deleteSelectedItem() {
+        const path = this.getSelectedExplorerPath();
+        if (!path) {
+            this.setStatus('Select a file or folder in the explorer first', 'warning');
+            return;
+        }
+
+        if (!confirm(`Delete '${path}'? It can be restored with File > Undo Delete.`)) {
+            return;
+        }
+
+        try {
+            const result = await this.postFileOperation('/api/delete', { path });
+            if (result.success) {
+                this.lastDeleted = { path: result.path, trashId: result.trashId };
+                this.updateTabsForPathChange(result.path, null);
+                this.setStatus(`Deleted ${result.path}`, 'success');
+                this.refreshFileTree();
+            } else {
+                this.setStatus(`Error: ${result.error}`, 'error');
+            }
+        } catch (error) {
+            this.setStatus(`Error deleting: ${error.message}`, 'error');
+        }
+    }
+
+    async undoDelete() {
+        if (!this.lastDeleted) {
+            this.setStatus('Nothing to restore', 'warning');
+            return;
+        }
+
+        try {
+            const result = await this.postFileOperation('/api/restore', this.lastDeleted);
+            if (result.success) {
+                this.lastDeleted = null;
+                this.setStatus(`Restored ${result.path}`, 'success');
+                this.refreshFileTree();
+            } else {
+                this.setStatus(`Error: ${result.error}`, 'error');
+            }
+        } catch (error) {
+            this.setStatus(`Error restoring: ${error.message}`, 'error');
+        }
+    }
+
+    // Close or reopen tabs affected by a rename (newPath) or delete (newPath = null)
+    updateTabsForPathChange(oldPath, newPath) {
+        Array.from(this.openTabs.keys()).forEach(filename => {
+            if (filename !== oldPath && !filename.startsWith(oldPath + '/')) {
+                return;
+            }
+            const modified = this.openTabs.get(filename)?.modified;
+            this.closeTab(filename);
+            if (newPath && !modified && !this.openTabs.has(filename)) {
+                this.openFile(newPath + filename.substring(oldPath.length));
+            }
+        });
+    }
+
+    // Reload the explorer, keeping expanded directories expanded
+    async refreshFileTree() {
+        const expanded = Array.from(this.fileTree.querySelectorAll('.file-item.directory'))
+            .filter(item => item.dataset.expanded === 'true')
+            .map(item => item.dataset.itemPath);
+
+        await this.loadFileTree();
+
+        // Parents come before children, so expanding in order restores nested directories
+        for (const path of expanded) {
+            const item = Array.from(this.fileTree.querySelectorAll('.file-item.directory'))
+                .find(el => el.dataset.itemPath === path);
+            if (item && item.dataset.expanded !== 'true') {
+                await this.expandDirectoryItem(item);
+            }
+        }
+    }
+
+    async expandDirectoryItem(item) {
+        const container = item.nextElementSibling;
+        if (!container || !container.classList.contains('dir-children')) return;
+
+        item.dataset.expanded = 'true';
+        const arrow = item.querySelector('.dir-arrow');
+        if (arrow) arrow.style.transform = 'rotate(90deg)';
+        container.style.display = 'block';
+        await this.loadDirectoryContents(item.dataset.itemPath, container, parseInt(item.dataset.level) + 1);
+        container.dataset.loaded = 'true';
+    }
+
+    // Listen for file changes made by other clients or server-side tools
+    connectFilesWebSocket() {
+        const protocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+        this.filesSocket = new WebSocket(`${protocol}//${window.location.host}/ws/files`);
+
+        this.filesSocket.onmessage = (event) => {
+            try {
+                const msg = JSON.parse(event.data);
+                if (msg.type === 'changed' && this.activeSidePanel === 'explorer') {
+                    this.refreshFileTree();
+                }
+            } catch (error) {
+                console.error('Error handling file change notification:', error);
+            }
+        };
+
+        this.filesSocket.onclose = () => {
+            // Reconnect after a short delay (e.g. server restart)
+            setTimeout(() => this.connectFilesWebSocket(), 5000);
+        };
+    }
     
     showOpenDialog() {
         this.showFileDialog();
@@ -1378,6 +1570,16 @@ class CodeEditor {
                 }
                 break;
                 
+            case 'F2':
+                e.preventDefault();
+                this.renameSelectedItem();
+                break;
+
+            case 'Delete':
+                e.preventDefault();
+                this.deleteSelectedItem();
+                break;
+
             case 'Home':
                 e.preventDefault();
                 if (explorerItems.length > 0) {
@@ -1652,7 +1854,23 @@ function createNewFile() {
 }
 
 function refreshExplorer() {
-    window.codeEditor?.loadFileTree();
+    window.codeEditor?.refreshFileTree();
+}
+
+function createNewFolder() {
+    window.codeEditor?.createNewFolder();
+}
+
+function renameSelectedItem() {
+    window.codeEditor?.renameSelectedItem();
+}
+
+function deleteSelectedItem() {
+    window.codeEditor?.deleteSelectedItem();
+}
+
+function undoDelete() {
+    window.codeEditor?.undoDelete();
 }
 
 function openFileDialog() {
@@ -1729,6 +1947,402 @@ function toggleGitPanel() {
     window.codeEditor?.switchSidePanel('git');
 }
 
+function refreshGitPanel() {
+    loadGitStatus();
+}
+
+// Source Control: the branch of the session root, its changed files and recent commits, and
+// the files the last Compile with Hooks instrumented
+async function loadGitStatus() {
+    const container = document.getElementById('gitStatus');
+    const branch = document.getElementById('gitBranch');
+    if (!container) return;
+
+    let status;
+    try {
+        const response = await fetch('/api/git/status');
+        status = await response.json();
+    } catch (err) {
+        console.error('Error loading git status:', err);
+        return;
+    }
+    if (status.error) {
+        container.innerHTML = `<p class="git-empty">${escapeHtml(status.error)}</p>`;
+        return;
+    }
+
+    container.innerHTML = '';
+    if (status.repository) {
+        let tracking = '';
+        if (status.upstream) {
+            tracking = ` → ${escapeHtml(status.upstream)}`;
+            if (status.ahead || status.behind) {
+                tracking += ` ↑${status.ahead} ↓${status.behind}`;
+            }
+        }
+        const header = document.createElement('div');
+        header.className = 'git-branch';
+        header.title = status.toplevel;
+        header.innerHTML = `<strong>${escapeHtml(status.branch)}</strong>${tracking}`;
+        container.appendChild(header);
+
+        document.getElementById('gitBranchName').textContent = status.branch;
+        branch?.classList.remove('hidden');
+    } else {
+        container.innerHTML = '<p class="git-empty">No repository detected</p>';
+        branch?.classList.add('hidden');
+    }
+
+    if (status.repository) {
+        appendGitSection(container, `Changes (${status.files.length})`, status.files, file => {
+            const item = gitFileItem(file.path, file.status.trim() || file.status, file.origPath ? `${file.origPath} → ${file.path}` : file.path);
+            item.addEventListener('click', () => showGitDiff(file.path, false, file.origPath));
+            return item;
+        });
+    }
+    appendGitSection(container, `Instrumented (${status.instrumented.length})`, status.instrumented, file => {
+        const item = gitFileItem(file.path, 'I', `${file.path}\nInstrumented copy: ${file.instrumented}`);
+        item.classList.add('instrumented');
+        item.addEventListener('click', () => showInstrumentedSource(file.path));
+        return item;
+    });
+
+    if (status.repository) {
+        try {
+            const response = await fetch('/api/git/log?limit=20');
+            const log = await response.json();
+            if (log.success) {
+                appendGitSection(container, 'Commits', log.commits, commit => {
+                    const item = document.createElement('div');
+                    item.className = 'git-commit';
+                    item.title = `${commit.hash}\n${commit.author}, ${new Date(commit.date).toLocaleString()}`;
+                    item.innerHTML = `<span class="git-hash">${commit.hash.substring(0, 7)}</span> ${escapeHtml(commit.subject)}`;
+                    return item;
+                });
+            }
+        } catch (err) {
+            console.error('Error loading git log:', err);
+        }
+    }
+}
+
+function appendGitSection(container, title, items, render) {
+    const section = document.createElement('div');
+    section.className = 'git-section';
+    section.innerHTML = `<div class="git-section-title">${escapeHtml(title)}</div>`;
+    items.forEach(item => section.appendChild(render(item)));
+    container.appendChild(section);
+}
+
+function gitFileItem(path, status, tooltip) {
+    const item = document.createElement('div');
+    item.className = 'git-file';
+    item.title = tooltip;
+    const name = path.split('/').pop();
+    const dir = path.substring(0, path.length - name.length);
+    item.innerHTML = `<span class="git-file-name">${escapeHtml(name)}</span>` +
+        `<span class="git-file-dir">${escapeHtml(dir)}</span>` +
+        `<span class="git-file-status">${escapeHtml(status)}</span>`;
+    return item;
+}
+
+// Shows the changes of a file since the last commit, or with instrumented the changes the last
+// Compile with Hooks made to it
+async function showGitDiff(path, instrumented, origPath) {
+    let data;
+    try {
+        const params = new URLSearchParams({ path });
+        if (instrumented) params.set('instrumented', 'true');
+        if (origPath) params.set('origPath', origPath);
+        const response = await fetch(`/api/git/diff?${params}`);
+        data = await response.json();
+    } catch (err) {
+        showMessageWindow('Diff Failed', err.message, 'error');
+        return;
+    }
+    if (data.error) {
+        showMessageWindow('Diff Failed', data.error, 'error');
+        return;
+    }
+
+    closeGitDiff();
+    const diffWindow = document.createElement('div');
+    diffWindow.id = 'gitDiffWindow';
+    diffWindow.className = 'preview-window';
+    diffWindow.innerHTML = `
+        <div class="message-window-header message-header-info">
+            <span class="message-title">${instrumented ? '🔧 Instrumented' : '± Changes'} — ${escapeHtml(path)}</span>
+            <button class="message-close" onclick="closeGitDiff()">×</button>
+        </div>
+        <div class="timeline-toolbar">
+            <button class="toolbar-button" id="gitDiffOpen">Open File</button>
+            ${instrumented ? '<button class="toolbar-button" id="gitDiffSideBySide">Side by Side</button>' : ''}
+        </div>
+        <div class="preview-content" id="gitDiffContent"></div>
+    `;
+    document.body.appendChild(diffWindow);
+    document.getElementById('gitDiffOpen').addEventListener('click', () => {
+        closeGitDiff();
+        window.codeEditor?.openFile(path);
+    });
+    document.getElementById('gitDiffSideBySide')?.addEventListener('click', () => {
+        closeGitDiff();
+        showInstrumentedSource(path);
+    });
+
+    const content = document.getElementById('gitDiffContent');
+    if (!data.content) {
+        content.innerHTML = '<div class="preview-empty">No changes.</div>';
+        return;
+    }
+    const pre = document.createElement('pre');
+    pre.className = 'preview-text';
+    data.content.split('\n').forEach(line => {
+        const div = document.createElement('div');
+        div.textContent = line;
+        if (line.startsWith('@@')) {
+            div.className = 'diff-hunk';
+        } else if (line.startsWith('+++') || line.startsWith('---') || line.startsWith('diff ') || line.startsWith('index ')) {
+            div.className = 'diff-header';
+        } else if (line.startsWith('+')) {
+            div.className = 'diff-added';
+        } else if (line.startsWith('-')) {
+            div.className = 'diff-removed';
+        }
+        pre.appendChild(div);
+    });
+    content.appendChild(pre);
+}
+
+function closeGitDiff() {
+    document.getElementById('gitDiffWindow')?.remove();
+}
+
+// Side by side: a file and its instrumented copy of the last Compile with Hooks in a diff
+// editor, with the trampoline calls instrumentation injected highlighted
+let instrumentedDiffEditor = null;
+
+function showActiveInstrumentedSource() {
+    const path = window.codeEditor?.activeTab;
+    if (!path) {
+        showMessageWindow('Instrumented Side by Side', 'Open an instrumented file first.', 'info');
+        return;
+    }
+    showInstrumentedSource(path);
+}
+
+async function showInstrumentedSource(path) {
+    let data;
+    try {
+        const response = await fetch(`/api/instrumented-source?${new URLSearchParams({ path })}`);
+        data = await response.json();
+    } catch (err) {
+        showMessageWindow('Instrumented Source Failed', err.message, 'error');
+        return;
+    }
+    if (data.error) {
+        showMessageWindow('Instrumented Source Failed', data.error, 'error');
+        return;
+    }
+
+    closeInstrumentedSource();
+    const trampolines = data.trampolines || [];
+    const sourceWindow = document.createElement('div');
+    sourceWindow.id = 'instrumentedSourceWindow';
+    sourceWindow.className = 'preview-window';
+    sourceWindow.innerHTML = `
+        <div class="message-window-header message-header-info">
+            <span class="message-title">🔧 ${escapeHtml(data.path)} — original and instrumented</span>
+            <button class="message-close" onclick="closeInstrumentedSource()">×</button>
+        </div>
+        <div class="timeline-toolbar">
+            <button class="toolbar-button" id="instrumentedOpen">Open File</button>
+            <button class="toolbar-button" id="instrumentedUnified">Unified Diff</button>
+            <button class="toolbar-button" id="instrumentedPrevious" title="Previous trampoline call">↑</button>
+            <button class="toolbar-button" id="instrumentedNext" title="Next trampoline call">↓</button>
+            <span class="instrumented-summary">${trampolines.length} trampoline calls — ${escapeHtml(data.instrumentedPath)}</span>
+        </div>
+        <div class="preview-content instrumented-diff" id="instrumentedSourceDiff"></div>
+    `;
+    document.body.appendChild(sourceWindow);
+    document.getElementById('instrumentedOpen').addEventListener('click', () => {
+        closeInstrumentedSource();
+        window.codeEditor?.openFile(data.path);
+    });
+    document.getElementById('instrumentedUnified').addEventListener('click', () => {
+        closeInstrumentedSource();
+        showGitDiff(data.path, true);
+    });
+
+    const language = data.path.endsWith('.go') ? 'go' : 'plaintext';
+    instrumentedDiffEditor = monaco.editor.createDiffEditor(document.getElementById('instrumentedSourceDiff'), {
+        theme: 'vs-dark',
+        readOnly: true,
+        originalEditable: false,
+        renderSideBySide: true,
+        automaticLayout: true,
+        minimap: { enabled: false }
+    });
+    instrumentedDiffEditor.setModel({
+        original: monaco.editor.createModel(data.original, language),
+        modified: monaco.editor.createModel(data.instrumented, language)
+    });
+
+    const modified = instrumentedDiffEditor.getModifiedEditor();
+    modified.deltaDecorations([], trampolines.map(call => ({
+        range: new monaco.Range(call.line, 1, call.line, 1),
+        options: {
+            isWholeLine: true,
+            className: `trampoline-line trampoline-${call.kind}`,
+            hoverMessage: { value: `${call.kind === 'before' ? 'Before' : 'After'} trampoline of \`${call.function}\`` },
+            overviewRuler: { color: '#c586c0', position: monaco.editor.OverviewRulerLane.Center }
+        }
+    })));
+
+    // The buttons step through the trampoline calls
+    let current = -1;
+    const reveal = (step) => {
+        if (trampolines.length === 0) return;
+        current = (current + step + trampolines.length) % trampolines.length;
+        const line = trampolines[current].line;
+        modified.setPosition({ lineNumber: line, column: 1 });
+        modified.revealLineInCenter(line);
+        modified.focus();
+    };
+    document.getElementById('instrumentedPrevious').addEventListener('click', () => reveal(-1));
+    document.getElementById('instrumentedNext').addEventListener('click', () => reveal(1));
+    if (trampolines.length > 0) {
+        modified.revealLineInCenter(trampolines[0].line);
+    }
+}
+
+function closeInstrumentedSource() {
+    if (instrumentedDiffEditor) {
+        const model = instrumentedDiffEditor.getModel();
+        instrumentedDiffEditor.dispose();
+        model?.original.dispose();
+        model?.modified.dispose();
+        instrumentedDiffEditor = null;
+    }
+    document.getElementById('instrumentedSourceWindow')?.remove();
+}
+
+// Find in Files: searches the contents of the session root through /api/search as the query,
+// its options or the include and exclude patterns change
+let searchTimer = null;
+let searchRequest = null;
+
+function initializeSearch() {
+    const input = document.getElementById('searchInput');
+    if (!input) return;
+    ['searchInput', 'searchInclude', 'searchExclude'].forEach(id => {
+        const field = document.getElementById(id);
+        field.addEventListener('input', scheduleSearch);
+        field.addEventListener('keydown', e => {
+            if (e.key === 'Enter') {
+                clearTimeout(searchTimer);
+                runSearch();
+            }
+        });
+    });
+}
+
+function toggleSearchOption(button) {
+    button.classList.toggle('active');
+    scheduleSearch();
+}
+
+function scheduleSearch() {
+    clearTimeout(searchTimer);
+    searchTimer = setTimeout(runSearch, 300);
+}
+
+async function runSearch() {
+    const query = document.getElementById('searchInput').value;
+    const summary = document.getElementById('searchSummary');
+    const results = document.getElementById('searchResults');
+    searchRequest?.abort();
+    if (!query) {
+        summary.textContent = '';
+        results.innerHTML = '';
+        return;
+    }
+
+    const params = new URLSearchParams({ q: query });
+    if (document.getElementById('searchCaseSensitive').classList.contains('active')) params.set('caseSensitive', 'true');
+    if (document.getElementById('searchWord').classList.contains('active')) params.set('word', 'true');
+    if (document.getElementById('searchRegex').classList.contains('active')) params.set('regex', 'true');
+    const include = document.getElementById('searchInclude').value.trim();
+    const exclude = document.getElementById('searchExclude').value.trim();
+    if (include) params.set('include', include);
+    if (exclude) params.set('exclude', exclude);
+
+    searchRequest = new AbortController();
+    let data;
+    try {
+        summary.textContent = 'Searching...';
+        const response = await fetch(`/api/search?${params}`, { signal: searchRequest.signal });
+        data = await response.json();
+    } catch (err) {
+        if (err.name !== 'AbortError') {
+            summary.textContent = err.message;
+        }
+        return;
+    }
+    if (data.error) {
+        summary.textContent = data.error;
+        results.innerHTML = '';
+        return;
+    }
+
+    summary.textContent = data.matches === 0
+        ? `No results in ${data.searched} files`
+        : `${data.matches}${data.truncated ? '+' : ''} results in ${data.files.length} files`;
+    results.innerHTML = '';
+    data.files.forEach(file => {
+        const group = document.createElement('div');
+        group.className = 'search-file';
+        const name = file.path.split('/').pop();
+        const dir = file.path.substring(0, file.path.length - name.length);
+        const header = document.createElement('div');
+        header.className = 'search-file-header';
+        header.title = file.path;
+        header.innerHTML = `<span class="search-file-name">${escapeHtml(name)}</span>` +
+            `<span class="git-file-dir">${escapeHtml(dir)}</span>` +
+            `<span class="search-file-count">${file.matches.length}</span>`;
+        header.addEventListener('click', () => group.classList.toggle('collapsed'));
+        group.appendChild(header);
+
+        file.matches.forEach(match => {
+            const item = document.createElement('div');
+            item.className = 'search-match';
+            item.title = `${file.path}:${match.line}:${match.column}`;
+            const chars = Array.from(match.text);
+            const start = match.column - 1;
+            // Long lines start shortly before the match
+            const from = Math.max(0, start - 30);
+            const before = (from > 0 ? '…' : '') + chars.slice(from, start).join('').trimStart();
+            const matched = chars.slice(start, start + match.length).join('');
+            const after = chars.slice(start + match.length).join('');
+            item.innerHTML = `${escapeHtml(before)}<span class="search-highlight">${escapeHtml(matched)}</span>${escapeHtml(after)}`;
+            item.addEventListener('click', () => openSearchMatch(file.path, match));
+            group.appendChild(item);
+        });
+        results.appendChild(group);
+    });
+}
+
+// Opens the file of a search result and selects the match
+async function openSearchMatch(path, match) {
+    await window.codeEditor?.openFile(path);
+    const editor = window.codeEditor?.monacoEditor;
+    if (!editor) return;
+    const range = new monaco.Range(match.line, match.column, match.line, match.column + match.length);
+    editor.setSelection(range);
+    editor.revealRangeInCenter(range);
+    editor.focus();
+}
+
 async function showFunctions() {
     // Call external hc --pack-functions and show output in explorer
     console.log('Functions view - calling hc --pack-functions');
@@ -1806,8 +2420,8 @@ async function showFunctions() {
                             metaItem.textContent = trimmedLine;
                             fileTree.appendChild(metaItem);
                         } else {
-                            // Parse function name from the line (format: "funcName" or "package.funcName")
-                            const funcName = trimmedLine.split('(')[0].trim();
+                            // Parse function name from the line (format: "funcName" or "package.funcName", without type parameters)
+                            const funcName = trimmedLine.split('(')[0].split('[')[0].trim();
                             const funcData = { name: funcName, fullSignature: trimmedLine };
 
                             // Try to parse function information
@@ -1910,7 +2524,12 @@ async function showFiles() {
             
             if (fileTree) {
                 // Parse and format the output as clickable Go files
-                const lines = output.split('\n').filter(line => line.trim() !== '');
+                // Files are listed as "  - path (size)", up to the files shared between packages
+                const shared = output.indexOf('Files shared between compile commands');
+                const lines = (shared >= 0 ? output.slice(0, shared) : output).split('\n')
+                    .map(line => line.match(/^\s*- (.+?\.go)(?: \(.*\))?$/))
+                    .filter(match => match)
+                    .map(match => match[1]);
                 fileTree.innerHTML = '';
                 
                 // Add a header
@@ -2045,13 +2664,25 @@ async function showStaticCallGraph() {
         header.innerHTML = `
             <div style="display: flex; align-items: center; justify-content: space-between;">
                 <span>📊 Static Call Graph</span>
-                <button onclick="loadFilesIntoExplorer()" style="padding: 2px 6px; background: #007acc; color: white; border: none; border-radius: 3px; cursor: pointer; font-size: 10px;">
-                    ← Back
-                </button>
+                <span>
+                    <button onclick="setCallGraphBaseline()" title="Record this call graph to compare later changes with" style="padding: 2px 6px; background: #3c3c3c; color: white; border: none; border-radius: 3px; cursor: pointer; font-size: 10px;">
+                        Set Baseline
+                    </button>
+                    <button onclick="compareCallGraph()" title="Color the calls added since the baseline and list those removed" style="padding: 2px 6px; background: #3c3c3c; color: white; border: none; border-radius: 3px; cursor: pointer; font-size: 10px;">
+                        Compare
+                    </button>
+                    <button onclick="loadFilesIntoExplorer()" style="padding: 2px 6px; background: #007acc; color: white; border: none; border-radius: 3px; cursor: pointer; font-size: 10px;">
+                        ← Back
+                    </button>
+                </span>
             </div>
         `;
         fileTree.appendChild(header);
 
+        if (callGraphDiff) {
+            renderCallGraphDiffSummary(fileTree, callGraphDiff);
+        }
+
         // Clear previous selections when showing new call graph
         selectedCallGraphItems.clear();
         
@@ -2222,7 +2853,7 @@ function clearSelectionFromToolbar() {
     }
 }
 
-function renderCallTree(container, nodes, level = 0) {
+function renderCallTree(container, nodes, level = 0, parent = null) {
     nodes.forEach(node => {
         const nodeItem = document.createElement('div');
         nodeItem.className = 'call-graph-item';
@@ -2323,6 +2954,10 @@ function renderCallTree(container, nodes, level = 0) {
         if (linesSpan) {
             nodeContent.appendChild(linesSpan);
         }
+        if (callGraphDiff && isAddedSinceBaseline(node, parent)) {
+            nodeContent.classList.add('call-graph-added');
+            nodeContent.title = node.isRoot ? 'Function added since the baseline' : 'Call added since the baseline';
+        }
 
         // Add hover effects
         nodeContent.addEventListener('mouseenter', () => {
@@ -2372,7 +3007,7 @@ function renderCallTree(container, nodes, level = 0) {
             childrenContainer.className = 'call-graph-children';
             childrenContainer.style.display = node.expanded ? 'block' : 'none';
 
-            renderCallTree(childrenContainer, node.children, level + 1);
+            renderCallTree(childrenContainer, node.children, level + 1, node);
             nodeItem.appendChild(childrenContainer);
         }
 
@@ -2380,6 +3015,119 @@ function renderCallTree(container, nodes, level = 0) {
     });
 }
 
+// Call graph diff against the baseline overlaid on the static call graph, null when not comparing
+let callGraphDiff = null;
+
+async function setCallGraphBaseline() {
+    window.codeEditor?.setStatus('Recording call graph baseline...', 'info');
+    try {
+        const response = await fetch('/api/callgraph/baseline', { method: 'POST' });
+        const data = await response.json();
+        if (data.error) {
+            showMessageWindow('Baseline Failed', data.error, 'error');
+            return;
+        }
+        callGraphDiff = null;
+        window.codeEditor?.setStatus(data.content, 'success');
+        showStaticCallGraph();
+    } catch (err) {
+        console.error('Baseline error:', err);
+        showMessageWindow('Baseline Failed', err.message, 'error');
+    }
+}
+
+// Compare the call graph with the baseline, and the coverage of the hooks of hooksFile if given
+async function compareCallGraph(hooksFile = '') {
+    window.codeEditor?.setStatus('Comparing call graph with baseline...', 'info');
+    try {
+        const query = hooksFile ? '?hooks=' + encodeURIComponent(hooksFile) : '';
+        const response = await fetch('/api/callgraph/diff' + query);
+        const data = await response.json();
+        if (data.error) {
+            showMessageWindow('Compare', data.error, 'info');
+            return;
+        }
+        callGraphDiff = data.diff;
+        callGraphDiff.baselineTime = data.baseline;
+        window.codeEditor?.setStatus(`Call graph: +${callGraphDiff.addedEdges.length}/-${callGraphDiff.removedEdges.length} calls since baseline`, 'success');
+        showStaticCallGraph();
+    } catch (err) {
+        console.error('Compare error:', err);
+        showMessageWindow('Compare Failed', err.message, 'error');
+    }
+}
+
+async function compareCallGraphHooks() {
+    const hooksFile = await showFileSelector('./generated_hooks/generated_hooks.go');
+    if (!hooksFile || hooksFile.trim() === '') {
+        return;
+    }
+    compareCallGraph(hooksFile.trim());
+}
+
+// A root is added when its function is new, a call when its edge is
+function isAddedSinceBaseline(node, parent) {
+    if (node.isRoot || !parent) {
+        return callGraphDiff.addedNodes.some(added => added.name === node.name);
+    }
+    return callGraphDiff.addedEdges.some(edge => edge.caller === parent.name && edge.callee === node.name);
+}
+
+// List what the call graph lost since the baseline, which the tree can't show, and the hooks
+// whose functions left it
+function renderCallGraphDiffSummary(container, diff) {
+    const panel = document.createElement('div');
+    panel.className = 'call-graph-diff';
+
+    const title = document.createElement('div');
+    title.className = 'call-graph-diff-title';
+    const since = diff.baselineTime ? new Date(diff.baselineTime).toLocaleString() : 'baseline';
+    title.textContent = `Since ${since}: +${diff.addedNodes.length}/-${diff.removedNodes.length} functions, ` +
+        `+${diff.addedEdges.length}/-${diff.removedEdges.length} calls`;
+    panel.appendChild(title);
+
+    const addLine = (className, text) => {
+        const line = document.createElement('div');
+        line.className = className;
+        line.textContent = text;
+        panel.appendChild(line);
+    };
+    diff.removedNodes.forEach(node => {
+        addLine('call-graph-removed', `− ${node.name}${node.hooked ? ' [hooked]' : ''}`);
+    });
+    diff.removedEdges.forEach(edge => {
+        addLine('call-graph-removed', `− ${edge.caller} → ${edge.callee} (line ${edge.lines.join(', ')})`);
+    });
+    diff.addedNodes.filter(node => node.hooked).forEach(node => {
+        addLine('call-graph-added', `+ ${node.name} [hooked]`);
+    });
+    (diff.hooks || []).forEach(hook => {
+        if (hook.lost) {
+            addLine('call-graph-removed', `✗ ${hook.target}: matched ${hook.old.length} function(s) before, none now`);
+        } else if (hook.new.length > 0) {
+            addLine('call-graph-hook', `✓ ${hook.target}: ${hook.new.length} function(s)`);
+        }
+    });
+
+    const actions = document.createElement('div');
+    actions.className = 'call-graph-diff-actions';
+    const hooksButton = document.createElement('button');
+    hooksButton.textContent = 'Check Hooks…';
+    hooksButton.title = 'Compare the functions a hooks file matches in both call graphs';
+    hooksButton.onclick = compareCallGraphHooks;
+    const clearButton = document.createElement('button');
+    clearButton.textContent = 'Clear';
+    clearButton.onclick = () => {
+        callGraphDiff = null;
+        showStaticCallGraph();
+    };
+    actions.appendChild(hooksButton);
+    actions.appendChild(clearButton);
+    panel.appendChild(actions);
+
+    container.appendChild(panel);
+}
+
 // Update selection count display and show/hide Generate Hooks button
 function updateCallGraphSelectionCount() {
     const count = selectedCallGraphItems.size;
@@ -3191,6 +3939,8 @@ File Operations:
 • Ctrl+O - Open File  
 • Ctrl+S - Save File
 • Ctrl+W - Close Tab
+• F2 - Rename/Move (explorer)
+• Delete - Delete (explorer)
 
 Editor:
 • Ctrl+Z - Undo
@@ -3403,6 +4153,11 @@ function closeTerminal() {
 }
 
 function clearTerminal() {
+    if (activeTerminalTab === 'shell') {
+        shellText = '';
+        document.getElementById('terminalShell').textContent = '';
+        return;
+    }
     const terminalContent = document.getElementById('terminalContent');
     terminalContent.innerHTML = '';
 }
@@ -3418,6 +4173,159 @@ function addTerminalOutput(text, className = '') {
     terminalContent.scrollTop = terminalContent.scrollHeight;
 }
 
+// Shell tab of the terminal panel: a shell on a pseudo-terminal in the root directory
+// (/api/terminal). Output is shown as plain text, without colors or cursor movement.
+let activeTerminalTab = 'output';
+let shellSocket = null;
+let shellText = '';
+let shellPendingCR = false;
+const shellMaxText = 200000;
+
+function switchTerminalTab(tab) {
+    activeTerminalTab = tab;
+    document.querySelectorAll('.terminal-tab').forEach(el => {
+        el.classList.toggle('active', el.dataset.terminalTab === tab);
+    });
+    const shell = document.getElementById('terminalShell');
+    document.getElementById('terminalContent').style.display = tab === 'output' ? '' : 'none';
+    shell.style.display = tab === 'shell' ? '' : 'none';
+    if (tab === 'shell') {
+        if (!shellSocket) {
+            connectShell();
+        }
+        shell.focus();
+    }
+}
+
+function connectShell() {
+    const shell = document.getElementById('terminalShell');
+    const wsProtocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+    shellSocket = new WebSocket(`${wsProtocol}//${window.location.host}/api/terminal`);
+    shellSocket.onopen = () => resizeShell();
+    shellSocket.onmessage = (event) => {
+        const msg = JSON.parse(event.data);
+        switch (msg.type) {
+            case 'output':
+                appendShellOutput(msg.data);
+                break;
+            case 'exit':
+                appendShellOutput(`\r\n[shell exited with code ${msg.exitCode}, press Enter to start a new one]\r\n`);
+                shellSocket.onclose = null;
+                shellSocket = null;
+                break;
+            case 'error':
+                appendShellOutput(`\r\n[${msg.error}]\r\n`);
+                break;
+        }
+    };
+    shellSocket.onclose = () => {
+        if (shellSocket) {
+            appendShellOutput('\r\n[disconnected, press Enter to reconnect]\r\n');
+            shellSocket = null;
+        }
+    };
+
+    if (!shell.dataset.initialized) {
+        shell.dataset.initialized = 'true';
+        shell.addEventListener('keydown', handleShellKey);
+        shell.addEventListener('paste', (e) => {
+            e.preventDefault();
+            sendShellInput(e.clipboardData.getData('text').replace(/\r?\n/g, '\r'));
+        });
+        new ResizeObserver(() => resizeShell()).observe(shell);
+    }
+}
+
+function sendShellInput(data) {
+    if (shellSocket && shellSocket.readyState === WebSocket.OPEN) {
+        shellSocket.send(JSON.stringify({ type: 'input', data }));
+    }
+}
+
+// Sends the terminal size in characters, so programs wrap and page their output to it
+function resizeShell() {
+    const shell = document.getElementById('terminalShell');
+    if (!shellSocket || shellSocket.readyState !== WebSocket.OPEN || shell.clientWidth === 0) return;
+    const probe = document.createElement('span');
+    probe.textContent = 'M';
+    shell.appendChild(probe);
+    const { width, height } = probe.getBoundingClientRect();
+    probe.remove();
+    const style = window.getComputedStyle(shell);
+    const cols = Math.floor((shell.clientWidth - parseFloat(style.paddingLeft) - parseFloat(style.paddingRight)) / width);
+    const rows = Math.floor((shell.clientHeight - parseFloat(style.paddingTop) - parseFloat(style.paddingBottom)) / height);
+    if (cols > 0 && rows > 0) {
+        shellSocket.send(JSON.stringify({ type: 'resize', rows, cols }));
+    }
+}
+
+const shellKeys = {
+    Enter: '\r', Backspace: '\x7f', Tab: '\t', Escape: '\x1b', Delete: '\x1b[3~',
+    ArrowUp: '\x1b[A', ArrowDown: '\x1b[B', ArrowRight: '\x1b[C', ArrowLeft: '\x1b[D',
+    Home: '\x1b[H', End: '\x1b[F', PageUp: '\x1b[5~', PageDown: '\x1b[6~'
+};
+
+function handleShellKey(e) {
+    // Keep the editor's shortcuts out of the shell, but leave copying a selection alone
+    e.stopPropagation();
+    if ((e.ctrlKey || e.metaKey) && (e.key === 'c' || e.key === 'v') && (e.key === 'v' || window.getSelection().toString())) {
+        return;
+    }
+    if (!shellSocket && e.key === 'Enter') {
+        e.preventDefault();
+        connectShell();
+        return;
+    }
+    let data = null;
+    if (shellKeys[e.key]) {
+        data = shellKeys[e.key];
+    } else if (e.ctrlKey && !e.altKey && e.key.length === 1 && /[a-z@\[\\\]^_]/i.test(e.key)) {
+        data = String.fromCharCode(e.key.toUpperCase().charCodeAt(0) & 0x1f);
+    } else if (e.key.length === 1 && !e.ctrlKey && !e.metaKey) {
+        data = e.altKey ? '\x1b' + e.key : e.key;
+    }
+    if (data !== null) {
+        e.preventDefault();
+        sendShellInput(data);
+    }
+}
+
+// Appends shell output, applying carriage returns and backspaces and dropping escape sequences
+function appendShellOutput(data) {
+    // A carriage return at the end may be the first half of a line break
+    if (shellPendingCR) {
+        data = '\r' + data;
+    }
+    shellPendingCR = data.endsWith('\r');
+    if (shellPendingCR) {
+        data = data.slice(0, -1);
+    }
+    data = data
+        .replace(/\x1b\][^\x07\x1b]*(\x07|\x1b\\)/g, '')
+        .replace(/\x1b\[[0-?]*[ -\/]*[@-~]/g, '')
+        .replace(/\x1b[@-Z\\-_]/g, '')
+        .replace(/\r\n/g, '\n');
+    let text = shellText;
+    for (const ch of data) {
+        if (ch === '\r') {
+            text = text.substring(0, text.lastIndexOf('\n') + 1);
+        } else if (ch === '\b') {
+            if (text.length > 0 && !text.endsWith('\n')) {
+                text = text.slice(0, -1);
+            }
+        } else if (ch !== '\x07') {
+            text += ch;
+        }
+    }
+    if (text.length > shellMaxText) {
+        text = text.substring(text.indexOf('\n', text.length - shellMaxText) + 1);
+    }
+    shellText = text;
+    const shell = document.getElementById('terminalShell');
+    shell.textContent = shellText;
+    shell.scrollTop = shell.scrollHeight;
+}
+
 // Directory selector dialog for choosing where to save generated hooks
 function showDirectorySelector(defaultDirName = 'generated_hooks') {
     return new Promise(async (resolve) => {
@@ -3941,7 +4849,7 @@ function showFileSelector(defaultPath = './generated_hooks/generated_hooks.go')
     });
 }
 
-// Update the runCompile function to show output in terminal
+// Instrument and build with hooks, streaming the output of hc --compile into the terminal
 async function runCompile() {
     const hooksFile = await showFileSelector('./generated_hooks/generated_hooks.go');
 
@@ -3949,44 +4857,469 @@ async function runCompile() {
         return;
     }
 
-    // Show terminal and clear previous output
+    await streamBuild('/api/instrument', { hooksFile: hooksFile.trim() }, 'Compile');
+}
+
+// Capture the build log of the project, streaming the output of hc --capture into the terminal
+async function runCapture() {
+    if (await streamBuild('/api/capture', {}, 'Capture')) {
+        window.codeEditor?.setStatus('Build log captured', 'success');
+    }
+}
+
+// Controller aborting the running capture or compile, which kills hc on the server
+let buildStreamController = null;
+
+// Run a streaming build endpoint and show its events in the terminal as they arrive.
+// Returns whether the command succeeded.
+async function streamBuild(url, body, label) {
+    if (buildStreamController) {
+        addTerminalOutput(`⚠️ A capture or compile is already running`, 'terminal-warning');
+        return false;
+    }
+
     showTerminal();
     clearTerminal();
-    addTerminalOutput('$ hc --compile ' + hooksFile.trim(), 'terminal-command');
-    addTerminalOutput('Compiling...', 'terminal-info');
+    showStopButton(true);
+    buildStreamController = new AbortController();
+
+    let exitCode = null;
+    const showEvent = (data) => {
+        switch (data.type) {
+            case 'started':
+                addTerminalOutput('$ ' + data.command, 'terminal-command');
+                break;
+            case 'stdout':
+                addTerminalOutput(data.output.replace(/\n$/, ''), '');
+                break;
+            case 'stderr':
+                addTerminalOutput(data.output.replace(/\n$/, ''), 'terminal-error');
+                break;
+            case 'exited':
+                exitCode = data.exitCode;
+                addTerminalOutput('', '');
+                if (data.exitCode === 0) {
+                    addTerminalOutput(`✅ ${label} completed successfully in ${(data.durationMs / 1000).toFixed(1)}s`, 'terminal-success');
+                } else {
+                    addTerminalOutput(`❌ ${label} failed with exit code ${data.exitCode}`, 'terminal-error');
+                }
+                break;
+            case 'error':
+                addTerminalOutput('', '');
+                addTerminalOutput(`❌ ${label} Failed: ` + data.error, 'terminal-error');
+                break;
+        }
+    };
 
     try {
-        const response = await fetch('/api/compile', {
+        const response = await fetch(url, {
             method: 'POST',
             headers: {
                 'Content-Type': 'application/json',
             },
-            body: JSON.stringify({ hooksFile: hooksFile.trim() })
+            body: JSON.stringify(body),
+            signal: buildStreamController.signal
         });
 
-        const data = await response.json();
+        // Requests rejected before the command starts are answered with a single JSON error
+        if (!(response.headers.get('Content-Type') || '').includes('application/x-ndjson')) {
+            const data = await response.json().catch(() => ({ error: response.statusText }));
+            showEvent({ type: 'error', error: data.error || data.message || response.statusText });
+            return false;
+        }
 
-        if (data.error) {
-            addTerminalOutput('', '');
-            addTerminalOutput('❌ Compile Failed:', 'terminal-error');
-            addTerminalOutput(data.error, 'terminal-error');
-        } else {
-            const output = data.content || 'Compile completed successfully (no output)';
-            addTerminalOutput('', '');
-            output.split('\n').forEach(line => {
-                addTerminalOutput(line, '');
-            });
+        const reader = response.body.getReader();
+        const decoder = new TextDecoder();
+        let buffered = '';
+        for (;;) {
+            const { value, done } = await reader.read();
+            if (done) {
+                break;
+            }
+            buffered += decoder.decode(value, { stream: true });
+            const lines = buffered.split('\n');
+            buffered = lines.pop();
+            lines.filter(line => line.trim() !== '').forEach(line => showEvent(JSON.parse(line)));
+        }
+        if (exitCode === null) {
             addTerminalOutput('', '');
-            addTerminalOutput('✅ Compile completed successfully', 'terminal-success');
+            addTerminalOutput('Connection closed', 'terminal-info');
         }
     } catch (err) {
-        console.error('Compile error:', err);
         addTerminalOutput('', '');
-        addTerminalOutput('❌ Error: ' + err.message, 'terminal-error');
+        if (err.name === 'AbortError') {
+            addTerminalOutput(`🛑 ${label} stopped by user`, 'terminal-info');
+        } else {
+            console.error(`${label} error:`, err);
+            addTerminalOutput('❌ Error: ' + err.message, 'terminal-error');
+        }
+    } finally {
+        buildStreamController = null;
+        showStopButton(false);
     }
+    return exitCode === 0;
 }
 
 // Message window functions - simple compact window with scrollbar
+// Preview instrumentation: show per-file diffs and generated files without compiling
+async function previewInstrumentation() {
+    const hooksFile = await showFileSelector('./generated_hooks/generated_hooks.go');
+
+    if (!hooksFile || hooksFile.trim() === '') {
+        return;
+    }
+
+    window.codeEditor?.setStatus('Generating instrumentation preview...', 'info');
+
+    try {
+        const response = await fetch('/api/instrument/preview', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ hooksFile: hooksFile.trim() })
+        });
+
+        const data = await response.json();
+
+        if (data.error) {
+            showMessageWindow('Preview Failed', data.error, 'error');
+            return;
+        }
+
+        window.codeEditor?.setStatus('Instrumentation preview ready', 'success');
+        showInstrumentationPreview(data.preview);
+    } catch (err) {
+        console.error('Preview error:', err);
+        showMessageWindow('Preview Failed', err.message, 'error');
+    }
+}
+
+function showInstrumentationPreview(preview) {
+    closeInstrumentationPreview();
+
+    // One tab per instrumented file (diff) and per generated file (full content)
+    const tabs = [];
+    (preview.files || []).forEach(f => {
+        tabs.push({ title: f.file.split('/').pop(), tooltip: `${f.file} (${f.package})`, kind: 'diff', text: f.diff });
+    });
+    (preview.generatedFiles || []).forEach(g => {
+        tabs.push({ title: '+ ' + g.name, tooltip: `Generated in package ${g.package}`, kind: 'generated', text: g.content });
+    });
+
+    const previewWindow = document.createElement('div');
+    previewWindow.id = 'previewWindow';
+    previewWindow.className = 'preview-window';
+    previewWindow.innerHTML = `
+        <div class="message-window-header message-header-info">
+            <span class="message-title">🔍 Instrumentation Preview — ${preview.files?.length || 0} changed, ${preview.generatedFiles?.length || 0} generated</span>
+            <button class="message-close" onclick="closeInstrumentationPreview()">×</button>
+        </div>
+        <div class="preview-tabs" id="previewTabs"></div>
+        <div class="preview-content" id="previewContent"></div>
+    `;
+    document.body.appendChild(previewWindow);
+
+    const tabBar = document.getElementById('previewTabs');
+    const content = document.getElementById('previewContent');
+
+    if (tabs.length === 0) {
+        content.innerHTML = '<div class="preview-empty">No functions matched the hooks - nothing would be instrumented.</div>';
+        return;
+    }
+
+    const selectTab = (index) => {
+        tabBar.querySelectorAll('.preview-tab').forEach((el, i) => el.classList.toggle('active', i === index));
+        content.innerHTML = '';
+        const pre = document.createElement('pre');
+        pre.className = 'preview-text';
+        tabs[index].text.split('\n').forEach(line => {
+            const div = document.createElement('div');
+            div.textContent = line;
+            if (tabs[index].kind === 'diff') {
+                if (line.startsWith('@@')) {
+                    div.className = 'diff-hunk';
+                } else if (line.startsWith('+++') || line.startsWith('---')) {
+                    div.className = 'diff-header';
+                } else if (line.startsWith('+')) {
+                    div.className = 'diff-added';
+                } else if (line.startsWith('-')) {
+                    div.className = 'diff-removed';
+                }
+            }
+            pre.appendChild(div);
+        });
+        content.appendChild(pre);
+    };
+
+    tabs.forEach((tab, index) => {
+        const el = document.createElement('div');
+        el.className = 'preview-tab' + (tab.kind === 'generated' ? ' generated' : '');
+        el.textContent = tab.title;
+        el.title = tab.tooltip;
+        el.addEventListener('click', () => selectTab(index));
+        tabBar.appendChild(el);
+    });
+
+    selectTab(0);
+}
+
+function closeInstrumentationPreview() {
+    document.getElementById('previewWindow')?.remove();
+}
+
+// Timeline: the phases of the last build (capture, instrumentation, replay with the compile
+// of every package and the link) followed by the first hook calls of a traced run
+async function showTimeline() {
+    try {
+        const response = await fetch('/api/timeline');
+        const data = await response.json();
+        if (data.error) {
+            showMessageWindow('Timeline', data.error, 'info');
+            return;
+        }
+        renderTimeline(data);
+    } catch (err) {
+        console.error('Timeline error:', err);
+        showMessageWindow('Timeline Failed', err.message, 'error');
+    }
+}
+
+async function traceTimelineRun() {
+    const execPath = prompt('Enter executable path (e.g., ./hello or hello):');
+    if (!execPath || execPath.trim() === '') {
+        return;
+    }
+    const events = parseInt(prompt('Number of hook calls to trace:', '200'), 10) || 200;
+
+    window.codeEditor?.setStatus('Tracing hook calls of ' + execPath.trim() + '...', 'info');
+    try {
+        const response = await fetch('/api/timeline/run', {
+            method: 'POST',
+            headers: {
+                'Content-Type': 'application/json',
+            },
+            body: JSON.stringify({ executablePath: execPath.trim(), events: events })
+        });
+        const data = await response.json();
+        if (data.error) {
+            showMessageWindow('Trace Failed', data.error, 'error');
+            return;
+        }
+        window.codeEditor?.setStatus(`Traced ${data.run?.events?.length || 0} hook calls`, 'success');
+        renderTimeline(data);
+    } catch (err) {
+        console.error('Trace error:', err);
+        showMessageWindow('Trace Failed', err.message, 'error');
+    }
+}
+
+function formatTimelineDuration(ms) {
+    if (ms >= 1000) {
+        return (ms / 1000).toFixed(2) + ' s';
+    }
+    if (ms >= 1) {
+        return ms.toFixed(1) + ' ms';
+    }
+    return (ms * 1000).toFixed(1) + ' µs';
+}
+
+function renderTimeline(data) {
+    closeTimeline();
+
+    const timelineWindow = document.createElement('div');
+    timelineWindow.id = 'timelineWindow';
+    timelineWindow.className = 'preview-window';
+    timelineWindow.innerHTML = `
+        <div class="message-window-header message-header-info">
+            <span class="message-title">⏱️ Timeline</span>
+            <button class="message-close" onclick="closeTimeline()">×</button>
+        </div>
+        <div class="timeline-toolbar">
+            <button class="toolbar-button" onclick="traceTimelineRun()">Trace Run...</button>
+            <span>Runs the executable with HC_HOOK_EVENTS set and adds its first hook calls.</span>
+        </div>
+        <div class="preview-content" id="timelineContent"></div>
+    `;
+    document.body.appendChild(timelineWindow);
+    const content = document.getElementById('timelineContent');
+
+    // Every section is scaled to its own span: a build takes seconds, hook calls microseconds
+    const addSection = (title, rows) => {
+        const header = document.createElement('div');
+        header.className = 'timeline-section';
+        header.textContent = title;
+        content.appendChild(header);
+        if (rows.length === 0) {
+            return;
+        }
+        const first = Math.min(...rows.map(row => row.start));
+        const span = Math.max(Math.max(...rows.map(row => row.end)) - first, 1e-6);
+        rows.forEach(row => {
+            const el = document.createElement('div');
+            el.className = 'timeline-row';
+            el.title = row.tooltip || row.label;
+            const left = ((row.start - first) / span) * 100;
+            const width = ((row.end - row.start) / span) * 100;
+            el.innerHTML = `
+                <span class="timeline-label"></span>
+                <span class="timeline-track"><span class="timeline-bar ${row.kind}" style="left: ${left}%; width: ${width}%"></span></span>
+                <span class="timeline-duration">${formatTimelineDuration(row.end - row.start)}</span>
+            `;
+            el.querySelector('.timeline-label').textContent = row.label;
+            content.appendChild(el);
+        });
+    };
+
+    // Times are in milliseconds
+    const entries = data.profile?.entries || [];
+    const buildRows = entries.map(entry => {
+        const start = Date.parse(entry.start);
+        const end = Date.parse(entry.end);
+        let label = entry.phase;
+        let kind = entry.phase;
+        if (entry.action) {
+            kind = entry.kind || 'replay';
+            label = '  ' + (entry.kind === 'compile' ? 'compile ' + entry.package
+                : entry.kind === 'link' ? 'link ' + (entry.package || entry.action) : entry.action);
+        }
+        if (entry.failed) {
+            kind += ' failed';
+        }
+        const worker = entry.worker ? ` on ${entry.worker}` : '';
+        return { label, kind, start, end, tooltip: `${label.trim()}${worker}: ${entry.start} - ${entry.end}` };
+    });
+    if (entries.length > 0 && !entries.some(entry => entry.action)) {
+        buildRows.push({ label: '  (replay with -j 2 or more to see every package)', kind: '', start: buildRows[0].start, end: buildRows[0].start });
+    }
+    addSection(entries.length > 0 ? `Build — ${new Date(Date.parse(entries[0].start)).toLocaleString()}` : 'Build — no build profile, run hc --compile', buildRows);
+
+    const run = data.run;
+    if (!run) {
+        addSection('Run — use Trace Run to add the hook calls of the executable', []);
+    } else {
+        const runStart = Date.parse(run.start);
+        const runRows = [{ label: run.executable, kind: 'run', start: 0, end: Date.parse(run.end) - runStart,
+            tooltip: `${run.executable} exited with code ${run.exitCode}` }];
+        run.events.forEach(event => {
+            const start = event.offsetNs / 1e6;
+            runRows.push({ label: '  ' + event.target, kind: 'hook', start, end: start + event.durationNs / 1e6, tooltip: `${event.hook} on ${event.target}` });
+        });
+        addSection(`Run — ${new Date(runStart).toLocaleString()}, first ${run.events.length} hook calls`, runRows);
+    }
+
+    if (data.output) {
+        const pre = document.createElement('pre');
+        pre.className = 'preview-text';
+        pre.textContent = data.output;
+        content.appendChild(pre);
+    }
+}
+
+function closeTimeline() {
+    document.getElementById('timelineWindow')?.remove();
+}
+
+// Command Logs: the output of the hc commands started by the editor, streamed from /ws/logs
+// line by line while they run
+let logsSocket = null;
+
+function showCommandLogs() {
+    if (document.getElementById('commandLogsWindow')) {
+        return;
+    }
+
+    const logsWindow = document.createElement('div');
+    logsWindow.id = 'commandLogsWindow';
+    logsWindow.className = 'preview-window';
+    logsWindow.innerHTML = `
+        <div class="message-window-header message-header-info">
+            <span class="message-title">📜 Command Logs</span>
+            <button class="message-close" onclick="closeCommandLogs()">×</button>
+        </div>
+        <div class="timeline-toolbar" id="commandLogsJobs"><span>No running commands</span></div>
+        <div class="preview-content"><pre class="preview-text" id="commandLogsOutput"></pre></div>
+    `;
+    document.body.appendChild(logsWindow);
+
+    const jobs = new Map();
+    const output = document.getElementById('commandLogsOutput');
+    const addLine = (text, className) => {
+        const div = document.createElement('div');
+        div.textContent = text.replace(/\n$/, '');
+        if (className) {
+            div.className = className;
+        }
+        const atBottom = output.parentElement.scrollTop + output.parentElement.clientHeight >= output.parentElement.scrollHeight - 4;
+        output.appendChild(div);
+        if (atBottom) {
+            output.parentElement.scrollTop = output.parentElement.scrollHeight;
+        }
+    };
+    const renderJobs = () => {
+        const bar = document.getElementById('commandLogsJobs');
+        if (!bar) {
+            return;
+        }
+        bar.innerHTML = jobs.size === 0 ? '<span>No running commands</span>' : '';
+        jobs.forEach((command, id) => {
+            const button = document.createElement('button');
+            button.className = 'toolbar-button';
+            button.textContent = `Cancel #${id}`;
+            button.title = command;
+            button.addEventListener('click', () => {
+                logsSocket?.send(JSON.stringify({ command: 'cancel', job: id }));
+            });
+            bar.appendChild(button);
+        });
+    };
+
+    const wsProtocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
+    logsSocket = new WebSocket(`${wsProtocol}//${window.location.host}/ws/logs`);
+    logsSocket.onmessage = (event) => {
+        const msg = JSON.parse(event.data);
+        switch (msg.type) {
+            case 'jobs':
+                (msg.jobs || []).forEach(job => {
+                    jobs.set(job.job, job.command);
+                    addLine(`[#${job.job}] running: ${job.command}`, 'diff-hunk');
+                });
+                break;
+            case 'started':
+                jobs.set(msg.job, msg.command);
+                addLine(`[#${msg.job}] $ ${msg.command}` + (msg.root ? ` (in ${msg.root})` : ''), 'diff-hunk');
+                break;
+            case 'stdout':
+                addLine(`[#${msg.job}] ${msg.output}`);
+                break;
+            case 'stderr':
+                addLine(`[#${msg.job}] ${msg.output}`, 'diff-removed');
+                break;
+            case 'exited':
+                jobs.delete(msg.job);
+                addLine(`[#${msg.job}] ` + (msg.canceled ? 'canceled' : msg.error ? `failed: ${msg.error}` : `exited with code ${msg.exitCode}`) +
+                    ` after ${formatTimelineDuration(msg.durationMs || 0)}`, msg.exitCode === 0 ? 'diff-added' : 'diff-removed');
+                break;
+            case 'error':
+                addLine((msg.job ? `[#${msg.job}] ` : '') + msg.error, 'diff-removed');
+                break;
+        }
+        renderJobs();
+    };
+    logsSocket.onclose = () => {
+        if (document.getElementById('commandLogsWindow')) {
+            addLine('Disconnected from the server', 'diff-removed');
+        }
+        logsSocket = null;
+    };
+}
+
+function closeCommandLogs() {
+    document.getElementById('commandLogsWindow')?.remove();
+    logsSocket?.close();
+    logsSocket = null;
+}
+
 function showMessageWindow(title, message, type = 'info') {
     // Remove existing message window if present
     const existing = document.getElementById('messageWindow');
@@ -4152,6 +5485,10 @@ async function runExecutable() {
 
 // Stop the currently running process
 function stopRunningProcess() {
+    if (buildStreamController) {
+        buildStreamController.abort();
+        return;
+    }
     if (runSocket && runSocket.readyState === WebSocket.OPEN) {
         runSocket.send(JSON.stringify({ command: 'stop' }));
     }
@@ -4286,6 +5623,41 @@ async function runCleanup() {
     }
 }
 
+// Download a zip of build-metadata and instrumented sources for bug reports
+async function exportBundle() {
+    showTerminal();
+    addTerminalOutput('$ Exporting bug report bundle...', 'terminal-command');
+
+    try {
+        const response = await fetch('/api/export');
+        if (!response.ok) {
+            const data = await response.json();
+            addTerminalOutput('❌ ' + (data.error || response.statusText), 'terminal-error');
+            return;
+        }
+
+        // Use the file name suggested by the server
+        const disposition = response.headers.get('Content-Disposition') || '';
+        const match = disposition.match(/filename="([^"]+)"/);
+        const filename = match ? match[1] : 'hc-bundle.zip';
+
+        const blob = await response.blob();
+        const url = URL.createObjectURL(blob);
+        const link = document.createElement('a');
+        link.href = url;
+        link.download = filename;
+        document.body.appendChild(link);
+        link.click();
+        link.remove();
+        URL.revokeObjectURL(url);
+
+        addTerminalOutput('✅ Downloaded ' + filename + ' (' + Math.round(blob.size / 1024) + ' KB)', 'terminal-success');
+    } catch (err) {
+        console.error('Export error:', err);
+        addTerminalOutput('❌ Error: ' + err.message, 'terminal-error');
+    }
+}
+
 function connectDebugWebSocket(port) {
     const wsProtocol = window.location.protocol === 'https:' ? 'wss:' : 'ws:';
     debugSocket = new WebSocket(`${wsProtocol}//${window.location.host}/ws/debug?port=${port}`);
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// terminalEnabled is set by -terminal: the shell is off unless asked for, since it runs any
+// command with the permissions of the server
+var terminalEnabled bool
+
+// terminalHangupDelay is how long a shell gets to exit after the browser disconnects
+const terminalHangupDelay = 2 * time.Second
+
+// TerminalMessage is a message of /api/terminal: input and resize from the browser, output
+// and exit from the server
+type TerminalMessage struct {
+	Type     string `json:"type"`               // "input", "resize", "output", "exit" or "error"
+	Data     string `json:"data,omitempty"`     // Typed keys or shell output
+	Rows     uint16 `json:"rows,omitempty"`     // Terminal size of resize
+	Cols     uint16 `json:"cols,omitempty"`     // Terminal size of resize
+	ExitCode *int   `json:"exitCode,omitempty"` // Exit code of the shell
+	Error    string `json:"error,omitempty"`
+}
+
+// terminalShell returns the shell the terminal runs: $SHELL, else bash or sh
+func terminalShell() string {
+	if shell := os.Getenv("SHELL"); shell != "" {
+		return shell
+	}
+	for _, name := range []string{"bash", "sh"} {
+		if path, err := exec.LookPath(name); err == nil {
+			return path
+		}
+	}
+	return "/bin/sh"
+}
+
+// handleTerminalWebSocket runs a shell on a pseudo-terminal in the session root and connects it
+// to the browser. The shell is hung up when the browser disconnects.
+func handleTerminalWebSocket(w http.ResponseWriter, r *http.Request) {
+	root, err := requestRoot(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgradeWebSocket(w, r)
+	if err != nil {
+		log.Printf("Terminal WebSocket upgrade failed: %v\n", err)
+		return
+	}
+	defer closeWebSocket(conn)
+
+	var connMutex sync.Mutex
+	safeWriteJSON := func(v interface{}) error {
+		connMutex.Lock()
+		defer connMutex.Unlock()
+		return conn.WriteJSON(v)
+	}
+	// Browsers don't show the status of refused upgrades, so the reason is sent as a message
+	if !terminalEnabled {
+		safeWriteJSON(TerminalMessage{Type: "error", Error: "the terminal is disabled, start the server with -terminal"})
+		return
+	}
+
+	shell := terminalShell()
+	cmd := exec.Command(shell)
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(), "TERM=dumb", "HC_ROOT="+root)
+	pty, err := startPTY(cmd)
+	if err != nil {
+		safeWriteJSON(TerminalMessage{Type: "error", Error: err.Error()})
+		return
+	}
+	defer pty.Close()
+	log.Printf("Terminal started: %s in %s (pid %d)\n", shell, root, cmd.Process.Pid)
+
+	// Shell output, until the shell and everything it started close the terminal
+	outputDone := make(chan struct{})
+	go func() {
+		defer close(outputDone)
+		buf := make([]byte, 32*1024)
+		var pending []byte
+		for {
+			n, err := pty.Read(buf)
+			if n > 0 {
+				// Runes split between reads are sent with the next read, messages must be UTF-8
+				data := append(pending, buf[:n]...)
+				complete := len(data)
+				for i := 1; i <= utf8.UTFMax-1 && i <= len(data); i++ {
+					if utf8.RuneStart(data[len(data)-i]) {
+						if !utf8.FullRune(data[len(data)-i:]) {
+							complete = len(data) - i
+						}
+						break
+					}
+				}
+				pending = append([]byte(nil), data[complete:]...)
+				if safeWriteJSON(TerminalMessage{Type: "output", Data: string(data[:complete])}) != nil {
+					return
+				}
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	// Keys and resizes from the browser, until it disconnects
+	disconnected := make(chan struct{})
+	go func() {
+		defer close(disconnected)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var msg TerminalMessage
+			if err := json.Unmarshal(message, &msg); err != nil {
+				safeWriteJSON(TerminalMessage{Type: "error", Error: "Invalid message"})
+				continue
+			}
+			switch msg.Type {
+			case "input":
+				pty.Write([]byte(msg.Data))
+			case "resize":
+				if msg.Rows > 0 && msg.Cols > 0 {
+					resizePTY(pty, msg.Rows, msg.Cols)
+				}
+			default:
+				safeWriteJSON(TerminalMessage{Type: "error", Error: "Invalid message type: " + msg.Type})
+			}
+		}
+	}()
+
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+
+	var waitErr error
+	select {
+	case waitErr = <-exited:
+	case <-disconnected:
+		// Hang up like a closed terminal window, then kill a shell ignoring it
+		cmd.Process.Signal(syscall.SIGHUP)
+		select {
+		case waitErr = <-exited:
+		case <-time.After(terminalHangupDelay):
+			cmd.Process.Kill()
+			waitErr = <-exited
+		}
+	}
+	log.Printf("Terminal exited: pid %d\n", cmd.Process.Pid)
+
+	// Let the last output reach the browser before telling it the shell exited
+	select {
+	case <-outputDone:
+	case <-time.After(time.Second):
+	}
+	exitCode := 0
+	var exitErr *exec.ExitError
+	if errors.As(waitErr, &exitErr) {
+		exitCode = exitErr.ExitCode()
+	}
+	safeWriteJSON(TerminalMessage{Type: "exit", ExitCode: &exitCode})
+}
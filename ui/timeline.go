@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hook call tracing of the hooks library (hooks.HookEventsEnv, hooks.HookEventPrefix)
+const (
+	hookEventsEnv    = "HC_HOOK_EVENTS"
+	hookEventPrefix  = "hc-hook-event"
+	defaultHookCalls = 200
+	maxHookCalls     = 10000
+)
+
+// hookEventsFile keeps the hook calls of the last traced run next to the build profile
+const hookEventsFile = "hook-events.json"
+
+// HookEvent is a Before or After hook call of a traced run
+type HookEvent struct {
+	Offset   int64  `json:"offsetNs"` // Start of the call since the start of the run
+	Duration int64  `json:"durationNs"`
+	Hook     string `json:"hook"`   // Fully qualified name of the hook function
+	Target   string `json:"target"` // Instrumented function, package.Function
+}
+
+// HookRun is a run of the instrumented executable whose hook calls were traced
+type HookRun struct {
+	Executable string      `json:"executable"`
+	Start      time.Time   `json:"start"`
+	End        time.Time   `json:"end"`
+	ExitCode   int         `json:"exitCode"`
+	Events     []HookEvent `json:"events"`
+}
+
+// TimelineResponse is the data of the timeline view: the build profile written by hc and the
+// hook calls of the last traced run, either of which may be missing
+type TimelineResponse struct {
+	Success bool            `json:"success"`
+	Profile json.RawMessage `json:"profile,omitempty"`
+	Run     *HookRun        `json:"run,omitempty"`
+	Output  string          `json:"output,omitempty"`
+}
+
+// getTimeline returns the build profile and the last traced run of the session root
+func getTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	// Don't read build-metadata while a build in the same root is writing it
+	defer lockRoot(root)()
+
+	response, err := loadTimeline(root)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// runTimeline runs the instrumented executable with hook call tracing, keeps its first hook
+// calls in build-metadata/hook-events.json and returns the timeline with them
+func runTimeline(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	var req struct {
+		ExecutablePath string `json:"executablePath"`
+		Events         int    `json:"events"`  // Hook calls traced (default 200)
+		Timeout        int    `json:"timeout"` // Timeout in seconds (default 10)
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request format")
+		return
+	}
+	if req.ExecutablePath == "" {
+		sendErrorResponse(w, "Executable path is required")
+		return
+	}
+	if req.Events <= 0 {
+		req.Events = defaultHookCalls
+	}
+	req.Events = min(req.Events, maxHookCalls)
+	if req.Timeout <= 0 {
+		req.Timeout = 10
+	}
+
+	execPath := req.ExecutablePath
+	if !filepath.IsAbs(execPath) {
+		execPath = filepath.Join(root, execPath)
+	}
+	if _, err := os.Stat(execPath); os.IsNotExist(err) {
+		sendErrorResponse(w, fmt.Sprintf("Executable not found at: %s", execPath))
+		return
+	}
+
+	fmt.Printf("⏱️  Tracing the first %d hook calls of %s (timeout: %ds)\n", req.Events, execPath, req.Timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(req.Timeout)*time.Second)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, execPath)
+	cmd.Dir = root
+	cmd.Env = append(os.Environ(), fmt.Sprintf("%s=%d", hookEventsEnv, req.Events))
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	run := &HookRun{Executable: req.ExecutablePath, Start: time.Now()}
+	analysisJobsRunning.add(1, "command", "timeline")
+	err = cmd.Run()
+	analysisJobsRunning.add(-1, "command", "timeline")
+	run.End = time.Now()
+	recordCommand("timeline", err == nil, run.End.Sub(run.Start))
+	if cmd.ProcessState == nil {
+		sendErrorResponse(w, fmt.Sprintf("Failed to run %s: %v", execPath, err))
+		return
+	}
+	run.ExitCode = cmd.ProcessState.ExitCode()
+
+	events, programStderr := parseHookEvents(stderr.String())
+	run.Events = events
+	output := stdout.String() + programStderr
+	if ctx.Err() == context.DeadlineExceeded {
+		output += fmt.Sprintf("\n[Process killed after %d seconds timeout]", req.Timeout)
+	}
+	if len(events) == 0 {
+		output += "\n[No hook calls traced: the executable was not built with hooks, or none of them ran]"
+	}
+
+	defer lockRoot(root)()
+	if data, err := json.MarshalIndent(run, "", "  "); err == nil {
+		if err := os.MkdirAll(filepath.Join(root, "build-metadata"), 0755); err == nil {
+			os.WriteFile(filepath.Join(root, "build-metadata", hookEventsFile), append(data, '\n'), 0644)
+		}
+	}
+
+	response, err := loadTimeline(root)
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+	response.Output = output
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// loadTimeline reads the build profile and the last traced run of root
+func loadTimeline(root string) (*TimelineResponse, error) {
+	response := &TimelineResponse{Success: true}
+	if data, err := os.ReadFile(filepath.Join(root, "build-metadata", "build-profile.json")); err == nil {
+		response.Profile = data
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "build-metadata", hookEventsFile)); err == nil {
+		run := &HookRun{}
+		if err := json.Unmarshal(data, run); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %v", hookEventsFile, err)
+		}
+		response.Run = run
+	}
+	if response.Profile == nil && response.Run == nil {
+		return nil, fmt.Errorf("nothing to show yet: build with hc --compile (-j 2 or more records every package) and run the executable from the timeline")
+	}
+	return response, nil
+}
+
+// parseHookEvents separates the hook call lines printed by the hooks library from the rest of
+// the standard error of a program. Their offsets are relative to the initialization of the
+// hooks library, which is taken to be the start of the program.
+func parseHookEvents(stderr string) ([]HookEvent, string) {
+	events := []HookEvent{}
+	var rest strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(stderr))
+	scanner.Buffer(nil, len(stderr)+1)
+	for scanner.Scan() {
+		line := scanner.Text()
+		fields := strings.Fields(line)
+		if len(fields) < 4 || fields[0] != hookEventPrefix {
+			rest.WriteString(line + "\n")
+			continue
+		}
+		offset, err1 := strconv.ParseInt(fields[1], 10, 64)
+		duration, err2 := strconv.ParseInt(fields[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			rest.WriteString(line + "\n")
+			continue
+		}
+		event := HookEvent{Offset: offset, Duration: duration, Hook: fields[3]}
+		if len(fields) > 4 {
+			event.Target = fields[4]
+		}
+		events = append(events, event)
+	}
+	return events, rest.String()
+}
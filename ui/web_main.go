@@ -6,11 +6,13 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"html"
 	"io"
 	"io/ioutil"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -39,13 +41,25 @@ var rootDirectory string
 // Restrict navigation to root directory only (disabled by default for local use)
 var restrictNavigation bool
 
-// WebSocket upgrader for LSP
+// WebSocket upgrader for LSP, the terminal and the run and debug streams
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Allow all origins for local development
-	},
+	CheckOrigin:     sameOrigin,
+}
+
+// sameOrigin reports whether a WebSocket request comes from a page of this server, so other
+// web pages the browser has open can't connect. Clients other than browsers send no Origin.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
 }
 
 // Global gopls process management
@@ -77,20 +91,16 @@ func ensureBuildLog() error {
 	// Check if go-build.log already exists
 	if _, err := os.Stat(buildLogPath); err == nil {
 		log.Printf("Build log already exists: %s\n", buildLogPath)
+		cacheRequestsTotal.add(1, "cache", "build_log", "result", "hit")
 		return nil
 	}
+	cacheRequestsTotal.add(1, "cache", "build_log", "result", "miss")
 
 	log.Printf("Build log not found, capturing build output for: %s\n", rootDirectory)
 
-	// Get absolute path to hc executable
-	execPath, err := filepath.Abs("../hc/hc")
+	execPath, err := hcExecutable()
 	if err != nil {
-		return fmt.Errorf("failed to resolve executable path: %v", err)
-	}
-
-	// Check if executable exists
-	if _, err := os.Stat(execPath); os.IsNotExist(err) {
-		return fmt.Errorf("hc executable not found at: %s", execPath)
+		return err
 	}
 
 	// Run hc --json to capture the build log
@@ -100,7 +110,12 @@ func ensureBuildLog() error {
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	if err := cmd.Run(); err != nil {
+	analysisJobsRunning.add(1, "command", "hc --json")
+	start := time.Now()
+	err = cmd.Run()
+	recordCommand("hc --json", err == nil, time.Since(start))
+	analysisJobsRunning.add(-1, "command", "hc --json")
+	if err != nil {
 		return fmt.Errorf("failed to capture build log: %v", err)
 	}
 
@@ -108,10 +123,10 @@ func ensureBuildLog() error {
 	return nil
 }
 
-// startGopls starts the gopls language server process
-func startGopls() (*exec.Cmd, io.WriteCloser, io.ReadCloser, error) {
+// startGopls starts the gopls language server process in root
+func startGopls(root string) (*exec.Cmd, io.WriteCloser, io.ReadCloser, error) {
 	cmd := exec.Command("gopls", "serve")
-	cmd.Dir = rootDirectory
+	cmd.Dir = root
 
 	stdin, err := cmd.StdinPipe()
 	if err != nil {
@@ -132,23 +147,29 @@ func startGopls() (*exec.Cmd, io.WriteCloser, io.ReadCloser, error) {
 		return nil, nil, nil, fmt.Errorf("failed to start gopls: %v", err)
 	}
 
-	log.Printf("Started gopls (PID: %d) for directory: %s\n", cmd.Process.Pid, rootDirectory)
+	log.Printf("Started gopls (PID: %d) for directory: %s\n", cmd.Process.Pid, root)
 	return cmd, stdin, stdout, nil
 }
 
 // handleLSPWebSocket handles WebSocket connections for LSP communication
 func handleLSPWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	root, err := requestRoot(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgradeWebSocket(w, r)
 	if err != nil {
 		log.Printf("WebSocket upgrade failed: %v\n", err)
 		return
 	}
-	defer conn.Close()
+	defer closeWebSocket(conn)
 
 	log.Println("LSP WebSocket connection established")
 
 	// Start gopls for this connection
-	cmd, stdin, stdout, err := startGopls()
+	cmd, stdin, stdout, err := startGopls(root)
 	if err != nil {
 		log.Printf("Failed to start gopls: %v\n", err)
 		conn.WriteMessage(websocket.TextMessage, []byte(fmt.Sprintf(`{"error": "%s"}`, err.Error())))
@@ -236,8 +257,25 @@ func main() {
 	flag.StringVar(&rootDirectory, "dir", ".", "Root directory to serve files from")
 	port := flag.String("port", "9090", "Port to serve on")
 	flag.BoolVar(&restrictNavigation, "restrict-nav", false, "Restrict file navigation to root directory only")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", shutdownTimeout, "How long to wait for in-flight requests on shutdown")
+	authFile := flag.String("auth-file", "", "JSON file with API users, tokens and roles (viewer or operator); without it authentication is disabled")
+	flag.BoolVar(&terminalEnabled, "terminal", false, "Enable the shell of the terminal panel (/api/terminal); it runs commands as the server, so combine it with -auth-file unless only trusted users reach the port")
+	flag.StringVar(&interceptorFlag, "interceptor", "", "hc executable the handlers run (default $"+interceptorEnv+", ../hc/hc, then hc or go-build-interceptor on PATH)")
+	auditLog := flag.String("audit-log", "", "File to append the audit trail of operator actions to (default: server log when authentication is enabled)")
 	flag.Parse()
 
+	if *authFile != "" {
+		if err := loadAuthFile(*authFile); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("🔒 Authentication enabled (%d users)\n", len(authUsers))
+	}
+	if *auditLog != "" {
+		if err := openAuditLog(*auditLog); err != nil {
+			log.Fatal(err)
+		}
+	}
+
 	// Resolve the root directory to an absolute path
 	absRoot, err := filepath.Abs(rootDirectory)
 	if err != nil {
@@ -250,6 +288,12 @@ func main() {
 		log.Fatalf("Root directory does not exist: %s", rootDirectory)
 	}
 
+	if execPath, source, err := resolveInterceptor(); err != nil {
+		log.Printf("Warning: %v\n", err)
+	} else {
+		fmt.Printf("🔧 Using hc executable %s (%s)\n", execPath, source)
+	}
+
 	// Ensure gopls is installed
 	if err := ensureGopls(); err != nil {
 		log.Printf("Warning: %v\n", err)
@@ -266,53 +310,86 @@ func main() {
 	http.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
 
 	// Main editor page
-	http.HandleFunc("/", serveEditor)
-
-	// API endpoints
-	http.HandleFunc("/api/open", openFile)
-	http.HandleFunc("/api/save", saveFile)
-	http.HandleFunc("/api/list", listFiles)
-	http.HandleFunc("/api/pack-files", getPackFiles)
-	http.HandleFunc("/api/pack-functions", getPackFunctions)
-	http.HandleFunc("/api/pack-packages", getPackPackages)
-	http.HandleFunc("/api/callgraph", getCallGraph)
-	http.HandleFunc("/api/workdir", getWorkDir)
-	http.HandleFunc("/api/compile", getCompile)
-	http.HandleFunc("/api/run-executable", getRunExecutable)
-	http.HandleFunc("/api/create-hooks-module", createHooksModule)
-	http.HandleFunc("/api/debug", handleDebug)
-	http.HandleFunc("/api/cleanup", handleCleanup)
+	http.HandleFunc("/", requireRole(roleViewer, serveEditor))
+
+	// API endpoints (viewers browse files and analysis results, operators build and modify files)
+	http.HandleFunc("/api/open", requireRole(roleViewer, openFile))
+	http.HandleFunc("/api/save", requireRole(roleOperator, saveFile))
+	http.HandleFunc("/api/list", requireRole(roleViewer, listFiles))
+	http.HandleFunc("/api/mkdir", requireRole(roleOperator, makeDirectory))
+	http.HandleFunc("/api/rename", requireRole(roleOperator, renamePath))
+	http.HandleFunc("/api/delete", requireRole(roleOperator, deletePath))
+	http.HandleFunc("/api/restore", requireRole(roleOperator, restorePath))
+	http.HandleFunc("/api/pack-files", requireRole(roleViewer, getPackFiles))
+	http.HandleFunc("/api/pack-functions", requireRole(roleViewer, getPackFunctions))
+	http.HandleFunc("/api/pack-packages", requireRole(roleViewer, getPackPackages))
+	http.HandleFunc("/api/callgraph", requireRole(roleViewer, getCallGraph))
+	http.HandleFunc("/api/callgraph-query", requireRole(roleViewer, getCallGraphQuery))
+	http.HandleFunc("/api/callgraph/baseline", requireRole(roleOperator, setCallGraphBaseline))
+	http.HandleFunc("/api/callgraph/diff", requireRole(roleViewer, getCallGraphDiff))
+	http.HandleFunc("/api/workdir", requireRole(roleViewer, getWorkDir))
+	http.HandleFunc("/api/compile", requireRole(roleOperator, getCompile))
+	http.HandleFunc("/api/capture", requireRole(roleOperator, runCapture))
+	http.HandleFunc("/api/instrument", requireRole(roleOperator, runInstrument))
+	// The preview writes build-metadata and runs the rewrite functions of the hooks package
+	http.HandleFunc("/api/instrument/preview", requireRole(roleOperator, getInstrumentationPreview))
+	http.HandleFunc("/api/instrumented-source", requireRole(roleViewer, getInstrumentedSource))
+	http.HandleFunc("/api/run-executable", requireRole(roleOperator, getRunExecutable))
+	http.HandleFunc("/api/create-hooks-module", requireRole(roleOperator, createHooksModule))
+	http.HandleFunc("/api/debug", requireRole(roleOperator, handleDebug))
+	http.HandleFunc("/api/cleanup", requireRole(roleOperator, handleCleanup))
+	http.HandleFunc("/api/export", requireRole(roleViewer, handleExport))
+	http.HandleFunc("/api/health", requireRole(roleViewer, handleHealth))
+	http.HandleFunc("/api/search", requireRole(roleViewer, getSearch))
+	http.HandleFunc("/api/git/status", requireRole(roleViewer, getGitStatus))
+	http.HandleFunc("/api/git/diff", requireRole(roleViewer, getGitDiff))
+	http.HandleFunc("/api/git/log", requireRole(roleViewer, getGitLog))
+	http.HandleFunc("/api/terminal", requireRole(roleOperator, handleTerminalWebSocket))
+	http.HandleFunc("/api/timeline", requireRole(roleViewer, getTimeline))
+	http.HandleFunc("/api/timeline/run", requireRole(roleOperator, runTimeline))
 
 	// LSP WebSocket endpoint
-	http.HandleFunc("/ws/lsp", handleLSPWebSocket)
+	http.HandleFunc("/ws/lsp", requireRole(roleViewer, handleLSPWebSocket))
 
 	// Debug WebSocket endpoint
-	http.HandleFunc("/ws/debug", handleDebugWebSocket)
+	http.HandleFunc("/ws/debug", requireRole(roleOperator, handleDebugWebSocket))
+
+	// File change notifications for explorer refresh
+	http.HandleFunc("/ws/files", requireRole(roleViewer, handleFilesWebSocket))
 
 	// Run executable WebSocket endpoint (for real-time output)
-	http.HandleFunc("/ws/run", handleRunWebSocket)
+	http.HandleFunc("/ws/run", requireRole(roleOperator, handleRunWebSocket))
+
+	// Output of the running hc commands, with cancel for operators
+	http.HandleFunc("/ws/logs", requireRole(roleViewer, handleLogsWebSocket))
 
 	// Stop process endpoint
-	http.HandleFunc("/api/stop-process", handleStopProcess)
+	http.HandleFunc("/api/stop-process", requireRole(roleOperator, handleStopProcess))
+
+	// Health and readiness endpoints
+	http.HandleFunc("/healthz", handleHealthz)
+	http.HandleFunc("/readyz", handleReadyz)
+	http.HandleFunc("/metrics", handleMetrics)
 
 	fmt.Printf("🚀 Web Text Editor Server Starting...\n")
-	fmt.Printf("📝 Access the editor at: http://localhost:%s\n", *port)
 	fmt.Printf("📁 Root directory: %s\n", rootDirectory)
 	fmt.Printf("⏹️  Press Ctrl+C to stop the server\n\n")
 
-	log.Fatal(http.ListenAndServe(":"+*port, nil))
+	if err := runServer(":" + *port); err != nil {
+		log.Fatal(err)
+	}
 }
 
-// getFullPath resolves a relative path to a full path within the root directory
-func getFullPath(relativePath string) (string, error) {
+// getFullPath resolves a relative path to a full path within root
+func getFullPath(root, relativePath string) (string, error) {
 	// Clean the path to prevent directory traversal
 	cleanPath := filepath.Clean(relativePath)
 
 	// Join with root directory
-	fullPath := filepath.Join(rootDirectory, cleanPath)
+	fullPath := filepath.Join(root, cleanPath)
 
 	// Only enforce root directory restriction if restrictNavigation is enabled
-	if restrictNavigation && !strings.HasPrefix(fullPath, rootDirectory) {
+	if restrictNavigation && !strings.HasPrefix(fullPath, root) {
 		return "", fmt.Errorf("path outside root directory")
 	}
 
@@ -320,6 +397,21 @@ func getFullPath(relativePath string) (string, error) {
 }
 
 func serveEditor(w http.ResponseWriter, r *http.Request) {
+	// Each browser gets its own session; ?root= switches the session to another directory
+	session, err := ensureSession(w, r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if requested := r.URL.Query().Get("root"); requested != "" {
+		if err := setSessionRoot(session, requested); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	root := session.root()
+	rootJSON, _ := json.Marshal(root)
+
 	html := `<!DOCTYPE html>
 <html lang="en">
 <head>
@@ -333,7 +425,9 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
     <script>
         require.config({ paths: { vs: '/static/monaco/vs' } });
         // Root directory for LSP
-        window.PROJECT_ROOT = '` + rootDirectory + `';
+        window.PROJECT_ROOT = ` + string(rootJSON) + `;
+        // Role of the current user: viewer or operator
+        window.USER_ROLE = "` + userRole(r) + `";
     </script>
 </head>
 <body class="vscode-theme">
@@ -346,10 +440,23 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
                     <div class="menu-option" onclick="createNewFile()">
                         New File <span class="menu-shortcut">Ctrl+N</span>
                     </div>
+                    <div class="menu-option" onclick="createNewFolder()">
+                        New Folder...
+                    </div>
                     <div class="menu-option" onclick="openFileDialog()">
                         Open... <span class="menu-shortcut">Ctrl+O</span>
                     </div>
                     <div class="menu-separator"></div>
+                    <div class="menu-option" onclick="renameSelectedItem()">
+                        Rename / Move... <span class="menu-shortcut">F2</span>
+                    </div>
+                    <div class="menu-option" onclick="deleteSelectedItem()">
+                        Delete <span class="menu-shortcut">Del</span>
+                    </div>
+                    <div class="menu-option" onclick="undoDelete()">
+                        Undo Delete
+                    </div>
+                    <div class="menu-separator"></div>
                     <div class="menu-option" onclick="saveCurrentFile()">
                         Save <span class="menu-shortcut">Ctrl+S</span>
                     </div>
@@ -357,6 +464,10 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
                         Save As... <span class="menu-shortcut">Ctrl+Shift+S</span>
                     </div>
                     <div class="menu-separator"></div>
+                    <div class="menu-option" onclick="exportBundle()">
+                        Export Bug Report Bundle...
+                    </div>
+                    <div class="menu-separator"></div>
                     <div class="menu-option" onclick="closeCurrentTab()">
                         Close Tab <span class="menu-shortcut">Ctrl+W</span>
                     </div>
@@ -428,6 +539,15 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
                     <div class="menu-option" onclick="showWorkDirectory()">
                         Work Directory
                     </div>
+                    <div class="menu-option" onclick="showTimeline()">
+                        Timeline
+                    </div>
+                    <div class="menu-option" onclick="showCommandLogs()">
+                        Command Logs
+                    </div>
+                    <div class="menu-option" onclick="showActiveInstrumentedSource()">
+                        Instrumented Side by Side
+                    </div>
                     <div class="menu-separator"></div>
                     <div class="menu-option" onclick="toggleWordWrap()">
                         Toggle Word Wrap
@@ -533,6 +653,12 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
                 <svg width="16" height="16" viewBox="0 0 16 16"><path fill="currentColor" d="M2 2v12h12V2H2zm11 11H3V3h10v10zM5.8 9L4 7.2l.6-.6L6 8l3.5-3.5.6.6L6.6 8.5l-.8.5z"/></svg>
             </button>
             <div class="toolbar-separator"></div>
+            <button class="toolbar-button" onclick="runCapture()" title="Capture Build Log">
+                <svg width="16" height="16" viewBox="0 0 16 16"><path fill="currentColor" d="M3 1h7l3 3v11H3V1zm1 1v12h8V5H9V2H4zm1 5h6v1H5V7zm0 2h6v1H5V9zm0 2h4v1H5v-1z"/></svg>
+            </button>
+            <button class="toolbar-button" onclick="previewInstrumentation()" title="Preview Instrumentation">
+                <svg width="16" height="16" viewBox="0 0 16 16"><path fill="currentColor" d="M8 3C4.5 3 1.7 5.3 1 8c.7 2.7 3.5 5 7 5s6.3-2.3 7-5c-.7-2.7-3.5-5-7-5zm0 8.5A3.5 3.5 0 1 1 8 4.5a3.5 3.5 0 0 1 0 7zM8 6a2 2 0 1 0 0 4 2 2 0 0 0 0-4z"/></svg>
+            </button>
             <button class="toolbar-button" onclick="runCompile()" title="Run Compile with Hooks">
                 <svg width="16" height="16" viewBox="0 0 16 16"><path fill="currentColor" d="M2 14L14 8 2 2v5l10 1L2 9v5z"/></svg>
             </button>
@@ -597,6 +723,9 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
                         <button class="panel-action" onclick="createNewFile()" title="New File">
                             <svg width="16" height="16" viewBox="0 0 16 16"><path fill="currentColor" d="M14.5 13.5h-13a.5.5 0 0 1-.5-.5V3a.5.5 0 0 1 .5-.5h8.793l4.207 4.207v6.293a.5.5 0 0 1-.5.5ZM2 12h11V7.5L9.5 4H2v8Z"/><path fill="currentColor" d="M8 6V4h1v2h2v1H9v2H8V7H6V6h2Z"/></svg>
                         </button>
+                        <button class="panel-action" onclick="createNewFolder()" title="New Folder">
+                            <svg width="16" height="16" viewBox="0 0 16 16"><path fill="currentColor" d="M14.5 3H7.71l-.85-.85A.5.5 0 0 0 6.5 2h-5a.5.5 0 0 0-.5.5v11a.5.5 0 0 0 .5.5h13a.5.5 0 0 0 .5-.5v-10a.5.5 0 0 0-.5-.5ZM14 13H2V3h4.29l.85.85A.5.5 0 0 0 7.5 4H14v9Z"/><path fill="currentColor" d="M8 7V5h1v2h2v1H9v2H8V8H6V7h2Z"/></svg>
+                        </button>
                         <button class="panel-action" onclick="refreshExplorer()" title="Refresh Explorer">
                             <svg width="16" height="16" viewBox="0 0 16 16"><path fill="currentColor" d="M8 3a5 5 0 1 0 4.546 2.914.5.5 0 0 1 .908-.418A6 6 0 1 1 8 2v1z"/><path fill="currentColor" d="M8 4.466V2.534a.25.25 0 0 1 .41-.192l2.36 1.966c.12.1.12.284 0 .384L8.41 6.658A.25.25 0 0 1 8 6.466V4.466z"/></svg>
                         </button>
@@ -613,7 +742,15 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
                     <span class="panel-title">SEARCH</span>
                 </div>
                 <div class="search-container">
-                    <input type="text" id="searchInput" placeholder="Search files..." class="search-input">
+                    <div class="search-query">
+                        <input type="text" id="searchInput" placeholder="Search" class="search-input">
+                        <button class="search-option" id="searchCaseSensitive" onclick="toggleSearchOption(this)" title="Match Case">Aa</button>
+                        <button class="search-option" id="searchWord" onclick="toggleSearchOption(this)" title="Match Whole Word">ab</button>
+                        <button class="search-option" id="searchRegex" onclick="toggleSearchOption(this)" title="Use Regular Expression">.*</button>
+                    </div>
+                    <input type="text" id="searchInclude" placeholder="files to include, e.g. *.go" class="search-input search-filter">
+                    <input type="text" id="searchExclude" placeholder="files to exclude, e.g. vendor" class="search-input search-filter">
+                    <div class="search-summary" id="searchSummary"></div>
                     <div class="search-results" id="searchResults"></div>
                 </div>
             </div>
@@ -623,8 +760,13 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
             <div class="panel-content hidden" id="git-panel">
                 <div class="panel-header">
                     <span class="panel-title">SOURCE CONTROL</span>
+                    <div class="panel-actions">
+                        <button class="panel-action" onclick="refreshGitPanel()" title="Refresh Source Control">
+                            <svg width="16" height="16" viewBox="0 0 16 16"><path fill="currentColor" d="M8 3a5 5 0 1 0 4.546 2.914.5.5 0 0 1 .908-.418A6 6 0 1 1 8 2v1z"/><path fill="currentColor" d="M8 4.466V2.534a.25.25 0 0 1 .41-.192l2.36 1.966c.12.1.12.284 0 .384L8.41 6.658A.25.25 0 0 1 8 6.466V4.466z"/></svg>
+                        </button>
+                    </div>
                 </div>
-                <div class="git-status">
+                <div class="git-status" id="gitStatus">
                     <p>No repository detected</p>
                 </div>
             </div>
@@ -646,7 +788,7 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
                     <div class="no-editor-message" id="noEditorMessage">
                         <div class="welcome-content">
                             <h2>GoLang Source File Viewer</h2>
-                            <p>Viewing files from: ` + rootDirectory + `</p>
+                            <p>Viewing files from: ` + html.EscapeString(root) + `</p>
                             <p>Open a file to start editing</p>
                             <div class="quick-actions">
                                 <button onclick="createNewFile()" class="quick-action">New File</button>
@@ -704,7 +846,10 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
         <!-- Terminal Resize Handle -->
         <div class="terminal-resize-handle" id="terminalResizeHandle"></div>
         <div class="terminal-header">
-            <span class="terminal-title">TERMINAL</span>
+            <div class="terminal-tabs">
+                <span class="terminal-title terminal-tab active" data-terminal-tab="output" onclick="switchTerminalTab('output')" title="Output of the commands run by the editor">OUTPUT</span>
+                <span class="terminal-title terminal-tab" data-terminal-tab="shell" onclick="switchTerminalTab('shell')" title="Shell in the root directory">SHELL</span>
+            </div>
             <div class="terminal-actions">
                 <button class="terminal-action" onclick="clearTerminal()" title="Clear Terminal">
                     <svg width="14" height="14" viewBox="0 0 16 16"><path fill="currentColor" d="M8 2.5a5.5 5.5 0 1 0 0 11 5.5 5.5 0 0 0 0-11zM3 8a5 5 0 1 1 10 0A5 5 0 0 1 3 8zm7.854-2.854a.5.5 0 0 1 0 .708L8.707 8l2.147 2.146a.5.5 0 0 1-.708.708L8 8.707l-2.146 2.147a.5.5 0 0 1-.708-.708L7.293 8 5.146 5.854a.5.5 0 1 1 .708-.708L8 7.293l2.146-2.147a.5.5 0 0 1 .708 0z"/></svg>
@@ -717,6 +862,7 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
         <div class="terminal-content" id="terminalContent">
             <!-- Terminal output will be displayed here -->
         </div>
+        <pre class="terminal-content terminal-shell" id="terminalShell" tabindex="0" style="display: none;"></pre>
     </div>
 
     <!-- Status Bar -->
@@ -724,7 +870,7 @@ func serveEditor(w http.ResponseWriter, r *http.Request) {
         <div class="status-left">
             <span id="gitBranch" class="status-item hidden">
                 <svg width="12" height="12" viewBox="0 0 16 16"><path fill="currentColor" d="M5.5 3.5a2 2 0 1 0 0 4 2 2 0 0 0 0-4zM2 5.5a3.5 3.5 0 1 1 5.898 2.549 5.508 5.508 0 0 1 3.034 4.084.75.75 0 1 1-1.482.235 4 4 0 0 0-7.9 0 .75.75 0 0 1-1.482-.235A5.507 5.507 0 0 1 3.102 8.05 3.493 3.493 0 0 1 2 5.5z"/></svg>
-                main
+                <span id="gitBranchName">main</span>
             </span>
             <span id="fileErrors" class="status-item hidden">
                 <svg width="12" height="12" viewBox="0 0 16 16"><path fill="currentColor" d="M8.22 1.754a.25.25 0 0 0-.44 0L1.698 13.132a.25.25 0 0 0 .22.368h12.164a.25.25 0 0 0 .22-.368L8.22 1.754zm-1.763-.707c.659-1.234 2.427-1.234 3.086 0l6.082 11.378A1.75 1.75 0 0 1 14.082 15H1.918a1.75 1.75 0 0 1-1.543-2.575L6.457 1.047zM9 11a1 1 0 1 1-2 0 1 1 0 0 1 2 0zm-.25-5.25a.75.75 0 0 0-1.5 0v2.5a.75.75 0 0 0 1.5 0v-2.5z"/></svg>
@@ -791,6 +937,12 @@ func openFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
 	var req FileRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		sendErrorResponse(w, "Invalid request format")
@@ -798,7 +950,6 @@ func openFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var fullPath string
-	var err error
 
 	// Check if this is an absolute path (e.g., from work directory)
 	if filepath.IsAbs(req.Filename) {
@@ -807,7 +958,7 @@ func openFile(w http.ResponseWriter, r *http.Request) {
 		fmt.Printf("📂 Opening absolute path: %s\n", fullPath)
 	} else {
 		// Get the full path within the root directory
-		fullPath, err = getFullPath(req.Filename)
+		fullPath, err = getFullPath(root, req.Filename)
 		if err != nil {
 			sendErrorResponse(w, "Invalid filename - path outside root directory")
 			return
@@ -836,6 +987,12 @@ func saveFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
 	var req FileRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		sendErrorResponse(w, "Invalid request format")
@@ -843,7 +1000,7 @@ func saveFile(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the full path within the root directory
-	fullPath, err := getFullPath(req.Filename)
+	fullPath, err := getFullPath(root, req.Filename)
 	if err != nil {
 		sendErrorResponse(w, "Invalid filename - path outside root directory")
 		return
@@ -859,11 +1016,18 @@ func saveFile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	_, statErr := os.Stat(fullPath)
+	isNewFile := os.IsNotExist(statErr)
+
 	if err := ioutil.WriteFile(fullPath, []byte(req.Content), 0644); err != nil {
 		sendErrorResponse(w, fmt.Sprintf("Failed to write file: %v", err))
 		return
 	}
 
+	if isNewFile {
+		notifyFileChange(root, "created", relativeToRoot(root, fullPath))
+	}
+
 	response := FileResponse{Success: true}
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
@@ -876,6 +1040,12 @@ func createHooksModule(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
 	var req struct {
 		DirName        string `json:"dirName"`
 		ModuleName     string `json:"moduleName"`
@@ -895,7 +1065,7 @@ func createHooksModule(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Get the full path within the root directory
-	fullPath, err := getFullPath(req.DirName)
+	fullPath, err := getFullPath(root, req.DirName)
 	if err != nil {
 		sendErrorResponse(w, "Invalid directory name - path outside root directory")
 		return
@@ -944,7 +1114,7 @@ func createHooksModule(w http.ResponseWriter, r *http.Request) {
 	moduleName := req.ModuleName
 	if moduleName == "" {
 		// Try to detect parent module name from go.mod
-		parentGoMod := filepath.Join(rootDirectory, "go.mod")
+		parentGoMod := filepath.Join(root, "go.mod")
 		if data, err := ioutil.ReadFile(parentGoMod); err == nil {
 			lines := strings.Split(string(data), "\n")
 			for _, line := range lines {
@@ -965,7 +1135,7 @@ func createHooksModule(w http.ResponseWriter, r *http.Request) {
 	// Run go mod init
 	cmd := exec.Command("go", "mod", "init", moduleName)
 	cmd.Dir = fullPath
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand("go mod init", cmd)
 	if err != nil {
 		// If go.mod already exists, that's okay
 		if !strings.Contains(string(output), "go.mod already exists") {
@@ -978,7 +1148,7 @@ func createHooksModule(w http.ResponseWriter, r *http.Request) {
 	// Run go mod tidy to add dependencies in the hooks directory
 	cmd = exec.Command("go", "mod", "tidy")
 	cmd.Dir = fullPath
-	if output, err := cmd.CombinedOutput(); err != nil {
+	if output, err := runCommand("go mod tidy", cmd); err != nil {
 		fmt.Printf("⚠️ go mod tidy warning: %s\n", string(output))
 	} else {
 		fmt.Printf("✅ Updated hooks module dependencies\n")
@@ -995,13 +1165,19 @@ func createHooksModule(w http.ResponseWriter, r *http.Request) {
 }
 
 func listFiles(w http.ResponseWriter, r *http.Request) {
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
 	dir := r.URL.Query().Get("dir")
 	if dir == "" {
 		dir = "."
 	}
 
 	// Get the full path within the root directory
-	fullPath, err := getFullPath(dir)
+	fullPath, err := getFullPath(root, dir)
 	if err != nil {
 		sendErrorResponse(w, "Invalid directory - path outside root directory")
 		return
@@ -1017,7 +1193,7 @@ func listFiles(w http.ResponseWriter, r *http.Request) {
 
 	// Add parent directory link
 	// If restrictNavigation is disabled, allow navigating up to filesystem root
-	// If restrictNavigation is enabled, only allow navigating within rootDirectory
+	// If restrictNavigation is enabled, only allow navigating within root
 	if !restrictNavigation {
 		// Always show ".." unless we're at filesystem root "/"
 		if fullPath != "/" {
@@ -1025,13 +1201,17 @@ func listFiles(w http.ResponseWriter, r *http.Request) {
 		}
 	} else {
 		// Only show ".." when we're not at the configured root directory
-		if dir != "." && fullPath != rootDirectory {
+		if dir != "." && fullPath != root {
 			fileList = append(fileList, "../")
 		}
 	}
 
 	// Add directories first, then files
 	for _, file := range files {
+		// Deleted files are kept in the trash directory for undo, not shown in the explorer
+		if file.IsDir() && file.Name() == trashDirName && fullPath == root {
+			continue
+		}
 		if file.IsDir() {
 			fileList = append(fileList, file.Name()+"/")
 		}
@@ -1057,32 +1237,33 @@ func getPackFiles(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Log the operation
-	fmt.Printf("🔍 Executing pack-files command...\n")
-
-	// Get absolute path to hc executable
-	execPath, err := filepath.Abs("../hc/hc")
+	root, err := requestRoot(r)
 	if err != nil {
-		sendErrorResponse(w, fmt.Sprintf("Failed to resolve executable path: %v", err))
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
 		return
 	}
 
-	// Check if executable exists
-	if _, err := os.Stat(execPath); os.IsNotExist(err) {
-		sendErrorResponse(w, fmt.Sprintf("Executable not found at: %s", execPath))
+	defer lockRoot(root)()
+
+	// Log the operation
+	fmt.Printf("🔍 Executing pack-files command...\n")
+
+	execPath, err := hcExecutable()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
 		return
 	}
 
 	// Execute the external command with absolute path
-	fmt.Printf("📍 Executing: %s --pack-files from directory: %s\n", execPath, rootDirectory)
+	fmt.Printf("📍 Executing: %s --pack-files from directory: %s\n", execPath, root)
 	cmd := exec.Command(execPath, "--pack-files")
-	cmd.Dir = rootDirectory // Set working directory to the root directory
+	cmd.Dir = root // Set working directory to the root directory
 
 	// Capture both stdout and stderr
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand("hc --pack-files", cmd)
 	if err != nil {
 		errorMsg := fmt.Sprintf("Failed to execute hc: %v\nExecutable: %s\nWorking Dir: %s\nOutput: %s",
-			err, execPath, rootDirectory, string(output))
+			err, execPath, root, string(output))
 		sendErrorResponse(w, errorMsg)
 		return
 	}
@@ -1103,32 +1284,33 @@ func getPackFunctions(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Log the operation
-	fmt.Printf("⚙️ Executing pack-functions command...\n")
-
-	// Get absolute path to hc executable
-	execPath, err := filepath.Abs("../hc/hc")
+	root, err := requestRoot(r)
 	if err != nil {
-		sendErrorResponse(w, fmt.Sprintf("Failed to resolve executable path: %v", err))
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
 		return
 	}
 
-	// Check if executable exists
-	if _, err := os.Stat(execPath); os.IsNotExist(err) {
-		sendErrorResponse(w, fmt.Sprintf("Executable not found at: %s", execPath))
+	defer lockRoot(root)()
+
+	// Log the operation
+	fmt.Printf("⚙️ Executing pack-functions command...\n")
+
+	execPath, err := hcExecutable()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
 		return
 	}
 
 	// Execute the external command with absolute path
-	fmt.Printf("📍 Executing: %s --pack-functions from directory: %s\n", execPath, rootDirectory)
+	fmt.Printf("📍 Executing: %s --pack-functions from directory: %s\n", execPath, root)
 	cmd := exec.Command(execPath, "--pack-functions")
-	cmd.Dir = rootDirectory // Set working directory to the root directory
+	cmd.Dir = root // Set working directory to the root directory
 
 	// Capture both stdout and stderr
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand("hc --pack-functions", cmd)
 	if err != nil {
 		errorMsg := fmt.Sprintf("Failed to execute hc: %v\nExecutable: %s\nWorking Dir: %s\nOutput: %s",
-			err, execPath, rootDirectory, string(output))
+			err, execPath, root, string(output))
 		sendErrorResponse(w, errorMsg)
 		return
 	}
@@ -1149,32 +1331,33 @@ func getPackPackages(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Log the operation
-	fmt.Printf("📦 Executing pack-packages command...\n")
-
-	// Get absolute path to hc executable
-	execPath, err := filepath.Abs("../hc/hc")
+	root, err := requestRoot(r)
 	if err != nil {
-		sendErrorResponse(w, fmt.Sprintf("Failed to resolve executable path: %v", err))
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
 		return
 	}
 
-	// Check if executable exists
-	if _, err := os.Stat(execPath); os.IsNotExist(err) {
-		sendErrorResponse(w, fmt.Sprintf("Executable not found at: %s", execPath))
+	defer lockRoot(root)()
+
+	// Log the operation
+	fmt.Printf("📦 Executing pack-packages command...\n")
+
+	execPath, err := hcExecutable()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
 		return
 	}
 
 	// Execute the external command with absolute path
-	fmt.Printf("📍 Executing: %s --pack-packages from directory: %s\n", execPath, rootDirectory)
+	fmt.Printf("📍 Executing: %s --pack-packages from directory: %s\n", execPath, root)
 	cmd := exec.Command(execPath, "--pack-packages")
-	cmd.Dir = rootDirectory // Set working directory to the root directory
+	cmd.Dir = root // Set working directory to the root directory
 
 	// Capture both stdout and stderr
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand("hc --pack-packages", cmd)
 	if err != nil {
 		errorMsg := fmt.Sprintf("Failed to execute hc: %v\nExecutable: %s\nWorking Dir: %s\nOutput: %s",
-			err, execPath, rootDirectory, string(output))
+			err, execPath, root, string(output))
 		sendErrorResponse(w, errorMsg)
 		return
 	}
@@ -1195,32 +1378,96 @@ func getCallGraph(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	defer lockRoot(root)()
+
 	// Log the operation
 	fmt.Printf("🕸️ Executing callgraph command...\n")
 
-	// Get absolute path to hc executable
-	execPath, err := filepath.Abs("../hc/hc")
+	execPath, err := hcExecutable()
 	if err != nil {
-		sendErrorResponse(w, fmt.Sprintf("Failed to resolve executable path: %v", err))
+		sendErrorResponse(w, err.Error())
 		return
 	}
 
-	// Check if executable exists
-	if _, err := os.Stat(execPath); os.IsNotExist(err) {
-		sendErrorResponse(w, fmt.Sprintf("Executable not found at: %s", execPath))
+	// Execute the external command with absolute path
+	fmt.Printf("📍 Executing: %s --callgraph from directory: %s\n", execPath, root)
+	cmd := exec.Command(execPath, "--callgraph")
+	cmd.Dir = root // Set working directory to the root directory
+
+	// Capture both stdout and stderr
+	output, err := runCommand("hc --callgraph", cmd)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Failed to execute hc: %v\nExecutable: %s\nWorking Dir: %s\nOutput: %s",
+			err, execPath, root, string(output))
+		sendErrorResponse(w, errorMsg)
+		return
+	}
+
+	// Return the command output
+	response := FileResponse{
+		Success: true,
+		Content: string(output),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// getCallGraphQuery returns the callers and callees of the function given by the function
+// query parameter, up to the optional depth parameter, for "who calls this"
+func getCallGraphQuery(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	function := r.URL.Query().Get("function")
+	if function == "" {
+		sendErrorResponse(w, "Missing function parameter")
+		return
+	}
+	depth := r.URL.Query().Get("depth")
+	if depth == "" {
+		depth = "0"
+	}
+	if n, err := strconv.Atoi(depth); err != nil || n < 0 {
+		sendErrorResponse(w, fmt.Sprintf("Invalid depth: %s", depth))
+		return
+	}
+
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	defer lockRoot(root)()
+
+	// Log the operation
+	fmt.Printf("🕸️ Executing callgraph query for %s...\n", function)
+
+	execPath, err := hcExecutable()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
 		return
 	}
 
 	// Execute the external command with absolute path
-	fmt.Printf("📍 Executing: %s --callgraph from directory: %s\n", execPath, rootDirectory)
-	cmd := exec.Command(execPath, "--callgraph")
-	cmd.Dir = rootDirectory // Set working directory to the root directory
+	fmt.Printf("📍 Executing: %s --callgraph-query %s --depth %s from directory: %s\n", execPath, function, depth, root)
+	cmd := exec.Command(execPath, "--callgraph-query", function, "--depth", depth, "--quiet")
+	cmd.Dir = root // Set working directory to the root directory
 
 	// Capture both stdout and stderr
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand("hc --callgraph-query", cmd)
 	if err != nil {
 		errorMsg := fmt.Sprintf("Failed to execute hc: %v\nExecutable: %s\nWorking Dir: %s\nOutput: %s",
-			err, execPath, rootDirectory, string(output))
+			err, execPath, root, string(output))
 		sendErrorResponse(w, errorMsg)
 		return
 	}
@@ -1241,32 +1488,33 @@ func getWorkDir(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Log the operation
-	fmt.Printf("📁 Executing workdir command...\n")
-
-	// Get absolute path to hc executable
-	execPath, err := filepath.Abs("../hc/hc")
+	root, err := requestRoot(r)
 	if err != nil {
-		sendErrorResponse(w, fmt.Sprintf("Failed to resolve executable path: %v", err))
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
 		return
 	}
 
-	// Check if executable exists
-	if _, err := os.Stat(execPath); os.IsNotExist(err) {
-		sendErrorResponse(w, fmt.Sprintf("Executable not found at: %s", execPath))
+	defer lockRoot(root)()
+
+	// Log the operation
+	fmt.Printf("📁 Executing workdir command...\n")
+
+	execPath, err := hcExecutable()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
 		return
 	}
 
 	// Execute the external command with absolute path
-	fmt.Printf("📍 Executing: %s --workdir from directory: %s\n", execPath, rootDirectory)
+	fmt.Printf("📍 Executing: %s --workdir from directory: %s\n", execPath, root)
 	cmd := exec.Command(execPath, "--workdir")
-	cmd.Dir = rootDirectory // Set working directory to the root directory
+	cmd.Dir = root // Set working directory to the root directory
 
 	// Capture both stdout and stderr
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand("hc --workdir", cmd)
 	if err != nil {
 		errorMsg := fmt.Sprintf("Failed to execute hc: %v\nExecutable: %s\nWorking Dir: %s\nOutput: %s",
-			err, execPath, rootDirectory, string(output))
+			err, execPath, root, string(output))
 		sendErrorResponse(w, errorMsg)
 		return
 	}
@@ -1287,6 +1535,14 @@ func getCompile(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	defer lockRoot(root)()
+
 	var req struct {
 		HooksFile string `json:"hooksFile"`
 	}
@@ -1303,29 +1559,22 @@ func getCompile(w http.ResponseWriter, r *http.Request) {
 	// Log the operation
 	fmt.Printf("🔧 Executing compile command with hooks file: %s...\n", req.HooksFile)
 
-	// Get absolute path to hc executable
-	execPath, err := filepath.Abs("../hc/hc")
+	execPath, err := hcExecutable()
 	if err != nil {
-		sendErrorResponse(w, fmt.Sprintf("Failed to resolve executable path: %v", err))
-		return
-	}
-
-	// Check if executable exists
-	if _, err := os.Stat(execPath); os.IsNotExist(err) {
-		sendErrorResponse(w, fmt.Sprintf("Executable not found at: %s", execPath))
+		sendErrorResponse(w, err.Error())
 		return
 	}
 
 	// Execute the external command with absolute path
-	fmt.Printf("📍 Executing: %s --compile %s from directory: %s\n", execPath, req.HooksFile, rootDirectory)
+	fmt.Printf("📍 Executing: %s --compile %s from directory: %s\n", execPath, req.HooksFile, root)
 	cmd := exec.Command(execPath, "--compile", req.HooksFile)
-	cmd.Dir = rootDirectory // Set working directory to the root directory
+	cmd.Dir = root // Set working directory to the root directory
 
 	// Capture both stdout and stderr
-	output, err := cmd.CombinedOutput()
+	output, err := runCommand("hc --compile", cmd)
 	if err != nil {
 		errorMsg := fmt.Sprintf("Failed to execute hc: %v\nExecutable: %s\nWorking Dir: %s\nOutput: %s",
-			err, execPath, rootDirectory, string(output))
+			err, execPath, root, string(output))
 		sendErrorResponse(w, errorMsg)
 		return
 	}
@@ -1340,12 +1589,85 @@ func getCompile(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
+// getInstrumentationPreview runs hc --compile with --preview, which instruments into a temporary
+// directory without building, and returns the per-file diffs and generated files
+func getInstrumentationPreview(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	defer lockRoot(root)()
+
+	var req struct {
+		HooksFile string `json:"hooksFile"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		sendErrorResponse(w, "Invalid request format")
+		return
+	}
+
+	if req.HooksFile == "" {
+		sendErrorResponse(w, "Hooks file is required for instrumentation preview")
+		return
+	}
+
+	fmt.Printf("🔍 Previewing instrumentation with hooks file: %s...\n", req.HooksFile)
+
+	execPath, err := hcExecutable()
+	if err != nil {
+		sendErrorResponse(w, err.Error())
+		return
+	}
+
+	// Remove a stale preview so a failed run is not mistaken for a successful one
+	previewPath := filepath.Join(root, "build-metadata", "instrumentation-preview.json")
+	os.Remove(previewPath)
+
+	fmt.Printf("📍 Executing: %s --compile %s --preview from directory: %s\n", execPath, req.HooksFile, root)
+	cmd := exec.Command(execPath, "--compile", req.HooksFile, "--preview")
+	cmd.Dir = root
+
+	output, err := runCommand("hc --compile --preview", cmd)
+	if err != nil {
+		errorMsg := fmt.Sprintf("Failed to execute hc: %v\nExecutable: %s\nWorking Dir: %s\nOutput: %s",
+			err, execPath, root, string(output))
+		sendErrorResponse(w, errorMsg)
+		return
+	}
+
+	previewData, err := os.ReadFile(previewPath)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Preview not generated: %v\nOutput: %s", err, string(output)))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"preview": json.RawMessage(previewData),
+		"output":  string(output),
+	})
+}
+
 func getRunExecutable(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
 	var req struct {
 		ExecutablePath string `json:"executablePath"`
 		Timeout        int    `json:"timeout"` // Timeout in seconds (default 10)
@@ -1366,10 +1688,10 @@ func getRunExecutable(w http.ResponseWriter, r *http.Request) {
 		timeout = 10
 	}
 
-	// Resolve the executable path relative to rootDirectory
+	// Resolve the executable path relative to the root directory
 	execPath := req.ExecutablePath
 	if !filepath.IsAbs(execPath) {
-		execPath = filepath.Join(rootDirectory, execPath)
+		execPath = filepath.Join(root, execPath)
 	}
 
 	// Log the operation
@@ -1382,14 +1704,14 @@ func getRunExecutable(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Execute the built program with a timeout context
-	fmt.Printf("📍 Executing: %s from directory: %s\n", execPath, rootDirectory)
+	fmt.Printf("📍 Executing: %s from directory: %s\n", execPath, root)
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
 	defer cancel()
 
 	cmd := exec.CommandContext(ctx, execPath)
-	cmd.Dir = rootDirectory
+	cmd.Dir = root
 
-	// Use CombinedOutput in a goroutine to capture all output
+	// Run the command in a goroutine to capture all output
 	type result struct {
 		output []byte
 		err    error
@@ -1397,7 +1719,7 @@ func getRunExecutable(w http.ResponseWriter, r *http.Request) {
 	done := make(chan result, 1)
 
 	go func() {
-		out, err := cmd.CombinedOutput()
+		out, err := runCommand("run", cmd)
 		done <- result{output: out, err: err}
 	}()
 
@@ -1483,6 +1805,14 @@ func handleDebug(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	defer lockRoot(root)()
+
 	var req struct {
 		ExecutablePath string `json:"executablePath"`
 		Port           int    `json:"port"`
@@ -1502,10 +1832,10 @@ func handleDebug(w http.ResponseWriter, r *http.Request) {
 		req.Port = 2345
 	}
 
-	// Resolve the executable path relative to rootDirectory
+	// Resolve the executable path relative to the root directory
 	execPath := req.ExecutablePath
 	if !filepath.IsAbs(execPath) {
-		execPath = filepath.Join(rootDirectory, execPath)
+		execPath = filepath.Join(root, execPath)
 	}
 
 	// Log the operation
@@ -1519,22 +1849,19 @@ func handleDebug(w http.ResponseWriter, r *http.Request) {
 
 	// Generate source mappings from existing build log
 	fmt.Printf("📄 Generating source mappings...\n")
-	interceptorPath, err := filepath.Abs("../hc/hc")
-	if err == nil {
-		if _, err := os.Stat(interceptorPath); err == nil {
-			cmd := exec.Command(interceptorPath, "--source-mappings")
-			cmd.Dir = rootDirectory
-			output, err := cmd.CombinedOutput()
-			if err != nil {
-				fmt.Printf("⚠️  Source mappings generation failed: %v\n%s\n", err, string(output))
-			} else {
-				fmt.Printf("✅ Source mappings generated\n")
-			}
+	if interceptorPath, err := hcExecutable(); err == nil {
+		cmd := exec.Command(interceptorPath, "--source-mappings")
+		cmd.Dir = root
+		output, err := runCommand("hc --source-mappings", cmd)
+		if err != nil {
+			fmt.Printf("⚠️  Source mappings generation failed: %v\n%s\n", err, string(output))
+		} else {
+			fmt.Printf("✅ Source mappings generated\n")
 		}
 	}
 
 	// Read source mappings
-	mappingsPath := filepath.Join(rootDirectory, "build-metadata", "source-mappings.json")
+	mappingsPath := filepath.Join(root, "build-metadata", "source-mappings.json")
 	var mappings SourceMappings
 	var substitutePaths []string
 
@@ -1587,7 +1914,7 @@ func handleDebug(w http.ResponseWriter, r *http.Request) {
 	}
 
 	cmd := exec.Command("dlv", args...)
-	cmd.Dir = rootDirectory
+	cmd.Dir = root
 
 	// Capture stderr to see dlv errors
 	stderrPipe, err := cmd.StderrPipe()
@@ -1718,12 +2045,18 @@ func translateFilePaths(v interface{}, mapping map[string]string) interface{} {
 }
 
 func handleDebugWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	root, err := requestRoot(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgradeWebSocket(w, r)
 	if err != nil {
 		log.Printf("Debug WebSocket upgrade failed: %v\n", err)
 		return
 	}
-	defer conn.Close()
+	defer closeWebSocket(conn)
 
 	// Get port from query params
 	portStr := r.URL.Query().Get("port")
@@ -1735,7 +2068,7 @@ func handleDebugWebSocket(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Load source mappings for file path translation
-	mappingsPath := filepath.Join(rootDirectory, "build-metadata", "source-mappings.json")
+	mappingsPath := filepath.Join(root, "build-metadata", "source-mappings.json")
 	origToInstr := make(map[string]string) // original -> instrumented (WORK dir path)
 	instrToOrig := make(map[string]string) // instrumented -> original
 	var substitutePaths []struct{ From, To string }
@@ -2039,6 +2372,14 @@ func handleCleanup(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	root, err := requestRoot(r)
+	if err != nil {
+		sendErrorResponse(w, fmt.Sprintf("Invalid root: %v", err))
+		return
+	}
+
+	defer lockRoot(root)()
+
 	fmt.Printf("🧹 Cleaning build artifacts...\n")
 
 	var deletedDirs []string
@@ -2048,7 +2389,7 @@ func handleCleanup(w http.ResponseWriter, r *http.Request) {
 	dirsToClean := []string{"build-metadata", ".debug-build"}
 
 	for _, dir := range dirsToClean {
-		dirPath := filepath.Join(rootDirectory, dir)
+		dirPath := filepath.Join(root, dir)
 		if _, err := os.Stat(dirPath); err == nil {
 			// Directory exists, remove it
 			if err := os.RemoveAll(dirPath); err != nil {
@@ -2086,12 +2427,18 @@ func handleCleanup(w http.ResponseWriter, r *http.Request) {
 
 // handleRunWebSocket handles WebSocket connections for running executables with real-time output
 func handleRunWebSocket(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+	root, err := requestRoot(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	conn, err := upgradeWebSocket(w, r)
 	if err != nil {
 		log.Printf("Run WebSocket upgrade failed: %v\n", err)
 		return
 	}
-	defer conn.Close()
+	defer closeWebSocket(conn)
 
 	log.Println("Run WebSocket connection established")
 
@@ -2122,10 +2469,10 @@ func handleRunWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Resolve the executable path relative to rootDirectory
+	// Resolve the executable path relative to the root directory
 	execPath := req.ExecutablePath
 	if !filepath.IsAbs(execPath) {
-		execPath = filepath.Join(rootDirectory, execPath)
+		execPath = filepath.Join(root, execPath)
 	}
 
 	// Check if executable exists
@@ -2149,7 +2496,7 @@ func handleRunWebSocket(w http.ResponseWriter, r *http.Request) {
 
 	// Create the command
 	cmd := exec.Command(execPath)
-	cmd.Dir = rootDirectory
+	cmd.Dir = root
 
 	// Create pipes for stdout and stderr
 	stdout, err := cmd.StdoutPipe()
@@ -2179,6 +2526,9 @@ func handleRunWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	startTime := time.Now()
+	analysisJobsRunning.add(1, "command", "run")
+
 	// Store the running command
 	runningMutex.Lock()
 	runningCmd = cmd
@@ -2249,6 +2599,8 @@ func handleRunWebSocket(w http.ResponseWriter, r *http.Request) {
 	// Goroutine to wait for process to exit
 	go func() {
 		err := cmd.Wait()
+		recordCommand("run", err == nil, time.Since(startTime))
+		analysisJobsRunning.add(-1, "command", "run")
 		processExited <- err
 	}()
 